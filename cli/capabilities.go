@@ -0,0 +1,79 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const shortCapabilitiesHelp = "Print all capabilities supported by your server."
+
+func getCapabilitiesCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capabilities",
+		Long:  shortCapabilitiesHelp + "\n\n" + typicalFlowHelp,
+		Short: shortCapabilitiesHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			// Allow insecure auth for local server for testing.
+			insecure := rootConf.server == localhost
+			cfg := core.IMAPConfig{
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+			}
+			capabilities, err := ops.getCapabilities(cfg)
+
+			// The capabilities have already been sorted.
+			fmt.Println(strings.Join(capabilities, "\n"))
+
+			return err
+		},
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			err := initCredentials(rootConf, keyring, rootConf.verbose)
+			if credentialsNotFound(err) {
+				err = fmt.Errorf("%s\n\n%s", err.Error(), loginCmdUse(rootConf, os.Args))
+			}
+			return err
+		},
+	}
+	initRootFlags(cmd, rootConf)
+	return cmd
+}
+
+var capabilitiesCmd = getCapabilitiesCmd(&rootConfig, defaultKeyring, &corer{})
+
+func init() {
+	rootCmd.AddCommand(capabilitiesCmd)
+}
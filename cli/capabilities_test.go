@@ -0,0 +1,60 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCapabilitiesCommand(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getCapabilities", mock.Anything).Return([]string{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	mk := &mockKeyring{}
+
+	rootConf := rootConfigT{}
+	cmd := getCapabilitiesCmd(&rootConf, mk, &mockOps)
+	rootConf.noKeyring = true
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestCapabilitiesCommandSuccess(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getCapabilities", mock.Anything).Return([]string{"IDLE", "IMAP4rev1"}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	mk := &mockKeyring{}
+
+	rootConf := rootConfigT{}
+	cmd := getCapabilitiesCmd(&rootConf, mk, &mockOps)
+	rootConf.noKeyring = true
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,83 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const shortConvertHelp = "Convert a locally stored maildir to an mbox file or vice versa."
+
+type convertConfigT struct {
+	source string
+	target string
+}
+
+func getConvertCmd(ops coreOps) *cobra.Command {
+	convertConf := convertConfigT{}
+	cmd := &cobra.Command{
+		Use: "convert",
+		Long: shortConvertHelp + "\n\n" +
+			"A maildir given as source is written to a new mbox file at the target path.\n" +
+			"An mbox file given as source is written to a maildir at the target path,\n" +
+			"which is created if it does not exist. The source is not changed. Maildir\n" +
+			"flags and the Status and X-Status header fields of mbox files are converted\n" +
+			"into each other. The server is not contacted and no credentials are needed.",
+		Short: shortConvertHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if convertConf.source == "" || convertConf.target == "" {
+				return fmt.Errorf("both a source and a target are required")
+			}
+			info, err := os.Stat(convertConf.source)
+			if err != nil {
+				return fmt.Errorf("cannot access source: %s", err.Error())
+			}
+			var count int
+			if info.IsDir() {
+				count, err = ops.convertMaildirToMbox(convertConf.source, convertConf.target)
+			} else {
+				count, err = ops.convertMboxToMaildir(convertConf.source, convertConf.target)
+			}
+			// Report the emails that could be converted even if others could not.
+			fmt.Printf(
+				"converted %d emails from %s to %s\n", count, convertConf.source, convertConf.target,
+			)
+			return err
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(
+		&convertConf.source, "source", "", "the maildir or mbox file to convert",
+	)
+	flags.StringVar(
+		&convertConf.target, "target", "",
+		"the mbox file or maildir to write the converted emails to",
+	)
+
+	return cmd
+}
+
+var convertCmd = getConvertCmd(&corer{})
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+}
@@ -0,0 +1,71 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertCommandMaildirToMbox(t *testing.T) {
+	source := t.TempDir()
+	target := filepath.Join(t.TempDir(), "INBOX.mbox")
+	mockOps := mockCoreOps{}
+	mockOps.On("convertMaildirToMbox", source, target).Return(2, nil)
+	defer mockOps.AssertExpectations(t)
+
+	cmd := getConvertCmd(&mockOps)
+	cmd.SetArgs([]string{"--source", source, "--target", target})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestConvertCommandMboxToMaildir(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "INBOX.mbox")
+	require.NoError(t, os.WriteFile(source, nil, filePerms))
+	target := filepath.Join(t.TempDir(), "INBOX")
+	mockOps := mockCoreOps{}
+	mockOps.On("convertMboxToMaildir", source, target).Return(1, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	cmd := getConvertCmd(&mockOps)
+	cmd.SetArgs([]string{"--source", source, "--target", target})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestConvertCommandInvalidArgs(t *testing.T) {
+	mockOps := mockCoreOps{}
+	// Nothing will be called without valid arguments.
+	defer mockOps.AssertExpectations(t)
+
+	cmd := getConvertCmd(&mockOps)
+	cmd.SetArgs([]string{"--source", "some-source"})
+	assert.ErrorContains(t, cmd.Execute(), "both a source and a target are required")
+
+	cmd = getConvertCmd(&mockOps)
+	cmd.SetArgs([]string{"--source", "does/not/exist", "--target", "some-target"})
+	assert.ErrorContains(t, cmd.Execute(), "cannot access source")
+}
@@ -19,11 +19,36 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 // library.
 package main
 
-import "github.com/razziel89/go-imapgrab/core"
+import (
+	"github.com/emersion/go-imap"
+	"github.com/razziel89/go-imapgrab/core"
+)
 
 type coreOps interface {
 	getAllFolders(cfg core.IMAPConfig) ([]string, error)
-	downloadFolder(cfg core.IMAPConfig, folders []string, maildirBase string, threads int) error
+	getFolderInfos(cfg core.IMAPConfig) ([]core.FolderInfo, error)
+	getFolderSummaries(cfg core.IMAPConfig, threads int) ([]core.FolderSummary, error)
+	getCapabilities(cfg core.IMAPConfig) ([]string, error)
+	getMessageCounts(cfg core.IMAPConfig, folders []string) ([]core.FolderCount, error)
+	searchFolders(
+		cfg core.IMAPConfig, folders []string, criteria *imap.SearchCriteria,
+	) ([]core.FolderSearchResult, error)
+	downloadFolder(
+		cfg core.IMAPConfig,
+		folders []string,
+		maildirBase string,
+		threads int,
+		opts core.DownloadOptions,
+	) error
+	pruneMaildirs(
+		cfg core.IMAPConfig, folders []string, maildirBase string, opts core.PruneOptions,
+	) ([]core.PruneResult, error)
+	reconcileFolders(
+		cfg core.IMAPConfig, folders []string, maildirBase string,
+	) ([]core.ReconcileResult, error)
+	getMaildirSizes(maildirBase string) ([]core.FolderSize, error)
+	convertMaildirToMbox(maildirPath, mboxPath string) (int, error)
+	convertMboxToMaildir(mboxPath, maildirPath string) (int, error)
 	serveMaildir(cfg core.IMAPConfig, serverPort int, maildirBase string) error
 	tryConnect(cfg core.IMAPConfig) error
 }
@@ -34,10 +59,64 @@ func (c *corer) getAllFolders(cfg core.IMAPConfig) ([]string, error) {
 	return core.GetAllFolders(cfg)
 }
 
+func (c *corer) getFolderInfos(cfg core.IMAPConfig) ([]core.FolderInfo, error) {
+	return core.GetFolderInfos(cfg)
+}
+
+func (c *corer) getFolderSummaries(
+	cfg core.IMAPConfig, threads int,
+) ([]core.FolderSummary, error) {
+	return core.GetFolderSummaries(cfg, threads)
+}
+
+func (c *corer) getCapabilities(cfg core.IMAPConfig) ([]string, error) {
+	return core.GetCapabilities(cfg)
+}
+
+func (c *corer) getMessageCounts(
+	cfg core.IMAPConfig, folders []string,
+) ([]core.FolderCount, error) {
+	return core.GetMessageCounts(cfg, folders)
+}
+
+func (c *corer) searchFolders(
+	cfg core.IMAPConfig, folders []string, criteria *imap.SearchCriteria,
+) ([]core.FolderSearchResult, error) {
+	return core.SearchFolders(cfg, folders, criteria)
+}
+
 func (c *corer) downloadFolder(
-	cfg core.IMAPConfig, folders []string, maildirBase string, threads int,
+	cfg core.IMAPConfig,
+	folders []string,
+	maildirBase string,
+	threads int,
+	opts core.DownloadOptions,
 ) error {
-	return core.DownloadFolder(cfg, folders, maildirBase, threads)
+	return core.DownloadFolder(cfg, folders, maildirBase, threads, opts)
+}
+
+func (c *corer) pruneMaildirs(
+	cfg core.IMAPConfig, folders []string, maildirBase string, opts core.PruneOptions,
+) ([]core.PruneResult, error) {
+	return core.PruneMaildirs(cfg, folders, maildirBase, opts)
+}
+
+func (c *corer) reconcileFolders(
+	cfg core.IMAPConfig, folders []string, maildirBase string,
+) ([]core.ReconcileResult, error) {
+	return core.ReconcileFolders(cfg, folders, maildirBase)
+}
+
+func (c *corer) getMaildirSizes(maildirBase string) ([]core.FolderSize, error) {
+	return core.GetMaildirSizes(maildirBase)
+}
+
+func (c *corer) convertMaildirToMbox(maildirPath, mboxPath string) (int, error) {
+	return core.ConvertMaildirToMbox(maildirPath, mboxPath)
+}
+
+func (c *corer) convertMboxToMaildir(mboxPath, maildirPath string) (int, error) {
+	return core.ConvertMboxToMaildir(mboxPath, maildirPath)
 }
 
 func (c *corer) serveMaildir(cfg core.IMAPConfig, serverPort int, maildirBase string) error {
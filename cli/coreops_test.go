@@ -18,8 +18,10 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"path/filepath"
 	"testing"
 
+	"github.com/emersion/go-imap"
 	"github.com/razziel89/go-imapgrab/core"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -34,13 +36,77 @@ func (m *mockCoreOps) getAllFolders(cfg core.IMAPConfig) ([]string, error) {
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (m *mockCoreOps) getFolderInfos(cfg core.IMAPConfig) ([]core.FolderInfo, error) {
+	args := m.Called(cfg)
+	return args.Get(0).([]core.FolderInfo), args.Error(1)
+}
+
+func (m *mockCoreOps) getFolderSummaries(
+	cfg core.IMAPConfig, threads int,
+) ([]core.FolderSummary, error) {
+	args := m.Called(cfg, threads)
+	return args.Get(0).([]core.FolderSummary), args.Error(1)
+}
+
+func (m *mockCoreOps) getCapabilities(cfg core.IMAPConfig) ([]string, error) {
+	args := m.Called(cfg)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *mockCoreOps) getMessageCounts(
+	cfg core.IMAPConfig, folders []string,
+) ([]core.FolderCount, error) {
+	args := m.Called(cfg, folders)
+	return args.Get(0).([]core.FolderCount), args.Error(1)
+}
+
+func (m *mockCoreOps) searchFolders(
+	cfg core.IMAPConfig, folders []string, criteria *imap.SearchCriteria,
+) ([]core.FolderSearchResult, error) {
+	args := m.Called(cfg, folders, criteria)
+	return args.Get(0).([]core.FolderSearchResult), args.Error(1)
+}
+
 func (m *mockCoreOps) downloadFolder(
-	cfg core.IMAPConfig, folders []string, maildirBase string, threads int,
+	cfg core.IMAPConfig,
+	folders []string,
+	maildirBase string,
+	threads int,
+	opts core.DownloadOptions,
 ) error {
-	args := m.Called(cfg, folders, maildirBase, threads)
+	args := m.Called(cfg, folders, maildirBase, threads, opts)
 	return args.Error(0)
 }
 
+func (m *mockCoreOps) pruneMaildirs(
+	cfg core.IMAPConfig, folders []string, maildirBase string, opts core.PruneOptions,
+) ([]core.PruneResult, error) {
+	args := m.Called(cfg, folders, maildirBase, opts)
+	return args.Get(0).([]core.PruneResult), args.Error(1)
+}
+
+func (m *mockCoreOps) reconcileFolders(
+	cfg core.IMAPConfig, folders []string, maildirBase string,
+) ([]core.ReconcileResult, error) {
+	args := m.Called(cfg, folders, maildirBase)
+	return args.Get(0).([]core.ReconcileResult), args.Error(1)
+}
+
+func (m *mockCoreOps) getMaildirSizes(maildirBase string) ([]core.FolderSize, error) {
+	args := m.Called(maildirBase)
+	return args.Get(0).([]core.FolderSize), args.Error(1)
+}
+
+func (m *mockCoreOps) convertMaildirToMbox(maildirPath, mboxPath string) (int, error) {
+	args := m.Called(maildirPath, mboxPath)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockCoreOps) convertMboxToMaildir(mboxPath, maildirPath string) (int, error) {
+	args := m.Called(mboxPath, maildirPath)
+	return args.Int(0), args.Error(1)
+}
+
 func (m *mockCoreOps) serveMaildir(cfg core.IMAPConfig, serverPort int, maildirBase string) error {
 	args := m.Called(cfg, serverPort, maildirBase)
 	return args.Error(0)
@@ -61,11 +127,103 @@ func TestCoreOpsGetAllFolders(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCoreOpsGetFolderInfos(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	folders, err := ops.getFolderInfos(cfg)
+
+	assert.Zero(t, len(folders))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsGetFolderSummaries(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	summaries, err := ops.getFolderSummaries(cfg, 0)
+
+	assert.Zero(t, len(summaries))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsGetCapabilities(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	capabilities, err := ops.getCapabilities(cfg)
+
+	assert.Zero(t, len(capabilities))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsGetMessageCounts(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	counts, err := ops.getMessageCounts(cfg, []string{"_ALL_"})
+
+	assert.Zero(t, len(counts))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsPruneMaildirs(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	results, err := ops.pruneMaildirs(cfg, []string{"INBOX"}, "", core.PruneOptions{})
+
+	assert.Zero(t, len(results))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsSearchFolders(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	results, err := ops.searchFolders(cfg, []string{"_ALL_"}, nil)
+
+	assert.Zero(t, len(results))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsReconcileFolders(t *testing.T) {
+	ops := corer{}
+	cfg := core.IMAPConfig{}
+
+	results, err := ops.reconcileFolders(cfg, []string{"_ALL_"}, "")
+
+	assert.Zero(t, len(results))
+	assert.Error(t, err)
+}
+
+func TestCoreOpsGetMaildirSizes(t *testing.T) {
+	ops := corer{}
+
+	sizes, err := ops.getMaildirSizes(t.TempDir())
+
+	assert.Zero(t, len(sizes))
+	assert.NoError(t, err)
+}
+
+func TestCoreOpsConvert(t *testing.T) {
+	ops := corer{}
+	dir := t.TempDir()
+
+	count, err := ops.convertMaildirToMbox(filepath.Join(dir, "missing"), "not-needed")
+	assert.Zero(t, count)
+	assert.Error(t, err)
+
+	count, err = ops.convertMboxToMaildir(filepath.Join(dir, "missing.mbox"), dir)
+	assert.Zero(t, count)
+	assert.Error(t, err)
+}
+
 func TestCoreOpsDownloadFolder(t *testing.T) {
 	ops := corer{}
 	cfg := core.IMAPConfig{}
 
-	err := ops.downloadFolder(cfg, []string{}, "", 0)
+	err := ops.downloadFolder(cfg, []string{}, "", 0, core.DownloadOptions{})
 
 	assert.Error(t, err)
 }
@@ -0,0 +1,117 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const shortCountHelp = "Print the number of emails in folders of your inbox."
+
+type countConfigT struct {
+	folders    []string
+	jsonOutput bool
+}
+
+// Print message counts as a table with one row per folder and a final row with the total.
+func printFolderCounts(writer io.Writer, counts []core.FolderCount) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(table, "FOLDER\tMESSAGES")
+	total := 0
+	for _, count := range counts {
+		fmt.Fprintf(table, "%s\t%d\n", count.Name, count.Messages)
+		total += count.Messages
+	}
+	fmt.Fprintf(table, "TOTAL\t%d\n", total)
+	return table.Flush()
+}
+
+func getCountCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.Command {
+	countConf := countConfigT{}
+	cmd := &cobra.Command{
+		Use:   "count",
+		Long:  shortCountHelp + "\n\n" + typicalFlowHelp,
+		Short: shortCountHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			// Allow insecure auth for local server for testing.
+			insecure := rootConf.server == localhost
+			cfg := core.IMAPConfig{
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+				SubscribedOnly:    rootConf.subscribedOnly,
+				SharedFolders:     rootConf.sharedFolders,
+			}
+			counts, err := ops.getMessageCounts(cfg, countConf.folders)
+			if err != nil {
+				return err
+			}
+			if countConf.jsonOutput {
+				return printJSON(os.Stdout, counts)
+			}
+			return printFolderCounts(os.Stdout, counts)
+		},
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			err := initCredentials(rootConf, keyring, rootConf.verbose)
+			if credentialsNotFound(err) {
+				err = fmt.Errorf("%s\n\n%s", err.Error(), loginCmdUse(rootConf, os.Args))
+			}
+			return err
+		},
+	}
+	initRootFlags(cmd, rootConf)
+	initFolderListFlags(cmd, rootConf)
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(
+		&countConf.folders,
+		"folder", "f", []string{"_ALL_"},
+		"a folder spec specifying something to count, same as for the download\n"+
+			"command, all folders by default",
+	)
+	flags.BoolVar(
+		&countConf.jsonOutput, "json", false,
+		"print counts as a JSON array of objects instead of a table",
+	)
+
+	return cmd
+}
+
+var countCmd = getCountCmd(&rootConfig, defaultKeyring, &corer{})
+
+func init() {
+	rootCmd.AddCommand(countCmd)
+}
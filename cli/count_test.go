@@ -0,0 +1,75 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCountCommand(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getMessageCounts", mock.Anything, []string{"INBOX"}).
+		Return([]core.FolderCount{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getCountCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--folder", "INBOX", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestCountCommandSuccess(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getMessageCounts", mock.Anything, []string{"_ALL_"}).
+		Return([]core.FolderCount{{Name: "INBOX", Messages: 2}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getCountCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestPrintFolderCounts(t *testing.T) {
+	counts := []core.FolderCount{{Name: "INBOX", Messages: 12}, {Name: "Sent", Messages: 3}}
+	buf := bytes.Buffer{}
+
+	err := printFolderCounts(&buf, counts)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"FOLDER  MESSAGES\n" +
+		"INBOX   12\n" +
+		"Sent    3\n" +
+		"TOTAL   15\n"
+	assert.Equal(t, expected, buf.String())
+}
@@ -19,27 +19,152 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/razziel89/go-imapgrab/core"
 	"github.com/spf13/cobra"
 )
 
-const defaultTimeoutSeconds = 1
+const (
+	defaultTimeoutSeconds        = 1
+	defaultProgressSeconds       = 5
+	defaultConnectRetries        = 3
+	defaultConnectBackoffSeconds = 1
+	defaultFolderListBuffer      = 10
+	defaultMessageBuffer         = 20
+)
 
 var downloadConf downloadConfigT
 
 type downloadConfigT struct {
-	folders        []string
-	path           string
-	threads        int
-	timeoutSeconds int
+	folders          []string
+	path             string
+	threads          int
+	autoThreads      bool
+	maxThreads       int
+	folderThreads    map[string]int
+	reconnectEvery   int
+	onlyChanged      bool
+	audit            bool
+	maildirFlags     bool
+	maildirSubdir    string
+	timeoutSeconds   int
+	headersOnly      bool
+	progressSeconds  int
+	keepaliveSeconds int
+	maxRuntime       int
+	maxBandwidth     int
+	maxConnections   int
+	connectionSlots  string
+	logDir           string
+	logFilesKept     int
+	archive          bool
+	maxPartSize      int
+	newestFirst      bool
+	mirror           bool
+	connectRetries   int
+	connectBackoff   int
+	transientErrors  []string
+	interactive      bool
+	keepMalformed    bool
+	mbox             bool
+	minSize          int
+	maxSize          int
+	fetchPreset      string
+	strictUIDCount   bool
+	moveTo           string
+	deleteFromServer bool
+	confirmDelete    bool
+	folderBuffer     int
+	messageBuffer    int
+	gmailAllMail     bool
+	fileNaming       string
+	format           string
+	layout           string
+	dedup            string
+	nameTemplate     string
+	nameMetadata     bool
+	verifyCount      bool
+	accountDirs      bool
+	manifest         bool
+	metadata         bool
+	compressIndex    bool
+	compress         bool
+	enableSync       bool
+	hostID           string
+	sinceUID         int
+	sinceValidity    int
+	lineEnding       string
+	dateFallback     string
+	uidFile          string
+	refresh          bool
+	referenceDirs    []string
+	quarantine       string
+	seqRange         string
+	addressFilter    string
+	entireBody       bool
+	bodyStructure    bool
+	gmailLabels      bool
+	summary          bool
+	jsonOutput       bool
 }
 
 const shortDownloadHelp = "Download all not yet downloaded emails from a folder to a maildir."
 
+// Print download statistics as a table with one row per folder and a final row with the totals.
+func printDownloadSummary(writer io.Writer, folders []core.FolderStats) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(
+		table, "ACCOUNT\tFOLDER\tDOWNLOADED\tSKIPPED\tFILTERED\tFAILED\tBYTES\tSECONDS\tERROR",
+	)
+	total := core.FolderStats{}
+	for _, stats := range folders {
+		fmt.Fprintf(
+			table, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%.1f\t%s\n", stats.Account, stats.Folder,
+			stats.Downloaded, stats.Skipped, stats.Filtered, stats.Failed, stats.Bytes,
+			stats.Seconds, stats.Error,
+		)
+		total.Downloaded += stats.Downloaded
+		total.Skipped += stats.Skipped
+		total.Filtered += stats.Filtered
+		total.Failed += stats.Failed
+		total.Bytes += stats.Bytes
+		total.Seconds += stats.Seconds
+	}
+	fmt.Fprintf(
+		table, "TOTAL\t\t%d\t%d\t%d\t%d\t%d\t%.1f\t\n",
+		total.Downloaded, total.Skipped, total.Filtered, total.Failed, total.Bytes, total.Seconds,
+	)
+	return table.Flush()
+}
+
+// Parse a range of sequence numbers given as <START>:<END>. An empty range is returned as zeroes.
+// The range itself is validated by the core library.
+func parseSeqRange(text string) (start, end int, err error) {
+	if text == "" {
+		return 0, 0, nil
+	}
+	startText, endText, found := strings.Cut(text, ":")
+	if found {
+		start, err = strconv.Atoi(startText)
+	}
+	if found && err == nil {
+		end, err = strconv.Atoi(endText)
+	}
+	if !found || err != nil {
+		return 0, 0, fmt.Errorf("cannot parse sequence number range '%s', use START:END", text)
+	}
+	return start, end, nil
+}
+
 func getDownloadCmd(
 	rootConf *rootConfigT,
 	downloadConf *downloadConfigT,
@@ -53,14 +178,77 @@ func getDownloadCmd(
 		Short: shortDownloadHelp,
 		RunE: func(_ *cobra.Command, _ []string) error {
 			core.SetVerboseLogs(rootConf.verbose)
+			if downloadConf.logDir != "" {
+				stopLog, err := startRunLog(downloadConf.logDir, downloadConf.logFilesKept)
+				if err != nil {
+					return err
+				}
+				defer stopLog()
+			}
 			// Allow insecure auth for local server for testing.
 			insecure := rootConf.server == localhost
 			cfg := core.IMAPConfig{
-				Server:   rootConf.server,
-				Port:     rootConf.port,
-				User:     rootConf.username,
-				Password: rootConf.password,
-				Insecure: insecure,
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+				SubscribedOnly:    rootConf.subscribedOnly,
+				SharedFolders:     rootConf.sharedFolders,
+
+				ConnectRetries: downloadConf.connectRetries,
+				ConnectBackoff: time.Duration(downloadConf.connectBackoff) * time.Second,
+				Compress:       downloadConf.compress,
+				EnableSync:     downloadConf.enableSync,
+
+				FolderListBuffer: downloadConf.folderBuffer,
+				MessageBuffer:    downloadConf.messageBuffer,
+			}
+			seqStart, seqEnd, err := parseSeqRange(downloadConf.seqRange)
+			if err != nil {
+				return err
+			}
+			var addressFilter *regexp.Regexp
+			if downloadConf.addressFilter != "" {
+				addressFilter, err = regexp.Compile(downloadConf.addressFilter)
+				if err != nil {
+					return fmt.Errorf("cannot parse address filter: %s", err.Error())
+				}
+			}
+			// Keep the list nil if no patterns were given so that the defaults apply.
+			for _, pattern := range downloadConf.transientErrors {
+				transientError, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("cannot parse transient error pattern: %s", err.Error())
+				}
+				cfg.TransientErrors = append(cfg.TransientErrors, transientError)
+			}
+			if downloadConf.deleteFromServer && !downloadConf.confirmDelete {
+				return fmt.Errorf(
+					"deleting emails from the server cannot be undone, " +
+						"add --confirm-delete-from-server to proceed",
+				)
+			}
+			folders := downloadConf.folders
+			// Picked folders are exact names, which must not be parsed as folder specs again.
+			var exactFolders bool
+			if downloadConf.interactive && !stdinIsTerminal() {
+				log.Println("stdin is not a terminal, ignoring --interactive")
+			} else if downloadConf.interactive {
+				// Prompt on stderr so that stdout contains only the download summary.
+				folders, err = selectFoldersInteractively(ops, cfg, folders, os.Stdin, os.Stderr)
+				if err != nil {
+					return err
+				}
+				exactFolders = true
 			}
 			lockfile := filepath.Join(downloadConf.path, lockfileName)
 			lockTimeout := time.Duration(downloadConf.timeoutSeconds) * time.Second
@@ -72,9 +260,85 @@ func getDownloadCmd(
 				)
 			}
 			defer unlock()
-			return ops.downloadFolder(
-				cfg, downloadConf.folders, downloadConf.path, downloadConf.threads,
+			var summary *core.DownloadSummary
+			if downloadConf.summary || downloadConf.jsonOutput {
+				summary = &core.DownloadSummary{}
+			}
+			err = ops.downloadFolder(
+				cfg, folders, downloadConf.path, downloadConf.threads,
+				core.DownloadOptions{
+					HeadersOnly:         downloadConf.headersOnly,
+					ProgressInterval:    time.Duration(downloadConf.progressSeconds) * time.Second,
+					KeepaliveInterval:   time.Duration(downloadConf.keepaliveSeconds) * time.Second,
+					MaxRuntime:          time.Duration(downloadConf.maxRuntime) * time.Second,
+					MaxBytesPerSecond:   downloadConf.maxBandwidth,
+					MaxConnections:      downloadConf.maxConnections,
+					ConnectionSlots:     downloadConf.connectionSlots,
+					Archive:             downloadConf.archive,
+					MaxPartSize:         downloadConf.maxPartSize,
+					NewestFirst:         downloadConf.newestFirst,
+					Mirror:              downloadConf.mirror,
+					KeepMalformed:       downloadConf.keepMalformed,
+					Mbox:                downloadConf.mbox,
+					MinSize:             downloadConf.minSize,
+					MaxSize:             downloadConf.maxSize,
+					SinceUID:            downloadConf.sinceUID,
+					SinceUIDValidity:    downloadConf.sinceValidity,
+					FetchPreset:         core.FetchPreset(downloadConf.fetchPreset),
+					StrictUIDCount:      downloadConf.strictUIDCount,
+					MoveTo:              downloadConf.moveTo,
+					DeleteFromServer:    downloadConf.deleteFromServer,
+					IncludeGmailAllMail: downloadConf.gmailAllMail,
+					ExactFolders:        exactFolders,
+					FileNaming:          core.FileNaming(downloadConf.fileNaming),
+					FileNameTemplate:    downloadConf.nameTemplate,
+					FileNameMetadata:    downloadConf.nameMetadata,
+					Format:              core.OutputFormat(downloadConf.format),
+					Layout:              core.Layout(downloadConf.layout),
+					Dedup:               core.DedupLink(downloadConf.dedup),
+					LineEnding:          core.LineEnding(downloadConf.lineEnding),
+					DateFallback:        core.DateFallback(downloadConf.dateFallback),
+					VerifyCount:         downloadConf.verifyCount,
+					AccountDirs:         downloadConf.accountDirs,
+					HostID:              downloadConf.hostID,
+					Manifest:            downloadConf.manifest || downloadConf.compressIndex,
+					CompressManifest:    downloadConf.compressIndex,
+					AutoThreads:         downloadConf.autoThreads,
+					MaxThreads:          downloadConf.maxThreads,
+					FolderThreads:       downloadConf.folderThreads,
+					ReconnectEvery:      downloadConf.reconnectEvery,
+					OnlyChanged:         downloadConf.onlyChanged,
+					Audit:               downloadConf.audit,
+					MaildirFlags:        downloadConf.maildirFlags,
+					MaildirSubdir:       core.MaildirSubdir(downloadConf.maildirSubdir),
+					UIDFile:             downloadConf.uidFile,
+					Refresh:             downloadConf.refresh,
+					ReferenceMaildirs:   downloadConf.referenceDirs,
+					Quarantine:          downloadConf.quarantine,
+					SeqStart:            seqStart,
+					SeqEnd:              seqEnd,
+					AddressFilter:       addressFilter,
+					Metadata:            downloadConf.metadata,
+					FetchEntireBody:     downloadConf.entireBody,
+					BodyStructure:       downloadConf.bodyStructure,
+					GmailLabels:         downloadConf.gmailLabels,
+					Summary:             summary,
+				},
 			)
+			if summary == nil {
+				return err
+			}
+			// Report the folders that could be downloaded even if others could not.
+			var printErr error
+			if downloadConf.jsonOutput {
+				printErr = printJSON(os.Stdout, summary.Folders())
+			} else {
+				printErr = printDownloadSummary(os.Stdout, summary.Folders())
+			}
+			if err != nil {
+				return err
+			}
+			return printErr
 		},
 		PreRunE: func(_ *cobra.Command, _ []string) error {
 			core.SetVerboseLogs(rootConf.verbose)
@@ -87,6 +351,7 @@ func getDownloadCmd(
 	}
 	initDownloadFlags(cmd, downloadConf)
 	initRootFlags(cmd, rootConf)
+	initFolderListFlags(cmd, rootConf)
 	return cmd
 }
 
@@ -107,13 +372,339 @@ func initDownloadFlags(downloadCmd *cobra.Command, downloadConf *downloadConfigT
 			"flag multiple times for multiple specs, prepend a minus '-' to any\n"+
 			"spec to deselect instead, specs are interpreted in order)\n",
 	)
+	flags.BoolVar(
+		&downloadConf.interactive, "interactive", false,
+		"pick the folders to download from a list of the folders selected via --folder,\n"+
+			"or of all folders if none are given, ignored if stdin is not a terminal",
+	)
 	flags.StringVar(&downloadConf.path, "path", "", "the local path to your maildir's parent dir")
 	flags.IntVarP(
 		&downloadConf.threads, "threads", "t", 0,
-		"number of download threads to use, one per folder by default",
+		"number of download threads to use, at most one per folder, defaults to\n"+
+			"the number of CPUs but at most 4",
+	)
+	flags.BoolVar(
+		&downloadConf.autoThreads, "auto-threads", false,
+		"start with one download thread and add more while that increases the\n"+
+			"throughput, using at most as many as specified via --threads or --max-threads",
+	)
+	flags.IntVar(
+		&downloadConf.maxThreads, "max-threads", core.DefaultMaxThreads,
+		"maximum number of download threads, larger values for --threads are reduced\n"+
+			"to it to avoid exceeding the number of connections servers allow",
+	)
+	flags.StringToIntVar(
+		&downloadConf.folderThreads, "folder-threads", nil,
+		"number of connections to retrieve the emails of a folder with in parallel,\n"+
+			"e.g. \"INBOX=4\", can be given several times, other folders use one connection",
+	)
+	flags.IntVar(
+		&downloadConf.reconnectEvery, "reconnect-every", 0,
+		"log out and reconnect after this many emails of a folder, e.g. for servers\n"+
+			"limiting emails per session, 0 to never reconnect",
+	)
+	flags.BoolVar(
+		&downloadConf.onlyChanged, "only-changed", false,
+		"skip folders whose number of emails and UIDNEXT have not changed since the\n"+
+			"last successful download",
 	)
 	flags.IntVar(
 		&downloadConf.timeoutSeconds, "timeout", defaultTimeoutSeconds,
 		"time in seconds to wait for acquiring a lock on the download folder",
 	)
+	flags.BoolVar(
+		&downloadConf.headersOnly, "headers-only", false,
+		"download only the headers of emails, e.g. to build a lightweight index\n"+
+			"(use a separate path since emails are remembered as downloaded either way)",
+	)
+	flags.IntVar(
+		&downloadConf.progressSeconds, "progress", defaultProgressSeconds,
+		"interval in seconds for logging throughput and estimated time remaining\n"+
+			"in verbose mode, 0 disables progress reports",
+	)
+	flags.IntVar(
+		&downloadConf.keepaliveSeconds, "keepalive", 0,
+		"send a NOOP to the server if no email has been retrieved for this many\n"+
+			"seconds while downloading a folder, 0 disables keepalives",
+	)
+	flags.IntVar(
+		&downloadConf.maxRuntime, "max-runtime", 0,
+		"stop gracefully after this many seconds and exit with code 75, the next download\n"+
+			"resumes where this one stopped, 0 means no limit",
+	)
+	flags.IntVar(
+		&downloadConf.maxBandwidth, "max-bytes-per-second", 0,
+		"limit the rate at which emails are retrieved across all threads to this many\n"+
+			"bytes per second, 0 means no limit",
+	)
+	flags.IntVar(
+		&downloadConf.maxConnections, "max-connections", 0,
+		"limit the number of connections open at the same time across all threads, further\n"+
+			"threads are not started while none is free, 0 means no limit",
+	)
+	flags.StringVar(
+		&downloadConf.connectionSlots, "connection-slots", "",
+		"share the limit set via --max-connections with all processes using this directory\n"+
+			"for it, e.g. to download several mailboxes at once",
+	)
+	flags.StringVar(
+		&downloadConf.logDir, "log-dir", "",
+		"also write the logs of each run to a new timestamped file in this directory, e.g.\n"+
+			"next to the one given via --path",
+	)
+	flags.IntVar(
+		&downloadConf.logFilesKept, "log-files-kept", defaultLogFilesKept,
+		"number of log files in --log-dir to keep, removing the oldest ones, 0 keeps all",
+	)
+	flags.BoolVar(
+		&downloadConf.archive, "compress-archive", false,
+		"write new emails of each folder to a tar.gz archive next to the folder's\n"+
+			"maildir instead, the archive can be extracted into a maildir later",
+	)
+	flags.IntVar(
+		&downloadConf.maxPartSize, "max-part-size", 0,
+		"replace parts of emails such as attachments larger than this many bytes by\n"+
+			"a placeholder, text/plain and text/html parts are always kept, 0 keeps all,\n"+
+			"emails are still retrieved in full",
+	)
+	flags.BoolVar(
+		&downloadConf.newestFirst, "newest-first", false,
+		"download the most recent emails first so that an interrupted run has\n"+
+			"retrieved those",
+	)
+	flags.BoolVar(
+		&downloadConf.mirror, "mirror", false,
+		"move local emails that have been deleted on the server to a maildir called\n"+
+			"\".deleted\" within the folder's maildir, emails are never deleted",
+	)
+	flags.IntVar(
+		&downloadConf.connectRetries, "connect-retries", defaultConnectRetries,
+		"number of times to retry connecting to the server after network errors or\n"+
+			"transient server errors, failed logins are retried only for the latter",
+	)
+	flags.IntVar(
+		&downloadConf.connectBackoff, "connect-backoff", defaultConnectBackoffSeconds,
+		"time in seconds to wait before retrying to connect, doubles with every retry",
+	)
+	flags.StringArrayVar(
+		&downloadConf.transientErrors, "transient-error", nil,
+		"regular expression matching server errors that are worth retrying, may be given\n"+
+			"multiple times, replaces the built-in list of transient errors",
+	)
+	flags.BoolVar(
+		&downloadConf.enableSync, "enable-sync", false,
+		"enable CONDSTORE via ENABLE after logging in to list only changed\n"+
+			"emails, compares emails by UID if the server does not enable CONDSTORE",
+	)
+	flags.BoolVar(
+		&downloadConf.compress, "compress-traffic", false,
+		"compress all traffic after logging in if the server supports COMPRESS=DEFLATE,\n"+
+			"continues uncompressed otherwise",
+	)
+	flags.BoolVar(
+		&downloadConf.keepMalformed, "keep-malformed", false,
+		"store empty or malformed emails as they are instead of reporting them as\n"+
+			"errors and retrying their download during the next run",
+	)
+	flags.StringVar(
+		&downloadConf.quarantine, "quarantine", "",
+		"directory to write whatever has been received of emails that cannot be\n"+
+			"retrieved in full or stored to, as .partial files with .error notes",
+	)
+	flags.BoolVar(
+		&downloadConf.mbox, "mbox", false,
+		"additionally append new emails of each folder to an mbox file next to the\n"+
+			"folder's maildir, emails are retrieved only once",
+	)
+	flags.StringVar(
+		&downloadConf.format, "format", string(core.OutputFormatAuto),
+		"output format, one of \"auto\", \"maildir\", or \"mbox\", \"auto\" continues in\n"+
+			"the format of existing output, any other value fails if it differs from it",
+	)
+	flags.IntVar(
+		&downloadConf.minSize, "min-size", 0,
+		"download only emails of at least this many bytes, 0 disables this bound",
+	)
+	flags.IntVar(
+		&downloadConf.maxSize, "max-size", 0,
+		"download only emails of at most this many bytes, 0 disables this bound",
+	)
+	flags.IntVar(
+		&downloadConf.sinceUID, "since-uid", 0,
+		"download only emails with at least this UID, 0 disables this bound",
+	)
+	flags.IntVar(
+		&downloadConf.sinceValidity, "since-uid-validity", 0,
+		"UIDVALIDITY that --since-uid refers to, it is ignored with a warning for\n"+
+			"folders with a different UIDVALIDITY",
+	)
+	flags.StringVar(
+		&downloadConf.seqRange, "seq-range", "",
+		"download only emails at these positions in each folder, given as sequence\n"+
+			"numbers <START>:<END>, e.g. \"100:200\" for diagnostics",
+	)
+	flags.StringVar(
+		&downloadConf.addressFilter, "address-filter", "",
+		"store only emails whose From, To, or Cc header matches this regular expression,\n"+
+			"others are still retrieved in full but counted as filtered and not retrieved again",
+	)
+	flags.StringVar(
+		&downloadConf.uidFile, "uid-file", "",
+		"download only the emails whose UIDs are listed in this file, one per line,\n"+
+			"optionally as <UIDVALIDITY>/<UID>, even if they have been downloaded before",
+	)
+	flags.BoolVar(
+		&downloadConf.refresh, "refresh", false,
+		"ignore which emails have been downloaded before and download all emails of the\n"+
+			"selected folders again, e.g. combined with a single --folder to recover it",
+	)
+	flags.StringArrayVar(
+		&downloadConf.referenceDirs, "reference-maildir", nil,
+		"directory with maildirs of another archive, emails whose Message-IDs are found\n"+
+			"in them are not downloaded, may be given multiple times",
+	)
+	flags.StringVar(
+		&downloadConf.fetchPreset, "fetch-preset", "",
+		"items to retrieve for each email, one of \"full\", \"headers\", or \"metadata\",\n"+
+			"defaults to \"full\" or to \"headers\" with --headers-only",
+	)
+	flags.BoolVar(
+		&downloadConf.entireBody, "fetch-entire-body", false,
+		"retrieve the full content of emails via BODY.PEEK[] instead of RFC822 for\n"+
+			"servers that reject the latter, used automatically after such a rejection",
+	)
+	flags.BoolVar(
+		&downloadConf.bodyStructure, "body-structure", false,
+		"also retrieve the MIME structure of emails and append it as JSON to the file\n"+
+			"imapgrab-bodystructure.jsonl in each folder's maildir",
+	)
+	flags.BoolVar(
+		&downloadConf.gmailLabels, "gmail-labels", false,
+		"also retrieve Gmail's labels and message and thread IDs of emails and append them\n"+
+			"as JSON to the file imapgrab-gmail.jsonl in each folder's maildir, Gmail only",
+	)
+	flags.StringVar(
+		&downloadConf.fileNaming, "file-naming", "",
+		"how to name files of emails in maildirs, one of \"unique\" or \"uid\",\n"+
+			"defaults to \"unique\", \"uid\" names them \"<UIDVALIDITY>.<UID>.eml\"",
+	)
+	flags.StringVar(
+		&downloadConf.nameTemplate, "file-name-template", "",
+		"Go template for names of files of emails in maildirs instead of --file-naming,\n"+
+			"fields are .UID, .UIDValidity, .Date, .Subject, .From, and .MessageID",
+	)
+	flags.BoolVar(
+		&downloadConf.nameMetadata, "file-name-metadata", false,
+		"add the UID and size of emails to the names of their files in maildirs, e.g.\n"+
+			"\"<NAME>,U=<UID>,W=<SIZE>\", as understood by Dovecot and others",
+	)
+	flags.StringVar(
+		&downloadConf.layout, "layout", "",
+		"how to organise emails of a folder, one of \"maildir\" or \"date\", defaults\n"+
+			"to \"maildir\", \"date\" uses one maildir per month, e.g. \"INBOX/2024/01\"",
+	)
+	flags.StringVar(
+		&downloadConf.dedup, "dedup", "",
+		"replace emails whose content has already been stored by links to the existing\n"+
+			"file, one of \"hardlink\" or \"symlink\", disabled by default,\n"+
+			"\"symlink\" cannot be combined with --mirror or with pruning",
+	)
+	flags.StringVar(
+		&downloadConf.lineEnding, "line-endings", "",
+		"line endings of stored emails, one of \"crlf\" or \"lf\", defaults to \"crlf\",\n"+
+			"\"lf\" converts headers and text parts only and keeps attachments unchanged,\n"+
+			"\"lf\" cannot be combined with --move-to or --delete-from-server",
+	)
+	flags.StringVar(
+		&downloadConf.dateFallback, "date-fallback", "",
+		"what to use for emails the server reports without a date, one of \"header\",\n"+
+			"\"now\", or \"none\", defaults to the Date header and then the download time",
+	)
+	flags.BoolVar(
+		&downloadConf.strictUIDCount, "strict-uid-count", false,
+		"fail instead of only warning if the server reports fewer UIDs than emails\n"+
+			"in a folder even after retrying",
+	)
+	flags.BoolVar(
+		&downloadConf.manifest, "manifest", false,
+		"write a JSON manifest listing all downloaded emails with their meta data to\n"+
+			"each folder's maildir",
+	)
+	flags.BoolVar(
+		&downloadConf.metadata, "metadata", false,
+		"store the annotations of each folder in its maildir if the server supports the\n"+
+			"METADATA extension",
+	)
+	flags.BoolVar(
+		&downloadConf.audit, "audit", false,
+		"append a tamper-evident record with origin, download time, and content hash of\n"+
+			"every downloaded email to an audit log in each folder's maildir",
+	)
+	flags.BoolVar(
+		&downloadConf.maildirFlags, "maildir-flags", false,
+		"store flags such as seen, answered, or draft as maildir flags in the names of\n"+
+			"files, emails in drafts folders are always flagged as drafts",
+	)
+	flags.StringVar(
+		&downloadConf.maildirSubdir, "maildir-subdir", "",
+		"maildir sub-directory emails are stored in, one of \"auto\", \"cur\", or \"new\",\n"+
+			"defaults to \"auto\", i.e. \"cur\" for emails with flags and \"new\" otherwise",
+	)
+	flags.BoolVar(
+		&downloadConf.compressIndex, "compress-index", false,
+		"gzip-compress the manifest, implies --manifest",
+	)
+	flags.StringVar(
+		&downloadConf.hostID, "host-id", "",
+		"identifier used instead of the host name in the names of new files, use distinct\n"+
+			"values if machines with the same host name write to the same maildir",
+	)
+	flags.BoolVar(
+		&downloadConf.accountDirs, "account-dirs", false,
+		"store maildirs in a directory specific to the account, i.e. <USER>@<SERVER>,\n"+
+			"within the download path to back up several accounts to the same path",
+	)
+	flags.BoolVar(
+		&downloadConf.verifyCount, "verify-count", false,
+		"fail instead of only warning if fewer emails have been stored than were to be\n"+
+			"downloaded even though no errors were reported",
+	)
+	flags.StringVar(
+		&downloadConf.moveTo, "move-to", "",
+		"move emails on the server to this folder once they have been stored locally,\n"+
+			"this modifies your mailbox, cannot be combined with --mirror",
+	)
+	flags.BoolVar(
+		&downloadConf.deleteFromServer, "delete-from-server", false,
+		"delete emails from the server once they have been stored locally by marking them\n"+
+			"as deleted and expunging, which also removes any other emails marked as deleted,\n"+
+			"requires --confirm-delete-from-server, cannot be combined with --move-to or --mirror",
+	)
+	flags.BoolVar(
+		&downloadConf.confirmDelete, "confirm-delete-from-server", false,
+		"confirm that emails shall be deleted from the server with --delete-from-server",
+	)
+	flags.BoolVar(
+		&downloadConf.summary, "summary", false,
+		"print a table summarising the numbers of downloaded, skipped, and failed emails,\n"+
+			"the bytes downloaded, and the time taken for each folder at the end",
+	)
+	flags.BoolVar(
+		&downloadConf.jsonOutput, "json", false,
+		"print the summary as a JSON array of objects instead of a table, implies --summary",
+	)
+	flags.IntVar(
+		&downloadConf.folderBuffer, "folder-buffer", defaultFolderListBuffer,
+		"number of folders to buffer while retrieving the list of folders",
+	)
+	flags.IntVar(
+		&downloadConf.messageBuffer, "message-buffer", defaultMessageBuffer,
+		"number of emails to buffer while retrieving them, larger values can improve\n"+
+			"throughput on high-latency connections but use more memory",
+	)
+	flags.BoolVar(
+		&downloadConf.gmailAllMail, "include-gmail-all-mail", false,
+		"also download Gmail's folder containing all emails when selected via a spec\n"+
+			"such as _ALL_, it is skipped by default since it duplicates all emails",
+	)
 }
@@ -18,12 +18,17 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"log"
 	"os"
 	"os/user"
+	"path/filepath"
+	"regexp"
 	"testing"
 	"time"
 
+	"github.com/razziel89/go-imapgrab/core"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -32,8 +37,9 @@ import (
 
 func TestDownloadCommand(t *testing.T) {
 	mockOps := mockCoreOps{}
-	mockOps.On("downloadFolder", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
-		Return(fmt.Errorf("some error"))
+	mockOps.On(
+		"downloadFolder", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(fmt.Errorf("some error"))
 	defer mockOps.AssertExpectations(t)
 
 	lockCalled := false
@@ -60,6 +66,284 @@ func TestDownloadCommand(t *testing.T) {
 	assert.True(t, releaseCalled)
 }
 
+func TestDownloadCommandHeadersOnly(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder", mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+		core.DownloadOptions{
+			HeadersOnly: true, ProgressInterval: 5 * time.Second, Format: core.OutputFormatAuto,
+			MaxThreads: core.DefaultMaxThreads,
+		},
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--headers-only", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestDownloadCommandOptions(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder", mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+		core.DownloadOptions{
+			ProgressInterval: 0, Archive: true, MaxPartSize: 1024, NewestFirst: true,
+			Mirror: true, KeepMalformed: true, Mbox: true, MinSize: 10, MaxSize: 2048,
+			FetchPreset: core.FetchPresetMetadata, StrictUIDCount: true, MoveTo: "Archived",
+			IncludeGmailAllMail: true, FileNaming: core.FileNamingUID, VerifyCount: true,
+			AccountDirs: true, Manifest: true, CompressManifest: true, HostID: "host-1",
+			SinceUID: 100, SinceUIDValidity: 42, LineEnding: core.LineEndingLF,
+			AutoThreads: true, UIDFile: "uids.txt", FetchEntireBody: true,
+			KeepaliveInterval: 30 * time.Second, Format: core.OutputFormatMbox,
+			BodyStructure: true, MaxThreads: 3, Layout: core.LayoutDate, GmailLabels: true,
+			Dedup: core.DedupSymlink, FileNameTemplate: "{{.UID}}.eml",
+			FolderThreads: map[string]int{"INBOX": 4, "Archive": 2},
+			Quarantine:    "quarantined", SeqStart: 100, SeqEnd: 200,
+			DeleteFromServer: true, AddressFilter: regexp.MustCompile(`@example\.com$`),
+			Metadata: true, ReconnectEvery: 500, OnlyChanged: true,
+			Audit: true, MaildirFlags: true, MaxRuntime: time.Hour, FileNameMetadata: true,
+			MaxBytesPerSecond: 1000, Refresh: true, MaxConnections: 4, ConnectionSlots: "slots",
+			DateFallback: core.DateFallbackNow, ReferenceMaildirs: []string{"old", "other"},
+			MaildirSubdir: core.MaildirSubdirCur,
+		},
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{
+		"--progress=0", "--compress-archive", "--max-part-size=1024", "--newest-first",
+		"--mirror", "--keep-malformed", "--mbox", "--min-size=10", "--max-size=2048",
+		"--fetch-preset=metadata", "--strict-uid-count", "--move-to=Archived",
+		"--include-gmail-all-mail", "--file-naming", "uid", "--verify-count",
+		"--account-dirs", "--compress-index", "--host-id=host-1",
+		"--since-uid=100", "--since-uid-validity=42", "--line-endings=lf",
+		"--auto-threads", "--uid-file=uids.txt", "--fetch-entire-body", "--keepalive=30",
+		"--format=mbox", "--body-structure", "--gmail-labels", "--max-threads=3",
+		"--layout=date", "--dedup=symlink", "--file-name-template={{.UID}}.eml",
+		"--folder-threads=INBOX=4", "--folder-threads", "Archive=2",
+		"--quarantine=quarantined", "--seq-range=100:200", "--delete-from-server",
+		"--confirm-delete-from-server", `--address-filter=@example\.com$`, "--metadata",
+		"--reconnect-every=500", "--only-changed", "--audit",
+		"--maildir-flags", "--max-runtime=3600", "--file-name-metadata",
+		"--max-bytes-per-second=1000", "--refresh", "--date-fallback=now",
+		"--max-connections=4", "--connection-slots=slots",
+		"--reference-maildir=old", "--reference-maildir", "other", "--maildir-subdir=cur",
+		"--no-keyring",
+	})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestDownloadCommandLogDir(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Run(func(_ mock.Arguments) { log.Println("downloading") }).Return(fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	logDir := t.TempDir()
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--log-dir", logDir, "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.Error(t, err)
+
+	// The log file has been closed even though the download failed.
+	paths, err := filepath.Glob(filepath.Join(logDir, "go-imapgrab-*.log"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	content, err := os.ReadFile(paths[0]) // nolint: gosec
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "downloading")
+}
+
+func TestDownloadCommandInvalidSeqRange(t *testing.T) {
+	mockOps := mockCoreOps{}
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	for _, seqRange := range []string{"100", "a:200", "100:b"} {
+		rootConf := rootConfigT{}
+		downloadConf := downloadConfigT{}
+		cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+		cmd.SetArgs([]string{"--seq-range", seqRange, "--no-keyring"})
+
+		err := cmd.Execute()
+		assert.ErrorContains(t, err, "cannot parse sequence number range", seqRange)
+	}
+}
+
+func TestDownloadCommandInvalidAddressFilter(t *testing.T) {
+	mockOps := mockCoreOps{}
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--address-filter", "(unclosed", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "cannot parse address filter")
+}
+
+func TestDownloadCommandDeleteFromServerRequiresConfirmation(t *testing.T) {
+	mockOps := mockCoreOps{}
+	defer mockOps.AssertExpectations(t)
+
+	locked := false
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		locked = true
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--delete-from-server", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "add --confirm-delete-from-server to proceed")
+	assert.False(t, locked)
+}
+
+func TestDownloadCommandConnectRetries(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool {
+			return cfg.ConnectRetries == 5 && cfg.ConnectBackoff == 2*time.Second &&
+				cfg.Compress && cfg.EnableSync
+		}),
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{
+		"--connect-retries=5", "--connect-backoff=2", "--compress-traffic", "--enable-sync",
+		"--no-keyring",
+	})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestDownloadCommandTransientErrors(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool {
+			return len(cfg.TransientErrors) == 2 &&
+				cfg.TransientErrors[0].String() == "(?i)overloaded" &&
+				cfg.TransientErrors[1].String() == `\[LIMIT\]`
+		}),
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{
+		"--transient-error=(?i)overloaded", `--transient-error=\[LIMIT\]`, "--no-keyring",
+	})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestDownloadCommandTransientErrorsInvalid(t *testing.T) {
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockCoreOps{}, nil)
+	cmd.SetArgs([]string{"--transient-error=(unclosed", "--no-keyring"})
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "cannot parse transient error pattern")
+}
+
+func TestDownloadCommandBufferSizes(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool {
+			return cfg.FolderListBuffer == 5 && cfg.MessageBuffer == 100
+		}),
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--folder-buffer=5", "--message-buffer=100", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
 func TestDownloadCommandNoKeyringProdRun(t *testing.T) {
 	mockOps := mockCoreOps{}
 	// Nothing will be called because the keyring cannot be initialised and the password is not
@@ -150,3 +434,47 @@ func TestDownloadCommandNoCredentialsInKeyring(t *testing.T) {
 	err = cmd.Execute()
 	assert.ErrorContains(t, err, "secret not found in keyring")
 }
+
+func TestDownloadCommandSummary(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"downloadFolder", mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+		mock.MatchedBy(func(opts core.DownloadOptions) bool { return opts.Summary != nil }),
+	).Return(fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestPrintDownloadSummary(t *testing.T) {
+	folders := []core.FolderStats{
+		{
+			Account: "a@server", Folder: "INBOX", Downloaded: 2, Skipped: 5, Filtered: 3,
+			Bytes: 42, Seconds: 1.25,
+		},
+		{Account: "a@server", Folder: "Sent", Failed: 1, Seconds: 0.5, Error: "some error"},
+	}
+	buf := bytes.Buffer{}
+
+	err := printDownloadSummary(&buf, folders)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"ACCOUNT   FOLDER  DOWNLOADED  SKIPPED  FILTERED  FAILED  BYTES  SECONDS  ERROR\n" +
+		"a@server  INBOX   2           5        3         0       42     1.2      \n" +
+		"a@server  Sent    0           0        0         1       0      0.5      some error\n" +
+		"TOTAL             2           5        3         1       42     1.8      \n"
+	assert.Equal(t, expected, buf.String())
+}
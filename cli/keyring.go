@@ -94,6 +94,18 @@ func initCredentials(rootConf *rootConfigT, keyring keyringOps, verbose bool) er
 			log.Println(s)
 		}
 	}
+	// A password read from stdin takes precedence since it has been requested explicitly. It is
+	// never added to the keyring since the login command serves that purpose.
+	if rootConf.passwordStdin {
+		password, err := readPasswordFromStdin()
+		if err != nil {
+			return fmt.Errorf("cannot read password from stdin: %s", err.Error())
+		}
+		logDebug("password taken from stdin")
+		rootConf.password = password
+		return nil
+	}
+
 	if passwordInput, found := os.LookupEnv(passwdEnvVar); found {
 		// Try to interpret the password as pointing to a file that exists. If so, we read the value
 		// from the file. If not, we use the value from the environment directly. This enables the
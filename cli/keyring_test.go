@@ -186,6 +186,33 @@ func TestInitCredentialsFromEnvironmentNoKeyring(t *testing.T) {
 	mk.AssertExpectations(t)
 }
 
+func TestInitCredentialsFromStdin(t *testing.T) {
+	// The password from stdin takes precedence.
+	t.Setenv("IGRAB_PASSWORD", "some password")
+	orgReadPasswordFromStdin := readPasswordFromStdin
+	t.Cleanup(func() { readPasswordFromStdin = orgReadPasswordFromStdin })
+	readPasswordFromStdin = func() (string, error) {
+		return "stdin password", nil
+	}
+
+	cfg := rootConfigT{server: "server", port: 42, username: "user", passwordStdin: true}
+	// The password is never added to the keyring.
+	mk := &mockKeyring{}
+
+	err := initCredentials(&cfg, mk, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "stdin password", cfg.password)
+	mk.AssertExpectations(t)
+
+	readPasswordFromStdin = func() (string, error) {
+		return "", fmt.Errorf("some error")
+	}
+	err = initCredentials(&cfg, mk, false)
+
+	assert.ErrorContains(t, err, "cannot read password from stdin: some error")
+}
+
 func TestInitCredentialsNoPasswordNoKeyring(t *testing.T) {
 	if orgVal, found := os.LookupEnv("IGRAB_PASSWORD"); found {
 		defer func() {
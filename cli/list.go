@@ -18,7 +18,9 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strings"
@@ -29,7 +31,29 @@ import (
 
 const shortListHelp = "Print all folders in your inbox."
 
+// Print a value as indented JSON for consumption by other tools.
+func printJSON(writer io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// Label folders with a special use, e.g. "Trash (trash)", for display.
+func labelFolders(infos []core.FolderInfo) []string {
+	labeled := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if specialUse := info.SpecialUse(); specialUse != "" {
+			labeled = append(labeled, fmt.Sprintf("%s (%s)", info.Name, specialUse))
+		} else {
+			labeled = append(labeled, info.Name)
+		}
+	}
+	return labeled
+}
+
 func getListCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.Command {
+	var jsonOutput bool
+	var specialUses []string
 	cmd := &cobra.Command{
 		Use:   "list",
 		Long:  shortListHelp + "\n\n" + typicalFlowHelp,
@@ -39,16 +63,40 @@ func getListCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.C
 			// Allow insecure auth for local server for testing.
 			insecure := rootConf.server == localhost
 			cfg := core.IMAPConfig{
-				Server:   rootConf.server,
-				Port:     rootConf.port,
-				User:     rootConf.username,
-				Password: rootConf.password,
-				Insecure: insecure,
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+				SubscribedOnly:    rootConf.subscribedOnly,
+				SharedFolders:     rootConf.sharedFolders,
+			}
+			infos, err := ops.getFolderInfos(cfg)
+			if len(specialUses) > 0 {
+				infos = core.FilterFoldersBySpecialUse(infos, specialUses)
 			}
-			folders, err := ops.getAllFolders(cfg)
 
-			sort.Strings(folders)
-			fmt.Println(strings.Join(folders, "\n"))
+			sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+			if jsonOutput {
+				// Never print partial results in a machine-readable format.
+				if err != nil {
+					return err
+				}
+				folders := make([]string, 0, len(infos))
+				for _, info := range infos {
+					folders = append(folders, info.Name)
+				}
+				return printJSON(os.Stdout, folders)
+			}
+			fmt.Println(strings.Join(labelFolders(infos), "\n"))
 
 			return err
 		},
@@ -62,6 +110,14 @@ func getListCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.C
 		},
 	}
 	initRootFlags(cmd, rootConf)
+	initFolderListFlags(cmd, rootConf)
+	cmd.Flags().BoolVar(
+		&jsonOutput, "json", false, "print folders as a JSON array instead of one per line",
+	)
+	cmd.Flags().StringSliceVar(
+		&specialUses, "special-use", nil,
+		"only list folders with this special use, e.g. sent or trash (can be given several times)",
+	)
 	return cmd
 }
 
@@ -18,11 +18,14 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/user"
+	"reflect"
 	"testing"
 
+	"github.com/razziel89/go-imapgrab/core"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -31,7 +34,8 @@ import (
 
 func TestListCommand(t *testing.T) {
 	mockOps := mockCoreOps{}
-	mockOps.On("getAllFolders", mock.Anything).Return([]string{}, fmt.Errorf("some error"))
+	mockOps.On("getFolderInfos", mock.Anything).
+		Return([]core.FolderInfo{}, fmt.Errorf("some error"))
 	defer mockOps.AssertExpectations(t)
 
 	t.Setenv("IGRAB_PASSWORD", "some password")
@@ -88,3 +92,225 @@ func TestListCommandNoCredentialsInKeyring(t *testing.T) {
 	err = cmd.Execute()
 	assert.ErrorContains(t, err, "secret not found in keyring")
 }
+
+func TestListCommandJSON(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getFolderInfos", mock.Anything).
+		Return([]core.FolderInfo{{Name: "b"}, {Name: "a"}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandJSONError(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getFolderInfos", mock.Anything).
+		Return([]core.FolderInfo{{Name: "a"}}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestListCommandSpecialUse(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getFolderInfos", mock.Anything).
+		Return([]core.FolderInfo{{Name: "Sent", Attributes: []string{"\\Sent"}}, {Name: "a"}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--special-use", "sent", "--json", "--no-keyring"})
+
+	readStdouterr := catchStdoutStderr(t)
+	err := cmd.Execute()
+	assert.NoError(t, err)
+
+	stdout, _ := readStdouterr()
+	assert.Equal(t, "[\n  \"Sent\"\n]\n", stdout)
+}
+
+func TestListCommandSubscribedOnly(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool { return cfg.SubscribedOnly }),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--subscribed-only", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandSharedFolders(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool { return cfg.SharedFolders }),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--shared-folders", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandNoTLSSessionCache(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool { return cfg.NoTLSSessionCache }),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--no-tls-session-cache", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandTLSServerName(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool {
+			return cfg.TLSServerName == "imap.example.com"
+		}),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--tls-server-name", "imap.example.com", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestLabelFolders(t *testing.T) {
+	infos := []core.FolderInfo{
+		{Name: "INBOX"},
+		{Name: "[Gmail]/Spam", Attributes: []string{"\\HasNoChildren", "\\Junk"}},
+	}
+
+	assert.Equal(t, []string{"INBOX", "[Gmail]/Spam (spam)"}, labelFolders(infos))
+}
+
+func TestPrintJSON(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	err := printJSON(&buf, []string{"INBOX", "Sent"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n  \"INBOX\",\n  \"Sent\"\n]\n", buf.String())
+}
+
+func TestListCommandAuthzID(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool { return cfg.AuthzID == "shared" }),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--authzid", "shared", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandAuthMethod(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool {
+			return cfg.AuthMethod == core.AuthMethodScramSHA256
+		}),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--auth-method", "SCRAM-SHA-256", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandFallbackServers(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool {
+			return reflect.DeepEqual(cfg.FallbackServers, []string{"imap2.example.com", "imap3:143"})
+		}),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{
+		"--fallback-server", "imap2.example.com", "--fallback-server", "imap3:143", "--no-keyring",
+	})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestListCommandProtocolLog(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On(
+		"getFolderInfos",
+		mock.MatchedBy(func(cfg core.IMAPConfig) bool { return cfg.ProtocolLog == os.Stderr }),
+	).Return([]core.FolderInfo{}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getListCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--protocol-log", "-", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,92 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// Log files of runs are called like "go-imapgrab-20060102-150405.log". Their names sort in the
+	// order the runs were started in.
+	logFilePrefix     = "go-imapgrab-"
+	logFileSuffix     = ".log"
+	logFileTimeLayout = "20060102-150405"
+	// Logs may contain folder names and email addresses, which is why only the owner may read them.
+	logFilePerm = 0600
+	// The number of log files of runs that are kept by default.
+	defaultLogFilesKept = 10
+)
+
+// The time used to name log files, replaceable in tests.
+var logFileNow = time.Now
+
+// Start writing all log output to a new log file in the given directory in addition to the
+// current output. Only the newest log files are kept, removing older ones. A value of zero or less
+// for the number of files to keep keeps all of them. The returned function stops writing to the
+// file and closes it. It has to be called before the program exits.
+func startRunLog(dir string, kept int) (func(), error) {
+	err := os.MkdirAll(dir, dirPerms)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create log directory: %s", err.Error())
+	}
+	name := logFilePrefix + logFileNow().Format(logFileTimeLayout) + logFileSuffix
+	file, err := os.OpenFile( // nolint: gosec
+		filepath.Join(dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, logFilePerm,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log file: %s", err.Error())
+	}
+	if err := rotateRunLogs(dir, kept); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	previous := log.Writer()
+	log.SetOutput(io.MultiWriter(previous, file))
+	return func() {
+		log.SetOutput(previous)
+		_ = file.Sync()
+		_ = file.Close()
+	}, nil
+}
+
+// Remove the oldest log files of runs in a directory so that only the given number of them is
+// left. Files not named like log files of runs are kept.
+func rotateRunLogs(dir string, kept int) error {
+	if kept <= 0 {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, logFilePrefix+"*"+logFileSuffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for len(paths) > kept {
+		if err := os.Remove(paths[0]); err != nil {
+			return fmt.Errorf("cannot remove old log file: %s", err.Error())
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
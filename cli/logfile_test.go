@@ -0,0 +1,92 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setUpLogFileClock(t *testing.T, date time.Time) {
+	orgNow := logFileNow
+	t.Cleanup(func() { logFileNow = orgNow })
+	logFileNow = func() time.Time { return date }
+}
+
+func TestStartRunLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	setUpLogFileClock(t, time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC))
+
+	stop, err := startRunLog(dir, defaultLogFilesKept)
+	require.NoError(t, err)
+	log.Println("some message")
+	stop()
+	log.Println("not in the file")
+
+	content, err := os.ReadFile(filepath.Join(dir, "go-imapgrab-20220304-050607.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "some message")
+	assert.NotContains(t, string(content), "not in the file")
+}
+
+func TestStartRunLogRotates(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"go-imapgrab-20220101-000000.log", "go-imapgrab-20220102-000000.log", "other.log",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, filePerms))
+	}
+	setUpLogFileClock(t, time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC))
+
+	stop, err := startRunLog(dir, 2)
+	require.NoError(t, err)
+	stop()
+
+	assert.False(t, exists(filepath.Join(dir, "go-imapgrab-20220101-000000.log")))
+	assert.True(t, exists(filepath.Join(dir, "go-imapgrab-20220102-000000.log")))
+	assert.True(t, exists(filepath.Join(dir, "go-imapgrab-20220103-000000.log")))
+	assert.True(t, exists(filepath.Join(dir, "other.log")))
+}
+
+func TestStartRunLogKeepsAll(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "go-imapgrab-20220101-000000.log")
+	require.NoError(t, os.WriteFile(old, nil, filePerms))
+	setUpLogFileClock(t, time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC))
+
+	stop, err := startRunLog(dir, 0)
+	require.NoError(t, err)
+	stop()
+
+	assert.True(t, exists(old))
+}
+
+func TestStartRunLogCannotCreateDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, filePerms))
+
+	_, err := startRunLog(filepath.Join(file, "logs"), defaultLogFilesKept)
+
+	assert.ErrorContains(t, err, "cannot create log directory")
+}
@@ -18,6 +18,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
@@ -84,10 +85,19 @@ func getLoginCmd(
 			// Allow insecure auth for local server for testing.
 			insecure := rootConf.server == localhost
 			cfg := core.IMAPConfig{
-				Server:   rootConf.server,
-				Port:     rootConf.port,
-				User:     rootConf.username,
-				Insecure: insecure,
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+
 				// Password will be filled in later.
 				Password: "",
 			}
@@ -148,6 +158,34 @@ func readFromStdin() ([]byte, error) {
 	return io.ReadAll(os.Stdin)
 }
 
+// Read a password from stdin. On a terminal, the user is prompted and the input is not echoed.
+// Otherwise, e.g. for pipes, only the first line is read without its line ending.
+func readPasswordLine() (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || (info.Mode()&os.ModeCharDevice) == os.ModeCharDevice {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := readFromTerminal(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr)
+		if err == nil && len(password) == 0 {
+			err = fmt.Errorf("empty password")
+		}
+		return string(password), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	// The last line might lack a line ending.
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if err == nil && line == "" {
+		err = fmt.Errorf("empty password")
+	}
+	return line, err
+}
+
+// Make this a function pointer to simplify testing.
+var readPasswordFromStdin = readPasswordLine
+
 var loginCmd = getLoginCmd(&rootConfig, defaultKeyring, readFromStdin, &corer{})
 
 func init() {
@@ -166,3 +166,53 @@ func TestReadFromStdin(t *testing.T) {
 	assert.True(t, readInteractively)
 	assert.Equal(t, string(text), "input")
 }
+
+func TestReadPasswordLine(t *testing.T) {
+	orgReadFromTerminal := readFromTerminal
+	orgStdin := os.Stdin
+	t.Cleanup(func() {
+		readFromTerminal = orgReadFromTerminal
+		os.Stdin = orgStdin
+	})
+	readFromTerminal = func(_ int) ([]byte, error) {
+		return []byte("input"), nil
+	}
+	fakeStdin := func(content string) {
+		fakeStdinPath := filepath.Join(t.TempDir(), "stdin")
+		err := os.WriteFile(fakeStdinPath, []byte(content), filePerms)
+		require.NoError(t, err)
+		os.Stdin, err = os.Open(fakeStdinPath)
+		require.NoError(t, err)
+	}
+
+	// Only the first line is read from stdin that is not a terminal.
+	fakeStdin("secret\r\nfurther input\n")
+	password, err := readPasswordLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", password)
+
+	fakeStdin("secret")
+	password, err = readPasswordLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", password)
+
+	fakeStdin("\n")
+	_, err = readPasswordLine()
+	assert.ErrorContains(t, err, "empty password")
+
+	fakeStdin("")
+	_, err = readPasswordLine()
+	assert.Error(t, err)
+
+	// Reading from stdin that is a terminal.
+	os.Stdin = nil
+	password, err = readPasswordLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "input", password)
+
+	readFromTerminal = func(_ int) ([]byte, error) {
+		return nil, nil
+	}
+	_, err = readPasswordLine()
+	assert.ErrorContains(t, err, "empty password")
+}
@@ -18,15 +18,28 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"errors"
 	"os"
+
+	"github.com/razziel89/go-imapgrab/core"
 )
 
-const localhost = "127.0.0.1"
+const (
+	localhost = "127.0.0.1"
+	// The exit code of downloads that stopped early due to --max-runtime, which is EX_TEMPFAIL
+	// from sysexits.h. It tells schedulers that the next run will continue the download.
+	incompleteExitCode = 75
+)
 
 var exitFn = os.Exit
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	// Otherwise, repetitions of warnings and errors logged since the last download would be lost.
+	core.FlushRepeatedLogs()
+	if errors.Is(err, core.ErrRuntimeExceeded) {
+		exitFn(incompleteExitCode)
+	} else if err != nil {
 		exitFn(1)
 	}
 }
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/razziel89/go-imapgrab/core"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 )
@@ -48,3 +49,24 @@ func TestMain(t *testing.T) {
 	assert.True(t, calledRootCmd)
 	assert.True(t, calledLogFatal)
 }
+
+func TestMainIncomplete(t *testing.T) {
+	orgRootCmd := rootCmd
+	t.Cleanup(func() { rootCmd = orgRootCmd })
+	rootCmd = &cobra.Command{
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return fmt.Errorf("some folder: %w", core.ErrRuntimeExceeded)
+		},
+	}
+
+	exitCode := 0
+	orgExitFn := exitFn
+	t.Cleanup(func() { exitFn = orgExitFn })
+	exitFn = func(code int) {
+		exitCode = code
+	}
+
+	main()
+
+	assert.Equal(t, incompleteExitCode, exitCode)
+}
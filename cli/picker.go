@@ -0,0 +1,130 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"golang.org/x/term"
+)
+
+const selectAll = "all"
+
+var stdinIsTerminal = func() bool {
+	return term.IsTerminal(int(syscall.Stdin))
+}
+
+// Parse a selection such as "1,3-5" into zero-based indices into a list of the given length.
+// The special selection "all" selects every entry. Duplicates are dropped and the original
+// order of the list is kept.
+func parseSelection(text string, length int) ([]int, error) {
+	text = strings.TrimSpace(text)
+	if strings.EqualFold(text, selectAll) {
+		text = fmt.Sprintf("1-%d", length)
+	}
+	selected := make([]bool, length)
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		startText, endText, isRange := strings.Cut(item, "-")
+		if !isRange {
+			endText = startText
+		}
+		start, startErr := strconv.Atoi(strings.TrimSpace(startText))
+		end, endErr := strconv.Atoi(strings.TrimSpace(endText))
+		if startErr != nil || endErr != nil || start < 1 || end > length || start > end {
+			return nil, fmt.Errorf("invalid selection '%s', use numbers between 1 and %d", item, length)
+		}
+		for idx := start; idx <= end; idx++ {
+			selected[idx-1] = true
+		}
+	}
+	indices := []int{}
+	for idx, isSelected := range selected {
+		if isSelected {
+			indices = append(indices, idx)
+		}
+	}
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("no folders selected")
+	}
+	return indices, nil
+}
+
+// Show a numbered list of folders with their message counts and let the user select some of
+// them. The user is asked again after invalid input until the reader is exhausted.
+func pickFolders(reader io.Reader, writer io.Writer, counts []core.FolderCount) ([]string, error) {
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("there are no folders to select from")
+	}
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(table, "NUMBER\tFOLDER\tMESSAGES")
+	for idx, count := range counts {
+		fmt.Fprintf(table, "%d\t%s\t%d\n", idx+1, count.Name, count.Messages)
+	}
+	if err := table.Flush(); err != nil {
+		return nil, err
+	}
+
+	lines := bufio.NewScanner(reader)
+	for {
+		fmt.Fprint(writer, "Folders to download, e.g. 1,3-5 or all: ")
+		if !lines.Scan() {
+			fmt.Fprintln(writer)
+			if err := lines.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no folders selected")
+		}
+		indices, err := parseSelection(lines.Text(), len(counts))
+		if err != nil {
+			fmt.Fprintln(writer, err.Error())
+			continue
+		}
+		folders := make([]string, 0, len(indices))
+		for _, idx := range indices {
+			folders = append(folders, counts[idx].Name)
+		}
+		return folders, nil
+	}
+}
+
+// Let the user pick folders interactively. The candidates are the folders selected by the given
+// folder specs, or all folders if there are none. The exact names of the picked folders are
+// returned, which have to be downloaded via core.DownloadOptions.ExactFolders.
+func selectFoldersInteractively(
+	ops coreOps, cfg core.IMAPConfig, folderSpecs []string, reader io.Reader, writer io.Writer,
+) ([]string, error) {
+	if len(folderSpecs) == 0 {
+		folderSpecs = []string{"_ALL_"}
+	}
+	counts, err := ops.getMessageCounts(cfg, folderSpecs)
+	if err != nil {
+		return nil, err
+	}
+	return pickFolders(reader, writer, counts)
+}
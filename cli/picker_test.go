@@ -0,0 +1,182 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelection(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []int
+		err      string
+	}{
+		{"1", []int{0}, ""},
+		{"3,1", []int{0, 2}, ""},
+		{" 2 - 4 , 1", []int{0, 1, 2, 3}, ""},
+		{"1-2,2-3", []int{0, 1, 2}, ""},
+		{"ALL", []int{0, 1, 2, 3, 4}, ""},
+		{"", nil, "no folders selected"},
+		{"0", nil, "invalid selection '0'"},
+		{"6", nil, "invalid selection '6'"},
+		{"3-1", nil, "invalid selection '3-1'"},
+		{"a", nil, "invalid selection 'a'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			indices, err := parseSelection(tc.input, 5)
+
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, indices)
+			}
+		})
+	}
+}
+
+func TestPickFolders(t *testing.T) {
+	counts := []core.FolderCount{
+		{Name: "INBOX", Messages: 12}, {Name: "Sent", Messages: 3}, {Name: "Spam", Messages: 0},
+	}
+	input := strings.NewReader("7\n3,1\n")
+	output := bytes.Buffer{}
+
+	folders, err := pickFolders(input, &output, counts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"INBOX", "Spam"}, folders)
+	expected := "" +
+		"NUMBER  FOLDER  MESSAGES\n" +
+		"1       INBOX   12\n" +
+		"2       Sent    3\n" +
+		"3       Spam    0\n" +
+		"Folders to download, e.g. 1,3-5 or all: " +
+		"invalid selection '7', use numbers between 1 and 3\n" +
+		"Folders to download, e.g. 1,3-5 or all: "
+	assert.Equal(t, expected, output.String())
+}
+
+func TestPickFoldersNoInput(t *testing.T) {
+	counts := []core.FolderCount{{Name: "INBOX", Messages: 12}}
+
+	_, err := pickFolders(strings.NewReader(""), &bytes.Buffer{}, counts)
+	assert.ErrorContains(t, err, "no folders selected")
+
+	_, err = pickFolders(strings.NewReader("all\n"), &bytes.Buffer{}, nil)
+	assert.ErrorContains(t, err, "there are no folders to select from")
+}
+
+func TestSelectFoldersInteractively(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getMessageCounts", mock.Anything, []string{"_ALL_"}).
+		Return([]core.FolderCount{{Name: "INBOX", Messages: 2}, {Name: "Sent", Messages: 1}}, nil)
+	mockOps.On("getMessageCounts", mock.Anything, []string{"_Gmail_"}).
+		Return([]core.FolderCount{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	folders, err := selectFoldersInteractively(
+		&mockOps, core.IMAPConfig{}, nil, strings.NewReader("2\n"), &bytes.Buffer{},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Sent"}, folders)
+
+	_, err = selectFoldersInteractively(
+		&mockOps, core.IMAPConfig{}, []string{"_Gmail_"}, strings.NewReader("1\n"), &bytes.Buffer{},
+	)
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestDownloadCommandInteractiveWithoutTerminal(t *testing.T) {
+	orgStdinIsTerminal := stdinIsTerminal
+	stdinIsTerminal = func() bool { return false }
+	t.Cleanup(func() { stdinIsTerminal = orgStdinIsTerminal })
+
+	mockOps := mockCoreOps{}
+	// The folder specs are passed on unchanged without asking the user.
+	mockOps.On(
+		"downloadFolder", mock.Anything, []string{"INBOX"},
+		mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--interactive", "--folder=INBOX", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestDownloadCommandInteractiveExactNames(t *testing.T) {
+	orgStdinIsTerminal := stdinIsTerminal
+	stdinIsTerminal = func() bool { return true }
+	t.Cleanup(func() { stdinIsTerminal = orgStdinIsTerminal })
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	orgStdin := os.Stdin
+	os.Stdin = reader
+	t.Cleanup(func() { os.Stdin = orgStdin })
+	_, err = writer.WriteString("2\n")
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	mockOps := mockCoreOps{}
+	mockOps.On("getMessageCounts", mock.Anything, []string{"_ALL_"}).
+		Return([]core.FolderCount{{Name: "INBOX", Messages: 2}, {Name: "-Drafts", Messages: 1}}, nil)
+	// The picked folder is passed on as an exact name, not as a spec excluding "Drafts".
+	mockOps.On(
+		"downloadFolder", mock.Anything, []string{"-Drafts"}, mock.Anything, mock.Anything,
+		mock.MatchedBy(func(opts core.DownloadOptions) bool { return opts.ExactFolders }),
+	).Return(nil)
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, nil
+	}
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	downloadConf := downloadConfigT{}
+	cmd := getDownloadCmd(&rootConf, &downloadConf, &mockKeyring{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--interactive", "--no-keyring"})
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,149 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shortPruneHelp = "Remove emails older than a maximum age from locally stored maildirs."
+	hoursPerDay    = 24
+)
+
+type pruneConfigT struct {
+	path           string
+	folders        []string
+	maxAgeDays     int
+	trash          bool
+	dryRun         bool
+	jsonOutput     bool
+	timeoutSeconds int
+}
+
+// Print prune results as a table with one row per folder and a final row with the total.
+func printPruneResults(writer io.Writer, results []core.PruneResult) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(table, "FOLDER\tPRUNED\tKEPT")
+	pruned, kept := 0, 0
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%d\t%d\n", result.Folder, result.Pruned, result.Kept)
+		pruned += result.Pruned
+		kept += result.Kept
+	}
+	fmt.Fprintf(table, "TOTAL\t%d\t%d\n", pruned, kept)
+	return table.Flush()
+}
+
+func getPruneCmd(rootConf *rootConfigT, ops coreOps, lockFn lockFn) *cobra.Command {
+	pruneConf := pruneConfigT{}
+	cmd := &cobra.Command{
+		Use: "prune",
+		Long: shortPruneHelp + "\n\n" +
+			"Emails are identified as outdated via the date at which the server received\n" +
+			"them. Pruned emails remain remembered as downloaded and will not be\n" +
+			"downloaded again. The server is not contacted and no credentials are needed.\n" +
+			"Specify the same server, port, and user as for downloading.",
+		Short: shortPruneHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			if pruneConf.maxAgeDays <= 0 {
+				return fmt.Errorf("a positive maximum age in days is required")
+			}
+			cfg := core.IMAPConfig{
+				Server: rootConf.server,
+				Port:   rootConf.port,
+				User:   rootConf.username,
+			}
+			lockfile := filepath.Join(pruneConf.path, lockfileName)
+			lockTimeout := time.Duration(pruneConf.timeoutSeconds) * time.Second
+			unlock, err := lockFn(lockfile, lockTimeout)
+			if err != nil {
+				return fmt.Errorf(
+					"cannot get lock on local folder, another process might be using it: %s",
+					err.Error(),
+				)
+			}
+			defer unlock()
+			opts := core.PruneOptions{
+				MaxAge: time.Duration(pruneConf.maxAgeDays) * hoursPerDay * time.Hour,
+				Trash:  pruneConf.trash,
+				DryRun: pruneConf.dryRun,
+			}
+			results, err := ops.pruneMaildirs(cfg, pruneConf.folders, pruneConf.path, opts)
+			// Report the folders that could be pruned even if others could not.
+			var printErr error
+			if pruneConf.jsonOutput {
+				printErr = printJSON(os.Stdout, results)
+			} else {
+				printErr = printPruneResults(os.Stdout, results)
+			}
+			if err != nil {
+				return err
+			}
+			return printErr
+		},
+	}
+	initRootFlags(cmd, rootConf)
+
+	flags := cmd.Flags()
+	flags.StringVar(&pruneConf.path, "path", "", "the local path to your maildir's parent dir")
+	flags.StringSliceVarP(
+		&pruneConf.folders,
+		"folder", "f", []string{},
+		"name of a folder whose local maildir to prune, specify this flag multiple\n"+
+			"times for multiple folders",
+	)
+	flags.IntVar(
+		&pruneConf.maxAgeDays, "max-age", 0,
+		"prune emails received more than this many days ago",
+	)
+	flags.BoolVar(
+		&pruneConf.trash, "trash", false,
+		"move pruned emails to a maildir called .pruned within each folder's maildir\n"+
+			"instead of deleting them",
+	)
+	flags.BoolVar(
+		&pruneConf.dryRun, "dry-run", false,
+		"only report how many emails would be pruned without changing anything",
+	)
+	flags.BoolVar(
+		&pruneConf.jsonOutput, "json", false,
+		"print results as a JSON array of objects instead of a table",
+	)
+	flags.IntVar(
+		&pruneConf.timeoutSeconds, "timeout", defaultTimeoutSeconds,
+		"time in seconds to wait for acquiring a lock on the local folder",
+	)
+
+	return cmd
+}
+
+var pruneCmd = getPruneCmd(&rootConfig, &corer{}, lock)
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+}
@@ -0,0 +1,121 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPruneCommand(t *testing.T) {
+	mockOps := mockCoreOps{}
+	opts := core.PruneOptions{MaxAge: 30 * 24 * time.Hour, Trash: true, DryRun: true}
+	mockOps.On("pruneMaildirs", mock.Anything, []string{"INBOX"}, "some/path", opts).
+		Return([]core.PruneResult{{Folder: "INBOX", Pruned: 1, Kept: 2}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	lockCalled := false
+	releaseCalled := false
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		lockCalled = true
+		return func() { releaseCalled = true }, nil
+	}
+
+	cmd := getPruneCmd(&rootConfigT{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{
+		"--folder", "INBOX", "--path", "some/path", "--max-age", "30", "--trash", "--dry-run",
+	})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+	assert.True(t, lockCalled)
+	assert.True(t, releaseCalled)
+}
+
+func TestPruneCommandError(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("pruneMaildirs", mock.Anything, []string{"INBOX"}, "", mock.Anything).
+		Return([]core.PruneResult{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) { return func() {}, nil }
+
+	cmd := getPruneCmd(&rootConfigT{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--folder", "INBOX", "--max-age", "1", "--json"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestPruneCommandNoMaxAge(t *testing.T) {
+	mockOps := mockCoreOps{}
+	// Nothing will be called without a maximum age.
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		t.Log("lock function should not be called")
+		t.FailNow()
+		return nil, fmt.Errorf("this should not be called")
+	}
+
+	cmd := getPruneCmd(&rootConfigT{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--folder", "INBOX"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "positive maximum age")
+}
+
+func TestPruneCommandCannotGetLock(t *testing.T) {
+	mockOps := mockCoreOps{}
+	// Nothing will be called because the lock cannot be acqired.
+	defer mockOps.AssertExpectations(t)
+
+	mockLock := func(_ string, _ time.Duration) (func(), error) {
+		return func() {}, fmt.Errorf("some locking error")
+	}
+
+	cmd := getPruneCmd(&rootConfigT{}, &mockOps, mockLock)
+	cmd.SetArgs([]string{"--folder", "INBOX", "--max-age", "1"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some locking error")
+}
+
+func TestPrintPruneResults(t *testing.T) {
+	results := []core.PruneResult{
+		{Folder: "INBOX", Pruned: 12, Kept: 3},
+		{Folder: "Sent", Pruned: 1, Kept: 0},
+	}
+	buf := bytes.Buffer{}
+
+	err := printPruneResults(&buf, results)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"FOLDER  PRUNED  KEPT\n" +
+		"INBOX   12      3\n" +
+		"Sent    1       0\n" +
+		"TOTAL   13      3\n"
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,146 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const shortReconcileHelp = "Compare locally stored maildirs with the emails on the server."
+
+type reconcileConfigT struct {
+	path       string
+	folders    []string
+	list       bool
+	jsonOutput bool
+}
+
+// Print reconcile results as a table with one row per folder. If list is set, one row per
+// differing email is printed instead of the counts.
+func printReconcileResults(writer io.Writer, results []core.ReconcileResult, list bool) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	if list {
+		fmt.Fprintln(table, "FOLDER\tUID\tMISSING")
+		for _, result := range results {
+			for _, uid := range result.MissingLocally {
+				fmt.Fprintf(table, "%s\t%s\tlocally\n", result.Folder, uid)
+			}
+			for _, uid := range result.MissingRemotely {
+				fmt.Fprintf(table, "%s\t%s\tremotely\n", result.Folder, uid)
+			}
+		}
+		return table.Flush()
+	}
+	fmt.Fprintln(table, "FOLDER\tMISSING LOCALLY\tMISSING REMOTELY")
+	for _, result := range results {
+		fmt.Fprintf(
+			table, "%s\t%d\t%d\n",
+			result.Folder, len(result.MissingLocally), len(result.MissingRemotely),
+		)
+	}
+	return table.Flush()
+}
+
+func getReconcileCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.Command {
+	reconcileConf := reconcileConfigT{}
+	cmd := &cobra.Command{
+		Use: "reconcile",
+		Long: shortReconcileHelp + "\n\n" +
+			"Emails the server has but that have not been downloaded are reported as\n" +
+			"missing locally. Emails that have been downloaded but that are no longer on the\n" +
+			"server are reported as missing remotely. UIDs are printed as\n" +
+			"<UIDVALIDITY>/<UID>. No emails are downloaded and nothing is changed.\n\n" +
+			typicalFlowHelp,
+		Short: shortReconcileHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			// Allow insecure auth for local server for testing.
+			insecure := rootConf.server == localhost
+			cfg := core.IMAPConfig{
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+				SubscribedOnly:    rootConf.subscribedOnly,
+				SharedFolders:     rootConf.sharedFolders,
+			}
+			results, err := ops.reconcileFolders(
+				cfg, reconcileConf.folders, reconcileConf.path,
+			)
+			if err != nil {
+				return err
+			}
+			if reconcileConf.jsonOutput {
+				return printJSON(os.Stdout, results)
+			}
+			return printReconcileResults(os.Stdout, results, reconcileConf.list)
+		},
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			err := initCredentials(rootConf, keyring, rootConf.verbose)
+			if credentialsNotFound(err) {
+				err = fmt.Errorf("%s\n\n%s", err.Error(), loginCmdUse(rootConf, os.Args))
+			}
+			return err
+		},
+	}
+	initRootFlags(cmd, rootConf)
+	initFolderListFlags(cmd, rootConf)
+
+	flags := cmd.Flags()
+	flags.StringVar(
+		&reconcileConf.path, "path", "", "the local path to your maildir's parent dir",
+	)
+	flags.StringSliceVarP(
+		&reconcileConf.folders,
+		"folder", "f", []string{"_ALL_"},
+		"a folder spec specifying something to reconcile, same as for the download\n"+
+			"command, all folders by default",
+	)
+	flags.BoolVar(
+		&reconcileConf.list, "list", false,
+		"print the UID of every differing email instead of only the counts",
+	)
+	flags.BoolVar(
+		&reconcileConf.jsonOutput, "json", false,
+		"print results as a JSON array of objects instead of a table",
+	)
+
+	return cmd
+}
+
+var reconcileCmd = getReconcileCmd(&rootConfig, defaultKeyring, &corer{})
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+}
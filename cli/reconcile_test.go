@@ -0,0 +1,88 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReconcileCommand(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("reconcileFolders", mock.Anything, []string{"INBOX"}, "some/path").
+		Return([]core.ReconcileResult{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getReconcileCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--folder", "INBOX", "--path=some/path", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestReconcileCommandSuccess(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("reconcileFolders", mock.Anything, []string{"_ALL_"}, "").
+		Return([]core.ReconcileResult{{Folder: "INBOX", MissingLocally: []string{"42/1"}}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getReconcileCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestPrintReconcileResults(t *testing.T) {
+	results := []core.ReconcileResult{
+		{Folder: "INBOX", MissingLocally: []string{"42/1", "42/5"}},
+		{Folder: "Sent", MissingRemotely: []string{"7/3"}},
+	}
+
+	buf := bytes.Buffer{}
+	err := printReconcileResults(&buf, results, false)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"FOLDER  MISSING LOCALLY  MISSING REMOTELY\n" +
+		"INBOX   2                0\n" +
+		"Sent    0                1\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf = bytes.Buffer{}
+	err = printReconcileResults(&buf, results, true)
+
+	assert.NoError(t, err)
+	expected = "" +
+		"FOLDER  UID   MISSING\n" +
+		"INBOX   42/1  locally\n" +
+		"INBOX   42/5  locally\n" +
+		"Sent    7/3   remotely\n"
+	assert.Equal(t, expected, buf.String())
+}
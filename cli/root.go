@@ -18,11 +18,19 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+
 	"github.com/spf13/cobra"
 )
 
 const (
 	defaultPort = 993
+	// The path that makes the protocol log go to stderr.
+	stdioPath = "-"
+	// The protocol log holds private data, which is why only the owner may read it.
+	protocolLogPerm = 0600
 )
 
 var rootConfig rootConfigT
@@ -35,6 +43,23 @@ type rootConfigT struct {
 	verbose  bool
 	// Whether to disable use of the system keyring.
 	noKeyring bool
+	// Whether to read the password from stdin instead of the environment or the keyring.
+	passwordStdin bool
+	// The identity to act as after logging in as username, if different.
+	authzID string
+	// The SASL mechanism to authenticate with instead of the LOGIN command, if any.
+	authMethod      string
+	fallbackServers []string
+	// The host name to verify the server's certificate against, if different from server.
+	tlsServerName string
+	// Whether to disable resuming TLS sessions of earlier connections.
+	noTLSSessionCache bool
+	// Where to log the raw protocol exchange, if anywhere.
+	protocolLog protocolLogFlag
+	// Whether to consider only folders the user is subscribed to.
+	subscribedOnly bool
+	// Whether to also consider folders in other users' and shared namespaces.
+	sharedFolders bool
 }
 
 const (
@@ -62,6 +87,36 @@ const (
 		"\"my.example@gmail.com\" and password \"example\" after running the \"serve\" command.\n"
 )
 
+// Type protocolLogFlag is a flag naming the file to log the raw protocol exchange to. The file is
+// opened for appending when the flag is set and stays open until the program exits.
+type protocolLogFlag struct {
+	path   string
+	writer io.Writer
+}
+
+func (f *protocolLogFlag) String() string {
+	return f.path
+}
+
+func (f *protocolLogFlag) Set(path string) error {
+	if path == stdioPath {
+		f.path, f.writer = path, os.Stderr
+		return nil
+	}
+	file, err := os.OpenFile( // nolint: gosec
+		filepath.Clean(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, protocolLogPerm,
+	)
+	if err != nil {
+		return err
+	}
+	f.path, f.writer = path, file
+	return nil
+}
+
+func (f *protocolLogFlag) Type() string {
+	return "file"
+}
+
 func getRootCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "go-imapgrab",
@@ -81,7 +136,51 @@ func initRootFlags(rootCmd *cobra.Command, rootConf *rootConfigT) {
 
 	flags.StringVarP(&rootConf.server, "server", "s", "", "address of imap server")
 	flags.IntVarP(&rootConf.port, "port", "p", defaultPort, "login port for imap server")
+	flags.StringSliceVar(
+		&rootConf.fallbackServers, "fallback-server", []string{},
+		"address of a server to connect to if the previous one is unreachable, can be\n"+
+			"given several times, add \":<PORT>\" to use a port other than --port",
+	)
 	flags.StringVarP(&rootConf.username, "user", "u", "", "login user name")
+	flags.StringVar(
+		&rootConf.authzID, "authzid", "",
+		"user to act as after logging in, e.g. for shared mailboxes (requires AUTH=PLAIN)",
+	)
+	flags.StringVar(
+		&rootConf.authMethod, "auth-method", "",
+		"SASL mechanism to log in with instead of LOGIN, SCRAM-SHA-1 or SCRAM-SHA-256",
+	)
+	flags.StringVar(
+		&rootConf.tlsServerName, "tls-server-name", "",
+		"host name to verify the server's certificate against, e.g. when connecting via IP",
+	)
+	flags.BoolVar(
+		&rootConf.noTLSSessionCache, "no-tls-session-cache", false,
+		"always perform a full TLS handshake instead of resuming earlier sessions",
+	)
+	flags.Var(
+		&rootConf.protocolLog, "protocol-log",
+		"append the raw protocol exchange with credentials redacted to this file, use \"-\" for\n"+
+			"stderr, for debugging servers only since the log contains your emails",
+	)
 	flags.BoolVarP(&rootConf.verbose, "verbose", "v", false, "verbose output")
 	flags.BoolVarP(&rootConf.noKeyring, "no-keyring", "k", false, "do not use the system keyring")
+	flags.BoolVar(
+		&rootConf.passwordStdin, "password-stdin", false,
+		"read the password from stdin, prompting without echo on a terminal and reading\n"+
+			"a single line otherwise, the password is not added to the keyring",
+	)
+}
+
+// Add flags to commands that work with the list of folders.
+func initFolderListFlags(cmd *cobra.Command, rootConf *rootConfigT) {
+	cmd.Flags().BoolVar(
+		&rootConf.subscribedOnly, "subscribed-only", false,
+		"consider only folders you are subscribed to instead of all folders",
+	)
+	cmd.Flags().BoolVar(
+		&rootConf.sharedFolders, "shared-folders", false,
+		"also consider folders in other users' and shared namespaces, which some servers\n"+
+			"do not report otherwise",
+	)
 }
@@ -18,6 +18,8 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,3 +30,41 @@ func TestRootCommand(t *testing.T) {
 	err := rootCmd.Execute()
 	assert.NoError(t, err)
 }
+
+func TestProtocolLogFlagStderr(t *testing.T) {
+	flag := protocolLogFlag{}
+
+	err := flag.Set("-")
+
+	assert.NoError(t, err)
+	assert.Equal(t, os.Stderr, flag.writer)
+	assert.Equal(t, "-", flag.String())
+	assert.Equal(t, "file", flag.Type())
+}
+
+func TestProtocolLogFlagFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protocol.log")
+	err := os.WriteFile(path, []byte("earlier\n"), 0600)
+	assert.NoError(t, err)
+	flag := protocolLogFlag{}
+
+	err = flag.Set(path)
+	assert.NoError(t, err)
+	_, err = flag.writer.Write([]byte("later\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, flag.writer.(*os.File).Close())
+
+	content, err := os.ReadFile(path) // nolint: gosec
+	assert.NoError(t, err)
+	assert.Equal(t, "earlier\nlater\n", string(content))
+	assert.Equal(t, path, flag.String())
+}
+
+func TestProtocolLogFlagError(t *testing.T) {
+	flag := protocolLogFlag{}
+
+	err := flag.Set(filepath.Join(t.TempDir(), "missing", "protocol.log"))
+
+	assert.Error(t, err)
+	assert.Nil(t, flag.writer)
+}
@@ -0,0 +1,166 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shortSearchHelp  = "Print the UIDs of emails on the server matching a search."
+	searchDateFormat = "2006-01-02"
+)
+
+type searchConfigT struct {
+	folders    []string
+	from       string
+	subject    string
+	since      string
+	unseen     bool
+	jsonOutput bool
+}
+
+// Build the search criteria from the configured options. Emails have to match all of them.
+func (c searchConfigT) criteria() (*imap.SearchCriteria, error) {
+	criteria := imap.NewSearchCriteria()
+	if c.from != "" {
+		criteria.Header.Add("From", c.from)
+	}
+	if c.subject != "" {
+		criteria.Header.Add("Subject", c.subject)
+	}
+	if c.since != "" {
+		since, err := time.Parse(searchDateFormat, c.since)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse date '%s', use YYYY-MM-DD", c.since)
+		}
+		criteria.Since = since
+	}
+	if c.unseen {
+		criteria.WithoutFlags = []string{imap.SeenFlag}
+	}
+	return criteria, nil
+}
+
+// Print search results as a table with one row per matching email.
+func printSearchResults(writer io.Writer, results []core.FolderSearchResult) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(table, "FOLDER\tUID")
+	for _, result := range results {
+		for _, uid := range result.UIDs {
+			fmt.Fprintf(table, "%s\t%s\n", result.Name, uid)
+		}
+	}
+	return table.Flush()
+}
+
+func getSearchCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.Command {
+	searchConf := searchConfigT{}
+	cmd := &cobra.Command{
+		Use: "search",
+		Long: shortSearchHelp + "\n\n" +
+			"The search is performed by the server and no emails are downloaded. UIDs are\n" +
+			"printed as <UIDVALIDITY>/<UID>, which the --uid-file flag of the download\n" +
+			"command understands.\n\n" + typicalFlowHelp,
+		Short: shortSearchHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			criteria, err := searchConf.criteria()
+			if err != nil {
+				return err
+			}
+			// Allow insecure auth for local server for testing.
+			insecure := rootConf.server == localhost
+			cfg := core.IMAPConfig{
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+				SubscribedOnly:    rootConf.subscribedOnly,
+				SharedFolders:     rootConf.sharedFolders,
+			}
+			results, err := ops.searchFolders(cfg, searchConf.folders, criteria)
+			if err != nil {
+				return err
+			}
+			if searchConf.jsonOutput {
+				return printJSON(os.Stdout, results)
+			}
+			return printSearchResults(os.Stdout, results)
+		},
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			err := initCredentials(rootConf, keyring, rootConf.verbose)
+			if credentialsNotFound(err) {
+				err = fmt.Errorf("%s\n\n%s", err.Error(), loginCmdUse(rootConf, os.Args))
+			}
+			return err
+		},
+	}
+	initRootFlags(cmd, rootConf)
+	initFolderListFlags(cmd, rootConf)
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(
+		&searchConf.folders,
+		"folder", "f", []string{"_ALL_"},
+		"a folder spec specifying something to search, same as for the download\n"+
+			"command, all folders by default",
+	)
+	flags.StringVar(
+		&searchConf.from, "from", "", "match emails whose From header contains this string",
+	)
+	flags.StringVar(
+		&searchConf.subject, "subject", "",
+		"match emails whose Subject header contains this string",
+	)
+	flags.StringVar(
+		&searchConf.since, "since", "",
+		"match emails received on or after this date, given as YYYY-MM-DD",
+	)
+	flags.BoolVar(&searchConf.unseen, "unseen", false, "match emails not marked as seen")
+	flags.BoolVar(
+		&searchConf.jsonOutput, "json", false,
+		"print results as a JSON array of objects instead of a table",
+	)
+
+	return cmd
+}
+
+var searchCmd = getSearchCmd(&rootConfig, defaultKeyring, &corer{})
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+}
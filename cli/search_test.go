@@ -0,0 +1,102 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSearchCommand(t *testing.T) {
+	expected := imap.NewSearchCriteria()
+	expected.Header.Add("From", "alice@example.com")
+	expected.Header.Add("Subject", "invoice")
+	expected.Since = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	expected.WithoutFlags = []string{imap.SeenFlag}
+
+	mockOps := mockCoreOps{}
+	mockOps.On("searchFolders", mock.Anything, []string{"INBOX"}, expected).
+		Return([]core.FolderSearchResult{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getSearchCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{
+		"--folder", "INBOX", "--from=alice@example.com", "--subject=invoice",
+		"--since=2024-03-01", "--unseen", "--no-keyring",
+	})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestSearchCommandSuccess(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("searchFolders", mock.Anything, []string{"_ALL_"}, imap.NewSearchCriteria()).
+		Return([]core.FolderSearchResult{{Name: "INBOX", UIDs: []string{"42/1"}}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getSearchCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestSearchCommandInvalidDate(t *testing.T) {
+	mockOps := mockCoreOps{}
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getSearchCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--since=01.03.2024", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "cannot parse date")
+}
+
+func TestPrintSearchResults(t *testing.T) {
+	results := []core.FolderSearchResult{
+		{Name: "INBOX", UIDs: []string{"42/1", "42/5"}}, {Name: "Sent", UIDs: []string{"7/3"}},
+	}
+	buf := bytes.Buffer{}
+
+	err := printSearchResults(&buf, results)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"FOLDER  UID\n" +
+		"INBOX   42/1\n" +
+		"INBOX   42/5\n" +
+		"Sent    7/3\n"
+	assert.Equal(t, expected, buf.String())
+}
@@ -52,11 +52,15 @@ func getServeCmd(
 			// Allow insecure auth for local server for testing.
 			insecure := rootConf.server == localhost
 			cfg := core.IMAPConfig{
-				Server:   rootConf.server,
-				Port:     rootConf.port,
-				User:     rootConf.username,
-				Password: rootConf.password,
-				Insecure: insecure,
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
 			}
 			lockfile := filepath.Join(serveConf.path, lockfileName)
 			lockTimeout := time.Duration(serveConf.timeoutSeconds) * time.Second
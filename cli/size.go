@@ -0,0 +1,90 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const shortSizeHelp = "Report the storage taken up by the locally stored maildir of every folder."
+
+type sizeConfigT struct {
+	path       string
+	jsonOutput bool
+}
+
+// Print folder sizes as a table with one row per folder and a final row with the total.
+func printFolderSizes(writer io.Writer, sizes []core.FolderSize) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(table, "FOLDER\tFILES\tBYTES")
+	var files int
+	var bytes int64
+	for _, size := range sizes {
+		fmt.Fprintf(table, "%s\t%d\t%d\n", size.Folder, size.Files, size.Bytes)
+		files += size.Files
+		bytes += size.Bytes
+	}
+	fmt.Fprintf(table, "TOTAL\t%d\t%d\n", files, bytes)
+	return table.Flush()
+}
+
+func getSizeCmd(ops coreOps) *cobra.Command {
+	sizeConf := sizeConfigT{}
+	cmd := &cobra.Command{
+		Use: "size",
+		Long: shortSizeHelp + "\n\n" +
+			"Every maildir below the given path is reported with the number of files it\n" +
+			"consists of and the number of bytes they take up, e.g. to find folders worth\n" +
+			"pruning. The server is not contacted and no credentials are needed.",
+		Short: shortSizeHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if !exists(sizeConf.path) {
+				return fmt.Errorf("path %s does not exist", sizeConf.path)
+			}
+			sizes, err := ops.getMaildirSizes(sizeConf.path)
+			if err != nil {
+				return err
+			}
+			if sizeConf.jsonOutput {
+				return printJSON(os.Stdout, sizes)
+			}
+			return printFolderSizes(os.Stdout, sizes)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&sizeConf.path, "path", "", "the local path to your maildir's parent dir")
+	flags.BoolVar(
+		&sizeConf.jsonOutput, "json", false,
+		"print folder sizes as a JSON array of objects instead of a table",
+	)
+
+	return cmd
+}
+
+var sizeCmd = getSizeCmd(&corer{})
+
+func init() {
+	rootCmd.AddCommand(sizeCmd)
+}
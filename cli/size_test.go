@@ -0,0 +1,86 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeCommand(t *testing.T) {
+	path := t.TempDir()
+	mockOps := mockCoreOps{}
+	mockOps.On("getMaildirSizes", path).
+		Return([]core.FolderSize{{Folder: "INBOX", Files: 2, Bytes: 42}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	for _, args := range [][]string{{"--path", path}, {"--path", path, "--json"}} {
+		cmd := getSizeCmd(&mockOps)
+		cmd.SetArgs(args)
+
+		err := cmd.Execute()
+		assert.NoError(t, err)
+	}
+}
+
+func TestSizeCommandError(t *testing.T) {
+	path := t.TempDir()
+	mockOps := mockCoreOps{}
+	mockOps.On("getMaildirSizes", path).Return([]core.FolderSize{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	cmd := getSizeCmd(&mockOps)
+	cmd.SetArgs([]string{"--path", path})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestSizeCommandMissingPath(t *testing.T) {
+	mockOps := mockCoreOps{}
+	// Nothing will be called for a path that does not exist.
+	defer mockOps.AssertExpectations(t)
+
+	cmd := getSizeCmd(&mockOps)
+	cmd.SetArgs([]string{"--path", "does/not/exist"})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestPrintFolderSizes(t *testing.T) {
+	sizes := []core.FolderSize{
+		{Folder: "INBOX", Files: 12, Bytes: 4096},
+		{Folder: "Sent", Files: 1, Bytes: 100},
+	}
+	buf := bytes.Buffer{}
+
+	err := printFolderSizes(&buf, sizes)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"FOLDER  FILES  BYTES\n" +
+		"INBOX   12     4096\n" +
+		"Sent    1      100\n" +
+		"TOTAL   13     4196\n"
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,128 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/spf13/cobra"
+)
+
+const shortStatusHelp = "Print a summary of all folders in your inbox."
+
+const (
+	defaultStatusThreads = 4
+	tabPadding           = 2
+)
+
+type statusConfigT struct {
+	threads    int
+	jsonOutput bool
+}
+
+// Print folder summaries as a table with one row per folder and a final row with the totals.
+func printFolderSummaries(writer io.Writer, summaries []core.FolderSummary) error {
+	table := tabwriter.NewWriter(writer, 0, 0, tabPadding, ' ', 0)
+	printRow := func(summary core.FolderSummary) {
+		fmt.Fprintf(
+			table, "%s\t%d\t%d\t%d\n",
+			summary.Name, summary.Messages, summary.Unseen, summary.Size,
+		)
+	}
+	fmt.Fprintln(table, "FOLDER\tMESSAGES\tUNSEEN\tSIZE (BYTES)")
+	total := core.FolderSummary{Name: "TOTAL"}
+	for _, summary := range summaries {
+		printRow(summary)
+		total.Messages += summary.Messages
+		total.Unseen += summary.Unseen
+		total.Size += summary.Size
+	}
+	printRow(total)
+	return table.Flush()
+}
+
+func getStatusCmd(rootConf *rootConfigT, keyring keyringOps, ops coreOps) *cobra.Command {
+	statusConf := statusConfigT{}
+	cmd := &cobra.Command{
+		Use:   "status",
+		Long:  shortStatusHelp + "\n\n" + typicalFlowHelp,
+		Short: shortStatusHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			// Allow insecure auth for local server for testing.
+			insecure := rootConf.server == localhost
+			cfg := core.IMAPConfig{
+				Server:     rootConf.server,
+				Port:       rootConf.port,
+				User:       rootConf.username,
+				Password:   rootConf.password,
+				Insecure:   insecure,
+				AuthzID:    rootConf.authzID,
+				AuthMethod: rootConf.authMethod,
+
+				FallbackServers: rootConf.fallbackServers,
+
+				TLSServerName:     rootConf.tlsServerName,
+				NoTLSSessionCache: rootConf.noTLSSessionCache,
+				ProtocolLog:       rootConf.protocolLog.writer,
+				SubscribedOnly:    rootConf.subscribedOnly,
+				SharedFolders:     rootConf.sharedFolders,
+			}
+			summaries, err := ops.getFolderSummaries(cfg, statusConf.threads)
+			if err != nil {
+				return err
+			}
+			if statusConf.jsonOutput {
+				return printJSON(os.Stdout, summaries)
+			}
+			return printFolderSummaries(os.Stdout, summaries)
+		},
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			core.SetVerboseLogs(rootConf.verbose)
+			err := initCredentials(rootConf, keyring, rootConf.verbose)
+			if credentialsNotFound(err) {
+				err = fmt.Errorf("%s\n\n%s", err.Error(), loginCmdUse(rootConf, os.Args))
+			}
+			return err
+		},
+	}
+	initRootFlags(cmd, rootConf)
+	initFolderListFlags(cmd, rootConf)
+
+	flags := cmd.Flags()
+	flags.IntVarP(
+		&statusConf.threads, "threads", "t", defaultStatusThreads,
+		"number of connections to use for querying folders",
+	)
+	flags.BoolVar(
+		&statusConf.jsonOutput, "json", false,
+		"print summaries as a JSON array of objects instead of a table",
+	)
+
+	return cmd
+}
+
+var statusCmd = getStatusCmd(&rootConfig, defaultKeyring, &corer{})
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,111 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/razziel89/go-imapgrab/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStatusCommand(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getFolderSummaries", mock.Anything, 3).
+		Return([]core.FolderSummary{}, fmt.Errorf("some error"))
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	mk := &mockKeyring{}
+
+	rootConf := rootConfigT{}
+	cmd := getStatusCmd(&rootConf, mk, &mockOps)
+	cmd.SetArgs([]string{"--threads", "3"})
+	rootConf.noKeyring = true
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestStatusCommandSuccess(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getFolderSummaries", mock.Anything, defaultStatusThreads).
+		Return([]core.FolderSummary{{Name: "INBOX"}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	mk := &mockKeyring{}
+
+	rootConf := rootConfigT{}
+	cmd := getStatusCmd(&rootConf, mk, &mockOps)
+	cmd.SetArgs([]string{})
+	rootConf.noKeyring = true
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestStatusCommandJSON(t *testing.T) {
+	mockOps := mockCoreOps{}
+	mockOps.On("getFolderSummaries", mock.Anything, defaultStatusThreads).
+		Return([]core.FolderSummary{{Name: "INBOX"}}, nil)
+	defer mockOps.AssertExpectations(t)
+
+	t.Setenv("IGRAB_PASSWORD", "some password")
+
+	rootConf := rootConfigT{}
+	cmd := getStatusCmd(&rootConf, &mockKeyring{}, &mockOps)
+	cmd.SetArgs([]string{"--json", "--no-keyring"})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestFolderSummariesJSON(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	err := printJSON(&buf, []core.FolderSummary{{Name: "INBOX", Messages: 2, Unseen: 1, Size: 3}})
+
+	assert.NoError(t, err)
+	expected := "[\n  {\n    \"name\": \"INBOX\",\n    \"messages\": 2,\n" +
+		"    \"unseen\": 1,\n    \"size\": 3\n  }\n]\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestPrintFolderSummaries(t *testing.T) {
+	summaries := []core.FolderSummary{
+		{Name: "INBOX", Messages: 12, Unseen: 2, Size: 34567},
+		{Name: "Sent", Messages: 3, Unseen: 0, Size: 890},
+	}
+	buf := bytes.Buffer{}
+
+	err := printFolderSummaries(&buf, summaries)
+
+	assert.NoError(t, err)
+	expected := "" +
+		"FOLDER  MESSAGES  UNSEEN  SIZE (BYTES)\n" +
+		"INBOX   12        2       34567\n" +
+		"Sent    3         0       890\n" +
+		"TOTAL   15        2       35457\n"
+	assert.Equal(t, expected, buf.String())
+}
@@ -292,7 +292,8 @@ func TestSystemDownloadSuccess(t *testing.T) {
 	actualFiles, actualDirs := scanDirReplacingEmails(t, maildir)
 
 	expectedFiles := []string{
-		".go-imapgrab.lock", "INBOX/new/email.0", "oldmail-127.0.0.1-30218-username-INBOX",
+		".go-imapgrab.lock", "INBOX/imapgrab-uidlist", "INBOX/new/email.0",
+		"oldmail-127.0.0.1-30218-username-INBOX", "oldmail-127.0.0.1-30218-username-INBOX.lock",
 	}
 	assert.Equal(t, expectedFiles, actualFiles)
 
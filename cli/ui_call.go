@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"sort"
 	"strings"
 )
 
@@ -99,6 +100,24 @@ func newRunSelfConf(
 		for _, folder := range downloadConf.folders {
 			args = append(args, []string{"--folder", folder}...)
 		}
+		// Sort folders so that the arguments do not depend on the order of iteration.
+		threadFolders := make([]string, 0, len(downloadConf.folderThreads))
+		for folder := range downloadConf.folderThreads {
+			threadFolders = append(threadFolders, folder)
+		}
+		sort.Strings(threadFolders)
+		for _, folder := range threadFolders {
+			args = append(args, []string{
+				"--folder-threads", fmt.Sprintf("%s=%d", folder, downloadConf.folderThreads[folder]),
+			}...)
+		}
+		if downloadConf.maxBandwidth > 0 {
+			args = append(args, "--max-bytes-per-second", fmt.Sprint(downloadConf.maxBandwidth))
+		}
+		if downloadConf.maxConnections > 0 {
+			args = append(args, "--max-connections", fmt.Sprint(downloadConf.maxConnections))
+			args = append(args, "--connection-slots", downloadConf.connectionSlots)
+		}
 	case "login": //nolint:goconst
 		// When calling login, the password has to be provided via stdin for now.
 		stdin = rootConf.password
@@ -110,7 +110,7 @@ func TestNewRunSelfConf(t *testing.T) {
 	testCases := []test{
 		{"list", 7, ""},
 		{"serve", 11, ""},
-		{"download", 13, ""},
+		{"download", 15, ""},
 		{"login", 8, "password"},
 	}
 
@@ -120,7 +120,9 @@ func TestNewRunSelfConf(t *testing.T) {
 			"some-path",
 			tc.cmd,
 			rootConfigT{password: "password", verbose: tc.cmd == "login"},
-			downloadConfigT{folders: []string{"_ALL_", "-_Gmail_"}},
+			downloadConfigT{
+				folders: []string{"_ALL_", "-_Gmail_"}, folderThreads: map[string]int{"INBOX": 2},
+			},
 			serveConfigT{},
 		)
 
@@ -130,3 +132,20 @@ func TestNewRunSelfConf(t *testing.T) {
 		assert.Equal(t, cfg.env, []string{"IGRAB_PASSWORD=password"})
 	}
 }
+
+func TestNewRunSelfConfDownloadLimits(t *testing.T) {
+	cfg, err := newRunSelfConf(
+		"some-path", "download", rootConfigT{},
+		downloadConfigT{maxBandwidth: 500, maxConnections: 2, connectionSlots: "slots"},
+		serveConfigT{},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"--max-bytes-per-second", "500", "--max-connections", "2", "--connection-slots", "slots",
+		},
+		cfg.args[len(cfg.args)-6:],
+	)
+}
@@ -26,11 +26,22 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-const yamlIndentSpaces = 2
+const (
+	yamlIndentSpaces = 2
+	// The directory below the config's path that the connection limit is shared via.
+	connectionSlotsDir = ".connection-slots"
+)
 
 type uiConfigFile struct {
-	Path      string
-	Mailboxes []*uiConfFileMailbox
+	Path string
+	// Maxbytespersecond optionally limits the bandwidth that all mailboxes downloaded at the same
+	// time use together. It is shared evenly among them.
+	Maxbytespersecond int `yaml:",omitempty"`
+	// Maxconnections optionally limits the number of connections that all mailboxes downloaded at
+	// the same time have open together, including those for folder threads. It is a single limit
+	// shared via lock files that every connection acquires one of.
+	Maxconnections int `yaml:",omitempty"`
+	Mailboxes      []*uiConfFileMailbox
 
 	filePath string
 }
@@ -42,6 +53,9 @@ type uiConfFileMailbox struct {
 	Port       int
 	Serverport int
 	Folders    []string
+	// Folderthreads optionally maps folders to the number of connections used to retrieve their
+	// emails in parallel.
+	Folderthreads map[string]int `yaml:",omitempty"`
 	// Keep this member internal so that it cannot be serialised or deserialised. It shall never be
 	// written to a file but always retrieved from the keyring, if present.
 	password string
@@ -62,6 +76,7 @@ func (mbCfg *uiConfFileMailbox) asRootConf(verbose bool) rootConfigT {
 func (mbCfg *uiConfFileMailbox) asDownloadConf(rootPath string) downloadConfigT {
 	return downloadConfigT{
 		folders:        mbCfg.Folders,
+		folderThreads:  mbCfg.Folderthreads,
 		path:           filepath.Join(rootPath, mbCfg.Name),
 		threads:        0,
 		timeoutSeconds: defaultTimeoutSeconds,
@@ -179,6 +194,19 @@ func (ui *uiConfigFile) asDownloadConf(mailboxName string) *downloadConfigT {
 	return &result
 }
 
+// Share the limits that apply to all mailboxes downloaded at the same time among them. The
+// bandwidth is split evenly, whereas all of them acquire connections from the same slots.
+func (ui *uiConfigFile) shareLimits(download *downloadConfigT, mailboxes int) {
+	mailboxes = max(mailboxes, 1)
+	if ui.Maxbytespersecond > 0 {
+		download.maxBandwidth = max(ui.Maxbytespersecond/mailboxes, 1)
+	}
+	if ui.Maxconnections > 0 {
+		download.maxConnections = ui.Maxconnections
+		download.connectionSlots = filepath.Join(ui.Path, connectionSlotsDir)
+	}
+}
+
 func (ui *uiConfigFile) asServeConf(mailboxName string) *serveConfigT {
 	box := ui.boxByName(mailboxName)
 	if box == nil {
@@ -43,13 +43,14 @@ func setUpUIConfigFile(path string) uiConfigFile {
 				password:   "I am really secret",
 			},
 			{
-				Name:       "box",
-				Server:     "other.server.com",
-				User:       "other@user.com",
-				Port:       993,
-				Serverport: 30124,
-				Folders:    []string{"_ALL_"},
-				password:   "I am very secret",
+				Name:          "box",
+				Server:        "other.server.com",
+				User:          "other@user.com",
+				Port:          993,
+				Serverport:    30124,
+				Folders:       []string{"_ALL_"},
+				Folderthreads: map[string]int{"INBOX": 4},
+				password:      "I am very secret",
 			},
 		},
 	}
@@ -74,7 +75,9 @@ func exampleConfigForTest(path string) string {
 		"    port: 993\n" +
 		"    serverport: 30124\n" +
 		"    folders:\n" +
-		"      - _ALL_\n"
+		"      - _ALL_\n" +
+		"    folderthreads:\n" +
+		"      INBOX: 4\n"
 }
 
 func TestBoxByName(t *testing.T) {
@@ -102,6 +105,7 @@ func TestConvertedBoxByName(t *testing.T) {
 	download := &downloadConfigT{
 		path:           filepath.Join(path, "download", "box"),
 		folders:        []string{"_ALL_"},
+		folderThreads:  map[string]int{"INBOX": 4},
 		threads:        0,
 		timeoutSeconds: 1,
 	}
@@ -122,6 +126,33 @@ func TestConvertedBoxByName(t *testing.T) {
 	assert.Nil(t, cfg.asServeConf("unknown"))
 }
 
+func TestShareLimits(t *testing.T) {
+	cfg := uiConfigFile{Path: "/some/path", Maxbytespersecond: 1000, Maxconnections: 5}
+	slots := filepath.Join("/some/path", connectionSlotsDir)
+
+	download := downloadConfigT{}
+	cfg.shareLimits(&download, 2)
+	assert.Equal(
+		t,
+		downloadConfigT{maxBandwidth: 500, maxConnections: 5, connectionSlots: slots},
+		download,
+	)
+
+	// The connection limit is not split but shared via the same slots.
+	download = downloadConfigT{}
+	cfg.shareLimits(&download, 10)
+	assert.Equal(
+		t,
+		downloadConfigT{maxBandwidth: 100, maxConnections: 5, connectionSlots: slots},
+		download,
+	)
+
+	// Nothing is limited by default.
+	download = downloadConfigT{}
+	(&uiConfigFile{}).shareLimits(&download, 2)
+	assert.Equal(t, downloadConfigT{}, download)
+}
+
 func TestRemoveMailbox(t *testing.T) {
 	path := t.TempDir()
 
@@ -255,6 +255,7 @@ func getGenericUIButtonHandler(
 				log.Printf("skipping %s for unknown mailbox %s", actionName, box)
 				continue
 			}
+			ui.config.shareLimits(download, len(selectedBoxes))
 
 			args, err := newRunSelfConf(ui.selfExe, actionName, *root, *download, *serve)
 			if err != nil {
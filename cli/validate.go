@@ -0,0 +1,211 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	shortValidateHelp = "Check the config file of the UI for problems without connecting anywhere."
+	maxPort           = 65535
+)
+
+type validateConfigT struct {
+	path       string
+	jsonOutput bool
+}
+
+// Type configProblem describes a problem with a config file. The path identifies the offending
+// entry, e.g. "mailboxes[1].port", or the line for problems with the file's structure.
+type configProblem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Load a config file and check it for problems. All problems are reported, not only the first one.
+// Entries unknown to the config file's schema are problems, too. An error is returned only if the
+// file cannot be read.
+func validateConfigFile(path string) ([]configProblem, error) {
+	content, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	var config uiConfigFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	problems := []configProblem{}
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+	case errors.As(err, &typeErr):
+		// The remainder of the file has still been decoded and can be checked.
+		for _, msg := range typeErr.Errors {
+			location, detail, found := strings.Cut(msg, ": ")
+			if !found {
+				location, detail = "", msg
+			}
+			problems = append(problems, configProblem{Path: location, Message: detail})
+		}
+	default:
+		return append(problems, configProblem{Message: err.Error()}), nil
+	}
+	return append(problems, config.validate()...), nil
+}
+
+// Check the entries of a config file for problems.
+func (ui *uiConfigFile) validate() []configProblem {
+	problems := []configProblem{}
+	add := func(path, format string, args ...interface{}) {
+		problems = append(problems, configProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if ui.Maxbytespersecond < 0 {
+		add("maxbytespersecond", "must not be negative but is %d", ui.Maxbytespersecond)
+	}
+	if ui.Maxconnections < 0 {
+		add("maxconnections", "must not be negative but is %d", ui.Maxconnections)
+	}
+	names := map[string]int{}
+	serverports := map[int]int{}
+	for idx, mb := range ui.Mailboxes {
+		path := fmt.Sprintf("mailboxes[%d]", idx)
+		if mb == nil {
+			add(path, "mailbox is empty")
+			continue
+		}
+		for _, field := range []struct{ name, value string }{
+			{"name", mb.Name}, {"server", mb.Server}, {"user", mb.User},
+		} {
+			if strings.TrimSpace(field.value) == "" {
+				add(path+"."+field.name, "required but empty")
+			}
+		}
+		if other, found := names[mb.Name]; found && mb.Name != "" {
+			add(path+".name", "'%s' already used by mailboxes[%d]", mb.Name, other)
+		} else {
+			names[mb.Name] = idx
+		}
+		if mb.Port < 1 || mb.Port > maxPort {
+			add(path+".port", "must be between 1 and %d but is %d", maxPort, mb.Port)
+		}
+		if mb.Serverport < 1 || mb.Serverport > maxPort {
+			add(path+".serverport", "must be between 1 and %d but is %d", maxPort, mb.Serverport)
+		} else if other, found := serverports[mb.Serverport]; found {
+			add(path+".serverport", "%d already used by mailboxes[%d]", mb.Serverport, other)
+		} else {
+			serverports[mb.Serverport] = idx
+		}
+		if len(mb.Folders) == 0 {
+			add(path+".folders", "at least one folder is required")
+		}
+		for folderIdx, folder := range mb.Folders {
+			if strings.TrimSpace(folder) == "" {
+				add(fmt.Sprintf("%s.folders[%d]", path, folderIdx), "folder name is empty")
+			}
+		}
+		// Folders are checked in order for reproducible output.
+		folders := make([]string, 0, len(mb.Folderthreads))
+		for folder := range mb.Folderthreads {
+			folders = append(folders, folder)
+		}
+		sort.Strings(folders)
+		for _, folder := range folders {
+			if threads := mb.Folderthreads[folder]; threads < 1 {
+				add(
+					fmt.Sprintf("%s.folderthreads.%s", path, folder),
+					"number of threads must be positive but is %d", threads,
+				)
+			}
+		}
+	}
+	return problems
+}
+
+// Print problems with a config file, one per line.
+func printConfigProblems(writer io.Writer, problems []configProblem) error {
+	for _, problem := range problems {
+		location := problem.Path
+		if location == "" {
+			location = "config"
+		}
+		if _, err := fmt.Fprintf(writer, "%s: %s\n", location, problem.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getValidateConfigCmd() *cobra.Command {
+	validateConf := validateConfigT{}
+	cmd := &cobra.Command{
+		Use: "validate-config",
+		Long: shortValidateHelp + "\n\n" +
+			"The config file is checked against its schema, and the entries of each mailbox\n" +
+			"are checked for required values, valid ports, and duplicates. All problems are\n" +
+			"reported at once and the command fails if there are any, e.g. for use in CI.\n" +
+			"Neither the keyring nor any server is accessed.",
+		Short: shortValidateHelp,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			path := validateConf.path
+			if path == "" {
+				path = findUIConfigFile()
+			}
+			problems, err := validateConfigFile(path)
+			if err != nil {
+				return fmt.Errorf("cannot read config file: %s", err.Error())
+			}
+			if validateConf.jsonOutput {
+				err = printJSON(os.Stdout, problems)
+			} else {
+				err = printConfigProblems(os.Stdout, problems)
+			}
+			if err == nil && len(problems) > 0 {
+				err = fmt.Errorf("found %d problems in config file %s", len(problems), path)
+			}
+			return err
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(
+		&validateConf.path, "config", "",
+		"the config file to check, defaults to the one the \"ui\" command uses",
+	)
+	flags.BoolVar(
+		&validateConf.jsonOutput, "json", false,
+		"print problems as a JSON array of objects with a path and a message each",
+	)
+
+	return cmd
+}
+
+var validateConfigCmd = getValidateConfigCmd()
+
+func init() {
+	rootCmd.AddCommand(validateConfigCmd)
+}
@@ -0,0 +1,202 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), filePerms)
+	require.NoError(t, err)
+	return path
+}
+
+const validConfig = `path: some/path
+mailboxes:
+  - name: first
+    server: imap.example.com
+    user: someone
+    port: 993
+    serverport: 30912
+    folders:
+      - INBOX
+    folderthreads:
+      INBOX: 2
+  - name: second
+    server: imap.example.com
+    user: someone-else
+    port: 993
+    serverport: 30913
+    folders:
+      - _ALL_
+`
+
+func TestValidateConfigFileValid(t *testing.T) {
+	problems, err := validateConfigFile(writeConfigFile(t, validConfig))
+
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestValidateConfigFileEmpty(t *testing.T) {
+	problems, err := validateConfigFile(writeConfigFile(t, ""))
+
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestValidateConfigFileMissing(t *testing.T) {
+	_, err := validateConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+
+	assert.Error(t, err)
+}
+
+func TestValidateConfigFileSyntaxError(t *testing.T) {
+	problems, err := validateConfigFile(writeConfigFile(t, "mailboxes: [unclosed\n"))
+
+	assert.NoError(t, err)
+	require.Len(t, problems, 1)
+	assert.Empty(t, problems[0].Path)
+}
+
+func TestValidateConfigFileReportsAllProblems(t *testing.T) {
+	content := `mailboxes:
+  - name: first
+    server: imap.example.com
+    user: someone
+    port: 993
+    serverport: 30912
+    password: secret
+    folders:
+      - INBOX
+  - name: first
+    server: ""
+    user: someone
+    port: 99999
+    serverport: 30912
+    folders:
+      - ""
+    folderthreads:
+      INBOX: 0
+  - name: third
+    server: imap.example.com
+    user: someone
+    port: many
+`
+	problems, err := validateConfigFile(writeConfigFile(t, content))
+
+	assert.NoError(t, err)
+	expected := []configProblem{
+		{Path: "line 7", Message: "field password not found in type main.uiConfFileMailbox"},
+		{Path: "line 22", Message: "cannot unmarshal !!str `many` into int"},
+		{Path: "mailboxes[1].server", Message: "required but empty"},
+		{Path: "mailboxes[1].name", Message: "'first' already used by mailboxes[0]"},
+		{Path: "mailboxes[1].port", Message: "must be between 1 and 65535 but is 99999"},
+		{Path: "mailboxes[1].serverport", Message: "30912 already used by mailboxes[0]"},
+		{Path: "mailboxes[1].folders[0]", Message: "folder name is empty"},
+		{
+			Path:    "mailboxes[1].folderthreads.INBOX",
+			Message: "number of threads must be positive but is 0",
+		},
+		{Path: "mailboxes[2].port", Message: "must be between 1 and 65535 but is 0"},
+		{Path: "mailboxes[2].serverport", Message: "must be between 1 and 65535 but is 0"},
+		{Path: "mailboxes[2].folders", Message: "at least one folder is required"},
+	}
+	assert.Equal(t, expected, problems)
+}
+
+func TestValidateConfigEmptyMailbox(t *testing.T) {
+	config := uiConfigFile{Mailboxes: []*uiConfFileMailbox{nil}}
+
+	problems := config.validate()
+
+	assert.Equal(t, []configProblem{{Path: "mailboxes[0]", Message: "mailbox is empty"}}, problems)
+}
+
+func TestValidateConfigNegativeLimits(t *testing.T) {
+	config := uiConfigFile{Maxbytespersecond: -1, Maxconnections: -2}
+
+	problems := config.validate()
+
+	expected := []configProblem{
+		{Path: "maxbytespersecond", Message: "must not be negative but is -1"},
+		{Path: "maxconnections", Message: "must not be negative but is -2"},
+	}
+	assert.Equal(t, expected, problems)
+}
+
+func TestPrintConfigProblems(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	err := printConfigProblems(&buf, []configProblem{
+		{Path: "mailboxes[0].port", Message: "some problem"},
+		{Message: "other problem"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "mailboxes[0].port: some problem\nconfig: other problem\n", buf.String())
+}
+
+func TestValidateConfigCommand(t *testing.T) {
+	cmd := getValidateConfigCmd()
+	cmd.SetArgs([]string{"--config", writeConfigFile(t, validConfig)})
+
+	err := cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func TestValidateConfigCommandProblems(t *testing.T) {
+	for _, args := range [][]string{{}, {"--json"}} {
+		cmd := getValidateConfigCmd()
+		path := writeConfigFile(t, "mailboxes:\n  - name: first\n")
+		cmd.SetArgs(append(args, "--config", path))
+
+		err := cmd.Execute()
+		assert.ErrorContains(t, err, "found 5 problems in config file")
+	}
+}
+
+func TestValidateConfigCommandMissingFile(t *testing.T) {
+	cmd := getValidateConfigCmd()
+	cmd.SetArgs([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")})
+
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "cannot read config file")
+}
+
+func TestValidateConfigCommandDefaultFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	cfgDir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "go-imapgrab")
+	require.NoError(t, os.MkdirAll(cfgDir, dirPerms))
+	err := os.WriteFile(filepath.Join(cfgDir, "config.yaml"), []byte(validConfig), filePerms)
+	require.NoError(t, err)
+
+	cmd := getValidateConfigCmd()
+	cmd.SetArgs([]string{})
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
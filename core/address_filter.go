@@ -0,0 +1,82 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// The header fields whose values are matched by an address filter, see
+// DownloadOptions.AddressFilter.
+var addressFilterFields = []string{"From", "To", "Cc"}
+
+// Type addressFilterStorer drops emails none of whose sender and recipient header fields match a
+// pattern before handing them to the underlying storer. Dropped emails count as handled, i.e. they
+// are remembered as downloaded and not retrieved again. Emails whose header cannot be parsed are
+// handed on unchanged so that they are validated as usual.
+type addressFilterStorer struct {
+	Storer
+	pattern  *regexp.Regexp
+	filtered int
+	sync.Mutex
+}
+
+// Write stores an email if one of its sender and recipient header fields matches the pattern. Only
+// the header is read to decide that, the remainder is streamed to the underlying storer.
+func (s *addressFilterStorer) Write(info EmailInfo, content io.Reader) error {
+	// Everything read from the content while filtering is kept to be handed on.
+	consumed := &bytes.Buffer{}
+	header, err := textproto.ReadHeader(bufio.NewReader(io.TeeReader(content, consumed)))
+	if err == nil && !s.matches(header) {
+		logInfo(fmt.Sprintf("not storing email %s since no address matches", info.Key))
+		s.Lock()
+		s.filtered++
+		s.Unlock()
+		return nil
+	}
+	return s.Storer.Write(info, io.MultiReader(consumed, content))
+}
+
+// Check whether any value of the sender and recipient header fields matches the pattern.
+func (s *addressFilterStorer) matches(header textproto.Header) bool {
+	for _, field := range addressFilterFields {
+		for _, value := range header.Values(field) {
+			if s.pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Provide the number of emails that have been dropped so far. A nil storer has dropped none.
+func (s *addressFilterStorer) count() int {
+	if s == nil {
+		return 0
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.filtered
+}
@@ -0,0 +1,105 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressFilterStorerStoresMatchingEmails(t *testing.T) {
+	pattern := regexp.MustCompile(`@example\.com>?$`)
+	emails := []string{
+		"From: Someone <someone@example.com>\r\n\r\nbody",
+		"From: other@example.org\r\nTo: a@example.org, b@example.com\r\n\r\nbody",
+		"From: other@example.org\r\nCc: team@example.com\r\n\r\nbody",
+	}
+	for _, email := range emails {
+		ms := &mockStorer{}
+		ms.On("Write", EmailInfo{Key: "42/1"}, email).Return(nil)
+		storer := &addressFilterStorer{Storer: ms, pattern: pattern}
+
+		err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(email))
+
+		assert.NoError(t, err)
+		assert.Zero(t, storer.count())
+		ms.AssertExpectations(t)
+	}
+}
+
+func TestAddressFilterStorerDropsOtherEmails(t *testing.T) {
+	ms := &mockStorer{}
+	storer := &addressFilterStorer{Storer: ms, pattern: regexp.MustCompile(`@example\.com`)}
+
+	for _, email := range []string{
+		"From: other@example.org\r\nTo: me@example.org\r\n\r\nbody",
+		// Other header fields are not considered.
+		"From: other@example.org\r\nReply-To: me@example.com\r\n\r\nbody",
+	} {
+		err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(email))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, storer.count())
+	ms.AssertNotCalled(t, "Write")
+}
+
+func TestAddressFilterStorerHandsOnMalformedEmails(t *testing.T) {
+	email := "not a header\r\n"
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, email).Return(nil)
+	storer := &addressFilterStorer{Storer: ms, pattern: regexp.MustCompile(`@example\.com`)}
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(email))
+
+	assert.NoError(t, err)
+	assert.Zero(t, storer.count())
+	ms.AssertExpectations(t)
+}
+
+func TestAddressFilterStorerLargeEmail(t *testing.T) {
+	// The body exceeds the buffer used to read the header and has to be handed on in full.
+	email := "To: me@example.com\r\n\r\n" + strings.Repeat("some line\r\n", 1000)
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, email).Return(nil)
+	storer := &addressFilterStorer{Storer: ms, pattern: regexp.MustCompile(`@example\.com`)}
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(email))
+
+	assert.NoError(t, err)
+	ms.AssertExpectations(t)
+}
+
+func TestAddressFilterStorerNilCount(t *testing.T) {
+	var storer *addressFilterStorer
+
+	assert.Zero(t, storer.count())
+}
+
+func TestDownloadOptionsFilterByAddress(t *testing.T) {
+	ms := &mockStorer{}
+
+	assert.Nil(t, DownloadOptions{}.filterByAddress(ms))
+
+	pattern := regexp.MustCompile("me")
+	storer := DownloadOptions{AddressFilter: pattern}.filterByAddress(ms)
+	assert.Equal(t, &addressFilterStorer{Storer: ms, pattern: pattern}, storer)
+}
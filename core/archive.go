@@ -0,0 +1,122 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+const archiveSuffix = ".tar.gz"
+
+// Type archiveStorer writes emails to a gzip-compressed tar archive instead of a maildir. Entries
+// are named like files in a maildir, i.e. "new/<unique name>", and the archive also contains the
+// "cur", "new", and "tmp" directories. Thus, extracting the archive into an empty directory results
+// in a maildir. Like for the default maildir storer, the oldmail information from previous runs is
+// used to determine which emails have already been stored.
+//
+// Every run creates a new archive next to the maildir, which contains only the emails downloaded
+// during that run. The archive is created on the first write and must be closed once done.
+type archiveStorer struct {
+	*maildirStorer
+	path       string
+	file       fileOps
+	gzipWriter *gzip.Writer
+	tarWriter  *tar.Writer
+}
+
+func newArchiveStorer(folderPath string, oldmails []oldmail) *archiveStorer {
+	return &archiveStorer{
+		maildirStorer: newMaildirStorer(folderPath, oldmails),
+		path:          fmt.Sprintf("%s.%d%s", folderPath, now().Unix(), archiveSuffix),
+	}
+}
+
+// Create the archive and add the maildir directories to it.
+func (s *archiveStorer) open() error {
+	logInfo(fmt.Sprintf("creating archive %s", s.path))
+	file, err := openFile(s.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.gzipWriter = gzip.NewWriter(file)
+	s.tarWriter = tar.NewWriter(s.gzipWriter)
+	for _, dir := range []string{curMaildir, newMaildir, tmpMaildir} {
+		header := &tar.Header{
+			Typeflag: tar.TypeDir, Name: dir + "/", Mode: dirPerm, ModTime: now(),
+		}
+		if err == nil {
+			err = s.tarWriter.WriteHeader(header)
+		}
+	}
+	return err
+}
+
+// Write adds an email to the archive. The internal date of the email is used as modification time
+// of the entry.
+func (s *archiveStorer) Write(info EmailInfo, content io.Reader) (err error) {
+	if s.tarWriter == nil {
+		err = s.open()
+	}
+	var fileName string
+	if err == nil {
+		fileName, err = newUniqueName(s.hostID)
+	}
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(content)
+	}
+	if err == nil {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(newMaildir, fileName),
+			Mode:     filePerm,
+			Size:     int64(len(data)),
+			ModTime:  info.InternalDate,
+		}
+		err = s.tarWriter.WriteHeader(header)
+	}
+	if err == nil {
+		_, err = s.tarWriter.Write(data)
+	}
+	if err == nil {
+		s.known[info.Key] = struct{}{}
+	}
+	return err
+}
+
+// Close finalises the archive, if one has been created.
+func (s *archiveStorer) Close() error {
+	if s.tarWriter == nil {
+		return nil
+	}
+	logInfo(fmt.Sprintf("closing archive %s", s.path))
+	err := s.tarWriter.Close()
+	if gzipErr := s.gzipWriter.Close(); err == nil {
+		err = gzipErr
+	}
+	if fileErr := s.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Read all entries of a tar.gz archive. Map entry names to their content and modification time.
+func readArchive(t *testing.T, path string) (map[string]string, map[string]time.Time) {
+	file, err := os.Open(path) // nolint: gosec
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+	gzipReader, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	tarReader := tar.NewReader(gzipReader)
+
+	contents := map[string]string{}
+	mtimes := map[string]time.Time{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		contents[header.Name] = string(data)
+		mtimes[header.Name] = header.ModTime
+	}
+	return contents, mtimes
+}
+
+func TestArchiveStorerWrite(t *testing.T) {
+	setUpMockNow(t, time.Unix(1234, 0))
+	folderPath := filepath.Join(t.TempDir(), "folder")
+	storer := newArchiveStorer(folderPath, []oldmail{{uidFolder: 42, uid: 1}})
+	internalDate := time.Unix(1000, 0).UTC()
+
+	found, err := storer.Exists("42/2")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	err = storer.Write(EmailInfo{Key: "42/2", InternalDate: internalDate}, strings.NewReader("hi"))
+	assert.NoError(t, err)
+	err = storer.Close()
+	assert.NoError(t, err)
+
+	found, err = storer.Exists("42/2")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	contents, mtimes := readArchive(t, folderPath+".1234.tar.gz")
+	assert.Len(t, contents, 4)
+	for _, dir := range []string{"cur/", "new/", "tmp/"} {
+		assert.Contains(t, contents, dir)
+	}
+	for name, content := range contents {
+		if strings.HasPrefix(name, "new/") && name != "new/" {
+			assert.Equal(t, "hi", content)
+			assert.True(t, internalDate.Equal(mtimes[name]))
+		}
+	}
+}
+
+func TestArchiveStorerCloseWithoutWrite(t *testing.T) {
+	folderPath := filepath.Join(t.TempDir(), "folder")
+	storer := newArchiveStorer(folderPath, nil)
+
+	err := storer.Close()
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, storer.path)
+}
+
+func TestArchiveStorerWriteCannotCreate(t *testing.T) {
+	folderPath := filepath.Join(t.TempDir(), "does", "not", "exist")
+	storer := newArchiveStorer(folderPath, nil)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("hi"))
+
+	assert.Error(t, err)
+	// Nothing has been stored.
+	found, _ := storer.Exists("42/1")
+	assert.False(t, found)
+}
+
+func TestArchiveStorerFileErrors(t *testing.T) {
+	f, deferMe := setUpMockOldmailFile()
+	defer deferMe()
+	f.m.On("Write", mock.Anything).Return(0, fmt.Errorf("some write error"))
+	f.m.On("Close").Return(fmt.Errorf("some close error"))
+	storer := newArchiveStorer("folder", nil)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("hi"))
+	assert.ErrorContains(t, err, "some write error")
+	err = storer.Close()
+
+	assert.Error(t, err)
+	f.m.AssertExpectations(t)
+}
+
+func TestCloseStorer(t *testing.T) {
+	assert.NoError(t, closeStorer(nil))
+	assert.NoError(t, closeStorer(newMaildirStorer("", nil)))
+	assert.NoError(t, closeStorer(newArchiveStorer("", nil)))
+}
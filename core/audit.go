@@ -0,0 +1,144 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The name of the file in a maildir that records every downloaded email, see DownloadOptions.Audit.
+const auditLogName = "imapgrab-audit.jsonl"
+
+// Type auditRecord describes a single downloaded email in the audit log of a folder. Records are
+// chained, i.e. every record contains the hash of the previous line of the log, so that modifying,
+// removing, or reordering records can be detected.
+type auditRecord struct {
+	UIDValidity  uidFolder `json:"uidvalidity"`
+	UID          uid       `json:"uid"`
+	Source       string    `json:"source"`
+	InternalDate time.Time `json:"internal_date"`
+	Downloaded   time.Time `json:"downloaded"`
+	Size         int       `json:"size"`
+	SHA256       string    `json:"sha256"`
+	// Path is relative to the folder's maildir. It is unknown for emails stored elsewhere.
+	Path string `json:"path,omitempty"`
+	// Previous is the hex-encoded SHA-256 hash of the previous line, which is empty for the first.
+	Previous string `json:"previous"`
+}
+
+// Determine the hash of a line of the audit log that the next record refers to.
+func auditLineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// Determine the hash of the last line of an existing audit log. It is empty for a missing log.
+func lastAuditLineHash(path string) (string, error) {
+	content, err := os.ReadFile(path) // nolint: gosec
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	content = bytes.TrimRight(content, "\n")
+	if len(content) == 0 {
+		return "", nil
+	}
+	return auditLineHash(content[bytes.LastIndexByte(content, '\n')+1:]), nil
+}
+
+// Append records about emails that have just been stored to the audit log of a folder. Paths of
+// files in the maildir are taken from the list of file names, if known. The log is flushed to disk
+// before returning.
+func appendAuditLog(folderPath string, records []auditRecord) (err error) {
+	path := filepath.Join(folderPath, auditLogName)
+	previous, err := lastAuditLineHash(path)
+	var fileNames map[string]string
+	if err == nil {
+		fileNames, err = readUIDList(folderPath)
+	}
+	var buf bytes.Buffer
+	for idx := 0; err == nil && idx < len(records); idx++ {
+		record := records[idx]
+		key := uidExt{folder: record.UIDValidity, msg: record.UID}.String()
+		if fileName, found := fileNames[key]; found {
+			record.Path = deliveredPath(folderPath, fileName)
+		}
+		record.Previous = previous
+		var line []byte
+		if line, err = json.Marshal(record); err == nil {
+			previous = auditLineHash(line)
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
+	var handle fileOps
+	if err == nil {
+		logInfo(fmt.Sprintf("appending %d records to audit log %s", len(records), path))
+		handle, err = openFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm)
+	}
+	if err == nil {
+		_, err = io.Copy(handle, &buf)
+		if err == nil {
+			err = handle.Sync()
+		}
+		if closeErr := handle.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot update audit log: %s", err.Error())
+	}
+	return nil
+}
+
+// auditStorer records where every email that has been written successfully came from, when it was
+// downloaded, and the hash of its content, which is computed while writing it. The records are
+// then appended to the audit log of the folder.
+type auditStorer struct {
+	Storer
+	source  string
+	records []auditRecord
+}
+
+func (s *auditStorer) Write(info EmailInfo, content io.Reader) error {
+	hasher := sha256.New()
+	counter := &countingReader{reader: io.TeeReader(content, hasher)}
+	err := s.Storer.Write(info, counter)
+	record := auditRecord{
+		Source: s.source, InternalDate: info.InternalDate, Downloaded: now().UTC(),
+	}
+	if err == nil {
+		_, err = fmt.Sscanf(info.Key, "%d/%d", &record.UIDValidity, &record.UID)
+	}
+	if err == nil {
+		record.Size = counter.count
+		record.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+		s.records = append(s.records, record)
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// The hex-encoded SHA-256 hash of "some content".
+const someContentHash = "290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56"
+
+func TestAuditStorer(t *testing.T) {
+	orgNow := now
+	now = func() time.Time { return time.Unix(23456, 0) }
+	t.Cleanup(func() { now = orgNow })
+	ms := &mockStorer{}
+	someTime := time.Unix(12345, 0).UTC()
+	ms.On("Write", EmailInfo{Key: "42/1", InternalDate: someTime}, "some content").Return(nil)
+	ms.On("Write", mock.Anything, "other content").Return(assert.AnError)
+
+	storer := &auditStorer{Storer: ms, source: "someone@some-server:993"}
+
+	err := storer.Write(
+		EmailInfo{Key: "42/1", InternalDate: someTime}, strings.NewReader("some content"),
+	)
+	assert.NoError(t, err)
+	err = storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("other content"))
+	assert.Error(t, err)
+
+	// Only emails that have been written successfully are recorded.
+	expected := []auditRecord{{
+		UIDValidity: 42, UID: 1, Source: "someone@some-server:993", InternalDate: someTime,
+		Downloaded: time.Unix(23456, 0).UTC(), Size: 12, SHA256: someContentHash,
+	}}
+	assert.Equal(t, expected, storer.records)
+	ms.AssertExpectations(t)
+}
+
+func TestAppendAuditLog(t *testing.T) {
+	folderPath := t.TempDir()
+	require.NoError(t, appendUIDList(folderPath, "42/1", "some-file"))
+	records := []auditRecord{
+		{UIDValidity: 42, UID: 1, SHA256: someContentHash},
+		{UIDValidity: 42, UID: 2},
+	}
+
+	err := appendAuditLog(folderPath, records[:1])
+	require.NoError(t, err)
+	err = appendAuditLog(folderPath, records[1:])
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(folderPath, auditLogName))
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+	require.Len(t, lines, 2)
+	var first, second auditRecord
+	require.NoError(t, json.Unmarshal(lines[0], &first))
+	require.NoError(t, json.Unmarshal(lines[1], &second))
+	// The chain of records continues across appends.
+	assert.Equal(t, "", first.Previous)
+	assert.Equal(t, auditLineHash(lines[0]), second.Previous)
+	assert.Equal(t, filepath.Join("new", "some-file"), first.Path)
+	assert.Equal(t, someContentHash, first.SHA256)
+	assert.Equal(t, "", second.Path)
+
+	previous, err := lastAuditLineHash(filepath.Join(folderPath, auditLogName))
+	assert.NoError(t, err)
+	assert.Equal(t, auditLineHash(lines[1]), previous)
+}
+
+func TestAppendAuditLogCannotWrite(t *testing.T) {
+	folderPath := filepath.Join(t.TempDir(), "missing")
+
+	err := appendAuditLog(folderPath, []auditRecord{{UIDValidity: 42, UID: 1}})
+
+	assert.ErrorContains(t, err, "cannot update audit log")
+}
+
+func TestLastAuditLineHashMissingOrEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), auditLogName)
+
+	previous, err := lastAuditLineHash(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "", previous)
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), filePerm))
+	previous, err = lastAuditLineHash(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "", previous)
+}
@@ -0,0 +1,154 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The interval at which automatic tuning measures the throughput and decides whether to add a
+// download thread. This is a variable to simplify testing.
+var autoTuneWindow = 10 * time.Second
+
+// The minimum relative increase in throughput that an added thread has to bring about for another
+// one to be added.
+const autoTuneMinGain = 0.1
+
+// autoTuner decides whether to add download threads based on the throughput measured in
+// consecutive windows of equal length. Once adding a thread no longer pays off, it holds.
+type autoTuner struct {
+	meter      *progress
+	errCount   func() int
+	lastBytes  int
+	lastWindow int
+	lastErrs   int
+	holding    bool
+}
+
+// Determine at the end of a window whether to add a thread. The throughput achieved during the
+// window is compared to that of the previous one, which had one thread fewer. Windows without any
+// throughput, e.g. while the list of emails is retrieved, are not considered. Any new error, e.g.
+// due to the server limiting connections, causes the tuner to hold.
+func (t *autoTuner) grow() bool {
+	if t.holding {
+		return false
+	}
+	bytes, errs := t.meter.transferred(), t.errCount()
+	window := bytes - t.lastBytes
+	t.lastBytes = bytes
+	switch {
+	case errs > t.lastErrs:
+		logInfo("holding number of download threads due to errors")
+		t.holding = true
+	case window == 0:
+		return false
+	case t.lastWindow > 0 && float64(window) < float64(t.lastWindow)*(1+autoTuneMinGain):
+		logInfo(fmt.Sprintf(
+			"holding number of download threads since throughput plateaued at %s/s",
+			formatBytes(float64(window)/autoTuneWindow.Seconds()),
+		))
+		t.holding = true
+	}
+	t.lastWindow, t.lastErrs = window, errs
+	return !t.holding
+}
+
+// Download folders with an automatically tuned number of threads of at most maxThreads. All
+// threads take folders from a shared queue. The first thread uses the already authenticated
+// mainOps, further ones are added one at a time as decided by an autoTuner.
+func downloadAutoTuned(
+	cfg IMAPConfig,
+	folders []string,
+	maildirBase string,
+	maxThreads int,
+	opts DownloadOptions,
+	mainOps ImapgrabOps,
+	errs *threadSafeErrors,
+	results *folderResults,
+	interrupt interruptOps,
+	cancels *cancelGroup,
+) {
+	if maxThreads <= 0 || maxThreads > len(folders) {
+		maxThreads = len(folders)
+	}
+	queue := make(chan string, len(folders))
+	for _, folder := range folders {
+		queue <- folder
+	}
+	close(queue)
+
+	opts.meter = newProgress("all folders", 0)
+	tuner := autoTuner{meter: opts.meter, errCount: errs.count, lastErrs: errs.count()}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	work := func(ops ImapgrabOps) {
+		for folder := range queue {
+			maildirPath := opts.maildirPath(maildirBase, folder)
+			downloadErr := ops.downloadMissingEmailsToFolder(
+				maildirPath, oldmailFileName(cfg, folder), opts,
+			)
+			errs.add(skipMissingFolder(downloadErr))
+			results.record(folder, downloadErr)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		work(mainOps)
+	}()
+
+	ticker := time.NewTicker(autoTuneWindow)
+	defer ticker.Stop()
+	for threads := 1; threads < maxThreads; {
+		<-ticker.C
+		if len(queue) == 0 || stopReason(interrupt, cancels, opts.runtime) != "" {
+			return
+		}
+		if !tuner.grow() {
+			if tuner.holding {
+				return
+			}
+			continue
+		}
+		ops := NewImapgrabOps()
+		if authErr := ops.authenticateClient(cfg); authErr != nil {
+			// The server might limit the number of connections. The folders remain queued for the
+			// threads already running.
+			logWarning(fmt.Sprintf(
+				"holding at %d download threads since another one cannot log in: %s",
+				threads, authErr.Error(),
+			))
+			if logoutErr := ops.logout(true); logoutErr != nil {
+				logWarning(logoutErr.Error())
+			}
+			return
+		}
+		cancels.track(ops)
+		threads++
+		logInfo(fmt.Sprintf("increasing number of download threads to %d", threads))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { errs.add(ops.logout(errs.bad())) }()
+			work(ops)
+		}()
+	}
+}
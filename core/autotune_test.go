@@ -0,0 +1,165 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setUpAutoTuneTest(t *testing.T) {
+	orgWindow := autoTuneWindow
+	autoTuneWindow = time.Millisecond
+	t.Cleanup(func() { autoTuneWindow = orgWindow })
+}
+
+func TestAutoTunerGrowsUntilPlateau(t *testing.T) {
+	meter := newProgress("all folders", 0)
+	tuner := autoTuner{meter: meter, errCount: func() int { return 0 }}
+
+	// No throughput yet, e.g. while retrieving the list of emails.
+	assert.False(t, tuner.grow())
+	assert.False(t, tuner.holding)
+
+	meter.add(100)
+	assert.True(t, tuner.grow())
+	meter.add(200)
+	assert.True(t, tuner.grow())
+	// Less than the minimum gain.
+	meter.add(210)
+	assert.False(t, tuner.grow())
+	assert.True(t, tuner.holding)
+
+	// Once holding, the tuner never grows again.
+	meter.add(1000)
+	assert.False(t, tuner.grow())
+}
+
+func TestAutoTunerHoldsOnErrors(t *testing.T) {
+	meter := newProgress("all folders", 0)
+	errs := 0
+	tuner := autoTuner{meter: meter, errCount: func() int { return errs }}
+
+	meter.add(100)
+	assert.True(t, tuner.grow())
+	meter.add(1000)
+	errs++
+	assert.False(t, tuner.grow())
+	assert.True(t, tuner.holding)
+}
+
+func TestDownloadFolderAutoThreadsWithoutThroughput(t *testing.T) {
+	setUpAutoTuneTest(t)
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	folders := []string{"f1", "f2", "f3"}
+	opts := DownloadOptions{AutoThreads: true}
+
+	mockOps := &mockImapgrabber{}
+	// Without any throughput, no further threads are added.
+	mockOps.On("authenticateClient", cfg).Once().Return(nil)
+	mockOps.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mockOps.On("logout", false).Once().Return(nil)
+	for _, folder := range folders {
+		mockOps.On(
+			"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: folder},
+			"oldmail-some-server-42-some_user-"+folder, mock.Anything,
+		).Return(nil)
+	}
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadFolder(cfg, folders, "/some/dir", 0, opts)
+
+	assert.NoError(t, err)
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadFolderAutoThreadsAddsThread(t *testing.T) {
+	setUpAutoTuneTest(t)
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	folders := []string{"f1", "f2"}
+	opts := DownloadOptions{AutoThreads: true}
+	added := make(chan struct{})
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfg).Once().Return(nil)
+	mockOps.On("authenticateClient", cfg).Once().Return(nil).Run(func(mock.Arguments) {
+		close(added)
+	})
+	mockOps.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mockOps.On("logout", false).Twice().Return(nil)
+	// The first folder is being downloaded with some throughput until another thread has been
+	// added, which downloads the second folder.
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: "f1"},
+		"oldmail-some-server-42-some_user-f1", mock.Anything,
+	).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(2).(DownloadOptions).meter.add(100)
+		<-added
+	})
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: "f2"},
+		"oldmail-some-server-42-some_user-f2", mock.Anything,
+	).Return(nil)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadFolder(cfg, folders, "/some/dir", 0, opts)
+
+	assert.NoError(t, err)
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadFolderAutoThreadsCannotAddThread(t *testing.T) {
+	setUpAutoTuneTest(t)
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	folders := []string{"f1", "f2"}
+	opts := DownloadOptions{AutoThreads: true}
+	refused := make(chan struct{})
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfg).Once().Return(nil)
+	mockOps.On("authenticateClient", cfg).Once().Return(fmt.Errorf("too many connections")).
+		Run(func(mock.Arguments) { close(refused) })
+	mockOps.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mockOps.On("logout", true).Once().Return(nil)
+	mockOps.On("logout", false).Once().Return(nil)
+	// The failed login does not cause any folder to fail, the first thread downloads both.
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: "f1"},
+		"oldmail-some-server-42-some_user-f1", mock.Anything,
+	).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(2).(DownloadOptions).meter.add(100)
+		<-refused
+	})
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: "f2"},
+		"oldmail-some-server-42-some_user-f2", mock.Anything,
+	).Return(nil)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadFolder(cfg, folders, "/some/dir", 0, opts)
+
+	assert.NoError(t, err)
+	mockOps.AssertExpectations(t)
+}
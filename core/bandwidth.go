@@ -0,0 +1,93 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Type bandwidthLimit limits the rate at which the contents of emails are retrieved via a token
+// bucket that holds at most one second's worth of bytes. Several download threads share the same
+// limit, see DownloadOptions.MaxBytesPerSecond. Reads that exceed the available bytes put the
+// bucket into debt, which makes later reads wait until it has been paid off. A nil limit never
+// waits.
+type bandwidthLimit struct {
+	mutex          sync.Mutex
+	bytesPerSecond float64
+	available      float64
+	last           time.Time
+}
+
+func newBandwidthLimit(bytesPerSecond int) *bandwidthLimit {
+	return &bandwidthLimit{
+		bytesPerSecond: float64(bytesPerSecond),
+		available:      float64(bytesPerSecond),
+		last:           now(),
+	}
+}
+
+// Take the given number of bytes from the bucket and wait until they would have been available.
+func (l *bandwidthLimit) take(bytes int) {
+	if l == nil || bytes <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	current := now()
+	refilled := l.available + current.Sub(l.last).Seconds()*l.bytesPerSecond
+	l.available = min(refilled, l.bytesPerSecond) - float64(bytes)
+	l.last = current
+	debt := -l.available
+	l.mutex.Unlock()
+	if debt > 0 {
+		sleep(time.Duration(debt / l.bytesPerSecond * float64(time.Second)))
+	}
+}
+
+// Type throttledReader takes every byte read from the underlying reader from a bandwidth limit.
+type throttledReader struct {
+	reader io.Reader
+	limit  *bandwidthLimit
+}
+
+func (r *throttledReader) Read(data []byte) (int, error) {
+	count, err := r.reader.Read(data)
+	r.limit.take(count)
+	return count, err
+}
+
+// Type throttledStorer reads the contents of emails no faster than a bandwidth limit allows. Since
+// only a limited number of retrieved emails is buffered, that also limits the rate at which emails
+// are retrieved from the server.
+type throttledStorer struct {
+	Storer
+	limit *bandwidthLimit
+}
+
+func (s *throttledStorer) Write(info EmailInfo, content io.Reader) error {
+	return s.Storer.Write(info, &throttledReader{reader: content, limit: s.limit})
+}
+
+// Wrap a storer such that the bandwidth is limited if requested.
+func (o DownloadOptions) throttle(storer Storer) Storer {
+	if o.bandwidth == nil {
+		return storer
+	}
+	return &throttledStorer{Storer: storer, limit: o.bandwidth}
+}
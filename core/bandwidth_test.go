@@ -0,0 +1,96 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Replace the clock by one that only advances while sleeping and record all sleeps.
+func setUpSleepingClock(t *testing.T) *[]time.Duration {
+	orgNow, orgSleep := now, sleep
+	t.Cleanup(func() { now, sleep = orgNow, orgSleep })
+	current := time.Unix(0, 0)
+	now = func() time.Time { return current }
+	sleeps := []time.Duration{}
+	sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		current = current.Add(d)
+	}
+	return &sleeps
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	sleeps := setUpSleepingClock(t)
+	limit := newBandwidthLimit(100)
+
+	// A full second's worth of bytes is available right away.
+	limit.take(100)
+	assert.Empty(t, *sleeps)
+	// Further bytes have to wait until they would have been available.
+	limit.take(50)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, *sleeps)
+	// Reads larger than the bucket wait correspondingly longer.
+	limit.take(300)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 3 * time.Second}, *sleeps)
+}
+
+func TestBandwidthLimitRefillsAtMostOneSecond(t *testing.T) {
+	sleeps := setUpSleepingClock(t)
+	limit := newBandwidthLimit(100)
+
+	limit.take(100)
+	// Idle time does not allow for bursts larger than one second's worth of bytes.
+	sleep(time.Minute)
+	limit.take(150)
+
+	assert.Equal(t, []time.Duration{time.Minute, 500 * time.Millisecond}, *sleeps)
+}
+
+func TestBandwidthLimitNil(t *testing.T) {
+	sleeps := setUpSleepingClock(t)
+	var limit *bandwidthLimit
+
+	limit.take(1000)
+
+	assert.Empty(t, *sleeps)
+}
+
+func TestThrottledStorer(t *testing.T) {
+	sleeps := setUpSleepingClock(t)
+	content := strings.Repeat("x", 150)
+
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, content).Return(nil)
+
+	storer := DownloadOptions{bandwidth: newBandwidthLimit(100)}.throttle(ms)
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(content))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, *sleeps)
+	ms.AssertExpectations(t)
+}
+
+func TestThrottleWithoutLimit(t *testing.T) {
+	ms := &mockStorer{}
+	assert.Equal(t, ms, DownloadOptions{}.throttle(ms))
+}
@@ -0,0 +1,107 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// The capability of servers that support compressing the whole session via DEFLATE, see RFC
+	// 4978.
+	compressCapability = "COMPRESS=DEFLATE"
+	compressMechanism  = "DEFLATE"
+)
+
+// Type compressCommand is the command that asks the server to start compressing the session.
+type compressCommand struct{}
+
+func (cmd *compressCommand) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "COMPRESS",
+		Arguments: []interface{}{imap.RawString(compressMechanism)},
+	}
+}
+
+// Type deflateConn wraps a connection, compressing everything written to it and decompressing
+// everything read from it. Writes are flushed immediately since IMAP is a request-response
+// protocol and the other side would otherwise wait for data that is stuck in the compressor.
+type deflateConn struct {
+	net.Conn
+	reader io.ReadCloser
+	writer *flate.Writer
+}
+
+func newDeflateConn(conn net.Conn) (net.Conn, error) {
+	writer, err := flate.NewWriter(conn, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &deflateConn{Conn: conn, reader: flate.NewReader(conn), writer: writer}, nil
+}
+
+func (c *deflateConn) Read(data []byte) (int, error) {
+	return c.reader.Read(data)
+}
+
+func (c *deflateConn) Write(data []byte) (int, error) {
+	written, err := c.writer.Write(data)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	return written, err
+}
+
+func (c *deflateConn) Close() error {
+	_ = c.reader.Close()
+	_ = c.writer.Close()
+	return c.Conn.Close()
+}
+
+// Start compressing the session if the server supports it. If the server does not support it or
+// rejects the request, the session continues uncompressed. An error is only returned if the
+// connection could not be switched to compression after the server agreed, in which case it is no
+// longer usable.
+func enableCompression(imapClient imapOps) error {
+	supported, err := imapClient.Support(compressCapability)
+	if err != nil || !supported {
+		logInfo("server does not support compression, continuing uncompressed")
+		return nil
+	}
+	status, err := imapClient.Execute(&compressCommand{}, nil)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		logWarning(fmt.Sprintf(
+			"cannot enable compression, continuing uncompressed: %s", err.Error(),
+		))
+		return nil
+	}
+	if err = imapClient.Upgrade(newDeflateConn); err != nil {
+		logError("cannot switch connection to compression")
+		return err
+	}
+	logInfo("enabled compression")
+	return nil
+}
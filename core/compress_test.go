@@ -0,0 +1,124 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableCompressionUnsupported(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", compressCapability).Return(false, nil)
+
+	err := enableCompression(m)
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestEnableCompressionRejected(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", compressCapability).Return(true, nil)
+	m.On("Execute", &compressCommand{}, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespNo, Info: "not now"}, nil)
+
+	err := enableCompression(m)
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "Upgrade", mock.Anything)
+}
+
+func TestEnableCompressionSuccess(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", compressCapability).Return(true, nil)
+	m.On("Execute", &compressCommand{}, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+	m.On("Upgrade", mock.Anything).Return(nil)
+
+	err := enableCompression(m)
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestEnableCompressionUpgradeFailure(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", compressCapability).Return(true, nil)
+	m.On("Execute", &compressCommand{}, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+	m.On("Upgrade", mock.Anything).Return(fmt.Errorf("some error"))
+
+	err := enableCompression(m)
+
+	assert.ErrorContains(t, err, "some error")
+	m.AssertExpectations(t)
+}
+
+func TestAuthenticateClientCompress(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Login", "someone", "some password").Return(nil)
+	m.On("Support", compressCapability).Return(true, nil)
+	m.On("Execute", &compressCommand{}, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+	m.On("Upgrade", mock.Anything).Return(fmt.Errorf("some error"))
+
+	config := IMAPConfig{User: "someone", Password: "some password", Compress: true}
+	client, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Nil(t, client)
+}
+
+func TestCompressCommand(t *testing.T) {
+	cmd := (&compressCommand{}).Command()
+
+	assert.Equal(t, "COMPRESS", cmd.Name)
+	assert.Equal(t, []interface{}{imap.RawString("DEFLATE")}, cmd.Arguments)
+}
+
+func TestDeflateConnRoundTrip(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	client, err := newDeflateConn(clientSide)
+	require.NoError(t, err)
+	server, err := newDeflateConn(serverSide)
+	require.NoError(t, err)
+
+	message := "a1 LOGOUT\r\n"
+	go func() {
+		_, writeErr := client.Write([]byte(message))
+		assert.NoError(t, writeErr)
+		// Closing writes the final compressed block, after which the other side reads EOF.
+		assert.NoError(t, client.Close())
+	}()
+
+	content, err := io.ReadAll(server)
+
+	assert.NoError(t, err)
+	assert.Equal(t, message, string(content))
+	assert.NoError(t, serverSide.Close())
+}
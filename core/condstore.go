@@ -0,0 +1,175 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+const (
+	// The capability of servers that track modification sequences of emails, see RFC 7162.
+	condstoreCapability = "CONDSTORE"
+	statusHighestModseq = imap.StatusItem("HIGHESTMODSEQ")
+	// The name of the file in a maildir that holds the highest modification sequence of the folder
+	// as of the last successful download.
+	modseqFileName = "imapgrab-modseq"
+)
+
+// Type modseqState describes the state of a folder on the server as of the last successful
+// download. Modification sequences are only meaningful for the same UIDVALIDITY.
+type modseqState struct {
+	folder uidFolder
+	modseq uint64
+}
+
+func readModseqState(folderPath string) (state modseqState, err error) {
+	content, err := os.ReadFile(filepath.Join(folderPath, modseqFileName)) // nolint: gosec
+	if os.IsNotExist(err) {
+		return modseqState{}, nil
+	}
+	if err == nil {
+		_, err = fmt.Sscanf(string(content), "%d %d", &state.folder, &state.modseq)
+	}
+	return state, err
+}
+
+func writeModseqState(folderPath string, state modseqState) error {
+	return writeFile(
+		filepath.Join(folderPath, modseqFileName),
+		strings.NewReader(fmt.Sprintf("%d %d\n", state.folder, state.modseq)),
+	)
+}
+
+// Retrieve the highest modification sequence of a folder. This is 0 if the server does not
+// support CONDSTORE or does not track modification sequences for the folder. This must not be
+// called for the currently selected folder.
+func getHighestModseq(imapClient imapOps, folder string) (uint64, error) {
+	supported, err := imapClient.Support(condstoreCapability)
+	if err != nil || !supported {
+		return 0, err
+	}
+	status, err := imapClient.Status(folder, []imap.StatusItem{statusHighestModseq})
+	if err != nil {
+		return 0, err
+	}
+	value, found := status.Items[statusHighestModseq]
+	if !found {
+		return 0, nil
+	}
+	return strconv.ParseUint(fmt.Sprint(value), 10, 64)
+}
+
+// Type changedSinceFetch is a FETCH command with the CHANGEDSINCE modifier, which restricts the
+// response to emails whose modification sequence is larger than the given one.
+type changedSinceFetch struct {
+	commands.Fetch
+	modseq uint64
+}
+
+func (cmd *changedSinceFetch) Command() *imap.Command {
+	command := cmd.Fetch.Command()
+	command.Arguments = append(command.Arguments, []interface{}{
+		imap.RawString("CHANGEDSINCE"), imap.RawString(strconv.FormatUint(cmd.modseq, 10)),
+	})
+	return command
+}
+
+// Retrieve information about those emails in the selected folder that have been added or changed
+// since the given modification sequence. Changed emails are included because CONDSTORE does not
+// distinguish between the two, but they are already known locally and will not be downloaded.
+func getChangedMessageUUIDs(
+	mbox *imap.MailboxStatus, imapClient imapOps, modseq uint64, bufferSize int,
+) (uids []uidExt, err error) {
+	logInfo(fmt.Sprintf("retrieving information about emails changed since modseq %d", modseq))
+	if mbox.Messages == 0 {
+		return nil, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, 0) // This is "1:*", i.e. all emails.
+	cmd := &commands.Uid{Cmd: &changedSinceFetch{
+		Fetch: commands.Fetch{
+			SeqSet: seqset, Items: []imap.FetchItem{imap.FetchUid, imap.FetchRFC822Size},
+		},
+		modseq: modseq,
+	}}
+
+	messageChannel := make(chan *imap.Message, bufferSize)
+	errChannel := make(chan error, 1)
+	go func() {
+		defer close(messageChannel)
+		handler := &responses.Fetch{Messages: messageChannel, SeqSet: seqset, Uid: true}
+		status, execErr := imapClient.Execute(cmd, handler)
+		if execErr == nil {
+			execErr = status.Err()
+		}
+		errChannel <- execErr
+	}()
+	for m := range messageChannel {
+		if m != nil {
+			uids = append(
+				uids, uidExt{folder: uidFolder(mbox.UidValidity), msg: uid(m.Uid), size: m.Size},
+			)
+		}
+	}
+	uids = dedupUIDs(uids)
+	logInfo(fmt.Sprintf("received information for %d changed emails", len(uids)))
+
+	return uids, <-errChannel
+}
+
+// Determine the highest modification sequence of a folder as of the last successful download and
+// the current one. Errors only cause the incremental retrieval to be skipped since it is merely an
+// optimisation.
+func modseqStates(ops downloadOps, maildirPath maildirPathT) (last modseqState, current uint64) {
+	last, err := readModseqState(maildirPath.folderPath())
+	if err == nil {
+		current, err = ops.highestModseq(maildirPath.folderName())
+	}
+	if err != nil {
+		logWarning(fmt.Sprintf("cannot determine modseq, listing all emails: %s", err.Error()))
+		return modseqState{}, 0
+	}
+	return last, current
+}
+
+// Retrieve information about those emails on the server that might be missing locally. If the
+// server supports CONDSTORE and the folder has been downloaded successfully before, only emails
+// changed since then are considered. Otherwise, or if a full list is requested, all are.
+func listCandidateUIDs(
+	ops downloadOps, mbox *imap.MailboxStatus, last, current modseqState, fullList bool,
+) ([]uidExt, error) {
+	switch {
+	case fullList || current.modseq == 0 || last.modseq == 0 || last.folder != current.folder ||
+		last.modseq > current.modseq:
+		return ops.getAllMessageUUIDs(mbox)
+	case last.modseq == current.modseq:
+		logInfo("folder has not changed since the last download")
+		return nil, nil
+	default:
+		return ops.getChangedMessageUUIDs(mbox, last.modseq)
+	}
+}
@@ -0,0 +1,291 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModseqStateRoundTrip(t *testing.T) {
+	tmpdir := t.TempDir()
+	state := modseqState{folder: 42, modseq: 12345678901}
+
+	err := writeModseqState(tmpdir, state)
+	require.NoError(t, err)
+	readState, err := readModseqState(tmpdir)
+
+	assert.NoError(t, err)
+	assert.Equal(t, state, readState)
+}
+
+func TestReadModseqStateMissingFile(t *testing.T) {
+	state, err := readModseqState(t.TempDir())
+
+	assert.NoError(t, err)
+	assert.Equal(t, modseqState{}, state)
+}
+
+func TestReadModseqStateMalformed(t *testing.T) {
+	tmpdir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tmpdir, modseqFileName), []byte("garbage"), filePerm)
+	require.NoError(t, err)
+
+	_, err = readModseqState(tmpdir)
+
+	assert.Error(t, err)
+}
+
+func TestGetHighestModseq(t *testing.T) {
+	status := &imap.MailboxStatus{
+		Items: map[imap.StatusItem]interface{}{statusHighestModseq: "12345678901"},
+	}
+	m := &mockClient{}
+	m.On("Support", condstoreCapability).Return(true, nil)
+	m.On("Status", "some-folder", []imap.StatusItem{statusHighestModseq}).Return(status, nil)
+
+	modseq, err := getHighestModseq(m, "some-folder")
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345678901), modseq)
+	m.AssertExpectations(t)
+}
+
+func TestGetHighestModseqNotSupported(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", condstoreCapability).Return(false, nil)
+
+	modseq, err := getHighestModseq(m, "some-folder")
+
+	assert.NoError(t, err)
+	assert.Zero(t, modseq)
+	m.AssertExpectations(t)
+}
+
+func TestGetHighestModseqNotTracked(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", condstoreCapability).Return(true, nil)
+	m.On("Status", "some-folder", mock.Anything).Return(&imap.MailboxStatus{}, nil)
+
+	modseq, err := getHighestModseq(m, "some-folder")
+
+	assert.NoError(t, err)
+	assert.Zero(t, modseq)
+}
+
+func TestGetHighestModseqError(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", condstoreCapability).Return(true, nil)
+	m.On("Status", "some-folder", mock.Anything).
+		Return(&imap.MailboxStatus{}, fmt.Errorf("some error"))
+
+	_, err := getHighestModseq(m, "some-folder")
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestChangedSinceFetchCommand(t *testing.T) {
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, 0)
+	cmd := &commands.Uid{Cmd: &changedSinceFetch{
+		Fetch:  commands.Fetch{SeqSet: seqset, Items: []imap.FetchItem{imap.FetchUid}},
+		modseq: 123,
+	}}
+	buf := bytes.Buffer{}
+	command := cmd.Command()
+	command.Tag = "A1"
+
+	err := command.WriteTo(imap.NewWriter(&buf))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "A1 UID FETCH 1:* (UID) (CHANGEDSINCE 123)\r\n", buf.String())
+}
+
+func TestGetChangedMessageUUIDs(t *testing.T) {
+	mbox := &imap.MailboxStatus{UidValidity: 42, Messages: 10}
+	m := &mockClient{}
+	m.On("Execute", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			handler := args.Get(1).(*responses.Fetch)
+			handler.Messages <- &imap.Message{Uid: 7}
+			handler.Messages <- &imap.Message{Uid: 9}
+		}).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+
+	uids, err := getChangedMessageUUIDs(mbox, m, 123, defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uidExt{{folder: 42, msg: 7}, {folder: 42, msg: 9}}, uids)
+	m.AssertExpectations(t)
+}
+
+func TestGetChangedMessageUUIDsEmptyFolder(t *testing.T) {
+	m := &mockClient{}
+
+	uids, err := getChangedMessageUUIDs(
+		&imap.MailboxStatus{}, m, 123, defaultMessageRetrievalBuffer,
+	)
+
+	assert.NoError(t, err)
+	assert.Empty(t, uids)
+}
+
+func TestGetChangedMessageUUIDsServerError(t *testing.T) {
+	mbox := &imap.MailboxStatus{UidValidity: 42, Messages: 10}
+	m := &mockClient{}
+	m.On("Execute", mock.Anything, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespNo, Info: "some error"}, nil)
+
+	_, err := getChangedMessageUUIDs(mbox, m, 123, defaultMessageRetrievalBuffer)
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestListCandidateUIDs(t *testing.T) {
+	mbox := &imap.MailboxStatus{UidValidity: 42}
+	all := []uidExt{{folder: 42, msg: 1}, {folder: 42, msg: 2}}
+	changed := []uidExt{{folder: 42, msg: 2}}
+
+	m := &mockDownloader{t: t}
+	m.On("getAllMessageUUIDs", mbox).Return(all, nil)
+	m.On("getChangedMessageUUIDs", mbox, uint64(10)).Return(changed, nil)
+
+	current := modseqState{folder: 42, modseq: 20}
+	for _, testCase := range []struct {
+		last     modseqState
+		current  modseqState
+		fullList bool
+		expected []uidExt
+	}{
+		{modseqState{folder: 42, modseq: 10}, current, false, changed},
+		{modseqState{folder: 42, modseq: 10}, current, true, all},
+		{modseqState{folder: 42, modseq: 20}, current, false, nil},
+		{modseqState{folder: 42, modseq: 30}, current, false, all},
+		{modseqState{folder: 41, modseq: 10}, current, false, all},
+		{modseqState{}, current, false, all},
+		{modseqState{folder: 42, modseq: 10}, modseqState{folder: 42}, false, all},
+	} {
+		uids, err := listCandidateUIDs(m, mbox, testCase.last, testCase.current, testCase.fullList)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, uids, testCase)
+	}
+}
+
+func TestDownloadMissingEmailsToFolderRemembersModseq(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 3}
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(20), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{}, nil).Once()
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	// The first run lists all emails and remembers the modseq while the second one recognises that
+	// nothing has changed.
+	for range []int{1, 2} {
+		err := downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, DownloadOptions{})
+		assert.NoError(t, err)
+	}
+
+	state, err := readModseqState(maildirPath.folderPath())
+	assert.NoError(t, err)
+	assert.Equal(t, modseqState{folder: 42, modseq: 20}, state)
+	m.AssertExpectations(t)
+}
+
+func TestModseqStatesError(t *testing.T) {
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "some-folder"}
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(0), fmt.Errorf("some error"))
+
+	last, current := modseqStates(m, maildirPath)
+
+	assert.Equal(t, modseqState{}, last)
+	assert.Zero(t, current)
+}
+
+func TestDownloadMissingEmailsToFolderSizeFilterListsAll(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 3}
+	_, _, err := initMaildir("some-file", maildirPath)
+	require.NoError(t, err)
+	err = writeModseqState(maildirPath.folderPath(), modseqState{folder: 42, modseq: 10})
+	require.NoError(t, err)
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(20), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{{folder: 42, msg: 1, size: 10}}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	opts := DownloadOptions{MinSize: 100}
+	err = downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
+
+	assert.NoError(t, err)
+	// The state is not updated because emails excluded by size have not been downloaded.
+	state, err := readModseqState(maildirPath.folderPath())
+	assert.NoError(t, err)
+	assert.Equal(t, modseqState{folder: 42, modseq: 10}, state)
+	m.AssertExpectations(t)
+}
+
+func TestDownloadMissingEmailsToFolderSinceUIDListsAll(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 3}
+	_, _, err := initMaildir("some-file", maildirPath)
+	require.NoError(t, err)
+	err = writeModseqState(maildirPath.folderPath(), modseqState{folder: 42, modseq: 10})
+	require.NoError(t, err)
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(20), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{{folder: 42, msg: 1}}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	opts := DownloadOptions{SinceUID: 2, SinceUIDValidity: 42}
+	err = downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
+
+	assert.NoError(t, err)
+	// The state is not updated because emails excluded by UID have not been downloaded.
+	state, err := readModseqState(maildirPath.folderPath())
+	assert.NoError(t, err)
+	assert.Equal(t, modseqState{folder: 42, modseq: 10}, state)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,142 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"time"
+)
+
+// Make this a function pointer to simplify testing.
+var sleep = time.Sleep
+
+// Determine whether an error occurred at the connection level, e.g. due to a failed DNS lookup, a
+// refused connection, a timeout or a connection that was closed unexpectedly. Such errors are
+// usually transient. In contrast, errors such as rejected credentials or invalid TLS certificates
+// are permanent and retrying would not help.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// DefaultTransientErrors are the patterns that IMAPConfig.TransientErrors defaults to. They match
+// errors of servers that are temporarily unable to serve a client, e.g. because it has too many
+// connections open, including the response codes UNAVAILABLE and INUSE of RFC 5530.
+var DefaultTransientErrors = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)too many (simultaneous |concurrent )?connections`),
+	regexp.MustCompile(`(?i)system error`),
+	regexp.MustCompile(`(?i)try again later`),
+	regexp.MustCompile(`\[(UNAVAILABLE|INUSE)\]`),
+}
+
+// Determine whether an error is transient, i.e. whether retrying might help. That is the case for
+// errors at the connection level and for those matching any of the configured patterns.
+func (c IMAPConfig) isTransientError(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	patterns := c.TransientErrors
+	if patterns == nil {
+		patterns = DefaultTransientErrors
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Connect to a server, retrying transient errors as often as configured. The delay between
+// attempts starts at the configured backoff and doubles after each failed attempt.
+func connectWithRetries(addr string, config IMAPConfig) (imapClient imapOps, err error) {
+	backoff := config.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		imapClient, err = newImapClient(addr, config.Insecure, config.tlsConfig())
+		if err == nil || attempt >= config.ConnectRetries || !config.isTransientError(err) {
+			return imapClient, err
+		}
+		logWarning(fmt.Sprintf(
+			"cannot connect, retrying in %s (%d/%d): %s",
+			backoff, attempt+1, config.ConnectRetries, err.Error(),
+		))
+		sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Determine the addresses of all servers to connect to, in order, i.e. Server followed by the
+// FallbackServers. Fallback servers use Port unless they state their own, e.g. "host:143".
+func (c IMAPConfig) serverAddrs() []string {
+	addrs := []string{net.JoinHostPort(c.Server, fmt.Sprint(c.Port))}
+	for _, server := range c.FallbackServers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, fmt.Sprint(c.Port))
+		}
+		addrs = append(addrs, server)
+	}
+	return addrs
+}
+
+// Connect to the first server that can be reached, trying the fallback servers in order if
+// connecting to the previous one fails due to transient errors even after retrying. Other errors,
+// e.g. invalid certificates, are returned right away. The address connected to is returned, too.
+func connectWithFailover(config IMAPConfig) (imapClient imapOps, addr string, err error) {
+	addrs := config.serverAddrs()
+	for idx, addr := range addrs {
+		logInfo(fmt.Sprintf("connecting to server %s", addr))
+		imapClient, err = connectWithRetries(addr, config)
+		if err == nil || idx == len(addrs)-1 || !config.isTransientError(err) {
+			return imapClient, addr, err
+		}
+		logWarning(fmt.Sprintf(
+			"cannot connect to %s, failing over to %s: %s", addr, addrs[idx+1], err.Error(),
+		))
+	}
+	return nil, "", fmt.Errorf("no server to connect to")
+}
+
+// Connect to a server and log in, retrying logins that fail due to transient errors as often as
+// configured. Failed connection attempts have been retried already. The delay between attempts
+// starts at the configured backoff and doubles after each failed attempt.
+func connectAndLoginWithRetries(config IMAPConfig) (imapClient imapOps, err error) {
+	backoff := config.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		imapClient, err = connectAndLogin(config)
+		if err == nil || attempt >= config.ConnectRetries || !errors.Is(err, ErrLoginFailed) ||
+			!config.isTransientError(err) {
+			return imapClient, err
+		}
+		logWarning(fmt.Sprintf(
+			"cannot log in, retrying in %s (%d/%d): %s",
+			backoff, attempt+1, config.ConnectRetries, err.Error(),
+		))
+		// Otherwise, the connection would count towards the limit of connections of the server.
+		if imapClient != nil {
+			_ = imapClient.Terminate()
+		}
+		sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,273 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Set up newImapClient to return the given errors in order and nil afterwards. Also record all
+// delays instead of sleeping.
+func setUpFlakyConnection(
+	t *testing.T, errs ...error,
+) (*mockClient, *int, *[]time.Duration) {
+	mock := &mockClient{}
+	calls := 0
+	orgClientGetter := newImapClient
+	newImapClient = func(_ string, _ bool, _ *tls.Config) (imapOps, error) {
+		calls++
+		if calls <= len(errs) {
+			return nil, errs[calls-1]
+		}
+		return mock, nil
+	}
+	delays := []time.Duration{}
+	orgSleep := sleep
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() {
+		newImapClient = orgClientGetter
+		sleep = orgSleep
+	})
+	return mock, &calls, &delays
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.True(t, isConnectionError(&net.DNSError{Err: "no such host"}))
+	assert.True(t, isConnectionError(&net.OpError{Op: "dial", Err: fmt.Errorf("refused")}))
+	assert.True(t, isConnectionError(fmt.Errorf("wrapped: %w", io.EOF)))
+	assert.False(t, isConnectionError(fmt.Errorf("invalid credentials")))
+	assert.False(t, isConnectionError(nil))
+}
+
+func TestConnectWithRetriesSuccessAfterRetries(t *testing.T) {
+	connErr := &net.DNSError{Err: "temporary failure"}
+	_, calls, delays := setUpFlakyConnection(t, connErr, connErr)
+	config := IMAPConfig{ConnectRetries: 3, ConnectBackoff: time.Second}
+
+	client, err := connectWithRetries("some-server:993", config)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *delays)
+}
+
+func TestConnectWithRetriesGivesUp(t *testing.T) {
+	connErr := &net.DNSError{Err: "temporary failure"}
+	_, calls, delays := setUpFlakyConnection(t, connErr, connErr, connErr)
+	config := IMAPConfig{ConnectRetries: 1, ConnectBackoff: time.Second}
+
+	_, err := connectWithRetries("some-server:993", config)
+
+	assert.ErrorIs(t, err, connErr)
+	assert.Equal(t, 2, *calls)
+	assert.Equal(t, []time.Duration{time.Second}, *delays)
+}
+
+func TestConnectWithRetriesNotRetryingPermanentErrors(t *testing.T) {
+	permanentErr := fmt.Errorf("certificate signed by unknown authority")
+	_, calls, delays := setUpFlakyConnection(t, permanentErr)
+	config := IMAPConfig{ConnectRetries: 3, ConnectBackoff: time.Second}
+
+	_, err := connectWithRetries("some-server:993", config)
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.Empty(t, *delays)
+}
+
+func TestAuthenticateClientNotRetryingLoginFailures(t *testing.T) {
+	mock, calls, delays := setUpFlakyConnection(t, &net.DNSError{Err: "temporary failure"})
+	mock.On("Login", "someone", "wrong password").Return(fmt.Errorf("wrong credentials"))
+	config := IMAPConfig{
+		User:           "someone",
+		Password:       "wrong password",
+		ConnectRetries: 3,
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "wrong credentials")
+	assert.Equal(t, 2, *calls)
+	assert.Len(t, *delays, 1)
+	mock.AssertNumberOfCalls(t, "Login", 1)
+}
+
+func TestIsTransientError(t *testing.T) {
+	config := IMAPConfig{}
+
+	assert.True(t, config.isTransientError(&net.DNSError{Err: "no such host"}))
+	assert.True(t, config.isTransientError(fmt.Errorf("Too many connections from your IP")))
+	assert.True(t, config.isTransientError(fmt.Errorf("[UNAVAILABLE] System error")))
+	assert.True(t, config.isTransientError(fmt.Errorf("[INUSE] Mailbox in use")))
+	assert.False(t, config.isTransientError(fmt.Errorf("invalid credentials")))
+
+	config.TransientErrors = []*regexp.Regexp{regexp.MustCompile("backend unreachable")}
+	assert.True(t, config.isTransientError(fmt.Errorf("login: backend unreachable")))
+	assert.True(t, config.isTransientError(io.EOF))
+	assert.False(t, config.isTransientError(fmt.Errorf("too many connections")))
+
+	config.TransientErrors = []*regexp.Regexp{}
+	assert.False(t, config.isTransientError(fmt.Errorf("too many connections")))
+}
+
+func TestConnectWithRetriesTransientErrors(t *testing.T) {
+	serverErr := fmt.Errorf("BYE too many connections")
+	_, calls, delays := setUpFlakyConnection(t, serverErr)
+	config := IMAPConfig{ConnectRetries: 3, ConnectBackoff: time.Second}
+
+	client, err := connectWithRetries("some-server:993", config)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, 2, *calls)
+	assert.Equal(t, []time.Duration{time.Second}, *delays)
+}
+
+func TestAuthenticateClientRetryingTransientLoginFailures(t *testing.T) {
+	mock, calls, delays := setUpFlakyConnection(t)
+	mock.On("Login", "someone", "some password").
+		Return(fmt.Errorf("[UNAVAILABLE] Too many connections")).Once()
+	mock.On("Login", "someone", "some password").Return(nil).Once()
+	// The connection of the failed attempt is closed before retrying.
+	mock.On("Terminate").Return(nil).Once()
+	config := IMAPConfig{
+		User:           "someone",
+		Password:       "some password",
+		ConnectRetries: 3,
+		ConnectBackoff: time.Second,
+	}
+
+	client, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, mock, client)
+	assert.Equal(t, 2, *calls)
+	assert.Equal(t, []time.Duration{time.Second}, *delays)
+	mock.AssertExpectations(t)
+}
+
+func TestAuthenticateClientTransientLoginFailuresGiveUp(t *testing.T) {
+	mock, calls, _ := setUpFlakyConnection(t)
+	mock.On("Login", "someone", "some password").Return(fmt.Errorf("System error"))
+	mock.On("Terminate").Return(nil)
+	config := IMAPConfig{User: "someone", Password: "some password", ConnectRetries: 2}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "System error")
+	assert.Equal(t, 3, *calls)
+	mock.AssertNumberOfCalls(t, "Login", 3)
+}
+
+func TestServerAddrs(t *testing.T) {
+	config := IMAPConfig{
+		Server: "primary", Port: 993, FallbackServers: []string{"secondary", "tertiary:143"},
+	}
+
+	assert.Equal(t, []string{"primary:993", "secondary:993", "tertiary:143"}, config.serverAddrs())
+}
+
+// Set up newImapClient to fail for the given addresses with the given errors and to succeed for
+// all others. All addresses connected to are recorded.
+func setUpFailingServers(t *testing.T, errs map[string]error) (*mockClient, *[]string) {
+	mock := &mockClient{}
+	addrs := []string{}
+	orgClientGetter := newImapClient
+	newImapClient = func(addr string, _ bool, _ *tls.Config) (imapOps, error) {
+		addrs = append(addrs, addr)
+		if err, found := errs[addr]; found {
+			return nil, err
+		}
+		return mock, nil
+	}
+	orgSleep := sleep
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() {
+		newImapClient = orgClientGetter
+		sleep = orgSleep
+	})
+	return mock, &addrs
+}
+
+func TestConnectWithFailover(t *testing.T) {
+	mock, addrs := setUpFailingServers(t, map[string]error{
+		"primary:993": &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")},
+	})
+	config := IMAPConfig{
+		Server: "primary", Port: 993, FallbackServers: []string{"secondary", "tertiary"},
+		ConnectRetries: 1,
+	}
+
+	client, addr, err := connectWithFailover(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, mock, client)
+	assert.Equal(t, "secondary:993", addr)
+	// The primary server is retried before failing over.
+	assert.Equal(t, []string{"primary:993", "primary:993", "secondary:993"}, *addrs)
+}
+
+func TestConnectWithFailoverAllFail(t *testing.T) {
+	_, addrs := setUpFailingServers(t, map[string]error{
+		"primary:993":   io.EOF,
+		"secondary:993": io.EOF,
+	})
+	config := IMAPConfig{Server: "primary", Port: 993, FallbackServers: []string{"secondary"}}
+
+	_, addr, err := connectWithFailover(config)
+
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "secondary:993", addr)
+	assert.Equal(t, []string{"primary:993", "secondary:993"}, *addrs)
+}
+
+func TestConnectWithFailoverPermanentError(t *testing.T) {
+	_, addrs := setUpFailingServers(t, map[string]error{
+		"primary:993": fmt.Errorf("x509: certificate signed by unknown authority"),
+	})
+	config := IMAPConfig{Server: "primary", Port: 993, FallbackServers: []string{"secondary"}}
+
+	_, _, err := connectWithFailover(config)
+
+	assert.ErrorContains(t, err, "certificate")
+	assert.Equal(t, []string{"primary:993"}, *addrs)
+}
+
+func TestAuthenticateClientNoFailoverForFailedLogins(t *testing.T) {
+	mock, addrs := setUpFailingServers(t, nil)
+	mock.On("Login", "someone", "wrong password").Return(fmt.Errorf("invalid credentials"))
+	config := IMAPConfig{
+		Server: "primary", Port: 993, FallbackServers: []string{"secondary"},
+		User: "someone", Password: "wrong password",
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.Equal(t, []string{"primary:993"}, *addrs)
+}
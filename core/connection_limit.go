@@ -0,0 +1,162 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/go-internal/lockedfile"
+)
+
+// ErrNoFreeConnection is returned when connecting an additional client while all connections
+// permitted via DownloadOptions.MaxConnections are in use.
+var ErrNoFreeConnection = errors.New("all permitted connections are in use")
+
+// The time to wait for a slot held by another process when not waiting for a free slot. Acquiring
+// a free slot is immediate, but doing so via a lock file takes a goroutine.
+var connectionSlotTimeout = 100 * time.Millisecond
+
+// Type connectionLimit limits the number of connections open at the same time via a semaphore.
+// Every connection acquires a slot before connecting and releases it after logging out. Several
+// download threads and accounts share the same limit, see DownloadOptions.MaxConnections. With a
+// directory, the slots are lock files in it, which shares the limit with all processes using the
+// same directory. To avoid deadlocks, a process only waits for a free slot if it holds none.
+// Otherwise, acquiring fails with ErrNoFreeConnection right away. A nil limit never waits.
+type connectionLimit struct {
+	mutex sync.Mutex
+	held  int
+	// Either slots or dir is used, the latter if set.
+	slots chan struct{}
+	dir   string
+	max   int
+}
+
+func newConnectionLimit(maxConnections int, dir string) (*connectionLimit, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, dirPerm); err != nil {
+			return nil, err
+		}
+	}
+	return &connectionLimit{
+		slots: make(chan struct{}, maxConnections),
+		dir:   dir,
+		max:   maxConnections,
+	}, nil
+}
+
+// Acquire a slot for a connection. The returned function releases it and may be called repeatedly.
+func (l *connectionLimit) acquire() (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mutex.Lock()
+	wait := l.held == 0
+	l.held++
+	l.mutex.Unlock()
+
+	var release func()
+	var err error
+	if l.dir != "" {
+		release, err = l.lockSlot(wait)
+	} else {
+		release, err = l.takeSlot(wait)
+	}
+	if err != nil {
+		l.mutex.Lock()
+		l.held--
+		l.mutex.Unlock()
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			release()
+			l.mutex.Lock()
+			l.held--
+			l.mutex.Unlock()
+		})
+	}, nil
+}
+
+// Take a slot of the semaphore shared within this process.
+func (l *connectionLimit) takeSlot(wait bool) (func(), error) {
+	if wait {
+		l.slots <- struct{}{}
+	} else {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			return nil, fmt.Errorf("%w, %d in total", ErrNoFreeConnection, l.max)
+		}
+	}
+	return func() { <-l.slots }, nil
+}
+
+// Since channels can only pass on single types but no tuples, we create this type.
+type slotLockT struct {
+	file *lockedfile.File
+	err  error
+}
+
+// Lock one of the lock files of the slots shared via the directory. All of them are attempted at
+// the same time and the first one obtained is kept. Locking a file cannot be aborted, so the
+// attempts for the other files continue until they succeed, upon which the files are unlocked right
+// away. That way, no slot is held longer than needed to notice that it is not wanted.
+func (l *connectionLimit) lockSlot(wait bool) (func(), error) {
+	resultChan := make(chan slotLockT)
+	done := make(chan struct{})
+	for idx := 0; idx < l.max; idx++ {
+		path := filepath.Join(l.dir, fmt.Sprintf("connection-%d%s", idx, lockSuffix))
+		go func() {
+			file, err := lockedfile.OpenFile(path, os.O_RDWR|os.O_CREATE, filePerm)
+			select {
+			case resultChan <- slotLockT{file: file, err: err}:
+			case <-done:
+				if file != nil {
+					_ = file.Close()
+				}
+			}
+		}()
+	}
+	defer close(done)
+	var timeout <-chan time.Time // A nil channel never receives, i.e. wait indefinitely.
+	if !wait {
+		timeout = time.After(connectionSlotTimeout)
+	}
+	errs := []error{}
+	for pending := l.max; pending > 0; pending-- {
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf(
+				"%w, %d in total shared via %s", ErrNoFreeConnection, l.max, l.dir,
+			)
+		case result := <-resultChan:
+			if result.err != nil {
+				errs = append(errs, result.err)
+				continue
+			}
+			return func() { _ = result.file.Close() }, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot lock any connection slot: %w", errors.Join(errs...))
+}
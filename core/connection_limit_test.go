@@ -0,0 +1,140 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionLimit(t *testing.T) {
+	limit, err := newConnectionLimit(2, "")
+	assert.NoError(t, err)
+
+	releaseFirst, err := limit.acquire()
+	assert.NoError(t, err)
+	releaseSecond, err := limit.acquire()
+	assert.NoError(t, err)
+	// Further connections do not wait while this process holds slots.
+	_, err = limit.acquire()
+	assert.ErrorIs(t, err, ErrNoFreeConnection)
+
+	// Releasing repeatedly frees the slot only once.
+	releaseSecond()
+	releaseSecond()
+	releaseThird, err := limit.acquire()
+	assert.NoError(t, err)
+	_, err = limit.acquire()
+	assert.ErrorIs(t, err, ErrNoFreeConnection)
+
+	releaseFirst()
+	releaseThird()
+	assert.Equal(t, 0, limit.held)
+}
+
+func TestConnectionLimitNil(t *testing.T) {
+	var limit *connectionLimit
+
+	release, err := limit.acquire()
+
+	assert.NoError(t, err)
+	release()
+}
+
+func TestConnectionLimitSharedViaDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "slots")
+	// Two limits using the same directory behave like those of two processes.
+	limitA, err := newConnectionLimit(2, dir)
+	assert.NoError(t, err)
+	limitB, err := newConnectionLimit(2, dir)
+	assert.NoError(t, err)
+	assert.DirExists(t, dir)
+
+	releaseA, err := limitA.acquire()
+	assert.NoError(t, err)
+	releaseB, err := limitB.acquire()
+	assert.NoError(t, err)
+	// The other process holds the remaining slot.
+	_, err = limitA.acquire()
+	assert.ErrorIs(t, err, ErrNoFreeConnection)
+
+	releaseB()
+	releaseSecondA, err := limitA.acquire()
+	assert.NoError(t, err)
+
+	releaseA()
+	releaseSecondA()
+}
+
+func TestConnectionLimitReleasesUnwantedSlots(t *testing.T) {
+	dir := t.TempDir()
+	limitA, err := newConnectionLimit(2, dir)
+	assert.NoError(t, err)
+	limitB, err := newConnectionLimit(2, dir)
+	assert.NoError(t, err)
+
+	releaseFirstA, err := limitA.acquire()
+	assert.NoError(t, err)
+	// The attempt to lock the slot held by the first connection is still underway.
+	releaseSecondA, err := limitA.acquire()
+	assert.NoError(t, err)
+	// Once released, that attempt obtains the slot but must not keep it.
+	releaseFirstA()
+	releaseFirstB, err := limitB.acquire()
+	assert.NoError(t, err)
+	releaseSecondA()
+	releaseSecondB, err := limitB.acquire()
+	assert.NoError(t, err)
+
+	releaseFirstB()
+	releaseSecondB()
+}
+
+func TestConnectionLimitWaitsIfNoneHeld(t *testing.T) {
+	dir := t.TempDir()
+	limitA, err := newConnectionLimit(1, dir)
+	assert.NoError(t, err)
+	limitB, err := newConnectionLimit(1, dir)
+	assert.NoError(t, err)
+
+	releaseA, err := limitA.acquire()
+	assert.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		release, err := limitB.acquire()
+		assert.NoError(t, err)
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		assert.Fail(t, "slot acquired while held by another process")
+	case <-time.After(2 * connectionSlotTimeout):
+	}
+
+	releaseA()
+	select {
+	case releaseB := <-acquired:
+		releaseB()
+	case <-time.After(time.Second):
+		assert.Fail(t, "slot not acquired after having been released")
+	}
+}
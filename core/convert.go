@@ -0,0 +1,294 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// The header fields that mail clients use to store the state of emails in mbox files.
+	mboxStatusHeader  = "Status"
+	mboxXStatusHeader = "X-Status"
+	// The Status flags of emails that have been read and that are no longer new, respectively.
+	mboxReadStatus = 'R'
+	mboxOldStatus  = 'O'
+	// The beginning of lines separating emails in mbox files.
+	mboxSeparator = "From "
+)
+
+// The X-Status flags corresponding to maildir flags. Maildir's "passed" flag has no equivalent.
+var mboxXStatusByMaildirFlag = map[rune]rune{
+	'R': 'A',
+	'F': 'F',
+	'T': 'D',
+	'D': 'T',
+}
+
+// ConvertMaildirToMbox writes all emails of a local maildir to a new mbox file in the mboxrd
+// format, see DownloadOptions.Mbox. The maildir is not changed. The separator line of each email
+// carries the date from its Date header or, if that is missing, the modification time of its file.
+// The maildir flags of emails are converted to the Status and X-Status header fields that mail
+// clients use for mbox files, e.g. "Status: RO" for a seen email. The number of converted emails
+// is returned. The mbox file must not exist yet.
+func ConvertMaildirToMbox(maildirPath, mboxPath string) (count int, err error) {
+	if !isMaildir(maildirPath) {
+		return 0, fmt.Errorf("given directory %s does not point to a maildir", maildirPath)
+	}
+	paths := []string{}
+	for _, dir := range []string{curMaildir, newMaildir} {
+		entries, err := os.ReadDir(filepath.Join(maildirPath, dir))
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	// Unique names start with the time of delivery, which keeps the order of emails.
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.Base(paths[i]) < filepath.Base(paths[j])
+	})
+
+	file, err := openFile(mboxPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create mbox file: %s", err.Error())
+	}
+	defer func() { err = errors.Join(err, file.Close()) }()
+	for _, path := range paths {
+		entry, err := mboxEntryFromFile(filepath.Join(maildirPath, path), mboxStatus(path))
+		if err == nil {
+			_, err = file.Write(entry)
+		}
+		if err != nil {
+			return count, fmt.Errorf("cannot convert email %s: %s", path, err.Error())
+		}
+		count++
+	}
+	logInfo(fmt.Sprintf(
+		"converted %d emails from maildir %s to mbox %s", count, maildirPath, mboxPath,
+	))
+	return count, file.Sync()
+}
+
+// Determine the Status and X-Status header fields of an email from the path of its file relative
+// to its maildir, e.g. "cur/name:2,RS". Emails in the cur sub-directory are no longer new.
+func mboxStatus(path string) []string {
+	dir, fileName := filepath.Split(path)
+	_, flags, _ := strings.Cut(fileName, maildirInfoPrefix)
+	status, xStatus := []rune{}, []rune{}
+	if strings.ContainsRune(flags, 'S') {
+		status = append(status, mboxReadStatus)
+	}
+	if filepath.Clean(dir) == curMaildir {
+		status = append(status, mboxOldStatus)
+	}
+	for _, flag := range flags {
+		if xFlag, ok := mboxXStatusByMaildirFlag[flag]; ok {
+			xStatus = append(xStatus, xFlag)
+		}
+	}
+	fields := []string{}
+	if len(status) > 0 {
+		fields = append(fields, mboxStatusHeader+": "+string(status))
+	}
+	if len(xStatus) > 0 {
+		fields = append(fields, mboxXStatusHeader+": "+string(xStatus))
+	}
+	return fields
+}
+
+// Format the email in a file as an entry of an mbox file whose header contains the given fields
+// instead of any Status and X-Status fields.
+func mboxEntryFromFile(path string, fields []string) ([]byte, error) {
+	content, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.Time{}, errors.New("no date header")
+	if msg, readErr := mail.ReadMessage(bytes.NewReader(content)); readErr == nil {
+		date, err = msg.Header.Date()
+	}
+	if err != nil {
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return nil, statErr
+		}
+		date = info.ModTime()
+	}
+	header := strings.Join(fields, "\n")
+	if header != "" {
+		header += "\n"
+	}
+	content = withoutHeaderFields(content, mboxStatusHeader, mboxXStatusHeader)
+	return mboxEntry(date, io.MultiReader(strings.NewReader(header), bytes.NewReader(content)))
+}
+
+// Remove header fields with the given names, compared case-insensitively, from an email. Folded
+// continuation lines of removed fields are removed, too. The body is kept as is.
+func withoutHeaderFields(content []byte, names ...string) []byte {
+	var result bytes.Buffer
+	removing := false
+	inHeader := true
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if inHeader && len(trimmed) == 0 {
+			inHeader = false
+		}
+		if inHeader && len(trimmed) > 0 && (trimmed[0] == ' ' || trimmed[0] == '\t') {
+			if !removing {
+				result.Write(line)
+			}
+			continue
+		}
+		removing = false
+		if inHeader {
+			name, _, _ := bytes.Cut(trimmed, []byte(":"))
+			for _, remove := range names {
+				removing = removing || strings.EqualFold(string(name), remove)
+			}
+		}
+		if !removing {
+			result.Write(line)
+		}
+	}
+	return result.Bytes()
+}
+
+// ConvertMboxToMaildir delivers all emails of an mbox file in the mboxrd format to a local maildir,
+// which is created if it does not exist. The mbox file is not changed. Lines quoted in the mboxrd
+// format are unquoted. The Status and X-Status header fields of emails are converted to maildir
+// flags and removed. The modification times of the files of emails are set to the dates in their
+// separator lines. The number of converted emails is returned.
+func ConvertMboxToMaildir(mboxPath, maildirPath string) (count int, err error) {
+	for _, dir := range []string{curMaildir, newMaildir, tmpMaildir} {
+		if err := os.MkdirAll(filepath.Join(maildirPath, dir), dirPerm); err != nil {
+			return 0, fmt.Errorf("cannot create maildir: %s", err.Error())
+		}
+	}
+	file, err := os.Open(mboxPath) // nolint: gosec
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = errors.Join(err, file.Close()) }()
+
+	reader := bufio.NewReader(file)
+	var separator string
+	var content bytes.Buffer
+	deliver := func() error {
+		if separator == "" {
+			return nil
+		}
+		// The empty line ending each entry is not part of the email.
+		email := bytes.TrimSuffix(content.Bytes(), []byte("\n"))
+		if err := deliverMboxEntry(separator, email, maildirPath); err != nil {
+			return fmt.Errorf("cannot convert email %d: %s", count+1, err.Error())
+		}
+		count++
+		return nil
+	}
+	for {
+		line, readErr := reader.ReadString('\n')
+		if strings.HasPrefix(line, mboxSeparator) {
+			if err := deliver(); err != nil {
+				return count, err
+			}
+			separator = line
+			content.Reset()
+		} else if separator != "" && mboxFromLine.MatchString(line) {
+			content.WriteString(line[1:])
+		} else if separator != "" {
+			content.WriteString(line)
+		} else if strings.TrimSpace(line) != "" {
+			return 0, fmt.Errorf("file %s is not an mbox file", mboxPath)
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return count, readErr
+		}
+	}
+	if err := deliver(); err != nil {
+		return count, err
+	}
+	logInfo(fmt.Sprintf(
+		"converted %d emails from mbox %s to maildir %s", count, mboxPath, maildirPath,
+	))
+	return count, nil
+}
+
+// Deliver a single email from an mbox file to a maildir, see ConvertMboxToMaildir.
+func deliverMboxEntry(separator string, email []byte, maildirPath string) error {
+	flags := maildirFlagsFromStatus(email)
+	email = withoutHeaderFields(email, mboxStatusHeader, mboxXStatusHeader)
+	cur := flags != ""
+	fileName, err := deliverMessage(bytes.NewReader(email), maildirPath, "", flags, cur)
+	if err != nil {
+		return err
+	}
+	if date, ok := mboxSeparatorDate(separator); ok {
+		path := filepath.Join(maildirPath, deliveredFilePath(fileName, flags, cur))
+		return os.Chtimes(path, date, date)
+	}
+	return nil
+}
+
+// Determine the maildir flags of an email from its Status and X-Status header fields, the inverse
+// of mboxStatus.
+func maildirFlagsFromStatus(email []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(email))
+	if err != nil {
+		return ""
+	}
+	flags := []rune{}
+	if strings.ContainsRune(msg.Header.Get(mboxStatusHeader), mboxReadStatus) {
+		flags = append(flags, 'S')
+	}
+	xStatus := msg.Header.Get(mboxXStatusHeader)
+	for flag, xFlag := range mboxXStatusByMaildirFlag {
+		if strings.ContainsRune(xStatus, xFlag) {
+			flags = append(flags, flag)
+		}
+	}
+	// The maildir specs mandate sorted flags.
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	return string(flags)
+}
+
+// Determine the date in the separator line of an mbox entry, e.g.
+// "From MAILER-DAEMON Mon Jan  2 15:04:05 2006". Dates are assumed to be in UTC.
+func mboxSeparatorDate(separator string) (time.Time, bool) {
+	fields := strings.Fields(strings.TrimPrefix(separator, mboxSeparator))
+	if len(fields) < 2 { // nolint: gomnd
+		return time.Time{}, false
+	}
+	date, err := time.Parse(time.ANSIC, strings.Join(fields[1:], " "))
+	return date, err == nil
+}
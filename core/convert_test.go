@@ -0,0 +1,172 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setUpConvertMaildir(t *testing.T) string {
+	maildirPath := filepath.Join(t.TempDir(), "INBOX")
+	for _, dir := range []string{curMaildir, newMaildir, tmpMaildir} {
+		require.NoError(t, os.MkdirAll(filepath.Join(maildirPath, dir), dirPerm))
+	}
+	seen := "Subject: seen\r\nStatus: O\r\nDate: Fri, 04 Mar 2022 05:06:07 +0000\r\n\r\n" +
+		"From me\r\nbody\r\n"
+	require.NoError(t, os.WriteFile(
+		filepath.Join(maildirPath, curMaildir, "1.first:2,RS"), []byte(seen), filePerm,
+	))
+	newPath := filepath.Join(maildirPath, newMaildir, "2.second")
+	require.NoError(t, os.WriteFile(newPath, []byte("Subject: new\n\nbody\n"), filePerm))
+	mtime := time.Date(2022, 3, 5, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(newPath, mtime, mtime))
+	return maildirPath
+}
+
+func TestConvertMaildirToMbox(t *testing.T) {
+	maildirPath := setUpConvertMaildir(t)
+	mboxPath := maildirPath + mboxSuffix
+
+	count, err := ConvertMaildirToMbox(maildirPath, mboxPath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	content, err := os.ReadFile(mboxPath)
+	assert.NoError(t, err)
+	expected := "From MAILER-DAEMON Fri Mar  4 05:06:07 2022\n" +
+		"Status: RO\nX-Status: A\nSubject: seen\nDate: Fri, 04 Mar 2022 05:06:07 +0000\n\n" +
+		">From me\nbody\n\n" +
+		"From MAILER-DAEMON Sat Mar  5 00:00:00 2022\n" +
+		"Subject: new\n\nbody\n\n"
+	assert.Equal(t, expected, string(content))
+}
+
+func TestConvertMaildirToMboxErrors(t *testing.T) {
+	maildirPath := setUpConvertMaildir(t)
+
+	_, err := ConvertMaildirToMbox(filepath.Join(t.TempDir(), "missing"), "not-needed")
+	assert.ErrorContains(t, err, "does not point to a maildir")
+
+	existing := filepath.Join(t.TempDir(), "existing.mbox")
+	require.NoError(t, os.WriteFile(existing, nil, filePerm))
+	_, err = ConvertMaildirToMbox(maildirPath, existing)
+	assert.ErrorContains(t, err, "cannot create mbox file")
+}
+
+func TestConvertMboxToMaildir(t *testing.T) {
+	mboxPath := filepath.Join(t.TempDir(), "INBOX.mbox")
+	content := "From MAILER-DAEMON Fri Mar  4 05:06:07 2022\n" +
+		"Status: RO\nX-Status: AF\nSubject: seen\n\n>From me\n>>From you\nbody\n\n" +
+		"From someone@example.com Sat Mar  5 00:00:00 2022\n" +
+		"Subject: new\n\nbody\n\n"
+	require.NoError(t, os.WriteFile(mboxPath, []byte(content), filePerm))
+	maildirPath := filepath.Join(t.TempDir(), "converted")
+
+	count, err := ConvertMboxToMaildir(mboxPath, maildirPath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.True(t, isMaildir(maildirPath))
+
+	seen, err := filepath.Glob(filepath.Join(maildirPath, curMaildir, "*:2,FRS"))
+	require.NoError(t, err)
+	require.Len(t, seen, 1)
+	seenContent, err := os.ReadFile(seen[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: seen\n\nFrom me\n>From you\nbody\n", string(seenContent))
+	info, err := os.Stat(seen[0])
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC), info.ModTime().UTC())
+
+	unseen, err := os.ReadDir(filepath.Join(maildirPath, newMaildir))
+	require.NoError(t, err)
+	require.Len(t, unseen, 1)
+	unseenContent, err := os.ReadFile(filepath.Join(maildirPath, newMaildir, unseen[0].Name()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: new\n\nbody\n", string(unseenContent))
+}
+
+func TestConvertMboxToMaildirRoundTrip(t *testing.T) {
+	maildirPath := setUpConvertMaildir(t)
+	mboxPath := maildirPath + mboxSuffix
+	_, err := ConvertMaildirToMbox(maildirPath, mboxPath)
+	require.NoError(t, err)
+	convertedPath := filepath.Join(t.TempDir(), "converted")
+
+	count, err := ConvertMboxToMaildir(mboxPath, convertedPath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	seen, err := filepath.Glob(filepath.Join(convertedPath, curMaildir, "*:2,RS"))
+	assert.NoError(t, err)
+	assert.Len(t, seen, 1)
+}
+
+func TestConvertMboxToMaildirErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := ConvertMboxToMaildir(filepath.Join(dir, "missing.mbox"), filepath.Join(dir, "a"))
+	assert.Error(t, err)
+
+	notMbox := filepath.Join(dir, "email.eml")
+	require.NoError(t, os.WriteFile(notMbox, []byte("Subject: hi\n\nbody\n"), filePerm))
+	_, err = ConvertMboxToMaildir(notMbox, filepath.Join(dir, "b"))
+	assert.ErrorContains(t, err, "is not an mbox file")
+
+	_, err = ConvertMboxToMaildir(notMbox, filepath.Join(notMbox, "c"))
+	assert.ErrorContains(t, err, "cannot create maildir")
+}
+
+func TestMboxStatus(t *testing.T) {
+	assert.Equal(t, []string{}, mboxStatus("new/name"))
+	assert.Equal(t, []string{"Status: O"}, mboxStatus("cur/name"))
+	assert.Equal(
+		t, []string{"Status: RO", "X-Status: TFAD"}, mboxStatus("cur/name:2,DFPRST"),
+	)
+}
+
+func TestMaildirFlagsFromStatus(t *testing.T) {
+	email := []byte("Status: RO\nX-Status: DTAF\nSubject: hi\n\nbody\n")
+	assert.Equal(t, "DFRST", maildirFlagsFromStatus(email))
+	assert.Equal(t, "", maildirFlagsFromStatus([]byte("Subject: hi\n\nbody\n")))
+}
+
+func TestWithoutHeaderFields(t *testing.T) {
+	content := "Status: RO\r\nSubject: a\r\n folded\r\nx-status: A\r\n more\r\n\r\nStatus: body\r\n"
+
+	result := withoutHeaderFields([]byte(content), "Status", "X-Status")
+
+	assert.Equal(t, "Subject: a\r\n folded\r\n\r\nStatus: body\r\n", string(result))
+}
+
+func TestMboxSeparatorDate(t *testing.T) {
+	date, ok := mboxSeparatorDate("From MAILER-DAEMON Sat Mar  5 00:00:00 2022\n")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2022, 3, 5, 0, 0, 0, 0, time.UTC), date)
+
+	_, ok = mboxSeparatorDate("From MAILER-DAEMON\n")
+	assert.False(t, ok)
+	_, ok = mboxSeparatorDate("From someone yesterday\n")
+	assert.False(t, ok)
+}
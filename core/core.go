@@ -21,16 +21,25 @@ package core
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/server"
 )
 
 var signalsToWaitFor = []os.Signal{os.Interrupt}
 
+// The maximum time that cancelling a client waits for its connection to be terminated. This is a
+// variable to simplify testing.
+var cancelTimeout = 5 * time.Second
+
 // IMAPConfig is a configuration needed to access an IMAP server.
 type IMAPConfig struct {
 	Server   string
@@ -38,6 +47,73 @@ type IMAPConfig struct {
 	User     string
 	Password string
 	Insecure bool
+	// FallbackServers are further servers providing the same mailbox, e.g. regional endpoints of a
+	// provider. They are tried in order if Server cannot be connected to due to transient errors,
+	// even after retrying as configured via ConnectRetries. Failed logins never cause a failover.
+	// Entries use Port unless they state their own, e.g. "imap2.example.com:143".
+	FallbackServers []string
+	// TLSServerName, if set, is the host name that the server's certificate is verified against
+	// instead of the one derived from Server, e.g. when connecting via an IP address or a load
+	// balancer.
+	TLSServerName string
+	// NoTLSSessionCache disables resuming TLS sessions of earlier connections to the same server,
+	// e.g. for debugging. By default, sessions are cached for the lifetime of the process so that
+	// connections after the first one, e.g. for further threads or after reconnecting, skip the
+	// full TLS handshake.
+	NoTLSSessionCache bool
+	// ProtocolLog, if set, receives the raw protocol exchange with the server for debugging, one
+	// line per line sent, prefixed with "C: " for the client and "S: " for the server. Credentials
+	// are redacted. Note that the log contains the content of all emails retrieved.
+	ProtocolLog io.Writer
+	// OAuth2, if configured, is used to authenticate instead of the password.
+	OAuth2 OAuth2Config
+	// AuthzID, if set, is the identity to act as after authenticating as User, e.g. to access a
+	// shared or delegated mailbox. It requires a server supporting the SASL PLAIN mechanism, or
+	// the one selected via AuthMethod, and cannot be combined with OAuth2.
+	AuthzID string
+	// AuthMethod, if set, selects the SASL mechanism to authenticate with the password instead of
+	// the LOGIN command, e.g. AuthMethodScramSHA256. The server has to advertise it. It cannot be
+	// combined with OAuth2.
+	AuthMethod string
+	// ConnectRetries is the number of times a connection attempt is retried after transient errors,
+	// see TransientErrors. Logins are retried only if they fail due to such errors, too.
+	ConnectRetries int
+	// ConnectBackoff is the delay before the first retry, which doubles with every retry.
+	ConnectBackoff time.Duration
+	// TransientErrors are patterns matched against the Error() string of errors that occur while
+	// connecting or logging in, in addition to errors at the connection level, e.g. a failed DNS
+	// lookup or a refused connection. Matching errors are considered transient and retried as
+	// configured via ConnectRetries. It defaults to DefaultTransientErrors if nil. Set it to an
+	// empty slice to only retry errors at the connection level.
+	TransientErrors []*regexp.Regexp
+	// FolderListBuffer and MessageBuffer, if positive, are the numbers of folders and emails,
+	// respectively, that are buffered while they are being retrieved. They default to 10 and 20.
+	// Larger buffers can improve throughput on high-latency connections but increase memory usage
+	// since up to that many emails, including their content, are held in memory at once. Negative
+	// values are invalid.
+	FolderListBuffer int
+	MessageBuffer    int
+	// SubscribedOnly causes only the folders the user is subscribed to to be listed and, thus,
+	// selected via folder specs such as _ALL_. By default, all folders are listed.
+	SubscribedOnly bool
+	// SharedFolders causes folders in other users' and shared namespaces, as reported via
+	// NAMESPACE, to be listed as well. Some servers, e.g. Exchange-style ones with shared
+	// mailboxes, do not report them otherwise.
+	SharedFolders bool
+	// Compress causes the whole session to be compressed after logging in if the server supports
+	// COMPRESS=DEFLATE, which reduces bandwidth at the cost of CPU time. It is opt-in since some
+	// servers do not implement compression correctly.
+	Compress bool
+	// EnableSync causes the CONDSTORE extension, see RFC 7162, to be enabled via the ENABLE
+	// command after logging in if the server supports it, as some servers require. Only emails
+	// changed since the last download are listed then. If the server does not confirm that
+	// CONDSTORE has been enabled, all emails are listed and compared by UID instead. QRESYNC is not
+	// supported since go-imap cannot handle the VANISHED responses that come with it.
+	EnableSync bool
+
+	// The limit of the connections shared by all download threads, see
+	// DownloadOptions.MaxConnections.
+	connections *connectionLimit
 }
 
 // ImapgrabOps provides functionality for interacting with the basic imapgrab functionality such as
@@ -49,26 +125,85 @@ type ImapgrabOps interface {
 	logout(bool) error
 	// getFolderList provides all folders in the configured mailbox
 	getFolderList() ([]string, error)
+	// getFolderInfos provides all folders in the configured mailbox including their attributes
+	getFolderInfos() ([]FolderInfo, error)
+	// getCapabilities provides all capabilities supported by the server
+	getCapabilities() ([]string, error)
+	// getNamespaces provides the personal, other users', and shared namespaces of the server
+	getNamespaces() (Namespaces, error)
 	// downloadMissingEmailsToFolder downloads all emails to a local path that are present remotely
 	// but missing locally
-	downloadMissingEmailsToFolder(maildirPathT, string) error
+	downloadMissingEmailsToFolder(maildirPathT, string, DownloadOptions) error
+	// getFolderSummary provides an overview over a folder
+	getFolderSummary(string) (FolderSummary, error)
+	// getMessageCount provides the number of emails in a folder
+	getMessageCount(string) (int, error)
+	// searchFolder provides the UIDs of all emails in a folder matching the search criteria
+	searchFolder(string, *imap.SearchCriteria) ([]uidExt, error)
+	// getFolderUIDs provides the UIDs of all emails in a folder
+	getFolderUIDs(string) ([]uidExt, error)
+	// Cancel aborts all operations in progress promptly by terminating the connection
+	Cancel()
 }
 
 // Imapgrabber is the defailt implementation of ImapgrabOps.
 type Imapgrabber struct {
-	downloadOps  downloadOps
-	imapOps      imapOps
-	interruptOps interruptOps
+	downloadOps    downloadOps
+	imapOps        imapOps
+	interruptOps   interruptOps
+	buffers        bufferSizes
+	subscribedOnly bool
+	sharedFolders  bool
+	// Release the connection's slot of IMAPConfig.connections, if any, after logging out.
+	release func()
+	// Cancel may be called concurrently, e.g. while connecting. The mutex guards the fields it
+	// accesses, i.e. imapOps, interruptOps, and cancelled.
+	mutex     sync.Mutex
+	cancelled bool
 }
 
 // authenticateClient is used to authenticate against a remote server
 func (ig *Imapgrabber) authenticateClient(cfg IMAPConfig) error {
+	release, err := cfg.connections.acquire()
+	if err != nil {
+		ig.mutex.Lock()
+		ig.interruptOps = newInterruptOps(signalsToWaitFor)
+		ig.mutex.Unlock()
+		return err
+	}
 	imapOps, err := authenticateClient(cfg)
+	if err != nil {
+		release()
+	}
+	ig.release = release
+	var extensions syncExtensions
+	if err == nil && cfg.EnableSync {
+		extensions = enableSyncExtensions(imapOps)
+	}
+	ig.mutex.Lock()
 	ig.imapOps = imapOps
 	ig.interruptOps = newInterruptOps(signalsToWaitFor)
+	cancelled := ig.cancelled
+	ig.mutex.Unlock()
+	// Cancelling while connecting finds no connection to terminate, so do that now.
+	if cancelled {
+		ig.interruptOps.deregister()
+		if err == nil {
+			logInfo("cancelled while connecting, terminating connection")
+			_ = imapOps.Terminate()
+			release()
+			err = fmt.Errorf("cancelled while connecting")
+		}
+	}
+	ig.buffers = cfg.bufferSizes()
+	ig.subscribedOnly = cfg.SubscribedOnly
+	ig.sharedFolders = cfg.SharedFolders
 	ig.downloadOps = downloader{
 		imapOps:    imapOps,
 		deliverOps: deliverer{},
+		buffers:    ig.buffers,
+		keepalive:  &keepalive{},
+		extensions: extensions,
 	}
 	return err
 }
@@ -76,6 +211,13 @@ func (ig *Imapgrabber) authenticateClient(cfg IMAPConfig) error {
 // logout is used to log out from an authenticated session
 func (ig *Imapgrabber) logout(doTerminate bool) error {
 	defer ig.interruptOps.deregister()
+	if ig.release != nil {
+		defer ig.release()
+	}
+	if ig.imapOps == nil {
+		// No client has been connected, e.g. since no connection was free.
+		return nil
+	}
 	if doTerminate {
 		logInfo("terminating connection")
 		return ig.imapOps.Terminate()
@@ -84,22 +226,99 @@ func (ig *Imapgrabber) logout(doTerminate bool) error {
 	return ig.imapOps.Logout()
 }
 
+// Cancel aborts all operations in progress promptly, including retrievals of emails that are
+// blocked waiting for the server. No further emails are retrieved and the connection is terminated
+// since a blocked retrieval would not return otherwise. The client cannot be used afterwards.
+// Cancel returns after at most 5 seconds even if the connection cannot be terminated in time. If a
+// connection is being established, it is terminated right after and authenticating fails.
+func (ig *Imapgrabber) Cancel() {
+	ig.mutex.Lock()
+	ig.cancelled = true
+	imapOps, interruptOps := ig.imapOps, ig.interruptOps
+	ig.mutex.Unlock()
+	if interruptOps != nil {
+		// A deregistered interrupt handler reports an interrupt, which stops all retrievals.
+		interruptOps.deregister()
+	}
+	if imapOps == nil {
+		return
+	}
+	logInfo("cancelling, terminating connection")
+	terminated := make(chan struct{})
+	go func() {
+		defer close(terminated)
+		if err := imapOps.Terminate(); err != nil {
+			logWarning(fmt.Sprintf("error while terminating connection: %s", err.Error()))
+		}
+	}()
+	select {
+	case <-terminated:
+	case <-time.After(cancelTimeout):
+		logWarning("connection could not be terminated in time, giving up")
+	}
+}
+
 // getFolderList provides all folders in the configured mailbox
 func (ig *Imapgrabber) getFolderList() ([]string, error) {
-	return getFolderList(ig.imapOps)
+	return getFolderList(
+		ig.imapOps, ig.buffers.folderList, ig.subscribedOnly, ig.sharedFolders,
+	)
+}
+
+// getFolderInfos provides all folders in the configured mailbox including their attributes
+func (ig *Imapgrabber) getFolderInfos() ([]FolderInfo, error) {
+	if ig.sharedFolders {
+		return listFoldersWithNamespaces(ig.imapOps, ig.buffers.folderList, ig.subscribedOnly)
+	}
+	return listFolders(ig.imapOps, ig.buffers.folderList, ig.subscribedOnly)
+}
+
+// getCapabilities provides all capabilities supported by the server
+func (ig *Imapgrabber) getCapabilities() ([]string, error) {
+	return getCapabilities(ig.imapOps)
+}
+
+// getNamespaces provides the personal, other users', and shared namespaces of the server
+func (ig *Imapgrabber) getNamespaces() (Namespaces, error) {
+	return getNamespaces(ig.imapOps)
+}
+
+// getFolderSummary provides an overview over a folder
+func (ig *Imapgrabber) getFolderSummary(folder string) (FolderSummary, error) {
+	return getFolderSummary(ig.imapOps, folder, ig.buffers.messages)
+}
+
+// getMessageCount provides the number of emails in a folder
+func (ig *Imapgrabber) getMessageCount(folder string) (int, error) {
+	return getMessageCount(ig.imapOps, folder)
+}
+
+// searchFolder provides the UIDs of all emails in a folder matching the search criteria
+func (ig *Imapgrabber) searchFolder(
+	folder string, criteria *imap.SearchCriteria,
+) ([]uidExt, error) {
+	return searchFolder(ig.imapOps, folder, criteria)
+}
+
+// getFolderUIDs provides the UIDs of all emails in a folder
+func (ig *Imapgrabber) getFolderUIDs(folder string) ([]uidExt, error) {
+	return getFolderUIDs(ig.imapOps, folder, ig.buffers.messages)
 }
 
 // downloadMissingEmailsToFolder downloads all emails to a local path that are present remotely
 // but missing locally
 func (ig *Imapgrabber) downloadMissingEmailsToFolder(
-	maildirPath maildirPathT, oldmailName string,
+	maildirPath maildirPathT, oldmailName string, opts DownloadOptions,
 ) (err error) {
-	if !ig.interruptOps.interrupted() {
-		return downloadMissingEmailsToFolder(
-			ig.downloadOps, maildirPath, oldmailName, ig.interruptOps,
-		)
+	sig := opts.runtime.wrap(ig.interruptOps)
+	if sig.interrupted() {
+		return fmt.Errorf("not downloading due to previous interrupt")
 	}
-	return fmt.Errorf("not downloading due to previous interrupt")
+	// Retrievals are only interrupted between emails. Thus, the connection is terminated like when
+	// cancelling once the deadline passes.
+	stop := opts.runtime.afterDeadline(ig.Cancel)
+	defer stop()
+	return downloadMissingEmailsToFolder(ig.downloadOps, maildirPath, oldmailName, sig, opts)
 }
 
 // NewImapgrabOps creates a new instance of the default implementation of ImapgrabOps.
@@ -136,6 +355,40 @@ func GetAllFolders(cfg IMAPConfig) (folders []string, err error) {
 	return folders, err
 }
 
+// GetFolderInfos retrieves a list of all folders in a mailbox including their attributes.
+func GetFolderInfos(cfg IMAPConfig) (folders []FolderInfo, err error) {
+	ops := NewImapgrabOps()
+	err = ops.authenticateClient(cfg)
+	if err == nil {
+		// Make sure to log out in the end if we logged in successfully.
+		defer func() {
+			// Don't overwrite the error if it has already been set.
+			if logoutErr := ops.logout(false); logoutErr != nil && err == nil {
+				err = logoutErr
+			}
+		}()
+		folders, err = ops.getFolderInfos()
+	}
+	return folders, err
+}
+
+// GetCapabilities retrieves the sorted list of capabilities the server supports after logging in.
+func GetCapabilities(cfg IMAPConfig) (capabilities []string, err error) {
+	ops := NewImapgrabOps()
+	err = ops.authenticateClient(cfg)
+	if err == nil {
+		// Make sure to log out in the end if we logged in successfully.
+		defer func() {
+			// Don't overwrite the error if it has already been set.
+			if logoutErr := ops.logout(false); logoutErr != nil && err == nil {
+				err = logoutErr
+			}
+		}()
+		capabilities, err = ops.getCapabilities()
+	}
+	return capabilities, err
+}
+
 func partitionFolders(folders []string, numPartitions int) [][]string {
 	// Never spawn more threads than there are folders.
 	if numPartitions > len(folders) || numPartitions <= 0 {
@@ -156,30 +409,163 @@ func partitionFolders(folders []string, numPartitions int) [][]string {
 // The oldmail file in the parent directory of the maildir is used to determine which emails have
 // already been downloaded. According to the [maildir specs](https://cr.yp.to/proto/maildir.html),
 // the email is first downloaded into the `tmp` sub-directory and then moved atomically to the `new`
-// sub-directory.
-func DownloadFolder(cfg IMAPConfig, folders []string, maildirBase string, threads int) (err error) {
+// sub-directory. Use opts to adjust the download, the zero value provides the default behaviour.
+// Folders are distributed across the given number of download threads, which must not be negative.
+// Zero selects a default number depending on the number of CPUs.
+func DownloadFolder(
+	cfg IMAPConfig, folders []string, maildirBase string, threads int, opts DownloadOptions,
+) (err error) {
 	interrupt := newInterruptOps(signalsToWaitFor)
 	defer interrupt.deregister()
+	// Repetitions of log messages are reported per download.
+	FlushRepeatedLogs()
+	defer FlushRepeatedLogs()
+	// With DownloadAccounts, the limits have been set up already.
+	if opts.MaxRuntime > 0 && opts.runtime == nil {
+		opts.runtime = newRuntimeLimit(opts.MaxRuntime)
+	}
+	if opts.MaxBytesPerSecond > 0 && opts.bandwidth == nil {
+		opts.bandwidth = newBandwidthLimit(opts.MaxBytesPerSecond)
+	}
 
 	errs := threadSafeErrors{verbose: true}
-	defer func() { err = errs.err() }() // Make sure to return all errors in the end.
+	var loginErr error
+	defer func() {
+		// Make sure to return all errors in the end. The login error is kept as is so that callers
+		// can distinguish failed logins from other errors, see ErrLoginFailed.
+		err = errors.Join(loginErr, errs.err())
+		if opts.runtime.wasExceeded() {
+			// Let callers distinguish incomplete downloads from other errors.
+			err = errors.Join(fmt.Errorf("%w after %s", ErrRuntimeExceeded, opts.MaxRuntime), err)
+		}
+	}()
+
+	if opts.AccountDirs {
+		maildirBase = filepath.Join(maildirBase, AccountDirName(cfg))
+		logInfo(fmt.Sprintf("using account directory %s", maildirBase))
+	}
+
+	// Detect the format of existing output before logging in so that mismatches fail early.
+	var formatErr error
+	if opts, formatErr = opts.reconcileFormat(maildirBase); formatErr != nil {
+		errs.add(formatErr)
+		return
+	}
+	threads, threadsErr := opts.downloadThreads(threads)
+	if threadsErr != nil {
+		errs.add(threadsErr)
+		return
+	}
+	// With DownloadAccounts, the connection limit has been set up already.
+	if opts.MaxConnections > 0 && opts.connections == nil {
+		connections, limitErr := newConnectionLimit(opts.MaxConnections, opts.ConnectionSlots)
+		if limitErr != nil {
+			errs.add(fmt.Errorf("cannot limit connections: %s", limitErr.Error()))
+			return
+		}
+		opts.connections = connections
+	}
+	cfg.connections = opts.connections
+
+	if opts.Dedup != "" {
+		hashes, hashErr := openHashStore(maildirBase, opts.Dedup)
+		if hashErr != nil {
+			errs.add(fmt.Errorf("cannot read hashes for deduplication: %s", hashErr.Error()))
+			return
+		}
+		opts.hashes = hashes
+	}
+	// With DownloadAccounts, the reference maildirs have been read already.
+	if len(opts.ReferenceMaildirs) > 0 && opts.references == nil {
+		references, refErr := readReferenceMaildirs(opts.ReferenceMaildirs)
+		if refErr != nil {
+			errs.add(refErr)
+			return
+		}
+		opts.references = references
+	}
+	if opts.Summary != nil {
+		opts.account = AccountDirName(cfg)
+	}
+	if opts.Audit {
+		opts.source = fmt.Sprintf("%s@%s:%d", cfg.User, cfg.Server, cfg.Port)
+	}
+	cancels := newCancelGroup(opts.Cancel)
+	defer cancels.stop()
+	if len(opts.FolderThreads) > 0 || opts.ReconnectEvery > 0 {
+		opts.connect = newConnectFunc(cfg, cancels)
+	}
 
 	mainOps := NewImapgrabOps()
-	errs.add(mainOps.authenticateClient(cfg))
+	loginErr = mainOps.authenticateClient(cfg)
+	if loginErr != nil {
+		logError(loginErr.Error())
+		return
+	}
 	if errs.bad() {
 		return
 	}
 	defer func() { errs.add(mainOps.logout(errs.bad())) }() // Make sure to log out in the end.
+	cancels.track(mainOps)
+	if opts.GmailLabels {
+		opts.gmail = detectGmail(cfg.Server, mainOps)
+	}
 
 	// Actually retrieve folder list and partition across threads.
-	availableFolders, listErr := mainOps.getFolderList()
+	availableInfos, listErr := mainOps.getFolderInfos()
 	errs.add(listErr)
-	partitions := partitionFolders(expandFolders(folders, availableFolders), threads)
+	availableFolders := make([]string, 0, len(availableInfos))
+	for _, info := range availableInfos {
+		availableFolders = append(availableFolders, info.Name)
+	}
+	if opts.MaildirFlags {
+		opts.draftFolders = map[string]struct{}{}
+		for _, info := range FilterFoldersBySpecialUse(availableInfos, []string{imap.DraftsAttr}) {
+			opts.draftFolders[info.Name] = struct{}{}
+		}
+	}
+	selectedFolders := folders
+	if !opts.ExactFolders {
+		selectedFolders = expandFolders(folders, availableFolders)
+	}
+	if !opts.IncludeGmailAllMail && !opts.ExactFolders {
+		selectedFolders = skipGmailAllMail(selectedFolders, folders, availableInfos, cfg.Server)
+	}
+	if pathErr := opts.checkFolderPaths(maildirBase, selectedFolders); pathErr != nil {
+		errs.add(pathErr)
+		return
+	}
+	partitions := partitionFolders(selectedFolders, threads)
+
+	// A failure for one folder, or even for all folders handled by one thread, does not stop the
+	// download of the remaining ones. Instead, we report which folders failed in the end.
+	results := folderResults{summary: opts.Summary, account: opts.account}
+	defer func() { errs.add(results.summarise()) }()
+
+	if opts.AutoThreads {
+		downloadAutoTuned(
+			cfg, selectedFolders, maildirBase, threads, opts, mainOps, &errs, &results,
+			interrupt, cancels,
+		)
+		return
+	}
 
 	var wg sync.WaitGroup
 	defer wg.Wait()
+	// The first thread is started last so that it can take over the folders of threads that cannot
+	// connect since all connections permitted via DownloadOptions.MaxConnections are in use.
 	for idx := range partitions {
-		partition, threadIdx := partitions[idx], idx // Avoid closing over loop variables.
+		threadIdx := (idx + 1) % len(partitions)
+		partition := partitions[threadIdx] // Avoid closing over loop variables.
+		if reason := stopReason(interrupt, cancels, opts.runtime); reason != "" {
+			errs.add(fmt.Errorf("stopping download threads due to %s", reason))
+			for remainingIdx := idx; remainingIdx < len(partitions); remainingIdx++ {
+				for _, folder := range partitions[(remainingIdx+1)%len(partitions)] {
+					results.record(folder, fmt.Errorf("not attempted due to %s", reason))
+				}
+			}
+			break
+		}
 		var ops ImapgrabOps
 		if threadIdx > 0 {
 			// The first goroutine will use the "ops" we alread have. Every other goroutine will get
@@ -187,34 +573,150 @@ func DownloadFolder(cfg IMAPConfig, folders []string, maildirBase string, thread
 			// deadlocks or slowdowns because each gorutine has its own one.
 			// After this call, the interrupt signal handler hidden in "ops" will be registered.
 			ops = NewImapgrabOps()
-			errs.add(ops.authenticateClient(cfg))
+			if authErr := ops.authenticateClient(cfg); authErr != nil {
+				errs.add(ops.logout(true)) // Special case logout on error.
+				if errors.Is(authErr, ErrNoFreeConnection) {
+					logWarning(fmt.Sprintf(
+						"first download thread takes over %d folders: %s",
+						len(partition), authErr.Error(),
+					))
+					partitions[0] = append(partitions[0], partition...)
+					continue
+				}
+				errs.add(authErr)
+				for _, folder := range partition {
+					results.record(folder, authErr)
+				}
+				continue
+			}
+			cancels.track(ops)
 		} else {
 			ops = mainOps
 		}
 		// The signal handler in "ops" is already registered. Thus, if we have not yet been
 		// interrupted, we can be sure the goroutine will receive any interrupt.
-		if !interrupt.interrupted() && !errs.bad() {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if threadIdx > 0 { // We segfault for logouts of all but first outside a goroutine.
-					defer func() { errs.add(ops.logout(errs.bad())) }()
-				}
-				for _, folder := range partition {
-					oldmailFilePath := oldmailFileName(cfg, folder)
-					maildirPath := maildirPathT{base: maildirBase, folder: folder}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if threadIdx > 0 { // We segfault for logouts of all but first outside a goroutine.
+				defer func() { errs.add(ops.logout(errs.bad())) }()
+			}
+			for _, folder := range partition {
+				oldmailFilePath := oldmailFileName(cfg, folder)
+				maildirPath := opts.maildirPath(maildirBase, folder)
+
+				downloadErr := ops.downloadMissingEmailsToFolder(
+					maildirPath, oldmailFilePath, opts,
+				)
+				errs.add(skipMissingFolder(downloadErr))
+				results.record(folder, downloadErr)
+			}
+		}()
+	}
+	return
+}
 
-					downloadErr := ops.downloadMissingEmailsToFolder(maildirPath, oldmailFilePath)
-					errs.add(downloadErr)
-				}
-			}()
-		} else {
-			errs.add(ops.logout(errs.bad())) // Special case logout on error or interrupt.
-			errs.add(fmt.Errorf("stopping download threads due to user interrupt or error"))
+// Account specifies the folders of an account that DownloadAccounts shall download and where to.
+type Account struct {
+	Config      IMAPConfig
+	Folders     []string
+	MaildirBase string
+}
+
+// DownloadAccounts downloads the folders of several accounts one after the other via
+// DownloadFolder, using the same options for all of them. A failure for one account does not stop
+// the download of the remaining ones unless no client could be authenticated for it. Set
+// opts.ContinueOnLoginFailure to continue in that case, too. All errors are returned in the end.
+func DownloadAccounts(accounts []Account, threads int, opts DownloadOptions) error {
+	interrupt := newInterruptOps(signalsToWaitFor)
+	defer interrupt.deregister()
+	if opts.MaxRuntime > 0 {
+		opts.runtime = newRuntimeLimit(opts.MaxRuntime)
+	}
+	if opts.MaxBytesPerSecond > 0 {
+		opts.bandwidth = newBandwidthLimit(opts.MaxBytesPerSecond)
+	}
+	if opts.MaxConnections > 0 {
+		connections, err := newConnectionLimit(opts.MaxConnections, opts.ConnectionSlots)
+		if err != nil {
+			return fmt.Errorf("cannot limit connections: %s", err.Error())
+		}
+		opts.connections = connections
+	}
+	// Reference maildirs are read only once for all accounts.
+	if len(opts.ReferenceMaildirs) > 0 {
+		references, err := readReferenceMaildirs(opts.ReferenceMaildirs)
+		if err != nil {
+			return err
+		}
+		opts.references = references
+	}
+
+	errs := []error{}
+	for idx, account := range accounts {
+		name := AccountDirName(account.Config)
+		if opts.runtime.reached() {
+			errs = append(errs, fmt.Errorf(
+				"%w: not downloading %d remaining accounts", ErrRuntimeExceeded, len(accounts)-idx,
+			))
 			break
 		}
+		if reason := accountStopReason(interrupt, opts.Cancel); reason != "" {
+			errs = append(errs, fmt.Errorf(
+				"not downloading %d remaining accounts due to %s", len(accounts)-idx, reason,
+			))
+			break
+		}
+		logInfo(fmt.Sprintf("downloading account %s", name))
+		err := DownloadFolder(
+			account.Config, account.Folders, account.MaildirBase, threads, opts,
+		)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("account %s: %w", name, err))
+		if errors.Is(err, ErrLoginFailed) && !opts.ContinueOnLoginFailure {
+			if remaining := len(accounts) - idx - 1; remaining > 0 {
+				errs = append(errs, fmt.Errorf(
+					"not downloading %d remaining accounts due to failed login", remaining,
+				))
+			}
+			break
+		}
+		if errors.Is(err, ErrLoginFailed) {
+			logWarning(fmt.Sprintf(
+				"continuing with remaining accounts after login for %s failed", name,
+			))
+		}
 	}
-	return
+	return errors.Join(errs...)
+}
+
+// Determine why no further accounts shall be downloaded, if at all. A nil channel is never closed.
+func accountStopReason(interrupt interruptOps, cancel <-chan struct{}) string {
+	if interrupt.interrupted() {
+		return "user interrupt"
+	}
+	select {
+	case <-cancel:
+		return "cancellation"
+	default:
+		return ""
+	}
+}
+
+// Determine why no further download threads shall be started, if at all.
+func stopReason(interrupt interruptOps, cancels *cancelGroup, limit *runtimeLimit) string {
+	if limit.reached() {
+		return "exceeded runtime"
+	}
+	if interrupt.interrupted() {
+		return "user interrupt"
+	}
+	if cancels.isCancelled() {
+		return "cancellation"
+	}
+	return ""
 }
 
 const errClosedNetwork = "use of closed network connection"
@@ -18,14 +18,18 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-imap"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type mockImapgrabber struct {
@@ -47,14 +51,65 @@ func (m *mockImapgrabber) getFolderList() ([]string, error) {
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (m *mockImapgrabber) getFolderInfos() ([]FolderInfo, error) {
+	args := m.Called()
+	return args.Get(0).([]FolderInfo), args.Error(1)
+}
+
+// Describe folders without any attributes.
+func folderInfos(names []string) []FolderInfo {
+	infos := make([]FolderInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, FolderInfo{Name: name})
+	}
+	return infos
+}
+
 func (m *mockImapgrabber) downloadMissingEmailsToFolder(
 	maildirPath maildirPathT,
 	oldmailName string,
+	opts DownloadOptions,
 ) error {
-	args := m.Called(maildirPath, oldmailName)
+	args := m.Called(maildirPath, oldmailName, opts)
 	return args.Error(0)
 }
 
+func (m *mockImapgrabber) getCapabilities() ([]string, error) {
+	args := m.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *mockImapgrabber) getNamespaces() (Namespaces, error) {
+	args := m.Called()
+	return args.Get(0).(Namespaces), args.Error(1)
+}
+
+func (m *mockImapgrabber) getFolderSummary(folder string) (FolderSummary, error) {
+	args := m.Called(folder)
+	return args.Get(0).(FolderSummary), args.Error(1)
+}
+
+func (m *mockImapgrabber) getMessageCount(folder string) (int, error) {
+	args := m.Called(folder)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockImapgrabber) searchFolder(
+	folder string, criteria *imap.SearchCriteria,
+) ([]uidExt, error) {
+	args := m.Called(folder, criteria)
+	return args.Get(0).([]uidExt), args.Error(1)
+}
+
+func (m *mockImapgrabber) getFolderUIDs(folder string) ([]uidExt, error) {
+	args := m.Called(folder)
+	return args.Get(0).([]uidExt), args.Error(1)
+}
+
+func (m *mockImapgrabber) Cancel() {
+	_ = m.Called()
+}
+
 func setUpCoreTest(t *testing.T, m *mockImapgrabber) {
 	orgNewImapgrabOps := NewImapgrabOps
 	t.Cleanup(func() { NewImapgrabOps = orgNewImapgrabOps })
@@ -74,6 +129,27 @@ func TestImapgrabberAuthenticate(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestImapgrabberAuthenticateNoFreeConnection(t *testing.T) {
+	ig, ok := NewImapgrabOps().(*Imapgrabber)
+	assert.True(t, ok)
+	limit, err := newConnectionLimit(1, "")
+	assert.NoError(t, err)
+	release, err := limit.acquire()
+	assert.NoError(t, err)
+
+	err = ig.authenticateClient(IMAPConfig{connections: limit})
+	assert.ErrorIs(t, err, ErrNoFreeConnection)
+	// Logging out without a client is fine.
+	assert.NoError(t, ig.logout(true))
+
+	// A connection that cannot log in frees its slot right away.
+	release()
+	err = ig.authenticateClient(IMAPConfig{connections: limit})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoFreeConnection)
+	assert.Equal(t, 0, limit.held)
+}
+
 func TestImapgrabberGetFolderList(t *testing.T) {
 	ig, ok := NewImapgrabOps().(*Imapgrabber)
 	assert.True(t, ok)
@@ -87,6 +163,19 @@ func TestImapgrabberGetFolderList(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestImapgrabberGetCapabilities(t *testing.T) {
+	ig, ok := NewImapgrabOps().(*Imapgrabber)
+	assert.True(t, ok)
+
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Capability").Return(map[string]bool{}, fmt.Errorf("some error"))
+	ig.imapOps = m
+
+	_, err := ig.getCapabilities()
+
+	assert.Error(t, err)
+}
+
 func TestImapgrabberDownloadMissingEmails(t *testing.T) {
 	ig, ok := NewImapgrabOps().(*Imapgrabber)
 	assert.True(t, ok)
@@ -99,7 +188,9 @@ func TestImapgrabberDownloadMissingEmails(t *testing.T) {
 	defer mi.AssertExpectations(t)
 	ig.interruptOps = mi
 
-	err := ig.downloadMissingEmailsToFolder(maildirPathT{}, "")
+	err := ig.downloadMissingEmailsToFolder(
+		maildirPathT{base: t.TempDir()}, "", DownloadOptions{},
+	)
 
 	assert.Error(t, err)
 }
@@ -113,7 +204,9 @@ func TestImapgrabberDowloadMissingEmailsSkipIfInterrupted(t *testing.T) {
 	defer mi.AssertExpectations(t)
 	ig.interruptOps = mi
 
-	err := ig.downloadMissingEmailsToFolder(maildirPathT{}, "")
+	err := ig.downloadMissingEmailsToFolder(
+		maildirPathT{base: t.TempDir()}, "", DownloadOptions{},
+	)
 
 	assert.Error(t, err)
 }
@@ -154,6 +247,118 @@ func TestImapgrabberTerminate(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// Type slowClient simulates a server that never sends any emails. A retrieval only returns once the
+// connection has been terminated, like with a real connection blocked in a network read.
+type slowClient struct {
+	*mockClient
+	terminated chan struct{}
+	once       sync.Once
+}
+
+func (c *slowClient) UidFetch( //nolint:revive,stylecheck
+	_ *imap.SeqSet, _ []imap.FetchItem, ch chan *imap.Message,
+) error {
+	defer close(ch)
+	<-c.terminated
+	return fmt.Errorf("connection closed")
+}
+
+func (c *slowClient) Terminate() error {
+	c.once.Do(func() { close(c.terminated) })
+	return nil
+}
+
+func TestImapgrabberCancelAbortsBlockedRetrieval(t *testing.T) {
+	mbox := &imap.MailboxStatus{Name: "INBOX", UidValidity: 42, Messages: 1}
+	m := &mockClient{messages: []*imap.Message{{Uid: 1, Size: 10}}}
+	m.On("Support", condstoreCapability).Return(false, nil)
+	m.On("Select", "INBOX", true).Return(mbox, nil)
+	m.On("Fetch", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client := &slowClient{mockClient: m, terminated: make(chan struct{})}
+
+	ig := &Imapgrabber{
+		imapOps:      client,
+		interruptOps: newInterruptOps(signalsToWaitFor),
+		downloadOps:  downloader{imapOps: client, deliverOps: deliverer{}, buffers: bufferSizes{}},
+	}
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "INBOX"}
+
+	downloadErr := make(chan error, 1)
+	go func() {
+		downloadErr <- ig.downloadMissingEmailsToFolder(maildirPath, "oldmail", DownloadOptions{})
+	}()
+	// Give the download some time to block while retrieving emails.
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	ig.Cancel()
+	assert.Less(t, time.Since(start), time.Second)
+
+	select {
+	case err := <-downloadErr:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("download did not return after cancelling")
+	}
+	m.AssertExpectations(t)
+}
+
+func TestImapgrabberCancelBoundedTime(t *testing.T) {
+	orgCancelTimeout := cancelTimeout
+	cancelTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { cancelTimeout = orgCancelTimeout })
+
+	// The connection never terminates.
+	block := make(chan time.Time)
+	t.Cleanup(func() { close(block) })
+	m := &mockClient{}
+	m.On("Terminate").WaitUntil(block).Return(nil)
+
+	mi := &mockInterrupter{}
+	mi.On("deregister").Return()
+	defer mi.AssertExpectations(t)
+
+	ig := &Imapgrabber{imapOps: m, interruptOps: mi}
+
+	start := time.Now()
+	ig.Cancel()
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestImapgrabberCancelWithoutConnection(t *testing.T) {
+	ig, ok := NewImapgrabOps().(*Imapgrabber)
+	assert.True(t, ok)
+
+	assert.NotPanics(t, ig.Cancel)
+}
+
+func TestImapgrabberCancelWhileConnecting(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Login", "someone", "some password").Return(nil)
+	m.On("Terminate").Return(nil)
+	// Block connecting until the client has been cancelled.
+	connecting := make(chan struct{})
+	cancelled := make(chan struct{})
+	newImapClient = func(_ string, _ bool, _ *tls.Config) (imapOps, error) {
+		close(connecting)
+		<-cancelled
+		return m, nil
+	}
+
+	ig, ok := NewImapgrabOps().(*Imapgrabber)
+	require.True(t, ok)
+	authErr := make(chan error, 1)
+	go func() {
+		authErr <- ig.authenticateClient(IMAPConfig{User: "someone", Password: "some password"})
+	}()
+	<-connecting
+	ig.Cancel()
+	close(cancelled)
+
+	assert.ErrorContains(t, <-authErr, "cancelled while connecting")
+	assert.True(t, ig.interruptOps.interrupted())
+}
+
 func TestTryConnect(t *testing.T) {
 	cfg := IMAPConfig{
 		Server:   "some-server",
@@ -198,6 +403,47 @@ func TestGetAllFolders(t *testing.T) {
 	mock.AssertExpectations(t)
 }
 
+func TestGetFolderInfos(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	folders := []FolderInfo{{Name: "INBOX"}, {Name: "Sent", Attributes: []string{"\\Sent"}}}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderInfos").Return(folders, nil)
+	mock.On("logout", false).Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	actualFolders, err := GetFolderInfos(cfg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, folders, actualFolders)
+	mock.AssertExpectations(t)
+}
+
+func TestGetCapabilities(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some user",
+		Password: "this is very secret",
+	}
+	capabilities := []string{"IDLE", "IMAP4rev1"}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getCapabilities").Return(capabilities, nil)
+	mock.On("logout", false).Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	actualCapabilities, err := GetCapabilities(cfg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, capabilities, actualCapabilities)
+	mock.AssertExpectations(t)
+}
+
 func TestDownloadFolder(t *testing.T) {
 	cfg := IMAPConfig{
 		Server:   "some-server",
@@ -212,19 +458,105 @@ func TestDownloadFolder(t *testing.T) {
 
 	mock := &mockImapgrabber{}
 	mock.On("authenticateClient", cfg).Return(nil)
-	mock.On("getFolderList").Return(folders, nil)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
 	mock.On("logout", false).Return(fmt.Errorf("some error"))
-	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1).Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, DownloadOptions{}).
+		Return(nil)
 
 	setUpCoreTest(t, mock)
 
-	err := DownloadFolder(cfg, folders, maildir, 0)
+	err := DownloadFolder(cfg, folders, maildir, 0, DownloadOptions{})
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "some error")
 	mock.AssertExpectations(t)
 }
 
+func TestDownloadFolderAccountDirs(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some_user",
+		Password: "this is very secret",
+	}
+	folders := []string{"f1"}
+	maildirPathF1 := maildirPathT{base: "/some/dir/some_user@some-server", folder: "f1"}
+	oldmailF1 := "oldmail-some-server-42-some_user-f1"
+	opts := DownloadOptions{AccountDirs: true}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mock.On("logout", false).Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, opts).Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	err := DownloadFolder(cfg, folders, "/some/dir", 0, opts)
+
+	assert.NoError(t, err)
+	mock.AssertExpectations(t)
+}
+
+func TestDownloadFolderExactFolders(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some_user",
+		Password: "this is very secret",
+	}
+	// As folder specs, the first would be an exclusion and the second would be decoded.
+	folders := []string{"-Drafts", "A&-B"}
+	opts := DownloadOptions{ExactFolders: true}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mock.On("logout", false).Return(nil)
+	for _, folder := range folders {
+		mock.On(
+			"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: folder},
+			"oldmail-some-server-42-some_user-"+folder, opts,
+		).Return(nil)
+	}
+
+	setUpCoreTest(t, mock)
+
+	err := DownloadFolder(cfg, folders, "/some/dir", 1, opts)
+
+	assert.NoError(t, err)
+	mock.AssertExpectations(t)
+}
+
+func TestDownloadFolderFormatMismatch(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	maildir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(maildir, "f1.mbox"), nil, filePerm))
+
+	// The format is detected before logging in.
+	mock := &mockImapgrabber{}
+	setUpCoreTest(t, mock)
+
+	opts := DownloadOptions{Format: OutputFormatMaildir}
+	err := DownloadFolder(cfg, []string{"f1"}, maildir, 0, opts)
+
+	assert.ErrorContains(t, err, "requested output format maildir but")
+	mock.AssertExpectations(t)
+}
+
+func TestDownloadFolderNegativeThreads(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+
+	// The number of threads is validated before logging in.
+	mock := &mockImapgrabber{}
+	setUpCoreTest(t, mock)
+
+	err := DownloadFolder(cfg, []string{"f1"}, t.TempDir(), -1, DownloadOptions{})
+
+	assert.ErrorContains(t, err, "number of download threads must be positive")
+	mock.AssertExpectations(t)
+}
+
 func TestDownloadFolderDownloadErr(t *testing.T) {
 	cfg := IMAPConfig{
 		Server:   "some-server",
@@ -244,26 +576,194 @@ func TestDownloadFolderDownloadErr(t *testing.T) {
 	// download successfully while the other one will not.
 	mock.On("authenticateClient", cfg).Twice().Return(nil)
 	mock.On("authenticateClient", cfg).Once().Return(fmt.Errorf("some auth error"))
-	mock.On("getFolderList").Return(folders, nil)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
 	mock.On("logout", true).Return(fmt.Errorf("some logout error"))
-	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1).Return(nil)
-	mock.On("downloadMissingEmailsToFolder", maildirPathF2, oldmailF2).
+	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, DownloadOptions{}).
+		Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF2, oldmailF2, DownloadOptions{}).
 		Return(fmt.Errorf("some download error"))
 
 	setUpCoreTest(t, mock)
 
 	// We download with three goroutines. The first one succeeds apart from logout. The second one
 	// fails at the download step. The third one doesn't even manage to authenticate.
-	err := DownloadFolder(cfg, folders, maildir, 3)
+	err := DownloadFolder(cfg, folders, maildir, 3, DownloadOptions{})
 
 	assert.Error(t, err)
 	// We receive all errors concatenated.
 	assert.Contains(t, err.Error(), "some download error")
 	assert.Contains(t, err.Error(), "some logout error")
 	assert.Contains(t, err.Error(), "some auth error")
+	// The failed folders are named in the end.
+	assert.Contains(t, err.Error(), "failed to download folders 'f2, f3'")
+	mock.AssertExpectations(t)
+}
+
+func TestDownloadFolderContinuePastFailedThread(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some_user",
+		Password: "this is very secret",
+	}
+	folders := []string{"f1", "f2", "f3"}
+	maildir := "/some/dir"
+	maildirPathF1 := maildirPathT{base: maildir, folder: "f1"}
+	maildirPathF3 := maildirPathT{base: maildir, folder: "f3"}
+	oldmailF1 := "oldmail-some-server-42-some_user-f1"
+	oldmailF3 := "oldmail-some-server-42-some_user-f3"
+
+	mock := &mockImapgrabber{}
+	// The second thread fails to authenticate, but the third one is still started.
+	mock.On("authenticateClient", cfg).Once().Return(nil)
+	mock.On("authenticateClient", cfg).Once().Return(fmt.Errorf("some auth error"))
+	mock.On("authenticateClient", cfg).Once().Return(nil)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mock.On("logout", true).Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, DownloadOptions{}).
+		Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF3, oldmailF3, DownloadOptions{}).
+		Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	err := DownloadFolder(cfg, folders, maildir, 3, DownloadOptions{})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "some auth error")
+	assert.Contains(t, err.Error(), "failed to download folders 'f2'")
+	mock.AssertExpectations(t)
+}
+
+func TestDownloadFolderFirstThreadTakesOverFolders(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some_user",
+		Password: "this is very secret",
+	}
+	folders := []string{"f1", "f2"}
+	maildir := "/some/dir"
+	maildirPathF1 := maildirPathT{base: maildir, folder: "f1"}
+	maildirPathF2 := maildirPathT{base: maildir, folder: "f2"}
+	oldmailF1 := "oldmail-some-server-42-some_user-f1"
+	oldmailF2 := "oldmail-some-server-42-some_user-f2"
+
+	mock := &mockImapgrabber{}
+	// The second thread finds no free connection, so the first one downloads both folders.
+	mock.On("authenticateClient", cfg).Once().Return(nil)
+	mock.On("authenticateClient", cfg).Once().Return(
+		fmt.Errorf("%w, 1 in total", ErrNoFreeConnection),
+	)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mock.On("logout", true).Once().Return(nil)
+	mock.On("logout", false).Once().Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, DownloadOptions{}).
+		Return(nil)
+	mock.On("downloadMissingEmailsToFolder", maildirPathF2, oldmailF2, DownloadOptions{}).
+		Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	err := DownloadFolder(cfg, folders, maildir, 2, DownloadOptions{})
+
+	assert.NoError(t, err)
 	mock.AssertExpectations(t)
 }
 
+func TestDownloadFolderSkipsMissingFolder(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some_user",
+		Password: "this is very secret",
+	}
+	folders := []string{"f1", "f2"}
+	maildir := "/some/dir"
+	maildirPathF1 := maildirPathT{base: maildir, folder: "f1"}
+	maildirPathF2 := maildirPathT{base: maildir, folder: "f2"}
+	oldmailF1 := "oldmail-some-server-42-some_user-f1"
+	oldmailF2 := "oldmail-some-server-42-some_user-f2"
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderInfos").Return(folderInfos(folders), nil)
+	mock.On("logout", false).Return(nil)
+	// The first folder has been deleted after the folders were listed.
+	mock.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, DownloadOptions{}).
+		Return(fmt.Errorf("%w: f1: Mailbox doesn't exist", ErrFolderMissing))
+	mock.On("downloadMissingEmailsToFolder", maildirPathF2, oldmailF2, DownloadOptions{}).
+		Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	err := DownloadFolder(cfg, folders, maildir, 1, DownloadOptions{})
+
+	assert.NoError(t, err)
+	mock.AssertExpectations(t)
+}
+
+func TestFolderResults(t *testing.T) {
+	results := folderResults{}
+	assert.NoError(t, results.summarise())
+
+	results.record("b", nil)
+	results.record("c", fmt.Errorf("some error"))
+	results.record("a", fmt.Errorf("some other error"))
+
+	err := results.summarise()
+	assert.EqualError(t, err, "failed to download folders 'a, c'")
+	assert.Equal(t, []string{"b"}, results.succeeded)
+	assert.Equal(t, "some error", results.reasons["c"])
+}
+
+func TestFolderResultsSkipsMissingFolders(t *testing.T) {
+	results := folderResults{}
+
+	results.record("a", fmt.Errorf("%w: a", ErrFolderMissing))
+	results.record("b", nil)
+
+	assert.NoError(t, results.summarise())
+	assert.Equal(t, []string{"a"}, results.skipped)
+	assert.Equal(t, []string{"b"}, results.succeeded)
+	assert.Empty(t, results.failed)
+}
+
+func TestDownloadFolderCancel(t *testing.T) {
+	cfg := IMAPConfig{
+		Server:   "some-server",
+		Port:     42,
+		User:     "some_user",
+		Password: "this is very secret",
+	}
+	folders := []string{"f1"}
+	maildirPathF1 := maildirPathT{base: "/some/dir", folder: "f1"}
+	oldmailF1 := "oldmail-some-server-42-some_user-f1"
+	cancel := make(chan struct{})
+	opts := DownloadOptions{Cancel: cancel}
+
+	// The download only returns once the client has been cancelled.
+	cancelled := make(chan struct{})
+	m := &mockImapgrabber{}
+	m.On("authenticateClient", cfg).Return(nil)
+	m.On("getFolderInfos").Return(folderInfos(folders), nil)
+	m.On("downloadMissingEmailsToFolder", maildirPathF1, oldmailF1, opts).
+		Run(func(_ mock.Arguments) {
+			close(cancel)
+			<-cancelled
+		}).
+		Return(fmt.Errorf("connection closed"))
+	m.On("Cancel").Run(func(_ mock.Arguments) { close(cancelled) }).Return().Once()
+	m.On("logout", true).Return(nil)
+
+	setUpCoreTest(t, m)
+
+	err := DownloadFolder(cfg, folders, "/some/dir", 0, opts)
+
+	assert.ErrorContains(t, err, "connection closed")
+	m.AssertExpectations(t)
+}
+
 func TestDownloadFolderAuthErr(t *testing.T) {
 	cfg := IMAPConfig{
 		Server:   "some-server",
@@ -280,13 +780,153 @@ func TestDownloadFolderAuthErr(t *testing.T) {
 	setUpCoreTest(t, mock)
 
 	// Ensure sequential download to trigger the error reliably.
-	err := DownloadFolder(cfg, folders, maildir, 1)
+	err := DownloadFolder(cfg, folders, maildir, 1, DownloadOptions{})
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "some auth error")
 	mock.AssertExpectations(t)
 }
 
+func setUpAccountsTest() ([]Account, IMAPConfig, IMAPConfig) {
+	cfgA := IMAPConfig{Server: "server-a", Port: 42, User: "user", Password: "secret"}
+	cfgB := IMAPConfig{Server: "server-b", Port: 42, User: "user", Password: "secret"}
+	accounts := []Account{
+		{Config: cfgA, Folders: []string{"f1"}, MaildirBase: "/some/dir/a"},
+		{Config: cfgB, Folders: []string{"f1"}, MaildirBase: "/some/dir/b"},
+	}
+	return accounts, cfgA, cfgB
+}
+
+func TestDownloadAccountsSuccess(t *testing.T) {
+	accounts, cfgA, cfgB := setUpAccountsTest()
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfgA).Return(nil)
+	mockOps.On("authenticateClient", cfgB).Return(nil)
+	mockOps.On("getFolderInfos").Return(folderInfos([]string{"f1"}), nil)
+	mockOps.On("logout", false).Return(nil)
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir/a", folder: "f1"},
+		"oldmail-server-a-42-user-f1", DownloadOptions{},
+	).Return(nil)
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir/b", folder: "f1"},
+		"oldmail-server-b-42-user-f1", DownloadOptions{},
+	).Return(nil)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, DownloadOptions{})
+
+	assert.NoError(t, err)
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadAccountsStopsAtLoginFailure(t *testing.T) {
+	accounts, cfgA, _ := setUpAccountsTest()
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfgA).Return(ErrLoginFailed)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, DownloadOptions{})
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "account user@server-a: ")
+	assert.ErrorContains(t, err, "not downloading 1 remaining accounts due to failed login")
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadAccountsContinuesPastConnectFailure(t *testing.T) {
+	accounts, cfgA, cfgB := setUpAccountsTest()
+
+	mockOps := &mockImapgrabber{}
+	// Only failed logins stop the download of the remaining accounts.
+	mockOps.On("authenticateClient", cfgA).Return(fmt.Errorf("%w: some error", ErrConnectFailed))
+	mockOps.On("authenticateClient", cfgB).Return(nil)
+	mockOps.On("getFolderInfos").Return(folderInfos([]string{"f1"}), nil)
+	mockOps.On("logout", false).Return(nil)
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir/b", folder: "f1"},
+		"oldmail-server-b-42-user-f1", DownloadOptions{},
+	).Return(nil)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, DownloadOptions{})
+
+	assert.ErrorIs(t, err, ErrConnectFailed)
+	assert.NotErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "account user@server-a: ")
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadAccountsContinuesPastLoginFailure(t *testing.T) {
+	accounts, cfgA, cfgB := setUpAccountsTest()
+	opts := DownloadOptions{ContinueOnLoginFailure: true}
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfgA).Return(ErrLoginFailed)
+	mockOps.On("authenticateClient", cfgB).Return(nil)
+	mockOps.On("getFolderInfos").Return(folderInfos([]string{"f1"}), nil)
+	mockOps.On("logout", false).Return(nil)
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir/b", folder: "f1"},
+		"oldmail-server-b-42-user-f1", opts,
+	).Return(nil)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, opts)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "account user@server-a: ")
+	assert.NotContains(t, err.Error(), "server-b")
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadAccountsContinuesPastDownloadFailure(t *testing.T) {
+	accounts, cfgA, cfgB := setUpAccountsTest()
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfgA).Return(nil)
+	mockOps.On("authenticateClient", cfgB).Return(nil)
+	mockOps.On("getFolderInfos").Return(folderInfos([]string{"f1"}), nil)
+	mockOps.On("logout", true).Return(nil)
+	mockOps.On("logout", false).Return(nil)
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir/a", folder: "f1"},
+		"oldmail-server-a-42-user-f1", DownloadOptions{},
+	).Return(fmt.Errorf("some download error"))
+	mockOps.On(
+		"downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir/b", folder: "f1"},
+		"oldmail-server-b-42-user-f1", DownloadOptions{},
+	).Return(nil)
+
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, DownloadOptions{})
+
+	assert.ErrorContains(t, err, "account user@server-a: ")
+	assert.NotErrorIs(t, err, ErrLoginFailed)
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadAccountsCancelled(t *testing.T) {
+	accounts, _, _ := setUpAccountsTest()
+	cancel := make(chan struct{})
+	close(cancel)
+
+	mockOps := &mockImapgrabber{}
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, DownloadOptions{Cancel: cancel})
+
+	assert.ErrorContains(t, err, "not downloading 2 remaining accounts due to cancellation")
+	mockOps.AssertExpectations(t)
+}
+
 func TestPartitionFolders(t *testing.T) {
 	inFolders := []string{"f1", "f2", "f3", "f4"}
 	threads := 3
@@ -0,0 +1,91 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"time"
+)
+
+// DateFallback selects what replaces the internal date of emails that the server reports without a
+// usable one, see DownloadOptions.DateFallback.
+type DateFallback string
+
+const (
+	// DateFallbackHeader uses the date in the Date header field of an email or, if that is missing
+	// or invalid, the time of the download. This is the default.
+	DateFallbackHeader DateFallback = "header"
+	// DateFallbackNow uses the time of the download.
+	DateFallbackNow DateFallback = "now"
+	// DateFallbackNone causes emails without a usable internal date to fail. Like other failed
+	// emails, they are retried during the next download.
+	DateFallbackNone DateFallback = "none"
+)
+
+// Determine whether a date can be used as the internal date of an email. Dates not after the Unix
+// epoch are what servers report if they do not know the date, or what is recorded if they do not
+// report one at all.
+func usableDate(date time.Time) bool {
+	return date.Unix() > 0
+}
+
+// Replace the missing or unusable internal date of an email according to the fallback. The email's
+// content is returned since the header might have been read from it. A warning is logged whenever
+// a fallback is used.
+func fallBackDate(
+	om oldmail, content io.Reader, fallback DateFallback,
+) (oldmail, io.Reader, error) {
+	if fallback == DateFallbackNone {
+		return om, content, fmt.Errorf("email %s has no usable internal date", om.key())
+	}
+	if fallback == DateFallbackHeader || fallback == "" {
+		date, rest, err := readHeaderDate(content)
+		content = rest
+		if err == nil && usableDate(date) {
+			logWarning(fmt.Sprintf(
+				"email %s has no usable internal date, using its Date header %s",
+				om.key(), date.UTC(),
+			))
+			om.timestamp = int(date.Unix())
+			return om, content, nil
+		}
+	}
+	date := now()
+	logWarning(fmt.Sprintf(
+		"email %s has no usable internal date, using the download time %s", om.key(), date.UTC(),
+	))
+	om.timestamp = int(date.Unix())
+	return om, content, nil
+}
+
+// Parse the Date header field of an email. The returned reader provides the full content of the
+// email irrespective of how much has been read to parse the header.
+func readHeaderDate(content io.Reader) (time.Time, io.Reader, error) {
+	var read bytes.Buffer
+	msg, err := mail.ReadMessage(bufio.NewReader(io.TeeReader(content, &read)))
+	rest := io.MultiReader(&read, content)
+	if err != nil {
+		return time.Time{}, rest, err
+	}
+	date, err := msg.Header.Date()
+	return date, rest, err
+}
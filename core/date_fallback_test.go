@@ -0,0 +1,94 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpFixedClock(t *testing.T, fixed time.Time) {
+	orgNow := now
+	now = func() time.Time { return fixed }
+	t.Cleanup(func() { now = orgNow })
+}
+
+func TestUsableDate(t *testing.T) {
+	assert.True(t, usableDate(time.Unix(1, 0)))
+	assert.False(t, usableDate(time.Unix(0, 0)))
+	assert.False(t, usableDate(time.Unix(-1, 0)))
+	assert.False(t, usableDate(time.Time{}))
+}
+
+func TestFallBackDateHeader(t *testing.T) {
+	content := "Subject: hi\r\nDate: Mon, 02 Jan 2006 15:04:05 +0100\r\n\r\n" +
+		strings.Repeat("body", 2000)
+	om := oldmail{uidFolder: 42, uid: 1}
+
+	for _, fallback := range []DateFallback{"", DateFallbackHeader} {
+		dated, rest, err := fallBackDate(om, strings.NewReader(content), fallback)
+
+		assert.NoError(t, err)
+		expected := time.Date(2006, 1, 2, 14, 4, 5, 0, time.UTC)
+		assert.Equal(t, oldmail{uidFolder: 42, uid: 1, timestamp: int(expected.Unix())}, dated)
+		// The content is provided in full although the header has been read.
+		assert.Equal(t, content, readContent(t, rest))
+	}
+}
+
+func TestFallBackDateHeaderUnusable(t *testing.T) {
+	downloaded := time.Unix(23456, 0)
+	setUpFixedClock(t, downloaded)
+
+	for _, content := range []string{
+		"Subject: no date\r\n\r\nbody",
+		"Date: not a date\r\n\r\nbody",
+		"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n\r\nbody",
+		"not an email",
+	} {
+		dated, rest, err := fallBackDate(
+			oldmail{uid: 1}, strings.NewReader(content), DateFallbackHeader,
+		)
+
+		assert.NoError(t, err)
+		assert.Equal(t, oldmail{uid: 1, timestamp: 23456}, dated, content)
+		assert.Equal(t, content, readContent(t, rest))
+	}
+}
+
+func TestFallBackDateNow(t *testing.T) {
+	setUpFixedClock(t, time.Unix(23456, 0))
+	content := "Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n\r\nbody"
+
+	dated, rest, err := fallBackDate(oldmail{uid: 1}, strings.NewReader(content), DateFallbackNow)
+
+	assert.NoError(t, err)
+	assert.Equal(t, oldmail{uid: 1, timestamp: 23456}, dated)
+	assert.Equal(t, content, readContent(t, rest))
+}
+
+func TestFallBackDateNone(t *testing.T) {
+	_, _, err := fallBackDate(
+		oldmail{uidFolder: 42, uid: 1}, strings.NewReader("body"), DateFallbackNone,
+	)
+
+	assert.ErrorContains(t, err, "email 42/1 has no usable internal date")
+}
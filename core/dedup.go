@@ -0,0 +1,183 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The name of the file in the base directory that maps hashes of the content of emails to the
+// paths of their files.
+const hashStoreName = "imapgrab-hashes"
+
+// DedupLink selects how duplicate emails are linked to the file of the first copy, see
+// DownloadOptions.Dedup.
+type DedupLink string
+
+const (
+	// DedupHardlink replaces duplicate emails by hard links. This is the most robust choice since
+	// all links remain valid if one of them is moved, e.g. by a mail client.
+	DedupHardlink DedupLink = "hardlink"
+	// DedupSymlink replaces duplicate emails by relative symbolic links, e.g. for filesystems that
+	// do not support hard links. Links remain valid if they are moved between the "new" and "cur"
+	// directories of their maildir but break if the file they point to is moved, e.g. by a mail
+	// client. Thus, it cannot be combined with DownloadOptions.Mirror, and PruneMaildirs refuses
+	// to prune emails below a base directory containing such links.
+	DedupSymlink DedupLink = "symlink"
+)
+
+// Type hashStore remembers the file of the first copy of each email by the SHA-256 hash of its
+// content. It is shared by all folders downloaded to the same base directory and persisted in a
+// file there. Paths are relative to the base directory. Entries are only ever appended to the file,
+// later ones take precedence.
+type hashStore struct {
+	base   string
+	link   DedupLink
+	hashes map[string]string
+	sync.Mutex
+}
+
+// Read the hashes remembered in a base directory. If there is no file yet, e.g. when enabling
+// deduplication for an existing archive, the emails already present are indexed and remembered.
+func openHashStore(base string, link DedupLink) (*hashStore, error) {
+	store := &hashStore{base: base, link: link, hashes: map[string]string{}}
+	handle, err := os.Open(filepath.Join(base, hashStoreName)) // nolint: gosec
+	if os.IsNotExist(err) {
+		return store, store.index(numCPU())
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = handle.Close() }()
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		hash, path, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			return nil, fmt.Errorf("malformed line in hash file: %s", scanner.Text())
+		}
+		store.hashes[hash] = path
+	}
+	return store, scanner.Err()
+}
+
+// Replace the file of a newly delivered email by a link to an existing file with the same content,
+// if there is one. Otherwise, remember the file as the first copy of that content. Since the email
+// has already been stored, failures are only logged. In particular, the full copy is kept if the
+// file cannot be linked, e.g. because the filesystem does not support the link type.
+func (h *hashStore) deduplicate(path string, sum []byte) {
+	hash := hex.EncodeToString(sum)
+	relPath, err := filepath.Rel(h.base, path)
+	if err != nil {
+		logWarning(fmt.Sprintf("cannot deduplicate %s: %s", path, err.Error()))
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	// Files might have been moved since, e.g. by mail clients. Then, the new one takes over.
+	if existing, found := h.hashes[hash]; found && isFile(filepath.Join(h.base, existing)) {
+		if err := h.replaceByLink(filepath.Join(h.base, existing), path); err != nil {
+			logWarning(fmt.Sprintf(
+				"keeping full copy of duplicate %s of %s: %s", path, existing, err.Error(),
+			))
+		} else {
+			logInfo(fmt.Sprintf("linked duplicate %s to %s", path, existing))
+		}
+		return
+	}
+	h.hashes[hash] = relPath
+	if err := h.persist(hash, relPath); err != nil {
+		logWarning(fmt.Sprintf("cannot remember hash of %s: %s", path, err.Error()))
+	}
+}
+
+// Atomically replace a file by a link to another one. The link is created in the "tmp" directory
+// of the file's maildir first and then moved over the file so that the email is never missing.
+// Symbolic links point to the target relative to the maildir so that they can be moved between
+// its "tmp", "new", and "cur" directories.
+func (h *hashStore) replaceByLink(existing, path string) error {
+	maildir := filepath.Dir(filepath.Dir(path))
+	tmpPath := filepath.Join(maildir, tmpMaildir, filepath.Base(path)+".link")
+	var err error
+	if h.link == DedupSymlink {
+		var target string
+		target, err = filepath.Rel(maildir, existing)
+		if err == nil {
+			err = os.Symlink(filepath.Join("..", target), tmpPath)
+		}
+	} else {
+		err = os.Link(existing, tmpPath)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Remember the hashes of all emails already present below the base directory, replacing the file
+// in the base directory. The file is written even if there are no emails so that they are indexed
+// only once.
+func (h *hashStore) index(workers int) error {
+	hashes, err := indexMaildirs(h.base, workers)
+	if err != nil {
+		return fmt.Errorf("cannot index existing emails: %s", err.Error())
+	}
+	if len(hashes) > 0 {
+		logInfo(fmt.Sprintf("indexed %d existing emails for deduplication", len(hashes)))
+	}
+	lines := make([]string, 0, len(hashes))
+	for hash, relPath := range hashes {
+		lines = append(lines, fmt.Sprintf("%s %s\n", hash, relPath))
+	}
+	// Sorting makes the file reproducible.
+	sort.Strings(lines)
+	// Without a base directory, there are no emails and the file is created once there are.
+	if !isDir(h.base) {
+		return nil
+	}
+	err = writeFile(filepath.Join(h.base, hashStoreName), strings.NewReader(strings.Join(lines, "")))
+	if err == nil {
+		h.hashes = hashes
+	}
+	return err
+}
+
+// Append a hash and the path of its file to the file in the base directory.
+func (h *hashStore) persist(hash, relPath string) (err error) {
+	path := filepath.Join(h.base, hashStoreName)
+	handle, err := openFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := handle.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	_, err = handle.Write([]byte(fmt.Sprintf("%s %s\n", hash, relPath)))
+	return err
+}
@@ -0,0 +1,208 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setUpDedupStorer(t *testing.T, link DedupLink) (string, *maildirStorer) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	hashes, err := openHashStore(tmpdir, link)
+	require.NoError(t, err)
+	storer := newMaildirStorer(filepath.Join(tmpdir, "folder"), nil)
+	storer.dedup = hashes
+	return tmpdir, storer
+}
+
+func readNewFiles(t *testing.T, tmpdir string) []os.DirEntry {
+	files, err := os.ReadDir(filepath.Join(tmpdir, "folder", "new"))
+	require.NoError(t, err)
+	return files
+}
+
+func TestDedupHardlink(t *testing.T) {
+	tmpdir, storer := setUpDedupStorer(t, DedupHardlink)
+
+	for idx, key := range []string{"42/1", "42/2", "42/3"} {
+		content := "some content"
+		if idx == 2 {
+			content = "other content"
+		}
+		err := storer.Write(EmailInfo{Key: key}, strings.NewReader(content))
+		assert.NoError(t, err)
+	}
+
+	files := readNewFiles(t, tmpdir)
+	require.Equal(t, 3, len(files))
+	var duplicates []os.FileInfo
+	for _, file := range files {
+		path := filepath.Join(tmpdir, "folder", "new", file.Name())
+		content, err := os.ReadFile(path) // nolint: gosec
+		require.NoError(t, err)
+		if string(content) == "some content" {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			duplicates = append(duplicates, info)
+		}
+	}
+	require.Equal(t, 2, len(duplicates))
+	assert.True(t, os.SameFile(duplicates[0], duplicates[1]))
+
+	// Only the first copy of each content is remembered.
+	content, err := os.ReadFile(filepath.Join(tmpdir, hashStoreName)) // nolint: gosec
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+}
+
+func TestDedupSymlink(t *testing.T) {
+	tmpdir, storer := setUpDedupStorer(t, DedupSymlink)
+
+	for _, key := range []string{"42/1", "42/2"} {
+		err := storer.Write(EmailInfo{Key: key}, strings.NewReader("some content"))
+		assert.NoError(t, err)
+	}
+
+	files := readNewFiles(t, tmpdir)
+	require.Equal(t, 2, len(files))
+	var links int
+	for _, file := range files {
+		path := filepath.Join(tmpdir, "folder", "new", file.Name())
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		if info.Mode()&os.ModeSymlink != 0 {
+			links++
+			target, err := os.Readlink(path)
+			assert.NoError(t, err)
+			assert.False(t, filepath.IsAbs(target))
+			// Links remain valid when a mail client moves them to "cur".
+			curPath := filepath.Join(tmpdir, "folder", "cur", file.Name()+":2,S")
+			require.NoError(t, os.Rename(path, curPath))
+			path = curPath
+		}
+		// Both files provide the content.
+		content, err := os.ReadFile(path) // nolint: gosec
+		assert.NoError(t, err)
+		assert.Equal(t, "some content", string(content))
+	}
+	assert.Equal(t, 1, links)
+	// Links are created in the tmp directory, which is left empty.
+	entries, err := os.ReadDir(filepath.Join(tmpdir, "folder", "tmp"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDedupPersisted(t *testing.T) {
+	tmpdir, storer := setUpDedupStorer(t, DedupHardlink)
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	require.NoError(t, err)
+
+	// A new run knows about emails stored before.
+	hashes, err := openHashStore(tmpdir, DedupHardlink)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(hashes.hashes))
+	storer = newMaildirStorer(filepath.Join(tmpdir, "folder"), nil)
+	storer.dedup = hashes
+	err = storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("some content"))
+	require.NoError(t, err)
+
+	files := readNewFiles(t, tmpdir)
+	require.Equal(t, 2, len(files))
+	first, err := os.Stat(filepath.Join(tmpdir, "folder", "new", files[0].Name()))
+	require.NoError(t, err)
+	second, err := os.Stat(filepath.Join(tmpdir, "folder", "new", files[1].Name()))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(first, second))
+}
+
+func TestDedupMovedFile(t *testing.T) {
+	tmpdir, storer := setUpDedupStorer(t, DedupHardlink)
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	require.NoError(t, err)
+	// Simulate a mail client moving the file.
+	files := readNewFiles(t, tmpdir)
+	require.Equal(t, 1, len(files))
+	err = os.Rename(
+		filepath.Join(tmpdir, "folder", "new", files[0].Name()),
+		filepath.Join(tmpdir, "folder", "cur", files[0].Name()+":2,S"),
+	)
+	require.NoError(t, err)
+
+	err = storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("some content"))
+	assert.NoError(t, err)
+
+	// The new file is kept as a full copy and replaces the remembered one.
+	files = readNewFiles(t, tmpdir)
+	require.Equal(t, 1, len(files))
+	relPath := filepath.Join("folder", "new", files[0].Name())
+	assert.Equal(t, relPath, storer.dedup.hashes[firstHash(t, storer.dedup)])
+}
+
+func firstHash(t *testing.T, store *hashStore) string {
+	require.Equal(t, 1, len(store.hashes))
+	for hash := range store.hashes {
+		return hash
+	}
+	return ""
+}
+
+func TestDedupLinkFailureKeepsCopy(t *testing.T) {
+	tmpdir, storer := setUpDedupStorer(t, DedupHardlink)
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	require.NoError(t, err)
+	// Linking fails since a directory is in the way of the temporary link.
+	hash := firstHash(t, storer.dedup)
+	existing := filepath.Join(tmpdir, storer.dedup.hashes[hash])
+	path := filepath.Join(tmpdir, "folder", "new", "copy")
+	require.NoError(t, os.WriteFile(path, []byte("some content"), filePerm))
+	require.NoError(t, os.Mkdir(filepath.Join(tmpdir, "folder", "tmp", "copy.link"), dirPerm))
+
+	err = storer.dedup.replaceByLink(existing, path)
+	assert.Error(t, err)
+
+	content, err := os.ReadFile(path) // nolint: gosec
+	assert.NoError(t, err)
+	assert.Equal(t, "some content", string(content))
+}
+
+func TestOpenHashStoreMalformed(t *testing.T) {
+	tmpdir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tmpdir, hashStoreName), []byte("no-space\n"), filePerm)
+	require.NoError(t, err)
+
+	_, err = openHashStore(tmpdir, DedupHardlink)
+	assert.ErrorContains(t, err, "malformed line")
+}
+
+func TestOpenHashStoreLaterEntriesWin(t *testing.T) {
+	tmpdir := t.TempDir()
+	err := os.WriteFile(
+		filepath.Join(tmpdir, hashStoreName), []byte("abc first\nabc second\n"), filePerm,
+	)
+	require.NoError(t, err)
+
+	store, err := openHashStore(tmpdir, DedupSymlink)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"abc": "second"}, store.hashes)
+}
@@ -17,28 +17,30 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 package core
 
-import "sync"
+import (
+	"io"
+	"sync"
+	"time"
+)
 
 type deliverOps interface {
-	deliverMessage(string, string) error
-	rfc822FromEmail(emailOps, uidFolder) (string, oldmail, error)
+	rfc822FromEmail(emailOps, uidFolder) (io.Reader, oldmail, error)
 }
 
 type deliverer struct{}
 
-func (d deliverer) deliverMessage(text string, maildirPath string) error {
-	return deliverMessage(text, maildirPath)
-}
-
-func (d deliverer) rfc822FromEmail(msg emailOps, uidFolder uidFolder) (string, oldmail, error) {
+func (d deliverer) rfc822FromEmail(
+	msg emailOps, uidFolder uidFolder,
+) (io.Reader, oldmail, error) {
 	return rfc822FromEmail(msg, uidFolder)
 }
 
 func streamingDelivery(
 	ops deliverOps,
 	messageChan <-chan emailOps,
-	maildirPath string,
+	storer Storer,
 	uidFolder uidFolder,
+	dateFallback DateFallback,
 	wg, stwg *sync.WaitGroup,
 ) (returnedChan <-chan oldmail, errCountPtr *int) {
 	var errCount int
@@ -50,10 +52,16 @@ func streamingDelivery(
 		// Do not start before the entire pipeline has been set up.
 		stwg.Wait()
 		for msg := range messageChan {
-			// Deliver each email to the `tmp` directory and move them to the `new` directory.
-			text, oldmail, err := ops.rfc822FromEmail(msg, uidFolder)
-			if err == nil {
-				err = ops.deliverMessage(text, maildirPath)
+			// Hand each email over to the storer. For maildirs, that means delivering it to the
+			// `tmp` directory and moving it to the `new` directory.
+			content, oldmail, err := ops.rfc822FromEmail(msg, uidFolder)
+			if err == nil && !usableDate(time.Unix(int64(oldmail.timestamp), 0)) {
+				oldmail, content, err = fallBackDate(oldmail, content, dateFallback)
+			}
+			if q, ok := storer.(quarantiner); ok && err != nil {
+				err = q.quarantineEmail(msg, uidFolder, err)
+			} else if err == nil {
+				err = storer.Write(oldmail.info(), content)
 			}
 			if err != nil {
 				logError(err.Error())
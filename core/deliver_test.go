@@ -19,7 +19,8 @@ package core
 
 import (
 	"fmt"
-	"path/filepath"
+	"io"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -32,26 +33,11 @@ type mockDeliverer struct {
 	mock.Mock
 }
 
-func (m *mockDeliverer) deliverMessage(text string, maildirPath string) error {
-	args := m.Called(text, maildirPath)
-	return args.Error(0)
-}
-
 func (m *mockDeliverer) rfc822FromEmail(
 	msg emailOps, uidFolder uidFolder,
-) (string, oldmail, error) {
+) (io.Reader, oldmail, error) {
 	args := m.Called(msg, uidFolder)
-	return args.String(0), args.Get(1).(oldmail), args.Error(2)
-}
-
-func TestDelivererDeliverMessage(t *testing.T) {
-	tmpdir := t.TempDir()
-	missingDir := filepath.Join(tmpdir, "some", "dir", "that", "surely", "does", "not", "exist")
-
-	deliverer := &deliverer{}
-	err := deliverer.deliverMessage("some text", missingDir)
-
-	assert.Error(t, err)
+	return strings.NewReader(args.String(0)), args.Get(1).(oldmail), args.Error(2)
 }
 
 func TestDelivererRFC822FromEmail(t *testing.T) {
@@ -65,8 +51,37 @@ func TestDelivererRFC822FromEmail(t *testing.T) {
 	msg.AssertExpectations(t)
 }
 
+func TestStreamingDeliveryMissingInternalDate(t *testing.T) {
+	content := "Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n\r\nbody"
+	msg := &mockEmail{}
+	msg.On("Format").Return([]interface{}{uint32(7), "rfc822 header", content})
+	date := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7", InternalDate: date}, content).Return(nil)
+
+	msgChan := make(chan emailOps, 1)
+	msgChan <- msg
+	close(msgChan)
+	var wg, stwg sync.WaitGroup
+
+	oldmailChan, errCountPtr := streamingDelivery(
+		deliverer{}, msgChan, ms, 42, DateFallbackHeader, &wg, &stwg,
+	)
+	oldmails := []oldmail{}
+	for om := range oldmailChan {
+		oldmails = append(oldmails, om)
+	}
+	wg.Wait()
+
+	// The date from the header is remembered, too.
+	assert.Zero(t, *errCountPtr)
+	assert.Equal(t, []oldmail{{uidFolder: 42, uid: 7, timestamp: int(date.Unix())}}, oldmails)
+	ms.AssertExpectations(t)
+}
+
 func TestStreamingDeliverySuccessDespiteOneError(t *testing.T) {
 	m := &mockDeliverer{}
+	ms := &mockStorer{}
 
 	mockEmails := []*mockEmail{}
 	for i := 0; i < 10; i++ {
@@ -83,7 +98,7 @@ func TestStreamingDeliverySuccessDespiteOneError(t *testing.T) {
 		}
 		m.On("rfc822FromEmail", msg, uidFolder(42)).Return("actual content", om, formatErr)
 		if formatErr == nil {
-			m.On("deliverMessage", "actual content", "/some/path").Return(nil)
+			ms.On("Write", om.info(), "actual content").Return(nil)
 		}
 	}
 
@@ -101,15 +116,14 @@ func TestStreamingDeliverySuccessDespiteOneError(t *testing.T) {
 	stwg.Add(1)
 
 	uidFolder := uidFolder(42)
-	maildir := "/some/path"
 
-	oldmailChan, errCountPtr := streamingDelivery(m, msgChan, maildir, uidFolder, &wg, &stwg)
+	oldmailChan, errCountPtr := streamingDelivery(m, msgChan, ms, uidFolder, "", &wg, &stwg)
 	assert.Zero(t, *errCountPtr)
 
 	// Wait a while and check that nothing has happened yet.
 	time.Sleep(time.Millisecond * 100) // nolint: gomnd
 	m.AssertNotCalled(t, "rfc822FromEmail", mock.Anything, mock.Anything)
-	m.AssertNotCalled(t, "deliverMessage", mock.Anything, mock.Anything)
+	ms.AssertNotCalled(t, "Write", mock.Anything, mock.Anything)
 
 	// Actually trigger operations and read from output channel.
 	stwg.Done()
@@ -120,6 +134,7 @@ func TestStreamingDeliverySuccessDespiteOneError(t *testing.T) {
 	wg.Wait()
 
 	m.AssertExpectations(t)
+	ms.AssertExpectations(t)
 	assert.Equal(t, 1, *errCountPtr)
 	assert.Equal(t, 9, len(oldmails))
 }
@@ -18,8 +18,10 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/emersion/go-imap"
 )
@@ -32,26 +34,77 @@ const (
 type downloadOps interface {
 	selectFolder(folder string) (*imap.MailboxStatus, error)
 	getAllMessageUUIDs(*imap.MailboxStatus) ([]uidExt, error)
+	highestModseq(folder string) (uint64, error)
+	folderStatus(folder string) (folderStatus, error)
+	getChangedMessageUUIDs(*imap.MailboxStatus, uint64) ([]uidExt, error)
+	getSeqRangeUUIDs(mbox *imap.MailboxStatus, start, end uint32) ([]uidExt, error)
+	moveEmails(folder string, uids []uid, target string) error
+	deleteEmails(folder string, uids []uid) error
+	getMetadata(folder string) (map[string]string, error)
+	getMessageIDs(uids []uid) (map[uid]string, error)
 	streamingOldmailWriteout(<-chan oldmail, string, *sync.WaitGroup, *sync.WaitGroup) (*int, error)
 	streamingRetrieval(
-		[]uid, *sync.WaitGroup, *sync.WaitGroup, func() bool,
+		[]uid, []imap.FetchItem, int, *sync.WaitGroup, *sync.WaitGroup, func() bool,
 	) (<-chan emailOps, *int, error)
 	streamingDelivery(
-		<-chan emailOps, string, uidFolder, *sync.WaitGroup, *sync.WaitGroup,
+		<-chan emailOps, Storer, uidFolder, DateFallback, *sync.WaitGroup, *sync.WaitGroup,
 	) (<-chan oldmail, *int)
+	startKeepalive(interval time.Duration) (stop func())
 }
 
 type downloader struct {
 	imapOps    imapOps
 	deliverOps deliverOps
+	buffers    bufferSizes
+	keepalive  *keepalive
+	extensions syncExtensions
 }
 
 func (d downloader) selectFolder(folder string) (*imap.MailboxStatus, error) {
 	return selectFolder(d.imapOps, folder)
 }
 
+func (d downloader) moveEmails(folder string, uids []uid, target string) error {
+	return moveEmails(d.imapOps, folder, uids, target)
+}
+
+func (d downloader) deleteEmails(folder string, uids []uid) error {
+	return deleteEmails(d.imapOps, folder, uids)
+}
+
+func (d downloader) getMetadata(folder string) (map[string]string, error) {
+	return getMetadata(d.imapOps, folder)
+}
+
+func (d downloader) getMessageIDs(uids []uid) (map[uid]string, error) {
+	return getMessageIDs(d.imapOps, uids, d.buffers.messages)
+}
+
 func (d downloader) getAllMessageUUIDs(mbox *imap.MailboxStatus) ([]uidExt, error) {
-	return getAllMessageUUIDs(mbox, d.imapOps)
+	return getAllMessageUUIDs(mbox, d.imapOps, d.buffers.messages)
+}
+
+func (d downloader) highestModseq(folder string) (uint64, error) {
+	if !d.extensions.modseqUsable() {
+		return 0, nil
+	}
+	return getHighestModseq(d.imapOps, folder)
+}
+
+func (d downloader) folderStatus(folder string) (folderStatus, error) {
+	return getFolderStatus(d.imapOps, folder)
+}
+
+func (d downloader) getChangedMessageUUIDs(
+	mbox *imap.MailboxStatus, modseq uint64,
+) ([]uidExt, error) {
+	return getChangedMessageUUIDs(mbox, d.imapOps, modseq, d.buffers.messages)
+}
+
+func (d downloader) getSeqRangeUUIDs(
+	mbox *imap.MailboxStatus, start, end uint32,
+) ([]uidExt, error) {
+	return getSeqRangeUUIDs(mbox, d.imapOps, start, end, d.buffers.messages)
 }
 
 func (d downloader) streamingOldmailWriteout(
@@ -62,31 +115,117 @@ func (d downloader) streamingOldmailWriteout(
 
 func (d downloader) streamingRetrieval(
 	missingUIDs []uid,
+	fetchItems []imap.FetchItem,
+	batchSize int,
 	wg, startWg *sync.WaitGroup,
 	interrupted func() bool,
 ) (<-chan emailOps, *int, error) {
-	return streamingRetrieval(d.imapOps, missingUIDs, wg, startWg, interrupted)
+	return streamingRetrieval(
+		d.keepalive.wrap(d.imapOps), missingUIDs, fetchItems, batchSize, d.buffers.messages, wg, startWg,
+		interrupted,
+	)
+}
+
+func (d downloader) startKeepalive(interval time.Duration) func() {
+	return d.keepalive.start(d.imapOps, interval)
 }
 
 func (d downloader) streamingDelivery(
 	messageChan <-chan emailOps,
-	maildirPath string,
+	storer Storer,
 	uidFolder uidFolder,
+	dateFallback DateFallback,
 	wg, startWg *sync.WaitGroup,
 ) (<-chan oldmail, *int) {
-	return streamingDelivery(d.deliverOps, messageChan, maildirPath, uidFolder, wg, startWg)
+	return streamingDelivery(
+		d.deliverOps, messageChan, storer, uidFolder, dateFallback, wg, startWg,
+	)
 }
 
 func downloadMissingEmailsToFolder(
-	ops downloadOps, maildirPath maildirPathT, oldmailName string, sig interruptOps,
+	ops downloadOps,
+	maildirPath maildirPathT,
+	oldmailName string,
+	sig interruptOps,
+	opts DownloadOptions,
 ) (err error) {
-	oldmails, oldmailPath, err := initMaildir(oldmailName, maildirPath)
+	err = opts.check()
+	// Statistics are recorded last, i.e. once all emails have been handled.
+	start := now()
+	var counted *progress
+	var filtered *addressFilterStorer
+	var skipped, total int
+	defer func() {
+		opts.Summary.recordCounts(
+			opts.account, maildirPath.folderName(), counted, skipped, filtered.count(), total,
+			start,
+		)
+	}()
+	// The lock is released last, i.e. after the storer has been closed and the index updated.
+	var unlock func()
+	if err == nil {
+		unlock, err = lockFolder(oldmailName, maildirPath)
+	}
+	if unlock != nil {
+		defer unlock()
+	}
+	var oldmails []oldmail
+	var oldmailPath string
+	if err == nil {
+		oldmails, oldmailPath, err = initMaildir(oldmailName, maildirPath)
+	}
+	if err == nil && opts.Metadata {
+		err = backUpMetadata(ops, maildirPath)
+	}
+	// Unchanged folders are skipped before anything else is retrieved from the server.
+	var status folderStatus
+	if err == nil && opts.OnlyChanged {
+		var unchanged bool
+		if status, unchanged = unchangedFolder(ops, maildirPath); unchanged && !opts.Refresh {
+			logInfo(fmt.Sprintf(
+				"skipping folder %s, which has not changed since the last download",
+				maildirPath.folderName(),
+			))
+			return nil
+		}
+	}
+	if err == nil && opts.Manifest {
+		var known manifest
+		known, err = readManifest(maildirPath.folderPath(), opts.CompressManifest)
+		oldmails = append(oldmails, known.missingOldmails(oldmails)...)
+	}
+	var storer Storer
+	if err == nil {
+		storer, err = opts.newStorer(maildirPath, oldmails)
+	}
+	// Emails are moved or deleted on the server only once all of them have been stored, i.e. after
+	// the storer has been closed successfully. Only emails that have been written successfully are
+	// moved or deleted.
+	var stored *recordingStorer
+	defer func() {
+		if closeErr := closeStorer(storer); err == nil {
+			err = closeErr
+		}
+		if err == nil && stored != nil && opts.MoveTo != "" {
+			err = ops.moveEmails(maildirPath.folderName(), stored.uids, opts.MoveTo)
+		} else if err == nil && stored != nil {
+			err = ops.deleteEmails(maildirPath.folderName(), stored.uids)
+		}
+	}()
+	// The highest modification sequence has to be determined before selecting the folder.
+	var lastState, state modseqState
+	if err == nil {
+		lastState, state.modseq = modseqStates(ops, maildirPath)
+	}
 	var mbox *imap.MailboxStatus
 	if err == nil {
 		mbox, err = ops.selectFolder(maildirPath.folderName())
 	}
 	// Retrieve information about which emails are present on the remote system and check which ones
-	// are missing when comparing against those on disk.
+	// are missing when comparing against those in storage. Mirror mode needs to know about all
+	// emails present on the server. So does filtering by size or UID because emails excluded that
+	// way would otherwise never be considered again, e.g. after changing the bounds. Refreshing
+	// ignores the state of the last download.
 	var uidFold uidFolder
 	var uids []uidExt
 	if err == nil && sig.interrupted() {
@@ -94,30 +233,153 @@ func downloadMissingEmailsToFolder(
 	}
 	if err == nil {
 		uidFold = uidFolder(mbox.UidValidity)
-		uids, err = ops.getAllMessageUUIDs(mbox)
+		state.folder = uidFold
+	}
+	if err == nil && opts.seqRange() {
+		uids, err = ops.getSeqRangeUUIDs(
+			mbox, intToUint32(opts.SeqStart), intToUint32(opts.SeqEnd),
+		)
+	} else if err == nil && opts.UIDFile == "" {
+		fullList := opts.Mirror || opts.excludesEmails() || opts.Refresh
+		uids, err = listCandidateUIDs(ops, mbox, lastState, state, fullList)
+	}
+	// An incomplete list of emails still allows downloading those that are listed. However,
+	// unlisted emails must not be considered deleted.
+	incomplete := errors.Is(err, errUIDCountMismatch) && !opts.StrictUIDCount
+	if incomplete {
+		logWarning(fmt.Sprintf("continuing with incomplete list of emails: %s", err.Error()))
+		err = nil
+	}
+	if err == nil && opts.Mirror && incomplete {
+		logWarning("not moving deleted emails since the list of emails is incomplete")
+	} else if err == nil && opts.Mirror {
+		err = mirrorDeletions(maildirPath.folderPath(), oldmails, uids, uidFold)
 	}
 	var missingUIDs []uid
-	if err == nil {
-		missingUIDs, err = determineMissingUIDs(oldmails, uids)
+	if err == nil && opts.UIDFile != "" {
+		missingUIDs, err = readUIDFile(opts.UIDFile, uidFold)
+		opts.order(missingUIDs)
+	} else if err == nil {
+		candidates := opts.filterBySize(opts.filterSinceUID(uids, uidFold))
+		if opts.Refresh {
+			missingUIDs = allUIDs(candidates)
+		} else {
+			missingUIDs, err = determineMissingUIDs(oldmails, candidates, storer)
+		}
+		if err == nil {
+			missingUIDs, err = opts.skipReferenced(ops, missingUIDs)
+		}
+		opts.order(missingUIDs)
+		skipped = len(candidates) - len(missingUIDs)
 	}
-	total := len(missingUIDs)
+	total = len(missingUIDs)
 	logInfo(fmt.Sprintf("will download %d new emails", total))
-	if err != nil || total == 0 {
-		return err
+	// Preparing storage and delivering emails might take long enough for servers to consider the
+	// connection idle. The keepalive has to be stopped before moving emails on the server.
+	if err == nil && total > 0 && opts.KeepaliveInterval > 0 {
+		defer ops.startKeepalive(opts.KeepaliveInterval)()
+	}
+	var recorded *manifestStorer
+	var audited *auditStorer
+	if err == nil && total > 0 {
+		primary := storer
+		if opts.Manifest {
+			recorded = &manifestStorer{Storer: storer}
+			primary = recorded
+		}
+		if opts.Audit {
+			audited = &auditStorer{Storer: primary, source: opts.source}
+			primary = audited
+		}
+		if opts.Summary != nil {
+			counted = newProgress(maildirPath.folderName(), total)
+			primary = counted.wrap(primary)
+		}
+		tracked, done := opts.trackProgress(
+			maildirPath.folderName(), total, opts.meterThroughput(primary),
+		)
+		// Line endings are converted last since filtering parts rewrites delimiters with CRLF.
+		converted := opts.filterParts(opts.convertLineEndings(tracked))
+		validated := opts.transform(opts.validate(converted))
+		// Emails are filtered by their addresses as retrieved, i.e. before transforming them.
+		if filtered = opts.filterByAddress(validated); filtered != nil {
+			validated = filtered
+		}
+		if opts.MoveTo != "" || opts.DeleteFromServer {
+			stored = &recordingStorer{Storer: validated}
+			validated = stored
+		}
+		// Emails are quarantined last so that those rejected by any other storer are kept, too.
+		quarantined := opts.quarantine(opts.throttle(validated), maildirPath.folderName())
+		err = downloadEmails(
+			ops, maildirPath.folderName(), missingUIDs, quarantined, uidFold, oldmailPath, sig,
+			opts,
+		)
+		done()
+		verifyErr := verifyDownloadCount(
+			oldmailPath, uidFold, missingUIDs, err != nil, opts.VerifyCount,
+		)
+		if err == nil {
+			err = verifyErr
+		}
+	}
+	// Emails that have been stored are listed even if others could not be downloaded.
+	if opts.Manifest && (err == nil || recorded != nil) {
+		var entries []manifestEntry
+		if recorded != nil {
+			entries = recorded.entries
+		}
+		err = errors.Join(err, updateManifest(
+			maildirPath.folderPath(), maildirPath.folderName(), entries, oldmailPath,
+			opts.CompressManifest,
+		))
 	}
+	// Emails that have been stored are recorded even if others could not be downloaded.
+	if audited != nil && len(audited.records) > 0 {
+		err = errors.Join(err, appendAuditLog(maildirPath.folderPath(), audited.records))
+	}
+	// The emails have been stored even if the list of their file names cannot be compacted.
+	if compactErr := compactUIDList(maildirPath.folderPath()); compactErr != nil {
+		logWarning(fmt.Sprintf("cannot compact list of file names: %s", compactErr.Error()))
+	}
+	// Only remember the state of the folder if all emails have been downloaded. Otherwise, those
+	// that failed or have been excluded would not be considered again.
+	if err == nil && state.modseq > 0 && !opts.excludesEmails() && opts.UIDFile == "" &&
+		!incomplete {
+		err = writeModseqState(maildirPath.folderPath(), state)
+	}
+	if err == nil && status.uidNext > 0 && status.folder == uidFold && !opts.excludesEmails() &&
+		!incomplete {
+		err = writeFolderStatus(maildirPath.folderPath(), status)
+	}
+	return err
+}
 
+// Set up the download pipeline consisting of retrieval, delivery to storage, and oldmail writeout
+// for the given UIDs, run it, and report on errors.
+func downloadEmails(
+	ops downloadOps,
+	folder string,
+	missingUIDs []uid,
+	storer Storer,
+	uidFold uidFolder,
+	oldmailPath string,
+	sig interruptOps,
+	opts DownloadOptions,
+) (err error) {
 	var wg, startWg sync.WaitGroup
 	startWg.Add(1) // startWg is used to defer operations until the pipeline is set up.
 	// Retrieve email information. This does not download the emails themselves yet.
-	messageChan, fetchErrCount, err := ops.streamingRetrieval(
-		missingUIDs, &wg, &startWg, sig.interrupted,
+	messageChan, fetchErrCount, logoutClients, err := opts.parallelRetrieval(
+		ops, folder, uidFold, missingUIDs, &wg, &startWg, sig.interrupted,
 	)
+	defer logoutClients()
 	var deliveredChan <-chan oldmail
 	var deliverErrCount, oldmailErrCount *int
 	if err == nil {
-		// Download missing emails and store them on disk.
+		// Download missing emails and store them.
 		deliveredChan, deliverErrCount = ops.streamingDelivery(
-			messageChan, maildirPath.folderPath(), uidFold, &wg, &startWg,
+			messageChan, storer, uidFold, opts.DateFallback, &wg, &startWg,
 		)
 		// Retrieve and write out information about all emails.
 		oldmailErrCount, err = ops.streamingOldmailWriteout(
@@ -19,13 +19,16 @@ package core
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type mockDownloader struct {
@@ -47,6 +50,50 @@ func (m *mockDownloader) getAllMessageUUIDs(mbox *imap.MailboxStatus) ([]uidExt,
 	return args.Get(0).([]uidExt), args.Error(1)
 }
 
+func (m *mockDownloader) highestModseq(folder string) (uint64, error) {
+	args := m.Called(folder)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *mockDownloader) folderStatus(folder string) (folderStatus, error) {
+	args := m.Called(folder)
+	return args.Get(0).(folderStatus), args.Error(1)
+}
+
+func (m *mockDownloader) moveEmails(folder string, uids []uid, target string) error {
+	args := m.Called(folder, uids, target)
+	return args.Error(0)
+}
+
+func (m *mockDownloader) deleteEmails(folder string, uids []uid) error {
+	args := m.Called(folder, uids)
+	return args.Error(0)
+}
+
+func (m *mockDownloader) getMetadata(folder string) (map[string]string, error) {
+	args := m.Called(folder)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
+func (m *mockDownloader) getMessageIDs(uids []uid) (map[uid]string, error) {
+	args := m.Called(uids)
+	return args.Get(0).(map[uid]string), args.Error(1)
+}
+
+func (m *mockDownloader) getChangedMessageUUIDs(
+	mbox *imap.MailboxStatus, modseq uint64,
+) ([]uidExt, error) {
+	args := m.Called(mbox, modseq)
+	return args.Get(0).([]uidExt), args.Error(1)
+}
+
+func (m *mockDownloader) getSeqRangeUUIDs(
+	mbox *imap.MailboxStatus, start, end uint32,
+) ([]uidExt, error) {
+	args := m.Called(mbox, start, end)
+	return args.Get(0).([]uidExt), args.Error(1)
+}
+
 func (m *mockDownloader) streamingOldmailWriteout(
 	deliveredChan <-chan oldmail, oldmailPath string, wg, startWg *sync.WaitGroup,
 ) (*int, error) {
@@ -65,9 +112,13 @@ func (m *mockDownloader) streamingOldmailWriteout(
 }
 
 func (m *mockDownloader) streamingRetrieval(
-	missingUIDs []uid, wg, startWg *sync.WaitGroup, in func() bool,
+	missingUIDs []uid,
+	items []imap.FetchItem,
+	batchSize int,
+	wg, startWg *sync.WaitGroup,
+	in func() bool,
 ) (<-chan emailOps, *int, error) {
-	args := m.Called(missingUIDs, wg, startWg, in)
+	args := m.Called(missingUIDs, items, batchSize, wg, startWg, in)
 	wg.Add(1)
 	go func() {
 		startWg.Wait()
@@ -81,13 +132,19 @@ func (m *mockDownloader) streamingRetrieval(
 	return args.Get(0).(chan emailOps), args.Get(1).(*int), args.Error(2)
 }
 
+func (m *mockDownloader) startKeepalive(interval time.Duration) func() {
+	args := m.Called(interval)
+	return args.Get(0).(func())
+}
+
 func (m *mockDownloader) streamingDelivery(
 	messageChan <-chan emailOps,
-	maildirPath string,
+	storer Storer,
 	uidFolder uidFolder,
+	dateFallback DateFallback,
 	wg, startWg *sync.WaitGroup,
 ) (<-chan oldmail, *int) {
-	args := m.Called(messageChan, maildirPath, uidFolder, wg, startWg)
+	args := m.Called(messageChan, storer, uidFolder, dateFallback, wg, startWg)
 	wg.Add(1)
 	go func() {
 		startWg.Wait()
@@ -146,26 +203,170 @@ func TestDownloadMissingEmailsToFolderSuccess(t *testing.T) {
 	mi := &mockInterrupter{}
 	mi.On("interrupted").Return(false)
 
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return(uids, nil)
+	m.On("streamingRetrieval",
+		missingUIDs, DownloadOptions{}.fetchItems(), 0, mock.Anything, mock.Anything,
+		// We cannot use functions in expectations. Thus use this construct instead.
+		mock.AnythingOfType("func() bool"),
+	).Return(messageChan, &fetchErrCount, nil)
+	uidFolder := uidFolder(42)
+	m.On(
+		"streamingDelivery", inMessageChan,
+		&validatingStorer{Storer: newMaildirStorer(folderPath, nil)}, uidFolder,
+		DateFallback(""), mock.Anything, mock.Anything,
+	).Return(deliveredChan, &deliverErrCount)
+	m.On("streamingOldmailWriteout", inDeliveredChan, oldmailPath, mock.Anything, mock.Anything).
+		Return(&oldmailErrCount, nil)
+
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, DownloadOptions{})
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+	mi.AssertExpectations(t)
+}
+
+func TestDownloadMissingEmailsToFolderKeepalive(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	folderPath := maildirPath.folderPath()
+	oldmailFileName := "some-oldmail-file"
+	oldmailPath := filepath.Join(tmpdir, oldmailFileName)
+
+	mbox := &imap.MailboxStatus{
+		Name:        "some-folder",
+		UidValidity: 42,
+		Messages:    3,
+	}
+	uids := []uidExt{
+		{folder: 42, msg: 1}, {folder: 42, msg: 2}, {folder: 42, msg: 3},
+	}
+	missingUIDs := []uid{1, 2, 3}
+
+	messages := []*mockEmail{
+		{uid: 1}, {uid: 2}, {uid: 3},
+	}
+	messageChan := make(chan emailOps)
+	var inMessageChan <-chan emailOps = messageChan
+	var fetchErrCount int
+
+	delivered := []oldmail{
+		{uidFolder: 42, uid: 1}, {uidFolder: 42, uid: 2}, {uidFolder: 42, uid: 3},
+	}
+	deliveredChan := make(chan oldmail)
+	var inDeliveredChan <-chan oldmail = deliveredChan
+	var deliverErrCount int
+	var oldmailErrCount int
+
+	m := &mockDownloader{
+		t:             t,
+		messages:      messages,
+		messageChan:   messageChan,
+		delivered:     delivered,
+		deliveredChan: deliveredChan,
+	}
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
 	m.On("selectFolder", "some-folder").Return(mbox, nil)
 	m.On("getAllMessageUUIDs", mbox).Return(uids, nil)
 	m.On("streamingRetrieval",
-		missingUIDs, mock.Anything, mock.Anything,
+		missingUIDs, DownloadOptions{}.fetchItems(), 0, mock.Anything, mock.Anything,
 		// We cannot use functions in expectations. Thus use this construct instead.
 		mock.AnythingOfType("func() bool"),
 	).Return(messageChan, &fetchErrCount, nil)
 	uidFolder := uidFolder(42)
-	m.On("streamingDelivery", inMessageChan, folderPath, uidFolder, mock.Anything, mock.Anything).
-		Return(deliveredChan, &deliverErrCount)
+	m.On(
+		"streamingDelivery", inMessageChan,
+		&validatingStorer{Storer: newMaildirStorer(folderPath, nil)}, uidFolder,
+		DateFallback(""), mock.Anything, mock.Anything,
+	).Return(deliveredChan, &deliverErrCount)
 	m.On("streamingOldmailWriteout", inDeliveredChan, oldmailPath, mock.Anything, mock.Anything).
 		Return(&oldmailErrCount, nil)
+	stopped := false
+	m.On("startKeepalive", time.Minute).Return(func() { stopped = true })
 
-	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi)
+	opts := DownloadOptions{KeepaliveInterval: time.Minute}
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, opts)
 
 	assert.NoError(t, err)
+	assert.True(t, stopped)
 	m.AssertExpectations(t)
 	mi.AssertExpectations(t)
 }
 
+func TestDownloadMissingEmailsToFolderDeleteFromServer(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	oldmailFileName := "some-oldmail-file"
+	oldmailPath := filepath.Join(tmpdir, oldmailFileName)
+
+	mbox := &imap.MailboxStatus{
+		Name:        "some-folder",
+		UidValidity: 42,
+		Messages:    3,
+	}
+	uids := []uidExt{
+		{folder: 42, msg: 1}, {folder: 42, msg: 2}, {folder: 42, msg: 3},
+	}
+	missingUIDs := []uid{1, 2, 3}
+
+	messages := []*mockEmail{
+		{uid: 1}, {uid: 2}, {uid: 3},
+	}
+	messageChan := make(chan emailOps)
+	var inMessageChan <-chan emailOps = messageChan
+	var fetchErrCount int
+
+	delivered := []oldmail{
+		{uidFolder: 42, uid: 1}, {uidFolder: 42, uid: 2}, {uidFolder: 42, uid: 3},
+	}
+	deliveredChan := make(chan oldmail)
+	var inDeliveredChan <-chan oldmail = deliveredChan
+	var deliverErrCount int
+	var oldmailErrCount int
+
+	m := &mockDownloader{
+		t:             t,
+		messages:      messages,
+		messageChan:   messageChan,
+		delivered:     delivered,
+		deliveredChan: deliveredChan,
+	}
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return(uids, nil)
+	m.On("streamingRetrieval",
+		missingUIDs, DownloadOptions{}.fetchItems(), 0, mock.Anything, mock.Anything,
+		mock.AnythingOfType("func() bool"),
+	).Return(messageChan, &fetchErrCount, nil)
+	m.On(
+		"streamingDelivery", inMessageChan, mock.AnythingOfType("*core.recordingStorer"),
+		uidFolder(42), DateFallback(""), mock.Anything, mock.Anything,
+	).Return(deliveredChan, &deliverErrCount).Run(func(args mock.Arguments) {
+		// Pretend that only some emails could be stored.
+		args.Get(1).(*recordingStorer).uids = []uid{1, 3}
+	})
+	m.On("streamingOldmailWriteout", inDeliveredChan, oldmailPath, mock.Anything, mock.Anything).
+		Return(&oldmailErrCount, nil)
+	m.On("deleteEmails", "some-folder", []uid{1, 3}).Return(nil)
+
+	opts := DownloadOptions{DeleteFromServer: true}
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, opts)
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "moveEmails", mock.Anything, mock.Anything, mock.Anything)
+	mi.AssertExpectations(t)
+}
+
 func TestDownloadMissingEmailsToFolderPreparationError(t *testing.T) {
 	tmpdir := t.TempDir()
 	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
@@ -179,12 +380,13 @@ func TestDownloadMissingEmailsToFolderPreparationError(t *testing.T) {
 
 	m := &mockDownloader{t: t}
 
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
 	m.On("selectFolder", "some-folder").Return(mbox, nil)
 
 	mi := &mockInterrupter{}
 	mi.On("interrupted").Return(true) // Simulate an interrupt.
 
-	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi)
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, DownloadOptions{})
 
 	assert.Error(t, err)
 	assert.Equal(t, "aborting due to user interrupt", err.Error())
@@ -206,16 +408,202 @@ func TestDownloadMissingEmailsToFolderPreparationNoNewEmails(t *testing.T) {
 
 	m := &mockDownloader{t: t}
 
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
 	m.On("selectFolder", "some-folder").Return(mbox, nil)
 	m.On("getAllMessageUUIDs", mbox).Return(uids, nil)
 
 	mi := &mockInterrupter{}
 	mi.On("interrupted").Return(false)
 
-	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi)
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, DownloadOptions{})
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestDownloadMissingEmailsToFolderSeqRange(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 300}
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	// Only the emails in the range are listed, the full list is not retrieved.
+	m.On("getSeqRangeUUIDs", mbox, uint32(100), uint32(200)).Return([]uidExt{}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	opts := DownloadOptions{SeqStart: 100, SeqEnd: 200}
+	err := downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
 
 	assert.NoError(t, err)
 	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "getAllMessageUUIDs", mock.Anything)
+}
+
+func TestDownloadMissingEmailsToFolderRefresh(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 2}
+	_, _, err := initMaildir("some-file", maildirPath)
+	require.NoError(t, err)
+	// Both emails have been downloaded before and the folder has not changed since.
+	oldmails := []byte("42/1\x00100\n42/2\x00200\n")
+	require.NoError(t, os.WriteFile(filepath.Join(tmpdir, "some-file"), oldmails, filePerm))
+	err = writeModseqState(maildirPath.folderPath(), modseqState{folder: 42, modseq: 10})
+	require.NoError(t, err)
+	status := folderStatus{folder: 42, uidNext: 3, messages: 2}
+	require.NoError(t, writeFolderStatus(maildirPath.folderPath(), status))
+
+	messageChan := make(chan emailOps)
+	deliveredChan := make(chan oldmail)
+	var errCount int
+	m := &mockDownloader{t: t, messageChan: messageChan, deliveredChan: deliveredChan}
+	m.On("folderStatus", "some-folder").Return(status, nil)
+	m.On("highestModseq", "some-folder").Return(uint64(10), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).
+		Return([]uidExt{{folder: 42, msg: 1}, {folder: 42, msg: 2}}, nil)
+	m.On("streamingRetrieval",
+		[]uid{1, 2}, DownloadOptions{}.fetchItems(), 0, mock.Anything, mock.Anything,
+		mock.AnythingOfType("func() bool"),
+	).Return(messageChan, &errCount, nil)
+	m.On("streamingDelivery", mock.Anything, mock.Anything, uidFolder(42), DateFallback(""),
+		mock.Anything, mock.Anything).Return(deliveredChan, &errCount)
+	m.On("streamingOldmailWriteout", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&errCount, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	opts := DownloadOptions{Refresh: true, OnlyChanged: true}
+	err = downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
+
+	// All emails are downloaded again since neither the index nor the state are considered.
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "getChangedMessageUUIDs", mock.Anything, mock.Anything)
+}
+
+func TestDownloadMissingEmailsToFolderLocked(t *testing.T) {
+	orgTimeout := lockTimeout
+	lockTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { lockTimeout = orgTimeout })
+
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	oldmailFileName := "some-file"
+
+	unlock, err := lockFolder(oldmailFileName, maildirPath)
+	assert.NoError(t, err)
+	defer unlock()
+
+	// Nothing is expected to happen on the server while another process holds the lock.
+	m := &mockDownloader{t: t}
+	mi := &mockInterrupter{}
+
+	err = downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, DownloadOptions{})
+
+	assert.ErrorContains(t, err, "folder some-folder is locked by pid")
+	assert.NoDirExists(t, maildirPath.folderPath())
+	m.AssertExpectations(t)
+}
+
+func TestDownloadMissingEmailsToFolderMirrorError(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	_, _, err := initMaildir("some-file", maildirPath)
+	require.NoError(t, err)
+	// A directory in place of the uid list causes mirroring to fail.
+	err = os.Mkdir(filepath.Join(maildirPath.folderPath(), uidListName), dirPerm)
+	require.NoError(t, err)
+
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42}
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	err = downloadMissingEmailsToFolder(
+		m, maildirPath, "some-file", mi, DownloadOptions{Mirror: true},
+	)
+
+	assert.Error(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestDownloadMissingEmailsToFolderIncompleteUIDList(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		tmpdir := t.TempDir()
+		maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+		_, _, err := initMaildir("some-file", maildirPath)
+		require.NoError(t, err)
+		// A directory in place of the uid list causes mirroring to fail if it is attempted.
+		err = os.Mkdir(filepath.Join(maildirPath.folderPath(), uidListName), dirPerm)
+		require.NoError(t, err)
+
+		mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 1}
+
+		m := &mockDownloader{t: t}
+		m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+		m.On("selectFolder", "some-folder").Return(mbox, nil)
+		m.On("getAllMessageUUIDs", mbox).Return([]uidExt{}, errUIDCountMismatch)
+
+		mi := &mockInterrupter{}
+		mi.On("interrupted").Return(false)
+
+		err = downloadMissingEmailsToFolder(
+			m, maildirPath, "some-file", mi, DownloadOptions{Mirror: true, StrictUIDCount: strict},
+		)
+
+		if strict {
+			assert.ErrorIs(t, err, errUIDCountMismatch)
+		} else {
+			assert.NoError(t, err)
+		}
+		m.AssertExpectations(t)
+	}
+}
+
+type closingMockStorer struct {
+	mockStorer
+}
+
+func (m *closingMockStorer) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func TestDownloadMissingEmailsToFolderClosesStorer(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42}
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	ms := &closingMockStorer{}
+	ms.On("Close").Return(fmt.Errorf("some close error"))
+	opts := DownloadOptions{NewStorer: func(string) (Storer, error) { return ms, nil }}
+
+	err := downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
+
+	assert.ErrorContains(t, err, "some close error")
+	m.AssertExpectations(t)
+	ms.AssertExpectations(t)
 }
 
 func TestDownloadMissingEmailsToFolderDownloadError(t *testing.T) {
@@ -259,19 +647,24 @@ func TestDownloadMissingEmailsToFolderDownloadError(t *testing.T) {
 	mi := &mockInterrupter{}
 	mi.On("interrupted").Return(false)
 
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
 	m.On("selectFolder", "some-folder").Return(mbox, nil)
 	m.On("getAllMessageUUIDs", mbox).Return(uids, nil)
-	m.On("streamingRetrieval", missingUIDs, mock.Anything, mock.Anything,
+	m.On("streamingRetrieval", missingUIDs, DownloadOptions{}.fetchItems(), 0,
+		mock.Anything, mock.Anything,
 		// We cannot use functions in expectations. Thus use this construct instead.
 		mock.AnythingOfType("func() bool"),
 	).Return(messageChan, &fetchErrCount, nil)
 	uidFolder := uidFolder(42)
-	m.On("streamingDelivery", inMessageChan, folderPath, uidFolder, mock.Anything, mock.Anything).
-		Return(deliveredChan, &deliverErrCount)
+	m.On(
+		"streamingDelivery", inMessageChan,
+		&validatingStorer{Storer: newMaildirStorer(folderPath, nil)}, uidFolder,
+		DateFallback(""), mock.Anything, mock.Anything,
+	).Return(deliveredChan, &deliverErrCount)
 	m.On("streamingOldmailWriteout", inDeliveredChan, oldmailPath, mock.Anything, mock.Anything).
 		Return(&oldmailErrCount, nil)
 
-	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi)
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, DownloadOptions{})
 
 	assert.Error(t, err)
 	assert.Equal(
@@ -349,7 +742,7 @@ func TestDownloaderStreamingRetrieval(t *testing.T) {
 	var wg, startWg sync.WaitGroup
 	interrupted := func() bool { return false }
 
-	_, errPtr, err := dl.streamingRetrieval(nil, &wg, &startWg, interrupted)
+	_, errPtr, err := dl.streamingRetrieval(nil, nil, 0, &wg, &startWg, interrupted)
 
 	assert.NoError(t, err)
 	wg.Wait()
@@ -362,7 +755,9 @@ func TestDownloaderStreamingDelivery(t *testing.T) {
 	close(inChan)
 	var wg, startWg sync.WaitGroup
 
-	_, errPtr := dl.streamingDelivery(inChan, "", 42, &wg, &startWg)
+	_, errPtr := dl.streamingDelivery(
+		inChan, newMaildirStorer("", nil), 42, DateFallbackHeader, &wg, &startWg,
+	)
 
 	wg.Wait()
 	assert.Equal(t, 0, *errPtr)
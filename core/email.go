@@ -19,16 +19,13 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 )
 
-const (
-	rfc822ExpectedNumFields = 6
-)
-
 type emailOps interface {
 	Format() []interface{}
 }
@@ -37,8 +34,17 @@ type emailOps interface {
 type email struct {
 	uid       uid
 	timestamp time.Time
-	// rfc822 is the content of the email according to this RFC.
-	rfc822 string
+	// content is the content of the email according to RFC822. It is read directly from the
+	// literal received from the server to avoid copying it.
+	content io.Reader
+	// flags are the flags of the email, if they have been retrieved.
+	flags []string
+	// structure is the body structure of the email, if it has been retrieved and is complete.
+	structure *BodyStructure
+	// size is the size of the email according to the server, if it has been retrieved.
+	size int64
+	// gmail holds Gmail's labels and IDs of the email, if any of them have been retrieved.
+	gmail *GmailInfo
 
 	// The following members determine which of the fields has already been set. They are used for
 	// internal debugging.
@@ -46,12 +52,52 @@ type email struct {
 	setTimestamp bool
 	setRFC822    bool
 	seenHeader   bool
+	// skipValue determines whether the next field is the value of a fetch item that is not needed.
+	skipValue bool
+	// readFlags determines whether the next field is the value of the FLAGS fetch item.
+	readFlags bool
+	// readStructure determines whether the next field is the value of the BODYSTRUCTURE fetch item.
+	readStructure bool
+	// readSize determines whether the next field is the value of the RFC822.SIZE fetch item.
+	readSize bool
+	// readGmail is the Gmail fetch item whose value is the next field, if any.
+	readGmail imap.FetchItem
 }
 
 // Function set sets a member of an email depending on the type of the input. It errors out if the
 // respective field has already been set or the input type cannot be converted into a required field
 // for the email.
 func (e *email) set(value interface{}) error {
+	if e.skipValue {
+		e.skipValue = false
+		return nil
+	}
+	if e.readFlags {
+		e.readFlags = false
+		return e.setFlags(value)
+	}
+	if e.readStructure {
+		e.readStructure = false
+		e.structure = parseBodyStructure(value)
+		return nil
+	}
+	if e.readGmail != "" {
+		item := e.readGmail
+		e.readGmail = ""
+		if e.gmail == nil {
+			e.gmail = &GmailInfo{}
+		}
+		return e.gmail.set(item, value)
+	}
+	if e.readSize {
+		e.readSize = false
+		size, ok := value.(uint32)
+		if !ok {
+			return fmt.Errorf("unexpected size %v", value)
+		}
+		e.size = int64(size)
+		return nil
+	}
 	switch concrete := value.(type) {
 	case uint32:
 		if e.setUID {
@@ -66,12 +112,23 @@ func (e *email) set(value interface{}) error {
 		e.timestamp = concrete
 		e.setTimestamp = true
 	case imap.RawString:
-		// Ignore this case. This is a header specification.
+		// This is a header specification. It is followed by the value of the respective fetch item.
+		// Skip that value in case it is none of the fields needed.
+		e.readFlags = concrete == imap.RawString(imap.FetchFlags)
+		e.readStructure = concrete == imap.RawString(imap.FetchBodyStructure)
+		e.readSize = concrete == imap.RawString(imap.FetchRFC822Size)
+		if isGmailFetchItem(imap.FetchItem(concrete)) {
+			e.readGmail = imap.FetchItem(concrete)
+		}
+		e.skipValue = !e.readFlags && !e.readStructure && !e.readSize && e.readGmail == "" &&
+			isUnneededFetchItem(concrete)
 	default:
 		// Ignore the first entry in this category. It will be the header specification for this
-		// RFC. Only throw an error if the string representation of that does not contain rfc822.
+		// RFC. Only throw an error if the string representation of that does not contain rfc822 or,
+		// when retrieving only headers or the entire body section, the section specifier.
 		if !e.seenHeader {
-			if !strings.Contains(strings.ToLower(fmt.Sprint(concrete)), "rfc822") {
+			if !strings.Contains(strings.ToLower(fmt.Sprint(concrete)), "rfc822") &&
+				!isHeaderSection(concrete) && !isEntireBody(concrete) {
 				return fmt.Errorf(
 					"rfc822 header not found or with unexpected content: %s", concrete,
 				)
@@ -84,50 +141,91 @@ func (e *email) set(value interface{}) error {
 			return fmt.Errorf("rfc822 already set")
 		}
 		// This is likely the rfc822 content.
-		e.rfc822 = fmt.Sprint(concrete)
+		if reader, ok := concrete.(io.Reader); ok {
+			e.content = reader
+		} else {
+			e.content = strings.NewReader(fmt.Sprint(concrete))
+		}
 		e.setRFC822 = true
 	}
 	return nil
 }
 
-// Function validate returns whether all expected fields of an email have been set.
-func (e email) validate() bool {
-	return e.setUID && e.setTimestamp && e.setRFC822
+// Set the flags of an email from the value of the FLAGS fetch item.
+func (e *email) setFlags(value interface{}) error {
+	flags, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected flags %v", value)
+	}
+	e.flags = make([]string, 0, len(flags))
+	for _, flag := range flags {
+		e.flags = append(e.flags, fmt.Sprint(flag))
+	}
+	return nil
+}
+
+// Determine whether a header specification names a fetch item whose value is not needed, e.g.
+// FLAGS or RFC822.SIZE. Names of fetch items are upper case and contain no whitespace.
+func isUnneededFetchItem(spec imap.RawString) bool {
+	name := string(spec)
+	return name == strings.ToUpper(name) && !strings.ContainsAny(name, " \t") &&
+		name != string(imap.FetchUid) && name != string(imap.FetchInternalDate)
+}
+
+// Determine whether a value is the header section specification for a headers-only retrieval.
+func isHeaderSection(value interface{}) bool {
+	section, ok := value.(*imap.BodySectionName)
+	return ok && section.Specifier == imap.HeaderSpecifier
 }
 
-// String provides a nice string representation of the email. This contains only the bare content
-// and none of the meta data.
-func (e email) String() string {
-	return e.rfc822
+// Determine whether a value is the specification of the section containing the entire content of
+// an email, i.e. BODY[], which is retrieved instead of RFC822 for some servers.
+func isEntireBody(value interface{}) bool {
+	section, ok := value.(*imap.BodySectionName)
+	return ok && section.Specifier == imap.EntireSpecifier && len(section.Path) == 0 &&
+		len(section.Partial) == 0
+}
+
+// Function validate returns whether all expected fields of an email have been set. The internal
+// date is not required since a fallback can be used if the server does not report it, see
+// fallBackDate.
+func (e email) validate() bool {
+	return e.setUID && e.setRFC822
 }
 
 // Convert an imap.Message into its content according to rfc822. That content can then be stored in
-// a maildir as is.
+// a maildir as is. It is provided as a reader to avoid copying it into a string. Note that go-imap
+// reads each literal into memory in full before handing it on, so the content is held in memory
+// anyway. It can be read only once.
 func rfc822FromEmail(
 	msg emailOps, uidFolder uidFolder,
-) (text string, oldmailInfo oldmail, err error) {
+) (content io.Reader, oldmailInfo oldmail, err error) {
 	fields := msg.Format()
-	if len(fields) != rfc822ExpectedNumFields {
-		return "", oldmail{}, fmt.Errorf("cannot extract required rfc822 fields from email")
-	}
-
 	email := email{}
 	for _, field := range fields {
 		if err := email.set(field); err != nil {
-			return "", oldmail{}, fmt.Errorf("cannot extract email data: %s", err.Error())
+			return nil, oldmail{}, fmt.Errorf("cannot extract email data: %s", err.Error())
 		}
 	}
 	if !email.validate() {
-		return "", oldmail{}, fmt.Errorf("cannot extract full email from reply")
+		return nil, oldmail{}, fmt.Errorf("cannot extract full email from reply")
 	}
 
-	text = email.String()
+	// A missing internal date is recorded as the Unix epoch, which is never a usable date.
+	var timestamp int
+	if email.setTimestamp {
+		timestamp = int(email.timestamp.Unix())
+	}
 	oldmailInfo = oldmail{
 		uid:       email.uid,
 		uidFolder: uidFolder,
-		timestamp: int(email.timestamp.Unix()),
+		timestamp: timestamp,
+		flags:     email.flags,
+		structure: email.structure,
+		size:      email.size,
+		gmail:     email.gmail,
 	}
 	logInfo(fmt.Sprintf("downloaded email %s", oldmailInfo))
 
-	return text, oldmailInfo, nil
+	return email.content, oldmailInfo, nil
 }
@@ -18,6 +18,8 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,6 +38,14 @@ func (e *mockEmail) Format() []interface{} {
 	return args.Get(0).([]interface{})
 }
 
+// Read the content of an email in full.
+func readContent(t *testing.T, content io.Reader) string {
+	t.Helper()
+	data, err := io.ReadAll(content)
+	assert.NoError(t, err)
+	return string(data)
+}
+
 func TestEmailSetValidateStringSuccess(t *testing.T) {
 	e := email{}
 
@@ -51,7 +61,21 @@ func TestEmailSetValidateStringSuccess(t *testing.T) {
 	}
 
 	assert.True(t, e.validate())
-	assert.Equal(t, "actual content", e.String())
+	assert.Equal(t, "actual content", readContent(t, e.content))
+}
+
+func TestEmailSetLiteral(t *testing.T) {
+	literal := strings.NewReader("Subject: hi\r\n\r\nbody")
+	e := email{}
+
+	for _, val := range []interface{}{uint32(1), time.Now(), "rfc822 header", literal} {
+		err := e.set(val)
+		assert.NoError(t, err)
+	}
+
+	// The literal is used as is instead of being copied.
+	assert.True(t, e.validate())
+	assert.Same(t, literal, e.content)
 }
 
 func TestEmailSetValueTwice(t *testing.T) {
@@ -78,6 +102,40 @@ func TestEmailSetRFCTooOften(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestEmailSetHeaderSection(t *testing.T) {
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier},
+	}
+	e := email{}
+
+	for _, val := range []interface{}{uint32(1), time.Now(), section, "Subject: hi\r\n\r\n"} {
+		err := e.set(val)
+		assert.NoError(t, err)
+	}
+
+	assert.True(t, e.validate())
+	assert.Equal(t, "Subject: hi\r\n\r\n", readContent(t, e.content))
+}
+
+func TestEmailSetEntireBody(t *testing.T) {
+	section, err := imap.ParseBodySectionName("BODY[]")
+	assert.NoError(t, err)
+	e := email{}
+
+	for _, val := range []interface{}{uint32(1), time.Now(), section, "Subject: hi\r\n\r\nbody"} {
+		err := e.set(val)
+		assert.NoError(t, err)
+	}
+
+	assert.True(t, e.validate())
+	assert.Equal(t, "Subject: hi\r\n\r\nbody", readContent(t, e.content))
+
+	// Other sections are not the content of the email.
+	section, err = imap.ParseBodySectionName("BODY[1]")
+	assert.NoError(t, err)
+	assert.Error(t, (&email{}).set(section))
+}
+
 func TestEmailSetNoRFCHeader(t *testing.T) {
 	e := email{}
 	err := e.set("the first string needs the rfc header")
@@ -104,11 +162,31 @@ func TestRFCFromEmail(t *testing.T) {
 
 	content, om, err := rfc822FromEmail(&msg, 21)
 	assert.NoError(t, err)
-	assert.Equal(t, "actual content", content)
+	assert.Equal(t, "actual content", readContent(t, content))
 	assert.Equal(t, oldmail{uidFolder: 21, uid: 1, timestamp: someTimestamp}, om)
 	msg.AssertExpectations(t)
 }
 
+func TestRFCFromEmailMissingInternalDate(t *testing.T) {
+	msg := mockEmail{}
+	msg.On("Format").Return(
+		[]interface{}{
+			imap.RawString("uid header"),
+			uint32(1),
+			"rfc822 header",
+			"actual content",
+		},
+	)
+
+	content, om, err := rfc822FromEmail(&msg, 21)
+
+	// The missing date is recorded as the Unix epoch so that a fallback can be used.
+	assert.NoError(t, err)
+	assert.Equal(t, "actual content", readContent(t, content))
+	assert.Equal(t, oldmail{uidFolder: 21, uid: 1}, om)
+	msg.AssertExpectations(t)
+}
+
 func TestRFCFromEmailTooFewFields(t *testing.T) {
 	msg := mockEmail{}
 	msg.On("Format").Return(
@@ -162,3 +240,120 @@ func TestRFCFromEmailEnoughFieldsButNotAllWeNeed(t *testing.T) {
 	assert.Error(t, err)
 	msg.AssertExpectations(t)
 }
+
+func TestRFCFromEmailUnneededFetchItems(t *testing.T) {
+	someTime := time.Now()
+	msg := mockEmail{}
+	msg.On("Format").Return(
+		[]interface{}{
+			imap.RawString("UID"),
+			uint32(1),
+			imap.RawString("RFC822.SIZE"),
+			uint32(42),
+			imap.RawString("FLAGS"),
+			[]interface{}{imap.RawString(imap.SeenFlag)},
+			imap.RawString("INTERNALDATE"),
+			someTime,
+			"rfc822 header",
+			"actual content",
+		},
+	)
+
+	content, om, err := rfc822FromEmail(&msg, 21)
+	assert.NoError(t, err)
+	assert.Equal(t, "actual content", readContent(t, content))
+	// Flags and the size are kept.
+	assert.Equal(
+		t,
+		oldmail{
+			uidFolder: 21, uid: 1, timestamp: int(someTime.Unix()),
+			flags: []string{imap.SeenFlag}, size: 42,
+		},
+		om,
+	)
+	msg.AssertExpectations(t)
+}
+
+func TestRFCFromEmailGmailLabels(t *testing.T) {
+	someTime := time.Now()
+	msg := mockEmail{}
+	msg.On("Format").Return(
+		[]interface{}{
+			imap.RawString("UID"),
+			uint32(1),
+			imap.RawString("X-GM-MSGID"),
+			"1278455344230334865",
+			imap.RawString("X-GM-THRID"),
+			"1266894439832287888",
+			imap.RawString("X-GM-LABELS"),
+			[]interface{}{"\\Important", "Receipts"},
+			imap.RawString("INTERNALDATE"),
+			someTime,
+			"rfc822 header",
+			"actual content",
+		},
+	)
+
+	content, om, err := rfc822FromEmail(&msg, 21)
+	assert.NoError(t, err)
+	assert.Equal(t, "actual content", readContent(t, content))
+	assert.Equal(
+		t,
+		&GmailInfo{
+			MsgID: 1278455344230334865, ThreadID: 1266894439832287888,
+			Labels: []string{"\\Important", "Receipts"},
+		},
+		om.gmail,
+	)
+	msg.AssertExpectations(t)
+}
+
+func TestRFCFromEmailBodyStructure(t *testing.T) {
+	someTime := time.Now()
+	structure := &imap.BodyStructure{
+		MIMEType: "text", MIMESubType: "plain", Params: map[string]string{},
+		Encoding: "7bit", Size: 14,
+	}
+	msg := mockEmail{}
+	msg.On("Format").Return(
+		[]interface{}{
+			imap.RawString("UID"),
+			uint32(1),
+			imap.RawString("BODYSTRUCTURE"),
+			structure.Format(),
+			imap.RawString("INTERNALDATE"),
+			someTime,
+			"rfc822 header",
+			"actual content",
+		},
+	)
+
+	_, om, err := rfc822FromEmail(&msg, 21)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &BodyStructure{MIMEType: "text/plain", Size: 14, Encoding: "7bit"}, om.structure)
+	msg.AssertExpectations(t)
+}
+
+func TestRFCFromEmailIncompleteBodyStructure(t *testing.T) {
+	msg := mockEmail{}
+	msg.On("Format").Return(
+		[]interface{}{
+			imap.RawString("UID"),
+			uint32(1),
+			imap.RawString("BODYSTRUCTURE"),
+			[]interface{}{"text", "plain"},
+			imap.RawString("INTERNALDATE"),
+			time.Now(),
+			"rfc822 header",
+			"actual content",
+		},
+	)
+
+	// The email is still extracted, just without its structure.
+	content, om, err := rfc822FromEmail(&msg, 21)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "actual content", readContent(t, content))
+	assert.Nil(t, om.structure)
+}
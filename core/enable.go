@@ -0,0 +1,112 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+const (
+	// The capability of servers that support enabling extensions via ENABLE, see RFC 5161.
+	enableCapability = "ENABLE"
+	enabledResponse  = "ENABLED"
+)
+
+// Type syncExtensions is the state of the extensions for incremental downloads negotiated via
+// ENABLE after logging in, see IMAPConfig.EnableSync.
+//
+// QRESYNC is deliberately not enabled. Servers then report expunged emails via VANISHED instead of
+// EXPUNGE responses, which go-imap does not understand and silently drops. The number of emails
+// known for the selected folder would become stale that way.
+type syncExtensions struct {
+	// negotiated is whether enabling the extensions has been attempted at all. Otherwise, the
+	// capabilities advertised by the server decide.
+	negotiated bool
+	condstore  bool
+}
+
+// Determine whether modification sequences may be used to list only changed emails. Otherwise,
+// emails are compared by UID.
+func (s syncExtensions) modseqUsable() bool {
+	return !s.negotiated || s.condstore
+}
+
+// Type enableCommand is the command that enables extensions for the rest of the session.
+type enableCommand struct {
+	extensions []string
+}
+
+func (cmd *enableCommand) Command() *imap.Command {
+	args := make([]interface{}, 0, len(cmd.extensions))
+	for _, extension := range cmd.extensions {
+		args = append(args, imap.RawString(extension))
+	}
+	return &imap.Command{Name: enableCapability, Arguments: args}
+}
+
+// Type enabledResp handles the untagged ENABLED response, which lists the extensions that have
+// actually been enabled.
+type enabledResp struct {
+	enabled map[string]bool
+}
+
+func (r *enabledResp) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != enabledResponse {
+		return responses.ErrUnhandled
+	}
+	for _, field := range fields {
+		extension, err := imap.ParseString(field)
+		if err != nil {
+			return fmt.Errorf("cannot parse enabled extension: %s", err.Error())
+		}
+		r.enabled[strings.ToUpper(extension)] = true
+	}
+	return nil
+}
+
+// Enable CONDSTORE if the server supports it. Failures never prevent the download. Instead, emails
+// are compared by UID if CONDSTORE could not be enabled.
+func enableSyncExtensions(imapClient imapOps) syncExtensions {
+	state := syncExtensions{negotiated: true}
+	if supported, err := imapClient.Support(enableCapability); err != nil || !supported {
+		logInfo("server does not support ENABLE, comparing emails by UID")
+		return state
+	}
+	if supported, err := imapClient.Support(condstoreCapability); err != nil || !supported {
+		logInfo("server does not support CONDSTORE, comparing emails by UID")
+		return state
+	}
+	handler := &enabledResp{enabled: map[string]bool{}}
+	cmd := &enableCommand{extensions: []string{condstoreCapability}}
+	status, err := imapClient.Execute(cmd, handler)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		logWarning(fmt.Sprintf("cannot enable CONDSTORE, comparing emails by UID: %s", err.Error()))
+		return state
+	}
+	state.condstore = handler.enabled[condstoreCapability]
+	logInfo(fmt.Sprintf("enabled CONDSTORE: %t", state.condstore))
+	return state
+}
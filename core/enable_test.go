@@ -0,0 +1,138 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Set up the mock client to advertise CONDSTORE and to enable the extensions reported.
+func expectEnable(m *mockClient, enabled ...interface{}) {
+	m.On("Support", enableCapability).Return(true, nil)
+	m.On("Support", condstoreCapability).Return(true, nil)
+	m.On("Execute", &enableCommand{extensions: []string{condstoreCapability}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			handler := args.Get(1).(responses.Handler)
+			resp := &imap.DataResp{Fields: append([]interface{}{enabledResponse}, enabled...)}
+			_ = handler.Handle(resp)
+		}).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+}
+
+func TestEnableCommand(t *testing.T) {
+	cmd := &enableCommand{extensions: []string{condstoreCapability, "UTF8=ACCEPT"}}
+
+	command := cmd.Command()
+
+	assert.Equal(t, "ENABLE", command.Name)
+	assert.Equal(
+		t, []interface{}{imap.RawString("CONDSTORE"), imap.RawString("UTF8=ACCEPT")},
+		command.Arguments,
+	)
+}
+
+func TestEnableSyncExtensionsCondstore(t *testing.T) {
+	m := &mockClient{}
+	expectEnable(m, "condstore")
+
+	state := enableSyncExtensions(m)
+
+	assert.Equal(t, syncExtensions{negotiated: true, condstore: true}, state)
+	assert.True(t, state.modseqUsable())
+	m.AssertExpectations(t)
+	// QRESYNC is never asked for, even if the server supports it.
+	m.AssertNotCalled(t, "Support", "QRESYNC")
+}
+
+func TestEnableSyncExtensionsNoneEnabled(t *testing.T) {
+	m := &mockClient{}
+	expectEnable(m)
+
+	state := enableSyncExtensions(m)
+
+	// The server did not confirm anything, so emails are compared by UID.
+	assert.Equal(t, syncExtensions{negotiated: true}, state)
+	assert.False(t, state.modseqUsable())
+}
+
+func TestEnableSyncExtensionsUnsupported(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", enableCapability).Return(false, nil)
+
+	state := enableSyncExtensions(m)
+
+	assert.Equal(t, syncExtensions{negotiated: true}, state)
+	m.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestEnableSyncExtensionsNothingToEnable(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", enableCapability).Return(true, nil)
+	m.On("Support", condstoreCapability).Return(false, fmt.Errorf("some error"))
+
+	state := enableSyncExtensions(m)
+
+	assert.Equal(t, syncExtensions{negotiated: true}, state)
+	m.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestEnableSyncExtensionsRejected(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", mock.Anything).Return(true, nil)
+	m.On("Execute", mock.Anything, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespNo, Info: "not now"}, nil)
+
+	state := enableSyncExtensions(m)
+
+	assert.Equal(t, syncExtensions{negotiated: true}, state)
+	m.AssertExpectations(t)
+}
+
+func TestEnabledRespUnhandled(t *testing.T) {
+	handler := &enabledResp{enabled: map[string]bool{}}
+
+	err := handler.Handle(&imap.DataResp{Fields: []interface{}{"CAPABILITY", "IMAP4rev1"}})
+
+	assert.Equal(t, responses.ErrUnhandled, err)
+	assert.Empty(t, handler.enabled)
+}
+
+func TestSyncExtensionsModseqUsable(t *testing.T) {
+	// Without negotiation, the capabilities advertised by the server decide.
+	assert.True(t, syncExtensions{}.modseqUsable())
+	assert.False(t, syncExtensions{negotiated: true}.modseqUsable())
+	assert.True(t, syncExtensions{negotiated: true, condstore: true}.modseqUsable())
+}
+
+func TestDownloaderHighestModseqNotEnabled(t *testing.T) {
+	m := &mockClient{}
+	dl := downloader{imapOps: m, extensions: syncExtensions{negotiated: true}}
+
+	modseq, err := dl.highestModseq("INBOX")
+
+	// Emails are compared by UID since CONDSTORE has not been enabled.
+	assert.NoError(t, err)
+	assert.Zero(t, modseq)
+	m.AssertNotCalled(t, "Status", mock.Anything, mock.Anything)
+}
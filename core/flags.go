@@ -0,0 +1,94 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// The prefix of the info part of names of files in maildirs that carries the flags.
+	maildirInfoPrefix = ":2,"
+	// The maildir flag of drafts.
+	maildirDraftFlag = 'D'
+	// The keyword that many servers and clients use for forwarded emails, see RFC 5788.
+	forwardedKeyword = "$Forwarded"
+)
+
+// MaildirSubdir selects the sub-directory of a maildir that downloaded emails are delivered to, see
+// DownloadOptions.MaildirSubdir.
+type MaildirSubdir string
+
+const (
+	// MaildirSubdirAuto delivers emails with maildir flags, e.g. seen ones, to the cur
+	// sub-directory and all others to the new sub-directory. This is the default.
+	MaildirSubdirAuto MaildirSubdir = "auto"
+	// MaildirSubdirCur delivers all emails to the cur sub-directory, i.e. as seen by a mail client.
+	MaildirSubdirCur MaildirSubdir = "cur"
+	// MaildirSubdirNew delivers all emails to the new sub-directory, i.e. as not yet seen by a
+	// mail client. Files in there carry no maildir flags.
+	MaildirSubdirNew MaildirSubdir = "new"
+)
+
+// Determine whether an email with the given maildir flags is delivered to the cur sub-directory.
+func (s MaildirSubdir) cur(flags string) bool {
+	switch s {
+	case MaildirSubdirCur:
+		return true
+	case MaildirSubdirNew:
+		return false
+	default:
+		return flags != ""
+	}
+}
+
+// The maildir flags corresponding to IMAP flags, see https://cr.yp.to/proto/maildir.html. The
+// \Recent flag has no equivalent since emails in the new sub-directory of a maildir are recent.
+var maildirFlagsByIMAPFlag = map[string]rune{
+	strings.ToLower(imap.DraftFlag):    maildirDraftFlag,
+	strings.ToLower(imap.FlaggedFlag):  'F',
+	strings.ToLower(forwardedKeyword):  'P',
+	strings.ToLower(imap.AnsweredFlag): 'R',
+	strings.ToLower(imap.SeenFlag):     'S',
+	strings.ToLower(imap.DeletedFlag):  'T',
+}
+
+// Determine the maildir flags of an email from its IMAP flags, e.g. "DS" for "\Seen" and "\Draft".
+// IMAP flags are compared case-insensitively and those without an equivalent are ignored. The
+// flags are sorted as mandated by the maildir specs. The draft flag is set for drafts even if the
+// server does not report the \Draft flag for them.
+func maildirInfoFlags(flags []string, draft bool) string {
+	found := map[rune]struct{}{}
+	if draft {
+		found[maildirDraftFlag] = struct{}{}
+	}
+	for _, flag := range flags {
+		if maildirFlag, ok := maildirFlagsByIMAPFlag[strings.ToLower(flag)]; ok {
+			found[maildirFlag] = struct{}{}
+		}
+	}
+	result := make([]rune, 0, len(found))
+	for maildirFlag := range found {
+		result = append(result, maildirFlag)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return string(result)
+}
@@ -0,0 +1,66 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaildirInfoFlags(t *testing.T) {
+	testCases := []struct {
+		name     string
+		flags    []string
+		draft    bool
+		expected string
+	}{
+		{"none", nil, false, ""},
+		{"seen", []string{imap.SeenFlag}, false, "S"},
+		{
+			"multiple flags are sorted",
+			[]string{
+				imap.SeenFlag, imap.DraftFlag, imap.AnsweredFlag, imap.FlaggedFlag,
+				imap.DeletedFlag, "$Forwarded",
+			},
+			false, "DFPRST",
+		},
+		{"case-insensitive", []string{`\seen`, `\FLAGGED`}, false, "FS"},
+		{"unknown flags ignored", []string{imap.RecentFlag, "$Junk", imap.SeenFlag}, false, "S"},
+		{"drafts folder", []string{imap.SeenFlag}, true, "DS"},
+		{"no duplicates", []string{imap.DraftFlag, imap.DraftFlag}, true, "D"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, maildirInfoFlags(tc.flags, tc.draft))
+		})
+	}
+}
+
+func TestMaildirSubdirCur(t *testing.T) {
+	for _, subdir := range []MaildirSubdir{"", MaildirSubdirAuto} {
+		assert.True(t, subdir.cur("S"))
+		assert.False(t, subdir.cur(""))
+	}
+	assert.True(t, MaildirSubdirCur.cur("S"))
+	assert.True(t, MaildirSubdirCur.cur(""))
+	assert.False(t, MaildirSubdirNew.cur("S"))
+	assert.False(t, MaildirSubdirNew.cur(""))
+}
@@ -21,6 +21,9 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/utf7"
 )
 
 const (
@@ -36,10 +39,154 @@ const (
 	gmailPrefix2 = "[Google Mail]"
 )
 
+// Hostnames of Gmail's IMAP servers end in these domains.
+var gmailDomains = []string{"gmail.com", "googlemail.com"}
+
+// Labels for folders with a special use, see RFC 6154.
+var specialUseLabels = map[string]string{
+	imap.AllAttr:     "all mail",
+	imap.ArchiveAttr: "archive",
+	imap.DraftsAttr:  "drafts",
+	imap.FlaggedAttr: "flagged",
+	imap.JunkAttr:    "spam",
+	imap.SentAttr:    "sent",
+	imap.TrashAttr:   "trash",
+}
+
 func isGmailDir(dirName string) bool {
 	return strings.HasPrefix(dirName, gmailPrefix1) || strings.HasPrefix(dirName, gmailPrefix2)
 }
 
+// Determine whether a server is one of Gmail's.
+func isGmailServer(server string) bool {
+	server = strings.ToLower(strings.TrimSuffix(server, "."))
+	for _, domain := range gmailDomains {
+		if server == domain || strings.HasSuffix(server, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// FolderInfo describes a folder on the server.
+type FolderInfo struct {
+	Name string
+	// Attributes are the attributes the server reports for the folder, e.g. "\Sent" for a folder
+	// containing sent emails.
+	Attributes []string
+}
+
+// SpecialUse provides a short label for the special use of a folder as indicated by its
+// attributes, e.g. "sent", "trash", or "spam". It is empty for folders without a special use.
+func (f FolderInfo) SpecialUse() string {
+	for _, attr := range f.Attributes {
+		for specialAttr, label := range specialUseLabels {
+			if strings.EqualFold(attr, specialAttr) {
+				return label
+			}
+		}
+	}
+	return ""
+}
+
+// HasAttribute determines whether the server reports the given attribute for the folder, e.g.
+// "\Sent". Attributes are compared case-insensitively, and the leading backslash is optional.
+func (f FolderInfo) HasAttribute(attr string) bool {
+	attr = strings.TrimPrefix(attr, "\\")
+	for _, folderAttr := range f.Attributes {
+		if strings.EqualFold(strings.TrimPrefix(folderAttr, "\\"), attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSpecialUse determines whether a folder has the given special use, which is either a label as
+// provided by SpecialUse, e.g. "spam", or the respective attribute, e.g. "\Junk" or "junk".
+// Matching is case-insensitive.
+func (f FolderInfo) HasSpecialUse(use string) bool {
+	if use == "" {
+		return false
+	}
+	return strings.EqualFold(f.SpecialUse(), use) || f.HasAttribute(use)
+}
+
+// FilterFoldersBySpecialUse selects those folders that have any of the given special uses, e.g. all
+// folders containing sent emails. See HasSpecialUse for how uses are matched.
+func FilterFoldersBySpecialUse(infos []FolderInfo, uses []string) []FolderInfo {
+	var filtered []FolderInfo
+	for _, info := range infos {
+		for _, use := range uses {
+			if info.HasSpecialUse(use) {
+				filtered = append(filtered, info)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Determine whether a Gmail folder contains all emails, which are thus contained twice in a full
+// backup. Servers that do not report special uses are handled via the folder name.
+func (f FolderInfo) isGmailAllMail() bool {
+	if f.SpecialUse() != "" {
+		return f.SpecialUse() == specialUseLabels[imap.AllAttr]
+	}
+	return f.Name == gmailPrefix1+"/All Mail" || f.Name == gmailPrefix2+"/All Mail"
+}
+
+// Remove Gmail's folder containing all emails from the selected folders unless it has been
+// requested explicitly by its name. Other servers are not affected.
+func skipGmailAllMail(
+	selected, folderSpecs []string, available []FolderInfo, server string,
+) []string {
+	gmail := isGmailServer(server)
+	for _, info := range available {
+		gmail = gmail || isGmailDir(info.Name)
+	}
+	if !gmail {
+		return selected
+	}
+	explicit := map[string]bool{}
+	for _, spec := range folderSpecs {
+		explicit[decodeFolderName(spec)] = true
+	}
+	skip := map[string]bool{}
+	for _, info := range available {
+		if info.isGmailAllMail() && !explicit[info.Name] {
+			skip[info.Name] = true
+		}
+	}
+	var kept []string
+	for _, folder := range selected {
+		if skip[folder] {
+			logInfo(fmt.Sprintf("skipping Gmail folder '%s' containing all emails", folder))
+			continue
+		}
+		kept = append(kept, folder)
+	}
+	return kept
+}
+
+// Decode a folder name given in IMAP's modified UTF-7 (see RFC 3501, section 5.1.3) to UTF-8. Names
+// that are no valid modified UTF-7, e.g. because they already contain non-ASCII characters, are
+// returned as they are.
+//
+// Note that go-imap already decodes folder names received via LIST and encodes them again when
+// issuing SELECT. Thus, we always work with decoded names internally and keep no encoded form
+// around. This function exists so that users may also specify folders in their encoded form, e.g.
+// as shown by other tools.
+func decodeFolderName(name string) string {
+	decoded, err := utf7.Encoding.NewDecoder().String(name)
+	if err != nil {
+		return name
+	}
+	if decoded != name {
+		logInfo(fmt.Sprintf("decoded folder name '%s' to '%s'", name, decoded))
+	}
+	return decoded
+}
+
 // Perform fancy name replacements on folder names. For example, specifying _ALL_ causes all
 // folders to be selected.
 func expandFolders(folderSpecs, availableFolders []string) []string {
@@ -55,7 +202,7 @@ func expandFolders(folderSpecs, availableFolders []string) []string {
 
 	for _, folderSpec := range folderSpecs {
 		if strings.HasPrefix(folderSpec, removalSelector) {
-			folderSpec = strings.TrimPrefix(folderSpec, removalSelector)
+			folderSpec = decodeFolderName(strings.TrimPrefix(folderSpec, removalSelector))
 			// Remove the specified directory.
 			switch folderSpec {
 			case allSelector:
@@ -89,7 +236,7 @@ func expandFolders(folderSpecs, availableFolders []string) []string {
 					}
 				}
 			default:
-				foldersSet.add(folderSpec)
+				foldersSet.add(decodeFolderName(folderSpec))
 			}
 		}
 	}
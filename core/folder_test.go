@@ -21,6 +21,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/emersion/go-imap/utf7"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -115,3 +116,143 @@ func TestExpandFoldersMultiSelect(t *testing.T) {
 	actual := expandFolders(selector, availableTestFolders())
 	assert.Equal(t, []string{"death star"}, actual)
 }
+
+func TestDecodeFolderNameRoundTrip(t *testing.T) {
+	testData := []struct {
+		encoded string
+		decoded string
+	}{
+		{"&BD8ENgQ+-", "пжо"},
+		{"&ZeVnLIqe-", "日本語"},
+		{"Entw&APw-rfe", "Entwürfe"},
+		{"R&-D", "R&D"},
+		{"INBOX", "INBOX"},
+		{"[Gmail]/All Mail", "[Gmail]/All Mail"},
+	}
+
+	for _, data := range testData {
+		decoded := decodeFolderName(data.encoded)
+		assert.Equal(t, data.decoded, decoded)
+
+		encoded, err := utf7.Encoding.NewEncoder().String(decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, data.encoded, encoded)
+	}
+}
+
+func TestDecodeFolderNameKeepInvalid(t *testing.T) {
+	for _, name := range []string{"Gelöscht", "R&D", "Tom&Jerry", ""} {
+		assert.Equal(t, name, decodeFolderName(name))
+	}
+}
+
+func TestExpandFoldersSelectEncoded(t *testing.T) {
+	folders := append(availableTestFolders(), "Entwürfe")
+	selector := []string{"Entw&APw-rfe", "death star"}
+	actual := expandFolders(selector, folders)
+	assert.Equal(t, []string{"Entwürfe", "death star"}, actual)
+}
+
+func TestExpandFoldersDeselectEncoded(t *testing.T) {
+	folders := append(availableTestFolders(), "Entwürfe")
+	selector := []string{"_ALL_", "-Entw&APw-rfe"}
+	actual := expandFolders(selector, folders)
+	assert.Equal(t, availableTestFolders(), actual)
+}
+
+func TestIsGmailServer(t *testing.T) {
+	for server, expected := range map[string]bool{
+		"imap.gmail.com":       true,
+		"IMAP.GMAIL.COM":       true,
+		"imap.googlemail.com":  true,
+		"gmail.com.":           true,
+		"imap.notgmail.com":    false,
+		"imap.gmail.com.evil":  false,
+		"imap.example.com":     false,
+		"":                     false,
+		"gmail.com.example.de": false,
+	} {
+		assert.Equal(t, expected, isGmailServer(server), server)
+	}
+}
+
+func TestFolderInfoSpecialUse(t *testing.T) {
+	assert.Equal(t, "", FolderInfo{Name: "INBOX"}.SpecialUse())
+	assert.Equal(
+		t, "sent", FolderInfo{Attributes: []string{"\\HasNoChildren", "\\Sent"}}.SpecialUse(),
+	)
+	// Attributes are matched case-insensitively.
+	assert.Equal(t, "spam", FolderInfo{Attributes: []string{"\\junk"}}.SpecialUse())
+	assert.Equal(t, "trash", FolderInfo{Attributes: []string{"\\TRASH"}}.SpecialUse())
+	assert.Equal(t, "all mail", FolderInfo{Attributes: []string{"\\All"}}.SpecialUse())
+}
+
+func TestFolderInfoHasSpecialUse(t *testing.T) {
+	info := FolderInfo{Name: "Junk", Attributes: []string{"\\HasNoChildren", "\\Junk"}}
+
+	assert.True(t, info.HasAttribute("\\Junk"))
+	assert.True(t, info.HasAttribute("\\JUNK"))
+	assert.True(t, info.HasAttribute("junk"))
+	assert.False(t, info.HasAttribute("\\Sent"))
+
+	assert.True(t, info.HasSpecialUse("spam"))
+	assert.True(t, info.HasSpecialUse("Junk"))
+	assert.True(t, info.HasSpecialUse("\\junk"))
+	assert.False(t, info.HasSpecialUse("trash"))
+	assert.False(t, FolderInfo{Name: "INBOX"}.HasSpecialUse(""))
+}
+
+func TestFilterFoldersBySpecialUse(t *testing.T) {
+	infos := []FolderInfo{
+		{Name: "INBOX"},
+		{Name: "Sent", Attributes: []string{"\\Sent"}},
+		{Name: "Trash", Attributes: []string{"\\Trash"}},
+		{Name: "Archive/Sent", Attributes: []string{"\\HasNoChildren", "\\sent"}},
+	}
+
+	filtered := FilterFoldersBySpecialUse(infos, []string{"sent"})
+	assert.Equal(t, []FolderInfo{infos[1], infos[3]}, filtered)
+
+	filtered = FilterFoldersBySpecialUse(infos, []string{"\\Trash", "sent"})
+	assert.Equal(t, []FolderInfo{infos[1], infos[2], infos[3]}, filtered)
+
+	assert.Empty(t, FilterFoldersBySpecialUse(infos, []string{"drafts"}))
+}
+
+func TestSkipGmailAllMail(t *testing.T) {
+	available := []FolderInfo{
+		{Name: "INBOX"},
+		{Name: "[Gmail]/Alle Nachrichten", Attributes: []string{"\\All"}},
+		{Name: "[Gmail]/Sent Mail", Attributes: []string{"\\Sent"}},
+	}
+	selected := []string{"INBOX", "[Gmail]/Alle Nachrichten", "[Gmail]/Sent Mail"}
+
+	kept := skipGmailAllMail(selected, []string{"_ALL_"}, available, "imap.gmail.com")
+	assert.Equal(t, []string{"INBOX", "[Gmail]/Sent Mail"}, kept)
+
+	// Requesting the folder by its name keeps it.
+	kept = skipGmailAllMail(
+		selected, []string{"_ALL_", "[Gmail]/Alle Nachrichten"}, available, "imap.gmail.com",
+	)
+	assert.Equal(t, selected, kept)
+}
+
+func TestSkipGmailAllMailByName(t *testing.T) {
+	// Gmail is detected via folder names and its folder containing all emails via its name if the
+	// server reports no special uses.
+	available := folderInfos([]string{"INBOX", "[Google Mail]/All Mail"})
+	selected := []string{"INBOX", "[Google Mail]/All Mail"}
+
+	kept := skipGmailAllMail(selected, []string{"_Gmail_", "INBOX"}, available, "localhost")
+
+	assert.Equal(t, []string{"INBOX"}, kept)
+}
+
+func TestSkipGmailAllMailOtherServers(t *testing.T) {
+	available := []FolderInfo{{Name: "INBOX"}, {Name: "Virtual/All", Attributes: []string{"\\All"}}}
+	selected := []string{"INBOX", "Virtual/All"}
+
+	kept := skipGmailAllMail(selected, []string{"_ALL_"}, available, "imap.example.com")
+
+	assert.Equal(t, selected, kept)
+}
@@ -0,0 +1,161 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Type connectFunc connects an additional client, e.g. to retrieve the emails of a folder in
+// parallel, see DownloadOptions.FolderThreads. The returned function logs the client out.
+type connectFunc func() (downloadOps, func(), error)
+
+// Create a connectFunc authenticating clients with the given configuration. Clients are tracked by
+// the cancelGroup so that they are cancelled along with all others.
+func newConnectFunc(cfg IMAPConfig, cancels *cancelGroup) connectFunc {
+	return func() (downloadOps, func(), error) {
+		ig := &Imapgrabber{}
+		if err := ig.authenticateClient(cfg); err != nil {
+			ig.interruptOps.deregister()
+			return nil, nil, err
+		}
+		cancels.track(ig)
+		logout := func() {
+			if err := ig.logout(false); err != nil {
+				logWarning(fmt.Sprintf("error while logging out additional client: %s", err.Error()))
+			}
+		}
+		return ig.downloadOps, logout, nil
+	}
+}
+
+// Determine the number of clients that the emails of a folder are retrieved with.
+func (o DownloadOptions) folderThreads(folder string) int {
+	if threads, found := o.FolderThreads[folder]; found {
+		return threads
+	}
+	return 1
+}
+
+// Retrieve the given emails of a folder. If more than one thread is configured for the folder, the
+// emails are distributed across that many clients, including the given one, and retrieved in
+// parallel. Emails retrieved by all clients are provided via a single channel in no particular
+// order. If additional clients cannot be connected, fewer are used. The returned function must be
+// called once the retrieval has finished to log out the additional clients. With
+// DownloadOptions.ReconnectEvery, emails are retrieved via reconnectingRetrieval instead.
+func (o DownloadOptions) parallelRetrieval(
+	ops downloadOps,
+	folder string,
+	uidFold uidFolder,
+	uids []uid,
+	wg, startWg *sync.WaitGroup,
+	interrupted func() bool,
+) (<-chan emailOps, *int, func(), error) {
+	if o.connect != nil && o.ReconnectEvery > 0 && len(uids) > o.ReconnectEvery {
+		return o.reconnectingRetrieval(ops, folder, uidFold, uids, wg, startWg, interrupted)
+	}
+	clients := []downloadOps{ops}
+	var logouts []func()
+	done := func() {
+		for _, logout := range logouts {
+			logout()
+		}
+	}
+	for o.connect != nil && len(clients) < min(o.folderThreads(folder), len(uids)) {
+		client, logout, err := o.connectFolder(folder, uidFold)
+		if err != nil {
+			logWarning(fmt.Sprintf(
+				"retrieving emails of folder %s via %d clients since no further one can be used: %s",
+				folder, len(clients), err.Error(),
+			))
+			break
+		}
+		clients = append(clients, client)
+		logouts = append(logouts, logout)
+	}
+	if len(clients) == 1 {
+		messageChan, errCount, err := ops.streamingRetrieval(
+			uids, o.fetchItems(), o.batchSize(), wg, startWg, interrupted,
+		)
+		return messageChan, errCount, done, err
+	}
+
+	logInfo(fmt.Sprintf("retrieving emails of folder %s via %d clients", folder, len(clients)))
+	mergedChan := make(chan emailOps)
+	errCounts := make([]*int, 0, len(clients))
+	var forwarders sync.WaitGroup
+	for idx, client := range clients {
+		messageChan, errCount, err := client.streamingRetrieval(
+			shareOfUIDs(uids, idx, len(clients)), o.fetchItems(), o.batchSize(), wg, startWg,
+			interrupted,
+		)
+		if err != nil {
+			return nil, nil, done, err
+		}
+		errCounts = append(errCounts, errCount)
+		forwarders.Add(1)
+		go func() {
+			defer forwarders.Done()
+			for msg := range messageChan {
+				mergedChan <- msg
+			}
+		}()
+	}
+	// The errors of all clients are known once all of them have provided their last email.
+	var totalErrCount int
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		forwarders.Wait()
+		for _, errCount := range errCounts {
+			totalErrCount += *errCount
+		}
+		close(mergedChan)
+	}()
+	return mergedChan, &totalErrCount, done, nil
+}
+
+// Connect an additional client and select a folder, making sure that its UIDs have not changed.
+func (o DownloadOptions) connectFolder(
+	folder string, uidFold uidFolder,
+) (downloadOps, func(), error) {
+	client, logout, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	mbox, err := client.selectFolder(folder)
+	if err == nil && uidFolder(mbox.UidValidity) != uidFold {
+		err = fmt.Errorf("UIDVALIDITY changed from %d to %d", uidFold, mbox.UidValidity)
+	}
+	if err != nil {
+		logout()
+		return nil, nil, err
+	}
+	return client, logout, nil
+}
+
+// Provide the share of UIDs that one of several clients retrieves. UIDs are distributed in turn so
+// that every client follows the requested order, e.g. with DownloadOptions.NewestFirst.
+func shareOfUIDs(uids []uid, idx, numClients int) []uid {
+	share := make([]uid, 0, len(uids)/numClients+1)
+	for pos := idx; pos < len(uids); pos += numClients {
+		share = append(share, uids[pos])
+	}
+	return share
+}
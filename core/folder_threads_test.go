@@ -0,0 +1,164 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Set up a mock retrieving the given emails. The returned pointer is the mock's error count.
+func setUpRetrievingMock(t *testing.T, uids []uid, errCount int) (*mockDownloader, *int) {
+	m := &mockDownloader{t: t, messageChan: make(chan emailOps)}
+	for _, msg := range uids {
+		m.messages = append(m.messages, &mockEmail{uid: int(msg)})
+	}
+	m.On("streamingRetrieval",
+		uids, DownloadOptions{}.fetchItems(), 0, mock.Anything, mock.Anything,
+		mock.AnythingOfType("func() bool"),
+	).Return(m.messageChan, &errCount, nil)
+	return m, &errCount
+}
+
+// Run a retrieval set up via parallelRetrieval and collect the UIDs of all retrieved emails.
+func collectRetrieved(
+	t *testing.T, messageChan <-chan emailOps, wg, startWg *sync.WaitGroup,
+) []int {
+	startWg.Done()
+	var retrieved []int
+	for msg := range messageChan {
+		retrieved = append(retrieved, msg.(*mockEmail).uid)
+	}
+	wg.Wait()
+	sort.Ints(retrieved)
+	return retrieved
+}
+
+func TestFolderThreads(t *testing.T) {
+	opts := DownloadOptions{FolderThreads: map[string]int{"large": 3}}
+
+	assert.Equal(t, 3, opts.folderThreads("large"))
+	assert.Equal(t, 1, opts.folderThreads("small"))
+}
+
+func TestShareOfUIDs(t *testing.T) {
+	uids := []uid{5, 4, 3, 2, 1}
+
+	assert.Equal(t, []uid{5, 3, 1}, shareOfUIDs(uids, 0, 2))
+	assert.Equal(t, []uid{4, 2}, shareOfUIDs(uids, 1, 2))
+	assert.Equal(t, []uid{}, shareOfUIDs(uids[:1], 1, 2))
+}
+
+func TestParallelRetrieval(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{1, 3}, 1)
+	additional, _ := setUpRetrievingMock(t, []uid{2}, 2)
+	additional.On("selectFolder", "large").Return(&imap.MailboxStatus{UidValidity: 42}, nil)
+	loggedOut := 0
+	opts := DownloadOptions{FolderThreads: map[string]int{"large": 2}}
+	opts.connect = func() (downloadOps, func(), error) {
+		return additional, func() { loggedOut++ }, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "large", 42, []uid{1, 2, 3}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{1, 2, 3}, collectRetrieved(t, messageChan, &wg, &startWg))
+	assert.Equal(t, 3, *errCount)
+	assert.Equal(t, 0, loggedOut)
+	done()
+	assert.Equal(t, 1, loggedOut)
+	primary.AssertExpectations(t)
+	additional.AssertExpectations(t)
+}
+
+func TestParallelRetrievalSingleClient(t *testing.T) {
+	primary, primaryErrCount := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	opts := DownloadOptions{FolderThreads: map[string]int{"large": 2}}
+	opts.connect = func() (downloadOps, func(), error) {
+		assert.Fail(t, "no additional client shall be connected")
+		return nil, nil, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "small", 42, []uid{1, 2}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	assert.Equal(t, []int{1, 2}, collectRetrieved(t, messageChan, &wg, &startWg))
+	assert.Equal(t, primaryErrCount, errCount)
+	primary.AssertExpectations(t)
+}
+
+func TestParallelRetrievalConnectFailure(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	opts := DownloadOptions{FolderThreads: map[string]int{"large": 2}}
+	opts.connect = func() (downloadOps, func(), error) {
+		return nil, nil, fmt.Errorf("too many connections")
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, _, done, err := opts.parallelRetrieval(
+		primary, "large", 42, []uid{1, 2}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	// All emails are retrieved via the given client.
+	assert.Equal(t, []int{1, 2}, collectRetrieved(t, messageChan, &wg, &startWg))
+	primary.AssertExpectations(t)
+}
+
+func TestParallelRetrievalUIDValidityChanged(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	additional := &mockDownloader{t: t}
+	additional.On("selectFolder", "large").Return(&imap.MailboxStatus{UidValidity: 43}, nil)
+	loggedOut := 0
+	opts := DownloadOptions{FolderThreads: map[string]int{"large": 2}}
+	opts.connect = func() (downloadOps, func(), error) {
+		return additional, func() { loggedOut++ }, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, _, done, err := opts.parallelRetrieval(
+		primary, "large", 42, []uid{1, 2}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	// The additional client is logged out right away.
+	assert.Equal(t, 1, loggedOut)
+	assert.Equal(t, []int{1, 2}, collectRetrieved(t, messageChan, &wg, &startWg))
+	primary.AssertExpectations(t)
+	additional.AssertExpectations(t)
+}
@@ -0,0 +1,103 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OutputFormat selects the format that emails are stored in locally.
+type OutputFormat string
+
+const (
+	// OutputFormatAuto continues in the format of existing output, if there is any. New output is
+	// stored in maildirs, unless emails are additionally appended to mbox files via Mbox.
+	OutputFormatAuto OutputFormat = "auto"
+	// OutputFormatMaildir stores emails in maildirs only.
+	OutputFormatMaildir OutputFormat = "maildir"
+	// OutputFormatMbox additionally appends emails to mbox files next to the maildirs, like Mbox.
+	OutputFormatMbox OutputFormat = "mbox"
+)
+
+// Determine the format of existing output below a path. Maildirs are directories containing the
+// directories "cur", "new", and "tmp" whereas mbox files end in ".mbox". Since mbox files are
+// always accompanied by maildirs, any mbox file means the output is in mbox format. An empty string
+// is returned if there is no output yet. The content of maildirs is not inspected.
+func detectOutputFormat(path string) (OutputFormat, error) {
+	var format OutputFormat
+	err := filepath.WalkDir(path, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), mboxSuffix) {
+			format = OutputFormatMbox
+			return filepath.SkipAll
+		}
+		if entry.IsDir() && isMaildir(current) {
+			format = OutputFormatMaildir
+		}
+		switch entry.Name() {
+		case curMaildir, newMaildir, tmpMaildir:
+			if entry.IsDir() && isMaildir(filepath.Dir(current)) {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return format, err
+}
+
+// Reconcile the requested output format with that of existing output below a path. The automatic
+// format is replaced by the detected one, if any. An error is returned if the requested format
+// differs from the detected one since that would mix formats.
+func (o DownloadOptions) reconcileFormat(path string) (DownloadOptions, error) {
+	switch o.Format {
+	case OutputFormatAuto, OutputFormatMaildir, OutputFormatMbox:
+	default:
+		// Unknown formats are reported when checking the options.
+		return o, nil
+	}
+	detected, err := detectOutputFormat(path)
+	if err != nil || detected == "" {
+		return o, err
+	}
+	requested := o.Format
+	if requested == OutputFormatAuto && !o.Mbox {
+		logInfo(fmt.Sprintf("continuing with output format %s detected in %s", detected, path))
+		o.Format = detected
+		return o, nil
+	}
+	if o.mbox() {
+		requested = OutputFormatMbox
+	}
+	if requested != detected {
+		return o, fmt.Errorf(
+			"requested output format %s but %s already contains output in format %s",
+			requested, path, detected,
+		)
+	}
+	return o, nil
+}
@@ -0,0 +1,111 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setUpOutput(t *testing.T, mbox bool) string {
+	base := t.TempDir()
+	for _, dir := range []string{curMaildir, newMaildir, tmpMaildir} {
+		require.NoError(t, os.MkdirAll(filepath.Join(base, "INBOX", dir), dirPerm))
+	}
+	// Emails in maildirs must not be mistaken for mbox files.
+	email := filepath.Join(base, "INBOX", newMaildir, "email.mbox")
+	require.NoError(t, os.WriteFile(email, []byte("content"), filePerm))
+	if mbox {
+		path := filepath.Join(base, "INBOX"+mboxSuffix)
+		require.NoError(t, os.WriteFile(path, []byte("content"), filePerm))
+	}
+	return base
+}
+
+func TestDetectOutputFormat(t *testing.T) {
+	format, err := detectOutputFormat(setUpOutput(t, false))
+	assert.NoError(t, err)
+	assert.Equal(t, OutputFormatMaildir, format)
+
+	format, err = detectOutputFormat(setUpOutput(t, true))
+	assert.NoError(t, err)
+	assert.Equal(t, OutputFormatMbox, format)
+
+	format, err = detectOutputFormat(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, OutputFormat(""), format)
+
+	format, err = detectOutputFormat(filepath.Join(t.TempDir(), "missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, OutputFormat(""), format)
+}
+
+func TestReconcileFormatAuto(t *testing.T) {
+	opts, err := DownloadOptions{Format: OutputFormatAuto}.reconcileFormat(setUpOutput(t, true))
+	assert.NoError(t, err)
+	assert.Equal(t, OutputFormatMbox, opts.Format)
+	assert.True(t, opts.mbox())
+
+	opts, err = DownloadOptions{Format: OutputFormatAuto}.reconcileFormat(setUpOutput(t, false))
+	assert.NoError(t, err)
+	assert.Equal(t, OutputFormatMaildir, opts.Format)
+	assert.False(t, opts.mbox())
+
+	// Without existing output, the requested format is kept.
+	opts, err = DownloadOptions{Format: OutputFormatAuto, Mbox: true}.reconcileFormat(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, DownloadOptions{Format: OutputFormatAuto, Mbox: true}, opts)
+}
+
+func TestReconcileFormatMismatch(t *testing.T) {
+	_, err := DownloadOptions{Format: OutputFormatAuto, Mbox: true}.
+		reconcileFormat(setUpOutput(t, false))
+	assert.ErrorContains(t, err, "requested output format mbox but")
+	assert.ErrorContains(t, err, "already contains output in format maildir")
+
+	_, err = DownloadOptions{Format: OutputFormatMbox}.reconcileFormat(setUpOutput(t, false))
+	assert.ErrorContains(t, err, "requested output format mbox but")
+
+	_, err = DownloadOptions{Format: OutputFormatMaildir}.reconcileFormat(setUpOutput(t, true))
+	assert.ErrorContains(t, err, "requested output format maildir but")
+}
+
+func TestReconcileFormatMatch(t *testing.T) {
+	opts := DownloadOptions{Format: OutputFormatMbox}
+	reconciled, err := opts.reconcileFormat(setUpOutput(t, true))
+	assert.NoError(t, err)
+	assert.Equal(t, opts, reconciled)
+
+	opts = DownloadOptions{Format: OutputFormatMaildir}
+	reconciled, err = opts.reconcileFormat(setUpOutput(t, false))
+	assert.NoError(t, err)
+	assert.Equal(t, opts, reconciled)
+}
+
+func TestReconcileFormatNoDetection(t *testing.T) {
+	// Without a format, nothing is detected. Unknown formats are reported by the options check.
+	for _, opts := range []DownloadOptions{{Mbox: true}, {Format: "unknown"}} {
+		reconciled, err := opts.reconcileFormat(setUpOutput(t, false))
+		assert.NoError(t, err)
+		assert.Equal(t, opts, reconciled)
+	}
+}
@@ -20,13 +20,16 @@ package core
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"runtime"
 )
 
 func isFile(path string) bool {
 	stat, err := os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
+	if err != nil {
+		// This includes the case that the path does not exist.
 		return false
 	}
 	// We consider anything that exists and is no directory to be a file. This could be symlinks or
@@ -37,7 +40,7 @@ func isFile(path string) bool {
 
 func isDir(path string) bool {
 	stat, err := os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
+	if err != nil {
 		return false
 	}
 	return stat.IsDir()
@@ -51,6 +54,7 @@ type fileOps interface {
 	Write(b []byte) (n int, err error)
 	Close() error
 	Read(p []byte) (n int, err error)
+	Sync() error
 }
 
 var openFile = openFileImpl
@@ -59,6 +63,43 @@ func openFileImpl(name string, flag int, perm fs.FileMode) (fileOps, error) {
 	return os.OpenFile(name, flag, perm) // nolint: gosec
 }
 
+// Write everything that can be read from a reader to a file, replacing existing content. The
+// content is flushed to disk before the file is closed.
+func writeFile(path string, content io.Reader) (err error) {
+	handle, err := openFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := handle.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(handle, content)
+	if err == nil {
+		err = handle.Sync()
+	}
+	return err
+}
+
+// Flush a directory to disk, which makes sure that renames of files in it are persisted. Windows
+// does not support syncing directories, so that is skipped there.
+func syncDir(path string) (err error) {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	handle, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := handle.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	return handle.Sync()
+}
+
 func errorIfExists(path, message string) error {
 	_, err := os.Stat(path)
 	if errors.Is(err, os.ErrNotExist) {
@@ -18,8 +18,10 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -67,6 +69,65 @@ func TestOpenFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWriteFile(t *testing.T) {
+	tmp := t.TempDir()
+	tmpFile := filepath.Join(tmp, "file")
+
+	err := writeFile(tmpFile, strings.NewReader("some content"))
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(tmpFile) //nolint:gosec
+	assert.NoError(t, err)
+	assert.Equal(t, "some content", string(content))
+}
+
+func TestWriteFileFailure(t *testing.T) {
+	tmp := t.TempDir()
+	tmpFile := filepath.Join(tmp, "missing_dir", "file")
+
+	err := writeFile(tmpFile, strings.NewReader("some content"))
+
+	assert.Error(t, err)
+}
+
+func TestWriteFileCloseFailure(t *testing.T) {
+	f, deferMe := setUpMockOldmailFile()
+	defer deferMe()
+	f.m.On("Write", []byte("some content")).Return(12, nil)
+	f.m.On("Sync").Return(nil)
+	f.m.On("Close").Return(fmt.Errorf("some error"))
+
+	err := writeFile("some file", strings.NewReader("some content"))
+
+	assert.Error(t, err)
+	f.m.AssertExpectations(t)
+}
+
+func TestWriteFileSyncFailure(t *testing.T) {
+	f, deferMe := setUpMockOldmailFile()
+	defer deferMe()
+	f.m.On("Write", []byte("some content")).Return(12, nil)
+	f.m.On("Sync").Return(fmt.Errorf("some error"))
+	f.m.On("Close").Return(nil)
+
+	err := writeFile("some file", strings.NewReader("some content"))
+
+	assert.ErrorContains(t, err, "some error")
+	f.m.AssertExpectations(t)
+}
+
+func TestSyncDir(t *testing.T) {
+	err := syncDir(t.TempDir())
+
+	assert.NoError(t, err)
+}
+
+func TestSyncDirMissing(t *testing.T) {
+	err := syncDir(filepath.Join(t.TempDir(), "missing_dir"))
+
+	assert.Error(t, err)
+}
+
 func TestErrorIfExistsSuccess(t *testing.T) {
 	tmp := t.TempDir()
 	tmpFile := filepath.Join(tmp, "file")
@@ -0,0 +1,176 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/utf7"
+)
+
+const (
+	// The capability of servers that support Gmail's IMAP extensions.
+	gmailCapability = "X-GM-EXT-1"
+	// The fetch items of Gmail's IMAP extensions that provide the labels of an email, its unique
+	// ID, and the ID of the thread it belongs to.
+	gmailLabelsItem   imap.FetchItem = "X-GM-LABELS"
+	gmailMsgIDItem    imap.FetchItem = "X-GM-MSGID"
+	gmailThreadIDItem imap.FetchItem = "X-GM-THRID"
+	// The name of the file in a maildir that lists the Gmail labels of emails, one per line.
+	gmailLabelsName = "imapgrab-gmail.jsonl"
+)
+
+// GmailInfo contains the information about an email that Gmail provides via its IMAP extensions,
+// see DownloadOptions.GmailLabels.
+type GmailInfo struct {
+	// MsgID identifies the email across all of Gmail's folders, i.e. labels.
+	MsgID uint64 `json:"msgid,omitempty"`
+	// ThreadID identifies the conversation the email belongs to.
+	ThreadID uint64 `json:"thrid,omitempty"`
+	// Labels are the labels of the email, e.g. "\Important" or "Receipts".
+	Labels []string `json:"labels"`
+}
+
+// Determine whether a fetch item is one of Gmail's.
+func isGmailFetchItem(item imap.FetchItem) bool {
+	return item == gmailLabelsItem || item == gmailMsgIDItem || item == gmailThreadIDItem
+}
+
+// Set a field from the value of one of Gmail's fetch items.
+func (g *GmailInfo) set(item imap.FetchItem, value interface{}) (err error) {
+	switch item {
+	case gmailLabelsItem:
+		return g.setLabels(value)
+	case gmailMsgIDItem:
+		g.MsgID, err = strconv.ParseUint(fmt.Sprint(value), 10, 64)
+	case gmailThreadIDItem:
+		g.ThreadID, err = strconv.ParseUint(fmt.Sprint(value), 10, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("unexpected value %v for %s", value, item)
+	}
+	return nil
+}
+
+// Set the labels from the value of the X-GM-LABELS fetch item. Like folder names, labels are
+// encoded in modified UTF-7. Labels that cannot be decoded are kept as they are.
+func (g *GmailInfo) setLabels(value interface{}) error {
+	labels, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected labels %v", value)
+	}
+	g.Labels = make([]string, 0, len(labels))
+	for _, label := range labels {
+		text, err := imap.ParseString(label)
+		if err != nil {
+			return fmt.Errorf("unexpected label %v", label)
+		}
+		if decoded, err := utf7.Encoding.NewDecoder().String(text); err == nil {
+			text = decoded
+		}
+		g.Labels = append(g.Labels, text)
+	}
+	return nil
+}
+
+// Determine whether a server is one of Gmail's. Servers whose host names do not give that away,
+// e.g. when connecting via a proxy, are detected via the capability of Gmail's IMAP extensions.
+func detectGmail(server string, ops ImapgrabOps) bool {
+	if isGmailServer(server) {
+		return true
+	}
+	capabilities, err := ops.getCapabilities()
+	if err != nil {
+		logWarning(fmt.Sprintf("cannot determine whether server is Gmail's: %s", err.Error()))
+		return false
+	}
+	for _, capability := range capabilities {
+		if strings.EqualFold(capability, gmailCapability) {
+			return true
+		}
+	}
+	logWarning("server is not Gmail's, not retrieving Gmail labels")
+	return false
+}
+
+// Type gmailLabelsEntry is a single line in the file listing Gmail labels.
+type gmailLabelsEntry struct {
+	UIDValidity uidFolder `json:"uidvalidity"`
+	UID         uid       `json:"uid"`
+	GmailInfo
+}
+
+// Type gmailLabelsStorer appends the Gmail labels and IDs of each email to a file in the folder's
+// maildir, see DownloadOptions.GmailLabels. Like structureStorer, it is meant to be used as an
+// additional storer. Emails whose labels are unknown are skipped. The file is opened on the first
+// write and must be closed once done.
+type gmailLabelsStorer struct {
+	path string
+	file fileOps
+}
+
+func newGmailLabelsStorer(folderPath string) *gmailLabelsStorer {
+	return &gmailLabelsStorer{path: filepath.Join(folderPath, gmailLabelsName)}
+}
+
+// Exists never reports an email as existing since only the primary storer determines that.
+func (s *gmailLabelsStorer) Exists(string) (bool, error) {
+	return false, nil
+}
+
+// Write appends the Gmail labels and IDs of an email as a single line of JSON. The content is not
+// needed.
+func (s *gmailLabelsStorer) Write(info EmailInfo, _ io.Reader) (err error) {
+	if info.Gmail == nil {
+		logWarning(fmt.Sprintf("no Gmail labels known for email %s, skipping it", info.Key))
+		return nil
+	}
+	entry := gmailLabelsEntry{GmailInfo: *info.Gmail}
+	_, err = fmt.Sscanf(info.Key, "%d/%d", &entry.UIDValidity, &entry.UID)
+	var line []byte
+	if err == nil {
+		line, err = json.Marshal(entry)
+	}
+	if err == nil && s.file == nil {
+		logInfo(fmt.Sprintf("opening Gmail labels file %s", s.path))
+		var file fileOps
+		if file, err = openFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm); err == nil {
+			s.file = file
+		}
+	}
+	// Write each line with a single call to avoid partial entries in case of errors.
+	if err == nil {
+		_, err = s.file.Write(append(line, '\n'))
+	}
+	return err
+}
+
+// Close closes the file, if it has been opened.
+func (s *gmailLabelsStorer) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,121 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGmailInfoSet(t *testing.T) {
+	info := GmailInfo{}
+
+	assert.NoError(t, info.set(gmailMsgIDItem, "1278455344230334865"))
+	assert.NoError(t, info.set(gmailThreadIDItem, uint32(42)))
+	// Labels are decoded like folder names.
+	assert.NoError(t, info.set(
+		gmailLabelsItem, []interface{}{"\\Inbox", "Caf&AOk-", imap.RawString("Work")},
+	))
+
+	assert.Equal(
+		t,
+		GmailInfo{
+			MsgID: 1278455344230334865, ThreadID: 42, Labels: []string{"\\Inbox", "Café", "Work"},
+		},
+		info,
+	)
+}
+
+func TestGmailInfoSetErrors(t *testing.T) {
+	info := GmailInfo{}
+
+	assert.ErrorContains(t, info.set(gmailMsgIDItem, "not a number"), "unexpected value")
+	assert.ErrorContains(t, info.set(gmailThreadIDItem, nil), "unexpected value")
+	assert.ErrorContains(t, info.set(gmailLabelsItem, "not a list"), "unexpected labels")
+	assert.ErrorContains(t, info.set(gmailLabelsItem, []interface{}{42}), "unexpected label")
+}
+
+func TestIsGmailFetchItem(t *testing.T) {
+	assert.True(t, isGmailFetchItem("X-GM-LABELS"))
+	assert.True(t, isGmailFetchItem("X-GM-MSGID"))
+	assert.True(t, isGmailFetchItem("X-GM-THRID"))
+	assert.False(t, isGmailFetchItem(imap.FetchFlags))
+}
+
+func TestDetectGmail(t *testing.T) {
+	byName := &mockImapgrabber{}
+	assert.True(t, detectGmail("imap.gmail.com", byName))
+	// The capabilities are not needed for servers with Gmail's host names.
+	byName.AssertExpectations(t)
+
+	byCapability := &mockImapgrabber{}
+	byCapability.On("getCapabilities").Return([]string{"IMAP4rev1", "X-GM-EXT-1"}, nil)
+	assert.True(t, detectGmail("127.0.0.1", byCapability))
+	byCapability.AssertExpectations(t)
+
+	other := &mockImapgrabber{}
+	other.On("getCapabilities").Return([]string{"IMAP4rev1"}, nil)
+	assert.False(t, detectGmail("imap.example.com", other))
+	other.AssertExpectations(t)
+
+	failing := &mockImapgrabber{}
+	failing.On("getCapabilities").Return([]string{}, fmt.Errorf("some error"))
+	assert.False(t, detectGmail("imap.example.com", failing))
+	failing.AssertExpectations(t)
+}
+
+func TestGmailLabelsStorer(t *testing.T) {
+	folderPath := t.TempDir()
+	storer := newGmailLabelsStorer(folderPath)
+
+	exists, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	info := &GmailInfo{MsgID: 10, ThreadID: 9, Labels: []string{"\\Important"}}
+	assert.NoError(t, storer.Write(EmailInfo{Key: "42/1", Gmail: info}, nil))
+	// Emails without labels are skipped.
+	assert.NoError(t, storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("content")))
+	assert.NoError(t, storer.Write(EmailInfo{Key: "42/3", Gmail: &GmailInfo{}}, nil))
+	assert.NoError(t, storer.Close())
+
+	content, err := os.ReadFile(filepath.Join(folderPath, gmailLabelsName))
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`{"uidvalidity":42,"uid":1,"msgid":10,"thrid":9,"labels":["\\Important"]}`+"\n"+
+			`{"uidvalidity":42,"uid":3,"labels":null}`+"\n",
+		string(content),
+	)
+}
+
+func TestGmailLabelsStorerErrors(t *testing.T) {
+	storer := newGmailLabelsStorer(filepath.Join(t.TempDir(), "missing"))
+	info := &GmailInfo{Labels: []string{}}
+
+	assert.Error(t, storer.Write(EmailInfo{Key: "invalid", Gmail: info}, nil))
+	assert.Error(t, storer.Write(EmailInfo{Key: "42/1", Gmail: info}, nil))
+	// Nothing has been opened.
+	assert.NoError(t, storer.Close())
+}
@@ -19,8 +19,9 @@ package core
 
 import "fmt"
 
-// Determine the UIDs of emails that have not yet been downloaded.
-func determineMissingUIDs(oldmails []oldmail, uids []uidExt) ([]uid, error) {
+// Determine the UIDs of emails that have not yet been downloaded. The storer decides which emails
+// have already been stored while the oldmail data is used to check for consistency.
+func determineMissingUIDs(oldmails []oldmail, uids []uidExt, storer Storer) ([]uid, error) {
 	// Check special cases such as an empty mailbox or uidvalidities that do not agree.
 	if len(uids) == 0 {
 		return []uid{}, nil
@@ -39,20 +40,49 @@ func determineMissingUIDs(oldmails []oldmail, uids []uidExt) ([]uid, error) {
 		}
 	}
 
-	// Add the UIDs of the oldmail data (the data stored on disk) to a map to simplify determining
-	// whether we've already downloaded some message.
-	oldmailUIDs := make(map[uid]struct{}, len(oldmails))
-	for _, msg := range oldmails {
-		oldmailUIDs[msg.uid] = struct{}{}
-	}
-
 	missingUIDs := []uid{}
-	// Determine which UIDs are missing on disk.
+	// Determine which UIDs are missing in storage.
 	for _, msg := range uids {
-		if _, found := oldmailUIDs[msg.msg]; !found {
+		found, err := storer.Exists(msg.String())
+		if err != nil {
+			return []uid{}, err
+		}
+		if !found {
 			missingUIDs = append(missingUIDs, msg.msg)
 		}
 	}
 
 	return missingUIDs, nil
 }
+
+// Determine the UIDs of all emails irrespective of whether they have been downloaded before.
+func allUIDs(uids []uidExt) []uid {
+	all := make([]uid, 0, len(uids))
+	for _, msg := range uids {
+		all = append(all, msg.msg)
+	}
+	return all
+}
+
+// Remove duplicate UIDs, which buggy servers rarely report, keeping the first occurrence of each.
+// Otherwise, such emails would be retrieved and stored several times. UIDs are only duplicates if
+// their UIDVALIDITY agrees, too.
+func dedupUIDs(uids []uidExt) []uidExt {
+	type key struct {
+		folder uidFolder
+		msg    uid
+	}
+	seen := make(map[key]struct{}, len(uids))
+	unique := uids[:0]
+	for _, msg := range uids {
+		if _, found := seen[key{msg.folder, msg.msg}]; found {
+			continue
+		}
+		seen[key{msg.folder, msg.msg}] = struct{}{}
+		unique = append(unique, msg)
+	}
+	if duplicates := len(uids) - len(unique); duplicates > 0 {
+		logWarning(fmt.Sprintf("server reported %d duplicate UIDs, ignoring them", duplicates))
+	}
+	return unique
+}
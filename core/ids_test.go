@@ -18,6 +18,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,7 +28,7 @@ func TestDetermineMissingIDsEmptyData(t *testing.T) {
 	oldmails := []oldmail{}
 	uids := []uidExt{}
 
-	missingIDs, err := determineMissingUIDs(oldmails, uids)
+	missingIDs, err := determineMissingUIDs(oldmails, uids, newMaildirStorer("", oldmails))
 
 	assert.NoError(t, err)
 	assert.Equal(t, []uid{}, missingIDs)
@@ -48,7 +49,7 @@ func TestDetermineMissingIDsEverythingDownloaded(t *testing.T) {
 	orgUIDs := make([]uidExt, len(uids))
 	_ = copy(orgUIDs, uids)
 
-	missingIDs, err := determineMissingUIDs(oldmails, uids)
+	missingIDs, err := determineMissingUIDs(oldmails, uids, newMaildirStorer("", oldmails))
 
 	assert.NoError(t, err)
 	assert.Equal(t, orgUIDs, uids)
@@ -70,7 +71,7 @@ func TestDetermineMissingIDsSomeMissing(t *testing.T) {
 	orgUIDs := make([]uidExt, len(uids))
 	_ = copy(orgUIDs, uids)
 
-	missingIDs, err := determineMissingUIDs(oldmails, uids)
+	missingIDs, err := determineMissingUIDs(oldmails, uids, newMaildirStorer("", oldmails))
 
 	assert.NoError(t, err)
 	assert.Equal(t, orgUIDs, uids)
@@ -88,7 +89,7 @@ func TestDetermineMissingIDsMismatchesInRemoteData(t *testing.T) {
 	}
 
 	// UIDs are not consistent in uids slice.
-	_, err := determineMissingUIDs([]oldmail{}, uids)
+	_, err := determineMissingUIDs([]oldmail{}, uids, newMaildirStorer("", []oldmail{}))
 	assert.Error(t, err)
 }
 
@@ -101,7 +102,7 @@ func TestDetermineMissingIDsMismatches(t *testing.T) {
 	}
 
 	// UIDs are not consistent between uid and oldmails slices.
-	_, err := determineMissingUIDs(oldmails, uids)
+	_, err := determineMissingUIDs(oldmails, uids, newMaildirStorer("", oldmails))
 	assert.Error(t, err)
 }
 
@@ -121,8 +122,57 @@ func TestDetermineMissingIDsSomeMissingNonconsecutiveRanges(t *testing.T) {
 		{folder: 0, msg: 6},
 	}
 
-	missingIDs, err := determineMissingUIDs(oldmails, uids)
+	missingIDs, err := determineMissingUIDs(oldmails, uids, newMaildirStorer("", oldmails))
 
 	assert.NoError(t, err)
 	assert.Equal(t, []uid{2, 5}, missingIDs)
 }
+
+func TestDetermineMissingIDsStorerDecides(t *testing.T) {
+	uids := []uidExt{{folder: 0, msg: 1}, {folder: 0, msg: 2}}
+
+	ms := &mockStorer{}
+	ms.On("Exists", "0/1").Return(true, nil)
+	ms.On("Exists", "0/2").Return(false, nil)
+
+	missingIDs, err := determineMissingUIDs([]oldmail{}, uids, ms)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uid{2}, missingIDs)
+	ms.AssertExpectations(t)
+}
+
+func TestDetermineMissingIDsStorerError(t *testing.T) {
+	uids := []uidExt{{folder: 0, msg: 1}, {folder: 0, msg: 2}}
+
+	ms := &mockStorer{}
+	ms.On("Exists", "0/1").Return(false, fmt.Errorf("some error"))
+
+	missingIDs, err := determineMissingUIDs([]oldmail{}, uids, ms)
+
+	assert.Error(t, err)
+	assert.Empty(t, missingIDs)
+	ms.AssertExpectations(t)
+}
+
+func TestDedupUIDs(t *testing.T) {
+	uids := []uidExt{
+		{folder: 42, msg: 1, size: 10},
+		{folder: 42, msg: 2},
+		{folder: 42, msg: 1, size: 20},
+		{folder: 43, msg: 1},
+	}
+
+	unique := dedupUIDs(uids)
+
+	expected := []uidExt{{folder: 42, msg: 1, size: 10}, {folder: 42, msg: 2}, {folder: 43, msg: 1}}
+	assert.Equal(t, expected, unique)
+	assert.Empty(t, dedupUIDs(nil))
+}
+
+func TestAllUIDs(t *testing.T) {
+	uids := []uidExt{{folder: 42, msg: 3}, {folder: 42, msg: 1, size: 10}}
+
+	assert.Equal(t, []uid{3, 1}, allUIDs(uids))
+	assert.Empty(t, allUIDs(nil))
+}
@@ -18,30 +18,95 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-sasl"
 )
 
 const (
-	folderListBuffer       = 10
-	messageRetrievalBuffer = 20
+	defaultFolderListBuffer       = 10
+	defaultMessageRetrievalBuffer = 20
+	// The capability of servers that support the SASL PLAIN mechanism.
+	plainAuthCapability = "AUTH=PLAIN"
+	// The capability of servers that forbid logging in until the connection is encrypted.
+	loginDisabledCapability = "LOGINDISABLED"
+	// The number of TLS sessions that are cached for resumption, see IMAPConfig.NoTLSSessionCache.
+	tlsSessionCacheSize = 64
+	// How often to try to retrieve information about all emails of a folder if the server does not
+	// report one UID per email.
+	uidListAttempts = 2
+	// How often to request emails again that the server did not return when they were requested.
+	missingEmailRetries = 2
 )
 
+// The fetch item retrieving the full content of emails for servers that reject RFC822. It provides
+// the same bytes but does not implicitly mark emails as seen.
+var fetchEntireBody = (&imap.BodySectionName{Peek: true}).FetchItem()
+
+// Some servers report fewer UIDs than they report emails in a folder.
+var errUIDCountMismatch = errors.New("server reported an unexpected number of UIDs")
+
+// Errors returned when connecting to or authenticating with a server. Underlying errors are wrapped
+// so that callers can use errors.Is to distinguish, e.g., authentication from network failures.
+var (
+	// ErrEmptyPassword is returned if no password has been given but one is needed.
+	ErrEmptyPassword = errors.New("password not set")
+	// ErrInsecureNonLocalhost is returned if an insecure connection to a remote host is requested.
+	ErrInsecureNonLocalhost = errors.New("not allowing insecure auth for non-localhost address")
+	// ErrConnectFailed is returned if no connection to the server can be established.
+	ErrConnectFailed = errors.New("cannot connect")
+	// ErrLoginFailed is returned if the server rejects the login or the login cannot be attempted.
+	ErrLoginFailed = errors.New("cannot log in")
+	// ErrLoginDisabled is returned if the server forbids logging in over an unencrypted connection.
+	ErrLoginDisabled = errors.New("server does not allow logging in without TLS")
+)
+
+// ErrFolderMissing is returned if a folder does not exist on the server. Callers can use errors.Is
+// to skip such folders or, when writing to the server, to create them via createFolder.
+var ErrFolderMissing = errors.New("folder does not exist")
+
+// Servers only state the TRYCREATE and NONEXISTENT response codes along with a human-readable
+// text, which is all that the IMAP library reports. Thus, missing folders are detected via the
+// texts that common servers use, compared in lower case.
+var missingFolderTexts = []string{
+	"doesn't exist", "does not exist", "no such mailbox", "no such folder", "unknown mailbox",
+	"unknown folder", "mailbox not found", "folder not found", "nonexistent",
+}
+
+// Determine whether an error returned by the server states that a folder does not exist.
+func isMissingFolderError(err error) bool {
+	if err == nil {
+		return false
+	}
+	text := strings.ToLower(err.Error())
+	for _, missing := range missingFolderTexts {
+		if strings.Contains(text, missing) {
+			return true
+		}
+	}
+	return false
+}
+
 // Make this a function pointer to simplify testing. Also takes a boolean to decide whether to use
 // secure auth nor not (i.e. TLS). This errors out if insecure auth is chossen but anything other
-// than "127.0.0.1" is passed as "addr".
-var newImapClient = func(addr string, insecure bool) (imap imapOps, err error) {
+// than "127.0.0.1" is passed as "addr". A nil TLS config results in automatic configuration of TLS
+// options.
+var newImapClient = func(
+	addr string, insecure bool, tlsConfig *tls.Config,
+) (imap imapOps, err error) {
 	if !insecure {
-		// Use automatic configuration of TLS options.
-		imap, err = client.DialTLS(addr, nil)
+		imap, err = client.DialTLS(addr, tlsConfig)
 	} else if !strings.HasPrefix(addr, "127.0.0.1:") {
-		err = fmt.Errorf(
-			"not allowing insecure auth for non-localhost address %s, use 127.0.0.1", addr,
-		)
+		err = fmt.Errorf("%w %s, use 127.0.0.1", ErrInsecureNonLocalhost, addr)
 	} else {
 		logWarning("using insecure connection to locahost")
 		imap, err = client.Dial(addr)
@@ -49,53 +114,264 @@ var newImapClient = func(addr string, insecure bool) (imap imapOps, err error) {
 	return
 }
 
+// The cache of TLS sessions shared by all connections of this process. Sessions are cached per
+// server name, which is why a single cache suffices for all servers.
+var tlsSessionCache = tls.NewLRUClientSessionCache(tlsSessionCacheSize)
+
+// Determine the TLS options to use. Nil means automatic configuration, which derives the server
+// name to verify the certificate against from the dial address. The same happens if no server name
+// is set explicitly.
+func (c IMAPConfig) tlsConfig() *tls.Config {
+	if c.TLSServerName == "" && c.NoTLSSessionCache {
+		return nil
+	}
+	config := &tls.Config{ServerName: c.TLSServerName, MinVersion: tls.VersionTLS12}
+	if !c.NoTLSSessionCache {
+		config.ClientSessionCache = tlsSessionCache
+	}
+	return config
+}
+
+// Type bufferSizes contains the sizes of buffers used while retrieving folders and emails.
+type bufferSizes struct {
+	folderList int
+	messages   int
+}
+
+// Determine the buffer sizes to use, applying defaults where none are configured.
+func (c IMAPConfig) bufferSizes() bufferSizes {
+	sizes := bufferSizes{folderList: c.FolderListBuffer, messages: c.MessageBuffer}
+	if sizes.folderList <= 0 {
+		sizes.folderList = defaultFolderListBuffer
+	}
+	if sizes.messages <= 0 {
+		sizes.messages = defaultMessageRetrievalBuffer
+	}
+	return sizes
+}
+
 type imapOps interface {
 	Login(username string, password string) error
+	Authenticate(auth sasl.Client) error
 	List(ref string, name string, ch chan *imap.MailboxInfo) error
+	Lsub(ref string, name string, ch chan *imap.MailboxInfo) error
 	Select(name string, readOnly bool) (*imap.MailboxStatus, error)
+	Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error)
+	Capability() (map[string]bool, error)
+	Support(capability string) (bool, error)
 	Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
 	UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
+	Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error)
+	UidMove(seqset *imap.SeqSet, dest string) error
+	UidCopy(seqset *imap.SeqSet, dest string) error
+	UidStore(seqset *imap.SeqSet, item imap.StoreItem, value interface{}, ch chan *imap.Message) error
+	Expunge(ch chan uint32) error
+	UidSearch(criteria *imap.SearchCriteria) ([]uint32, error)
+	Upgrade(upgrader imap.ConnUpgrader) error
 	Logout() error
 	Terminate() error
+	State() imap.ConnState
+	Noop() error
+	Create(name string) error
+	SetDebug(w io.Writer)
 }
 
 func authenticateClient(config IMAPConfig) (imapClient imapOps, err error) {
-	if len(config.Password) == 0 {
-		logError("empty password detected")
-		err = fmt.Errorf("password not set")
+	imapClient, err = connectAndLoginWithRetries(config)
+	// Access tokens are reused until they expire but might have been revoked before. Then, a new
+	// one is obtained and logging in is attempted once more.
+	if errors.Is(err, errTokenRejected) {
+		logWarning("OAuth2 access token rejected, reconnecting with new access token")
+		if imapClient != nil {
+			_ = imapClient.Terminate()
+		}
+		config.OAuth2.source().refresh()
+		imapClient, err = connectAndLoginWithRetries(config)
+	}
+	if err == nil && config.Compress {
+		err = enableCompression(imapClient)
+	}
+	if err != nil {
 		return nil, err
 	}
+	return imapClient, nil
+}
 
-	logInfo(fmt.Sprintf("connecting to server %s", config.Server))
-	serverWithPort := fmt.Sprintf("%s:%d", config.Server, config.Port)
-	if imapClient, err = newImapClient(serverWithPort, config.Insecure); err != nil {
+// Connect to a server and log in. If logging in fails, the connected client is returned along with
+// the error so that the connection can be terminated before retrying.
+func connectAndLogin(config IMAPConfig) (imapClient imapOps, err error) {
+	if config.FolderListBuffer < 0 || config.MessageBuffer < 0 {
+		return nil, fmt.Errorf("buffer sizes must be positive")
+	}
+	if config.AuthzID != "" && config.OAuth2.enabled() {
+		logError("authorization identity given for OAuth2")
+		return nil, fmt.Errorf("an authorization identity cannot be used with OAuth2")
+	}
+	if config.AuthMethod != "" && config.OAuth2.enabled() {
+		logError("authentication method given for OAuth2")
+		return nil, fmt.Errorf("an authentication method cannot be used with OAuth2")
+	}
+	// Without a password, connecting only serves to find out whether the server preauthenticates
+	// the connection.
+	emptyPassword := len(config.Password) == 0 && !config.OAuth2.enabled()
+	if emptyPassword {
+		logInfo("empty password detected, checking whether the server preauthenticates")
+	}
+
+	var serverWithPort string
+	if imapClient, serverWithPort, err = connectWithFailover(config); err != nil {
 		logError("cannot connect")
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrConnectFailed, err)
+	}
+	logInfo(fmt.Sprintf("connected to %s", serverWithPort))
+	if config.ProtocolLog != nil {
+		logInfo("writing protocol exchange to log")
+		imapClient.SetDebug(newProtocolLog(config.ProtocolLog))
+	}
+
+	// Servers greeting with PREAUTH have already authenticated the connection, e.g. for local
+	// setups, and do not accept any login. Thus, no password is needed, either.
+	if imapClient.State() == imap.AuthenticatedState {
+		logInfo("connection has been preauthenticated by the server, not logging in")
+		return imapClient, nil
+	}
+	if emptyPassword {
+		logError("empty password detected")
+		_ = imapClient.Terminate()
+		return nil, ErrEmptyPassword
+	}
+
+	if config.OAuth2.enabled() {
+		return authenticateOAuth2(imapClient, config)
+	}
+
+	if err = checkLoginAllowed(imapClient, config); err != nil {
+		logError("cannot log in")
+		return imapClient, fmt.Errorf("%w: %w", ErrLoginFailed, err)
+	}
+
+	if config.AuthMethod != "" {
+		return authenticateScram(imapClient, config)
+	}
+
+	if config.AuthzID != "" {
+		return authenticatePlain(imapClient, config)
 	}
-	logInfo("connected")
 
 	logInfo(fmt.Sprintf("logging in as %s with provided password", config.User))
 	if err = imapClient.Login(config.User, config.Password); err != nil {
 		logError("cannot log in")
-		return nil, err
+		return imapClient, fmt.Errorf("%w: %w", ErrLoginFailed, err)
 	}
 	logInfo("logged in")
 
 	return imapClient, nil
 }
 
-func getFolderList(imapClient imapOps) (folders []string, err error) {
-	logInfo("retrieving folders")
-	mailboxes := make(chan *imap.MailboxInfo, folderListBuffer)
+// Servers advertising LOGINDISABLED reject any password-based login until TLS has been negotiated.
+// Attempting it regardless results in a rather baffling error, so detect the situation up front.
+// Only unencrypted connections need checking, since TLS is established right when connecting
+// otherwise. STARTTLS is not supported, so the only remedy is connecting via TLS right away.
+func checkLoginAllowed(imapClient imapOps, config IMAPConfig) error {
+	if !config.Insecure {
+		return nil
+	}
+	disabled, err := imapClient.Support(loginDisabledCapability)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		return fmt.Errorf(
+			"%w: the server advertises %s, connect to a port that uses TLS instead",
+			ErrLoginDisabled, loginDisabledCapability,
+		)
+	}
+	return nil
+}
+
+// Authenticate via the SASL PLAIN mechanism, which is the only one supported that allows for an
+// authorization identity distinct from the authentication one. The LOGIN command does not.
+func authenticatePlain(imapClient imapOps, config IMAPConfig) (imapOps, error) {
+	supported, err := imapClient.Support(plainAuthCapability)
+	if err == nil && !supported {
+		err = fmt.Errorf(
+			"server does not support %s, which is needed for an authorization identity",
+			plainAuthCapability,
+		)
+	}
+	if err == nil {
+		logInfo(fmt.Sprintf("logging in as %s to act as %s", config.User, config.AuthzID))
+		err = imapClient.Authenticate(
+			sasl.NewPlainClient(config.AuthzID, config.User, config.Password),
+		)
+	}
+	if err != nil {
+		logError("cannot log in")
+		return imapClient, fmt.Errorf("%w: %w", ErrLoginFailed, err)
+	}
+	logInfo("logged in")
+	return imapClient, nil
+}
+
+func getFolderList(
+	imapClient imapOps, bufferSize int, subscribedOnly, sharedFolders bool,
+) (folders []string, err error) {
+	list := listFolders
+	if sharedFolders {
+		list = listFoldersWithNamespaces
+	}
+	infos, err := list(imapClient, bufferSize, subscribedOnly)
+	for _, info := range infos {
+		folders = append(folders, info.Name)
+	}
+	return folders, err
+}
+
+// Retrieve all folders including their attributes. If requested, retrieve only the folders the user
+// is subscribed to via LSUB instead. Note that servers might not report special uses via LSUB.
+func listFolders(
+	imapClient imapOps, bufferSize int, subscribedOnly bool,
+) (folders []FolderInfo, err error) {
+	if subscribedOnly {
+		logInfo("retrieving subscribed folders")
+	} else {
+		logInfo("retrieving folders")
+	}
+	folders, err = listFoldersMatching(imapClient, bufferSize, subscribedOnly, "*")
+	logInfo(fmt.Sprintf("retrieved %d folders", len(folders)))
+	return folders, err
+}
+
+// Retrieve all folders whose names match a pattern including their attributes.
+func listFoldersMatching(
+	imapClient imapOps, bufferSize int, subscribedOnly bool, pattern string,
+) (folders []FolderInfo, err error) {
+	list := imapClient.List
+	if subscribedOnly {
+		list = imapClient.Lsub
+	}
+	mailboxes := make(chan *imap.MailboxInfo, bufferSize)
+	errChannel := make(chan error, 1)
 	go func() {
-		err = imapClient.List("", "*", mailboxes)
+		errChannel <- list("", pattern, mailboxes)
 	}()
 	for m := range mailboxes {
-		folders = append(folders, m.Name)
+		folders = append(folders, FolderInfo{Name: m.Name, Attributes: m.Attributes})
 	}
-	logInfo(fmt.Sprintf("retrieved %d folders", len(folders)))
+	return folders, <-errChannel
+}
 
-	return folders, err
+func getCapabilities(imapClient imapOps) ([]string, error) {
+	logInfo("retrieving capabilities")
+	caps, err := imapClient.Capability()
+	capabilities := make([]string, 0, len(caps))
+	for capability, supported := range caps {
+		if supported {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities, err
 }
 
 func selectFolder(imapClient imapOps, folder string) (*imap.MailboxStatus, error) {
@@ -106,29 +382,67 @@ func selectFolder(imapClient imapOps, folder string) (*imap.MailboxStatus, error
 		logInfo(fmt.Sprint("flags for selected folder are", mbox.Flags))
 		logInfo(fmt.Sprintf("selected folder contains %d emails", mbox.Messages))
 	}
+	if isMissingFolderError(err) {
+		err = fmt.Errorf("%w: %s: %w", ErrFolderMissing, folder, err)
+	}
 	return mbox, err
 }
 
+// Create a folder on the server, e.g. after an operation failed with ErrFolderMissing.
+func createFolder(imapClient imapOps, folder string) error {
+	logInfo(fmt.Sprintf("creating folder %s", folder))
+	if err := imapClient.Create(folder); err != nil {
+		return fmt.Errorf("cannot create folder %s: %s", folder, err.Error())
+	}
+	return nil
+}
+
 // Type once behaves like sync.Once but we can also query whether it has already been called. This
-// is needed because sync.Once does not provide a facility to check that.
+// is needed because sync.Once does not provide a facility to check that. It can also be reset to be
+// reused, e.g. between repeated runs.
+//
+// All methods are safe for concurrent use. The hook is executed at most once between resets, and
+// concurrent calls block until it has finished. A call that is already in progress while Reset is
+// called completes normally but does not count towards the reset instance.
 type once struct {
 	called bool
 	hook   func()
-	sync.Once
+	inner  *sync.Once
+	mutex  sync.Mutex
 }
 
 func (o *once) call() {
-	o.Do(o.hook)
+	o.mutex.Lock()
+	inner := o.inner
+	o.mutex.Unlock()
+	inner.Do(func() {
+		o.mutex.Lock()
+		// Only mark as called if there has been no reset in the meantime.
+		if o.inner == inner {
+			o.called = true
+		}
+		o.mutex.Unlock()
+		o.hook()
+	})
+}
+
+// Determine whether the hook has been called since creation or the last reset.
+func (o *once) wasCalled() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.called
+}
+
+// Reset re-arms the once so that the next call executes the hook again.
+func (o *once) Reset() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.called = false
+	o.inner = &sync.Once{}
 }
 
 func newOnce(hook func()) *once {
-	o := once{}
-	innerHook := func() {
-		o.called = true
-		hook()
-	}
-	o.hook = innerHook
-	return &o
+	return &once{hook: hook, inner: &sync.Once{}}
 }
 
 // Obtain messages whose ids/indices lie in certain ranges. Negative indices are automatically
@@ -138,10 +452,17 @@ func newOnce(hook func()) *once {
 // In this function, we translate from *imap.Message to emailOps separately. Sadly, the compiler
 // does not auto-generate the code to use a `chan emailOps` as a `chan *imap.Message`. Thus, we need
 // a separate, second goroutine translating between the two. This second goroutine also handles
-// interrupts.
+// interrupts. The fetchItems determine what is retrieved for each message.
+//
+// Servers return the messages of a single fetch in ascending order. Thus, to retrieve messages in
+// the order given, they are fetched in batches of at most batchSize messages, following that order.
+// A batchSize of 0 or less retrieves all messages with a single fetch.
 func streamingRetrieval(
 	imapClient imapOps,
 	uids []uid,
+	fetchItems []imap.FetchItem,
+	batchSize int,
+	bufferSize int,
 	wg, startWg *sync.WaitGroup,
 	interrupted func() bool,
 ) (returnedChan <-chan emailOps, errCountPtr *int, err error) {
@@ -152,36 +473,75 @@ func streamingRetrieval(
 		}
 	}
 
-	// Emails will be retrieved via a SeqSet, which can contain a set of messages.
-	seqset := new(imap.SeqSet)
-	for _, uid := range uids {
-		seqset.AddNum(intToUint32(int(uid)))
-	}
+	// Emails will be retrieved via SeqSets, each of which can contain a set of messages.
+	seqsets := batchSeqSets(uids, batchSize)
 
 	wg.Add(1)
 	// Ensure we call "Done" exactly once on wg here.
 	already := newOnce(func() { wg.Done() })
 	var errCount int
-	translatedMessageChan := make(chan emailOps, messageRetrievalBuffer)
+	translatedMessageChan := make(chan emailOps, bufferSize)
 	orgMessageChan := make(chan *imap.Message)
 	go func() {
 		// Do not start before the entire pipeline has been set up.
 		startWg.Wait()
-		err := imapClient.UidFetch(
-			seqset,
-			[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822},
-			orgMessageChan,
-		)
-		if err != nil {
-			logError(err.Error())
+		received := map[uid]struct{}{}
+		failed := []*imap.SeqSet{}
+		for _, seqset := range seqsets {
+			if already.wasCalled() {
+				break
+			}
+			count, err := fetchBatch(imapClient, seqset, fetchItems, orgMessageChan, received)
+			// Some servers reject RFC822. Retry with BODY[] then, unless emails have been retrieved
+			// already, and keep using it for the remaining batches if that works.
+			fallback, replaced := replaceRFC822(fetchItems)
+			if err != nil && count == 0 && replaced {
+				logWarning(fmt.Sprintf(
+					"cannot retrieve emails via %s, retrying with %s: %s",
+					imap.FetchRFC822, fetchEntireBody, err.Error(),
+				))
+				_, err = fetchBatch(imapClient, seqset, fallback, orgMessageChan, received)
+				if err == nil {
+					fetchItems = fallback
+				}
+			}
+			if err != nil {
+				logError(err.Error())
+				errCount++
+				failed = append(failed, seqset)
+			}
+		}
+		// Emails of failed batches have been accounted for already.
+		missing := missingUIDs(uids, received, failed)
+		for attempt := 1; len(missing) > 0 && attempt <= missingEmailRetries; attempt++ {
+			if already.wasCalled() {
+				break
+			}
+			logWarning(fmt.Sprintf(
+				"server did not return %d requested emails, requesting them again (%d/%d)",
+				len(missing), attempt, missingEmailRetries,
+			))
+			for _, seqset := range batchSeqSets(missing, batchSize) {
+				_, err := fetchBatch(imapClient, seqset, fetchItems, orgMessageChan, received)
+				if err != nil {
+					logError(err.Error())
+					errCount++
+				}
+			}
+			missing = missingUIDs(missing, received, nil)
+		}
+		if len(missing) > 0 && !already.wasCalled() {
+			logError(fmt.Sprintf("server did not return emails with UIDs %v", missing))
 			errCount++
 		}
+		// Unblock the translating goroutine, which then notices that we are done.
+		close(orgMessageChan)
 		already.call()
 	}()
 
 	go func() {
 		defer close(translatedMessageChan)
-		for !already.called {
+		for !already.wasCalled() {
 			if interrupted() {
 				errCount++
 				already.call()
@@ -201,6 +561,84 @@ func streamingRetrieval(
 	return translatedMessageChan, &errCount, nil
 }
 
+// Split UIDs into SeqSets of at most batchSize elements each, keeping their order. A batchSize of 0
+// or less puts all UIDs into a single SeqSet.
+func batchSeqSets(uids []uid, batchSize int) []*imap.SeqSet {
+	if batchSize <= 0 || batchSize > len(uids) {
+		batchSize = len(uids)
+	}
+	seqsets := []*imap.SeqSet{new(imap.SeqSet)}
+	for idx, uid := range uids {
+		if idx > 0 && idx%batchSize == 0 {
+			seqsets = append(seqsets, new(imap.SeqSet))
+		}
+		seqsets[len(seqsets)-1].AddNum(intToUint32(int(uid)))
+	}
+	return seqsets
+}
+
+// Fetch a single batch of messages and forward them to a channel that is not closed afterwards.
+// This is needed because each fetch closes the channel it is given. The number of forwarded
+// messages is returned, too. The UIDs of all forwarded messages are added to the received ones.
+func fetchBatch(
+	imapClient imapOps,
+	seqset *imap.SeqSet,
+	items []imap.FetchItem,
+	ch chan<- *imap.Message,
+	received map[uid]struct{},
+) (int, error) {
+	batchChan := make(chan *imap.Message)
+	forwarded := make(chan struct{})
+	count := 0
+	go func() {
+		defer close(forwarded)
+		for msg := range batchChan {
+			if msg != nil {
+				received[uid(msg.Uid)] = struct{}{}
+			}
+			ch <- msg
+			count++
+		}
+	}()
+	err := imapClient.UidFetch(seqset, items, batchChan)
+	<-forwarded
+	return count, err
+}
+
+// Determine the requested UIDs for which no message has been received, skipping those requested
+// via any of the failed SeqSets. The order of the UIDs is kept.
+func missingUIDs(uids []uid, received map[uid]struct{}, failed []*imap.SeqSet) []uid {
+	missing := []uid{}
+	for _, id := range uids {
+		if _, found := received[id]; found {
+			continue
+		}
+		inFailed := false
+		for _, seqset := range failed {
+			inFailed = inFailed || seqset.Contains(intToUint32(int(id)))
+		}
+		if !inFailed {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// Replace RFC822 in a list of fetch items by BODY[], which provides the same content. The original
+// list is not modified. The second return value states whether RFC822 has been replaced.
+func replaceRFC822(items []imap.FetchItem) ([]imap.FetchItem, bool) {
+	replaced := false
+	result := make([]imap.FetchItem, 0, len(items))
+	for _, item := range items {
+		if item == imap.FetchRFC822 {
+			item = fetchEntireBody
+			replaced = true
+		}
+		result = append(result, item)
+	}
+	return result, replaced
+}
+
 // Type uid describes a message. It is a type alias to prevent accidental mixups.
 type uid int
 
@@ -213,6 +651,8 @@ type uidFolder int
 type uidExt struct {
 	folder uidFolder
 	msg    uid
+	// size is the size of the email in bytes according to the server, if retrieved.
+	size uint32
 }
 
 // String provides a string representation for a message's unique identifier.
@@ -221,7 +661,7 @@ func (u uidExt) String() string {
 }
 
 func getAllMessageUUIDs(
-	mbox *imap.MailboxStatus, imapClient imapOps,
+	mbox *imap.MailboxStatus, imapClient imapOps, bufferSize int,
 ) (uids []uidExt, err error) {
 	logInfo("retrieving information about emails stored on server")
 	// Handle the special case of empty folders by returning early.
@@ -229,17 +669,47 @@ func getAllMessageUUIDs(
 		return nil, nil
 	}
 
-	uids = make([]uidExt, 0, mbox.Messages)
+	// Buggy servers sometimes do not report all emails. Thus, try again in such a case. If that
+	// does not help, return what has been received along with an error.
+	for attempt := 1; attempt <= uidListAttempts; attempt++ {
+		uids, err = fetchAllMessageUUIDs(mbox, imapClient, bufferSize)
+		logInfo(fmt.Sprintf("received information for %d emails", len(uids)))
+		if err != nil || len(uids) == int(mbox.Messages) {
+			return uids, err
+		}
+		logWarning(fmt.Sprintf(
+			"received %d UIDs for %d emails on attempt %d/%d",
+			len(uids), mbox.Messages, attempt, uidListAttempts,
+		))
+	}
+	return uids, fmt.Errorf(
+		"%w: received %d for %d emails", errUIDCountMismatch, len(uids), mbox.Messages,
+	)
+}
 
+// Retrieve UIDs and sizes of all emails of a non-empty folder.
+func fetchAllMessageUUIDs(
+	mbox *imap.MailboxStatus, imapClient imapOps, bufferSize int,
+) ([]uidExt, error) {
 	// Retrieve information about all emails.
 	seqset := new(imap.SeqSet)
 	seqset.AddRange(1, mbox.Messages)
+	return fetchMessageUUIDs(mbox, imapClient, seqset, int(mbox.Messages), bufferSize)
+}
 
-	messageChannel := make(chan *imap.Message, messageRetrievalBuffer)
+// Retrieve UIDs and sizes of the emails at the positions within a folder given by a SeqSet of
+// sequence numbers. The expected number of emails is used to avoid reallocations.
+func fetchMessageUUIDs(
+	mbox *imap.MailboxStatus, imapClient imapOps, seqset *imap.SeqSet, expected, bufferSize int,
+) ([]uidExt, error) {
+	uids := make([]uidExt, 0, expected)
+
+	messageChannel := make(chan *imap.Message, bufferSize)
+	errChannel := make(chan error, 1)
 	go func() {
-		err = imapClient.Fetch(
+		errChannel <- imapClient.Fetch(
 			seqset,
-			[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate},
+			[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822Size},
 			messageChannel,
 		)
 	}()
@@ -248,11 +718,36 @@ func getAllMessageUUIDs(
 			appUID := uidExt{
 				folder: uidFolder(mbox.UidValidity),
 				msg:    uid(m.Uid),
+				size:   m.Size,
 			}
 			uids = append(uids, appUID)
 		}
 	}
-	logInfo(fmt.Sprintf("received information for %d emails", len(uids)))
 
+	return dedupUIDs(uids), <-errChannel
+}
+
+// Retrieve UIDs and sizes of the emails with sequence numbers from start to end inclusive. Sequence
+// numbers beyond the end of the folder are ignored with a warning.
+func getSeqRangeUUIDs(
+	mbox *imap.MailboxStatus, imapClient imapOps, start, end uint32, bufferSize int,
+) ([]uidExt, error) {
+	logInfo(fmt.Sprintf("retrieving information about emails %d to %d", start, end))
+	if start > mbox.Messages {
+		logWarning(fmt.Sprintf(
+			"folder contains only %d emails, none in range %d:%d", mbox.Messages, start, end,
+		))
+		return nil, nil
+	}
+	if end > mbox.Messages {
+		logWarning(fmt.Sprintf(
+			"folder contains only %d emails, shortening range %d:%d", mbox.Messages, start, end,
+		))
+		end = mbox.Messages
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(start, end)
+	uids, err := fetchMessageUUIDs(mbox, imapClient, seqset, int(end-start+1), bufferSize)
+	logInfo(fmt.Sprintf("received information for %d emails", len(uids)))
 	return uids, err
 }
@@ -18,12 +18,16 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-sasl"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -33,6 +37,7 @@ type mockClient struct {
 
 	mailboxes []*imap.MailboxInfo
 	messages  []*imap.Message
+	state     imap.ConnState
 }
 
 func (mc *mockClient) Login(username string, password string) error {
@@ -40,6 +45,11 @@ func (mc *mockClient) Login(username string, password string) error {
 	return args.Error(0)
 }
 
+func (mc *mockClient) Authenticate(auth sasl.Client) error {
+	args := mc.Called(auth)
+	return args.Error(0)
+}
+
 func (mc *mockClient) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	defer close(ch)
 	args := mc.Called(ref, name, ch)
@@ -49,11 +59,53 @@ func (mc *mockClient) List(ref string, name string, ch chan *imap.MailboxInfo) e
 	return args.Error(0)
 }
 
+// The state is not mocked via expectations since it is queried for every connection. A mock client
+// is not authenticated unless requested.
+func (mc *mockClient) State() imap.ConnState {
+	if mc.state == 0 {
+		return imap.NotAuthenticatedState
+	}
+	return mc.state
+}
+
+func (mc *mockClient) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	defer close(ch)
+	args := mc.Called(ref, name, ch)
+	for _, box := range mc.mailboxes {
+		ch <- box
+	}
+	return args.Error(0)
+}
+
 func (mc *mockClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
 	args := mc.Called(name, readOnly)
 	return args.Get(0).(*imap.MailboxStatus), args.Error(1)
 }
 
+func (mc *mockClient) Status(
+	name string, items []imap.StatusItem,
+) (*imap.MailboxStatus, error) {
+	args := mc.Called(name, items)
+	return args.Get(0).(*imap.MailboxStatus), args.Error(1)
+}
+
+func (mc *mockClient) Execute(
+	cmdr imap.Commander, h responses.Handler,
+) (*imap.StatusResp, error) {
+	args := mc.Called(cmdr, h)
+	return args.Get(0).(*imap.StatusResp), args.Error(1)
+}
+
+func (mc *mockClient) Capability() (map[string]bool, error) {
+	args := mc.Called()
+	return args.Get(0).(map[string]bool), args.Error(1)
+}
+
+func (mc *mockClient) Support(capability string) (bool, error) {
+	args := mc.Called(capability)
+	return args.Bool(0), args.Error(1)
+}
+
 func (mc *mockClient) Fetch(
 	seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message,
 ) error {
@@ -73,11 +125,68 @@ func (mc *mockClient) UidFetch( //nolint:revive,stylecheck
 	defer close(ch)
 	args := mc.Called(seqset, items, ch)
 	for _, msg := range mc.messages {
+		// Like servers, only return the requested messages. Messages without UID are always
+		// returned for simplicity.
+		if msg != nil && msg.Uid != 0 && seqset != nil && !seqset.Contains(msg.Uid) {
+			continue
+		}
 		ch <- msg
 	}
 	return args.Error(0)
 }
 
+// UidMove has to have that name because it implements an interface htat follows an external
+// dependency. Thus, disable linter warnings about the name.
+func (mc *mockClient) Noop() error {
+	args := mc.Called()
+	return args.Error(0)
+}
+
+func (mc *mockClient) Create(name string) error {
+	args := mc.Called(name)
+	return args.Error(0)
+}
+
+func (mc *mockClient) UidMove(seqset *imap.SeqSet, dest string) error { //nolint:revive,stylecheck
+	args := mc.Called(seqset, dest)
+	return args.Error(0)
+}
+
+func (mc *mockClient) UidCopy(seqset *imap.SeqSet, dest string) error { //nolint:revive,stylecheck
+	args := mc.Called(seqset, dest)
+	return args.Error(0)
+}
+
+func (mc *mockClient) UidStore( //nolint:revive,stylecheck
+	seqset *imap.SeqSet, item imap.StoreItem, value interface{}, ch chan *imap.Message,
+) error {
+	args := mc.Called(seqset, item, value, ch)
+	return args.Error(0)
+}
+
+func (mc *mockClient) Expunge(ch chan uint32) error {
+	args := mc.Called(ch)
+	return args.Error(0)
+}
+
+// UidSearch has to have that name because it implements an interface htat follows an external
+// dependency. Thus, disable linter warnings about the name.
+func (mc *mockClient) UidSearch( //nolint:revive,stylecheck
+	criteria *imap.SearchCriteria,
+) ([]uint32, error) {
+	args := mc.Called(criteria)
+	return args.Get(0).([]uint32), args.Error(1)
+}
+
+func (mc *mockClient) SetDebug(w io.Writer) {
+	mc.Called(w)
+}
+
+func (mc *mockClient) Upgrade(upgrader imap.ConnUpgrader) error {
+	args := mc.Called(upgrader)
+	return args.Error(0)
+}
+
 func (mc *mockClient) Logout() error {
 	args := mc.Called()
 	return args.Error(0)
@@ -96,7 +205,7 @@ func setUpMockClient(
 		messages:  messages,
 	}
 	orgClientGetter := newImapClient
-	newImapClient = func(_ string, _ bool) (imapOps, error) {
+	newImapClient = func(_ string, _ bool, _ *tls.Config) (imapOps, error) {
 		return mock, err
 	}
 	t.Cleanup(func() { newImapClient = orgClientGetter })
@@ -105,17 +214,18 @@ func setUpMockClient(
 }
 
 func TestAuthFailure(t *testing.T) {
-	_, err := newImapClient("", false)
+	_, err := newImapClient("", false, nil)
 	assert.Error(t, err)
 }
 
 func TestDisallowInsecureRemoteAuth(t *testing.T) {
-	_, err := newImapClient("", true)
-	assert.Error(t, err, "not allowing insecure auth for non-localhost address")
+	_, err := newImapClient("", true, nil)
+	assert.ErrorIs(t, err, ErrInsecureNonLocalhost)
+	assert.ErrorContains(t, err, "not allowing insecure auth for non-localhost address")
 }
 
 func TestAllowInsecureLocalAuth(t *testing.T) {
-	_, err := newImapClient("127.0.0.1:1234", true)
+	_, err := newImapClient("127.0.0.1:1234", true, nil)
 	assert.Error(t, err)
 }
 
@@ -135,12 +245,94 @@ func TestAuthenticateClientSuccess(t *testing.T) {
 }
 
 func TestAuthenticateClientNoPassword(t *testing.T) {
-	_ = setUpMockClient(t, nil, nil, nil)
+	mock := setUpMockClient(t, nil, nil, nil)
+	// The connection is not needed without preauthentication.
+	mock.On("Terminate").Return(nil).Once()
 	config := IMAPConfig{}
 
 	_, err := authenticateClient(config)
 
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrEmptyPassword)
+	assert.Equal(t, "password not set", err.Error())
+}
+
+func TestAuthenticateClientPreauth(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.state = imap.AuthenticatedState
+	// Neither a password is needed nor is Login called.
+	config := IMAPConfig{User: "someone"}
+
+	client, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, client, mock)
+}
+
+func TestAuthenticateClientNegativeBufferSize(t *testing.T) {
+	_ = setUpMockClient(t, nil, nil, nil)
+	config := IMAPConfig{User: "someone", Password: "some password", MessageBuffer: -1}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "buffer sizes must be positive")
+}
+
+func TestIMAPConfigBufferSizes(t *testing.T) {
+	assert.Equal(
+		t,
+		bufferSizes{folderList: defaultFolderListBuffer, messages: defaultMessageRetrievalBuffer},
+		IMAPConfig{}.bufferSizes(),
+	)
+	assert.Equal(
+		t,
+		bufferSizes{folderList: 3, messages: 100},
+		IMAPConfig{FolderListBuffer: 3, MessageBuffer: 100}.bufferSizes(),
+	)
+}
+
+func TestIMAPConfigTLSConfig(t *testing.T) {
+	assert.Nil(t, IMAPConfig{Server: "127.0.0.1", NoTLSSessionCache: true}.tlsConfig())
+
+	tlsConfig := IMAPConfig{
+		Server: "127.0.0.1", TLSServerName: "imap.example.com", NoTLSSessionCache: true,
+	}.tlsConfig()
+	assert.Equal(t, "imap.example.com", tlsConfig.ServerName)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	assert.Nil(t, tlsConfig.ClientSessionCache)
+}
+
+func TestIMAPConfigTLSConfigSessionCache(t *testing.T) {
+	tlsConfig := IMAPConfig{Server: "127.0.0.1"}.tlsConfig()
+	// The server name is derived from the dial address.
+	assert.Equal(t, "", tlsConfig.ServerName)
+	assert.Same(t, tlsSessionCache, tlsConfig.ClientSessionCache)
+
+	// All connections share the same cache.
+	other := IMAPConfig{Server: "127.0.0.1", TLSServerName: "imap.example.com"}.tlsConfig()
+	assert.Equal(t, "imap.example.com", other.ServerName)
+	assert.Same(t, tlsSessionCache, other.ClientSessionCache)
+}
+
+func TestAuthenticateClientTLSServerName(t *testing.T) {
+	mock := &mockClient{}
+	mock.On("Login", "someone", "some password").Return(nil)
+	orgClientGetter := newImapClient
+	var serverName string
+	newImapClient = func(_ string, _ bool, tlsConfig *tls.Config) (imapOps, error) {
+		serverName = tlsConfig.ServerName
+		return mock, nil
+	}
+	t.Cleanup(func() { newImapClient = orgClientGetter })
+
+	config := IMAPConfig{
+		Server: "10.0.0.1", User: "someone", Password: "some password",
+		TLSServerName: "imap.example.com",
+	}
+	_, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "imap.example.com", serverName)
+	mock.AssertExpectations(t)
 }
 
 func TestAuthenticateClientCannotConnect(t *testing.T) {
@@ -154,8 +346,9 @@ func TestAuthenticateClientCannotConnect(t *testing.T) {
 
 	_, err := authenticateClient(config)
 
-	assert.Error(t, err)
-	assert.Equal(t, loginErr, err)
+	assert.ErrorIs(t, err, ErrConnectFailed)
+	assert.ErrorIs(t, err, loginErr)
+	assert.NotErrorIs(t, err, ErrLoginFailed)
 }
 
 func TestAuthenticateClientWrongCredentials(t *testing.T) {
@@ -170,7 +363,133 @@ func TestAuthenticateClientWrongCredentials(t *testing.T) {
 
 	_, err := authenticateClient(config)
 
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorIs(t, err, loginErr)
+	assert.NotErrorIs(t, err, ErrConnectFailed)
+	assert.ErrorContains(t, err, "wrong credentials")
+}
+
+func TestAuthenticateClientLoginDisabled(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.On("Support", "LOGINDISABLED").Return(true, nil)
+
+	config := IMAPConfig{User: "someone", Password: "some password", Insecure: true}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorIs(t, err, ErrLoginDisabled)
+	assert.ErrorContains(t, err, "connect to a port that uses TLS instead")
+	mock.AssertNotCalled(t, "Login", "someone", "some password")
+}
+
+func TestAuthenticateClientInsecureLoginAllowed(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.On("Support", "LOGINDISABLED").Return(false, nil)
+	mock.On("Login", "someone", "some password").Return(nil)
+
+	config := IMAPConfig{User: "someone", Password: "some password", Insecure: true}
+
+	client, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, client, mock)
+}
+
+func TestAuthenticateClientLoginDisabledCheckFails(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.On("Support", "LOGINDISABLED").Return(false, fmt.Errorf("connection lost"))
+
+	config := IMAPConfig{User: "someone", Password: "some password", Insecure: true}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "connection lost")
+}
+
+func TestGetCapabilitiesSuccess(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	caps := map[string]bool{"IMAP4rev1": true, "IDLE": true, "MOVE": true, "STARTTLS": false}
+	m.On("Capability").Return(caps, nil)
+
+	capabilities, err := getCapabilities(m)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"IDLE", "IMAP4rev1", "MOVE"}, capabilities)
+}
+
+func TestGetCapabilitiesError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Capability").Return(map[string]bool(nil), fmt.Errorf("some error"))
+
+	_, err := getCapabilities(m)
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestAuthenticateClientAuthzID(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.On("Support", "AUTH=PLAIN").Return(true, nil)
+	mock.On("Authenticate", sasl.NewPlainClient("shared", "someone", "some password")).
+		Return(nil)
+
+	config := IMAPConfig{
+		User:     "someone",
+		Password: "some password",
+		AuthzID:  "shared",
+	}
+
+	client, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, client, mock)
+}
+
+func TestAuthenticateClientAuthzIDNotSupported(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.On("Support", "AUTH=PLAIN").Return(false, nil)
+
+	config := IMAPConfig{
+		User:     "someone",
+		Password: "some password",
+		AuthzID:  "shared",
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "server does not support AUTH=PLAIN")
+}
+
+func TestAuthenticateClientAuthzIDRejected(t *testing.T) {
+	mock := setUpMockClient(t, nil, nil, nil)
+	mock.On("Support", "AUTH=PLAIN").Return(true, nil)
+	mock.On("Authenticate", sasl.NewPlainClient("shared", "someone", "some password")).
+		Return(fmt.Errorf("not authorized"))
+
+	config := IMAPConfig{
+		User:     "someone",
+		Password: "some password",
+		AuthzID:  "shared",
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "not authorized")
+}
+
+func TestAuthenticateClientAuthzIDWithOAuth2(t *testing.T) {
+	_ = setUpMockClient(t, nil, nil, nil)
+
+	config := IMAPConfig{
+		User:    "someone",
+		AuthzID: "shared",
+		OAuth2:  OAuth2Config{RefreshToken: "some token"},
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "cannot be used with OAuth2")
 }
 
 func TestGetFolderListSuccess(t *testing.T) {
@@ -182,12 +501,48 @@ func TestGetFolderListSuccess(t *testing.T) {
 	m := setUpMockClient(t, boxes, nil, nil)
 	m.On("List", "", "*", mock.Anything).Return(nil)
 
-	list, err := getFolderList(m)
+	list, err := getFolderList(m, defaultFolderListBuffer, false, false)
 
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"b1", "b2", "b3"}, list)
 }
 
+func TestListFoldersWithAttributes(t *testing.T) {
+	boxes := []*imap.MailboxInfo{
+		{Name: "INBOX"},
+		{Name: "Sent", Attributes: []string{imap.HasNoChildrenAttr, imap.SentAttr}},
+	}
+	m := setUpMockClient(t, boxes, nil, nil)
+	m.On("List", "", "*", mock.Anything).Return(nil)
+
+	folders, err := listFolders(m, defaultFolderListBuffer, false)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]FolderInfo{
+			{Name: "INBOX"},
+			{Name: "Sent", Attributes: []string{imap.HasNoChildrenAttr, imap.SentAttr}},
+		},
+		folders,
+	)
+}
+
+func TestGetFolderListSubscribedOnly(t *testing.T) {
+	boxes := []*imap.MailboxInfo{
+		{Name: "b1"},
+		{Name: "b3"},
+	}
+	m := setUpMockClient(t, boxes, nil, nil)
+	// Only LSUB is used, List is not called.
+	m.On("Lsub", "", "*", mock.Anything).Return(nil)
+
+	list, err := getFolderList(m, defaultFolderListBuffer, true, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b1", "b3"}, list)
+}
+
 func TestGetFolderListError(t *testing.T) {
 	listErr := fmt.Errorf("list error")
 	boxes := []*imap.MailboxInfo{
@@ -196,7 +551,7 @@ func TestGetFolderListError(t *testing.T) {
 	m := setUpMockClient(t, boxes, nil, nil)
 	m.On("List", "", "*", mock.Anything).Return(listErr)
 
-	_, err := getFolderList(m)
+	_, err := getFolderList(m, defaultFolderListBuffer, false, false)
 
 	assert.Error(t, err)
 	assert.Equal(t, listErr, err)
@@ -213,6 +568,49 @@ func TestSelectFolderSuccess(t *testing.T) {
 	assert.Equal(t, expectedStatus, status)
 }
 
+func TestSelectFolderMissing(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "some folder", true).
+		Return((*imap.MailboxStatus)(nil), fmt.Errorf("Mailbox doesn't exist: some folder"))
+
+	_, err := selectFolder(m, "some folder")
+
+	assert.ErrorIs(t, err, ErrFolderMissing)
+	assert.ErrorContains(t, err, "Mailbox doesn't exist")
+}
+
+func TestSelectFolderError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "some folder", true).
+		Return((*imap.MailboxStatus)(nil), fmt.Errorf("some error"))
+
+	_, err := selectFolder(m, "some folder")
+
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrFolderMissing)
+}
+
+func TestIsMissingFolderError(t *testing.T) {
+	assert.False(t, isMissingFolderError(nil))
+	assert.False(t, isMissingFolderError(fmt.Errorf("some error")))
+	for _, text := range []string{
+		"Mailbox doesn't exist: INBOX/Foo", "Unknown Mailbox: Foo (Failure)",
+		"[TRYCREATE] No such mailbox", "Folder not found",
+	} {
+		assert.True(t, isMissingFolderError(fmt.Errorf("%s", text)), text)
+	}
+}
+
+func TestCreateFolder(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Create", "new").Return(nil)
+	m.On("Create", "forbidden").Return(fmt.Errorf("permission denied"))
+
+	assert.NoError(t, createFolder(m, "new"))
+	assert.ErrorContains(t, createFolder(m, "forbidden"), "permission denied")
+	m.AssertExpectations(t)
+}
+
 func TestStreamingRetrievalSuccess(t *testing.T) {
 	uids := []uid{10, 12, 16}
 	messages := []*imap.Message{
@@ -236,7 +634,9 @@ func TestStreamingRetrievalSuccess(t *testing.T) {
 	stwg.Add(1)
 	interrupted := func() bool { return false }
 
-	emailChan, errPtr, err := streamingRetrieval(m, uids, &wg, &stwg, interrupted)
+	emailChan, errPtr, err := streamingRetrieval(
+		m, uids, expectedFetchRequest, 0, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
 
 	assert.NoError(t, err)
 	assert.Zero(t, *errPtr)
@@ -264,6 +664,217 @@ func TestStreamingRetrievalSuccess(t *testing.T) {
 	assert.Equal(t, messages, emails)
 }
 
+func TestStreamingRetrievalBatches(t *testing.T) {
+	uids := []uid{16, 12, 10}
+	messages := []*imap.Message{{Uid: 16}, {Uid: 12}, {Uid: 10}}
+
+	firstSeqSet := &imap.SeqSet{}
+	firstSeqSet.AddNum(16, 12)
+	secondSeqSet := &imap.SeqSet{}
+	secondSeqSet.AddNum(10)
+
+	m := setUpMockClient(t, nil, messages, nil)
+	m.On("UidFetch", firstSeqSet, mock.Anything, mock.Anything).Return(nil).Once()
+	m.On("UidFetch", secondSeqSet, mock.Anything, mock.Anything).Return(nil).Once()
+
+	var wg, stwg sync.WaitGroup
+	interrupted := func() bool { return false }
+
+	emailChan, errPtr, err := streamingRetrieval(
+		m, uids, nil, 2, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
+	assert.NoError(t, err)
+
+	count := 0
+	for range emailChan {
+		count++
+	}
+	wg.Wait()
+
+	assert.Zero(t, *errPtr)
+	assert.Equal(t, 3, count)
+	// Check the order of the fetches.
+	assert.Equal(t, firstSeqSet, m.Calls[0].Arguments.Get(0))
+	assert.Equal(t, secondSeqSet, m.Calls[1].Arguments.Get(0))
+}
+
+func TestStreamingRetrievalRefetchesMissingEmails(t *testing.T) {
+	uids := []uid{10, 12}
+	// The server omits the email with UID 12 in its first response.
+	messages := []*imap.Message{{Uid: 10}}
+
+	firstSeqSet := &imap.SeqSet{}
+	firstSeqSet.AddNum(10, 12)
+	retrySeqSet := &imap.SeqSet{}
+	retrySeqSet.AddNum(12)
+
+	m := setUpMockClient(t, nil, messages, nil)
+	m.On("UidFetch", firstSeqSet, mock.Anything, mock.Anything).Return(nil).Once()
+	m.On("UidFetch", retrySeqSet, mock.Anything, mock.Anything).Return(nil).Once().
+		Run(func(_ mock.Arguments) { m.messages = append(m.messages, &imap.Message{Uid: 12}) })
+
+	var wg, stwg sync.WaitGroup
+	interrupted := func() bool { return false }
+
+	emailChan, errPtr, err := streamingRetrieval(
+		m, uids, nil, 0, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
+	assert.NoError(t, err)
+
+	emails := []*imap.Message{}
+	for em := range emailChan {
+		emails = append(emails, em.(*imap.Message))
+	}
+	wg.Wait()
+
+	assert.Zero(t, *errPtr)
+	assert.Equal(t, []*imap.Message{{Uid: 10}, {Uid: 12}}, emails)
+	m.AssertExpectations(t)
+}
+
+func TestStreamingRetrievalGivesUpOnMissingEmails(t *testing.T) {
+	uids := []uid{10, 12}
+	// The server never returns the email with UID 12.
+	messages := []*imap.Message{{Uid: 10}}
+
+	firstSeqSet := &imap.SeqSet{}
+	firstSeqSet.AddNum(10, 12)
+	retrySeqSet := &imap.SeqSet{}
+	retrySeqSet.AddNum(12)
+
+	m := setUpMockClient(t, nil, messages, nil)
+	m.On("UidFetch", firstSeqSet, mock.Anything, mock.Anything).Return(nil).Once()
+	m.On("UidFetch", retrySeqSet, mock.Anything, mock.Anything).Return(nil).
+		Times(missingEmailRetries)
+
+	var wg, stwg sync.WaitGroup
+	interrupted := func() bool { return false }
+
+	emailChan, errPtr, err := streamingRetrieval(
+		m, uids, nil, 0, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
+	assert.NoError(t, err)
+
+	count := 0
+	for range emailChan {
+		count++
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, *errPtr)
+	assert.Equal(t, 1, count)
+	m.AssertExpectations(t)
+}
+
+func TestMissingUIDs(t *testing.T) {
+	received := map[uid]struct{}{10: {}, 16: {}}
+	failed := &imap.SeqSet{}
+	failed.AddNum(18)
+
+	missing := missingUIDs([]uid{10, 12, 14, 16, 18}, received, []*imap.SeqSet{failed})
+
+	assert.Equal(t, []uid{12, 14}, missing)
+	assert.Equal(t, []uid{}, missingUIDs([]uid{10, 16}, received, nil))
+}
+
+func TestStreamingRetrievalFallbackToEntireBody(t *testing.T) {
+	uids := []uid{10, 12}
+	messages := []*imap.Message{{Uid: 10}, {Uid: 12}}
+	rfc822Items := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822}
+	bodyItems := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, "BODY.PEEK[]"}
+
+	firstSeqSet := &imap.SeqSet{}
+	firstSeqSet.AddNum(10)
+	secondSeqSet := &imap.SeqSet{}
+	secondSeqSet.AddNum(12)
+
+	m := &mockClient{}
+	// The server rejects RFC822 without returning any emails but supports BODY[].
+	m.On("UidFetch", firstSeqSet, rfc822Items, mock.Anything).
+		Run(func(_ mock.Arguments) { m.messages = nil }).
+		Return(fmt.Errorf("unknown fetch item")).Once()
+	m.On("UidFetch", firstSeqSet, bodyItems, mock.Anything).
+		Run(func(_ mock.Arguments) { m.messages = messages[:1] }).
+		Return(nil).Once()
+	// Later batches use BODY[] right away.
+	m.On("UidFetch", secondSeqSet, bodyItems, mock.Anything).
+		Run(func(_ mock.Arguments) { m.messages = messages[1:] }).
+		Return(nil).Once()
+
+	var wg, stwg sync.WaitGroup
+	interrupted := func() bool { return false }
+
+	emailChan, errPtr, err := streamingRetrieval(
+		m, uids, rfc822Items, 1, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
+	assert.NoError(t, err)
+
+	emails := []*imap.Message{}
+	for em := range emailChan {
+		emails = append(emails, em.(*imap.Message))
+	}
+	wg.Wait()
+
+	assert.Zero(t, *errPtr)
+	assert.Equal(t, messages, emails)
+	m.AssertExpectations(t)
+}
+
+func TestStreamingRetrievalFallbackFails(t *testing.T) {
+	uids := []uid{10}
+	rfc822Items := []imap.FetchItem{imap.FetchUid, imap.FetchRFC822}
+	bodyItems := []imap.FetchItem{imap.FetchUid, "BODY.PEEK[]"}
+
+	m := &mockClient{}
+	m.On("UidFetch", mock.Anything, rfc822Items, mock.Anything).
+		Return(fmt.Errorf("some error")).Once()
+	m.On("UidFetch", mock.Anything, bodyItems, mock.Anything).
+		Return(fmt.Errorf("some error")).Once()
+
+	var wg, stwg sync.WaitGroup
+	interrupted := func() bool { return false }
+
+	emailChan, errPtr, err := streamingRetrieval(
+		m, uids, rfc822Items, 0, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
+	assert.NoError(t, err)
+
+	for range emailChan {
+		t.Fail()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, *errPtr)
+	m.AssertExpectations(t)
+}
+
+func TestReplaceRFC822(t *testing.T) {
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchRFC822, imap.FetchFlags}
+
+	replaced, ok := replaceRFC822(items)
+
+	assert.True(t, ok)
+	assert.Equal(t, []imap.FetchItem{imap.FetchUid, "BODY.PEEK[]", imap.FetchFlags}, replaced)
+	assert.Equal(t, imap.FetchRFC822, items[1])
+
+	_, ok = replaceRFC822([]imap.FetchItem{imap.FetchUid, "BODY.PEEK[HEADER]"})
+	assert.False(t, ok)
+}
+
+func TestBatchSeqSets(t *testing.T) {
+	single := &imap.SeqSet{}
+	single.AddNum(3, 2, 1)
+	first := &imap.SeqSet{}
+	first.AddNum(3, 2)
+	second := &imap.SeqSet{}
+	second.AddNum(1)
+
+	assert.Equal(t, []*imap.SeqSet{single}, batchSeqSets([]uid{3, 2, 1}, 0))
+	assert.Equal(t, []*imap.SeqSet{single}, batchSeqSets([]uid{3, 2, 1}, 5))
+	assert.Equal(t, []*imap.SeqSet{first, second}, batchSeqSets([]uid{3, 2, 1}, 2))
+	assert.Equal(t, []*imap.SeqSet{{}}, batchSeqSets(nil, 2))
+}
+
 func TestStreamingRetrievalError(t *testing.T) {
 	m := setUpMockClient(t, nil, nil, nil)
 
@@ -274,7 +885,9 @@ func TestStreamingRetrievalError(t *testing.T) {
 	stwg.Add(1)
 	interrupted := func() bool { return false }
 
-	_, _, err := streamingRetrieval(m, uids, &wg, &stwg, interrupted)
+	_, _, err := streamingRetrieval(
+		m, uids, nil, 0, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
 
 	assert.Error(t, err)
 }
@@ -290,7 +903,7 @@ func TestStreamingRetrievalInterrupt(t *testing.T) {
 	// The reason is that the call to streamingRetrieval will use 2 goroutines and we cannot
 	// guarantee that UidFetch will have been called.
 	orgClientGetter := newImapClient
-	newImapClient = func(_ string, _ bool) (imapOps, error) {
+	newImapClient = func(_ string, _ bool, _ *tls.Config) (imapOps, error) {
 		return m, nil
 	}
 	t.Cleanup(func() { newImapClient = orgClientGetter })
@@ -302,7 +915,9 @@ func TestStreamingRetrievalInterrupt(t *testing.T) {
 	// interrupt case. Interrupts are handled preferentially compared to message conversion.
 	interrupted := func() bool { return true }
 
-	_, errPtr, err := streamingRetrieval(m, uids, &wg, &stwg, interrupted)
+	_, errPtr, err := streamingRetrieval(
+		m, uids, nil, 0, defaultMessageRetrievalBuffer, &wg, &stwg, interrupted,
+	)
 
 	assert.NoError(t, err)
 
@@ -328,29 +943,168 @@ func TestGetAllMessageUUIDsSuccess(t *testing.T) {
 		UidValidity: 42,
 	}
 	messages := []*imap.Message{
-		{Uid: 10},
+		{Uid: 10, Size: 100},
 		// There are no guarantees the server does not return nil. Thus, we make sure to ignore such
 		// values.
 		nil,
-		{Uid: 12},
+		{Uid: 12, Size: 200},
 		nil,
-		{Uid: 16},
+		{Uid: 16, Size: 300},
 	}
 
 	expectedSeqSet := &imap.SeqSet{}
 	expectedSeqSet.AddRange(1, 3)
 	expectedUUIDs := []uidExt{
-		{folder: 42, msg: 10},
-		{folder: 42, msg: 12},
-		{folder: 42, msg: 16},
+		{folder: 42, msg: 10, size: 100},
+		{folder: 42, msg: 12, size: 200},
+		{folder: 42, msg: 16, size: 300},
+	}
+	expectedFetchRequest := []imap.FetchItem{
+		imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822Size,
 	}
-	expectedFetchRequest := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate}
 
 	m := setUpMockClient(t, nil, messages, nil)
 	m.On("Fetch", expectedSeqSet, expectedFetchRequest, mock.Anything).Return(nil)
 
-	uids, err := getAllMessageUUIDs(status, m)
+	uids, err := getAllMessageUUIDs(status, m, defaultMessageRetrievalBuffer)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedUUIDs, uids)
 }
+
+func TestGetAllMessageUUIDsDuplicates(t *testing.T) {
+	status := &imap.MailboxStatus{Messages: 3, UidValidity: 42}
+	messages := []*imap.Message{{Uid: 10}, {Uid: 12}, {Uid: 10}, {Uid: 16}}
+
+	m := setUpMockClient(t, nil, messages, nil)
+	m.On("Fetch", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+
+	uids, err := getAllMessageUUIDs(status, m, defaultMessageRetrievalBuffer)
+	assert.NoError(t, err)
+	missing, err := determineMissingUIDs(nil, uids, newMaildirStorer("", nil))
+
+	// Every email is listed and will thus be retrieved only once.
+	assert.NoError(t, err)
+	assert.Equal(t, []uid{10, 12, 16}, missing)
+	m.AssertExpectations(t)
+}
+
+func TestGetAllMessageUUIDsRetriesIncompleteList(t *testing.T) {
+	status := &imap.MailboxStatus{Messages: 2, UidValidity: 42}
+
+	m := setUpMockClient(t, nil, []*imap.Message{{Uid: 10}}, nil)
+	m.On("Fetch", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	// The server reports all emails on the second attempt.
+	m.On("Fetch", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(
+		func(_ mock.Arguments) {
+			m.messages = []*imap.Message{{Uid: 10}, {Uid: 12}}
+		},
+	).Once()
+
+	uids, err := getAllMessageUUIDs(status, m, defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uidExt{{folder: 42, msg: 10}, {folder: 42, msg: 12}}, uids)
+}
+
+func TestGetAllMessageUUIDsIncompleteList(t *testing.T) {
+	status := &imap.MailboxStatus{Messages: 2, UidValidity: 42}
+
+	m := setUpMockClient(t, nil, []*imap.Message{{Uid: 10}}, nil)
+	m.On("Fetch", mock.Anything, mock.Anything, mock.Anything).Return(nil).Times(uidListAttempts)
+
+	uids, err := getAllMessageUUIDs(status, m, defaultMessageRetrievalBuffer)
+
+	assert.ErrorIs(t, err, errUIDCountMismatch)
+	assert.Equal(t, []uidExt{{folder: 42, msg: 10}}, uids)
+}
+
+func TestGetSeqRangeUUIDs(t *testing.T) {
+	status := &imap.MailboxStatus{Messages: 150, UidValidity: 42}
+	// The range is shortened to the emails present in the folder.
+	expectedSeqSet := &imap.SeqSet{}
+	expectedSeqSet.AddRange(100, 150)
+
+	m := setUpMockClient(t, nil, []*imap.Message{{Uid: 110, Size: 10}, nil}, nil)
+	m.On("Fetch", expectedSeqSet, mock.Anything, mock.Anything).Return(nil)
+
+	uids, err := getSeqRangeUUIDs(status, m, 100, 200, defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uidExt{{folder: 42, msg: 110, size: 10}}, uids)
+	m.AssertExpectations(t)
+}
+
+func TestGetSeqRangeUUIDsBeyondFolder(t *testing.T) {
+	status := &imap.MailboxStatus{Messages: 50, UidValidity: 42}
+	m := setUpMockClient(t, nil, nil, nil)
+
+	uids, err := getSeqRangeUUIDs(status, m, 100, 200, defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Empty(t, uids)
+	m.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOnceCallsHookOnlyOnce(t *testing.T) {
+	count := 0
+	o := newOnce(func() { count++ })
+
+	assert.False(t, o.wasCalled())
+	o.call()
+	o.call()
+
+	assert.True(t, o.wasCalled())
+	assert.Equal(t, 1, count)
+}
+
+func TestOnceReset(t *testing.T) {
+	count := 0
+	o := newOnce(func() { count++ })
+
+	o.call()
+	o.Reset()
+
+	assert.False(t, o.wasCalled())
+	o.call()
+	o.call()
+
+	assert.True(t, o.wasCalled())
+	assert.Equal(t, 2, count)
+}
+
+func TestOnceResetWhileInProgress(t *testing.T) {
+	var o *once
+	count := 0
+	o = newOnce(func() {
+		count++
+		// Simulate a reset from elsewhere while the hook is running.
+		o.Reset()
+	})
+
+	o.call()
+	assert.False(t, o.wasCalled())
+	o.call()
+
+	assert.Equal(t, 2, count)
+}
+
+func TestOnceConcurrentUse(t *testing.T) {
+	var count threadSafeCounter
+	o := newOnce(func() { count.inc() })
+
+	numRoutines := 10
+	var wg sync.WaitGroup
+	wg.Add(numRoutines)
+	for idx := 0; idx < numRoutines; idx++ {
+		go func() {
+			defer wg.Done()
+			o.call()
+			_ = o.wasCalled()
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, o.wasCalled())
+	assert.Equal(t, 1, count.get())
+}
@@ -0,0 +1,158 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The number of files queued per worker when indexing maildirs. Walking directories is much faster
+// than hashing files, so a short queue suffices to keep all workers busy.
+const indexQueuePerWorker = 4
+
+// Type indexedFile is the hash of the content of a file as determined when indexing maildirs.
+type indexedFile struct {
+	path string
+	hash string
+}
+
+// Index the emails in all maildirs below a base directory by the SHA-256 hashes of their content,
+// e.g. to deduplicate against emails downloaded before deduplication was enabled. Directories are
+// walked by a single goroutine while files are hashed by a bounded pool of workers since hashing
+// dominates for large archives. Only regular files in the "cur" and "new" directories of maildirs
+// are considered, i.e. links created by deduplication are skipped. The returned map assigns the
+// path of the first file in lexical order, relative to the base directory, to each hash. Files
+// that cannot be read are skipped with a warning.
+func indexMaildirs(base string, workers int) (map[string]string, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	paths := make(chan string, workers*indexQueuePerWorker)
+	results := make(chan indexedFile, workers*indexQueuePerWorker)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				hash, err := hashFile(path)
+				if err != nil {
+					logWarning(fmt.Sprintf("cannot index %s: %s", path, err.Error()))
+					continue
+				}
+				results <- indexedFile{path: path, hash: hash}
+			}
+		}()
+	}
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		walkErr <- walkMaildirFiles(base, func(path string) { paths <- path })
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	index := map[string]string{}
+	for result := range results {
+		relPath, err := filepath.Rel(base, result.path)
+		if err != nil {
+			logWarning(fmt.Sprintf("cannot index %s: %s", result.path, err.Error()))
+			continue
+		}
+		if existing, found := index[result.hash]; !found || relPath < existing {
+			index[result.hash] = relPath
+		}
+	}
+	if err := <-walkErr; err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Call a function for every regular file in the "cur" and "new" directories of all maildirs below
+// a base directory. A missing base directory contains no files.
+func walkMaildirFiles(base string, fn func(path string)) error {
+	return walkMaildirEntries(base, fs.FileMode(0), fn)
+}
+
+// Find an email below a base directory that has been deduplicated via a symbolic link. Return an
+// empty string if there is none.
+func findSymlinkedEmail(base string) (string, error) {
+	var found string
+	err := walkMaildirEntries(base, fs.ModeSymlink, func(path string) {
+		if found == "" {
+			found = path
+		}
+	})
+	return found, err
+}
+
+// Call a function for every entry of a type in the "cur" and "new" directories of all maildirs
+// below a base directory, see walkMaildirFiles.
+func walkMaildirEntries(base string, fileType fs.FileMode, fn func(path string)) error {
+	// Whether directories contain emails is determined once per directory, not once per file.
+	emailDirs := map[string]bool{}
+	err := filepath.WalkDir(base, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type() != fileType {
+			return nil
+		}
+		dir := filepath.Dir(current)
+		isEmailDir, known := emailDirs[dir]
+		if !known {
+			name := filepath.Base(dir)
+			isEmailDir = (name == curMaildir || name == newMaildir) &&
+				isMaildir(filepath.Dir(dir))
+			emailDirs[dir] = isEmailDir
+		}
+		if isEmailDir {
+			fn(current)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Compute the hex-encoded SHA-256 hash of the content of a file.
+func hashFile(path string) (string, error) {
+	handle, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = handle.Close() }()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, handle); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,156 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeIndexTestFile(t testing.TB, path, content string) {
+	err := os.MkdirAll(filepath.Dir(path), dirPerm)
+	require.NoError(t, err)
+	err = os.WriteFile(path, []byte(content), filePerm)
+	require.NoError(t, err)
+}
+
+func setUpIndexTestMaildir(t testing.TB, base, folder string) {
+	for _, dir := range []string{curMaildir, newMaildir, tmpMaildir} {
+		err := os.MkdirAll(filepath.Join(base, folder, dir), dirPerm)
+		require.NoError(t, err)
+	}
+}
+
+func TestIndexMaildirs(t *testing.T) {
+	base := t.TempDir()
+	setUpIndexTestMaildir(t, base, "INBOX")
+	setUpIndexTestMaildir(t, base, "Archive/2024-01")
+	writeIndexTestFile(t, filepath.Join(base, "INBOX", "cur", "b"), "some content")
+	writeIndexTestFile(t, filepath.Join(base, "INBOX", "new", "c"), "other content")
+	writeIndexTestFile(t, filepath.Join(base, "Archive/2024-01", "cur", "a"), "some content")
+	// None of these are emails.
+	writeIndexTestFile(t, filepath.Join(base, "INBOX", "tmp", "d"), "partial content")
+	writeIndexTestFile(t, filepath.Join(base, "INBOX", "oldmail"), "no email")
+	writeIndexTestFile(t, filepath.Join(base, "other", "cur", "e"), "not in a maildir")
+	writeIndexTestFile(t, filepath.Join(base, hashStoreName), "abc INBOX/cur/b\n")
+	err := os.Symlink("b", filepath.Join(base, "INBOX", "cur", "link"))
+	require.NoError(t, err)
+
+	someHash, err := hashFile(filepath.Join(base, "INBOX", "cur", "b"))
+	require.NoError(t, err)
+	otherHash, err := hashFile(filepath.Join(base, "INBOX", "new", "c"))
+	require.NoError(t, err)
+
+	for _, workers := range []int{0, 1, 4} {
+		index, err := indexMaildirs(base, workers)
+
+		assert.NoError(t, err)
+		// The first path in lexical order is remembered for duplicates.
+		expected := map[string]string{
+			someHash:  filepath.Join("Archive/2024-01", "cur", "a"),
+			otherHash: filepath.Join("INBOX", "new", "c"),
+		}
+		assert.Equal(t, expected, index)
+	}
+}
+
+func TestIndexMaildirsMissingBase(t *testing.T) {
+	index, err := indexMaildirs(filepath.Join(t.TempDir(), "missing"), 2)
+
+	assert.NoError(t, err)
+	assert.Empty(t, index)
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	writeIndexTestFile(t, path, "some content")
+
+	hash, err := hashFile(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56", hash)
+
+	_, err = hashFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestOpenHashStoreIndexesExistingEmails(t *testing.T) {
+	base := t.TempDir()
+	setUpIndexTestMaildir(t, base, "INBOX")
+	writeIndexTestFile(t, filepath.Join(base, "INBOX", "cur", "a"), "some content")
+
+	store, err := openHashStore(base, DedupHardlink)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(store.hashes))
+	content, err := os.ReadFile(filepath.Join(base, hashStoreName)) // nolint: gosec
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+
+	// Emails are indexed only once, later ones are remembered when they are stored.
+	writeIndexTestFile(t, filepath.Join(base, "INBOX", "cur", "b"), "other content")
+	store, err = openHashStore(base, DedupHardlink)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(store.hashes))
+}
+
+func TestOpenHashStoreMissingBase(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "missing")
+
+	store, err := openHashStore(base, DedupHardlink)
+
+	assert.NoError(t, err)
+	assert.Empty(t, store.hashes)
+	assert.NoDirExists(t, base)
+}
+
+// Set up a base directory with several maildirs containing emails of typical size.
+func setUpIndexBenchmark(b *testing.B, folders, emails int) string {
+	base := b.TempDir()
+	for idx := 0; idx < folders; idx++ {
+		setUpIndexTestMaildir(b, base, fmt.Sprintf("folder-%d", idx))
+	}
+	content := strings.Repeat("Some line of an email that is long enough.\r\n", 1000)
+	for idx := 0; idx < emails; idx++ {
+		folder := fmt.Sprintf("folder-%d", idx%folders)
+		path := filepath.Join(base, folder, curMaildir, fmt.Sprintf("email-%d", idx))
+		writeIndexTestFile(b, path, fmt.Sprintf("%d\r\n%s", idx, content))
+	}
+	return base
+}
+
+func BenchmarkIndexMaildirs(b *testing.B) {
+	emails := 1000
+	base := setUpIndexBenchmark(b, 10, emails)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for idx := 0; idx < b.N; idx++ {
+				index, err := indexMaildirs(base, workers)
+				if err != nil || len(index) != emails {
+					b.Fatalf("unexpected index of %d emails: %v", len(index), err)
+				}
+			}
+		})
+	}
+}
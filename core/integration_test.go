@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 
 	"github.com/emersion/go-imap"
@@ -76,7 +77,9 @@ func TestIntegrationDownloadMissingEmailsToFolderSuccess(t *testing.T) {
 
 	seqSet := &imap.SeqSet{}
 	seqSet.AddRange(1, 3)
-	fetchRequestListUUIDs := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate}
+	fetchRequestListUUIDs := []imap.FetchItem{
+		imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822Size,
+	}
 	fetchRequestDownload := []imap.FetchItem{
 		imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822,
 	}
@@ -91,7 +94,9 @@ func TestIntegrationDownloadMissingEmailsToFolderSuccess(t *testing.T) {
 	downloader := buildFakeDownloader(mockClient)
 	interrupter := newInterruptOps(nil)
 
-	err := downloadMissingEmailsToFolder(downloader, maildirPath, "some-oldmail", interrupter)
+	err := downloadMissingEmailsToFolder(
+		downloader, maildirPath, "some-oldmail", interrupter, DownloadOptions{},
+	)
 
 	assert.NoError(t, err)
 
@@ -131,7 +136,9 @@ func TestIntegrationDownloadMissingEmailsToFolderPreparationError(t *testing.T)
 	downloader := buildFakeDownloader(mockClient)
 	interrupter := newInterruptOps(nil)
 
-	err := downloadMissingEmailsToFolder(downloader, maildirPath, "some-oldmail", interrupter)
+	err := downloadMissingEmailsToFolder(
+		downloader, maildirPath, "some-oldmail", interrupter, DownloadOptions{},
+	)
 
 	assert.Error(t, err)
 	assert.Equal(t, "some error", err.Error())
@@ -160,7 +167,9 @@ func TestIntegrationDownloadMissingEmailsToFolderDownloadError(t *testing.T) {
 
 	seqSet := &imap.SeqSet{}
 	seqSet.AddRange(1, 3)
-	fetchRequestListUUIDs := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate}
+	fetchRequestListUUIDs := []imap.FetchItem{
+		imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822Size,
+	}
 	fetchRequestDownload := []imap.FetchItem{
 		imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822,
 	}
@@ -179,7 +188,9 @@ func TestIntegrationDownloadMissingEmailsToFolderDownloadError(t *testing.T) {
 	downloader := buildFakeDownloader(mockClient)
 	interrupter := newInterruptOps(nil)
 
-	err := downloadMissingEmailsToFolder(downloader, maildirPath, "some-oldmail", interrupter)
+	err := downloadMissingEmailsToFolder(
+		downloader, maildirPath, "some-oldmail", interrupter, DownloadOptions{},
+	)
 
 	assert.Error(t, err)
 	assert.Equal(
@@ -197,3 +208,52 @@ func TestIntegrationDownloadMissingEmailsToFolderDownloadError(t *testing.T) {
 	// New directory contains two files.
 	assert.Equal(t, 2, len(downloadedMessages))
 }
+
+func TestIntegrationDownloadMissingEmailsToFolderRemoveAltered(t *testing.T) {
+	if val, found := os.LookupEnv("SKIP_INTEGRATION_TESTS"); !found || val != "0" {
+		t.Skip("integration tests disabled")
+	}
+
+	transform := func(content []byte) ([]byte, error) { return content, nil }
+	altering := map[string]DownloadOptions{
+		"max part size":  {MaxPartSize: 10},
+		"transform":      {Transform: transform},
+		"lf line ending": {LineEnding: LineEndingLF},
+		"address filter": {AddressFilter: regexp.MustCompile("someone@example.com")},
+		"headers only":   {HeadersOnly: true},
+		"headers preset": {FetchPreset: FetchPresetHeaders},
+		"metadata":       {FetchPreset: FetchPresetMetadata},
+	}
+	removing := map[string]func(*DownloadOptions){
+		"delete": func(opts *DownloadOptions) { opts.DeleteFromServer = true },
+		"move":   func(opts *DownloadOptions) { opts.MoveTo = "Archived" },
+	}
+
+	for altName, opts := range altering {
+		for removeName, remove := range removing {
+			t.Run(altName+" "+removeName, func(t *testing.T) {
+				mockPath := setUpEmptyMaildir(t, "some-folder", "some-oldmail")
+				// No expectations, i.e. the server must not be contacted at all.
+				mockClient := setUpMockClient(t, nil, nil, nil)
+
+				maildirPath := maildirPathT{base: mockPath, folder: "some-folder"}
+				downloader := buildFakeDownloader(mockClient)
+				interrupter := newInterruptOps(nil)
+
+				removeOpts := opts
+				remove(&removeOpts)
+				err := downloadMissingEmailsToFolder(
+					downloader, maildirPath, "some-oldmail", interrupter, removeOpts,
+				)
+
+				assert.Error(t, err)
+				assert.Empty(t, mockClient.Calls)
+				for _, dir := range []string{"new", "cur"} {
+					entries, err := os.ReadDir(filepath.Join(mockPath, "some-folder", dir))
+					assert.NoError(t, err)
+					assert.Empty(t, entries)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import "github.com/emersion/go-imap"
+
+// Downloader provides the operations of this package that interact with servers or maildirs.
+// Applications that depend on an implementation of this interface instead of calling the
+// functions of this package directly can inject a fake one in their tests. Use NewDownloader to
+// obtain the real implementation, which calls the respective functions of this package.
+type Downloader interface {
+	// TryConnect, see the function of the same name.
+	TryConnect(cfg IMAPConfig) error
+	// GetAllFolders, see the function of the same name.
+	GetAllFolders(cfg IMAPConfig) ([]string, error)
+	// GetFolderInfos, see the function of the same name.
+	GetFolderInfos(cfg IMAPConfig) ([]FolderInfo, error)
+	// GetFolderSummaries, see the function of the same name.
+	GetFolderSummaries(cfg IMAPConfig, threads int) ([]FolderSummary, error)
+	// GetCapabilities, see the function of the same name.
+	GetCapabilities(cfg IMAPConfig) ([]string, error)
+	// GetNamespaces, see the function of the same name.
+	GetNamespaces(cfg IMAPConfig) (Namespaces, error)
+	// GetMessageCounts, see the function of the same name.
+	GetMessageCounts(cfg IMAPConfig, folderSpecs []string) ([]FolderCount, error)
+	// SearchFolders, see the function of the same name.
+	SearchFolders(
+		cfg IMAPConfig, folderSpecs []string, criteria *imap.SearchCriteria,
+	) ([]FolderSearchResult, error)
+	// DownloadFolder, see the function of the same name.
+	DownloadFolder(
+		cfg IMAPConfig, folders []string, maildirBase string, threads int, opts DownloadOptions,
+	) error
+	// DownloadAccounts, see the function of the same name.
+	DownloadAccounts(accounts []Account, threads int, opts DownloadOptions) error
+	// ReconcileFolders, see the function of the same name.
+	ReconcileFolders(
+		cfg IMAPConfig, folderSpecs []string, maildirBase string,
+	) ([]ReconcileResult, error)
+	// PruneMaildirs, see the function of the same name.
+	PruneMaildirs(
+		cfg IMAPConfig, folders []string, maildirBase string, opts PruneOptions,
+	) ([]PruneResult, error)
+	// ServeMaildir, see the function of the same name.
+	ServeMaildir(cfg IMAPConfig, serverPort int, maildirBase string) error
+}
+
+// NewDownloader provides the default implementation of Downloader.
+func NewDownloader() Downloader {
+	return packageDownloader{}
+}
+
+// Type packageDownloader implements Downloader via the functions of this package.
+type packageDownloader struct{}
+
+func (packageDownloader) TryConnect(cfg IMAPConfig) error {
+	return TryConnect(cfg)
+}
+
+func (packageDownloader) GetAllFolders(cfg IMAPConfig) ([]string, error) {
+	return GetAllFolders(cfg)
+}
+
+func (packageDownloader) GetFolderInfos(cfg IMAPConfig) ([]FolderInfo, error) {
+	return GetFolderInfos(cfg)
+}
+
+func (packageDownloader) GetFolderSummaries(cfg IMAPConfig, threads int) ([]FolderSummary, error) {
+	return GetFolderSummaries(cfg, threads)
+}
+
+func (packageDownloader) GetCapabilities(cfg IMAPConfig) ([]string, error) {
+	return GetCapabilities(cfg)
+}
+
+func (packageDownloader) GetNamespaces(cfg IMAPConfig) (Namespaces, error) {
+	return GetNamespaces(cfg)
+}
+
+func (packageDownloader) GetMessageCounts(
+	cfg IMAPConfig, folderSpecs []string,
+) ([]FolderCount, error) {
+	return GetMessageCounts(cfg, folderSpecs)
+}
+
+func (packageDownloader) SearchFolders(
+	cfg IMAPConfig, folderSpecs []string, criteria *imap.SearchCriteria,
+) ([]FolderSearchResult, error) {
+	return SearchFolders(cfg, folderSpecs, criteria)
+}
+
+func (packageDownloader) DownloadFolder(
+	cfg IMAPConfig, folders []string, maildirBase string, threads int, opts DownloadOptions,
+) error {
+	return DownloadFolder(cfg, folders, maildirBase, threads, opts)
+}
+
+func (packageDownloader) DownloadAccounts(
+	accounts []Account, threads int, opts DownloadOptions,
+) error {
+	return DownloadAccounts(accounts, threads, opts)
+}
+
+func (packageDownloader) ReconcileFolders(
+	cfg IMAPConfig, folderSpecs []string, maildirBase string,
+) ([]ReconcileResult, error) {
+	return ReconcileFolders(cfg, folderSpecs, maildirBase)
+}
+
+func (packageDownloader) PruneMaildirs(
+	cfg IMAPConfig, folders []string, maildirBase string, opts PruneOptions,
+) ([]PruneResult, error) {
+	return PruneMaildirs(cfg, folders, maildirBase, opts)
+}
+
+func (packageDownloader) ServeMaildir(cfg IMAPConfig, serverPort int, maildirBase string) error {
+	return ServeMaildir(cfg, serverPort, maildirBase)
+}
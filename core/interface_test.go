@@ -0,0 +1,71 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The default implementation calls the functions of this package. Thus, it suffices to check that
+// each method reaches the server, which fails to authenticate here.
+func TestNewDownloader(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	authErr := fmt.Errorf("some auth error")
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(authErr)
+	mock.On("logout", true).Return(nil).Maybe()
+	setUpCoreTest(t, mock)
+	account := Account{Config: cfg, Folders: []string{"INBOX"}, MaildirBase: t.TempDir()}
+
+	downloader := NewDownloader()
+
+	assert.ErrorIs(t, downloader.TryConnect(cfg), authErr)
+	_, err := downloader.GetAllFolders(cfg)
+	assert.ErrorIs(t, err, authErr)
+	_, err = downloader.GetFolderInfos(cfg)
+	assert.ErrorIs(t, err, authErr)
+	_, err = downloader.GetFolderSummaries(cfg, 1)
+	assert.ErrorIs(t, err, authErr)
+	_, err = downloader.GetCapabilities(cfg)
+	assert.ErrorIs(t, err, authErr)
+	_, err = downloader.GetNamespaces(cfg)
+	assert.ErrorIs(t, err, authErr)
+	_, err = downloader.GetMessageCounts(cfg, []string{"INBOX"})
+	assert.ErrorContains(t, err, "some auth error")
+	_, err = downloader.SearchFolders(cfg, []string{"INBOX"}, nil)
+	assert.ErrorContains(t, err, "some auth error")
+	_, err = downloader.ReconcileFolders(cfg, []string{"INBOX"}, account.MaildirBase)
+	assert.ErrorContains(t, err, "some auth error")
+	err = downloader.DownloadFolder(cfg, account.Folders, account.MaildirBase, 1, DownloadOptions{})
+	assert.ErrorContains(t, err, "some auth error")
+	err = downloader.DownloadAccounts([]Account{account}, 1, DownloadOptions{})
+	assert.ErrorContains(t, err, "some auth error")
+	mock.AssertExpectations(t)
+}
+
+func TestNewDownloaderLocalOperations(t *testing.T) {
+	downloader := NewDownloader()
+
+	_, err := downloader.PruneMaildirs(IMAPConfig{}, nil, t.TempDir(), PruneOptions{})
+	assert.ErrorContains(t, err, "maximum age for pruning must be positive")
+	err = downloader.ServeMaildir(IMAPConfig{}, 0, "")
+	assert.Error(t, err)
+}
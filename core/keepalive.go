@@ -0,0 +1,109 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// Servers might drop connections that have been idle for a while, e.g. while local storage is being
+// prepared on a slow machine. A keepalive issues NOOP commands whenever no retrieval has progressed
+// for a given interval. Retrievals and NOOPs exclude each other so that commands never interleave.
+type keepalive struct {
+	// The mutex is held while retrieving emails or while issuing a NOOP.
+	mutex sync.Mutex
+	last  time.Time
+}
+
+// Start issuing NOOP commands via the given client. The returned function stops doing so and must
+// be called before the client is used for commands other than retrievals. A nil keepalive does
+// nothing.
+func (k *keepalive) start(imapClient imapOps, interval time.Duration) (stop func()) {
+	if k == nil || interval <= 0 {
+		return func() {}
+	}
+	k.mutex.Lock()
+	k.last = now()
+	k.mutex.Unlock()
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-ticker.C:
+				k.noopIfIdle(imapClient, interval)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-exited
+	}
+}
+
+// Issue a NOOP if nothing has been retrieved for the given interval. Retrievals in progress are
+// never interrupted.
+func (k *keepalive) noopIfIdle(imapClient imapOps, interval time.Duration) {
+	if !k.mutex.TryLock() {
+		return
+	}
+	defer k.mutex.Unlock()
+	if now().Sub(k.last) < interval {
+		return
+	}
+	logInfo("connection has been idle, sending keepalive")
+	if err := imapClient.Noop(); err != nil {
+		logWarning(fmt.Sprintf("keepalive failed: %s", err.Error()))
+	}
+	k.last = now()
+}
+
+// Wrap a client such that retrievals pause the keepalive while they are in progress. A nil
+// keepalive returns the client unchanged.
+func (k *keepalive) wrap(imapClient imapOps) imapOps {
+	if k == nil {
+		return imapClient
+	}
+	return &keepaliveClient{imapOps: imapClient, keepalive: k}
+}
+
+type keepaliveClient struct {
+	imapOps
+	keepalive *keepalive
+}
+
+func (c *keepaliveClient) UidFetch( //nolint:revive,stylecheck
+	seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message,
+) error {
+	c.keepalive.mutex.Lock()
+	defer func() {
+		c.keepalive.last = now()
+		c.keepalive.mutex.Unlock()
+	}()
+	return c.imapOps.UidFetch(seqset, items, ch)
+}
@@ -0,0 +1,94 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestKeepaliveSendsNoopWhenIdle(t *testing.T) {
+	m := &mockClient{}
+	noops := make(chan struct{}, 10)
+	m.On("Noop").Return(nil).Run(func(mock.Arguments) { noops <- struct{}{} })
+
+	stop := (&keepalive{}).start(m, time.Millisecond)
+	<-noops
+	stop()
+
+	m.AssertExpectations(t)
+}
+
+func TestKeepaliveNoopIfIdle(t *testing.T) {
+	setUpNow(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	m := &mockClient{}
+	m.On("Noop").Return(fmt.Errorf("some error")).Once()
+	k := &keepalive{last: now().Add(-30 * time.Second)}
+
+	// Not idle for long enough yet.
+	k.noopIfIdle(m, time.Minute)
+	m.AssertNotCalled(t, "Noop")
+
+	k.last = now().Add(-time.Minute)
+	// Errors are only logged.
+	k.noopIfIdle(m, time.Minute)
+	m.AssertExpectations(t)
+	assert.Equal(t, now(), k.last)
+}
+
+func TestKeepaliveNoNoopWhileFetching(t *testing.T) {
+	m := &mockClient{}
+	k := &keepalive{}
+	fetching := make(chan struct{})
+	proceed := make(chan struct{})
+	m.On("UidFetch", mock.Anything, mock.Anything, mock.Anything).Return(nil).
+		Run(func(mock.Arguments) {
+			close(fetching)
+			<-proceed
+		})
+	client := k.wrap(m)
+
+	done := make(chan error)
+	go func() {
+		done <- client.UidFetch(&imap.SeqSet{}, nil, make(chan *imap.Message))
+	}()
+	<-fetching
+	// The retrieval is in progress, which holds off any NOOP, no matter how long it takes.
+	k.noopIfIdle(m, 0)
+	close(proceed)
+
+	assert.NoError(t, <-done)
+	m.AssertNotCalled(t, "Noop")
+	m.AssertExpectations(t)
+}
+
+func TestKeepaliveDisabled(t *testing.T) {
+	m := &mockClient{}
+	var k *keepalive
+
+	k.start(m, time.Millisecond)()
+	(&keepalive{}).start(m, 0)()
+
+	assert.Equal(t, m, k.wrap(m))
+	m.AssertNotCalled(t, "Noop")
+}
@@ -0,0 +1,135 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+const (
+	crlf = "\r\n"
+	lf   = "\n"
+)
+
+// Type lineEndingStorer converts the CRLF line endings of emails, as delivered by IMAP servers, to
+// LF before handing them to the underlying storer. Only headers, multipart delimiters, and text
+// bodies are converted. Bodies of other parts, e.g. attachments, are kept byte for byte since they
+// might contain binary data.
+type lineEndingStorer struct {
+	Storer
+}
+
+// Write converts the line endings of an email and stores the result. Emails whose header cannot be
+// parsed are stored unchanged.
+func (s *lineEndingStorer) Write(info EmailInfo, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	var converted bytes.Buffer
+	if err := convertEntityToLF(&converted, data); err != nil {
+		logWarning(fmt.Sprintf(
+			"storing email %s with unchanged line endings: %s", info.Key, err.Error(),
+		))
+		return s.Storer.Write(info, bytes.NewReader(data))
+	}
+	return s.Storer.Write(info, &converted)
+}
+
+// Write an entity to a buffer, converting line endings where that is safe. For multipart entities
+// and attached emails, each part is converted recursively. Bodies of other entities that are not
+// text are copied as they are.
+func convertEntityToLF(buf *bytes.Buffer, entity []byte) error {
+	headerSize := headerLength(entity)
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(entity[:headerSize])))
+	if err != nil {
+		return err
+	}
+	buf.Write(toLF(entity[:headerSize]))
+	body := entity[headerSize:]
+
+	mediaType, params := mediaTypeOf(header)
+	switch {
+	case strings.HasPrefix(mediaType, multipartPrefix) && params["boundary"] != "":
+		return convertMultipartToLF(buf, body, params["boundary"])
+	case mediaType == "message/rfc822":
+		return convertEntityToLF(buf, body)
+	case strings.HasPrefix(mediaType, "text/"):
+		buf.Write(toLF(body))
+	default:
+		buf.Write(body)
+	}
+	return nil
+}
+
+// Convert the body of a multipart entity part by part. The preamble, the epilogue, and the
+// delimiter lines are text. The line ending preceding a delimiter belongs to the delimiter as per
+// RFC 2046. It is left as it is after parts that are not converted, which keeps those parts intact.
+func convertMultipartToLF(buf *bytes.Buffer, body []byte, boundary string) error {
+	delimiter := "--" + boundary
+	var part []byte
+	inPart, closed := false, false
+	flush := func() error {
+		var err error
+		if inPart {
+			err = convertEntityToLF(buf, part)
+		} else {
+			buf.Write(toLF(part))
+		}
+		part = nil
+		return err
+	}
+	for _, line := range bytes.SplitAfter(body, []byte(lf)) {
+		trimmed := strings.TrimRight(string(line), " \t\r\n")
+		isDelimiter := !closed && (trimmed == delimiter || trimmed == delimiter+"--")
+		if !isDelimiter {
+			part = append(part, line...)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		buf.Write(toLF(line))
+		inPart = trimmed == delimiter
+		closed = !inPart
+	}
+	return flush()
+}
+
+// Determine the length of the header section of an entity including the empty line terminating
+// it. The whole entity is the header if there is no empty line.
+func headerLength(entity []byte) int {
+	length := 0
+	for _, line := range bytes.SplitAfter(entity, []byte(lf)) {
+		length += len(line)
+		if string(line) == crlf || string(line) == lf {
+			break
+		}
+	}
+	return length
+}
+
+func toLF(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte(crlf), []byte(lf))
+}
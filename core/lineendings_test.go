@@ -0,0 +1,146 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An attachment whose base64 lines end in CRLF and whose content is binary.
+const lineEndingAttachment = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4\r\n" +
+	"nGNgYGD4DwABBAEAHnOcQAAAAABJRU5ErkJggg==\r\n"
+
+const lineEndingEmail = "From: someone@example.com\r\n" +
+	"Subject: line endings\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"a preamble\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"some text\r\n" +
+	"spanning lines\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	lineEndingAttachment +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"\r\n" +
+	"binary\r\n\x00\r\ndata\r\n" +
+	"--outer--\r\n" +
+	"an epilogue\r\n"
+
+func TestConvertEntityToLFMultipart(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	err := convertEntityToLF(&buf, []byte(lineEndingEmail))
+
+	require.NoError(t, err)
+	expected := "From: someone@example.com\n" +
+		"Subject: line endings\n" +
+		"Content-Type: multipart/mixed; boundary=outer\n" +
+		"\n" +
+		"a preamble\n" +
+		"--outer\n" +
+		"Content-Type: text/plain\n" +
+		"\n" +
+		"some text\n" +
+		"spanning lines\n" +
+		"--outer\n" +
+		"Content-Type: image/png\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		lineEndingAttachment +
+		"--outer\n" +
+		"Content-Type: application/octet-stream\n" +
+		"\n" +
+		"binary\r\n\x00\r\ndata\r\n" +
+		"--outer--\n" +
+		"an epilogue\n"
+	assert.Equal(t, expected, buf.String())
+	// The attachment is untouched.
+	assert.Contains(t, buf.String(), "\n\n"+lineEndingAttachment+"--outer\n")
+}
+
+func TestConvertEntityToLFSinglePart(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := convertEntityToLF(&buf, []byte("Subject: text\r\n\r\nsome\r\ntext\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "Subject: text\n\nsome\ntext\n", buf.String())
+
+	buf.Reset()
+	err = convertEntityToLF(&buf, []byte(
+		"Subject: binary\r\nContent-Type: application/pdf\r\n\r\nsome\r\ndata\r\n",
+	))
+	require.NoError(t, err)
+	expected := "Subject: binary\nContent-Type: application/pdf\n\nsome\r\ndata\r\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestConvertEntityToLFHeaderOnly(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	err := convertEntityToLF(&buf, []byte("From: someone@example.com\r\nSubject: header\r\n"))
+
+	require.NoError(t, err)
+	assert.Equal(t, "From: someone@example.com\nSubject: header\n", buf.String())
+}
+
+func TestConvertEntityToLFAttachedEmail(t *testing.T) {
+	email := "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: message/rfc822\r\n\r\n" +
+		"Subject: attached\r\n\r\nattached text\r\n" +
+		"--b--\r\n"
+	buf := bytes.Buffer{}
+
+	err := convertEntityToLF(&buf, []byte(email))
+
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "\r")
+}
+
+func TestLineEndingStorerWrite(t *testing.T) {
+	m := &mockStorer{}
+	m.On("Write", EmailInfo{Key: "42/1"}, "Subject: text\n\nsome text\n").Return(nil)
+	storer := lineEndingStorer{Storer: m}
+
+	content := strings.NewReader("Subject: text\r\n\r\nsome text\r\n")
+	err := storer.Write(EmailInfo{Key: "42/1"}, content)
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestLineEndingStorerWriteMalformed(t *testing.T) {
+	malformed := "not a header\r\n\r\nsome text\r\n"
+	m := &mockStorer{}
+	m.On("Write", EmailInfo{Key: "42/1"}, malformed).Return(nil)
+	storer := lineEndingStorer{Storer: m}
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(malformed))
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
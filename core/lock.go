@@ -0,0 +1,140 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rogpeppe/go-internal/lockedfile"
+)
+
+const lockSuffix = ".lock"
+
+// The time to wait for a folder lock held by another process before giving up. Having a short
+// timeout avoids failing due to other processes only briefly inspecting a lock.
+var lockTimeout = 2 * time.Second
+
+// Since channels can only pass on single types but no tuples, we create this type.
+type folderLockT struct {
+	file *lockedfile.File
+	err  error
+}
+
+// Lock a folder of a maildir so that no two processes download to it at the same time. The lock
+// is a file next to the folder's oldmail file that is protected by an advisory file lock. While
+// held, the file contains the PID and host name of the holder as well as the time the lock was
+// acquired. The operating system releases the lock once the holder exits, even if it crashes.
+// Thus, a lock file that still has content when acquiring the lock stems from a crashed run,
+// which is reported but does not prevent the download. The returned function releases the lock.
+func lockFolder(oldmailName string, maildirPath maildirPathT) (func(), error) {
+	oldmailName = strings.ReplaceAll(oldmailName, string(os.PathSeparator), ".")
+	path := filepath.Join(maildirPath.basePath(), oldmailName+lockSuffix)
+	err := os.MkdirAll(maildirPath.basePath(), dirPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Obtain lock, honoring the timeout. Note that this leaks a goroutine if the timeout is
+	// reached before the lock can be obtained, but there seems to be no way around that.
+	resultChan := make(chan folderLockT, 1)
+	go func() {
+		file, err := lockedfile.OpenFile(path, os.O_RDWR|os.O_CREATE, filePerm)
+		resultChan <- folderLockT{file: file, err: err}
+	}()
+	var result folderLockT
+	select {
+	case <-time.After(lockTimeout):
+		go func() {
+			if result := <-resultChan; result.file != nil {
+				_ = result.file.Close()
+			}
+		}()
+		return nil, fmt.Errorf(
+			"folder %s is locked by %s, refusing to download concurrently",
+			maildirPath.folderName(), describeLockHolder(path),
+		)
+	case result = <-resultChan:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	err = claimLock(result.file, maildirPath)
+	if err != nil {
+		_ = result.file.Close()
+		return nil, err
+	}
+	unlock := func() {
+		// Emptying the lock file marks it as released properly. Only files of crashed runs keep
+		// their content.
+		err := result.file.Truncate(0)
+		if closeErr := result.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			logWarning(fmt.Sprintf(
+				"cannot release lock for folder %s: %s", maildirPath, err.Error(),
+			))
+		}
+	}
+	return unlock, nil
+}
+
+// Replace the content of an acquired lock file by information about this process. Any previous
+// content belongs to a run that did not release the lock properly and is reported.
+func claimLock(file *lockedfile.File, maildirPath maildirPathT) error {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if holder := strings.TrimSpace(string(content)); len(holder) > 0 {
+		logWarning(fmt.Sprintf(
+			"removing stale lock for folder %s of a crashed run: %s", maildirPath, holder,
+		))
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	holder := fmt.Sprintf(
+		"pid %d on %s since %s\n", os.Getpid(), host, now().Format(time.RFC3339),
+	)
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt([]byte(holder), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	return err
+}
+
+// Describe the holder of a lock based on the lock file's content. The file might not be readable
+// while locked on some systems, in which case there is no detailed information.
+func describeLockHolder(path string) string {
+	content, err := os.ReadFile(path) // nolint: gosec
+	if holder := strings.TrimSpace(string(content)); err == nil && len(holder) > 0 {
+		return holder
+	}
+	return "another process"
+}
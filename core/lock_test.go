@@ -0,0 +1,108 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpLockTest(t *testing.T) (maildirPathT, string) {
+	t.Helper()
+	orgTimeout := lockTimeout
+	lockTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { lockTimeout = orgTimeout })
+
+	maildirPath := maildirPathT{base: filepath.Join(t.TempDir(), "base"), folder: "some/folder"}
+	return maildirPath, filepath.Join(maildirPath.basePath(), "oldmail-some.folder.lock")
+}
+
+func TestLockFolderSuccess(t *testing.T) {
+	maildirPath, lockPath := setUpLockTest(t)
+
+	unlock, err := lockFolder("oldmail-some/folder", maildirPath)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(lockPath)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "pid "), string(content))
+	assert.Contains(t, string(content), " since ")
+
+	unlock()
+	content, err = os.ReadFile(lockPath)
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+
+	// The lock can be acquired again after having been released.
+	unlock, err = lockFolder("oldmail-some/folder", maildirPath)
+	assert.NoError(t, err)
+	unlock()
+}
+
+func TestLockFolderAlreadyHeld(t *testing.T) {
+	maildirPath, _ := setUpLockTest(t)
+
+	unlock, err := lockFolder("oldmail-some/folder", maildirPath)
+	assert.NoError(t, err)
+	defer unlock()
+
+	_, err = lockFolder("oldmail-some/folder", maildirPath)
+	assert.ErrorContains(t, err, "folder some/folder is locked by pid ")
+
+	// Other folders can still be locked.
+	otherUnlock, err := lockFolder("oldmail-other", maildirPath)
+	assert.NoError(t, err)
+	otherUnlock()
+}
+
+func TestLockFolderStaleLock(t *testing.T) {
+	maildirPath, lockPath := setUpLockTest(t)
+
+	err := os.MkdirAll(maildirPath.basePath(), dirPerm)
+	assert.NoError(t, err)
+	stale := "pid 1 on crashed since some time, longer content\n"
+	err = os.WriteFile(lockPath, []byte(stale), filePerm)
+	assert.NoError(t, err)
+
+	unlock, err := lockFolder("oldmail-some/folder", maildirPath)
+	assert.NoError(t, err)
+	defer unlock()
+
+	content, err := os.ReadFile(lockPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "crashed")
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+}
+
+func TestLockFolderCannotCreateBase(t *testing.T) {
+	maildirPath, _ := setUpLockTest(t)
+
+	// The base path is below a regular file and can thus not be created.
+	file := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(file, []byte{}, filePerm)
+	assert.NoError(t, err)
+	maildirPath.base = filepath.Join(file, "base")
+
+	_, err = lockFolder("oldmail-some/folder", maildirPath)
+	assert.Error(t, err)
+}
@@ -18,11 +18,20 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"time"
 )
 
 const logJoiner = ", "
 
+// Identical warnings and errors logged within this window after their first occurrence are
+// coalesced into a single line stating how often they were repeated. This keeps logs readable if a
+// misbehaving server causes many download goroutines to log the same error over and over again.
+const logRepeatWindow = 10 * time.Second
+
 var verbose = false
 
 // SetVerboseLogs sets the log level for core functionality to verbose if passed true and to less
@@ -39,10 +48,77 @@ func logInfo(msg string) {
 
 func logWarning(msg string) {
 	// Always log warning.
-	log.Println("WARNING", msg)
+	repeatedLogs.println("WARNING", msg)
 }
 
 func logError(msg string) {
 	// Always log errors.
-	log.Println("ERROR", msg)
+	repeatedLogs.println("ERROR", msg)
+}
+
+// Type logRepeat tracks how often a message has been suppressed since it was last logged.
+type logRepeat struct {
+	level    string
+	msg      string
+	since    time.Time
+	repeated int
+}
+
+// Type logThrottle coalesces identical log lines, see logRepeatWindow. It is safe for concurrent
+// use. Messages are still logged in the order in which they occur, only repetitions are delayed.
+type logThrottle struct {
+	mutex   sync.Mutex
+	repeats map[string]*logRepeat
+}
+
+var repeatedLogs = newLogThrottle()
+
+func newLogThrottle() *logThrottle {
+	return &logThrottle{repeats: map[string]*logRepeat{}}
+}
+
+func (l *logThrottle) println(level, msg string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current := now()
+	l.flushBefore(current.Add(-logRepeatWindow))
+	key := level + " " + msg
+	if repeat, found := l.repeats[key]; found {
+		repeat.repeated++
+		return
+	}
+	log.Println(level, msg)
+	l.repeats[key] = &logRepeat{level: level, msg: msg, since: current}
+}
+
+// Report and forget about all messages first logged before the given time. Later occurrences of
+// those messages will be logged again.
+func (l *logThrottle) flushBefore(before time.Time) {
+	expired := []*logRepeat{}
+	for key, repeat := range l.repeats {
+		if repeat.since.Before(before) {
+			expired = append(expired, repeat)
+			delete(l.repeats, key)
+		}
+	}
+	// Report in the order in which messages have first been logged.
+	sort.Slice(expired, func(i, j int) bool { return expired[i].since.Before(expired[j].since) })
+	for _, repeat := range expired {
+		if repeat.repeated > 0 {
+			log.Println(repeat.level, fmt.Sprintf(
+				"last message repeated %d times: %s", repeat.repeated, repeat.msg,
+			))
+		}
+	}
+}
+
+// FlushRepeatedLogs reports all warnings and errors that have been suppressed as repetitions so
+// far so that none remain unreported. Afterwards, any message is logged again on its next
+// occurrence. Call it before the process exits since repetitions are only reported by
+// DownloadFolder otherwise.
+func FlushRepeatedLogs() {
+	repeatedLogs.mutex.Lock()
+	defer repeatedLogs.mutex.Unlock()
+	repeatedLogs.flushBefore(now().Add(time.Nanosecond))
 }
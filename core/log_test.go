@@ -21,7 +21,9 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -29,6 +31,7 @@ import (
 func setUpLogTest() (*bytes.Buffer, func()) {
 	buf := bytes.Buffer{}
 	log.SetOutput(&buf)
+	repeatedLogs = newLogThrottle()
 
 	deferMe := func() {
 		log.SetOutput(os.Stderr)
@@ -92,3 +95,35 @@ func TestLogErrorVerbose(t *testing.T) {
 	logError("some message")
 	assert.Contains(t, buf.String(), "ERROR some message")
 }
+
+func TestLogErrorRepeated(t *testing.T) {
+	buf, cleanUp := setUpLogTest()
+	defer cleanUp()
+	current := time.Unix(1000, 0)
+	orgNow := now
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = orgNow })
+
+	for range 3 {
+		logError("some message")
+	}
+	logWarning("some message")
+	logError("other message")
+	logError("some message")
+	assert.Equal(t, 1, strings.Count(buf.String(), "ERROR some message"))
+	assert.Contains(t, buf.String(), "WARNING some message")
+	assert.Contains(t, buf.String(), "ERROR other message")
+	assert.NotContains(t, buf.String(), "repeated")
+
+	// Repetitions are reported once the window has passed and the message is logged again.
+	current = current.Add(logRepeatWindow + time.Second)
+	logError("some message")
+	assert.Contains(t, buf.String(), "ERROR last message repeated 3 times: some message")
+	assert.Equal(t, 1, strings.Count(buf.String(), "repeated"))
+	assert.True(t, strings.HasSuffix(buf.String(), "ERROR some message\n"))
+
+	logError("some message")
+	FlushRepeatedLogs()
+	assert.Contains(t, buf.String(), "ERROR last message repeated 1 times: some message")
+	assert.Empty(t, repeatedLogs.repeats)
+}
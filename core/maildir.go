@@ -21,6 +21,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -50,7 +51,8 @@ var deliveryCount = &threadSafeCounter{}
 // are very, very small. For that to happen, two processes on two different machines that have the
 // same hostname need to start a delivery at the very same time. Furthermore, they must have had
 // delivered the exact same number of emails since launch and a 8-bit cryptographic random number
-// must be identical. It is not clear how that should ever happen.
+// must be identical. It is not clear how that should ever happen. Machines that share a host name
+// and write to the same maildir can inject distinct host identifiers instead.
 //
 // You can inject a hostname, which allows to simulate generating a unique name for other
 // environments. If none is injected (empty hostname), generate data for the current system.
@@ -171,20 +173,31 @@ func initMaildir(oldmailName string, maildirPath maildirPathT) ([]oldmail, strin
 }
 
 // Write an email to the tmp sub-directory of a maildir with an appropriate, unique name and then
-// move it to new sub-directory as mandated by the maildir specs.
-func deliverMessage(rfc822 string, basePath string) (err error) {
+// move it to new sub-directory as mandated by the maildir specs. Return the unique file name. Both
+// the file and the new sub-directory are flushed to disk, which makes sure that no partially
+// written emails end up in the maildir after a crash. If cur is set, the email goes to the cur
+// sub-directory instead and the name of its file carries an info part with the given maildir flags,
+// e.g. "name:2,DS" or "name:2," without flags. The returned name never contains the info part.
+//
+// If a file name is given, it is used instead of a unique one. It is up to the caller to make sure
+// that the name is unique within the maildir.
+func deliverMessage(
+	rfc822 io.Reader, basePath, fileName, flags string, cur bool,
+) (_ string, err error) {
 	// Determine relevant paths.
-	var fileName, tmpPath, newPath string
-	fileName, err = newUniqueName("")
+	var tmpPath, newPath string
+	if fileName == "" {
+		fileName, err = newUniqueName("")
+	}
 	if err == nil {
 		tmpPath = filepath.Join(basePath, tmpMaildir, fileName)
-		newPath = filepath.Join(basePath, newMaildir, fileName)
+		newPath = filepath.Join(basePath, deliveredFilePath(fileName, flags, cur))
 		err = errorIfExists(tmpPath, fmt.Sprintf("unique file name '%s' is not unique", tmpPath))
 	}
 	// Write rfc822 to file.
 	if err == nil {
 		logInfo(fmt.Sprintf("writing new email to file %s", tmpPath))
-		err = os.WriteFile(tmpPath, []byte(rfc822), filePerm) //nolint:gosec
+		err = writeFile(tmpPath, rfc822)
 	}
 	// Move to new location but only if there exist no other file at that location, yet. This is in
 	// accordance with the maildir specs. It might take a while to write out a file, which means
@@ -196,5 +209,45 @@ func deliverMessage(rfc822 string, basePath string) (err error) {
 	if err == nil {
 		err = os.Rename(tmpPath, newPath)
 	}
-	return err
+	// Make sure the rename survives a crash.
+	if err == nil {
+		err = syncDir(filepath.Dir(newPath))
+	}
+	return fileName, err
+}
+
+// Determine the path relative to its maildir of a file delivered via deliverMessage.
+func deliveredFilePath(fileName, flags string, cur bool) string {
+	if !cur {
+		return filepath.Join(newMaildir, fileName)
+	}
+	return filepath.Join(curMaildir, fileName+maildirInfoPrefix+flags)
+}
+
+// Find the file of an email in a maildir by the name it was delivered with. The file might be in
+// the cur sub-directory, where its name might carry an info part with flags, e.g. "name:2,S",
+// either because it was delivered with flags or because a mail client moved it there. Return an
+// empty string if there is no such file.
+func findDeliveredFile(maildir, fileName string) string {
+	for _, dir := range []string{newMaildir, curMaildir} {
+		if path := filepath.Join(maildir, dir, fileName); isFile(path) {
+			return path
+		}
+	}
+	matches, err := filepath.Glob(filepath.Join(maildir, curMaildir, globEscape(fileName)+":*"))
+	if err != nil || len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+// Determine the path relative to its maildir of the file of an email delivered with the given
+// name, see findDeliveredFile. The path in the new sub-directory is assumed for missing files.
+func deliveredPath(maildir, fileName string) string {
+	if path := findDeliveredFile(maildir, fileName); path != "" {
+		if relPath, err := filepath.Rel(maildir, path); err == nil {
+			return relPath
+		}
+	}
+	return filepath.Join(newMaildir, fileName)
 }
@@ -17,12 +17,19 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 package core
 
-import "path/filepath"
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
 
 // Type maildirPathT provides routines to manipulate paths that are required to handle maildirs.
 type maildirPathT struct {
 	base   string
 	folder string
+	// The path of the folder's maildir relative to base. DefaultFolderPath is used if it is empty.
+	dir string
 }
 
 func (p maildirPathT) basePath() string {
@@ -30,9 +37,61 @@ func (p maildirPathT) basePath() string {
 }
 
 func (p maildirPathT) folderPath() string {
-	return filepath.Join(filepath.Clean(p.base), p.folder)
+	if p.dir != "" {
+		return filepath.Join(filepath.Clean(p.base), p.dir)
+	}
+	return filepath.Join(filepath.Clean(p.base), DefaultFolderPath(p.folder))
 }
 
 func (p maildirPathT) folderName() string {
 	return p.folder
 }
+
+// AccountDirName provides the name of the directory that the maildirs of an account are stored in
+// when using DownloadOptions.AccountDirs, i.e. "<USER>@<SERVER>". Path separators are replaced by
+// underscores so that the name is a single path component.
+func AccountDirName(cfg IMAPConfig) string {
+	name := fmt.Sprintf("%s@%s", cfg.User, cfg.Server)
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+}
+
+// DefaultFolderPath provides the path of the maildir of a folder relative to the maildir base that
+// is used unless DownloadOptions.FolderPath is set. It is the folder name with leading path
+// separators removed and any "." and ".." components resolved so that the path cannot leave the
+// maildir base.
+func DefaultFolderPath(folder string) string {
+	sep := string(filepath.Separator)
+	return strings.TrimPrefix(filepath.Clean(sep+folder), sep)
+}
+
+// Provide the path of the maildir of a folder below the maildir base, see FolderPath.
+func (o DownloadOptions) maildirPath(base, folder string) maildirPathT {
+	maildirPath := maildirPathT{base: base, folder: folder}
+	if o.FolderPath != nil {
+		maildirPath.dir = o.FolderPath(folder)
+	}
+	return maildirPath
+}
+
+// Make sure that the maildirs of all folders are distinct. Otherwise, emails of several folders
+// would end up in the same maildir. Paths are compared after cleaning them.
+func (o DownloadOptions) checkFolderPaths(base string, folders []string) error {
+	errs := []error{}
+	seen := map[string]string{}
+	for _, folder := range folders {
+		maildirPath := o.maildirPath(base, folder)
+		path := maildirPath.folderPath()
+		if (o.FolderPath != nil && maildirPath.dir == "") || path == maildirPath.basePath() {
+			errs = append(errs, fmt.Errorf("empty maildir path for folder '%s'", folder))
+			continue
+		}
+		if other, found := seen[path]; found {
+			errs = append(errs, fmt.Errorf(
+				"folders '%s' and '%s' map to the same maildir %s", other, folder, path,
+			))
+			continue
+		}
+		seen[path] = folder
+	}
+	return errors.Join(errs...)
+}
@@ -41,3 +41,56 @@ func TestFolderName(t *testing.T) {
 
 	assert.Equal(t, "folder name", handler.folderName())
 }
+
+func TestAccountDirName(t *testing.T) {
+	cfg := IMAPConfig{Server: "imap.example.com", User: "someone@example.com"}
+	assert.Equal(t, "someone@example.com@imap.example.com", AccountDirName(cfg))
+
+	cfg = IMAPConfig{Server: "imap.example.com", User: `domain\someone/else`}
+	assert.Equal(t, "domain_someone_else@imap.example.com", AccountDirName(cfg))
+}
+
+func TestFolderPathCustomDir(t *testing.T) {
+	handler := maildirPathT{base: "basepath", folder: "folder name", dir: "other"}
+
+	assert.Equal(t, "basepath"+string(os.PathSeparator)+"other", handler.folderPath())
+	assert.Equal(t, "folder name", handler.folderName())
+}
+
+func TestDefaultFolderPath(t *testing.T) {
+	sep := string(os.PathSeparator)
+
+	assert.Equal(t, "INBOX", DefaultFolderPath("INBOX"))
+	assert.Equal(t, "a"+sep+"b", DefaultFolderPath("a"+sep+"b"))
+	assert.Equal(t, "escape", DefaultFolderPath(".."+sep+".."+sep+"escape"))
+	assert.Equal(t, "root", DefaultFolderPath(sep+"root"))
+	assert.Equal(t, "", DefaultFolderPath(".."))
+}
+
+func TestDownloadOptionsMaildirPath(t *testing.T) {
+	opts := DownloadOptions{}
+	assert.Equal(t, maildirPathT{base: "base", folder: "INBOX"}, opts.maildirPath("base", "INBOX"))
+
+	opts.FolderPath = func(folder string) string { return "mapped-" + folder }
+	assert.Equal(
+		t,
+		maildirPathT{base: "base", folder: "INBOX", dir: "mapped-INBOX"},
+		opts.maildirPath("base", "INBOX"),
+	)
+}
+
+func TestDownloadOptionsCheckFolderPaths(t *testing.T) {
+	opts := DownloadOptions{}
+	assert.NoError(t, opts.checkFolderPaths("base", []string{"INBOX", "Sent"}))
+	assert.Error(t, opts.checkFolderPaths("base", []string{"INBOX", ".."}))
+
+	mapping := map[string]string{"INBOX": "inbox", "Sent": "sent", "Other": "./inbox", "Empty": ""}
+	opts.FolderPath = func(folder string) string { return mapping[folder] }
+	assert.NoError(t, opts.checkFolderPaths("base", []string{"INBOX", "Sent"}))
+
+	err := opts.checkFolderPaths("base", []string{"INBOX", "Sent", "Other"})
+	assert.ErrorContains(t, err, "folders 'INBOX' and 'Other' map to the same maildir")
+
+	err = opts.checkFolderPaths("base", []string{"INBOX", "Empty"})
+	assert.ErrorContains(t, err, "empty maildir path for folder 'Empty'")
+}
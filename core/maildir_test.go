@@ -20,9 +20,12 @@ package core
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setUpEmptyMaildir(t *testing.T, folderName, oldmailName string) string {
@@ -76,6 +79,35 @@ func TestNewUniqueNameBrokenNameFixes(t *testing.T) {
 	assert.Contains(t, newName, "BrokenHostname\\057withSlash")
 }
 
+func TestNewUniqueNameConcurrent(t *testing.T) {
+	const goroutines = 10
+	const namesPerGoroutine = 1000
+
+	names := make(chan string, goroutines*namesPerGoroutine)
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < namesPerGoroutine; j++ {
+				name, err := newUniqueName("some-host-id")
+				assert.NoError(t, err)
+				names <- name
+			}
+		}()
+	}
+	wg.Wait()
+	close(names)
+
+	set := map[string]struct{}{}
+	for name := range names {
+		assert.NotContains(t, set, name)
+		assert.True(t, strings.HasSuffix(name, ".some-host-id"))
+		set[name] = struct{}{}
+	}
+	assert.Equal(t, goroutines*namesPerGoroutine, len(set))
+}
+
 func TestNewUniqueNameStartAndEnd(t *testing.T) {
 	newName, err := newUniqueName("SomeHost")
 
@@ -168,11 +200,55 @@ func TestInitMaildirFailure(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestDeliverMessageGivenName(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	basepath := filepath.Join(tmpdir, "folder")
+
+	fileName, err := deliverMessage(strings.NewReader("some text"), basepath, "1.2.eml", "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.eml", fileName)
+	assert.FileExists(t, filepath.Join(basepath, "new", "1.2.eml"))
+
+	// Given names are not made unique.
+	_, err = deliverMessage(strings.NewReader("other text"), basepath, "1.2.eml", "", false)
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestDeliverMessageWithFlags(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	basepath := filepath.Join(tmpdir, "folder")
+
+	fileName, err := deliverMessage(strings.NewReader("some text"), basepath, "1.2.eml", "DS", true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.eml", fileName)
+	assert.FileExists(t, filepath.Join(basepath, "cur", "1.2.eml:2,DS"))
+	assert.NoFileExists(t, filepath.Join(basepath, "new", "1.2.eml"))
+	assert.Equal(t, filepath.Join("cur", "1.2.eml:2,DS"), deliveredPath(basepath, fileName))
+}
+
+func TestFindDeliveredFile(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	basepath := filepath.Join(tmpdir, "folder")
+	for _, path := range []string{"new/1.eml", "cur/2.eml", "cur/3.eml:2,S"} {
+		require.NoError(t, os.WriteFile(filepath.Join(basepath, path), []byte("text"), filePerm))
+	}
+
+	assert.Equal(t, filepath.Join(basepath, "new", "1.eml"), findDeliveredFile(basepath, "1.eml"))
+	assert.Equal(t, filepath.Join(basepath, "cur", "2.eml"), findDeliveredFile(basepath, "2.eml"))
+	assert.Equal(
+		t, filepath.Join(basepath, "cur", "3.eml:2,S"), findDeliveredFile(basepath, "3.eml"),
+	)
+	assert.Equal(t, "", findDeliveredFile(basepath, "4.eml"))
+	// Missing files are assumed to be in the new sub-directory.
+	assert.Equal(t, filepath.Join("new", "4.eml"), deliveredPath(basepath, "4.eml"))
+}
+
 func TestDeliverMessage(t *testing.T) {
 	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
 	basepath := filepath.Join(tmpdir, "folder")
 
-	err := deliverMessage("I am some text", basepath)
+	fileName, err := deliverMessage(strings.NewReader("I am some text"), basepath, "", "", false)
 
 	assert.NoError(t, err)
 
@@ -180,6 +256,7 @@ func TestDeliverMessage(t *testing.T) {
 	files, err := os.ReadDir(filepath.Join(basepath, "new"))
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(files))
+	assert.Equal(t, fileName, files[0].Name())
 
 	content, err := os.ReadFile(filepath.Join(basepath, "new", files[0].Name())) // nolint: gosec
 	assert.NoError(t, err)
@@ -0,0 +1,230 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// The name of the file in a maildir that lists all emails of the folder including meta data.
+	manifestName = "imapgrab-manifest.json"
+	// The suffix of compressed manifests.
+	manifestGzipSuffix = ".gz"
+)
+
+// Type manifest lists all emails of a folder that have been downloaded, see
+// DownloadOptions.Manifest.
+type manifest struct {
+	Folder   string          `json:"folder"`
+	Messages []manifestEntry `json:"messages"`
+}
+
+// Type manifestEntry describes a single email in a manifest. Sizes and flags are unknown for emails
+// that were downloaded without writing a manifest and are omitted in that case.
+type manifestEntry struct {
+	UIDValidity  uidFolder `json:"uidvalidity"`
+	UID          uid       `json:"uid"`
+	Size         int       `json:"size,omitempty"`
+	InternalDate time.Time `json:"internal_date"`
+	Flags        []string  `json:"flags,omitempty"`
+	// Path is relative to the folder's maildir. It is unknown for emails stored elsewhere.
+	Path string `json:"path,omitempty"`
+}
+
+func (e manifestEntry) key() string {
+	return uidExt{folder: e.UIDValidity, msg: e.UID}.String()
+}
+
+// Determine the path of the manifest of a folder.
+func manifestPath(folderPath string, compress bool) string {
+	path := filepath.Join(folderPath, manifestName)
+	if compress {
+		path += manifestGzipSuffix
+	}
+	return path
+}
+
+// Read the manifest of a folder. A missing manifest is no error but results in an empty one.
+func readManifest(folderPath string, compress bool) (result manifest, err error) {
+	handle, err := os.Open(manifestPath(folderPath, compress))
+	if os.IsNotExist(err) {
+		return manifest{}, nil
+	}
+	if err != nil {
+		return manifest{}, err
+	}
+	defer func() {
+		if closeErr := handle.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	var reader io.Reader = handle
+	if compress {
+		gzipReader, err := gzip.NewReader(handle)
+		if err != nil {
+			return manifest{}, err
+		}
+		reader = gzipReader
+	}
+	if err = json.NewDecoder(reader).Decode(&result); err != nil {
+		return manifest{}, fmt.Errorf("cannot read manifest: %s", err.Error())
+	}
+	return result, nil
+}
+
+// Provide the oldmail information for all emails in the manifest that are not yet known. That way,
+// emails listed in the manifest are considered downloaded.
+func (m manifest) missingOldmails(oldmails []oldmail) []oldmail {
+	known := make(map[string]struct{}, len(oldmails))
+	for _, om := range oldmails {
+		known[om.key()] = struct{}{}
+	}
+	missing := []oldmail{}
+	for _, entry := range m.Messages {
+		if _, found := known[entry.key()]; !found {
+			missing = append(missing, oldmail{
+				uidFolder: entry.UIDValidity,
+				uid:       entry.UID,
+				timestamp: int(entry.InternalDate.Unix()),
+			})
+		}
+	}
+	return missing
+}
+
+// Update a manifest with the emails that have just been stored and all emails remembered as stored
+// in the oldmail file. Information about emails that have just been stored takes precedence.
+// Paths of files in the maildir at the given path are determined from the list of file names, if
+// known.
+func (m *manifest) update(
+	stored []manifestEntry, oldmails []oldmail, folderPath string, fileNames map[string]string,
+) {
+	entries := make(map[string]manifestEntry, len(m.Messages)+len(stored))
+	for _, om := range oldmails {
+		entries[om.key()] = manifestEntry{
+			UIDValidity:  om.uidFolder,
+			UID:          om.uid,
+			InternalDate: time.Unix(int64(om.timestamp), 0).UTC(),
+		}
+	}
+	for _, entry := range m.Messages {
+		entries[entry.key()] = entry
+	}
+	for _, entry := range stored {
+		entries[entry.key()] = entry
+	}
+	m.Messages = make([]manifestEntry, 0, len(entries))
+	for key, entry := range entries {
+		if fileName, found := fileNames[key]; found && entry.Path == "" {
+			entry.Path = deliveredPath(folderPath, fileName)
+		}
+		m.Messages = append(m.Messages, entry)
+	}
+	sort.Slice(m.Messages, func(i, j int) bool {
+		if m.Messages[i].UIDValidity != m.Messages[j].UIDValidity {
+			return m.Messages[i].UIDValidity < m.Messages[j].UIDValidity
+		}
+		return m.Messages[i].UID < m.Messages[j].UID
+	})
+}
+
+// Write the manifest of a folder atomically, i.e. write it to a temporary file first and then
+// rename that file. That way, an existing manifest is never left partially written.
+func (m manifest) write(folderPath string, compress bool) error {
+	var buf bytes.Buffer
+	var writer io.Writer = &buf
+	var gzipWriter *gzip.Writer
+	if compress {
+		gzipWriter = gzip.NewWriter(&buf)
+		writer = gzipWriter
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(m)
+	if err == nil && gzipWriter != nil {
+		err = gzipWriter.Close()
+	}
+	path := manifestPath(folderPath, compress)
+	tmpPath := path + ".tmp"
+	if err == nil {
+		logInfo(fmt.Sprintf("writing manifest %s", path))
+		err = writeFile(tmpPath, &buf)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err == nil {
+		err = syncDir(folderPath)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write manifest: %s", err.Error())
+	}
+	return nil
+}
+
+// Update the manifest of a folder after a download with the emails that have just been stored.
+// Emails downloaded earlier are taken from the existing manifest and the oldmail file.
+func updateManifest(
+	folderPath, folderName string, stored []manifestEntry, oldmailPath string, compress bool,
+) error {
+	m, err := readManifest(folderPath, compress)
+	var oldmails []oldmail
+	if err == nil {
+		oldmails, err = readOldmail(oldmailPath)
+	}
+	var fileNames map[string]string
+	if err == nil {
+		fileNames, err = readUIDList(folderPath)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot update manifest: %s", err.Error())
+	}
+	m.Folder = folderName
+	m.update(stored, oldmails, folderPath, fileNames)
+	return m.write(folderPath, compress)
+}
+
+// manifestStorer records meta data about all emails that have been written successfully, which is
+// then added to the manifest of the folder.
+type manifestStorer struct {
+	Storer
+	entries []manifestEntry
+}
+
+func (s *manifestStorer) Write(info EmailInfo, content io.Reader) error {
+	counter := &countingReader{reader: content}
+	err := s.Storer.Write(info, counter)
+	entry := manifestEntry{InternalDate: info.InternalDate, Flags: info.Flags}
+	if err == nil {
+		_, err = fmt.Sscanf(info.Key, "%d/%d", &entry.UIDValidity, &entry.UID)
+	}
+	if err == nil {
+		entry.Size = counter.count
+		s.entries = append(s.entries, entry)
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		folderPath := t.TempDir()
+		written := manifest{
+			Folder: "INBOX",
+			Messages: []manifestEntry{{
+				UIDValidity: 42, UID: 1, Size: 123, InternalDate: time.Unix(12345, 0).UTC(),
+				Flags: []string{"\\Seen"}, Path: "new/some-file",
+			}},
+		}
+
+		err := written.write(folderPath, compress)
+		assert.NoError(t, err)
+		assert.FileExists(t, manifestPath(folderPath, compress))
+		// No temporary file is left behind.
+		assert.NoFileExists(t, manifestPath(folderPath, compress)+".tmp")
+
+		read, err := readManifest(folderPath, compress)
+		assert.NoError(t, err)
+		assert.Equal(t, written, read)
+	}
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	read, err := readManifest(t.TempDir(), false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, manifest{}, read)
+}
+
+func TestReadManifestBroken(t *testing.T) {
+	folderPath := t.TempDir()
+	err := os.WriteFile(manifestPath(folderPath, false), []byte("not json"), filePerm)
+	assert.NoError(t, err)
+
+	_, err = readManifest(folderPath, false)
+	assert.ErrorContains(t, err, "cannot read manifest")
+
+	// A manifest that is not compressed cannot be read as a compressed one.
+	err = os.WriteFile(manifestPath(folderPath, true), []byte("{}"), filePerm)
+	assert.NoError(t, err)
+
+	_, err = readManifest(folderPath, true)
+	assert.Error(t, err)
+}
+
+func TestManifestMissingOldmails(t *testing.T) {
+	m := manifest{Messages: []manifestEntry{
+		{UIDValidity: 42, UID: 1, InternalDate: time.Unix(1, 0)},
+		{UIDValidity: 42, UID: 2, InternalDate: time.Unix(2, 0)},
+	}}
+	oldmails := []oldmail{{uidFolder: 42, uid: 1, timestamp: 1}}
+
+	assert.Equal(t, []oldmail{{uidFolder: 42, uid: 2, timestamp: 2}}, m.missingOldmails(oldmails))
+}
+
+func TestManifestUpdate(t *testing.T) {
+	m := manifest{Messages: []manifestEntry{
+		{UIDValidity: 42, UID: 3, Size: 30, InternalDate: time.Unix(3, 0).UTC()},
+	}}
+	stored := []manifestEntry{
+		{UIDValidity: 42, UID: 2, Size: 20, InternalDate: time.Unix(2, 0).UTC()},
+	}
+	oldmails := []oldmail{
+		{uidFolder: 42, uid: 1, timestamp: 1},
+		{uidFolder: 42, uid: 2, timestamp: 2},
+		{uidFolder: 42, uid: 3, timestamp: 3},
+	}
+	fileNames := map[string]string{"42/1": "file1", "42/2": "file2"}
+
+	m.update(stored, oldmails, t.TempDir(), fileNames)
+
+	// Emails are sorted and the most detailed information available is kept.
+	assert.Equal(
+		t,
+		[]manifestEntry{
+			{
+				UIDValidity: 42, UID: 1, InternalDate: time.Unix(1, 0).UTC(),
+				Path: filepath.Join("new", "file1"),
+			},
+			{
+				UIDValidity: 42, UID: 2, Size: 20, InternalDate: time.Unix(2, 0).UTC(),
+				Path: filepath.Join("new", "file2"),
+			},
+			{UIDValidity: 42, UID: 3, Size: 30, InternalDate: time.Unix(3, 0).UTC()},
+		},
+		m.Messages,
+	)
+}
+
+func TestUpdateManifest(t *testing.T) {
+	folderPath := t.TempDir()
+	oldmailPath := filepath.Join(t.TempDir(), "oldmail")
+	err := os.WriteFile(oldmailPath, []byte("42/1\x001\n"), filePerm)
+	assert.NoError(t, err)
+	stored := []manifestEntry{{UIDValidity: 42, UID: 1, Size: 10, InternalDate: time.Unix(1, 0)}}
+
+	err = updateManifest(folderPath, "INBOX", stored, oldmailPath, true)
+	assert.NoError(t, err)
+
+	read, err := readManifest(folderPath, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "INBOX", read.Folder)
+	assert.Equal(t, 1, len(read.Messages))
+	assert.Equal(t, 10, read.Messages[0].Size)
+
+	// The oldmail file is required.
+	err = updateManifest(folderPath, "INBOX", stored, oldmailPath+"-missing", true)
+	assert.ErrorContains(t, err, "cannot update manifest")
+}
+
+func TestManifestStorer(t *testing.T) {
+	ms := &mockStorer{}
+	someTime := time.Unix(12345, 0).UTC()
+	ms.On("Write", EmailInfo{Key: "42/1", InternalDate: someTime, Flags: []string{"\\Seen"}},
+		"some content").Return(nil)
+	ms.On("Write", mock.Anything, "other content").Return(assert.AnError)
+
+	storer := &manifestStorer{Storer: ms}
+
+	err := storer.Write(
+		EmailInfo{Key: "42/1", InternalDate: someTime, Flags: []string{"\\Seen"}},
+		strings.NewReader("some content"),
+	)
+	assert.NoError(t, err)
+	err = storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("other content"))
+	assert.Error(t, err)
+
+	// Only emails that have been written successfully are recorded.
+	assert.Equal(
+		t,
+		[]manifestEntry{{
+			UIDValidity: 42, UID: 1, Size: 12, InternalDate: someTime, Flags: []string{"\\Seen"},
+		}},
+		storer.entries,
+	)
+	ms.AssertExpectations(t)
+}
@@ -0,0 +1,107 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+const mboxSuffix = ".mbox"
+
+// Lines that have to be quoted in the mboxrd format by prepending a ">".
+var mboxFromLine = regexp.MustCompile(`^>*From `)
+
+// Type mboxStorer appends emails to a file in the mboxrd format instead of delivering them to a
+// maildir. The file is located next to the maildir. Line endings are converted to LF and lines
+// starting with any number of ">" followed by "From " are quoted by prepending another ">". Like
+// for the default maildir storer, the oldmail information from previous runs is used to determine
+// which emails have already been stored. The file is opened on the first write and must be closed
+// once done.
+type mboxStorer struct {
+	*maildirStorer
+	path string
+	file fileOps
+}
+
+func newMboxStorer(folderPath string, oldmails []oldmail) *mboxStorer {
+	return &mboxStorer{
+		maildirStorer: newMaildirStorer(folderPath, oldmails),
+		path:          folderPath + mboxSuffix,
+	}
+}
+
+// Write appends an email to the mbox file. The internal date of the email is used in the line
+// separating it from the previous one.
+func (s *mboxStorer) Write(info EmailInfo, content io.Reader) (err error) {
+	if s.file == nil {
+		logInfo(fmt.Sprintf("opening mbox file %s", s.path))
+		s.file, err = openFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm)
+	}
+	var entry []byte
+	if err == nil {
+		entry, err = mboxEntry(info.InternalDate, content)
+	}
+	// Write each email with a single call to avoid partial entries in case of errors.
+	if err == nil {
+		_, err = s.file.Write(entry)
+	}
+	if err == nil {
+		s.known[info.Key] = struct{}{}
+	}
+	return err
+}
+
+// Close closes the mbox file, if it has been opened.
+func (s *mboxStorer) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// Format a single email in the mboxrd format, including the separating "From " line and the empty
+// line that ends it.
+func mboxEntry(date time.Time, content io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From MAILER-DAEMON %s\n", date.UTC().Format(time.ANSIC))
+	reader := bufio.NewReader(content)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if mboxFromLine.Match(line) {
+				buf.WriteByte('>')
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
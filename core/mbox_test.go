@@ -0,0 +1,93 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMboxEntry(t *testing.T) {
+	date := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	content := "From: someone\r\nSubject: hi\r\n\r\nFrom here on\r\n>From there\r\nlast line"
+
+	entry, err := mboxEntry(date, strings.NewReader(content))
+
+	assert.NoError(t, err)
+	expected := "From MAILER-DAEMON Wed Apr  5 06:07:08 2023\n" +
+		"From: someone\nSubject: hi\n\n>From here on\n>>From there\nlast line\n\n"
+	assert.Equal(t, expected, string(entry))
+}
+
+func TestMboxStorerWrite(t *testing.T) {
+	folderPath := filepath.Join(t.TempDir(), "INBOX")
+	storer := newMboxStorer(folderPath, []oldmail{{uidFolder: 42, uid: 1}})
+	date := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	for _, key := range []string{"42/2", "42/3"} {
+		err := storer.Write(EmailInfo{Key: key, InternalDate: date}, strings.NewReader("body "+key))
+		require.NoError(t, err)
+	}
+	err := storer.Close()
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(folderPath + mboxSuffix) // nolint: gosec
+	assert.NoError(t, err)
+	expected := "From MAILER-DAEMON Wed Apr  5 06:07:08 2023\nbody 42/2\n\n" +
+		"From MAILER-DAEMON Wed Apr  5 06:07:08 2023\nbody 42/3\n\n"
+	assert.Equal(t, expected, string(content))
+	for _, key := range []string{"42/1", "42/2", "42/3"} {
+		found, err := storer.Exists(key)
+		assert.NoError(t, err)
+		assert.True(t, found, key)
+	}
+}
+
+func TestMboxStorerCloseWithoutWrite(t *testing.T) {
+	folderPath := filepath.Join(t.TempDir(), "INBOX")
+	storer := newMboxStorer(folderPath, nil)
+
+	err := storer.Close()
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, folderPath+mboxSuffix)
+}
+
+func TestMboxStorerWriteError(t *testing.T) {
+	f, deferMe := setUpMockOldmailFile()
+	defer deferMe()
+	f.m.On("Write", []byte("From MAILER-DAEMON Thu Jan  1 00:00:00 1970\nbody\n\n")).
+		Return(0, fmt.Errorf("some error"))
+	storer := newMboxStorer("some-folder", nil)
+
+	info := EmailInfo{Key: "42/1", InternalDate: time.Unix(0, 0)}
+
+	err := storer.Write(info, strings.NewReader("body"))
+
+	assert.ErrorContains(t, err, "some error")
+	found, _ := storer.Exists("42/1")
+	assert.False(t, found)
+	f.m.AssertExpectations(t)
+}
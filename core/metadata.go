@@ -0,0 +1,144 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+const (
+	// The capability of servers that store annotations of folders via METADATA, see RFC 5464.
+	metadataCapability = "METADATA"
+	metadataCommand    = "GETMETADATA"
+	metadataResponse   = "METADATA"
+	// The name of the file in a maildir that holds the annotations of the folder.
+	metadataName = "imapgrab-metadata.json"
+)
+
+// Type getMetadataCmd is the command that asks the server for all annotations of a folder, i.e. all
+// shared and private entries at any depth.
+type getMetadataCmd struct {
+	mailbox string
+}
+
+func (cmd *getMetadataCmd) Command() *imap.Command {
+	return &imap.Command{
+		Name: metadataCommand,
+		Arguments: []interface{}{
+			[]interface{}{imap.RawString("DEPTH"), imap.RawString("infinity")},
+			imap.FormatMailboxName(cmd.mailbox),
+			[]interface{}{imap.RawString("/shared"), imap.RawString("/private")},
+		},
+	}
+}
+
+// Type metadataResp handles untagged METADATA responses for a folder, each of which contains a
+// list of entries and their values. Entries without values are reported as NIL and skipped.
+// Unsolicited responses that only name changed entries are ignored.
+type metadataResp struct {
+	mailbox string
+	entries map[string]string
+}
+
+func (r *metadataResp) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != metadataResponse || len(fields) != 2 { // nolint: gomnd
+		return responses.ErrUnhandled
+	}
+	mailbox, err := imap.ParseString(fields[0])
+	if err != nil || mailbox != r.mailbox {
+		return responses.ErrUnhandled
+	}
+	list, ok := fields[1].([]interface{})
+	if !ok {
+		return responses.ErrUnhandled
+	}
+	if len(list)%2 != 0 {
+		return fmt.Errorf("cannot parse metadata response %v", list)
+	}
+	for idx := 0; idx < len(list); idx += 2 {
+		entry, err := imap.ParseString(list[idx])
+		if err != nil {
+			return fmt.Errorf("cannot parse metadata entry: %s", err.Error())
+		}
+		if list[idx+1] == nil {
+			continue
+		}
+		value, err := imap.ParseString(list[idx+1])
+		if err != nil {
+			return fmt.Errorf("cannot parse value of metadata entry %s: %s", entry, err.Error())
+		}
+		r.entries[entry] = value
+	}
+	return nil
+}
+
+// Retrieve the annotations of a folder. The library does not implement METADATA, which is why the
+// command is issued directly. A nil map is returned if the server does not support it.
+func getMetadata(imapClient imapOps, folder string) (map[string]string, error) {
+	supported, err := imapClient.Support(metadataCapability)
+	if err != nil || !supported {
+		logInfo("server does not support metadata")
+		return nil, err
+	}
+	logInfo(fmt.Sprintf("retrieving metadata of folder %s", folder))
+	mailbox, err := utf7.Encoding.NewEncoder().String(folder)
+	if err != nil {
+		return nil, err
+	}
+	handler := &metadataResp{mailbox: mailbox, entries: map[string]string{}}
+	status, err := imapClient.Execute(&getMetadataCmd{mailbox: mailbox}, handler)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve metadata of folder %s: %s", folder, err.Error())
+	}
+	return handler.entries, nil
+}
+
+// Store the annotations of a folder next to its emails as a JSON object mapping entries to values.
+// The file is replaced every time so that it reflects the current state on the server.
+func writeMetadata(folderPath string, entries map[string]string) error {
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(folderPath, metadataName), bytes.NewReader(content))
+}
+
+// Store the annotations of a folder if the server supports them. Failures to retrieve them are only
+// logged since they must not prevent backing up the folder's emails.
+func backUpMetadata(ops downloadOps, maildirPath maildirPathT) error {
+	entries, err := ops.getMetadata(maildirPath.folderName())
+	if err != nil {
+		logWarning(err.Error())
+		return nil
+	}
+	if entries == nil {
+		return nil
+	}
+	return writeMetadata(maildirPath.folderPath(), entries)
+}
@@ -0,0 +1,199 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMetadataCommand(t *testing.T) {
+	cmd := (&getMetadataCmd{mailbox: "INBOX"}).Command()
+
+	assert.Equal(t, metadataCommand, cmd.Name)
+	expected := []interface{}{
+		[]interface{}{imap.RawString("DEPTH"), imap.RawString("infinity")},
+		imap.FormatMailboxName("INBOX"),
+		[]interface{}{imap.RawString("/shared"), imap.RawString("/private")},
+	}
+	assert.Equal(t, expected, cmd.Arguments)
+}
+
+func TestGetMetadataUnsupported(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", metadataCapability).Return(false, nil)
+
+	entries, err := getMetadata(m, "INBOX")
+
+	assert.NoError(t, err)
+	assert.Nil(t, entries)
+	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestGetMetadataSuccess(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", metadataCapability).Return(true, nil)
+	// Folder names are sent encoded as modified UTF-7.
+	m.On("Execute", &getMetadataCmd{mailbox: "Entw&APw-rfe"}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			handler := args.Get(1).(responses.Handler)
+			for _, fields := range [][]interface{}{
+				{metadataResponse, "Entw&APw-rfe", []interface{}{
+					"/shared/comment", "Drafts to review", "/private/color", nil,
+				}},
+				{metadataResponse, "Entw&APw-rfe", []interface{}{"/private/comment", "Mine"}},
+				// Responses for other folders are not considered.
+				{metadataResponse, "INBOX", []interface{}{"/shared/comment", "Inbox"}},
+			} {
+				_ = handler.Handle(&imap.DataResp{Fields: fields})
+			}
+		}).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+
+	entries, err := getMetadata(m, "Entwürfe")
+
+	assert.NoError(t, err)
+	expected := map[string]string{
+		"/shared/comment": "Drafts to review", "/private/comment": "Mine",
+	}
+	assert.Equal(t, expected, entries)
+	m.AssertExpectations(t)
+}
+
+func TestGetMetadataRejected(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", metadataCapability).Return(true, nil)
+	m.On("Execute", mock.Anything, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespNo, Info: "permission denied"}, nil)
+
+	_, err := getMetadata(m, "INBOX")
+
+	assert.ErrorContains(t, err, "cannot retrieve metadata of folder INBOX: permission denied")
+}
+
+func TestMetadataRespUnhandled(t *testing.T) {
+	for _, fields := range [][]interface{}{
+		{"CAPABILITY", "IMAP4rev1"},
+		// Unsolicited responses only name the changed entries.
+		{metadataResponse, "INBOX", "/shared/comment"},
+		{metadataResponse, "INBOX", "/shared/comment", "/private/comment"},
+	} {
+		handler := &metadataResp{mailbox: "INBOX", entries: map[string]string{}}
+
+		err := handler.Handle(&imap.DataResp{Fields: fields})
+
+		assert.Equal(t, responses.ErrUnhandled, err, fmt.Sprint(fields))
+	}
+}
+
+func TestMetadataRespMalformed(t *testing.T) {
+	for _, fields := range [][]interface{}{
+		{metadataResponse, "INBOX", []interface{}{"/shared/comment"}},
+		{metadataResponse, "INBOX", []interface{}{42, "value"}},
+		{metadataResponse, "INBOX", []interface{}{"/shared/comment", 42}},
+	} {
+		handler := &metadataResp{mailbox: "INBOX", entries: map[string]string{}}
+
+		err := handler.Handle(&imap.DataResp{Fields: fields})
+
+		assert.Error(t, err, fmt.Sprint(fields))
+	}
+}
+
+func TestBackUpMetadata(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "INBOX"}
+	require.NoError(t, os.MkdirAll(maildirPath.folderPath(), dirPerm))
+	m := &mockDownloader{}
+	m.On("getMetadata", "INBOX").Return(map[string]string{"/shared/comment": "Inbox"}, nil)
+
+	err := backUpMetadata(m, maildirPath)
+
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(maildirPath.folderPath(), metadataName))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"/shared/comment\": \"Inbox\"\n}", string(content))
+}
+
+func TestBackUpMetadataUnsupported(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "INBOX"}
+	m := &mockDownloader{}
+	m.On("getMetadata", "INBOX").Return(map[string]string(nil), nil)
+
+	err := backUpMetadata(m, maildirPath)
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(maildirPath.folderPath(), metadataName))
+}
+
+func TestBackUpMetadataRetrievalError(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "INBOX"}
+	m := &mockDownloader{}
+	m.On("getMetadata", "INBOX").Return(map[string]string(nil), fmt.Errorf("some error"))
+
+	// Failures to retrieve metadata do not prevent downloading emails.
+	err := backUpMetadata(m, maildirPath)
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(maildirPath.folderPath(), metadataName))
+}
+
+func TestBackUpMetadataWriteError(t *testing.T) {
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "missing"}
+	m := &mockDownloader{}
+	m.On("getMetadata", "missing").Return(map[string]string{}, nil)
+
+	err := backUpMetadata(m, maildirPath)
+
+	assert.Error(t, err)
+}
+
+func TestDownloadMissingEmailsToFolderMetadata(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	oldmailFileName := "some-file"
+
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42}
+
+	m := &mockDownloader{t: t}
+	m.On("getMetadata", "some-folder").Return(map[string]string{"/shared/comment": "x"}, nil)
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	opts := DownloadOptions{Metadata: true}
+	err := downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, opts)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(maildirPath.folderPath(), metadataName))
+	m.AssertExpectations(t)
+}
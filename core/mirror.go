@@ -0,0 +1,184 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// The name of the file in a maildir that maps keys of emails to the names of their files. It
+	// is written in every mode since mirror mode, pruning, manifests, and audit logs use it.
+	uidListName = "imapgrab-uidlist"
+	// The name of the maildir that emails deleted on the server are moved to in mirror mode.
+	deletedMaildir = ".deleted"
+)
+
+// Remember the file name of an email that has been delivered to a maildir. This information is
+// needed to find the files of emails that have since been deleted on the server.
+func appendUIDList(folderPath, key, fileName string) (err error) {
+	path := filepath.Join(folderPath, uidListName)
+	handle, err := openFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := handle.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	_, err = handle.Write([]byte(fmt.Sprintf("%s %s\n", key, fileName)))
+	return err
+}
+
+// Read the mapping from keys of emails to the names of their files. A missing file is no error
+// because emails delivered before its introduction are not listed.
+func readUIDList(folderPath string) (map[string]string, error) {
+	files, _, err := readUIDListLines(folderPath)
+	return files, err
+}
+
+// Read the mapping from keys of emails to the names of their files, see readUIDList, along with
+// the number of lines in the file. Later lines supersede earlier ones for the same key, e.g. for
+// emails that have been downloaded again.
+func readUIDListLines(folderPath string) (map[string]string, int, error) {
+	files := map[string]string{}
+	handle, err := os.Open(filepath.Join(folderPath, uidListName)) // nolint: gosec
+	if os.IsNotExist(err) {
+		return files, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = handle.Close() }()
+	scanner := bufio.NewScanner(handle)
+	var lines int
+	for scanner.Scan() {
+		lines++
+		if key, fileName, found := strings.Cut(scanner.Text(), " "); found {
+			files[key] = fileName
+		}
+	}
+	return files, lines, scanner.Err()
+}
+
+// Replace the list of file names of emails in a maildir. Entries are sorted by key.
+func writeUIDList(folderPath string, files map[string]string) error {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var content strings.Builder
+	for _, key := range keys {
+		content.WriteString(fmt.Sprintf("%s %s\n", key, files[key]))
+	}
+	path := filepath.Join(folderPath, uidListName)
+	tmpPath := path + ".tmp"
+	err := writeFile(tmpPath, strings.NewReader(content.String()))
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	return err
+}
+
+// Drop superseded lines from the list of file names of emails in a maildir once they make up at
+// least half of it. Since entries are only ever appended, the list would grow with every email
+// that is downloaded again otherwise.
+func compactUIDList(folderPath string) error {
+	files, lines, err := readUIDListLines(folderPath)
+	if err != nil || lines < 2*len(files) || lines == 0 {
+		return err
+	}
+	logInfo(fmt.Sprintf(
+		"compacting list of file names in %s from %d to %d lines", folderPath, lines, len(files),
+	))
+	return writeUIDList(folderPath, files)
+}
+
+// Move emails that are present locally but have been deleted on the server to a separate maildir
+// called ".deleted" within the folder's maildir. Emails are never deleted. Only emails with the
+// current UIDVALIDITY of the folder are considered. Thus, a change in UIDVALIDITY does not result
+// in all emails being considered deleted.
+func mirrorDeletions(
+	folderPath string, oldmails []oldmail, uids []uidExt, validity uidFolder,
+) error {
+	onServer := make(map[string]struct{}, len(uids))
+	for _, u := range uids {
+		onServer[u.String()] = struct{}{}
+	}
+	files, err := readUIDList(folderPath)
+	if err != nil {
+		return err
+	}
+	deletedPath := filepath.Join(folderPath, deletedMaildir)
+	moved := 0
+	for _, om := range oldmails {
+		key := om.key()
+		if _, found := onServer[key]; found || om.uidFolder != validity {
+			continue
+		}
+		fileName, known := files[key]
+		if !known {
+			logWarning(fmt.Sprintf("cannot find file of email %s deleted on server", key))
+			continue
+		}
+		wasMoved, err := moveToDeleted(folderPath, deletedPath, fileName)
+		if err != nil {
+			return err
+		}
+		if wasMoved {
+			logInfo(fmt.Sprintf("moved email %s deleted on server to %s", key, deletedPath))
+			moved++
+		}
+	}
+	if moved > 0 {
+		logWarning(fmt.Sprintf("moved %d emails deleted on server to %s", moved, deletedPath))
+	}
+	return nil
+}
+
+// Move the file of an email from a maildir to the "new" directory of the maildir for deleted
+// emails, creating it if needed. Files that have already been moved during earlier runs are
+// skipped.
+func moveToDeleted(folderPath, deletedPath, fileName string) (bool, error) {
+	target := filepath.Join(deletedPath, newMaildir, fileName)
+	if isFile(target) {
+		return false, nil
+	}
+	source := findDeliveredFile(folderPath, fileName)
+	if source == "" {
+		logWarning(fmt.Sprintf("cannot find file %s in %s", fileName, folderPath))
+		return false, nil
+	}
+	var err error
+	for _, dir := range []string{newMaildir, curMaildir, tmpMaildir} {
+		if err == nil {
+			err = os.MkdirAll(filepath.Join(deletedPath, dir), dirPerm)
+		}
+	}
+	if err == nil {
+		err = os.Rename(source, target)
+	}
+	return err == nil, err
+}
@@ -0,0 +1,172 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUIDListRoundTrip(t *testing.T) {
+	folderPath := t.TempDir()
+
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	require.NoError(t, appendUIDList(folderPath, "42/1", "file1"))
+	require.NoError(t, appendUIDList(folderPath, "42/2", "file2"))
+
+	files, err = readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"42/1": "file1", "42/2": "file2"}, files)
+}
+
+func TestCompactUIDList(t *testing.T) {
+	folderPath := t.TempDir()
+	// Without a list, there is nothing to compact.
+	assert.NoError(t, compactUIDList(folderPath))
+	assert.NoFileExists(t, filepath.Join(folderPath, uidListName))
+
+	require.NoError(t, appendUIDList(folderPath, "42/2", "file2"))
+	require.NoError(t, appendUIDList(folderPath, "42/1", "file1"))
+	require.NoError(t, appendUIDList(folderPath, "42/1", "file3"))
+	// Fewer than half of the lines are superseded.
+	assert.NoError(t, compactUIDList(folderPath))
+	_, lines, err := readUIDListLines(folderPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, lines)
+
+	require.NoError(t, appendUIDList(folderPath, "42/2", "file4"))
+	assert.NoError(t, compactUIDList(folderPath))
+	content, err := os.ReadFile(filepath.Join(folderPath, uidListName)) // nolint: gosec
+	assert.NoError(t, err)
+	assert.Equal(t, "42/1 file3\n42/2 file4\n", string(content))
+}
+
+func TestUIDListErrors(t *testing.T) {
+	folderPath := filepath.Join(t.TempDir(), "does-not-exist")
+	err := appendUIDList(folderPath, "42/1", "file1")
+	assert.Error(t, err)
+
+	// A directory in place of the file cannot be read.
+	folderPath = t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(folderPath, uidListName), dirPerm))
+	_, err = readUIDList(folderPath)
+	assert.Error(t, err)
+}
+
+func TestUIDListCloseError(t *testing.T) {
+	f, deferMe := setUpMockOldmailFile()
+	defer deferMe()
+	f.m.On("Write", []byte("42/1 file1\n")).Return(11, nil)
+	f.m.On("Close").Return(fmt.Errorf("some error"))
+
+	err := appendUIDList("some-folder", "42/1", "file1")
+
+	assert.Error(t, err)
+	f.m.AssertExpectations(t)
+}
+
+func TestMaildirStorerWriteRemembersFileName(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	require.NoError(t, err)
+
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(folderPath, "new", files["42/1"]))
+}
+
+func TestMirrorDeletions(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+	for _, key := range []string{"42/1", "42/2", "42/3", "41/1"} {
+		require.NoError(t, storer.Write(EmailInfo{Key: key}, strings.NewReader(key)))
+	}
+	files, err := readUIDList(folderPath)
+	require.NoError(t, err)
+	// Simulate that one email has been seen by a client.
+	require.NoError(t, os.Rename(
+		filepath.Join(folderPath, "new", files["42/3"]),
+		filepath.Join(folderPath, "cur", files["42/3"]),
+	))
+	oldmails := []oldmail{
+		{uidFolder: 42, uid: 1}, {uidFolder: 42, uid: 2}, {uidFolder: 42, uid: 3},
+		// Emails with a different UIDVALIDITY are never considered deleted.
+		{uidFolder: 41, uid: 1},
+		// Emails without a known file are skipped.
+		{uidFolder: 42, uid: 4},
+	}
+	// Only the email 42/1 is still on the server.
+	uids := []uidExt{{folder: 42, msg: 1}}
+
+	err = mirrorDeletions(folderPath, oldmails, uids, 42)
+	require.NoError(t, err)
+
+	deletedPath := filepath.Join(folderPath, ".deleted")
+	assert.True(t, isMaildir(deletedPath))
+	assert.FileExists(t, filepath.Join(folderPath, "new", files["42/1"]))
+	assert.FileExists(t, filepath.Join(folderPath, "new", files["41/1"]))
+	assert.FileExists(t, filepath.Join(deletedPath, "new", files["42/2"]))
+	assert.FileExists(t, filepath.Join(deletedPath, "new", files["42/3"]))
+	assert.NoFileExists(t, filepath.Join(folderPath, "new", files["42/2"]))
+	assert.NoFileExists(t, filepath.Join(folderPath, "cur", files["42/3"]))
+
+	// Running again changes nothing.
+	err = mirrorDeletions(folderPath, oldmails, uids, 42)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(deletedPath, "new", files["42/2"]))
+}
+
+func TestMirrorDeletionsMissingFile(t *testing.T) {
+	folderPath := t.TempDir()
+	require.NoError(t, appendUIDList(folderPath, "42/1", "file1"))
+
+	err := mirrorDeletions(folderPath, []oldmail{{uidFolder: 42, uid: 1}}, nil, 42)
+
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(folderPath, ".deleted"))
+}
+
+func TestMirrorDeletionsErrors(t *testing.T) {
+	folderPath := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(folderPath, uidListName), dirPerm))
+
+	err := mirrorDeletions(folderPath, nil, nil, 42)
+	assert.Error(t, err)
+
+	// The maildir for deleted emails cannot be created if a file is in the way.
+	folderPath = filepath.Join(setUpEmptyMaildir(t, "folder", "oldmail"), "folder")
+	require.NoError(t, appendUIDList(folderPath, "42/1", "file1"))
+	require.NoError(t, os.WriteFile(filepath.Join(folderPath, "new", "file1"), nil, filePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(folderPath, ".deleted"), nil, filePerm))
+
+	err = mirrorDeletions(folderPath, []oldmail{{uidFolder: 42, uid: 1}}, nil, 42)
+	assert.Error(t, err)
+}
@@ -0,0 +1,211 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// The number of emails marked as deleted before expunging a folder.
+	deleteBatchSize = 500
+	// The capability of servers that support moving emails, see RFC 6851.
+	moveCapability = "MOVE"
+	// The capability of servers that support expunging individual emails, see RFC 4315.
+	uidplusCapability = "UIDPLUS"
+)
+
+// Type uidExpungeCommand is the UID EXPUNGE command of the UIDPLUS extension. Unlike EXPUNGE, it
+// only removes the given emails even if others have been marked as deleted, e.g. by mail clients.
+type uidExpungeCommand struct {
+	seqset *imap.SeqSet
+}
+
+func (cmd *uidExpungeCommand) Command() *imap.Command {
+	return &imap.Command{
+		Name: "UID", Arguments: []interface{}{imap.RawString("EXPUNGE"), cmd.seqset},
+	}
+}
+
+// Type expunger expunges emails that have been marked as deleted from the selected folder.
+type expunger struct {
+	imapClient imapOps
+	folder     string
+	uidplus    bool
+}
+
+// Determine how to expunge emails from the selected folder. Without UIDPLUS, expunging removes all
+// emails marked as deleted. Thus, refuse to expunge anything if other emails in the folder have
+// been marked as deleted, which the user might not have downloaded.
+func newExpunger(imapClient imapOps, folder string) (expunger, error) {
+	result := expunger{imapClient: imapClient, folder: folder}
+	if supported, err := imapClient.Support(uidplusCapability); err == nil && supported {
+		result.uidplus = true
+		return result, nil
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{imap.DeletedFlag}
+	deleted, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return result, fmt.Errorf(
+			"cannot search for deleted emails in folder %s: %s", folder, err.Error(),
+		)
+	}
+	if len(deleted) > 0 {
+		return result, fmt.Errorf(
+			"not expunging folder %s since the server does not support %s and %d other emails "+
+				"have been marked as deleted", folder, uidplusCapability, len(deleted),
+		)
+	}
+	logWarning(fmt.Sprintf(
+		"server does not support %s, expunging all emails marked as deleted in folder %s",
+		uidplusCapability, folder,
+	))
+	return result, nil
+}
+
+// Expunge the given emails, which have been marked as deleted.
+func (e expunger) expunge(seqset *imap.SeqSet) error {
+	var err error
+	if e.uidplus {
+		var status *imap.StatusResp
+		status, err = e.imapClient.Execute(&uidExpungeCommand{seqset: seqset}, nil)
+		if err == nil {
+			err = status.Err()
+		}
+	} else {
+		err = e.imapClient.Expunge(nil)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot expunge folder %s: %s", e.folder, err.Error())
+	}
+	return nil
+}
+
+// Move emails of a folder to another folder on the server. This selects the folder in read-write
+// mode. Servers that do not support the MOVE extension copy the emails instead, mark them as
+// deleted, and expunge them, see newExpunger.
+func moveEmails(imapClient imapOps, folder string, uids []uid, target string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	logInfo(fmt.Sprintf("moving %d emails from folder %s to %s", len(uids), folder, target))
+	_, err := imapClient.Select(folder, false)
+	if err != nil {
+		return fmt.Errorf("cannot select folder %s for moving emails: %s", folder, err.Error())
+	}
+	seqset := new(imap.SeqSet)
+	for _, msg := range uids {
+		seqset.AddNum(uint32(msg))
+	}
+	move := imapClient.UidMove
+	if supported, supportErr := imapClient.Support(moveCapability); supportErr != nil || !supported {
+		move = func(seqset *imap.SeqSet, target string) error {
+			return copyAndDelete(imapClient, folder, seqset, target)
+		}
+	}
+	err = move(seqset, target)
+	// Servers respond with TRYCREATE if the target folder does not exist. Create it and try again.
+	if isMissingFolderError(err) {
+		logWarning(fmt.Sprintf("folder %s to move emails to does not exist", target))
+		if err = createFolder(imapClient, target); err == nil {
+			err = move(seqset, target)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot move emails to folder %s: %s", target, err.Error())
+	}
+	return nil
+}
+
+// Move emails on servers that do not support MOVE by copying them, marking them as deleted, and
+// expunging them. Nothing is copied if expunging would remove other emails.
+func copyAndDelete(imapClient imapOps, folder string, seqset *imap.SeqSet, target string) error {
+	ex, err := newExpunger(imapClient, folder)
+	if err == nil {
+		err = imapClient.UidCopy(seqset, target)
+	}
+	flags := []interface{}{imap.DeletedFlag}
+	if err == nil {
+		err = imapClient.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil)
+	}
+	if err == nil {
+		err = ex.expunge(seqset)
+	}
+	return err
+}
+
+// Delete emails of a folder from the server by marking them as deleted and expunging them, see
+// newExpunger. This selects the folder in read-write mode. Emails are deleted in batches so that a
+// failure leaves at most one batch marked as deleted without having been expunged.
+func deleteEmails(imapClient imapOps, folder string, uids []uid) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	logInfo(fmt.Sprintf("deleting %d emails from folder %s on the server", len(uids), folder))
+	_, err := imapClient.Select(folder, false)
+	if err != nil {
+		return fmt.Errorf("cannot select folder %s for deleting emails: %s", folder, err.Error())
+	}
+	ex, err := newExpunger(imapClient, folder)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(uids); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		seqset := new(imap.SeqSet)
+		for _, msg := range uids[start:end] {
+			seqset.AddNum(uint32(msg))
+		}
+		flags := []interface{}{imap.DeletedFlag}
+		// No channel is passed since the updated flags are of no interest.
+		err = imapClient.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil)
+		if err != nil {
+			return fmt.Errorf("cannot mark emails in folder %s as deleted: %s", folder, err.Error())
+		}
+		if err = ex.expunge(seqset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingStorer records the UIDs of all emails that have been written successfully. That way,
+// only emails that have been stored can be moved or deleted on the server afterwards.
+type recordingStorer struct {
+	Storer
+	uids []uid
+}
+
+func (s *recordingStorer) Write(info EmailInfo, content io.Reader) error {
+	err := s.Storer.Write(info, content)
+	var key uidExt
+	if err == nil {
+		_, err = fmt.Sscanf(info.Key, "%d/%d", &key.folder, &key.msg)
+	}
+	if err == nil {
+		s.uids = append(s.uids, key.msg)
+	}
+	return err
+}
@@ -0,0 +1,277 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMoveEmailsSuccess(t *testing.T) {
+	expectedSeqSet := &imap.SeqSet{}
+	expectedSeqSet.AddNum(3, 5)
+
+	m := setUpMockClient(t, nil, nil, nil)
+	// Moving requires a read-write selection.
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "MOVE").Return(true, nil)
+	m.On("UidMove", expectedSeqSet, "Archived").Return(nil)
+
+	err := moveEmails(m, "INBOX", []uid{3, 5}, "Archived")
+
+	assert.NoError(t, err)
+}
+
+func TestMoveEmailsNothingToMove(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+
+	err := moveEmails(m, "INBOX", nil, "Archived")
+
+	assert.NoError(t, err)
+}
+
+func TestMoveEmailsSelectError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, fmt.Errorf("read-only"))
+
+	err := moveEmails(m, "INBOX", []uid{3}, "Archived")
+
+	assert.ErrorContains(t, err, "read-only")
+}
+
+func TestMoveEmailsMoveError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "MOVE").Return(true, nil)
+	m.On("UidMove", mock.Anything, "Archived").Return(fmt.Errorf("quota exceeded"))
+
+	err := moveEmails(m, "INBOX", []uid{3}, "Archived")
+
+	assert.ErrorContains(t, err, "quota exceeded")
+}
+
+func TestMoveEmailsCreatesMissingTarget(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "MOVE").Return(true, nil)
+	m.On("UidMove", mock.Anything, "Archived").
+		Return(fmt.Errorf("Mailbox doesn't exist: Archived")).Once()
+	m.On("Create", "Archived").Return(nil)
+	m.On("UidMove", mock.Anything, "Archived").Return(nil).Once()
+
+	err := moveEmails(m, "INBOX", []uid{3}, "Archived")
+
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestMoveEmailsCreateError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "MOVE").Return(true, nil)
+	m.On("UidMove", mock.Anything, "Archived").Return(fmt.Errorf("no such folder"))
+	m.On("Create", "Archived").Return(fmt.Errorf("permission denied"))
+
+	err := moveEmails(m, "INBOX", []uid{3}, "Archived")
+
+	assert.ErrorContains(t, err, "cannot create folder Archived: permission denied")
+	m.AssertNumberOfCalls(t, "UidMove", 1)
+}
+
+func TestDeleteEmailsSuccess(t *testing.T) {
+	expectedSeqSet := &imap.SeqSet{}
+	expectedSeqSet.AddNum(3, 5)
+
+	m := setUpMockClient(t, nil, nil, nil)
+	// Deleting requires a read-write selection.
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "UIDPLUS").Return(true, nil)
+	m.On(
+		"UidStore", expectedSeqSet, imap.StoreItem("+FLAGS.SILENT"),
+		[]interface{}{imap.DeletedFlag}, (chan *imap.Message)(nil),
+	).Return(nil)
+	// Only the given emails are expunged.
+	m.On("Execute", &uidExpungeCommand{seqset: expectedSeqSet}, nil).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+
+	err := deleteEmails(m, "INBOX", []uid{3, 5})
+
+	assert.NoError(t, err)
+}
+
+func TestDeleteEmailsNothingToDelete(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+
+	err := deleteEmails(m, "INBOX", nil)
+
+	assert.NoError(t, err)
+}
+
+func TestDeleteEmailsBatches(t *testing.T) {
+	uids := make([]uid, deleteBatchSize+1)
+	for idx := range uids {
+		uids[idx] = uid(idx + 1)
+	}
+	firstBatch := &imap.SeqSet{}
+	firstBatch.AddRange(1, deleteBatchSize)
+	secondBatch := &imap.SeqSet{}
+	secondBatch.AddNum(deleteBatchSize + 1)
+
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "UIDPLUS").Return(true, nil)
+	m.On("UidStore", firstBatch, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	m.On("UidStore", secondBatch, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	m.On("Execute", &uidExpungeCommand{seqset: firstBatch}, nil).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil).Once()
+	m.On("Execute", &uidExpungeCommand{seqset: secondBatch}, nil).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil).Once()
+
+	err := deleteEmails(m, "INBOX", uids)
+
+	assert.NoError(t, err)
+}
+
+func TestDeleteEmailsSelectError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, fmt.Errorf("read-only"))
+
+	err := deleteEmails(m, "INBOX", []uid{3})
+
+	assert.ErrorContains(t, err, "read-only")
+}
+
+func TestDeleteEmailsStoreError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "UIDPLUS").Return(true, nil)
+	m.On("UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(fmt.Errorf("permission denied"))
+
+	err := deleteEmails(m, "INBOX", []uid{3})
+
+	assert.ErrorContains(t, err, "cannot mark emails in folder INBOX as deleted")
+	// Nothing is expunged unless the emails have been marked.
+	m.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestDeleteEmailsExpungeError(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "UIDPLUS").Return(true, nil)
+	m.On("UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	m.On("Execute", mock.Anything, nil).Return(&imap.StatusResp{}, fmt.Errorf("connection lost"))
+
+	err := deleteEmails(m, "INBOX", []uid{3})
+
+	assert.ErrorContains(t, err, "cannot expunge folder INBOX: connection lost")
+}
+
+func TestDeleteEmailsWithoutUIDPlus(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "UIDPLUS").Return(false, nil)
+	m.On("UidSearch", mock.Anything).Return([]uint32{}, nil)
+	m.On("UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	m.On("Expunge", (chan uint32)(nil)).Return(nil)
+
+	err := deleteEmails(m, "INBOX", []uid{3})
+
+	assert.NoError(t, err)
+}
+
+func TestDeleteEmailsWithoutUIDPlusOthersDeleted(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "UIDPLUS").Return(false, nil)
+	m.On("UidSearch", mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+		return len(criteria.WithFlags) == 1 && criteria.WithFlags[0] == imap.DeletedFlag
+	})).Return([]uint32{7, 8}, nil)
+
+	err := deleteEmails(m, "INBOX", []uid{3})
+
+	assert.ErrorContains(t, err, "2 other emails have been marked as deleted")
+	// Nothing is marked or expunged if other emails would be expunged, too.
+	m.AssertNotCalled(t, "UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	m.AssertNotCalled(t, "Expunge", mock.Anything)
+}
+
+func TestMoveEmailsWithoutMove(t *testing.T) {
+	expectedSeqSet := &imap.SeqSet{}
+	expectedSeqSet.AddNum(3)
+
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "MOVE").Return(false, nil)
+	m.On("Support", "UIDPLUS").Return(true, nil)
+	m.On("UidCopy", expectedSeqSet, "Archived").Return(nil)
+	m.On(
+		"UidStore", expectedSeqSet, imap.StoreItem("+FLAGS.SILENT"),
+		[]interface{}{imap.DeletedFlag}, (chan *imap.Message)(nil),
+	).Return(nil)
+	m.On("Execute", &uidExpungeCommand{seqset: expectedSeqSet}, nil).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+
+	err := moveEmails(m, "INBOX", []uid{3}, "Archived")
+
+	assert.NoError(t, err)
+	m.AssertNotCalled(t, "UidMove", mock.Anything, mock.Anything)
+}
+
+func TestMoveEmailsWithoutMoveOthersDeleted(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+	m.On("Support", "MOVE").Return(false, nil)
+	m.On("Support", "UIDPLUS").Return(false, nil)
+	m.On("UidSearch", mock.Anything).Return([]uint32{7}, nil)
+
+	err := moveEmails(m, "INBOX", []uid{3}, "Archived")
+
+	assert.ErrorContains(t, err, "1 other emails have been marked as deleted")
+	m.AssertNotCalled(t, "UidCopy", mock.Anything, mock.Anything)
+}
+
+func TestUIDExpungeCommand(t *testing.T) {
+	seqset := &imap.SeqSet{}
+	seqset.AddRange(3, 5)
+
+	cmd := (&uidExpungeCommand{seqset: seqset}).Command()
+
+	assert.Equal(t, "UID", cmd.Name)
+	assert.Equal(t, []interface{}{imap.RawString("EXPUNGE"), seqset}, cmd.Arguments)
+}
+
+func TestRecordingStorerRecordsOnlyStoredEmails(t *testing.T) {
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, "content").Return(nil)
+	ms.On("Write", EmailInfo{Key: "42/2"}, "content").Return(fmt.Errorf("some error"))
+	ms.On("Write", EmailInfo{Key: "42/3"}, "content").Return(nil)
+	storer := &recordingStorer{Storer: ms}
+
+	for _, key := range []string{"42/1", "42/2", "42/3"} {
+		_ = storer.Write(EmailInfo{Key: key}, strings.NewReader("content"))
+	}
+
+	assert.Equal(t, []uid{1, 3}, storer.uids)
+	ms.AssertExpectations(t)
+}
@@ -0,0 +1,113 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The number of emails buffered for each additional storer before writing blocks.
+const additionalStorerBuffer = 20
+
+// Type bufferedEmail is an email whose content has been read completely.
+type bufferedEmail struct {
+	info    EmailInfo
+	content []byte
+}
+
+// Type asyncStorer writes emails to a storer in the background.
+type asyncStorer struct {
+	storer   Storer
+	emails   chan bufferedEmail
+	done     chan struct{}
+	errCount int
+}
+
+func newAsyncStorer(storer Storer) *asyncStorer {
+	async := &asyncStorer{
+		storer: storer,
+		emails: make(chan bufferedEmail, additionalStorerBuffer),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(async.done)
+		for email := range async.emails {
+			if err := storer.Write(email.info, bytes.NewReader(email.content)); err != nil {
+				logError(fmt.Sprintf(
+					"cannot write email %s to additional storage: %s", email.info.Key, err.Error(),
+				))
+				async.errCount++
+			}
+		}
+	}()
+	return async
+}
+
+// Type multiStorer writes each email to a primary storer and to any number of additional ones.
+// Thus, emails retrieved once can be stored in several formats. The primary storer alone determines
+// which emails exist and an email is only handed to the additional storers once the primary one has
+// stored it. Additional storers write in the background, each with a bounded buffer, such that a
+// slow one does not block the others until its buffer is full. Errors of additional storers are
+// logged and reported when closing.
+type multiStorer struct {
+	Storer
+	additional []*asyncStorer
+}
+
+func newMultiStorer(primary Storer, additional []Storer) *multiStorer {
+	multi := &multiStorer{Storer: primary}
+	for _, storer := range additional {
+		multi.additional = append(multi.additional, newAsyncStorer(storer))
+	}
+	return multi
+}
+
+// Write stores an email with the primary storer and hands it to all additional ones.
+func (s *multiStorer) Write(info EmailInfo, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err == nil {
+		err = s.Storer.Write(info, bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	for _, async := range s.additional {
+		async.emails <- bufferedEmail{info: info, content: data}
+	}
+	return nil
+}
+
+// Close waits for all additional storers to finish writing and closes all storers.
+func (s *multiStorer) Close() error {
+	errs := []error{}
+	for _, async := range s.additional {
+		close(async.emails)
+		<-async.done
+		if async.errCount > 0 {
+			errs = append(errs, fmt.Errorf(
+				"there were %d errors writing emails to additional storage", async.errCount,
+			))
+		}
+		errs = append(errs, closeStorer(async.storer))
+	}
+	errs = append(errs, closeStorer(s.Storer))
+	return errors.Join(errs...)
+}
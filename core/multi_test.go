@@ -0,0 +1,82 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiStorerWritesToAll(t *testing.T) {
+	info := EmailInfo{Key: "42/1"}
+	primary := &mockStorer{}
+	primary.On("Write", info, "some content").Return(nil)
+	primary.On("Exists", "42/1").Return(true, nil)
+	additional := &closingMockStorer{}
+	additional.On("Write", info, "some content").Return(nil)
+	additional.On("Close").Return(nil)
+
+	storer := newMultiStorer(primary, []Storer{additional})
+	err := storer.Write(info, strings.NewReader("some content"))
+	assert.NoError(t, err)
+	found, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	err = storer.Close()
+
+	assert.NoError(t, err)
+	primary.AssertExpectations(t)
+	additional.AssertExpectations(t)
+}
+
+func TestMultiStorerPrimaryError(t *testing.T) {
+	info := EmailInfo{Key: "42/1"}
+	primary := &mockStorer{}
+	primary.On("Write", info, "some content").Return(fmt.Errorf("some error"))
+	additional := &mockStorer{}
+
+	storer := newMultiStorer(primary, []Storer{additional})
+	err := storer.Write(info, strings.NewReader("some content"))
+	closeErr := storer.Close()
+
+	// Emails that could not be stored by the primary storer are not handed on.
+	assert.ErrorContains(t, err, "some error")
+	assert.NoError(t, closeErr)
+	additional.AssertExpectations(t)
+}
+
+func TestMultiStorerAdditionalError(t *testing.T) {
+	info := EmailInfo{Key: "42/1"}
+	primary := &closingMockStorer{}
+	primary.On("Write", info, "some content").Return(nil)
+	primary.On("Close").Return(nil)
+	additional := &mockStorer{}
+	additional.On("Write", info, "some content").Return(fmt.Errorf("some error"))
+
+	storer := newMultiStorer(primary, []Storer{additional})
+	err := storer.Write(info, strings.NewReader("some content"))
+	assert.NoError(t, err)
+	err = storer.Close()
+
+	assert.ErrorContains(t, err, "there were 1 errors writing emails to additional storage")
+	primary.AssertExpectations(t)
+	additional.AssertExpectations(t)
+}
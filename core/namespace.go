@@ -0,0 +1,225 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+const (
+	// The capability of servers that report the prefixes of folders via NAMESPACE, see RFC 2342.
+	namespaceCapability = "NAMESPACE"
+	namespaceCommand    = "NAMESPACE"
+)
+
+// The kinds of namespaces a server can report, in the order in which it reports them.
+const (
+	// NamespacePersonal is the kind of namespace containing the user's own folders.
+	NamespacePersonal = "personal"
+	// NamespaceOther is the kind of namespace containing the folders of other users.
+	NamespaceOther = "other"
+	// NamespaceShared is the kind of namespace containing folders shared between users.
+	NamespaceShared = "shared"
+)
+
+// Namespace describes a prefix below which a server stores folders of a certain kind.
+type Namespace struct {
+	Prefix    string `json:"prefix"`
+	Delimiter string `json:"delimiter"`
+}
+
+// Namespaces contains all namespaces a server reports, grouped by their kind.
+type Namespaces struct {
+	Personal []Namespace `json:"personal"`
+	Other    []Namespace `json:"other"`
+	Shared   []Namespace `json:"shared"`
+}
+
+// Determine the kind of namespace a folder belongs to. The longest matching prefix wins since
+// personal namespaces often use an empty prefix. This is empty if no namespace matches.
+func (n Namespaces) kindOf(folder string) string {
+	kind, longest := "", -1
+	for _, group := range []struct {
+		kind       string
+		namespaces []Namespace
+	}{
+		{NamespacePersonal, n.Personal}, {NamespaceOther, n.Other}, {NamespaceShared, n.Shared},
+	} {
+		for _, namespace := range group.namespaces {
+			prefix := namespace.Prefix
+			matches := strings.HasPrefix(folder, prefix) ||
+				folder == strings.TrimSuffix(prefix, namespace.Delimiter)
+			if matches && len(prefix) > longest {
+				kind, longest = group.kind, len(prefix)
+			}
+		}
+	}
+	return kind
+}
+
+// The prefixes of those namespaces whose folders are not reported when listing all folders on
+// some servers, i.e. the non-empty prefixes of other users' and shared namespaces.
+func (n Namespaces) foreignPrefixes() (prefixes []string) {
+	for _, namespace := range append(append([]Namespace{}, n.Other...), n.Shared...) {
+		if namespace.Prefix != "" {
+			prefixes = append(prefixes, namespace.Prefix)
+		}
+	}
+	return prefixes
+}
+
+// Type namespaceCmd is the command that asks the server for its namespaces.
+type namespaceCmd struct{}
+
+func (cmd *namespaceCmd) Command() *imap.Command {
+	return &imap.Command{Name: namespaceCommand}
+}
+
+// Type namespaceResp handles the untagged NAMESPACE response, which contains one list of
+// namespaces per kind, each of which is NIL if there are no namespaces of that kind.
+type namespaceResp struct {
+	namespaces Namespaces
+}
+
+func (r *namespaceResp) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != namespaceCommand {
+		return responses.ErrUnhandled
+	}
+	if len(fields) != 3 { // nolint: gomnd
+		return fmt.Errorf("cannot parse namespace response with %d fields", len(fields))
+	}
+	groups := []*[]Namespace{&r.namespaces.Personal, &r.namespaces.Other, &r.namespaces.Shared}
+	for idx, group := range groups {
+		namespaces, err := parseNamespaces(fields[idx])
+		if err != nil {
+			return err
+		}
+		*group = namespaces
+	}
+	return nil
+}
+
+// Parse a list of namespaces, each of which consists of a prefix and a delimiter followed by
+// optional extensions that are ignored. A missing delimiter is reported as NIL.
+func parseNamespaces(field interface{}) ([]Namespace, error) {
+	if field == nil {
+		return nil, nil
+	}
+	list, ok := field.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot parse namespace list %v", field)
+	}
+	namespaces := make([]Namespace, 0, len(list))
+	for _, entry := range list {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 2 { // nolint: gomnd
+			return nil, fmt.Errorf("cannot parse namespace %v", entry)
+		}
+		prefix, err := imap.ParseString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse namespace prefix: %s", err.Error())
+		}
+		namespace := Namespace{Prefix: prefix}
+		if fields[1] != nil {
+			if namespace.Delimiter, err = imap.ParseString(fields[1]); err != nil {
+				return nil, fmt.Errorf("cannot parse namespace delimiter: %s", err.Error())
+			}
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
+}
+
+// Retrieve the namespaces of the server. The library does not implement NAMESPACE, which is why
+// the command is issued directly. If the server does not support it, no namespaces are reported.
+func getNamespaces(imapClient imapOps) (Namespaces, error) {
+	supported, err := imapClient.Support(namespaceCapability)
+	if err != nil || !supported {
+		logInfo("server does not support namespaces")
+		return Namespaces{}, err
+	}
+	logInfo("retrieving namespaces")
+	handler := &namespaceResp{}
+	status, err := imapClient.Execute(&namespaceCmd{}, handler)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return Namespaces{}, fmt.Errorf("cannot retrieve namespaces: %s", err.Error())
+	}
+	return handler.namespaces, nil
+}
+
+// Retrieve all folders including those in other users' and shared namespaces. Some servers, e.g.
+// Exchange-style ones, do not report the latter when listing all folders. Folders are reported
+// only once even if several listings contain them.
+func listFoldersWithNamespaces(
+	imapClient imapOps, bufferSize int, subscribedOnly bool,
+) ([]FolderInfo, error) {
+	folders, err := listFolders(imapClient, bufferSize, subscribedOnly)
+	if err != nil {
+		return folders, err
+	}
+	namespaces, err := getNamespaces(imapClient)
+	if err != nil {
+		return folders, err
+	}
+	known := map[string]bool{}
+	for _, folder := range folders {
+		known[folder.Name] = true
+	}
+	for _, prefix := range namespaces.foreignPrefixes() {
+		logInfo(fmt.Sprintf("retrieving folders in namespace %s", prefix))
+		found, listErr := listFoldersMatching(
+			imapClient, bufferSize, subscribedOnly, prefix+"*",
+		)
+		if listErr != nil {
+			return folders, listErr
+		}
+		for _, folder := range found {
+			if !known[folder.Name] {
+				known[folder.Name] = true
+				folders = append(folders, folder)
+			}
+		}
+	}
+	return folders, nil
+}
+
+// GetNamespaces retrieves the personal, other users', and shared namespaces of the server after
+// logging in. No namespaces are reported if the server does not support NAMESPACE.
+func GetNamespaces(cfg IMAPConfig) (namespaces Namespaces, err error) {
+	ops := NewImapgrabOps()
+	err = ops.authenticateClient(cfg)
+	if err == nil {
+		// Make sure to log out in the end if we logged in successfully.
+		defer func() {
+			// Don't overwrite the error if it has already been set.
+			if logoutErr := ops.logout(false); logoutErr != nil && err == nil {
+				err = logoutErr
+			}
+		}()
+		namespaces, err = ops.getNamespaces()
+	}
+	return namespaces, err
+}
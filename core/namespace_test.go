@@ -0,0 +1,224 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Set up the mock client to report the given namespace response fields when asked for namespaces.
+func expectNamespaces(m *mockClient, fields ...interface{}) {
+	m.On("Support", namespaceCapability).Return(true, nil)
+	m.On("Execute", &namespaceCmd{}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			handler := args.Get(1).(responses.Handler)
+			resp := &imap.DataResp{Fields: append([]interface{}{namespaceCommand}, fields...)}
+			_ = handler.Handle(resp)
+		}).
+		Return(&imap.StatusResp{Type: imap.StatusRespOk}, nil)
+}
+
+func TestGetNamespacesUnsupported(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", namespaceCapability).Return(false, nil)
+
+	namespaces, err := getNamespaces(m)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Namespaces{}, namespaces)
+	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestGetNamespacesSuccess(t *testing.T) {
+	m := &mockClient{}
+	expectNamespaces(
+		m,
+		[]interface{}{[]interface{}{"", "/"}},
+		nil,
+		[]interface{}{
+			[]interface{}{"Shared/", "/", "X-EXTENSION", []interface{}{"value"}},
+			[]interface{}{"Public", nil},
+		},
+	)
+
+	namespaces, err := getNamespaces(m)
+
+	assert.NoError(t, err)
+	expected := Namespaces{
+		Personal: []Namespace{{Prefix: "", Delimiter: "/"}},
+		Shared:   []Namespace{{Prefix: "Shared/", Delimiter: "/"}, {Prefix: "Public"}},
+	}
+	assert.Equal(t, expected, namespaces)
+	m.AssertExpectations(t)
+}
+
+func TestGetNamespacesRejected(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", namespaceCapability).Return(true, nil)
+	m.On("Execute", &namespaceCmd{}, mock.Anything).
+		Return(&imap.StatusResp{Type: imap.StatusRespNo, Info: "not now"}, nil)
+
+	_, err := getNamespaces(m)
+
+	assert.ErrorContains(t, err, "cannot retrieve namespaces: not now")
+	m.AssertExpectations(t)
+}
+
+func TestNamespaceRespUnhandled(t *testing.T) {
+	handler := &namespaceResp{}
+
+	err := handler.Handle(&imap.DataResp{Fields: []interface{}{"CAPABILITY", "IMAP4rev1"}})
+
+	assert.Equal(t, responses.ErrUnhandled, err)
+}
+
+func TestNamespaceRespMalformed(t *testing.T) {
+	for _, fields := range [][]interface{}{
+		{namespaceCommand, nil, nil},
+		{namespaceCommand, "not a list", nil, nil},
+		{namespaceCommand, []interface{}{"not a namespace"}, nil, nil},
+		{namespaceCommand, []interface{}{[]interface{}{"only prefix"}}, nil, nil},
+		{namespaceCommand, []interface{}{[]interface{}{42, "/"}}, nil, nil},
+		{namespaceCommand, []interface{}{[]interface{}{"", 42}}, nil, nil},
+	} {
+		handler := &namespaceResp{}
+
+		err := handler.Handle(&imap.DataResp{Fields: fields})
+
+		assert.Error(t, err, fmt.Sprint(fields))
+	}
+}
+
+func TestNamespacesKindOf(t *testing.T) {
+	namespaces := Namespaces{
+		Personal: []Namespace{{Prefix: "", Delimiter: "/"}},
+		Other:    []Namespace{{Prefix: "Other Users/", Delimiter: "/"}},
+		Shared:   []Namespace{{Prefix: "Shared/", Delimiter: "/"}},
+	}
+
+	assert.Equal(t, NamespacePersonal, namespaces.kindOf("INBOX"))
+	assert.Equal(t, NamespaceOther, namespaces.kindOf("Other Users/bob/INBOX"))
+	assert.Equal(t, NamespaceShared, namespaces.kindOf("Shared/support"))
+	assert.Equal(t, NamespaceShared, namespaces.kindOf("Shared"))
+	assert.Equal(t, "", Namespaces{}.kindOf("INBOX"))
+}
+
+func TestListFoldersWithNamespaces(t *testing.T) {
+	m := setUpMockClient(t, []*imap.MailboxInfo{{Name: "INBOX"}, {Name: "Shared/sales"}}, nil, nil)
+	m.On("List", "", "*", mock.Anything).Return(nil).Once()
+	expectNamespaces(
+		m,
+		[]interface{}{[]interface{}{"", "/"}},
+		nil,
+		[]interface{}{[]interface{}{"Shared/", "/"}},
+	)
+	m.On("List", "", "Shared/*", mock.Anything).
+		Run(func(_ mock.Arguments) {
+			m.mailboxes = []*imap.MailboxInfo{{Name: "Shared/sales"}, {Name: "Shared/support"}}
+		}).
+		Return(nil).Once()
+
+	folders, err := listFoldersWithNamespaces(m, defaultFolderListBuffer, false)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]FolderInfo{{Name: "INBOX"}, {Name: "Shared/sales"}, {Name: "Shared/support"}},
+		folders,
+	)
+	m.AssertExpectations(t)
+}
+
+func TestListFoldersWithNamespacesErrors(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("List", "", "*", mock.Anything).Return(fmt.Errorf("list error")).Once()
+
+	_, err := getFolderList(m, defaultFolderListBuffer, false, true)
+
+	assert.ErrorContains(t, err, "list error")
+
+	m = setUpMockClient(t, nil, nil, nil)
+	m.On("List", "", "*", mock.Anything).Return(nil).Once()
+	m.On("Support", namespaceCapability).Return(false, fmt.Errorf("support error"))
+
+	_, err = getFolderList(m, defaultFolderListBuffer, false, true)
+
+	assert.ErrorContains(t, err, "support error")
+
+	m = setUpMockClient(t, nil, nil, nil)
+	m.On("List", "", "*", mock.Anything).Return(nil).Once()
+	expectNamespaces(m, nil, []interface{}{[]interface{}{"Other/", "/"}}, nil)
+	m.On("List", "", "Other/*", mock.Anything).Return(fmt.Errorf("other error")).Once()
+
+	_, err = getFolderList(m, defaultFolderListBuffer, false, true)
+
+	assert.ErrorContains(t, err, "other error")
+}
+
+func TestGetNamespaces(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	namespaces := Namespaces{Shared: []Namespace{{Prefix: "Shared/", Delimiter: "/"}}}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getNamespaces").Return(namespaces, nil)
+	mock.On("logout", false).Return(nil)
+
+	setUpCoreTest(t, mock)
+
+	actual, err := GetNamespaces(cfg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, namespaces, actual)
+	mock.AssertExpectations(t)
+}
+
+func TestGetFolderSummariesNamespaces(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	namespaces := Namespaces{
+		Personal: []Namespace{{Prefix: "", Delimiter: "/"}},
+		Shared:   []Namespace{{Prefix: "Shared/", Delimiter: "/"}},
+	}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderList").Return([]string{"INBOX", "Shared/sales"}, nil)
+	mock.On("getNamespaces").Return(namespaces, nil)
+	mock.On("logout", false).Return(nil)
+	mock.On("getFolderSummary", "INBOX").Return(FolderSummary{Name: "INBOX"}, nil)
+	mock.On("getFolderSummary", "Shared/sales").Return(FolderSummary{Name: "Shared/sales"}, nil)
+
+	setUpCoreTest(t, mock)
+
+	summaries, err := GetFolderSummaries(cfg, 1)
+
+	assert.NoError(t, err)
+	expected := []FolderSummary{
+		{Name: "INBOX", Namespace: NamespacePersonal},
+		{Name: "Shared/sales", Namespace: NamespaceShared},
+	}
+	assert.Equal(t, expected, summaries)
+	mock.AssertExpectations(t)
+}
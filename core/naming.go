@@ -0,0 +1,197 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// The maximum length in bytes of each field of a templated file name and of the name itself. Most
+// filesystems limit names to 255 bytes, which leaves room for counters and maildir flags.
+const (
+	maxFileNameFieldLength = 100
+	maxFileNameLength      = 200
+)
+
+// FileNameFields are the fields available in templates for names of files, see
+// DownloadOptions.FileNameTemplate. Text taken from headers is decoded and sanitised so that it
+// can be used in file names. Fields of headers that are missing are empty.
+type FileNameFields struct {
+	// UID is the UID of the email.
+	UID uint32
+	// UIDValidity is the UIDVALIDITY of the email's folder.
+	UIDValidity uint32
+	// Date is the date at which the server received the email, in UTC. Use its methods for
+	// formatting, e.g. {{.Date.Format "2006-01-02"}}.
+	Date time.Time
+	// Subject is the subject of the email.
+	Subject string
+	// From is the address of the sender of the email without the display name.
+	From string
+	// MessageID is the Message-ID of the email without angle brackets.
+	MessageID string
+}
+
+// Parse a template for names of files. The template is executed once with example fields so that
+// references to unknown fields are reported right away instead of for each email.
+func parseFileNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("file name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse file name template: %s", err.Error())
+	}
+	example := FileNameFields{
+		UID: 1, UIDValidity: 1, Date: time.Unix(0, 0).UTC(), Subject: "subject",
+		From: "sender@example.com", MessageID: "id@example.com",
+	}
+	name, err := executeFileNameTemplate(tmpl, example)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("file name template results in empty names")
+	}
+	return tmpl, nil
+}
+
+// Execute a template for names of files. The result is sanitised as well since the template itself
+// might contain characters that are not allowed in names of files in maildirs.
+func executeFileNameTemplate(tmpl *template.Template, fields FileNameFields) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fields); err != nil {
+		return "", fmt.Errorf("cannot execute file name template: %s", err.Error())
+	}
+	return sanitiseFileName(buf.String(), maxFileNameLength), nil
+}
+
+// Replace characters that have a special meaning in paths or maildirs, i.e. path separators and
+// the colon that separates maildir flags, as well as control characters by underscores. Leading
+// dots are removed to avoid hidden files and the result is limited to the given number of bytes
+// without splitting characters.
+func sanitiseFileName(text string, maxLength int) string {
+	sanitised := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case r == '/' || r == '\\' || r == ':' || unicode.IsControl(r):
+			return '_'
+		default:
+			return r
+		}
+	}, text)
+	sanitised = strings.TrimLeft(strings.TrimSpace(sanitised), ".")
+	for len(sanitised) > maxLength {
+		runes := []rune(sanitised)
+		sanitised = string(runes[:len(runes)-1])
+	}
+	return strings.TrimSpace(sanitised)
+}
+
+// Determine the fields of an email for templated file names. The header is read from the content,
+// which is why the content has to be read from the returned reader afterwards. If the header cannot
+// be parsed, the fields taken from it are empty.
+func readFileNameFields(info EmailInfo, content io.Reader) (FileNameFields, io.Reader) {
+	fields := FileNameFields{Date: info.InternalDate.UTC()}
+	if _, err := fmt.Sscanf(info.Key, "%d/%d", &fields.UIDValidity, &fields.UID); err != nil {
+		logWarning(fmt.Sprintf("cannot determine UID of email %s: %s", info.Key, err.Error()))
+	}
+	// Everything read from the content while parsing the header is kept so that it can be
+	// prepended to the remaining content.
+	var consumed bytes.Buffer
+	header, err := textproto.ReadHeader(bufio.NewReader(io.TeeReader(content, &consumed)))
+	content = io.MultiReader(&consumed, content)
+	if err != nil {
+		logWarning(fmt.Sprintf("cannot read header of email %s: %s", info.Key, err.Error()))
+		return fields, content
+	}
+
+	decoder := mime.WordDecoder{}
+	subject, err := decoder.DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		subject = header.Get("Subject")
+	}
+	fields.Subject = sanitiseFileName(subject, maxFileNameFieldLength)
+	from := header.Get("From")
+	if address, err := mail.ParseAddress(from); err == nil {
+		from = address.Address
+	}
+	fields.From = sanitiseFileName(from, maxFileNameFieldLength)
+	messageID := strings.Trim(strings.TrimSpace(header.Get("Message-Id")), "<>")
+	fields.MessageID = sanitiseFileName(messageID, maxFileNameFieldLength)
+	return fields, content
+}
+
+// Resolve collisions of a templated file name with files already present in a maildir by
+// appending the first counter that results in a new name, e.g. "name-1". Files in cur might carry
+// maildir flags, e.g. "name:2,S", and files might carry extension fields, e.g. "name,U=42".
+func uniqueTemplatedName(maildir, name string) (string, error) {
+	candidate := name
+	for counter := 1; ; counter++ {
+		taken, err := fileNameTaken(maildir, candidate)
+		if err != nil || !taken {
+			return candidate, err
+		}
+		candidate = fmt.Sprintf("%s-%d", name, counter)
+	}
+}
+
+func fileNameTaken(maildir, name string) (bool, error) {
+	for _, dir := range []string{newMaildir, curMaildir, tmpMaildir} {
+		if _, err := os.Lstat(filepath.Join(maildir, dir, name)); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	matches, err := filepath.Glob(filepath.Join(maildir, curMaildir, globEscape(name)+":*"))
+	if err != nil || len(matches) > 0 {
+		return len(matches) > 0, err
+	}
+	// Names might carry extension fields, e.g. "name,U=42", see DownloadOptions.FileNameMetadata.
+	for _, dir := range []string{newMaildir, curMaildir} {
+		matches, err = filepath.Glob(filepath.Join(maildir, dir, globEscape(name)+",*"))
+		if err != nil || len(matches) > 0 {
+			return len(matches) > 0, err
+		}
+	}
+	return false, nil
+}
+
+// Escape characters with a special meaning in patterns for filepath.Glob.
+func globEscape(name string) string {
+	var builder strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
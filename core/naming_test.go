@@ -0,0 +1,122 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const templatedEmail = "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe/Hello?=\r\n" +
+	"From: Some One <some.one@example.com>\r\n" +
+	"Message-Id: <abc:123@example.com>\r\n" +
+	"\r\n" +
+	"body\r\n"
+
+func TestParseFileNameTemplate(t *testing.T) {
+	_, err := parseFileNameTemplate("{{.UID}} {{.Subject}}")
+	assert.NoError(t, err)
+
+	_, err = parseFileNameTemplate("{{.UID")
+	assert.ErrorContains(t, err, "cannot parse")
+	_, err = parseFileNameTemplate("{{.Unknown}}")
+	assert.ErrorContains(t, err, "cannot execute")
+	_, err = parseFileNameTemplate("{{if false}}name{{end}}")
+	assert.ErrorContains(t, err, "empty names")
+}
+
+func TestSanitiseFileName(t *testing.T) {
+	assert.Equal(t, "a_b_c_d", sanitiseFileName("a/b\\c:d", 100))
+	assert.Equal(t, "hidden", sanitiseFileName("..hidden", 100))
+	assert.Equal(t, "new line_", sanitiseFileName(" new\tline\x00", 100))
+	// Multi-byte characters are not split.
+	assert.Equal(t, "ä", sanitiseFileName("ää", 3))
+}
+
+func TestReadFileNameFields(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	info := EmailInfo{Key: "42/7", InternalDate: date}
+
+	fields, content := readFileNameFields(info, strings.NewReader(templatedEmail))
+
+	assert.Equal(t, FileNameFields{
+		UID: 7, UIDValidity: 42, Date: date, Subject: "Grüße_Hello",
+		From: "some.one@example.com", MessageID: "abc_123@example.com",
+	}, fields)
+	// The content is provided in full.
+	data, err := io.ReadAll(content)
+	assert.NoError(t, err)
+	assert.Equal(t, templatedEmail, string(data))
+}
+
+func TestReadFileNameFieldsMalformedHeader(t *testing.T) {
+	info := EmailInfo{Key: "42/7"}
+
+	fields, content := readFileNameFields(info, strings.NewReader("no header"))
+
+	assert.Equal(t, uint32(7), fields.UID)
+	assert.Empty(t, fields.Subject)
+	data, err := io.ReadAll(content)
+	assert.NoError(t, err)
+	assert.Equal(t, "no header", string(data))
+}
+
+func TestUniqueTemplatedName(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildir := filepath.Join(tmpdir, "folder")
+
+	name, err := uniqueTemplatedName(maildir, "name*")
+	assert.NoError(t, err)
+	assert.Equal(t, "name*", name)
+
+	for _, path := range []string{"new/name*", "cur/name*-1:2,S", "new/name*-2,U=42"} {
+		err := os.WriteFile(filepath.Join(maildir, path), nil, filePerm)
+		require.NoError(t, err)
+	}
+	name, err = uniqueTemplatedName(maildir, "name*")
+	assert.NoError(t, err)
+	assert.Equal(t, "name*-3", name)
+}
+
+func TestMaildirStorerWriteTemplate(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	storer := newMaildirStorer(filepath.Join(tmpdir, "folder"), nil)
+	tmpl, err := parseFileNameTemplate(`{{.Date.Format "2006-01-02"}} {{.Subject}}.eml`)
+	require.NoError(t, err)
+	storer.nameTemplate = tmpl
+	info := EmailInfo{Key: "42/1", InternalDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	for _, key := range []string{"42/1", "42/2"} {
+		info.Key = key
+		err = storer.Write(info, strings.NewReader(templatedEmail))
+		assert.NoError(t, err)
+	}
+
+	for _, name := range []string{"2024-01-02 Grüße_Hello.eml", "2024-01-02 Grüße_Hello.eml-1"} {
+		content, err := os.ReadFile(filepath.Join(tmpdir, "folder", "new", name)) // nolint: gosec
+		assert.NoError(t, err)
+		assert.Equal(t, templatedEmail, string(content))
+	}
+}
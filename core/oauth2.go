@@ -0,0 +1,153 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/emersion/go-sasl"
+	"golang.org/x/oauth2"
+)
+
+const xoauth2Mechanism = "XOAUTH2"
+
+// errTokenRejected is returned when the server rejects an access token, e.g. because it has been
+// revoked before it expired.
+var errTokenRejected = errors.New("access token rejected")
+
+// OAuth2Config configures authentication via XOAUTH2 using the refresh-token flow. If a refresh
+// token is set, access tokens are obtained from the token endpoint and the password is not used.
+// Access tokens are reused for all logins with the same config until shortly before they expire.
+type OAuth2Config struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	// TokenURL is the token endpoint of the provider, e.g. "https://oauth2.googleapis.com/token".
+	TokenURL string
+}
+
+func (c OAuth2Config) enabled() bool {
+	return c.RefreshToken != ""
+}
+
+// The token sources of all configs used so far, which are shared by all connections.
+var oauth2Sources = struct {
+	sources map[OAuth2Config]*oauth2Source
+	sync.Mutex
+}{sources: map[OAuth2Config]*oauth2Source{}}
+
+// Type oauth2Source hands out access tokens for a config. The token source of the oauth2 package is
+// a ReuseTokenSource, i.e. it keeps handing out the same access token and obtains a new one shortly
+// before that one expires.
+type oauth2Source struct {
+	config oauth2.Config
+	// The latest refresh token, which the provider might have replaced by a new one.
+	refreshToken string
+	source       oauth2.TokenSource
+	sync.Mutex
+}
+
+// Get the token source of a config, creating it if needed.
+func (c OAuth2Config) source() *oauth2Source {
+	oauth2Sources.Lock()
+	defer oauth2Sources.Unlock()
+	source, found := oauth2Sources.sources[c]
+	if !found {
+		source = &oauth2Source{
+			config: oauth2.Config{
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				Endpoint:     oauth2.Endpoint{TokenURL: c.TokenURL},
+			},
+			refreshToken: c.RefreshToken,
+		}
+		oauth2Sources.sources[c] = source
+	}
+	return source
+}
+
+// Get a valid access token, obtaining a new one via the refresh-token flow if needed. Tokens must
+// never be logged.
+func (s *oauth2Source) accessToken() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.source == nil {
+		logInfo("obtaining new OAuth2 access token")
+		s.source = s.config.TokenSource(
+			context.Background(), &oauth2.Token{RefreshToken: s.refreshToken},
+		)
+	}
+	token, err := s.source.Token()
+	if err != nil {
+		return "", fmt.Errorf("cannot obtain OAuth2 access token: %s", err.Error())
+	}
+	if token.RefreshToken != "" {
+		s.refreshToken = token.RefreshToken
+	}
+	return token.AccessToken, nil
+}
+
+// Discard the current access token so that a new one is obtained next time, even if the current one
+// has not expired yet.
+func (s *oauth2Source) refresh() {
+	s.Lock()
+	defer s.Unlock()
+	s.source = nil
+}
+
+// Type xoauth2Client implements the XOAUTH2 SASL mechanism as used by Gmail and Office 365.
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (c *xoauth2Client) Start() (mech string, ir []byte, err error) {
+	ir = []byte(fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", c.username, c.token))
+	return xoauth2Mechanism, ir, nil
+}
+
+// Next handles a challenge by the server. With XOAUTH2, the server only sends a challenge with
+// error details if authentication failed. The client has to reply with an empty response, after
+// which the server reports the failure.
+func (c *xoauth2Client) Next(_ []byte) (response []byte, err error) {
+	return []byte{}, nil
+}
+
+// Authenticate via XOAUTH2. If the server rejects the access token, errTokenRejected is returned,
+// see authenticateClient.
+func authenticateOAuth2(imapClient imapOps, config IMAPConfig) (imapOps, error) {
+	token, err := config.OAuth2.source().accessToken()
+	if err != nil {
+		logError("cannot log in")
+		return imapClient, fmt.Errorf("%w: %w", ErrLoginFailed, err)
+	}
+	logInfo(fmt.Sprintf("logging in as %s via OAuth2", config.User))
+	err = imapClient.Authenticate(&xoauth2Client{username: config.User, token: token})
+	if err != nil {
+		logError("cannot log in")
+		return imapClient, fmt.Errorf("%w: %w: %w", ErrLoginFailed, errTokenRejected, err)
+	}
+	logInfo("logged in")
+	return imapClient, nil
+}
+
+// Ensure the SASL client interface is implemented.
+var _ sasl.Client = &xoauth2Client{}
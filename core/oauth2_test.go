@@ -0,0 +1,225 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Set up a fake token endpoint that hands out numbered access tokens. A status code of 200 results
+// in a valid token while any other one results in an error.
+func setUpTokenServer(t *testing.T, status int) (*httptest.Server, *int) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+		assert.Equal(t, "some refresh token", r.Form.Get("refresh_token"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(
+			w, `{"access_token":"token%d","token_type":"Bearer","expires_in":3600}`, count,
+		)
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func oauth2TestConfig(tokenURL string) IMAPConfig {
+	return IMAPConfig{
+		User: "someone",
+		OAuth2: OAuth2Config{
+			ClientID:     "some client",
+			RefreshToken: "some refresh token",
+			TokenURL:     tokenURL,
+		},
+	}
+}
+
+func TestOAuth2AccessToken(t *testing.T) {
+	server, count := setUpTokenServer(t, http.StatusOK)
+	cfg := oauth2TestConfig(server.URL).OAuth2
+
+	token, err := cfg.source().accessToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "token1", token)
+
+	// The token is reused until it expires, also for copies of the config.
+	token, err = oauth2TestConfig(server.URL).OAuth2.source().accessToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "token1", token)
+	assert.Equal(t, 1, *count)
+
+	// A new token is obtained when forcing a refresh.
+	cfg.source().refresh()
+	token, err = cfg.source().accessToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "token2", token)
+	assert.Equal(t, 2, *count)
+}
+
+func TestOAuth2AccessTokenExpired(t *testing.T) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		count++
+		w.Header().Set("Content-Type", "application/json")
+		// Tokens that are about to expire are replaced before they are used.
+		_, _ = fmt.Fprintf(
+			w, `{"access_token":"token%d","token_type":"Bearer","expires_in":1}`, count,
+		)
+	}))
+	t.Cleanup(server.Close)
+	source := oauth2TestConfig(server.URL).OAuth2.source()
+
+	token, err := source.accessToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "token1", token)
+
+	token, err = source.accessToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "token2", token)
+}
+
+func TestOAuth2AccessTokenError(t *testing.T) {
+	server, _ := setUpTokenServer(t, http.StatusBadRequest)
+	cfg := oauth2TestConfig(server.URL).OAuth2
+
+	_, err := cfg.source().accessToken()
+
+	assert.ErrorContains(t, err, "cannot obtain OAuth2 access token")
+}
+
+func TestXOAuth2Client(t *testing.T) {
+	c := &xoauth2Client{username: "someone", token: "some token"}
+
+	mech, ir, err := c.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "XOAUTH2", mech)
+	assert.Equal(t, "user=someone\x01auth=Bearer some token\x01\x01", string(ir))
+
+	resp, err := c.Next([]byte("some error details"))
+	assert.NoError(t, err)
+	assert.Empty(t, resp)
+}
+
+func TestAuthenticateClientOAuth2Success(t *testing.T) {
+	server, _ := setUpTokenServer(t, http.StatusOK)
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Authenticate", &xoauth2Client{username: "someone", token: "token1"}).Return(nil)
+
+	client, err := authenticateClient(oauth2TestConfig(server.URL))
+
+	assert.NoError(t, err)
+	assert.Equal(t, m, client)
+}
+
+func TestAuthenticateClientOAuth2Refresh(t *testing.T) {
+	server, count := setUpTokenServer(t, http.StatusOK)
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Authenticate", &xoauth2Client{username: "someone", token: "token1"}).
+		Return(fmt.Errorf("token expired"))
+	m.On("Terminate").Return(nil)
+	m.On("Authenticate", &xoauth2Client{username: "someone", token: "token2"}).Return(nil)
+
+	client, err := authenticateClient(oauth2TestConfig(server.URL))
+
+	assert.NoError(t, err)
+	assert.Equal(t, m, client)
+	assert.Equal(t, 2, *count)
+}
+
+func TestAuthenticateClientOAuth2ReuseToken(t *testing.T) {
+	server, count := setUpTokenServer(t, http.StatusOK)
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Authenticate", &xoauth2Client{username: "someone", token: "token1"}).Return(nil).Twice()
+
+	for range 2 {
+		_, err := authenticateClient(oauth2TestConfig(server.URL))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, *count)
+}
+
+func TestAuthenticateClientOAuth2RefreshProtocolLog(t *testing.T) {
+	server, _ := setUpTokenServer(t, http.StatusOK)
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Authenticate", &xoauth2Client{username: "someone", token: "token1"}).
+		Return(fmt.Errorf("token revoked"))
+	m.On("Terminate").Return(nil)
+	m.On("Authenticate", &xoauth2Client{username: "someone", token: "token2"}).Return(nil)
+	// The new connection is logged, too.
+	m.On("SetDebug", mock.AnythingOfType("*imap.debugWriter")).Return().Twice()
+	cfg := oauth2TestConfig(server.URL)
+	cfg.ProtocolLog = &bytes.Buffer{}
+
+	_, err := authenticateClient(cfg)
+
+	assert.NoError(t, err)
+}
+
+func TestAuthenticateClientOAuth2Failure(t *testing.T) {
+	server, count := setUpTokenServer(t, http.StatusOK)
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Authenticate", mock.Anything).Return(fmt.Errorf("wrong credentials"))
+	m.On("Terminate").Return(nil)
+
+	_, err := authenticateClient(oauth2TestConfig(server.URL))
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "wrong credentials")
+	assert.Equal(t, 2, *count)
+}
+
+func TestAuthenticateClientOAuth2TokenError(t *testing.T) {
+	server, _ := setUpTokenServer(t, http.StatusInternalServerError)
+	m := setUpMockClient(t, nil, nil, nil)
+
+	_, err := authenticateClient(oauth2TestConfig(server.URL))
+
+	assert.ErrorContains(t, err, "cannot obtain OAuth2 access token")
+	m.AssertNotCalled(t, "Authenticate", mock.Anything)
+}
+
+func TestAuthenticateClientOAuth2CannotReconnect(t *testing.T) {
+	server, _ := setUpTokenServer(t, http.StatusOK)
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Authenticate", mock.Anything).Return(fmt.Errorf("token expired"))
+	m.On("Terminate").Return(nil)
+	// Fail on the second connection attempt only.
+	connections := 0
+	newImapClient = func(_ string, _ bool, _ *tls.Config) (imapOps, error) {
+		connections++
+		if connections > 1 {
+			return nil, fmt.Errorf("cannot reconnect")
+		}
+		return m, nil
+	}
+
+	_, err := authenticateClient(oauth2TestConfig(server.URL))
+
+	assert.ErrorIs(t, err, ErrConnectFailed)
+	assert.ErrorContains(t, err, "cannot reconnect")
+}
@@ -40,6 +40,18 @@ type oldmail struct {
 	uidFolder uidFolder
 	uid       uid
 	timestamp int
+	// flags are the flags of an email retrieved during this run, if they have been retrieved. They
+	// are not stored in the oldmail file.
+	flags []string
+	// structure is the body structure of an email retrieved during this run, if it has been
+	// retrieved. It is not stored in the oldmail file, either.
+	structure *BodyStructure
+	// size is the size of an email according to the server, if it has been retrieved during this
+	// run. It is not stored in the oldmail file, either.
+	size int64
+	// gmail holds Gmail's labels and IDs of an email, if they have been retrieved during this run.
+	// They are not stored in the oldmail file, either.
+	gmail *GmailInfo
 }
 
 // Provide a string representation for oldmail information.
@@ -47,6 +47,11 @@ func (f *mockFile) Close() error {
 	return args.Error(0)
 }
 
+func (f *mockFile) Sync() error {
+	args := f.m.Called()
+	return args.Error(0)
+}
+
 func (f *mockFile) Read(p []byte) (n int, err error) {
 	if len(f.content) > len(p) {
 		panic("buffer too short")
@@ -0,0 +1,928 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/emersion/go-imap"
+)
+
+// The number of emails retrieved with a single fetch when downloading the newest emails first.
+const newestFirstBatchSize = 50
+
+const (
+	// DefaultMaxThreads is the default maximum number of download threads, see
+	// DownloadOptions.MaxThreads. Many servers allow about ten connections per user.
+	DefaultMaxThreads = 10
+	// The maximum number of download threads used by default, i.e. if no number is given.
+	defaultThreads = 4
+)
+
+// The number of CPUs, which determines the default number of download threads.
+var numCPU = runtime.NumCPU
+
+// FetchPreset selects a predefined set of items that are retrieved for each email.
+type FetchPreset string
+
+const (
+	// FetchPresetFull retrieves the full content of each email. This is the default.
+	FetchPresetFull FetchPreset = "full"
+	// FetchPresetHeaders retrieves only the header section of each email, like HeadersOnly.
+	FetchPresetHeaders FetchPreset = "headers"
+	// FetchPresetMetadata retrieves only the header fields identifying each email, i.e. its
+	// sender, recipients, subject, date, and message ID. Flags and size are retrieved, too.
+	FetchPresetMetadata FetchPreset = "metadata"
+)
+
+// FileNaming selects how the files of emails delivered to a maildir are named.
+type FileNaming string
+
+const (
+	// FileNamingUnique names files uniquely as mandated by the maildir specs. This is the default.
+	FileNamingUnique FileNaming = "unique"
+	// FileNamingUID names files after the UIDVALIDITY of their folder and the UID of their email,
+	// i.e. "<UIDVALIDITY>.<UID>.eml", which simplifies cross-referencing them with the server.
+	FileNamingUID FileNaming = "uid"
+)
+
+// Layout selects how the emails of a folder are organised on disk.
+type Layout string
+
+const (
+	// LayoutMaildir stores the emails of a folder in a single maildir. This is the default.
+	LayoutMaildir Layout = "maildir"
+	// LayoutDate stores the emails of a folder in one maildir per month in which the server received
+	// them, e.g. "INBOX/2024/01" for emails received in January 2024, which simplifies moving old
+	// emails to cold storage.
+	LayoutDate Layout = "date"
+)
+
+// LineEnding selects the line endings of stored emails.
+type LineEnding string
+
+const (
+	// LineEndingCRLF keeps the CRLF line endings that emails are delivered with. This is the
+	// default.
+	LineEndingCRLF LineEnding = "crlf"
+	// LineEndingLF converts CRLF line endings to LF in headers and text parts. Other parts, e.g.
+	// attachments, are kept unchanged since they might contain binary data. It cannot be
+	// combined with MoveTo or DeleteFromServer.
+	LineEndingLF LineEnding = "lf"
+)
+
+// The header fields stored for each email with the metadata preset.
+var metadataHeaderFields = []string{"From", "To", "Cc", "Subject", "Date", "Message-ID"}
+
+// DownloadOptions configures how emails are downloaded. The zero value results in the default
+// behaviour, i.e. all emails missing locally are downloaded to a maildir.
+type DownloadOptions struct {
+	// NewStorer, if set, creates the Storer that the emails of a folder are written to instead of
+	// the default maildir. It is called once per folder with the name of that folder.
+	NewStorer func(folder string) (Storer, error)
+	// FolderPath, if set, determines the path of the maildir of a folder relative to the maildir
+	// base instead of DefaultFolderPath. It is called with the name of each selected folder and
+	// must return a distinct, non-empty path for each of them. Otherwise, no folder is downloaded.
+	FolderPath func(folder string) string
+	// HeadersOnly causes only the header section of each email to be retrieved and stored instead
+	// of the full content. This is useful for building a lightweight index of a mailbox. Since
+	// emails are remembered as downloaded either way, do not mix modes for the same maildir.
+	HeadersOnly bool
+	// ProgressInterval, if positive, is the interval at which the download throughput and an
+	// estimate of the remaining time are logged for each folder.
+	ProgressInterval time.Duration
+	// KeepaliveInterval, if positive, is the interval after which a NOOP command is sent to the
+	// server if no email has been retrieved in the meantime. This keeps connections to servers
+	// with aggressive idle timeouts alive while downloading a folder. No NOOP is ever sent while
+	// emails are being retrieved.
+	KeepaliveInterval time.Duration
+	// Archive causes the emails of each folder to be written to a gzip-compressed tar archive next
+	// to the folder's maildir instead of to the maildir itself. The archive can be extracted into a
+	// maildir later on. Every run creates a new archive containing the newly downloaded emails.
+	Archive bool
+	// MaxPartSize, if positive, is the maximum size in bytes of parts of emails, e.g. attachments,
+	// that are stored. Larger parts are replaced by a short placeholder. Parts of type text/plain
+	// and text/html are always stored. This saves disk space but not bandwidth since emails are
+	// still retrieved in full. Since the original emails are not stored, it cannot be combined
+	// with MoveTo or DeleteFromServer.
+	MaxPartSize int
+	// NewestFirst causes emails to be downloaded in descending order of their UIDs, i.e. the most
+	// recent ones first. That way, an interrupted download has retrieved the most recent emails.
+	NewestFirst bool
+	// Mirror causes emails that are present locally but have been deleted on the server to be moved
+	// to a separate maildir called ".deleted" within the folder's maildir. They are never deleted.
+	// Only emails delivered to a maildir since the introduction of this option can be moved.
+	Mirror bool
+	// KeepMalformed causes emails that are empty or whose header cannot be parsed to be stored as
+	// they are. By default, such emails are not stored and reported as errors. Since they are not
+	// remembered as downloaded, their download is retried during the next run.
+	KeepMalformed bool
+	// Mbox causes emails to additionally be appended to a file in the mboxrd format next to the
+	// folder's maildir, e.g. "INBOX.mbox" for "INBOX".
+	Mbox bool
+	// Format, if set, is the format that emails are stored in. Unless it is empty, the format of
+	// any existing output is detected before downloading and the download fails if it differs from
+	// the requested one. The automatic format continues in the format of existing output. An empty
+	// format stores emails in maildirs and, if requested via Mbox, mbox files without detection.
+	Format OutputFormat
+	// AdditionalStorers, if set, create further Storers that the emails of a folder are written to.
+	// Each is called once per folder with the name of that folder. Emails are retrieved only once
+	// and written to all storers. Only the primary storer, i.e. the maildir or the one created by
+	// NewStorer, determines which emails have already been downloaded.
+	AdditionalStorers []func(folder string) (Storer, error)
+	// MinSize and MaxSize, if positive, are inclusive bounds for the size in bytes of emails that
+	// are downloaded as reported by the server. Emails outside of these bounds are not retrieved.
+	MinSize int
+	MaxSize int
+	// SinceUID, if positive, causes only emails with at least that UID to be downloaded, e.g. to
+	// resume from a known UID during manual recovery. Since UIDs are only meaningful for a specific
+	// UIDVALIDITY, SinceUIDValidity, if positive, is the UIDVALIDITY that SinceUID refers to. If a
+	// folder's UIDVALIDITY differs from it, a warning is logged and SinceUID is ignored for that
+	// folder.
+	SinceUID         int
+	SinceUIDValidity int
+	// SeqStart and SeqEnd, if positive, restrict the download to the emails at these positions in
+	// a folder, i.e. those with sequence numbers from SeqStart to SeqEnd inclusive, e.g. for
+	// diagnostics. Sequence numbers change whenever emails are removed from a folder, so the same
+	// range might refer to different emails later on. A range extending beyond the end of a
+	// folder is shortened with a warning. Both have to be set together.
+	SeqStart int
+	SeqEnd   int
+	// StrictUIDCount causes the download of a folder to fail if the server reports fewer UIDs than
+	// emails in that folder even after retrying. By default, a warning is logged and the emails
+	// that have been reported are downloaded.
+	StrictUIDCount bool
+	// MoveTo, if set, is the folder on the server that emails are moved to once they have been
+	// stored successfully. This mutates the mailbox and requires selecting folders in read-write
+	// mode. It cannot be combined with Mirror, which would consider moved emails deleted, or
+	// AddressFilter, which would move emails that are not stored, and requires emails to be
+	// retrieved and stored in full, i.e. without MaxPartSize, Transform, or LineEndingLF.
+	MoveTo string
+	// DeleteFromServer causes emails to be marked as deleted and expunged on the server once they
+	// have been stored successfully, i.e. they are moved off the server. This is destructive and
+	// requires selecting folders in read-write mode. Only the stored emails are expunged if the
+	// server supports UIDPLUS. Otherwise, folders containing other emails marked as deleted are
+	// left alone. It cannot be combined with MoveTo, Mirror, or AddressFilter and requires emails
+	// to be retrieved and stored in full, i.e. without MaxPartSize, Transform, or LineEndingLF.
+	DeleteFromServer bool
+	// Transform, if set, is applied to the content of each email, formatted according to RFC822,
+	// after it has been retrieved and before it is validated and stored, e.g. to remove tracking
+	// pixels. Since emails are processed after transforming them, sizes determined while storing,
+	// e.g. for progress reports, refer to the transformed content. Filtering by size always uses
+	// the sizes reported by the server. Since the original emails are not stored, it cannot be
+	// combined with MoveTo or DeleteFromServer.
+	Transform func([]byte) ([]byte, error)
+	// Metadata causes the annotations of each folder to be stored in a file next to its emails if
+	// the server supports the METADATA extension, see RFC 5464. Failures to retrieve them are
+	// logged as warnings. Annotations of individual emails are not part of that extension.
+	Metadata bool
+	// AddressFilter, if set, causes emails to be stored only if the value of one of their From, To,
+	// or Cc header fields matches it, e.g. to archive only emails from certain senders. Emails are
+	// filtered after they have been retrieved in full, which is why excluded emails still cost
+	// bandwidth. They are remembered as downloaded and counted as filtered, i.e. they are not
+	// retrieved again even if the filter changes. Header fields are matched as they are, i.e.
+	// without decoding any encoded words in display names.
+	AddressFilter *regexp.Regexp
+	// KeepUntransformed causes emails whose transformation fails to be stored unchanged. By
+	// default, such emails are not stored and reported as errors without aborting the download of
+	// the folder. Since they are not remembered as downloaded, their download is retried during the
+	// next run.
+	KeepUntransformed bool
+	// IncludeGmailAllMail causes Gmail's folder containing all emails, e.g. "[Gmail]/All Mail", to
+	// be downloaded even if it has not been requested by its name. By default, it is skipped since
+	// every email it contains is also contained in another folder. Gmail is detected via the
+	// server's hostname or the names of its special folders.
+	IncludeGmailAllMail bool
+	// ExactFolders causes the folders passed to DownloadFolder to be taken as the exact names the
+	// server lists them by instead of folder specs, e.g. for folders picked from such a list. Names
+	// are neither decoded nor expanded, and a leading "-" does not exclude a folder. Since every
+	// folder has been requested by its name, Gmail's folder containing all emails is not skipped.
+	ExactFolders bool
+	// FetchPreset selects the items retrieved for each email. It defaults to FetchPresetFull, or to
+	// FetchPresetHeaders if HeadersOnly is set. Each preset retrieves exactly one body section,
+	// which is what is stored.
+	FetchPreset FetchPreset
+	// FetchItems are additional items retrieved for each email along with those of the preset,
+	// e.g. imap.FetchFlags. Only the body section of the preset is stored. Thus, these must not be
+	// body sections.
+	FetchItems []imap.FetchItem
+	// BodyStructure causes the MIME structure of each email, e.g. content types, sizes of parts,
+	// and names of attached files, to be retrieved and appended as a line of JSON to a file in the
+	// folder's maildir. Combine it with FetchPresetMetadata to index emails without retrieving
+	// their content. Emails whose structure the server reports incompletely are not listed.
+	BodyStructure bool
+	// GmailLabels causes Gmail's labels of each email as well as its message and thread IDs, i.e.
+	// X-GM-LABELS, X-GM-MSGID, and X-GM-THRID, to be retrieved and appended as a line of JSON to a
+	// file in the folder's maildir. Folders alone do not reflect all labels of emails. It has no
+	// effect for servers other than Gmail's, which are detected via their host names or the
+	// X-GM-EXT-1 capability.
+	GmailLabels bool
+	// FileNaming selects how the files of emails delivered to a maildir are named. It defaults to
+	// FileNamingUnique. With FileNamingUID, emails whose files are present in the maildir are
+	// considered downloaded even if they are missing from the oldmail file. It cannot be combined
+	// with Archive and has no effect with NewStorer.
+	FileNaming FileNaming
+	// FileNameTemplate, if set, is a text/template that determines the names of the files of
+	// emails delivered to a maildir instead of FileNaming, e.g. "{{.Date.Format "2006-01-02"}}
+	// {{.Subject}}.eml". See FileNameFields for the available fields. Names that are already taken
+	// get a counter appended, e.g. "-1". Such names do not comply with the maildir specs, which
+	// is why the default remains FileNamingUnique. It cannot be combined with FileNamingUID or
+	// Archive and has no effect with NewStorer.
+	FileNameTemplate string
+	// VerifyCount causes the download of a folder to fail if, after the download, fewer emails have
+	// been remembered as stored than were to be downloaded even though no errors were reported. By
+	// default, such a discrepancy is only logged as a warning.
+	VerifyCount bool
+	// AccountDirs causes the maildirs of all folders to be stored in a directory specific to the
+	// account within the given base directory, see AccountDirName. That way, several accounts can
+	// be backed up to the same base directory without their folders colliding. The information
+	// about which emails have already been downloaded is kept within that directory, too.
+	AccountDirs bool
+	// Manifest causes a manifest listing all downloaded emails of a folder with their UIDs, sizes,
+	// internal dates, flags, and paths within the maildir to be written to the file
+	// "imapgrab-manifest.json" within the folder's maildir after each download. Emails listed in
+	// the manifest are considered downloaded. Flags are those at the time of the download.
+	Manifest bool
+	// CompressManifest causes the manifest to be gzip-compressed and written to the file
+	// "imapgrab-manifest.json.gz" instead. It requires Manifest.
+	CompressManifest bool
+	// HostID, if set, replaces the host name in the unique names of newly stored files. Use
+	// distinct values if several machines with the same host name write to the same maildir, e.g.
+	// on a network share. It must not contain whitespace. By default, the host name is used.
+	HostID string
+	// Cancel, if set, aborts the download once it is closed. All connections are terminated right
+	// away, even while emails are being retrieved, and folders that have not been downloaded
+	// completely are reported as failed. Emails stored until then remain remembered as downloaded.
+	Cancel <-chan struct{}
+	// MaxRuntime, if positive, stops the download once it has taken that long, e.g. for nightly
+	// runs with a time budget. The download stops as gracefully as after a user interrupt, i.e.
+	// emails that have been stored are remembered as downloaded and the next download resumes
+	// from there. A retrieval still ongoing then is aborted by terminating the connection. The
+	// returned error then wraps ErrRuntimeExceeded. With DownloadAccounts, the limit applies to the
+	// download of all accounts together.
+	MaxRuntime time.Duration
+	// MaxBytesPerSecond, if positive, limits the rate at which the contents of emails are retrieved
+	// summed up across all download threads, e.g. to avoid overwhelming a shared gateway. With
+	// DownloadAccounts, the limit is shared by all accounts. See MaxConnections to limit the number
+	// of connections.
+	MaxBytesPerSecond int
+	// MaxConnections, if positive, limits the number of connections to servers that are open at the
+	// same time, including those of additional clients, see FolderThreads, ReconnectEvery, and
+	// AutoThreads. With DownloadAccounts, the limit is shared by all accounts. The first connection
+	// waits for a free one. Further ones fail with ErrNoFreeConnection if none is free, in which
+	// case fewer threads or clients are used. The folders of a download thread that cannot connect
+	// are downloaded by the first thread instead.
+	MaxConnections int
+	// ConnectionSlots, if set, is a directory containing one lock file per connection permitted via
+	// MaxConnections, which shares the limit with all processes using the same directory, e.g. those
+	// that the UI starts for several mailboxes. Otherwise, the limit applies to this process only.
+	ConnectionSlots string
+	// ContinueOnLoginFailure causes DownloadAccounts to continue with the remaining accounts if
+	// one of them cannot be authenticated, e.g. due to a wrong password. By default, no further
+	// accounts are downloaded in that case. It has no effect on DownloadFolder.
+	ContinueOnLoginFailure bool
+	// LineEnding selects the line endings of stored emails, see LineEndingLF. It defaults to
+	// LineEndingCRLF.
+	LineEnding LineEnding
+	// Layout selects how emails are organised within the maildir of a folder, see LayoutDate. It
+	// defaults to LayoutMaildir. Emails are identified via the oldmail file and, with FileNamingUID,
+	// via the names of files in all partitions. Since the date layout does not remember the names
+	// of files, it cannot be combined with Mirror.
+	Layout Layout
+	// Dedup, if set, causes emails whose content matches that of an email already stored within
+	// the base directory to be replaced by a link to the existing file, see DedupHardlink and
+	// DedupSymlink. Emails are identified by the SHA-256 hashes of their content, which are
+	// remembered in a file in the base directory. If a link cannot be created, the full copy is
+	// kept. It cannot be combined with mbox files or archives, and DedupSymlink cannot be combined
+	// with Mirror.
+	Dedup DedupLink
+	// AutoThreads causes DownloadFolder to tune the number of download threads automatically. It
+	// starts with a single thread and adds one thread at a time as long as that noticeably
+	// increases the throughput without causing errors. The number of threads passed to
+	// DownloadFolder is the maximum then.
+	AutoThreads bool
+	// MaxThreads, if positive, is the maximum number of download threads. Any larger number passed
+	// to DownloadFolder is reduced to it to avoid exceeding the number of connections servers
+	// allow. It defaults to DefaultMaxThreads.
+	MaxThreads int
+	// FolderThreads, if set, maps names of folders to the number of clients that their emails are
+	// retrieved with in parallel, e.g. to speed up large folders. Folders without an entry are
+	// retrieved via a single client, the download thread handling them, as are all folders by
+	// default. Additional clients log in separately, which counts towards the connections servers
+	// allow. Emails are stored in the order they are retrieved then, irrespective of NewestFirst.
+	// Numbers must be positive and must not exceed MaxThreads.
+	FolderThreads map[string]int
+	// ReconnectEvery, if positive, is the number of emails of a folder after which the connection
+	// is logged out and a new one is established, authenticated, and selects the folder again to
+	// retrieve the next emails. Use it for servers that limit the number of emails or the amount
+	// of data per session. It cannot be combined with FolderThreads.
+	ReconnectEvery int
+	// OnlyChanged causes folders to be skipped entirely if neither their number of emails nor
+	// their UIDNEXT, i.e. the UID that the next new email will receive, has changed since the last
+	// successful download, as determined via STATUS. That avoids listing all emails of folders
+	// that rarely change. Changes of flags are not detected. It cannot be combined with UIDFile or
+	// a range of sequence numbers.
+	OnlyChanged bool
+	// Audit causes a record about every downloaded email to be appended to the file
+	// "imapgrab-audit.jsonl" within the folder's maildir, one JSON object per line, e.g. for
+	// forensic archives. Records state the user, server, and port the email came from, its
+	// internal date, the time of the download, and the size and SHA-256 hash of the content handed
+	// to storage, which is the content of the file for maildirs. Every record contains the hash
+	// of the previous line so that modifications of the log can be detected.
+	Audit bool
+	// FileNameMetadata causes the UID and the size of emails to be added to the names of their
+	// files in maildirs as extension fields, e.g. "name,U=42,W=1234:2,S", as understood by Dovecot
+	// and others. The size is the one reported by the server, i.e. with CRLF line endings, which
+	// is retrieved together with the emails. That way, restored maildirs retain hints about UIDs.
+	FileNameMetadata bool
+	// MaildirFlags causes the IMAP flags of emails to be stored as maildir flags, e.g. "\Seen" as
+	// "S" and "\Draft" as "D", so that mail clients show them correctly after a restore. Emails
+	// with flags are delivered to the cur sub-directory of the maildir with the flags in the info
+	// part of their file names, e.g. "name:2,DS". Emails in folders with the special use
+	// "\Drafts" are always flagged as drafts. Flags are those at the time of the download. It only
+	// affects emails stored in maildirs. See MaildirSubdir for the sub-directory emails go to.
+	MaildirFlags bool
+	// MaildirSubdir selects the sub-directory of the maildir that emails are delivered to. It
+	// defaults to MaildirSubdirAuto, which delivers emails with flags as per MaildirFlags, e.g.
+	// seen ones, to cur and all others to new. MaildirSubdirCur delivers all emails to cur, i.e.
+	// as already read. MaildirSubdirNew delivers all emails to new, whose files carry no flags,
+	// and thus cannot be combined with MaildirFlags. It only affects emails stored in maildirs.
+	MaildirSubdir MaildirSubdir
+	// UIDFile, if set, is the path to a file listing the UIDs of the emails to download, one per
+	// line. Entries can also state the UIDVALIDITY of the folder, e.g. "1234/56", in which case
+	// they are only downloaded from folders with that UIDVALIDITY. The list of emails on the
+	// server is not retrieved. Listed emails are downloaded even if they have been downloaded
+	// before, e.g. to repair a corrupted local copy.
+	UIDFile string
+	// Refresh causes the index of each folder, i.e. the oldmail file, the manifest, and the state
+	// used for incremental downloads, to be ignored so that all emails are downloaded again, e.g.
+	// to recover a folder whose local copy is incomplete. Combine it with a single folder to
+	// refresh only that one. Files stored before are kept, which is why the maildir then contains
+	// every email twice. Folders are never skipped via OnlyChanged. It cannot be combined with
+	// FileNamingUID, whose file names are taken already, or with UIDFile.
+	Refresh bool
+	// DateFallback selects what replaces the internal date of emails that the server reports
+	// without one or with one not after the Unix epoch, which would otherwise end up in file
+	// names, archives, mbox files, and the date layout. It defaults to DateFallbackHeader. A
+	// warning is logged whenever a fallback is used.
+	DateFallback DateFallback
+	// ReferenceMaildirs, if set, are directories containing maildirs with emails that are
+	// available elsewhere, e.g. in another archive that is being merged. Emails whose Message-ID
+	// matches that of an email in any of these maildirs are not downloaded. Their Message-IDs are
+	// retrieved from the server before downloading a folder. Since such emails are not remembered
+	// as downloaded, they are checked again during every download. Emails without a Message-ID
+	// are always downloaded.
+	ReferenceMaildirs []string
+	// FetchEntireBody causes the full content of emails to be retrieved via BODY.PEEK[] instead of
+	// RFC822, which provides the same content. Use it for servers that reject RFC822. Even without
+	// it, BODY.PEEK[] is used for the remainder of a folder if the server rejects RFC822. It has no
+	// effect with presets other than FetchPresetFull.
+	FetchEntireBody bool
+	// Summary, if set, collects statistics about every folder downloaded with these options, e.g.
+	// the numbers of downloaded, skipped, and failed emails. Use the same summary for several calls
+	// to DownloadFolder or with DownloadAccounts to collect statistics for several accounts.
+	Summary *DownloadSummary
+	// Quarantine, if set, is the path to a directory to which whatever has been received of emails
+	// that cannot be extracted from the server's reply or cannot be stored, e.g. because they are
+	// malformed, is written for later inspection. Each folder has its own sub-directory. Files
+	// have the extension .partial and are accompanied by files with the extension .error stating
+	// the reason. Quarantined emails count as failed and are retried during the next download.
+	Quarantine string
+
+	// The account whose folders are downloaded, which statistics are recorded for.
+	account string
+	// The user, server, and port that emails are downloaded from, which is recorded in audit logs.
+	source string
+	// The meter tracking the combined throughput of all threads during automatic tuning.
+	meter *progress
+	// The function connecting additional clients for FolderThreads and ReconnectEvery.
+	connect connectFunc
+	// The hashes of stored emails used for deduplication, see Dedup.
+	hashes *hashStore
+	// The folders with the special use "\Drafts", see MaildirFlags.
+	draftFolders map[string]struct{}
+	// The Message-IDs of emails in the reference maildirs, see ReferenceMaildirs.
+	references messageIDSet
+	// The limit of the runtime shared by all download threads, see MaxRuntime.
+	runtime *runtimeLimit
+	// The limit of the bandwidth shared by all download threads, see MaxBytesPerSecond.
+	bandwidth *bandwidthLimit
+	// The limit of the connections shared by all download threads, see MaxConnections.
+	connections *connectionLimit
+	// Whether the server is Gmail's and supports retrieving labels, see GmailLabels.
+	gmail bool
+}
+
+// Determine the preset in use, taking HeadersOnly into account.
+func (o DownloadOptions) fetchPreset() FetchPreset {
+	if o.FetchPreset != "" {
+		return o.FetchPreset
+	}
+	if o.HeadersOnly {
+		return FetchPresetHeaders
+	}
+	return FetchPresetFull
+}
+
+// Check that the options can be combined.
+func (o DownloadOptions) check() error {
+	if err := o.checkFetchItems(); err != nil {
+		return err
+	}
+	if o.MoveTo != "" && o.Mirror {
+		return fmt.Errorf("cannot move emails on the server while mirroring deletions")
+	}
+	if o.MoveTo != "" && o.fetchPreset() != FetchPresetFull {
+		return fmt.Errorf("cannot move emails on the server that are not retrieved in full")
+	}
+	if o.DeleteFromServer && o.MoveTo != "" {
+		return fmt.Errorf("cannot both move and delete emails on the server")
+	}
+	if o.DeleteFromServer && o.Mirror {
+		return fmt.Errorf("cannot delete emails on the server while mirroring deletions")
+	}
+	if o.DeleteFromServer && o.fetchPreset() != FetchPresetFull {
+		return fmt.Errorf("cannot delete emails on the server that are not retrieved in full")
+	}
+	// Emails skipped by the address filter count as stored but are not. The other options change
+	// the content of stored emails, i.e. the originals would be lost.
+	removing := o.MoveTo != "" || o.DeleteFromServer
+	if removing && o.AddressFilter != nil {
+		return fmt.Errorf("cannot remove emails from a folder on the server while filtering them")
+	}
+	if removing && o.MaxPartSize > 0 {
+		return fmt.Errorf("cannot remove emails from a folder on the server without storing parts")
+	}
+	if removing && o.Transform != nil {
+		return fmt.Errorf("cannot remove emails from a folder on the server while transforming them")
+	}
+	if removing && o.LineEnding == LineEndingLF {
+		return fmt.Errorf(
+			"cannot remove emails from a folder on the server while converting line endings",
+		)
+	}
+	if o.CompressManifest && !o.Manifest {
+		return fmt.Errorf("cannot compress manifest without writing one")
+	}
+	if o.SinceUID < 0 || o.SinceUIDValidity < 0 {
+		return fmt.Errorf("UIDs and UIDVALIDITY values must not be negative")
+	}
+	if o.SinceUIDValidity > 0 && o.SinceUID == 0 {
+		return fmt.Errorf("cannot validate a UIDVALIDITY without a UID to start from")
+	}
+	if o.SeqStart != 0 || o.SeqEnd != 0 {
+		if o.SeqStart <= 0 || o.SeqStart > o.SeqEnd || o.SeqEnd > math.MaxInt32 {
+			return fmt.Errorf(
+				"invalid sequence number range %d:%d, need 0<start<=end", o.SeqStart, o.SeqEnd,
+			)
+		}
+		if o.Mirror {
+			return fmt.Errorf("cannot mirror deletions when downloading a sequence number range")
+		}
+	}
+	if o.UIDFile != "" && (o.Mirror || o.excludesEmails()) {
+		return fmt.Errorf("cannot mirror deletions or filter emails when reading UIDs from a file")
+	}
+	if o.Refresh && (o.UIDFile != "" || o.FileNaming == FileNamingUID) {
+		return fmt.Errorf("cannot refresh folders when reading UIDs from a file or naming files by UID")
+	}
+	if strings.ContainsFunc(o.HostID, unicode.IsSpace) {
+		return fmt.Errorf("host identifier '%s' must not contain whitespace", o.HostID)
+	}
+	switch o.Format {
+	case "", OutputFormatAuto, OutputFormatMbox:
+	case OutputFormatMaildir:
+		if o.Mbox {
+			return fmt.Errorf("cannot append emails to mbox files with output format maildir")
+		}
+	default:
+		return fmt.Errorf("unknown output format '%s'", o.Format)
+	}
+	switch o.FileNaming {
+	case "", FileNamingUnique:
+	case FileNamingUID:
+		if o.Archive {
+			return fmt.Errorf("cannot name files after UIDs in archives")
+		}
+	default:
+		return fmt.Errorf("unknown file naming scheme '%s'", o.FileNaming)
+	}
+	if o.FileNameTemplate != "" {
+		if o.FileNaming == FileNamingUID || o.Archive {
+			return fmt.Errorf("cannot use a file name template with UID names or archives")
+		}
+		if _, err := parseFileNameTemplate(o.FileNameTemplate); err != nil {
+			return err
+		}
+	}
+	switch o.LineEnding {
+	case "", LineEndingCRLF, LineEndingLF:
+	default:
+		return fmt.Errorf("unknown line ending '%s'", o.LineEnding)
+	}
+	switch o.MaildirSubdir {
+	case "", MaildirSubdirAuto, MaildirSubdirCur:
+	case MaildirSubdirNew:
+		if o.MaildirFlags {
+			return fmt.Errorf("cannot store maildir flags for emails delivered to new")
+		}
+	default:
+		return fmt.Errorf("unknown maildir sub-directory '%s'", o.MaildirSubdir)
+	}
+	switch o.DateFallback {
+	case "", DateFallbackHeader, DateFallbackNow, DateFallbackNone:
+	default:
+		return fmt.Errorf("unknown date fallback '%s'", o.DateFallback)
+	}
+	switch o.Layout {
+	case "", LayoutMaildir:
+	case LayoutDate:
+		if o.Mirror || o.Archive {
+			return fmt.Errorf("cannot mirror deletions or write archives with the date layout")
+		}
+	default:
+		return fmt.Errorf("unknown layout '%s'", o.Layout)
+	}
+	switch o.Dedup {
+	case "":
+	case DedupHardlink, DedupSymlink:
+		if o.mbox() || o.Archive {
+			return fmt.Errorf("cannot deduplicate emails in mbox files or archives")
+		}
+		// Moving the files of emails deleted on the server would break links to them.
+		if o.Dedup == DedupSymlink && o.Mirror {
+			return fmt.Errorf("cannot mirror deletions when deduplicating via symbolic links")
+		}
+	default:
+		return fmt.Errorf("unknown link type '%s'", o.Dedup)
+	}
+	maxThreads := o.MaxThreads
+	if maxThreads <= 0 {
+		maxThreads = DefaultMaxThreads
+	}
+	if o.OnlyChanged && (o.UIDFile != "" || o.seqRange()) {
+		return fmt.Errorf("cannot skip unchanged folders when downloading specific emails")
+	}
+	if o.MaxRuntime < 0 {
+		return fmt.Errorf("maximum runtime must not be negative")
+	}
+	if o.MaxBytesPerSecond < 0 {
+		return fmt.Errorf("maximum bandwidth must not be negative")
+	}
+	if o.MaxConnections < 0 {
+		return fmt.Errorf("maximum number of connections must not be negative")
+	}
+	if o.ConnectionSlots != "" && o.MaxConnections == 0 {
+		return fmt.Errorf("cannot share connection slots without a maximum number of connections")
+	}
+	if o.ReconnectEvery < 0 {
+		return fmt.Errorf("number of emails to reconnect after must not be negative")
+	}
+	if o.ReconnectEvery > 0 && len(o.FolderThreads) > 0 {
+		return fmt.Errorf("cannot reconnect after a number of emails with folder threads")
+	}
+	for folder, threads := range o.FolderThreads {
+		if threads < 1 || threads > maxThreads {
+			return fmt.Errorf(
+				"number of threads for folder '%s' must be between 1 and %d, got %d",
+				folder, maxThreads, threads,
+			)
+		}
+	}
+	return nil
+}
+
+// Check that the configured fetch items can be retrieved and stored.
+func (o DownloadOptions) checkFetchItems() error {
+	switch preset := o.fetchPreset(); preset {
+	case FetchPresetFull, FetchPresetHeaders, FetchPresetMetadata:
+	default:
+		return fmt.Errorf("unknown fetch preset '%s'", preset)
+	}
+	if o.HeadersOnly && o.fetchPreset() != FetchPresetHeaders {
+		return fmt.Errorf("cannot retrieve only headers with fetch preset '%s'", o.fetchPreset())
+	}
+	for _, item := range o.FetchItems {
+		if _, err := imap.ParseBodySectionName(item); err == nil {
+			return fmt.Errorf("additional fetch item %s must not be a body section", item)
+		}
+	}
+	return nil
+}
+
+// Determine the items to fetch for each email. Only valid items, according to checkFetchItems,
+// are supported.
+func (o DownloadOptions) fetchItems() []imap.FetchItem {
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate}
+	// Use BODY.PEEK for partial content to avoid implicitly marking emails as seen.
+	switch o.fetchPreset() {
+	case FetchPresetHeaders:
+		section := imap.BodySectionName{
+			BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier},
+			Peek:         true,
+		}
+		items = append(items, section.FetchItem())
+	case FetchPresetMetadata:
+		section := imap.BodySectionName{
+			BodyPartName: imap.BodyPartName{
+				Specifier: imap.HeaderSpecifier, Fields: metadataHeaderFields,
+			},
+			Peek: true,
+		}
+		items = append(items, imap.FetchFlags, imap.FetchRFC822Size, section.FetchItem())
+	default:
+		if o.FetchEntireBody {
+			items = append(items, fetchEntireBody)
+		} else {
+			items = append(items, imap.FetchRFC822)
+		}
+	}
+	if o.BodyStructure {
+		items = append(items, imap.FetchBodyStructure)
+	}
+	if o.GmailLabels && o.gmail {
+		items = append(items, gmailMsgIDItem, gmailThreadIDItem, gmailLabelsItem)
+	}
+	// The manifest and maildir flags contain the flags of emails.
+	if (o.Manifest || o.MaildirFlags) && !containsFetchItem(items, imap.FetchFlags) {
+		items = append(items, imap.FetchFlags)
+	}
+	if o.FileNameMetadata && !containsFetchItem(items, imap.FetchRFC822Size) {
+		items = append(items, imap.FetchRFC822Size)
+	}
+	for _, item := range o.FetchItems {
+		if !containsFetchItem(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Determine whether a list of fetch items already contains an item.
+func containsFetchItem(items []imap.FetchItem, item imap.FetchItem) bool {
+	for _, existing := range items {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Determine the number of download threads to use given the requested number. If no number is
+// requested, i.e. it is zero, the number of CPUs is used but at most defaultThreads. With
+// AutoThreads, it is the maximum number of threads then. Either way, it is capped at MaxThreads.
+func (o DownloadOptions) downloadThreads(threads int) (int, error) {
+	if threads < 0 {
+		return 0, fmt.Errorf("number of download threads must be positive, got %d", threads)
+	}
+	maxThreads := o.MaxThreads
+	if maxThreads < 0 {
+		return 0, fmt.Errorf("maximum number of download threads must be positive")
+	}
+	if maxThreads == 0 {
+		maxThreads = DefaultMaxThreads
+	}
+	if threads == 0 && o.AutoThreads {
+		threads = maxThreads
+	} else if threads == 0 {
+		threads = min(numCPU(), defaultThreads)
+	}
+	if threads > maxThreads {
+		logWarning(fmt.Sprintf(
+			"reducing number of download threads from %d to maximum of %d", threads, maxThreads,
+		))
+		threads = maxThreads
+	}
+	return threads, nil
+}
+
+// Whether emails are appended to mbox files next to the maildirs.
+func (o DownloadOptions) mbox() bool {
+	return o.Mbox || o.Format == OutputFormatMbox
+}
+
+// Create the Storer for a folder, which writes to all configured storers. Existing oldmail
+// information is only used by the built-in storers.
+func (o DownloadOptions) newStorer(maildirPath maildirPathT, oldmails []oldmail) (Storer, error) {
+	primary, err := o.newPrimaryStorer(maildirPath, oldmails)
+	if err != nil {
+		return primary, err
+	}
+	additional := []Storer{}
+	if o.mbox() {
+		additional = append(additional, newMboxStorer(maildirPath.folderPath(), oldmails))
+	}
+	if o.BodyStructure {
+		additional = append(additional, newStructureStorer(maildirPath.folderPath()))
+	}
+	if o.GmailLabels && o.gmail {
+		additional = append(additional, newGmailLabelsStorer(maildirPath.folderPath()))
+	}
+	for _, newAdditional := range o.AdditionalStorers {
+		storer, err := newAdditional(maildirPath.folderName())
+		if err != nil {
+			return nil, errors.Join(err, newMultiStorer(primary, additional).Close())
+		}
+		additional = append(additional, storer)
+	}
+	if len(additional) == 0 {
+		return primary, nil
+	}
+	return newMultiStorer(primary, additional), nil
+}
+
+// Create the Storer that determines which emails have already been downloaded.
+func (o DownloadOptions) newPrimaryStorer(
+	maildirPath maildirPathT, oldmails []oldmail,
+) (Storer, error) {
+	if o.NewStorer != nil {
+		return o.NewStorer(maildirPath.folderName())
+	}
+	if o.Archive {
+		storer := newArchiveStorer(maildirPath.folderPath(), oldmails)
+		storer.hostID = o.HostID
+		return storer, nil
+	}
+	storer := newMaildirStorer(maildirPath.folderPath(), oldmails)
+	storer.hostID = o.HostID
+	storer.byDate = o.Layout == LayoutDate
+	storer.dedup = o.hashes
+	storer.withFlags = o.MaildirFlags
+	storer.subdir = o.MaildirSubdir
+	storer.metadataInNames = o.FileNameMetadata
+	_, storer.drafts = o.draftFolders[maildirPath.folderName()]
+	if o.FileNameTemplate != "" {
+		tmpl, err := parseFileNameTemplate(o.FileNameTemplate)
+		if err != nil {
+			return nil, err
+		}
+		storer.nameTemplate = tmpl
+	}
+	if o.FileNaming == FileNamingUID {
+		if err := storer.nameByUID(); err != nil {
+			return nil, err
+		}
+	}
+	return storer, nil
+}
+
+// Track the progress of writing the given total number of emails to a storer if requested. The
+// returned storer must be used instead of the original one and the returned function must be
+// called once the download has finished.
+func (o DownloadOptions) trackProgress(
+	folder string, total int, storer Storer,
+) (Storer, func()) {
+	if o.ProgressInterval <= 0 {
+		return storer, func() {}
+	}
+	prog := newProgress(folder, total)
+	stop := prog.startLogging(o.ProgressInterval)
+	return prog.wrap(storer), func() {
+		stop()
+		logInfo(prog.String())
+	}
+}
+
+// Wrap a storer such that the combined throughput of all download threads is tracked during
+// automatic tuning of their number.
+func (o DownloadOptions) meterThroughput(storer Storer) Storer {
+	if o.meter == nil {
+		return storer
+	}
+	return o.meter.wrap(storer)
+}
+
+// Wrap a storer such that large parts of emails are removed before storing them, if requested.
+func (o DownloadOptions) filterParts(storer Storer) Storer {
+	if o.MaxPartSize <= 0 {
+		return storer
+	}
+	return &partFilterStorer{Storer: storer, maxSize: o.MaxPartSize}
+}
+
+// Wrap a storer such that the line endings of emails are converted before storing them if
+// requested.
+func (o DownloadOptions) convertLineEndings(storer Storer) Storer {
+	if o.LineEnding != LineEndingLF {
+		return storer
+	}
+	return &lineEndingStorer{Storer: storer}
+}
+
+// Wrap a storer such that emails are transformed before storing them if requested.
+func (o DownloadOptions) transform(storer Storer) Storer {
+	if o.Transform == nil {
+		return storer
+	}
+	return &transformingStorer{
+		Storer: storer, transform: o.Transform, keepUntransformed: o.KeepUntransformed,
+	}
+}
+
+// Wrap a storer such that only emails with matching addresses are stored if requested. The
+// returned storer is nil if emails are not filtered by their addresses.
+func (o DownloadOptions) filterByAddress(storer Storer) *addressFilterStorer {
+	if o.AddressFilter == nil {
+		return nil
+	}
+	return &addressFilterStorer{Storer: storer, pattern: o.AddressFilter}
+}
+
+// Wrap a storer such that emails are validated before storing them.
+func (o DownloadOptions) validate(storer Storer) Storer {
+	return &validatingStorer{Storer: storer, keepMalformed: o.KeepMalformed}
+}
+
+// Determine whether emails are filtered by their size.
+func (o DownloadOptions) filtersBySize() bool {
+	return o.MinSize > 0 || o.MaxSize > 0
+}
+
+// Remove all emails whose size is outside of the configured bounds.
+func (o DownloadOptions) filterBySize(uids []uidExt) []uidExt {
+	if !o.filtersBySize() {
+		return uids
+	}
+	kept := make([]uidExt, 0, len(uids))
+	for _, msg := range uids {
+		size := int(msg.size)
+		if (o.MinSize <= 0 || size >= o.MinSize) && (o.MaxSize <= 0 || size <= o.MaxSize) {
+			kept = append(kept, msg)
+		}
+	}
+	logInfo(fmt.Sprintf("excluded %d emails by size", len(uids)-len(kept)))
+	return kept
+}
+
+// Determine whether some emails present on the server might be excluded from the download by
+// filters other than whether they have already been downloaded.
+func (o DownloadOptions) excludesEmails() bool {
+	return o.filtersBySize() || o.SinceUID > 0 || o.seqRange()
+}
+
+// Determine whether only emails within a range of sequence numbers are downloaded.
+func (o DownloadOptions) seqRange() bool {
+	return o.SeqStart > 0 && o.SeqEnd > 0
+}
+
+// Remove all emails with a UID lower than the configured one. No emails are removed if the UIDs
+// refer to a different UIDVALIDITY than the configured one.
+func (o DownloadOptions) filterSinceUID(uids []uidExt, folder uidFolder) []uidExt {
+	if o.SinceUID <= 0 {
+		return uids
+	}
+	if o.SinceUIDValidity > 0 && uidFolder(o.SinceUIDValidity) != folder {
+		logWarning(fmt.Sprintf(
+			"UIDVALIDITY of folder is %d instead of %d, ignoring UID %d to start from",
+			folder, o.SinceUIDValidity, o.SinceUID,
+		))
+		return uids
+	}
+	kept := make([]uidExt, 0, len(uids))
+	for _, msg := range uids {
+		if msg.msg >= uid(o.SinceUID) {
+			kept = append(kept, msg)
+		}
+	}
+	logInfo(fmt.Sprintf("excluded %d emails with a UID below %d", len(uids)-len(kept), o.SinceUID))
+	return kept
+}
+
+// Sort UIDs in place in the order in which emails shall be downloaded.
+func (o DownloadOptions) order(uids []uid) {
+	if o.NewestFirst {
+		sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+	}
+}
+
+// Determine the maximum number of emails retrieved with a single fetch. Servers return emails in
+// ascending order within a fetch. Thus, batches are needed to retrieve the newest emails first.
+func (o DownloadOptions) batchSize() int {
+	if o.NewestFirst {
+		return newestFirstBatchSize
+	}
+	return 0
+}
@@ -0,0 +1,663 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadOptionsNewStorerDefault(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+	oldmails := []oldmail{{uidFolder: 42, uid: 1}}
+
+	storer, err := DownloadOptions{}.newStorer(maildirPath, oldmails)
+
+	assert.NoError(t, err)
+	assert.Equal(t, newMaildirStorer("/some/base/folder", oldmails), storer)
+}
+
+func TestDownloadOptionsNewStorerCustom(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+	ms := &mockStorer{}
+	opts := DownloadOptions{
+		NewStorer: func(folder string) (Storer, error) {
+			assert.Equal(t, "folder", folder)
+			return ms, fmt.Errorf("some error")
+		},
+	}
+
+	storer, err := opts.newStorer(maildirPath, nil)
+
+	assert.Error(t, err)
+	assert.Equal(t, ms, storer)
+}
+
+func TestDownloadOptionsFetchItemsDefault(t *testing.T) {
+	items := DownloadOptions{}.fetchItems()
+
+	assert.Equal(
+		t, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822}, items,
+	)
+}
+
+func TestDownloadOptionsFetchItemsEntireBody(t *testing.T) {
+	items := DownloadOptions{FetchEntireBody: true}.fetchItems()
+
+	assert.Equal(
+		t, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, "BODY.PEEK[]"}, items,
+	)
+}
+
+func TestDownloadOptionsFetchItemsHeadersOnly(t *testing.T) {
+	items := DownloadOptions{HeadersOnly: true}.fetchItems()
+
+	assert.Equal(
+		t,
+		[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, "BODY.PEEK[HEADER]"},
+		items,
+	)
+}
+
+func TestDownloadOptionsFetchItemsGmailLabels(t *testing.T) {
+	// Gmail's fetch items are only requested from Gmail's servers.
+	assert.Equal(
+		t,
+		[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822},
+		DownloadOptions{GmailLabels: true}.fetchItems(),
+	)
+	assert.Equal(
+		t,
+		[]imap.FetchItem{
+			imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822,
+			"X-GM-MSGID", "X-GM-THRID", "X-GM-LABELS",
+		},
+		DownloadOptions{GmailLabels: true, gmail: true}.fetchItems(),
+	)
+}
+
+func TestDownloadOptionsNewStorerGmailLabels(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+
+	storer, err := DownloadOptions{GmailLabels: true}.newStorer(maildirPath, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &maildirStorer{}, storer)
+
+	storer, err = DownloadOptions{GmailLabels: true, gmail: true}.newStorer(maildirPath, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &multiStorer{}, storer)
+}
+
+func TestDownloadOptionsNewStorerArchive(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+
+	storer, err := DownloadOptions{Archive: true}.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	assert.IsType(t, &archiveStorer{}, storer)
+}
+
+func TestDownloadOptionsOrder(t *testing.T) {
+	uids := []uid{1, 3, 2}
+	DownloadOptions{}.order(uids)
+	assert.Equal(t, []uid{1, 3, 2}, uids)
+	assert.Equal(t, 0, DownloadOptions{}.batchSize())
+
+	opts := DownloadOptions{NewestFirst: true}
+	opts.order(uids)
+	assert.Equal(t, []uid{3, 2, 1}, uids)
+	assert.Equal(t, newestFirstBatchSize, opts.batchSize())
+}
+
+func TestDownloadOptionsValidate(t *testing.T) {
+	ms := &mockStorer{}
+
+	validated := DownloadOptions{KeepMalformed: true}.validate(ms)
+
+	assert.Equal(t, &validatingStorer{Storer: ms, keepMalformed: true}, validated)
+}
+
+func TestDownloadOptionsNewStorerMbox(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+	oldmails := []oldmail{{uidFolder: 42, uid: 1}}
+
+	storer, err := DownloadOptions{Mbox: true}.newStorer(maildirPath, oldmails)
+
+	assert.NoError(t, err)
+	multi, ok := storer.(*multiStorer)
+	require.True(t, ok)
+	assert.Equal(t, newMaildirStorer("/some/base/folder", oldmails), multi.Storer)
+	require.Len(t, multi.additional, 1)
+	assert.Equal(t, newMboxStorer("/some/base/folder", oldmails), multi.additional[0].storer)
+	assert.NoError(t, multi.Close())
+}
+
+func TestDownloadOptionsNewStorerAdditional(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+	ms := &mockStorer{}
+	opts := DownloadOptions{
+		AdditionalStorers: []func(string) (Storer, error){
+			func(folder string) (Storer, error) {
+				assert.Equal(t, "folder", folder)
+				return ms, nil
+			},
+		},
+	}
+
+	storer, err := opts.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	multi, ok := storer.(*multiStorer)
+	require.True(t, ok)
+	require.Len(t, multi.additional, 1)
+	assert.Equal(t, ms, multi.additional[0].storer)
+	assert.NoError(t, multi.Close())
+}
+
+func TestDownloadOptionsNewStorerAdditionalError(t *testing.T) {
+	maildirPath := maildirPathT{base: "/some/base", folder: "folder"}
+	opts := DownloadOptions{
+		AdditionalStorers: []func(string) (Storer, error){
+			func(string) (Storer, error) { return nil, fmt.Errorf("some error") },
+		},
+	}
+
+	_, err := opts.newStorer(maildirPath, nil)
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestDownloadOptionsFilterBySize(t *testing.T) {
+	uids := []uidExt{
+		{folder: 42, msg: 1, size: 10},
+		{folder: 42, msg: 2, size: 20},
+		{folder: 42, msg: 3, size: 30},
+	}
+
+	assert.Equal(t, uids, DownloadOptions{}.filterBySize(uids))
+	assert.Equal(t, uids[1:], DownloadOptions{MinSize: 20}.filterBySize(uids))
+	assert.Equal(t, uids[:2], DownloadOptions{MaxSize: 20}.filterBySize(uids))
+	assert.Equal(t, uids[1:2], DownloadOptions{MinSize: 20, MaxSize: 20}.filterBySize(uids))
+	assert.Empty(t, DownloadOptions{MinSize: 31}.filterBySize(uids))
+}
+
+func TestDownloadOptionsFilterSinceUID(t *testing.T) {
+	uids := []uidExt{{folder: 42, msg: 1}, {folder: 42, msg: 2}, {folder: 42, msg: 3}}
+
+	assert.Equal(t, uids, DownloadOptions{}.filterSinceUID(uids, 42))
+	assert.Equal(t, uids[1:], DownloadOptions{SinceUID: 2}.filterSinceUID(uids, 42))
+	assert.Empty(t, DownloadOptions{SinceUID: 4}.filterSinceUID(uids, 42))
+	opts := DownloadOptions{SinceUID: 2, SinceUIDValidity: 42}
+	assert.Equal(t, uids[1:], opts.filterSinceUID(uids, 42))
+	// Nothing is excluded if the UIDVALIDITY changed.
+	opts.SinceUIDValidity = 41
+	assert.Equal(t, uids, opts.filterSinceUID(uids, 42))
+
+	assert.True(t, DownloadOptions{SinceUID: 2}.excludesEmails())
+	assert.False(t, DownloadOptions{}.excludesEmails())
+}
+
+func TestDownloadOptionsCheckSinceUID(t *testing.T) {
+	assert.NoError(t, DownloadOptions{SinceUID: 2}.check())
+	assert.NoError(t, DownloadOptions{SinceUID: 2, SinceUIDValidity: 42}.check())
+
+	assert.Error(t, DownloadOptions{SinceUID: -1}.check())
+	assert.Error(t, DownloadOptions{SinceUID: 2, SinceUIDValidity: -1}.check())
+	assert.Error(t, DownloadOptions{SinceUIDValidity: 42}.check())
+}
+
+func TestDownloadOptionsCheckSeqRange(t *testing.T) {
+	assert.NoError(t, DownloadOptions{SeqStart: 100, SeqEnd: 200}.check())
+	assert.NoError(t, DownloadOptions{SeqStart: 7, SeqEnd: 7}.check())
+	assert.True(t, DownloadOptions{SeqStart: 100, SeqEnd: 200}.excludesEmails())
+
+	assert.Error(t, DownloadOptions{SeqStart: 100}.check())
+	assert.Error(t, DownloadOptions{SeqEnd: 200}.check())
+	assert.Error(t, DownloadOptions{SeqStart: 200, SeqEnd: 100}.check())
+	assert.Error(t, DownloadOptions{SeqStart: -1, SeqEnd: 100}.check())
+	assert.ErrorContains(
+		t, DownloadOptions{SeqStart: 1, SeqEnd: 2, Mirror: true}.check(), "cannot mirror",
+	)
+	assert.ErrorContains(
+		t, DownloadOptions{SeqStart: 1, SeqEnd: 2, UIDFile: "uids.txt"}.check(),
+		"reading UIDs from a file",
+	)
+}
+
+func TestDownloadOptionsFetchItemsMetadata(t *testing.T) {
+	items := DownloadOptions{FetchPreset: FetchPresetMetadata}.fetchItems()
+
+	assert.Equal(
+		t,
+		[]imap.FetchItem{
+			imap.FetchUid, imap.FetchInternalDate, imap.FetchFlags, imap.FetchRFC822Size,
+			"BODY.PEEK[HEADER.FIELDS (From To Cc Subject Date Message-ID)]",
+		},
+		items,
+	)
+}
+
+func TestDownloadOptionsFetchItemsBodyStructure(t *testing.T) {
+	opts := DownloadOptions{FetchPreset: FetchPresetMetadata, BodyStructure: true}
+
+	items := opts.fetchItems()
+
+	assert.Equal(
+		t,
+		[]imap.FetchItem{
+			imap.FetchUid, imap.FetchInternalDate, imap.FetchFlags, imap.FetchRFC822Size,
+			"BODY.PEEK[HEADER.FIELDS (From To Cc Subject Date Message-ID)]",
+			imap.FetchBodyStructure,
+		},
+		items,
+	)
+}
+
+func TestDownloadOptionsNewStorerBodyStructure(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildirPath := maildirPathT{base: tmpdir, folder: "folder"}
+
+	storer, err := DownloadOptions{BodyStructure: true}.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	multi := storer.(*multiStorer)
+	assert.Len(t, multi.additional, 1)
+	assert.NoError(t, closeStorer(storer))
+}
+
+func TestDownloadOptionsFetchItemsAdditional(t *testing.T) {
+	opts := DownloadOptions{FetchItems: []imap.FetchItem{imap.FetchFlags, imap.FetchUid}}
+
+	items := opts.fetchItems()
+
+	assert.Equal(
+		t,
+		[]imap.FetchItem{
+			imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822, imap.FetchFlags,
+		},
+		items,
+	)
+}
+
+func TestDownloadOptionsCheckFetchItems(t *testing.T) {
+	assert.NoError(t, DownloadOptions{}.checkFetchItems())
+	assert.NoError(t, DownloadOptions{HeadersOnly: true}.checkFetchItems())
+	assert.NoError(
+		t,
+		DownloadOptions{
+			FetchPreset: FetchPresetMetadata, FetchItems: []imap.FetchItem{imap.FetchEnvelope},
+		}.checkFetchItems(),
+	)
+
+	assert.Error(t, DownloadOptions{FetchPreset: "unknown"}.checkFetchItems())
+	assert.Error(
+		t, DownloadOptions{HeadersOnly: true, FetchPreset: FetchPresetFull}.checkFetchItems(),
+	)
+	assert.Error(
+		t, DownloadOptions{FetchItems: []imap.FetchItem{"BODY[1]"}}.checkFetchItems(),
+	)
+}
+
+func TestDownloadOptionsCheckMoveTo(t *testing.T) {
+	assert.NoError(t, DownloadOptions{MoveTo: "Archived"}.check())
+
+	assert.Error(t, DownloadOptions{MoveTo: "Archived", Mirror: true}.check())
+	assert.Error(t, DownloadOptions{MoveTo: "Archived", HeadersOnly: true}.check())
+}
+
+func TestDownloadOptionsCheckDeleteFromServer(t *testing.T) {
+	assert.NoError(t, DownloadOptions{DeleteFromServer: true}.check())
+
+	assert.Error(t, DownloadOptions{DeleteFromServer: true, MoveTo: "Archived"}.check())
+	assert.Error(t, DownloadOptions{DeleteFromServer: true, Mirror: true}.check())
+	assert.Error(t, DownloadOptions{DeleteFromServer: true, HeadersOnly: true}.check())
+}
+
+func TestDownloadOptionsCheckRemoveFiltered(t *testing.T) {
+	filter := regexp.MustCompile(`@example\.com$`)
+	assert.NoError(t, DownloadOptions{AddressFilter: filter}.check())
+	assert.Error(t, DownloadOptions{AddressFilter: filter, MoveTo: "Archived"}.check())
+	assert.Error(t, DownloadOptions{AddressFilter: filter, DeleteFromServer: true}.check())
+}
+
+func TestDownloadOptionsCheckRemovePartial(t *testing.T) {
+	assert.NoError(t, DownloadOptions{MaxPartSize: 1024}.check())
+	assert.Error(t, DownloadOptions{MaxPartSize: 1024, MoveTo: "Archived"}.check())
+	assert.Error(t, DownloadOptions{MaxPartSize: 1024, DeleteFromServer: true}.check())
+}
+
+func TestDownloadOptionsCheckRemoveTransformed(t *testing.T) {
+	transform := func(content []byte) ([]byte, error) { return content, nil }
+
+	assert.NoError(t, DownloadOptions{Transform: transform}.check())
+	assert.Error(t, DownloadOptions{Transform: transform, MoveTo: "Archived"}.check())
+	assert.Error(t, DownloadOptions{Transform: transform, DeleteFromServer: true}.check())
+}
+
+func TestDownloadOptionsCheckRemoveLineEndingLF(t *testing.T) {
+	assert.NoError(t, DownloadOptions{LineEnding: LineEndingLF}.check())
+	assert.NoError(t, DownloadOptions{LineEnding: LineEndingCRLF, MoveTo: "Archived"}.check())
+	assert.Error(t, DownloadOptions{LineEnding: LineEndingLF, MoveTo: "Archived"}.check())
+	assert.Error(t, DownloadOptions{LineEnding: LineEndingLF, DeleteFromServer: true}.check())
+}
+
+func TestDownloadOptionsCheckFileNaming(t *testing.T) {
+	assert.NoError(t, DownloadOptions{FileNaming: FileNamingUnique}.check())
+	assert.NoError(t, DownloadOptions{FileNaming: FileNamingUID}.check())
+
+	assert.Error(t, DownloadOptions{FileNaming: "random"}.check())
+	assert.Error(t, DownloadOptions{FileNaming: FileNamingUID, Archive: true}.check())
+}
+
+func setUpNumCPU(t *testing.T, cpus int) {
+	orgNumCPU := numCPU
+	t.Cleanup(func() { numCPU = orgNumCPU })
+	numCPU = func() int { return cpus }
+}
+
+func TestDownloadOptionsDownloadThreads(t *testing.T) {
+	setUpNumCPU(t, 2)
+
+	for _, tc := range []struct {
+		opts     DownloadOptions
+		threads  int
+		expected int
+	}{
+		{DownloadOptions{}, 1, 1},
+		{DownloadOptions{}, 3, 3},
+		{DownloadOptions{}, DefaultMaxThreads, DefaultMaxThreads},
+		{DownloadOptions{}, DefaultMaxThreads + 1, DefaultMaxThreads},
+		{DownloadOptions{MaxThreads: 2}, 3, 2},
+		{DownloadOptions{MaxThreads: 20}, 15, 15},
+		// The default depends on the number of CPUs.
+		{DownloadOptions{}, 0, 2},
+		{DownloadOptions{MaxThreads: 1}, 0, 1},
+		// With automatic tuning, the maximum is used by default.
+		{DownloadOptions{AutoThreads: true}, 0, DefaultMaxThreads},
+		{DownloadOptions{AutoThreads: true, MaxThreads: 3}, 0, 3},
+	} {
+		threads, err := tc.opts.downloadThreads(tc.threads)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, threads, "%+v with %d threads", tc.opts, tc.threads)
+	}
+}
+
+func TestDownloadOptionsDownloadThreadsDefaultCapped(t *testing.T) {
+	setUpNumCPU(t, 64)
+
+	threads, err := DownloadOptions{}.downloadThreads(0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, defaultThreads, threads)
+}
+
+func TestDownloadOptionsDownloadThreadsInvalid(t *testing.T) {
+	_, err := DownloadOptions{}.downloadThreads(-1)
+	assert.ErrorContains(t, err, "number of download threads must be positive, got -1")
+
+	_, err = DownloadOptions{MaxThreads: -1}.downloadThreads(1)
+	assert.ErrorContains(t, err, "maximum number of download threads must be positive")
+}
+
+func TestDownloadOptionsCheckLayout(t *testing.T) {
+	assert.NoError(t, DownloadOptions{Layout: LayoutMaildir, Mirror: true}.check())
+	assert.NoError(t, DownloadOptions{Layout: LayoutDate}.check())
+
+	assert.Error(t, DownloadOptions{Layout: "random"}.check())
+	assert.Error(t, DownloadOptions{Layout: LayoutDate, Mirror: true}.check())
+	assert.Error(t, DownloadOptions{Layout: LayoutDate, Archive: true}.check())
+}
+
+func TestDownloadOptionsNewStorerLayoutDate(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildirPath := maildirPathT{base: tmpdir, folder: "folder"}
+
+	storer, err := DownloadOptions{Layout: LayoutDate}.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	assert.True(t, storer.(*maildirStorer).byDate)
+}
+
+func TestDownloadOptionsCheckFileNameTemplate(t *testing.T) {
+	assert.NoError(t, DownloadOptions{FileNameTemplate: "{{.UID}}.eml"}.check())
+
+	assert.Error(t, DownloadOptions{FileNameTemplate: "{{.UID"}.check())
+	assert.Error(t, DownloadOptions{
+		FileNameTemplate: "{{.UID}}.eml", FileNaming: FileNamingUID,
+	}.check())
+	assert.Error(t, DownloadOptions{FileNameTemplate: "{{.UID}}.eml", Archive: true}.check())
+}
+
+func TestDownloadOptionsNewStorerFileNameTemplate(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildirPath := maildirPathT{base: tmpdir, folder: "folder"}
+
+	storer, err := DownloadOptions{FileNameTemplate: "{{.UID}}"}.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, storer.(*maildirStorer).nameTemplate)
+}
+
+func TestDownloadOptionsCheckFolderThreads(t *testing.T) {
+	assert.NoError(t, DownloadOptions{FolderThreads: map[string]int{"INBOX": 1}}.check())
+	assert.NoError(t, DownloadOptions{
+		FolderThreads: map[string]int{"INBOX": DefaultMaxThreads},
+	}.check())
+	assert.NoError(t, DownloadOptions{
+		FolderThreads: map[string]int{"INBOX": 3}, MaxThreads: 3,
+	}.check())
+
+	assert.Error(t, DownloadOptions{FolderThreads: map[string]int{"INBOX": 0}}.check())
+	assert.Error(t, DownloadOptions{
+		FolderThreads: map[string]int{"INBOX": 4}, MaxThreads: 3,
+	}.check())
+}
+
+func TestDownloadOptionsCheckOnlyChanged(t *testing.T) {
+	assert.NoError(t, DownloadOptions{OnlyChanged: true}.check())
+
+	assert.Error(t, DownloadOptions{OnlyChanged: true, UIDFile: "uids.txt"}.check())
+	assert.Error(t, DownloadOptions{OnlyChanged: true, SeqStart: 1, SeqEnd: 2}.check())
+}
+
+func TestDownloadOptionsCheckReconnectEvery(t *testing.T) {
+	assert.NoError(t, DownloadOptions{ReconnectEvery: 100}.check())
+
+	assert.Error(t, DownloadOptions{ReconnectEvery: -1}.check())
+	assert.Error(t, DownloadOptions{MaxRuntime: -1}.check())
+	assert.Error(t, DownloadOptions{MaxBytesPerSecond: -1}.check())
+	assert.Error(t, DownloadOptions{
+		ReconnectEvery: 100, FolderThreads: map[string]int{"INBOX": 2},
+	}.check())
+}
+
+func TestDownloadOptionsCheckMaxConnections(t *testing.T) {
+	assert.NoError(t, DownloadOptions{MaxConnections: 4}.check())
+	assert.NoError(t, DownloadOptions{MaxConnections: 4, ConnectionSlots: "/some/dir"}.check())
+
+	assert.Error(t, DownloadOptions{MaxConnections: -1}.check())
+	assert.Error(t, DownloadOptions{ConnectionSlots: "/some/dir"}.check())
+}
+
+func TestDownloadOptionsCheckRefresh(t *testing.T) {
+	assert.NoError(t, DownloadOptions{Refresh: true, OnlyChanged: true}.check())
+	assert.Error(t, DownloadOptions{Refresh: true, UIDFile: "uids.txt"}.check())
+	assert.Error(t, DownloadOptions{Refresh: true, FileNaming: FileNamingUID}.check())
+}
+
+func TestDownloadOptionsCheckDedup(t *testing.T) {
+	assert.NoError(t, DownloadOptions{Dedup: DedupHardlink}.check())
+	assert.NoError(t, DownloadOptions{Dedup: DedupSymlink, Layout: LayoutDate}.check())
+
+	assert.Error(t, DownloadOptions{Dedup: "random"}.check())
+	assert.Error(t, DownloadOptions{Dedup: DedupHardlink, Mbox: true}.check())
+	assert.Error(t, DownloadOptions{Dedup: DedupSymlink, Archive: true}.check())
+	assert.NoError(t, DownloadOptions{Dedup: DedupHardlink, Mirror: true}.check())
+	assert.Error(t, DownloadOptions{Dedup: DedupSymlink, Mirror: true}.check())
+}
+
+func TestDownloadOptionsNewStorerDedup(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildirPath := maildirPathT{base: tmpdir, folder: "folder"}
+	hashes := &hashStore{base: tmpdir, link: DedupHardlink, hashes: map[string]string{}}
+
+	storer, err := DownloadOptions{Dedup: DedupHardlink, hashes: hashes}.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, hashes, storer.(*maildirStorer).dedup)
+}
+
+func TestDownloadOptionsCheckFormat(t *testing.T) {
+	assert.NoError(t, DownloadOptions{Format: OutputFormatAuto, Mbox: true}.check())
+	assert.NoError(t, DownloadOptions{Format: OutputFormatMbox}.check())
+	assert.NoError(t, DownloadOptions{Format: OutputFormatMaildir}.check())
+
+	assert.Error(t, DownloadOptions{Format: "random"}.check())
+	assert.Error(t, DownloadOptions{Format: OutputFormatMaildir, Mbox: true}.check())
+}
+
+func TestDownloadOptionsNewStorerFileNamingUID(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildirPath := maildirPathT{base: tmpdir, folder: "folder"}
+
+	storer, err := DownloadOptions{FileNaming: FileNamingUID}.newStorer(maildirPath, nil)
+
+	assert.NoError(t, err)
+	assert.True(t, storer.(*maildirStorer).uidNames)
+
+	// The maildir is inspected right away.
+	maildirPath.folder = "missing"
+	_, err = DownloadOptions{FileNaming: FileNamingUID}.newStorer(maildirPath, nil)
+	assert.Error(t, err)
+}
+
+func TestDownloadOptionsCheckDateFallback(t *testing.T) {
+	for _, fallback := range []DateFallback{
+		"", DateFallbackHeader, DateFallbackNow, DateFallbackNone,
+	} {
+		assert.NoError(t, DownloadOptions{DateFallback: fallback}.check())
+	}
+	assert.Error(t, DownloadOptions{DateFallback: "yesterday"}.check())
+}
+
+func TestDownloadOptionsCheckMaildirSubdir(t *testing.T) {
+	for _, subdir := range []MaildirSubdir{
+		"", MaildirSubdirAuto, MaildirSubdirCur, MaildirSubdirNew,
+	} {
+		assert.NoError(t, DownloadOptions{MaildirSubdir: subdir}.check())
+	}
+	assert.Error(t, DownloadOptions{MaildirSubdir: "tmp"}.check())
+	assert.NoError(t, DownloadOptions{MaildirSubdir: MaildirSubdirCur, MaildirFlags: true}.check())
+	assert.Error(t, DownloadOptions{MaildirSubdir: MaildirSubdirNew, MaildirFlags: true}.check())
+
+	opts := DownloadOptions{MaildirSubdir: MaildirSubdirCur}
+	storer, err := opts.newPrimaryStorer(maildirPathT{base: t.TempDir(), folder: "INBOX"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, MaildirSubdirCur, storer.(*maildirStorer).subdir)
+}
+
+func TestDownloadOptionsLineEnding(t *testing.T) {
+	assert.NoError(t, DownloadOptions{LineEnding: LineEndingCRLF}.check())
+	assert.NoError(t, DownloadOptions{LineEnding: LineEndingLF}.check())
+	assert.Error(t, DownloadOptions{LineEnding: "cr"}.check())
+
+	storer := &mockStorer{}
+	assert.Equal(t, storer, DownloadOptions{}.convertLineEndings(storer))
+	assert.Equal(t, storer, DownloadOptions{LineEnding: LineEndingCRLF}.convertLineEndings(storer))
+	assert.Equal(
+		t, &lineEndingStorer{Storer: storer},
+		DownloadOptions{LineEnding: LineEndingLF}.convertLineEndings(storer),
+	)
+}
+
+func TestDownloadOptionsHostID(t *testing.T) {
+	assert.NoError(t, DownloadOptions{HostID: "host-1"}.check())
+	assert.Error(t, DownloadOptions{HostID: "host 1"}.check())
+
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	maildirPath := maildirPathT{base: tmpdir, folder: "folder"}
+
+	storer, err := DownloadOptions{HostID: "host-1"}.newStorer(maildirPath, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "host-1", storer.(*maildirStorer).hostID)
+
+	storer, err = DownloadOptions{HostID: "host-1", Archive: true}.newStorer(maildirPath, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "host-1", storer.(*archiveStorer).hostID)
+}
+
+func TestDownloadOptionsManifest(t *testing.T) {
+	assert.NoError(t, DownloadOptions{Manifest: true, CompressManifest: true}.check())
+	assert.Error(t, DownloadOptions{CompressManifest: true}.check())
+
+	// Flags are retrieved for the manifest, but only once.
+	items := DownloadOptions{Manifest: true}.fetchItems()
+	assert.Equal(
+		t,
+		[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822, imap.FetchFlags},
+		items,
+	)
+	items = DownloadOptions{Manifest: true, FetchPreset: FetchPresetMetadata}.fetchItems()
+	assert.Equal(t, 5, len(items))
+}
+
+func TestDownloadOptionsFileNameMetadata(t *testing.T) {
+	items := DownloadOptions{FileNameMetadata: true}.fetchItems()
+	assert.Equal(
+		t,
+		[]imap.FetchItem{
+			imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822, imap.FetchRFC822Size,
+		},
+		items,
+	)
+	// The metadata preset retrieves the size already.
+	items = DownloadOptions{FileNameMetadata: true, FetchPreset: FetchPresetMetadata}.fetchItems()
+	assert.Equal(t, 5, len(items))
+}
+
+func TestDownloadOptionsMaildirFlags(t *testing.T) {
+	items := DownloadOptions{MaildirFlags: true, Manifest: true}.fetchItems()
+	assert.Equal(
+		t,
+		[]imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchRFC822, imap.FetchFlags},
+		items,
+	)
+
+	opts := DownloadOptions{MaildirFlags: true, draftFolders: map[string]struct{}{"Drafts": {}}}
+	storer, err := opts.newPrimaryStorer(maildirPathT{base: t.TempDir(), folder: "Drafts"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, storer.(*maildirStorer).withFlags)
+	assert.True(t, storer.(*maildirStorer).drafts)
+
+	storer, err = opts.newPrimaryStorer(maildirPathT{base: t.TempDir(), folder: "INBOX"}, nil)
+	assert.NoError(t, err)
+	assert.False(t, storer.(*maildirStorer).drafts)
+}
+
+func TestDownloadOptionsTransform(t *testing.T) {
+	ms := &mockStorer{}
+
+	assert.Equal(t, ms, DownloadOptions{}.transform(ms))
+	assert.IsType(t, &transformingStorer{}, DownloadOptions{Transform: removePixel}.transform(ms))
+}
@@ -0,0 +1,189 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+const multipartPrefix = "multipart/"
+
+// Media types of parts that are always kept when removing large parts.
+var keptMediaTypes = map[string]bool{"text/plain": true, "text/html": true}
+
+// Type partFilterStorer removes large parts, e.g. attachments, from emails before handing them to
+// the underlying storer. Parts of type text/plain and text/html are always kept. Any other part
+// whose encoded size exceeds the threshold is replaced by a short text/plain placeholder. Thus, the
+// stored email remains a valid MIME message. Everything else, including the preambles and
+// epilogues of multipart entities, is kept verbatim. Note that the full email is still retrieved.
+type partFilterStorer struct {
+	Storer
+	maxSize int
+}
+
+// Write removes large parts from an email and stores the rest. Emails that cannot be parsed are
+// stored unchanged.
+func (s *partFilterStorer) Write(info EmailInfo, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	var filtered bytes.Buffer
+	if err := removeLargeParts(&filtered, bytes.NewReader(data), s.maxSize); err != nil {
+		logWarning(fmt.Sprintf("storing email %s unchanged: %s", info.Key, err.Error()))
+		return s.Storer.Write(info, bytes.NewReader(data))
+	}
+	return s.Storer.Write(info, &filtered)
+}
+
+// Write an email to a writer, replacing large parts other than text by placeholders.
+func removeLargeParts(w io.Writer, content io.Reader, maxSize int) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	filtered, err := filterEntity(data, maxSize, false)
+	if err == nil {
+		_, err = w.Write(filtered)
+	}
+	return err
+}
+
+// Filter an entity, i.e. a header followed by a body. For multipart entities, each part is filtered
+// recursively. Other entities are replaced by a placeholder if they may be removed and are too
+// large. The header is kept verbatim.
+func filterEntity(entity []byte, maxSize int, removable bool) ([]byte, error) {
+	header, headerLen, err := readRawHeader(entity)
+	if err != nil {
+		return nil, err
+	}
+	body := entity[headerLen:]
+	mediaType, params := mediaTypeOf(header)
+	isMultipart := strings.HasPrefix(mediaType, multipartPrefix)
+	switch {
+	case isMultipart && params["boundary"] != "":
+		filtered, err := filterMultipart(body, params["boundary"], maxSize)
+		if err != nil {
+			return nil, err
+		}
+		return append(entity[:headerLen:headerLen], filtered...), nil
+	case removable && !isMultipart && !keptMediaTypes[mediaType] && len(body) > maxSize:
+		return placeholderPart(header, mediaType, len(body))
+	default:
+		return entity, nil
+	}
+}
+
+// Parse the header of an entity and determine its length including the empty line ending it.
+func readRawHeader(entity []byte) (textproto.Header, int, error) {
+	source := bytes.NewReader(entity)
+	reader := bufio.NewReader(source)
+	header, err := textproto.ReadHeader(reader)
+	return header, len(entity) - source.Len() - reader.Buffered(), err
+}
+
+// Filter the body of a multipart entity with the given boundary. Only the parts themselves are
+// filtered. The preamble, the delimiter lines, and the epilogue are kept verbatim. As per RFC 2046,
+// the line break before a delimiter line belongs to the delimiter, not to the preceding part.
+func filterMultipart(body []byte, boundary string, maxSize int) ([]byte, error) {
+	delimiter := []byte("--" + boundary)
+	result := make([]byte, 0, len(body))
+	partStart := -1 // Negative while in the preamble.
+	for offset := 0; offset < len(body); {
+		lineEnd := len(body)
+		if idx := bytes.IndexByte(body[offset:], '\n'); idx >= 0 {
+			lineEnd = offset + idx + 1
+		}
+		line := body[offset:lineEnd]
+		isDelimiter, isClosing := parseDelimiter(line, delimiter)
+		if isDelimiter {
+			if partStart < 0 {
+				result = append(result, body[:offset]...)
+			} else {
+				part, lineBreak := splitLineBreak(body[partStart:offset])
+				filtered, err := filterEntity(part, maxSize, true)
+				if err != nil {
+					return nil, err
+				}
+				result = append(append(result, filtered...), lineBreak...)
+			}
+			result = append(result, line...)
+			if isClosing {
+				return append(result, body[lineEnd:]...), nil
+			}
+			partStart = lineEnd
+		}
+		offset = lineEnd
+	}
+	return nil, fmt.Errorf("missing closing delimiter for boundary %s", boundary)
+}
+
+// Determine whether a line is a delimiter line for a boundary, given as "--" followed by the
+// boundary, and whether it is the closing one. Delimiter lines may end in linear whitespace.
+func parseDelimiter(line, delimiter []byte) (isDelimiter bool, isClosing bool) {
+	rest, found := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), delimiter)
+	if !found {
+		return false, false
+	}
+	rest, isClosing = bytes.CutPrefix(rest, []byte("--"))
+	return len(bytes.Trim(rest, " \t")) == 0, isClosing
+}
+
+// Split the line break off the end of a part.
+func splitLineBreak(part []byte) ([]byte, []byte) {
+	for _, lineBreak := range []string{"\r\n", "\n"} {
+		if bytes.HasSuffix(part, []byte(lineBreak)) {
+			return part[:len(part)-len(lineBreak)], part[len(part)-len(lineBreak):]
+		}
+	}
+	return part, nil
+}
+
+// Create a text/plain part that describes a part that has been removed.
+func placeholderPart(org textproto.Header, mediaType string, size int) ([]byte, error) {
+	description := fmt.Sprintf("type %s, %d bytes", mediaType, size)
+	if _, params, err := mime.ParseMediaType(org.Get("Content-Disposition")); err == nil &&
+		params["filename"] != "" {
+		description = fmt.Sprintf("%s, file name %s", description, params["filename"])
+	}
+	header := textproto.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "8bit")
+	var part bytes.Buffer
+	if err := textproto.WriteHeader(&part, header); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&part, "[go-imapgrab removed a part of this email: %s]\r\n", description)
+	return part.Bytes(), nil
+}
+
+// Determine the media type of an entity. As per RFC 2045, it defaults to text/plain.
+func mediaTypeOf(header textproto.Header) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return "text/plain", nil
+	}
+	return strings.ToLower(mediaType), params
+}
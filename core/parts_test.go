@@ -0,0 +1,189 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartEmail = "From: someone@example.com\r\n" +
+	"Subject: attachments\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"some long plain text that is kept\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>some long html text that is kept</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=large.pdf\r\n" +
+	"\r\n" +
+	"a large attachment\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"\r\n" +
+	"small\r\n" +
+	"--outer--\r\n"
+
+func TestRemoveLargeParts(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := removeLargeParts(&buf, strings.NewReader(multipartEmail), 10)
+	require.NoError(t, err)
+
+	// The result must still be a valid MIME message.
+	reader, err := mail.CreateReader(&buf)
+	require.NoError(t, err)
+	subject, err := reader.Header.Subject()
+	assert.NoError(t, err)
+	assert.Equal(t, "attachments", subject)
+
+	bodies := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(part.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(data))
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"some long plain text that is kept",
+			"<p>some long html text that is kept</p>",
+			"[go-imapgrab removed a part of this email: " +
+				"type application/pdf, 18 bytes, file name large.pdf]\r\n",
+			"small",
+		},
+		bodies,
+	)
+}
+
+func TestRemoveLargePartsKeepsPreambleAndEpilogue(t *testing.T) {
+	email := "Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"This is a multi-part message in MIME format.\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"kept as is\r\n" +
+		"--b \r\n" +
+		"Content-Type: application/zip\r\n" +
+		"\r\n" +
+		"a large attachment\r\n" +
+		"--b--\r\n" +
+		"An epilogue that nobody reads.\r\n"
+	var buf bytes.Buffer
+
+	err := removeLargeParts(&buf, strings.NewReader(email), 10)
+
+	assert.NoError(t, err)
+	// Only the large part changes, everything else is kept byte by byte.
+	expected := strings.Replace(
+		email,
+		"Content-Type: application/zip\r\n\r\na large attachment",
+		"Content-Transfer-Encoding: 8bit\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n"+
+			"[go-imapgrab removed a part of this email: type application/zip, 18 bytes]\r\n",
+		1,
+	)
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRemoveLargePartsSinglePart(t *testing.T) {
+	email := "Subject: plain\r\nContent-Type: application/pdf\r\n\r\nnot removed at top level"
+	var buf bytes.Buffer
+
+	err := removeLargeParts(&buf, strings.NewReader(email), 1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, email, buf.String())
+}
+
+func TestRemoveLargePartsBrokenMultipart(t *testing.T) {
+	email := "Content-Type: multipart/mixed; boundary=b\r\n\r\nno parts at all"
+	var buf bytes.Buffer
+
+	err := removeLargeParts(&buf, strings.NewReader(email), 1)
+
+	assert.Error(t, err)
+}
+
+func TestPartFilterStorerWrite(t *testing.T) {
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, mock.AnythingOfType("string")).Return(nil)
+	storer := DownloadOptions{MaxPartSize: 10}.filterParts(ms)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(multipartEmail))
+
+	assert.NoError(t, err)
+	written := ms.Calls[0].Arguments.String(1)
+	assert.NotContains(t, written, "a large attachment")
+	assert.Contains(t, written, "some long plain text that is kept")
+	ms.AssertExpectations(t)
+}
+
+func TestPartFilterStorerWriteUnparsable(t *testing.T) {
+	email := "Content-Type: multipart/mixed; boundary=b\r\n\r\nno parts at all"
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, email).Return(nil)
+	storer := DownloadOptions{MaxPartSize: 10}.filterParts(ms)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader(email))
+
+	assert.NoError(t, err)
+	ms.AssertExpectations(t)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("some read error")
+}
+
+func TestPartFilterStorerWriteReadError(t *testing.T) {
+	storer := DownloadOptions{MaxPartSize: 10}.filterParts(&mockStorer{})
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, failingReader{})
+
+	assert.ErrorContains(t, err, "some read error")
+}
+
+func TestDownloadOptionsFilterPartsDisabled(t *testing.T) {
+	ms := &mockStorer{}
+	assert.Equal(t, ms, DownloadOptions{}.filterParts(ms))
+}
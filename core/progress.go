@@ -0,0 +1,144 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const bytesPerKiB = 1024
+
+// Function now is used to determine the current time. It is a variable to simplify testing.
+var now = time.Now
+
+// Type progress tracks the throughput of a download and estimates the time remaining.
+type progress struct {
+	folder   string
+	total    int
+	start    time.Time
+	messages int
+	bytes    int
+	sync.Mutex
+}
+
+func newProgress(folder string, total int) *progress {
+	return &progress{folder: folder, total: total, start: now()}
+}
+
+func (p *progress) add(bytes int) {
+	p.Lock()
+	defer p.Unlock()
+	p.messages++
+	p.bytes += bytes
+}
+
+// Provide the number of bytes written so far.
+func (p *progress) transferred() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.bytes
+}
+
+// Provide a human-readable report about the current state of the download.
+func (p *progress) String() string {
+	p.Lock()
+	defer p.Unlock()
+	elapsed := now().Sub(p.start).Seconds()
+	eta := "unknown"
+	var msgRate, byteRate float64
+	if elapsed > 0 {
+		msgRate = float64(p.messages) / elapsed
+		byteRate = float64(p.bytes) / elapsed
+	}
+	if msgRate > 0 {
+		remaining := time.Duration(float64(p.total-p.messages) / msgRate * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+	return fmt.Sprintf(
+		"progress for %s: %d/%d emails, %.1f emails/s, %s/s, ETA %s",
+		p.folder, p.messages, p.total, msgRate, formatBytes(byteRate), eta,
+	)
+}
+
+// Periodically log the progress until the returned function is called. That function returns
+// only after logging has stopped.
+func (p *progress) startLogging(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-ticker.C:
+				logInfo(p.String())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-exited
+	}
+}
+
+// Wrap a storer such that the size of every email written is tracked.
+func (p *progress) wrap(storer Storer) Storer {
+	return &progressStorer{Storer: storer, progress: p}
+}
+
+type progressStorer struct {
+	Storer
+	progress *progress
+}
+
+func (s *progressStorer) Write(info EmailInfo, content io.Reader) error {
+	counter := &countingReader{reader: content}
+	err := s.Storer.Write(info, counter)
+	if err == nil {
+		s.progress.add(counter.count)
+	}
+	return err
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.reader.Read(buf)
+	r.count += n
+	return n, err
+}
+
+// Format a number of bytes in a human-readable way using binary prefixes.
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	idx := 0
+	for bytes >= bytesPerKiB && idx < len(units)-1 {
+		bytes /= bytesPerKiB
+		idx++
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[idx])
+}
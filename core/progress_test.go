@@ -0,0 +1,130 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpMockNow(t *testing.T, times ...time.Time) {
+	orgNow := now
+	idx := 0
+	now = func() time.Time {
+		current := times[idx]
+		if idx < len(times)-1 {
+			idx++
+		}
+		return current
+	}
+	t.Cleanup(func() { now = orgNow })
+}
+
+func TestProgressString(t *testing.T) {
+	start := time.Now()
+	setUpMockNow(t, start, start.Add(10*time.Second))
+
+	prog := newProgress("folder", 10)
+	prog.add(2048)
+	prog.add(3072)
+
+	assert.Equal(
+		t, "progress for folder: 2/10 emails, 0.2 emails/s, 512.0 B/s, ETA 40s", prog.String(),
+	)
+}
+
+func TestProgressStringNothingYet(t *testing.T) {
+	start := time.Now()
+	setUpMockNow(t, start)
+
+	prog := newProgress("folder", 10)
+
+	assert.Equal(
+		t, "progress for folder: 0/10 emails, 0.0 emails/s, 0.0 B/s, ETA unknown", prog.String(),
+	)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "1023.0 B", formatBytes(1023))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "2.0 MiB", formatBytes(2*1024*1024))
+	assert.Equal(t, "4096.0 GiB", formatBytes(4096*1024*1024*1024))
+}
+
+func TestProgressStorerWrite(t *testing.T) {
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/1"}, "content").Return(nil)
+	ms.On("Write", EmailInfo{Key: "42/2"}, "other").Return(fmt.Errorf("some error"))
+	prog := newProgress("folder", 2)
+	storer := prog.wrap(ms)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("content"))
+	assert.NoError(t, err)
+	err = storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("other"))
+	assert.Error(t, err)
+
+	// Only successfully written emails count.
+	assert.Equal(t, 1, prog.messages)
+	assert.Equal(t, len("content"), prog.bytes)
+	ms.AssertExpectations(t)
+}
+
+func TestProgressStartLogging(t *testing.T) {
+	buf, cleanUp := setUpLogTest()
+	defer cleanUp()
+	SetVerboseLogs(true)
+
+	prog := newProgress("folder", 1)
+	stop := prog.startLogging(time.Millisecond)
+	time.Sleep(10 * time.Millisecond) // nolint: gomnd
+	stop()
+
+	assert.Contains(t, buf.String(), "INFO progress for folder: 0/1 emails")
+}
+
+func TestDownloadOptionsTrackProgressDisabled(t *testing.T) {
+	buf, cleanUp := setUpLogTest()
+	defer cleanUp()
+	SetVerboseLogs(true)
+	ms := &mockStorer{}
+
+	storer, done := DownloadOptions{}.trackProgress("folder", 1, ms)
+	done()
+
+	assert.Equal(t, ms, storer)
+	assert.Empty(t, buf.String())
+}
+
+func TestDownloadOptionsTrackProgressEnabled(t *testing.T) {
+	buf, cleanUp := setUpLogTest()
+	defer cleanUp()
+	SetVerboseLogs(true)
+	ms := &mockStorer{}
+	opts := DownloadOptions{ProgressInterval: time.Hour}
+
+	storer, done := opts.trackProgress("folder", 1, ms)
+	done()
+
+	assert.IsType(t, &progressStorer{}, storer)
+	// A final report is logged when done.
+	assert.Contains(t, buf.String(), "INFO progress for folder: 0/1 emails")
+}
@@ -0,0 +1,120 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// The text replacing credentials in the protocol log.
+	redacted = "[redacted]"
+	// Prefixes of lines in the protocol log sent by the client and by the server, respectively.
+	clientPrefix = "C: "
+	serverPrefix = "S: "
+)
+
+// Type protocolLog writes the raw protocol exchange of a connection line by line to a writer,
+// stating for each line whether the client or the server sent it. Credentials sent with LOGIN and
+// AUTHENTICATE, e.g. passwords and OAuth2 tokens, are redacted. That includes any lines the client
+// sends until the server has completed such a command, e.g. SASL responses and literals. Lines are
+// only written once they are complete, which is why partial lines are buffered.
+type protocolLog struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	// The tag of a command whose completion is pending and whose continuations hold credentials.
+	secretTag string
+}
+
+// Type protocolLogDirection collects the data sent in one direction of a connection.
+type protocolLogDirection struct {
+	log     *protocolLog
+	client  bool
+	pending []byte
+}
+
+// Set up a protocol log for a single connection. Several connections may share the same writer
+// as long as it accepts concurrent writes, e.g. a file.
+func newProtocolLog(writer io.Writer) io.Writer {
+	log := &protocolLog{writer: writer}
+	return imap.NewDebugWriter(
+		&protocolLogDirection{log: log, client: true}, &protocolLogDirection{log: log},
+	)
+}
+
+// Write collects the data and writes all complete lines to the log. Errors writing to the log
+// are ignored and all data are reported as written since the connection would fail otherwise.
+func (d *protocolLogDirection) Write(data []byte) (int, error) {
+	d.log.mutex.Lock()
+	defer d.log.mutex.Unlock()
+	d.pending = append(d.pending, data...)
+	for {
+		end := bytes.IndexByte(d.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := string(d.pending[:end+1])
+		d.pending = d.pending[end+1:]
+		if d.client {
+			_, _ = io.WriteString(d.log.writer, clientPrefix+d.log.redact(line))
+		} else {
+			d.log.observe(line)
+			_, _ = io.WriteString(d.log.writer, serverPrefix+line)
+		}
+	}
+	return len(data), nil
+}
+
+// Redact credentials from a line sent by the client. Lines sent while a command transmitting
+// credentials is pending are redacted in full, apart from the line ending.
+func (l *protocolLog) redact(line string) string {
+	ending := line[len(strings.TrimRight(line, "\r\n")):]
+	if l.secretTag != "" {
+		return redacted + ending
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 { // nolint: gomnd
+		return line
+	}
+	tag, command := fields[0], strings.ToUpper(fields[1])
+	switch {
+	case command == "LOGIN":
+		l.secretTag = tag
+		return strings.Join([]string{tag, fields[1], redacted}, " ") + ending
+	case command == "AUTHENTICATE" && len(fields) > 3: // nolint: gomnd
+		// The mechanism is kept but the initial response holds credentials.
+		l.secretTag = tag
+		return strings.Join([]string{tag, fields[1], fields[2], redacted}, " ") + ending
+	case command == "AUTHENTICATE":
+		l.secretTag = tag
+	}
+	return line
+}
+
+// Observe a line sent by the server to determine when a command transmitting credentials has been
+// completed.
+func (l *protocolLog) observe(line string) {
+	if l.secretTag != "" && strings.HasPrefix(line, l.secretTag+" ") {
+		l.secretTag = ""
+	}
+}
@@ -0,0 +1,168 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type protocolLogTestWriters struct {
+	buf    *bytes.Buffer
+	client io.Writer
+	server io.Writer
+}
+
+func setUpProtocolLog() protocolLogTestWriters {
+	buf := &bytes.Buffer{}
+	log := &protocolLog{writer: buf}
+	return protocolLogTestWriters{
+		buf:    buf,
+		client: &protocolLogDirection{log: log, client: true},
+		server: &protocolLogDirection{log: log},
+	}
+}
+
+func TestProtocolLogRedactsLogin(t *testing.T) {
+	w := setUpProtocolLog()
+
+	_, _ = io.WriteString(w.server, "* OK IMAP4rev1 ready\r\n")
+	_, _ = io.WriteString(w.client, "a1 LOGIN someone \"some password\"\r\n")
+	_, _ = io.WriteString(w.server, "a1 OK logged in\r\n")
+	_, _ = io.WriteString(w.client, "a2 SELECT INBOX\r\n")
+
+	expected := "S: * OK IMAP4rev1 ready\r\n" +
+		"C: a1 LOGIN [redacted]\r\n" +
+		"S: a1 OK logged in\r\n" +
+		"C: a2 SELECT INBOX\r\n"
+	assert.Equal(t, expected, w.buf.String())
+	assert.NotContains(t, w.buf.String(), "some password")
+}
+
+func TestProtocolLogRedactsLoginLiterals(t *testing.T) {
+	w := setUpProtocolLog()
+
+	_, _ = io.WriteString(w.client, "a1 LOGIN someone {13}\r\n")
+	_, _ = io.WriteString(w.server, "+ Ready for literal data\r\n")
+	_, _ = io.WriteString(w.client, "some password\r\n")
+	_, _ = io.WriteString(w.server, "a1 NO wrong credentials\r\n")
+	_, _ = io.WriteString(w.client, "a2 LOGOUT\r\n")
+
+	expected := "C: a1 LOGIN [redacted]\r\n" +
+		"S: + Ready for literal data\r\n" +
+		"C: [redacted]\r\n" +
+		"S: a1 NO wrong credentials\r\n" +
+		"C: a2 LOGOUT\r\n"
+	assert.Equal(t, expected, w.buf.String())
+}
+
+func TestProtocolLogRedactsAuthenticateInitialResponse(t *testing.T) {
+	w := setUpProtocolLog()
+
+	_, _ = io.WriteString(w.client, "a1 AUTHENTICATE XOAUTH2 dXNlcj1zb21lb25lAWF1dGg9QmVhcmVy\r\n")
+	_, _ = io.WriteString(w.server, "a1 OK authenticated\r\n")
+
+	expected := "C: a1 AUTHENTICATE XOAUTH2 [redacted]\r\n" +
+		"S: a1 OK authenticated\r\n"
+	assert.Equal(t, expected, w.buf.String())
+}
+
+func TestProtocolLogRedactsAuthenticateContinuations(t *testing.T) {
+	w := setUpProtocolLog()
+
+	_, _ = io.WriteString(w.client, "a1 AUTHENTICATE PLAIN\r\n")
+	_, _ = io.WriteString(w.server, "+ \r\n")
+	_, _ = io.WriteString(w.client, "AHNvbWVvbmUAc29tZSBwYXNzd29yZA==\r\n")
+	_, _ = io.WriteString(w.server, "a10 unrelated tag\r\n")
+	_, _ = io.WriteString(w.client, "*\r\n")
+	_, _ = io.WriteString(w.server, "a1 BAD authentication cancelled\r\n")
+	_, _ = io.WriteString(w.client, "a2 LOGOUT\r\n")
+
+	expected := "C: a1 AUTHENTICATE PLAIN\r\n" +
+		"S: + \r\n" +
+		"C: [redacted]\r\n" +
+		"S: a10 unrelated tag\r\n" +
+		"C: [redacted]\r\n" +
+		"S: a1 BAD authentication cancelled\r\n" +
+		"C: a2 LOGOUT\r\n"
+	assert.Equal(t, expected, w.buf.String())
+}
+
+func TestProtocolLogBuffersPartialLines(t *testing.T) {
+	w := setUpProtocolLog()
+
+	_, _ = io.WriteString(w.client, "a1 LOGIN some")
+	assert.Empty(t, w.buf.String())
+
+	n, err := io.WriteString(w.client, "one secret\r\na2 NOOP\r\na3")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 23, n)
+	assert.Equal(t, "C: a1 LOGIN [redacted]\r\nC: [redacted]\r\n", w.buf.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestProtocolLogIgnoresWriteErrors(t *testing.T) {
+	writer := &protocolLogDirection{log: &protocolLog{writer: failingWriter{}}, client: true}
+
+	n, err := io.WriteString(writer, "a1 NOOP\r\n")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+}
+
+func TestNewProtocolLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	writer := newProtocolLog(buf)
+
+	// Data written directly are attributed to the client.
+	_, _ = io.WriteString(writer, "a1 NOOP\r\n")
+	assert.Equal(t, "C: a1 NOOP\r\n", buf.String())
+}
+
+func TestAuthenticateClientProtocolLog(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("SetDebug", mock.AnythingOfType("*imap.debugWriter")).Return()
+	m.On("Login", "someone", "some password").Return(nil)
+
+	config := IMAPConfig{User: "someone", Password: "some password", ProtocolLog: &bytes.Buffer{}}
+	_, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+}
+
+func TestAuthenticateClientNoProtocolLog(t *testing.T) {
+	m := setUpMockClient(t, nil, nil, nil)
+	m.On("Login", "someone", "some password").Return(nil)
+
+	config := IMAPConfig{User: "someone", Password: "some password"}
+	_, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	m.AssertNotCalled(t, "SetDebug", mock.Anything)
+}
@@ -0,0 +1,246 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The name of the maildir that pruned emails are moved to instead of deleting them.
+const prunedMaildir = ".pruned"
+
+// PruneOptions configures how emails are pruned from local maildirs via PruneMaildirs.
+type PruneOptions struct {
+	// MaxAge is the age beyond which emails are pruned. It must be positive.
+	MaxAge time.Duration
+	// Trash causes pruned emails to be moved to a separate maildir called ".pruned" within the
+	// folder's maildir instead of deleting them.
+	Trash bool
+	// DryRun causes emails that would be pruned to be reported only.
+	DryRun bool
+}
+
+// PruneResult reports how many emails have been pruned for a folder and how many have been kept.
+type PruneResult struct {
+	Folder string `json:"folder"`
+	Pruned int    `json:"pruned"`
+	Kept   int    `json:"kept"`
+}
+
+// PruneMaildirs removes emails older than the given maximum age from the local maildirs of the
+// given folders, independent of whether they are still present on the server. The maildirs must
+// have been created via DownloadFolder with the same config and base directory. The server is not
+// contacted.
+//
+// The age of an email is determined via the date at which the server received it, which is known
+// for emails whose file names have been remembered. For other emails, the date in their header is
+// used. Emails whose date cannot be determined are kept. Pruned emails remain remembered as
+// downloaded so that they are not downloaded again.
+//
+// Emails below a base directory that contains emails deduplicated via symbolic links, see
+// DedupSymlink, cannot be pruned.
+func PruneMaildirs(
+	cfg IMAPConfig, folders []string, maildirBase string, opts PruneOptions,
+) ([]PruneResult, error) {
+	if opts.MaxAge <= 0 {
+		return nil, fmt.Errorf("maximum age for pruning must be positive")
+	}
+	// Pruning the file of an email would break symbolic links to it, which might be in any folder.
+	linked, err := findSymlinkedEmail(maildirBase)
+	if err != nil {
+		return nil, err
+	}
+	if linked != "" {
+		return nil, fmt.Errorf("cannot prune emails deduplicated via symbolic links such as %s", linked)
+	}
+	cutoff := now().Add(-opts.MaxAge)
+	logInfo(fmt.Sprintf("pruning emails received before %s", cutoff.UTC()))
+	errs := threadSafeErrors{verbose: true}
+	results := []PruneResult{}
+	for _, folder := range folders {
+		maildirPath := maildirPathT{base: maildirBase, folder: decodeFolderName(folder)}
+		result, err := pruneMaildir(cfg, maildirPath, cutoff, opts)
+		errs.add(err)
+		if err == nil {
+			results = append(results, result)
+		}
+	}
+	return results, errs.err()
+}
+
+// Prune a single maildir, see PruneMaildirs.
+func pruneMaildir(
+	cfg IMAPConfig, maildirPath maildirPathT, cutoff time.Time, opts PruneOptions,
+) (PruneResult, error) {
+	result := PruneResult{Folder: maildirPath.folderName()}
+	folderPath := maildirPath.folderPath()
+	if !isMaildir(folderPath) {
+		return result, fmt.Errorf("given directory %s does not point to a maildir", folderPath)
+	}
+	dates, err := receivedDates(cfg, maildirPath)
+	if err != nil {
+		return result, err
+	}
+	pruned := map[string]struct{}{}
+	for _, dir := range []string{curMaildir, newMaildir} {
+		entries, err := os.ReadDir(filepath.Join(folderPath, dir))
+		if err != nil {
+			return result, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(folderPath, dir, entry.Name())
+			date, known := dates[baseFileName(entry.Name())]
+			if !known {
+				date, known = headerDate(path)
+			}
+			if !known {
+				logWarning(fmt.Sprintf("keeping email %s of unknown date", path))
+			}
+			if !known || !date.Before(cutoff) {
+				result.Kept++
+				continue
+			}
+			result.Pruned++
+			if opts.DryRun {
+				logInfo(fmt.Sprintf("would prune email %s from %s", path, date.UTC()))
+				continue
+			}
+			if err := pruneFile(folderPath, dir, entry.Name(), opts.Trash); err != nil {
+				return result, err
+			}
+			pruned[baseFileName(entry.Name())] = struct{}{}
+		}
+	}
+	logInfo(fmt.Sprintf(
+		"pruned %d and kept %d emails of folder %s", result.Pruned, result.Kept, result.Folder,
+	))
+	if len(pruned) > 0 {
+		err = forgetFileNames(folderPath, pruned)
+	}
+	return result, err
+}
+
+// Determine the date at which the server received each email whose file name has been remembered.
+// The dates are keyed by the file names without any info part.
+func receivedDates(cfg IMAPConfig, maildirPath maildirPathT) (map[string]time.Time, error) {
+	oldmailName := strings.ReplaceAll(
+		oldmailFileName(cfg, maildirPath.folderName()), string(os.PathSeparator), ".",
+	)
+	oldmails, err := readOldmail(filepath.Join(maildirPath.basePath(), oldmailName))
+	if err != nil {
+		return nil, err
+	}
+	fileNames, err := readUIDList(maildirPath.folderPath())
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time, len(oldmails))
+	for _, om := range oldmails {
+		if fileName, found := fileNames[om.key()]; found {
+			dates[fileName] = time.Unix(int64(om.timestamp), 0)
+		}
+	}
+	return dates, nil
+}
+
+// Remove the info part with flags, e.g. ":2,S", from a file name in a maildir.
+func baseFileName(fileName string) string {
+	base, _, _ := strings.Cut(fileName, ":")
+	return base
+}
+
+// Determine the date of an email from its header.
+func headerDate(path string) (time.Time, bool) {
+	handle, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer func() { _ = handle.Close() }()
+	msg, err := mail.ReadMessage(handle)
+	if err != nil {
+		return time.Time{}, false
+	}
+	date, err := msg.Header.Date()
+	return date, err == nil
+}
+
+// Delete the file of an email or move it to the maildir for pruned emails.
+func pruneFile(folderPath, dir, fileName string, trash bool) error {
+	source := filepath.Join(folderPath, dir, fileName)
+	if !trash {
+		logInfo(fmt.Sprintf("deleting email %s", source))
+		return os.Remove(source)
+	}
+	prunedPath := filepath.Join(folderPath, prunedMaildir)
+	var err error
+	for _, sub := range []string{newMaildir, curMaildir, tmpMaildir} {
+		if err == nil {
+			err = os.MkdirAll(filepath.Join(prunedPath, sub), dirPerm)
+		}
+	}
+	if err == nil {
+		logInfo(fmt.Sprintf("moving email %s to %s", source, prunedPath))
+		err = os.Rename(source, filepath.Join(prunedPath, dir, fileName))
+	}
+	return err
+}
+
+// Remove the given file names from the list of file names of emails in a maildir since those files
+// no longer exist there.
+func forgetFileNames(folderPath string, fileNames map[string]struct{}) error {
+	files, err := readUIDList(folderPath)
+	if err != nil {
+		return err
+	}
+	for key, fileName := range files {
+		if _, found := fileNames[fileName]; found {
+			delete(files, key)
+		}
+	}
+	err = writeUIDList(folderPath, files)
+	// Manifests list emails that have been downloaded, which pruned ones still are, but pruned
+	// emails no longer have a file.
+	for _, compress := range []bool{false, true} {
+		if err == nil && isFile(manifestPath(folderPath, compress)) {
+			err = forgetManifestPaths(folderPath, fileNames, compress)
+		}
+	}
+	return err
+}
+
+// Remove the paths of the given files from the manifest of a folder.
+func forgetManifestPaths(folderPath string, fileNames map[string]struct{}, compress bool) error {
+	m, err := readManifest(folderPath, compress)
+	if err != nil {
+		return err
+	}
+	for idx, entry := range m.Messages {
+		if _, found := fileNames[baseFileName(filepath.Base(entry.Path))]; found {
+			m.Messages[idx].Path = ""
+		}
+	}
+	return m.write(folderPath, compress)
+}
@@ -0,0 +1,154 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Set up a maildir with an old and a recent email whose file names have been remembered, an old
+// email with only a date header, and an email of unknown date.
+func setUpPruneTest(t *testing.T) (IMAPConfig, string) {
+	t.Helper()
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "someone"}
+	base := setUpEmptyMaildir(t, "INBOX", oldmailFileName(cfg, "INBOX"))
+	folderPath := filepath.Join(base, "INBOX")
+
+	orgNow := now
+	now = func() time.Time { return time.Unix(100*24*3600, 0) }
+	t.Cleanup(func() { now = orgNow })
+
+	oldmail := "42/1\x00" + "86400\n" + "42/2\x00" + "8553600\n"
+	oldmailPath := filepath.Join(base, oldmailFileName(cfg, "INBOX"))
+	err := os.WriteFile(oldmailPath, []byte(oldmail), filePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(
+		filepath.Join(folderPath, uidListName), []byte("42/1 old\n42/2 recent\n"), filePerm,
+	)
+	assert.NoError(t, err)
+	for name, content := range map[string]string{
+		"cur/old:2,S": "Subject: old\r\n\r\nbody",
+		"new/recent":  "Subject: recent\r\n\r\nbody",
+		"new/header":  "Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n\r\nbody",
+		"new/unknown": "Subject: unknown\r\n\r\nbody",
+	} {
+		err := os.WriteFile(filepath.Join(folderPath, name), []byte(content), filePerm)
+		assert.NoError(t, err)
+	}
+	return cfg, base
+}
+
+func TestPruneMaildirsDelete(t *testing.T) {
+	cfg, base := setUpPruneTest(t)
+	folderPath := filepath.Join(base, "INBOX")
+
+	opts := PruneOptions{MaxAge: 30 * 24 * time.Hour}
+	results, err := PruneMaildirs(cfg, []string{"INBOX"}, base, opts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []PruneResult{{Folder: "INBOX", Pruned: 2, Kept: 2}}, results)
+	assert.NoFileExists(t, filepath.Join(folderPath, "cur", "old:2,S"))
+	assert.NoFileExists(t, filepath.Join(folderPath, "new", "header"))
+	assert.FileExists(t, filepath.Join(folderPath, "new", "recent"))
+	assert.FileExists(t, filepath.Join(folderPath, "new", "unknown"))
+	// Pruned emails are forgotten.
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"42/2": "recent"}, files)
+}
+
+func TestPruneMaildirsTrash(t *testing.T) {
+	cfg, base := setUpPruneTest(t)
+	folderPath := filepath.Join(base, "INBOX")
+
+	opts := PruneOptions{MaxAge: 30 * 24 * time.Hour, Trash: true}
+	_, err := PruneMaildirs(cfg, []string{"INBOX"}, base, opts)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(folderPath, prunedMaildir, "cur", "old:2,S"))
+	assert.FileExists(t, filepath.Join(folderPath, prunedMaildir, "new", "header"))
+	assert.NoFileExists(t, filepath.Join(folderPath, "cur", "old:2,S"))
+}
+
+func TestPruneMaildirsDryRun(t *testing.T) {
+	cfg, base := setUpPruneTest(t)
+	folderPath := filepath.Join(base, "INBOX")
+
+	opts := PruneOptions{MaxAge: 30 * 24 * time.Hour, DryRun: true}
+	results, err := PruneMaildirs(cfg, []string{"INBOX"}, base, opts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []PruneResult{{Folder: "INBOX", Pruned: 2, Kept: 2}}, results)
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "old:2,S"))
+	assert.FileExists(t, filepath.Join(folderPath, "new", "header"))
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(files))
+}
+
+func TestPruneMaildirsManifest(t *testing.T) {
+	cfg, base := setUpPruneTest(t)
+	folderPath := filepath.Join(base, "INBOX")
+	m := manifest{Folder: "INBOX", Messages: []manifestEntry{
+		{UIDValidity: 42, UID: 1, Path: filepath.Join("new", "old:2,S")},
+		{UIDValidity: 42, UID: 2, Path: filepath.Join("new", "recent")},
+	}}
+	assert.NoError(t, m.write(folderPath, false))
+
+	opts := PruneOptions{MaxAge: 30 * 24 * time.Hour}
+	_, err := PruneMaildirs(cfg, []string{"INBOX"}, base, opts)
+	assert.NoError(t, err)
+
+	// Pruned emails remain listed but no longer have a file.
+	m, err = readManifest(folderPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "", m.Messages[0].Path)
+	assert.Equal(t, filepath.Join("new", "recent"), m.Messages[1].Path)
+}
+
+func TestPruneMaildirsErrors(t *testing.T) {
+	cfg, base := setUpPruneTest(t)
+
+	_, err := PruneMaildirs(cfg, []string{"INBOX"}, base, PruneOptions{})
+	assert.ErrorContains(t, err, "must be positive")
+
+	results, err := PruneMaildirs(
+		cfg, []string{"missing", "INBOX"}, base, PruneOptions{MaxAge: time.Hour},
+	)
+	assert.ErrorContains(t, err, "does not point to a maildir")
+	// Other folders are still pruned.
+	assert.Equal(t, 1, len(results))
+}
+
+func TestPruneMaildirsSymlinks(t *testing.T) {
+	cfg, base := setUpPruneTest(t)
+	folderPath := filepath.Join(base, "INBOX")
+	err := os.Symlink("../cur/old:2,S", filepath.Join(folderPath, "new", "duplicate"))
+	assert.NoError(t, err)
+
+	opts := PruneOptions{MaxAge: 30 * 24 * time.Hour}
+	_, err = PruneMaildirs(cfg, []string{"INBOX"}, base, opts)
+
+	assert.ErrorContains(t, err, "deduplicated via symbolic links")
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "old:2,S"))
+}
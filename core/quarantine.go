@@ -0,0 +1,128 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The extension of files in the quarantine holding whatever was received of an email.
+	partialExt = ".partial"
+	// The extension of files in the quarantine stating why an email could not be stored.
+	errorNoteExt = ".error"
+)
+
+// Type quarantiner is implemented by storers that keep emails that could not be extracted from the
+// server's reply, see quarantineStorer.
+type quarantiner interface {
+	quarantineEmail(msg emailOps, uidFolder uidFolder, err error) error
+}
+
+// Type quarantineStorer keeps whatever has been received of emails that cannot be stored in a
+// quarantine directory for later inspection. For each such email, the received bytes are written
+// to a file with the extension .partial and the reason to a file with the extension .error next to
+// it. Quarantined emails still count as failed and are not remembered as downloaded. Each email is
+// held in memory while it is being stored so that it can be quarantined.
+type quarantineStorer struct {
+	Storer
+	path string
+}
+
+// Wrap a storer such that emails that cannot be stored are quarantined if requested.
+func (o DownloadOptions) quarantine(storer Storer, folder string) Storer {
+	if o.Quarantine == "" {
+		return storer
+	}
+	return &quarantineStorer{
+		Storer: storer,
+		path:   filepath.Join(o.Quarantine, sanitiseFileName(folder, maxFileNameLength)),
+	}
+}
+
+// Write stores an email and quarantines it if that fails. Content that the underlying storer did
+// not read before failing is quarantined, too.
+func (s *quarantineStorer) Write(info EmailInfo, content io.Reader) error {
+	received := &bytes.Buffer{}
+	err := s.Storer.Write(info, io.TeeReader(content, received))
+	if err == nil {
+		return nil
+	}
+	if _, readErr := io.Copy(received, content); readErr != nil {
+		err = fmt.Errorf("%s, reading remainder: %s", err.Error(), readErr.Error())
+	}
+	return s.keep(strings.ReplaceAll(info.Key, "/", "-"), received.Bytes(), err)
+}
+
+// Quarantine an email whose content could not be extracted from the server's reply. Everything
+// that has been received of its content is kept.
+func (s *quarantineStorer) quarantineEmail(msg emailOps, uidFolder uidFolder, err error) error {
+	msgUID, received := partialEmail(msg)
+	name := fmt.Sprintf("%d-%d", uidFolder, msgUID)
+	if msgUID == 0 {
+		// The UID is unknown, which is why the time is used to avoid overwriting other emails.
+		name = fmt.Sprintf("%d-unknown-%d", uidFolder, now().UnixNano())
+	}
+	return s.keep(name, received, err)
+}
+
+// Write the received content and the reason for quarantining an email to the quarantine. The
+// original error is returned so that the email counts as failed.
+func (s *quarantineStorer) keep(name string, received []byte, err error) error {
+	path := filepath.Join(s.path, name+partialExt)
+	keepErr := os.MkdirAll(s.path, dirPerm)
+	if keepErr == nil {
+		keepErr = writeFile(path, bytes.NewReader(received))
+	}
+	if keepErr == nil {
+		keepErr = writeFile(
+			filepath.Join(s.path, name+errorNoteExt), strings.NewReader(err.Error()+"\n"),
+		)
+	}
+	if keepErr != nil {
+		logError(fmt.Sprintf("cannot quarantine email: %s", keepErr.Error()))
+		return errors.Join(err, keepErr)
+	}
+	logWarning(fmt.Sprintf(
+		"quarantined %d bytes of email that could not be stored to %s: %s",
+		len(received), path, err.Error(),
+	))
+	return err
+}
+
+// Extract the UID and whatever content has been received from a reply that does not contain a
+// full email. The UID is 0 if it is unknown.
+func partialEmail(msg emailOps) (uid, []byte) {
+	partial := email{}
+	for _, field := range msg.Format() {
+		// Fields that cannot be extracted are skipped to keep as much as possible.
+		_ = partial.set(field)
+	}
+	buf := &bytes.Buffer{}
+	if partial.content != nil {
+		// Content that cannot be read in full is kept as far as it could be read.
+		_, _ = io.Copy(buf, partial.content)
+	}
+	return partial.uid, buf.Bytes()
+}
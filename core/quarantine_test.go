@@ -0,0 +1,164 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Type halfReadingStorer reads only part of each email before failing.
+type halfReadingStorer struct {
+	Storer
+}
+
+func (s *halfReadingStorer) Write(_ EmailInfo, content io.Reader) error {
+	_, err := io.ReadFull(content, make([]byte, 4)) // nolint: gomnd
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("disk full")
+}
+
+// Read a file from the quarantine.
+func readQuarantined(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path) // nolint: gosec
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestQuarantineDisabled(t *testing.T) {
+	ms := &mockStorer{}
+
+	storer := DownloadOptions{}.quarantine(ms, "INBOX")
+
+	assert.Same(t, ms, storer)
+}
+
+func TestQuarantineStorerSuccess(t *testing.T) {
+	base := t.TempDir()
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7"}, "some content").Return(nil)
+	storer := DownloadOptions{Quarantine: base}.quarantine(ms, "INBOX")
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader("some content"))
+
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(base, "INBOX"))
+	ms.AssertExpectations(t)
+}
+
+func TestQuarantineStorerWriteError(t *testing.T) {
+	base := t.TempDir()
+	storer := DownloadOptions{Quarantine: base}.quarantine(&halfReadingStorer{}, "Sent/2024")
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader("some content"))
+
+	assert.ErrorContains(t, err, "disk full")
+	folder := filepath.Join(base, "Sent_2024")
+	// Content not read before the failure is quarantined, too.
+	assert.Equal(t, "some content", readQuarantined(t, filepath.Join(folder, "42-7.partial")))
+	assert.Equal(t, "disk full\n", readQuarantined(t, filepath.Join(folder, "42-7.error")))
+}
+
+func TestQuarantineStorerCannotKeep(t *testing.T) {
+	base := t.TempDir()
+	// A file blocks creating the folder's directory in the quarantine.
+	assert.NoError(t, touch(filepath.Join(base, "INBOX"), filePerm))
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7"}, "some content").Return(fmt.Errorf("some error"))
+	storer := DownloadOptions{Quarantine: base}.quarantine(ms, "INBOX")
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader("some content"))
+
+	assert.ErrorContains(t, err, "some error")
+	assert.ErrorContains(t, err, "not a directory")
+}
+
+func TestQuarantineEmail(t *testing.T) {
+	base := t.TempDir()
+	storer := &quarantineStorer{path: base}
+	// The reply lacks the date at which the server received the email.
+	msg := &mockEmail{}
+	msg.On("Format").Return([]interface{}{uint32(7), "rfc822 header", "Subject: partial"})
+
+	err := storer.quarantineEmail(msg, 42, fmt.Errorf("incomplete reply"))
+
+	assert.ErrorContains(t, err, "incomplete reply")
+	assert.Equal(t, "Subject: partial", readQuarantined(t, filepath.Join(base, "42-7.partial")))
+	assert.Equal(t, "incomplete reply\n", readQuarantined(t, filepath.Join(base, "42-7.error")))
+	msg.AssertExpectations(t)
+}
+
+func TestQuarantineEmailUnknownUID(t *testing.T) {
+	orgNow := now
+	now = func() time.Time { return time.Unix(0, 123) }
+	t.Cleanup(func() { now = orgNow })
+
+	base := t.TempDir()
+	storer := &quarantineStorer{path: base}
+	msg := &mockEmail{}
+	msg.On("Format").Return([]interface{}{})
+
+	err := storer.quarantineEmail(msg, 42, fmt.Errorf("empty reply"))
+
+	assert.ErrorContains(t, err, "empty reply")
+	assert.Equal(t, "", readQuarantined(t, filepath.Join(base, "42-unknown-123.partial")))
+	assert.FileExists(t, filepath.Join(base, "42-unknown-123.error"))
+}
+
+func TestStreamingDeliveryQuarantine(t *testing.T) {
+	base := t.TempDir()
+	m := &mockDeliverer{}
+	ms := &mockStorer{}
+	storer := DownloadOptions{Quarantine: base}.quarantine(ms, "INBOX")
+
+	msg := &mockEmail{}
+	msg.On("Format").Return([]interface{}{uint32(7)})
+	m.On("rfc822FromEmail", msg, uidFolder(42)).
+		Return("", oldmail{}, fmt.Errorf("cannot extract full email from reply"))
+
+	msgChan := make(chan emailOps, 1)
+	msgChan <- msg
+	close(msgChan)
+
+	var wg, stwg sync.WaitGroup
+	oldmailChan, errCountPtr := streamingDelivery(m, msgChan, storer, 42, "", &wg, &stwg)
+	oldmails := []oldmail{}
+	for om := range oldmailChan {
+		oldmails = append(oldmails, om)
+	}
+	wg.Wait()
+
+	// Quarantined emails are not remembered as downloaded.
+	assert.Empty(t, oldmails)
+	assert.Equal(t, 1, *errCountPtr)
+	assert.FileExists(t, filepath.Join(base, "INBOX", "42-7.partial"))
+	ms.AssertNotCalled(t, "Write", mock.Anything, mock.Anything)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,140 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReconcileResult compares the emails of a folder on the server with those downloaded to its local
+// maildir. Entries have the form "<UIDVALIDITY>/<UID>", which is also understood by
+// DownloadOptions.UIDFile, and are sorted.
+type ReconcileResult struct {
+	Folder string `json:"folder"`
+	// MissingLocally lists emails on the server that have not been downloaded.
+	MissingLocally []string `json:"missing_locally"`
+	// MissingRemotely lists downloaded emails that are no longer on the server. That includes all
+	// emails downloaded while the folder had a different UIDVALIDITY.
+	MissingRemotely []string `json:"missing_remotely"`
+}
+
+// Retrieve the UIDs of all emails in a folder. The folder is selected in read-only mode.
+func getFolderUIDs(imapClient imapOps, folder string, bufferSize int) ([]uidExt, error) {
+	mbox, err := selectFolder(imapClient, folder)
+	if err != nil {
+		return nil, err
+	}
+	return getAllMessageUUIDs(mbox, imapClient, bufferSize)
+}
+
+// Compare the emails of a folder on the server with those remembered as downloaded.
+func reconcileUIDs(folder string, remote []uidExt, local []oldmail) ReconcileResult {
+	localKeys := make(map[string]struct{}, len(local))
+	for _, om := range local {
+		localKeys[om.key()] = struct{}{}
+	}
+	remoteKeys := make(map[string]struct{}, len(remote))
+	result := ReconcileResult{Folder: folder, MissingLocally: []string{}, MissingRemotely: []string{}}
+	for _, msg := range remote {
+		key := msg.String()
+		remoteKeys[key] = struct{}{}
+		if _, found := localKeys[key]; !found {
+			result.MissingLocally = append(result.MissingLocally, key)
+		}
+	}
+	for key := range localKeys {
+		if _, found := remoteKeys[key]; !found {
+			result.MissingRemotely = append(result.MissingRemotely, key)
+		}
+	}
+	sortKeys(result.MissingLocally)
+	sortKeys(result.MissingRemotely)
+	return result
+}
+
+// Sort keys of emails numerically by UIDVALIDITY and UID.
+func sortKeys(keys []string) {
+	parse := func(key string) uidExt {
+		var msg uidExt
+		_, _ = fmt.Sscanf(key, "%d/%d", &msg.folder, &msg.msg)
+		return msg
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		first, second := parse(keys[i]), parse(keys[j])
+		if first.folder != second.folder {
+			return first.folder < second.folder
+		}
+		return first.msg < second.msg
+	})
+}
+
+// Read the emails remembered as downloaded for a folder without modifying anything. A missing
+// oldmail file means that nothing has been downloaded yet.
+func readDownloaded(cfg IMAPConfig, maildirPath maildirPathT) ([]oldmail, error) {
+	oldmailName := strings.ReplaceAll(
+		oldmailFileName(cfg, maildirPath.folderName()), string(os.PathSeparator), ".",
+	)
+	oldmailPath := filepath.Join(maildirPath.basePath(), oldmailName)
+	if !isFile(oldmailPath) {
+		logWarning(fmt.Sprintf("no emails of folder %s have been downloaded", maildirPath.folder))
+		return nil, nil
+	}
+	return readOldmail(oldmailPath)
+}
+
+// ReconcileFolders compares each folder matching the given folder specs, see DownloadFolder, with
+// its local maildir in the given base directory. It reports the emails on the server that have not
+// been downloaded and the downloaded emails that are no longer on the server. Emails are identified
+// via the oldmail files written by DownloadFolder with the same config and base directory. Nothing
+// is modified, neither on the server nor locally. A single connection is used and folders are
+// selected in read-only mode. Results are in the order in which the server lists the folders.
+func ReconcileFolders(
+	cfg IMAPConfig, folderSpecs []string, maildirBase string,
+) ([]ReconcileResult, error) {
+	ops := NewImapgrabOps()
+	errs := threadSafeErrors{verbose: true}
+	errs.add(ops.authenticateClient(cfg))
+	if errs.bad() {
+		return nil, errs.err()
+	}
+	availableFolders, listErr := ops.getFolderList()
+	errs.add(listErr)
+	results := []ReconcileResult{}
+	if listErr == nil {
+		for _, folder := range expandFolders(folderSpecs, availableFolders) {
+			local, readErr := readDownloaded(cfg, maildirPathT{base: maildirBase, folder: folder})
+			if readErr != nil {
+				errs.add(readErr)
+				continue
+			}
+			// An incomplete list of emails on the server would report downloaded emails as gone.
+			remote, uidErr := ops.getFolderUIDs(folder)
+			if uidErr != nil {
+				errs.add(fmt.Errorf("cannot list emails of folder %s: %w", folder, uidErr))
+				continue
+			}
+			results = append(results, reconcileUIDs(folder, remote, local))
+		}
+	}
+	errs.add(ops.logout(false))
+	return results, errs.err()
+}
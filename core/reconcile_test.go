@@ -0,0 +1,121 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileUIDs(t *testing.T) {
+	remote := []uidExt{{folder: 42, msg: 10}, {folder: 42, msg: 2}, {folder: 42, msg: 3}}
+	local := []oldmail{
+		{uidFolder: 42, uid: 3}, {uidFolder: 42, uid: 11}, {uidFolder: 7, uid: 2},
+		{uidFolder: 42, uid: 9},
+	}
+
+	result := reconcileUIDs("some folder", remote, local)
+
+	assert.Equal(t, ReconcileResult{
+		Folder:          "some folder",
+		MissingLocally:  []string{"42/2", "42/10"},
+		MissingRemotely: []string{"7/2", "42/9", "42/11"},
+	}, result)
+}
+
+func TestReconcileUIDsComplete(t *testing.T) {
+	result := reconcileUIDs(
+		"some folder", []uidExt{{folder: 42, msg: 1}}, []oldmail{{uidFolder: 42, uid: 1}},
+	)
+
+	assert.Equal(t, ReconcileResult{
+		Folder: "some folder", MissingLocally: []string{}, MissingRemotely: []string{},
+	}, result)
+}
+
+func TestGetFolderUIDs(t *testing.T) {
+	m := &mockClient{}
+	m.On("Select", "some folder", true).Return(&imap.MailboxStatus{UidValidity: 42}, nil)
+
+	uids, err := getFolderUIDs(m, "some folder", 1)
+
+	assert.NoError(t, err)
+	assert.Empty(t, uids)
+	m.AssertExpectations(t)
+}
+
+func TestGetFolderUIDsSelectError(t *testing.T) {
+	m := &mockClient{}
+	m.On("Select", "some folder", true).
+		Return(&imap.MailboxStatus{}, fmt.Errorf("some error"))
+
+	_, err := getFolderUIDs(m, "some folder", 1)
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestReconcileFolders(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	tmpdir := t.TempDir()
+	oldmailPath := filepath.Join(tmpdir, "oldmail-some-server-42-some_user-f1")
+	err := os.WriteFile(oldmailPath, []byte("42/1\x001\n42/2\x002\n"), filePerm)
+	require.NoError(t, err)
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderList").Return([]string{"f1", "f2", "f3"}, nil)
+	mock.On("logout", false).Return(nil)
+	mock.On("getFolderUIDs", "f1").
+		Return([]uidExt{{folder: 42, msg: 2}, {folder: 42, msg: 3}}, nil)
+	// Nothing has been downloaded for the second folder, yet.
+	mock.On("getFolderUIDs", "f2").Return([]uidExt{{folder: 7, msg: 1}}, nil)
+	mock.On("getFolderUIDs", "f3").Return([]uidExt{}, fmt.Errorf("some error"))
+
+	setUpCoreTest(t, mock)
+
+	results, err := ReconcileFolders(cfg, []string{"_ALL_"}, tmpdir)
+
+	assert.ErrorContains(t, err, "cannot list emails of folder f3: some error")
+	assert.Equal(t, []ReconcileResult{
+		{Folder: "f1", MissingLocally: []string{"42/3"}, MissingRemotely: []string{"42/1"}},
+		{Folder: "f2", MissingLocally: []string{"7/1"}, MissingRemotely: []string{}},
+	}, results)
+	mock.AssertExpectations(t)
+	// Nothing has been created locally.
+	entries, err := os.ReadDir(tmpdir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestReconcileFoldersAuthError(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(fmt.Errorf("some auth error"))
+	setUpCoreTest(t, mock)
+
+	results, err := ReconcileFolders(cfg, []string{"_ALL_"}, t.TempDir())
+
+	assert.ErrorContains(t, err, "some auth error")
+	assert.Nil(t, results)
+}
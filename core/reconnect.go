@@ -0,0 +1,113 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Split UIDs into consecutive chunks of at most the given size, keeping their order.
+func chunkUIDs(uids []uid, size int) [][]uid {
+	chunks := make([][]uid, 0, len(uids)/size+1)
+	for len(uids) > size {
+		chunks = append(chunks, uids[:size])
+		uids = uids[size:]
+	}
+	if len(uids) > 0 {
+		chunks = append(chunks, uids)
+	}
+	return chunks
+}
+
+// Retrieve the given emails of a folder in chunks of DownloadOptions.ReconnectEvery emails. The
+// first chunk is retrieved via the given client, every further one via a client that is newly
+// connected once the previous chunk has been provided, and logged out once it has provided its
+// own. Thus, no session retrieves more emails than servers might allow. Emails are provided via
+// a single channel in the requested order. If a client cannot be connected, the remaining emails
+// are not retrieved, which counts as an error. The returned function must be called once the
+// retrieval has finished to make sure that the last client has been logged out.
+func (o DownloadOptions) reconnectingRetrieval(
+	ops downloadOps,
+	folder string,
+	uidFold uidFolder,
+	uids []uid,
+	wg, startWg *sync.WaitGroup,
+	interrupted func() bool,
+) (<-chan emailOps, *int, func(), error) {
+	chunks := chunkUIDs(uids, o.ReconnectEvery)
+	messageChan, errCount, err := ops.streamingRetrieval(
+		chunks[0], o.fetchItems(), o.batchSize(), wg, startWg, interrupted,
+	)
+	if err != nil {
+		return nil, nil, func() {}, err
+	}
+
+	mergedChan := make(chan emailOps)
+	var totalErrCount int
+	var lock sync.Mutex
+	logout := func() {}
+	done := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		logout()
+		logout = func() {}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(mergedChan)
+		for idx := 0; ; idx++ {
+			for msg := range messageChan {
+				mergedChan <- msg
+			}
+			// The errors of a client are known once it has provided its last email.
+			totalErrCount += *errCount
+			done()
+			if idx+1 == len(chunks) {
+				return
+			}
+			if interrupted() {
+				// The remaining emails have not been retrieved.
+				totalErrCount++
+				return
+			}
+			logInfo(fmt.Sprintf(
+				"reconnecting after %d emails of folder %s", (idx+1)*o.ReconnectEvery, folder,
+			))
+			client, clientLogout, err := o.connectFolder(folder, uidFold)
+			if err == nil {
+				lock.Lock()
+				logout = clientLogout
+				lock.Unlock()
+				messageChan, errCount, err = client.streamingRetrieval(
+					chunks[idx+1], o.fetchItems(), o.batchSize(), wg, startWg, interrupted,
+				)
+			}
+			if err != nil {
+				logError(fmt.Sprintf(
+					"cannot reconnect to retrieve remaining emails of folder %s: %s",
+					folder, err.Error(),
+				))
+				totalErrCount++
+				return
+			}
+		}
+	}()
+	return mergedChan, &totalErrCount, done, nil
+}
@@ -0,0 +1,170 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkUIDs(t *testing.T) {
+	uids := []uid{5, 4, 3, 2, 1}
+
+	assert.Equal(t, [][]uid{{5, 4}, {3, 2}, {1}}, chunkUIDs(uids, 2))
+	assert.Equal(t, [][]uid{{5, 4, 3, 2, 1}}, chunkUIDs(uids, 5))
+	assert.Equal(t, [][]uid{}, chunkUIDs(nil, 2))
+}
+
+// Run a retrieval and collect the UIDs of all retrieved emails in the order they are provided.
+func collectInOrder(
+	t *testing.T, messageChan <-chan emailOps, wg, startWg *sync.WaitGroup,
+) []int {
+	startWg.Done()
+	var retrieved []int
+	for msg := range messageChan {
+		retrieved = append(retrieved, msg.(*mockEmail).uid)
+	}
+	wg.Wait()
+	return retrieved
+}
+
+func TestReconnectingRetrieval(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{5, 4}, 1)
+	second, _ := setUpRetrievingMock(t, []uid{3, 2}, 0)
+	third, _ := setUpRetrievingMock(t, []uid{1}, 2)
+	for _, client := range []*mockDownloader{second, third} {
+		client.On("selectFolder", "INBOX").Return(&imap.MailboxStatus{UidValidity: 42}, nil)
+	}
+	clients := []downloadOps{second, third}
+	loggedOut := 0
+	opts := DownloadOptions{ReconnectEvery: 2}
+	opts.connect = func() (downloadOps, func(), error) {
+		client := clients[0]
+		clients = clients[1:]
+		return client, func() { loggedOut++ }, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "INBOX", 42, []uid{5, 4, 3, 2, 1}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, collectInOrder(t, messageChan, &wg, &startWg))
+	assert.Equal(t, 3, *errCount)
+	// Every further client is logged out once it has provided its emails.
+	assert.Equal(t, 2, loggedOut)
+	done()
+	assert.Equal(t, 2, loggedOut)
+	primary.AssertExpectations(t)
+	second.AssertExpectations(t)
+	third.AssertExpectations(t)
+}
+
+func TestReconnectingRetrievalSingleChunk(t *testing.T) {
+	primary, primaryErrCount := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	opts := DownloadOptions{ReconnectEvery: 2}
+	opts.connect = func() (downloadOps, func(), error) {
+		assert.Fail(t, "no further client shall be connected")
+		return nil, nil, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "INBOX", 42, []uid{1, 2}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	assert.Equal(t, []int{1, 2}, collectInOrder(t, messageChan, &wg, &startWg))
+	assert.Equal(t, primaryErrCount, errCount)
+	primary.AssertExpectations(t)
+}
+
+func TestReconnectingRetrievalConnectFailure(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	opts := DownloadOptions{ReconnectEvery: 2}
+	opts.connect = func() (downloadOps, func(), error) {
+		return nil, nil, fmt.Errorf("too many connections")
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "INBOX", 42, []uid{1, 2, 3}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	// The remaining email is not retrieved, which counts as an error.
+	assert.Equal(t, []int{1, 2}, collectInOrder(t, messageChan, &wg, &startWg))
+	assert.Equal(t, 1, *errCount)
+	primary.AssertExpectations(t)
+}
+
+func TestReconnectingRetrievalUIDValidityChanged(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	second := &mockDownloader{t: t}
+	second.On("selectFolder", "INBOX").Return(&imap.MailboxStatus{UidValidity: 43}, nil)
+	loggedOut := 0
+	opts := DownloadOptions{ReconnectEvery: 2}
+	opts.connect = func() (downloadOps, func(), error) {
+		return second, func() { loggedOut++ }, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "INBOX", 42, []uid{1, 2, 3}, &wg, &startWg, func() bool { return false },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	assert.Equal(t, []int{1, 2}, collectInOrder(t, messageChan, &wg, &startWg))
+	assert.Equal(t, 1, *errCount)
+	assert.Equal(t, 1, loggedOut)
+	primary.AssertExpectations(t)
+	second.AssertExpectations(t)
+}
+
+func TestReconnectingRetrievalInterrupted(t *testing.T) {
+	primary, _ := setUpRetrievingMock(t, []uid{1, 2}, 0)
+	opts := DownloadOptions{ReconnectEvery: 2}
+	opts.connect = func() (downloadOps, func(), error) {
+		assert.Fail(t, "no further client shall be connected")
+		return nil, nil, nil
+	}
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+
+	messageChan, errCount, done, err := opts.parallelRetrieval(
+		primary, "INBOX", 42, []uid{1, 2, 3}, &wg, &startWg, func() bool { return true },
+	)
+	require.NoError(t, err)
+	defer done()
+
+	assert.Equal(t, []int{1, 2}, collectInOrder(t, messageChan, &wg, &startWg))
+	assert.Equal(t, 1, *errCount)
+}
@@ -0,0 +1,126 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// Type messageIDSet holds the normalised Message-IDs of emails that are available elsewhere, see
+// DownloadOptions.ReferenceMaildirs.
+type messageIDSet map[string]struct{}
+
+// Normalise a Message-ID so that the same ID matches irrespective of surrounding whitespace or
+// angle brackets.
+func normaliseMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// Read the Message-IDs of the emails in all maildirs below the given directories. Unlike when
+// indexing for deduplication, a missing directory is an error since it is most likely a typo. Files
+// without a Message-ID or whose header cannot be parsed are skipped, the latter with a warning.
+func readReferenceMaildirs(dirs []string) (messageIDSet, error) {
+	ids := messageIDSet{}
+	for _, dir := range dirs {
+		if !isDir(dir) {
+			return nil, fmt.Errorf("reference maildir %s not found", dir)
+		}
+		err := walkMaildirFiles(dir, func(path string) {
+			id, err := readMessageID(path)
+			if err != nil {
+				logWarning(fmt.Sprintf("cannot read Message-ID of %s: %s", path, err.Error()))
+			} else if id != "" {
+				ids[id] = struct{}{}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read reference maildir %s: %s", dir, err.Error())
+		}
+	}
+	logInfo(fmt.Sprintf("read %d Message-IDs from reference maildirs", len(ids)))
+	return ids, nil
+}
+
+// Read the normalised Message-ID of the email in a file. Only the header is read.
+func readMessageID(path string) (string, error) {
+	handle, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = handle.Close() }()
+	msg, err := mail.ReadMessage(bufio.NewReader(handle))
+	if err != nil {
+		return "", err
+	}
+	return normaliseMessageID(msg.Header.Get("Message-Id")), nil
+}
+
+// Retrieve the normalised Message-IDs of emails in the selected folder via their envelopes. Emails
+// without a Message-ID are not listed.
+func getMessageIDs(imapClient imapOps, uids []uid, bufferSize int) (map[uid]string, error) {
+	ids := map[uid]string{}
+	if len(uids) == 0 {
+		return ids, nil
+	}
+	messageChannel := make(chan *imap.Message, bufferSize)
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- imapClient.UidFetch(
+			batchSeqSets(uids, 0)[0],
+			[]imap.FetchItem{imap.FetchUid, imap.FetchEnvelope},
+			messageChannel,
+		)
+	}()
+	for m := range messageChannel {
+		if m == nil || m.Envelope == nil {
+			continue
+		}
+		if id := normaliseMessageID(m.Envelope.MessageId); id != "" {
+			ids[uid(m.Uid)] = id
+		}
+	}
+	return ids, <-errChannel
+}
+
+// Remove those emails from the given ones whose Message-IDs are present in the reference maildirs.
+// The order of the remaining emails is kept.
+func (o DownloadOptions) skipReferenced(ops downloadOps, uids []uid) ([]uid, error) {
+	if len(o.references) == 0 || len(uids) == 0 {
+		return uids, nil
+	}
+	ids, err := ops.getMessageIDs(uids)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve Message-IDs: %s", err.Error())
+	}
+	remaining := make([]uid, 0, len(uids))
+	for _, msg := range uids {
+		if _, found := o.references[ids[msg]]; !found {
+			remaining = append(remaining, msg)
+		}
+	}
+	if skipped := len(uids) - len(remaining); skipped > 0 {
+		logInfo(fmt.Sprintf("skipping %d emails present in reference maildirs", skipped))
+	}
+	return remaining, nil
+}
@@ -0,0 +1,126 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormaliseMessageID(t *testing.T) {
+	assert.Equal(t, "id@example.com", normaliseMessageID(" <id@example.com>\r\n"))
+	assert.Equal(t, "id@example.com", normaliseMessageID("id@example.com"))
+	assert.Equal(t, "", normaliseMessageID(" <> "))
+}
+
+func TestReadReferenceMaildirs(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	setUpIndexTestMaildir(t, first, "INBOX")
+	setUpIndexTestMaildir(t, second, "Archive")
+	writeIndexTestFile(
+		t, filepath.Join(first, "INBOX", "cur", "a"), "Message-ID: <a@example.com>\r\n\r\nbody",
+	)
+	writeIndexTestFile(t, filepath.Join(first, "INBOX", "new", "b"), "Subject: no ID\r\n\r\nbody")
+	writeIndexTestFile(
+		t, filepath.Join(second, "Archive", "new", "c"), "Message-Id: c@example.com\r\n\r\n",
+	)
+	// Emails in tmp are not considered.
+	writeIndexTestFile(
+		t, filepath.Join(first, "INBOX", "tmp", "d"), "Message-ID: <d@example.com>\r\n\r\n",
+	)
+
+	ids, err := readReferenceMaildirs([]string{first, second})
+
+	assert.NoError(t, err)
+	expected := messageIDSet{"a@example.com": {}, "c@example.com": {}}
+	assert.Equal(t, expected, ids)
+}
+
+func TestReadReferenceMaildirsMissing(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	_, err := readReferenceMaildirs([]string{missing})
+
+	assert.ErrorContains(t, err, "reference maildir")
+}
+
+func TestGetMessageIDs(t *testing.T) {
+	m := &mockClient{messages: []*imap.Message{
+		{Uid: 1, Envelope: &imap.Envelope{MessageId: "<a@example.com>"}},
+		{Uid: 2, Envelope: &imap.Envelope{}},
+		{Uid: 3},
+		nil,
+	}}
+	m.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope},
+		mock.Anything).Return(nil)
+
+	ids, err := getMessageIDs(m, []uid{1, 2, 3}, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[uid]string{1: "a@example.com"}, ids)
+	m.AssertExpectations(t)
+}
+
+func TestGetMessageIDsNone(t *testing.T) {
+	m := &mockClient{}
+
+	ids, err := getMessageIDs(m, nil, 0)
+
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	m.AssertNotCalled(t, "UidFetch", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSkipReferenced(t *testing.T) {
+	m := &mockDownloader{t: t}
+	m.On("getMessageIDs", []uid{3, 1, 2}).
+		Return(map[uid]string{1: "a@example.com", 3: "c@example.com"}, nil)
+	opts := DownloadOptions{references: messageIDSet{"a@example.com": {}}}
+
+	remaining, err := opts.skipReferenced(m, []uid{3, 1, 2})
+
+	require.NoError(t, err)
+	assert.Equal(t, []uid{3, 2}, remaining)
+	m.AssertExpectations(t)
+}
+
+func TestSkipReferencedWithoutReferences(t *testing.T) {
+	m := &mockDownloader{t: t}
+
+	remaining, err := DownloadOptions{}.skipReferenced(m, []uid{1, 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uid{1, 2}, remaining)
+	m.AssertNotCalled(t, "getMessageIDs", mock.Anything)
+}
+
+func TestSkipReferencedError(t *testing.T) {
+	m := &mockDownloader{t: t}
+	m.On("getMessageIDs", []uid{1}).Return(map[uid]string{}, fmt.Errorf("some error"))
+	opts := DownloadOptions{references: messageIDSet{"a@example.com": {}}}
+
+	_, err := opts.skipReferenced(m, []uid{1})
+
+	assert.ErrorContains(t, err, "some error")
+}
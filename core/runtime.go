@@ -0,0 +1,101 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRuntimeExceeded marks errors of downloads that have been stopped early because they took
+// longer than DownloadOptions.MaxRuntime. Such downloads are incomplete but everything stored so
+// far is remembered, i.e. the next download resumes where this one stopped.
+var ErrRuntimeExceeded = errors.New("maximum runtime exceeded, download is incomplete")
+
+// Type runtimeLimit determines whether the maximum runtime of a download has been exceeded. It is
+// shared by all download threads. A nil runtimeLimit is never exceeded.
+type runtimeLimit struct {
+	limit    time.Duration
+	deadline time.Time
+	exceeded atomic.Bool
+}
+
+func newRuntimeLimit(limit time.Duration) *runtimeLimit {
+	return &runtimeLimit{limit: limit, deadline: now().Add(limit)}
+}
+
+// Determine whether the deadline has passed. The first call noticing that logs a warning.
+func (r *runtimeLimit) reached() bool {
+	if r == nil {
+		return false
+	}
+	if r.exceeded.Load() {
+		return true
+	}
+	if now().Before(r.deadline) {
+		return false
+	}
+	r.exceed()
+	return true
+}
+
+// Mark the limit as exceeded. The first call logs a warning.
+func (r *runtimeLimit) exceed() {
+	if !r.exceeded.Swap(true) {
+		logWarning(fmt.Sprintf("maximum runtime of %s exceeded, stopping download", r.limit))
+	}
+}
+
+// Call a function once the deadline has passed, e.g. to terminate a connection whose retrieval is
+// blocked waiting for the server, which would notice the deadline only once it returns. The limit
+// is exceeded then. The returned function stops waiting for the deadline.
+func (r *runtimeLimit) afterDeadline(fn func()) func() {
+	if r == nil {
+		return func() {}
+	}
+	timer := time.AfterFunc(r.deadline.Sub(now()), func() {
+		r.exceed()
+		fn()
+	})
+	return func() { timer.Stop() }
+}
+
+// Determine whether the download has been stopped early because of this limit.
+func (r *runtimeLimit) wasExceeded() bool {
+	return r != nil && r.exceeded.Load()
+}
+
+// Wrap interruptOps such that reaching the limit looks like an interrupt. That way, the download
+// stops as gracefully as when the user interrupts it.
+func (r *runtimeLimit) wrap(ops interruptOps) interruptOps {
+	if r == nil {
+		return ops
+	}
+	return limitedInterrupt{interruptOps: ops, limit: r}
+}
+
+type limitedInterrupt struct {
+	interruptOps
+	limit *runtimeLimit
+}
+
+func (l limitedInterrupt) interrupted() bool {
+	return l.limit.reached() || l.interruptOps.interrupted()
+}
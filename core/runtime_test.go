@@ -0,0 +1,159 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Let the clock advance by a minute whenever it is read.
+func setUpAdvancingClock(t *testing.T) {
+	orgNow := now
+	t.Cleanup(func() { now = orgNow })
+	current := time.Unix(0, 0)
+	now = func() time.Time {
+		current = current.Add(time.Minute)
+		return current
+	}
+}
+
+func TestRuntimeLimit(t *testing.T) {
+	setUpAdvancingClock(t)
+
+	limit := newRuntimeLimit(90 * time.Second)
+
+	assert.False(t, limit.reached())
+	assert.False(t, limit.wasExceeded())
+	assert.True(t, limit.reached())
+	assert.True(t, limit.wasExceeded())
+	// The limit stays exceeded.
+	assert.True(t, limit.reached())
+}
+
+func TestRuntimeLimitNil(t *testing.T) {
+	var limit *runtimeLimit
+	mi := &mockInterrupter{}
+
+	assert.False(t, limit.reached())
+	assert.False(t, limit.wasExceeded())
+	assert.Equal(t, mi, limit.wrap(mi))
+}
+
+func TestRuntimeLimitWrap(t *testing.T) {
+	setUpAdvancingClock(t)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false).Once()
+	defer mi.AssertExpectations(t)
+
+	sig := newRuntimeLimit(90 * time.Second).wrap(mi)
+
+	assert.False(t, sig.interrupted())
+	// Once the limit has been reached, the download is interrupted.
+	assert.True(t, sig.interrupted())
+}
+
+func TestRuntimeLimitAfterDeadline(t *testing.T) {
+	var limit *runtimeLimit
+	limit.afterDeadline(func() { assert.Fail(t, "called without limit") })()
+
+	limit = newRuntimeLimit(time.Hour)
+	stop := limit.afterDeadline(func() { assert.Fail(t, "called before deadline") })
+	stop()
+	assert.False(t, limit.wasExceeded())
+
+	called := make(chan struct{})
+	limit = newRuntimeLimit(10 * time.Millisecond)
+	defer limit.afterDeadline(func() { close(called) })()
+	select {
+	case <-called:
+		assert.True(t, limit.wasExceeded())
+	case <-time.After(time.Second):
+		assert.Fail(t, "not called after deadline")
+	}
+}
+
+func TestImapgrabberRuntimeAbortsBlockedRetrieval(t *testing.T) {
+	mbox := &imap.MailboxStatus{Name: "INBOX", UidValidity: 42, Messages: 1}
+	m := &mockClient{messages: []*imap.Message{{Uid: 1, Size: 10}}}
+	m.On("Support", condstoreCapability).Return(false, nil)
+	m.On("Select", "INBOX", true).Return(mbox, nil)
+	m.On("Fetch", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client := &slowClient{mockClient: m, terminated: make(chan struct{})}
+
+	ig := &Imapgrabber{
+		imapOps:      client,
+		interruptOps: newInterruptOps(signalsToWaitFor),
+		downloadOps:  downloader{imapOps: client, deliverOps: deliverer{}, buffers: bufferSizes{}},
+	}
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "INBOX"}
+	opts := DownloadOptions{runtime: newRuntimeLimit(100 * time.Millisecond)}
+
+	downloadErr := make(chan error, 1)
+	go func() {
+		downloadErr <- ig.downloadMissingEmailsToFolder(maildirPath, "oldmail", opts)
+	}()
+
+	// The retrieval never returns unless the connection is terminated.
+	select {
+	case err := <-downloadErr:
+		assert.Error(t, err)
+		assert.True(t, opts.runtime.wasExceeded())
+	case <-time.After(time.Second):
+		t.Fatal("download did not return after the deadline")
+	}
+	m.AssertExpectations(t)
+}
+
+func TestDownloadAccountsRuntimeExceeded(t *testing.T) {
+	setUpAdvancingClock(t)
+	accounts, _, _ := setUpAccountsTest()
+
+	mockOps := &mockImapgrabber{}
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadAccounts(accounts, 1, DownloadOptions{MaxRuntime: time.Second})
+
+	assert.ErrorIs(t, err, ErrRuntimeExceeded)
+	assert.ErrorContains(t, err, "not downloading 2 remaining accounts")
+	mockOps.AssertExpectations(t)
+}
+
+func TestDownloadFolderRuntimeExceeded(t *testing.T) {
+	setUpAdvancingClock(t)
+	cfg := IMAPConfig{Server: "server", Port: 42, User: "user", Password: "secret"}
+
+	mockOps := &mockImapgrabber{}
+	mockOps.On("authenticateClient", cfg).Return(nil)
+	mockOps.On("getFolderInfos").Return(folderInfos([]string{"f1", "f2"}), nil)
+	mockOps.On("logout", true).Return(nil)
+	setUpCoreTest(t, mockOps)
+
+	err := DownloadFolder(cfg, []string{"_ALL_"}, t.TempDir(), 1, DownloadOptions{
+		MaxRuntime: time.Second,
+	})
+
+	assert.ErrorIs(t, err, ErrRuntimeExceeded)
+	assert.ErrorContains(t, err, "stopping download threads due to exceeded runtime")
+	mockOps.AssertExpectations(t)
+}
@@ -0,0 +1,124 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-sasl"
+	"github.com/xdg-go/scram"
+)
+
+// Supported values for IMAPConfig.AuthMethod. By default, i.e. for an empty method, the LOGIN
+// command is used, or the SASL PLAIN mechanism with IMAPConfig.AuthzID.
+const (
+	// AuthMethodScramSHA1 authenticates via the SASL SCRAM-SHA-1 mechanism.
+	AuthMethodScramSHA1 = "SCRAM-SHA-1"
+	// AuthMethodScramSHA256 authenticates via the SASL SCRAM-SHA-256 mechanism.
+	AuthMethodScramSHA256 = "SCRAM-SHA-256"
+)
+
+// Create the nonce that a SCRAM client sends to the server. If nil, a random one is used. It can be
+// replaced in tests.
+var scramNonce scram.NonceGeneratorFcn
+
+// Type scramClient implements the SASL SCRAM mechanisms as specified in RFC 5802 and RFC 7677
+// without channel binding via the conversation of a SCRAM library. The client proves that it knows
+// the password without sending it and verifies that the server knows it, too. Names and passwords
+// are normalized via SASLprep.
+type scramClient struct {
+	mechanism    string
+	conversation *scram.ClientConversation
+}
+
+// Create a SCRAM client for one of the supported authentication methods.
+func newScramClient(method, authzID, username, password string) (*scramClient, error) {
+	var hashGen scram.HashGeneratorFcn
+	switch method {
+	case AuthMethodScramSHA1:
+		hashGen = scram.SHA1
+	case AuthMethodScramSHA256:
+		hashGen = scram.SHA256
+	default:
+		return nil, fmt.Errorf("unknown authentication method '%s'", method)
+	}
+	client, err := hashGen.NewClient(username, password, authzID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use credentials for %s: %s", method, err.Error())
+	}
+	if scramNonce != nil {
+		client = client.WithNonceGenerator(scramNonce)
+	}
+	return &scramClient{mechanism: method, conversation: client.NewConversation()}, nil
+}
+
+func (c *scramClient) Start() (mech string, ir []byte, err error) {
+	clientFirst, err := c.conversation.Step("")
+	return c.mechanism, []byte(clientFirst), err
+}
+
+// Next handles a challenge by the server. The first challenge provides what is needed to prove
+// knowledge of the password, the second one proves that the server knows it, too.
+func (c *scramClient) Next(challenge []byte) (response []byte, err error) {
+	if c.conversation.Done() {
+		return nil, fmt.Errorf("unexpected challenge during %s authentication", c.mechanism)
+	}
+	message, err := c.conversation.Step(string(challenge))
+	if err != nil {
+		return nil, fmt.Errorf("%s authentication failed: %s", c.mechanism, err.Error())
+	}
+	return []byte(message), nil
+}
+
+// Determine whether the server has proven that it knows the password. Otherwise, it might be an
+// impostor, even if it reported a successful authentication.
+func (c *scramClient) verified() bool {
+	return c.conversation.Valid()
+}
+
+// Authenticate via one of the SASL SCRAM mechanisms, which the server has to advertise.
+func authenticateScram(imapClient imapOps, config IMAPConfig) (imapOps, error) {
+	scram, err := newScramClient(config.AuthMethod, config.AuthzID, config.User, config.Password)
+	var supported bool
+	if err == nil {
+		supported, err = imapClient.Support("AUTH=" + config.AuthMethod)
+	}
+	if err == nil && !supported {
+		err = fmt.Errorf(
+			"server does not advertise AUTH=%s, choose another authentication method",
+			config.AuthMethod,
+		)
+	}
+	if err == nil {
+		logInfo(fmt.Sprintf("logging in as %s via %s", config.User, config.AuthMethod))
+		err = imapClient.Authenticate(scram)
+	}
+	if err == nil && !scram.verified() {
+		err = errors.New("server did not prove that it knows the password")
+	}
+	if err != nil {
+		logError("cannot log in")
+		return imapClient, fmt.Errorf("%w: %w", ErrLoginFailed, err)
+	}
+	logInfo("logged in")
+	return imapClient, nil
+}
+
+// Ensure the SASL client interface is implemented.
+var _ sasl.Client = &scramClient{}
@@ -0,0 +1,336 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xdg-go/scram"
+)
+
+// Use a fixed client nonce, e.g. to reproduce the examples of the RFCs.
+func setScramNonce(t *testing.T, nonce string) {
+	orgNonce := scramNonce
+	scramNonce = func() string { return nonce }
+	t.Cleanup(func() { scramNonce = orgNonce })
+}
+
+// Revert escaping names for use in SCRAM messages. The server side of the library does not do it.
+func scramUnescape(name string) string {
+	return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(name)
+}
+
+// Type fakeScramServer implements the server side of the SASL SCRAM mechanisms. It knows the
+// password of a single user and reports the authorization identity that a client asked for.
+type fakeScramServer struct {
+	t        *testing.T
+	method   string
+	username string
+	password string
+	// Causes the server to accept any proof and to send a made-up signature, i.e. to be an
+	// impostor that does not know the password.
+	impostor bool
+	// Causes the server to report success without sending its signature at all.
+	skipFinal bool
+
+	authzID string
+}
+
+// Run the challenge/response flow with a client the way a server would via AUTHENTICATE. An error
+// is returned if the server rejects the client or if the client rejects the server.
+func (s *fakeScramServer) authenticate(client sasl.Client) error {
+	hashGen := scram.SHA256
+	if s.method == AuthMethodScramSHA1 {
+		hashGen = scram.SHA1
+	}
+	credentials, err := hashGen.NewClient(s.username, s.password, "")
+	require.NoError(s.t, err)
+	server, err := hashGen.NewServer(func(username string) (scram.StoredCredentials, error) {
+		if scramUnescape(username) != s.username {
+			return scram.StoredCredentials{}, fmt.Errorf("unknown user")
+		}
+		factors := scram.KeyFactors{Salt: "some salt", Iters: 4096}
+		return credentials.GetStoredCredentials(factors), nil
+	})
+	require.NoError(s.t, err)
+	conversation := server.NewConversation()
+
+	mech, ir, err := client.Start()
+	require.NoError(s.t, err)
+	assert.Equal(s.t, s.method, mech)
+	serverFirst, err := conversation.Step(string(ir))
+	if err != nil {
+		return err
+	}
+	s.authzID = scramUnescape(conversation.AuthzID())
+	clientFinal, err := client.Next([]byte(serverFirst))
+	if err != nil {
+		return err
+	}
+	serverFinal, err := conversation.Step(string(clientFinal))
+	switch {
+	case s.skipFinal:
+		return nil
+	case s.impostor:
+		serverFinal = "v=" + base64.StdEncoding.EncodeToString([]byte("made-up signature"))
+	case err != nil:
+		// The client learns why it has been rejected via the server's final message.
+		_, clientErr := client.Next([]byte(serverFinal))
+		return errors.Join(err, clientErr)
+	}
+	response, err := client.Next([]byte(serverFinal))
+	if err != nil {
+		return err
+	}
+	assert.Empty(s.t, response)
+	return nil
+}
+
+func TestScramClientRFC5802Example(t *testing.T) {
+	setScramNonce(t, "fyko+d2lbbFgONRv9qkxdawL")
+	client, err := newScramClient(AuthMethodScramSHA1, "", "user", "pencil")
+	require.NoError(t, err)
+
+	mech, ir, err := client.Start()
+	require.NoError(t, err)
+	assert.Equal(t, "SCRAM-SHA-1", mech)
+	assert.Equal(t, "n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL", string(ir))
+
+	response, err := client.Next([]byte(
+		"r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+	))
+	require.NoError(t, err)
+	assert.Equal(t,
+		"c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+		string(response),
+	)
+
+	response, err = client.Next([]byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ="))
+	assert.NoError(t, err)
+	assert.Empty(t, response)
+}
+
+func TestScramClientRFC7677Example(t *testing.T) {
+	setScramNonce(t, "rOprNGfwEbeRWgbNEkqO")
+	client, err := newScramClient(AuthMethodScramSHA256, "", "user", "pencil")
+	require.NoError(t, err)
+
+	_, ir, err := client.Start()
+	require.NoError(t, err)
+	assert.Equal(t, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO", string(ir))
+
+	response, err := client.Next([]byte(
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==," +
+			"i=4096",
+	))
+	require.NoError(t, err)
+	assert.Equal(t,
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,"+
+			"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		string(response),
+	)
+
+	response, err = client.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+	assert.NoError(t, err)
+	assert.Empty(t, response)
+
+	_, err = client.Next([]byte("unexpected"))
+	assert.ErrorContains(t, err, "unexpected challenge")
+}
+
+func TestScramClientFakeServer(t *testing.T) {
+	for _, method := range []string{AuthMethodScramSHA1, AuthMethodScramSHA256} {
+		server := &fakeScramServer{
+			t: t, method: method, username: "some=one", password: "some password",
+		}
+		client, err := newScramClient(method, "shared,box", "some=one", "some password")
+		require.NoError(t, err)
+
+		err = server.authenticate(client)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "shared,box", server.authzID)
+	}
+}
+
+func TestScramClientFakeServerWrongPassword(t *testing.T) {
+	server := &fakeScramServer{
+		t: t, method: AuthMethodScramSHA256, username: "someone", password: "some password",
+	}
+	client, err := newScramClient(AuthMethodScramSHA256, "", "someone", "wrong password")
+	require.NoError(t, err)
+
+	err = server.authenticate(client)
+
+	assert.ErrorContains(t, err, "SCRAM-SHA-256 authentication failed: server error: invalid-proof")
+}
+
+func TestScramClientFakeServerImpostor(t *testing.T) {
+	server := &fakeScramServer{
+		t: t, method: AuthMethodScramSHA256, username: "someone", password: "some password",
+		impostor: true,
+	}
+	client, err := newScramClient(AuthMethodScramSHA256, "", "someone", "some password")
+	require.NoError(t, err)
+
+	err = server.authenticate(client)
+
+	assert.ErrorContains(t, err, "SCRAM-SHA-256 authentication failed: server validation failed")
+}
+
+func TestScramClientInvalidServerFirst(t *testing.T) {
+	setScramNonce(t, "nonce")
+	for challenge, valid := range map[string]bool{
+		"r=nonce2,s=c2FsdA==,i=4096":       true,
+		"e=unknown-user":                   false,
+		"r=other,s=c2FsdA==,i=4096":        false,
+		"r=nonce2,s=%,i=4096":              false,
+		"r=nonce2,s=c2FsdA==,i=1":          false,
+		"r=nonce2,s=c2FsdA==,i=many":       false,
+		"r=nonce2,s=c2FsdA==":              false,
+		"m=ext,r=nonce2,s=c2FsdA==,i=4096": false,
+	} {
+		client, err := newScramClient(AuthMethodScramSHA256, "", "someone", "some password")
+		require.NoError(t, err)
+		_, _, err = client.Start()
+		require.NoError(t, err)
+
+		_, err = client.Next([]byte(challenge))
+
+		if valid {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, "SCRAM-SHA-256 authentication failed", challenge)
+		}
+	}
+}
+
+func TestNewScramClientUnknownMethod(t *testing.T) {
+	_, err := newScramClient("SCRAM-MD5", "", "someone", "some password")
+
+	assert.ErrorContains(t, err, "unknown authentication method 'SCRAM-MD5'")
+}
+
+func TestNewScramClientProhibitedCharacters(t *testing.T) {
+	// SASLprep prohibits control characters.
+	_, err := newScramClient(AuthMethodScramSHA256, "", "some\u0007one", "some password")
+
+	assert.ErrorContains(t, err, "cannot use credentials for SCRAM-SHA-256")
+}
+
+func TestAuthenticateClientScram(t *testing.T) {
+	mockClient := setUpMockClient(t, nil, nil, nil)
+	mockClient.On("Support", "AUTH=SCRAM-SHA-256").Return(true, nil)
+	server := &fakeScramServer{
+		t: t, method: AuthMethodScramSHA256, username: "someone", password: "some password",
+	}
+	mockClient.On("Authenticate", mock.AnythingOfType("*core.scramClient")).
+		Run(func(args mock.Arguments) {
+			assert.NoError(t, server.authenticate(args.Get(0).(sasl.Client)))
+		}).
+		Return(nil)
+
+	config := IMAPConfig{
+		User:       "someone",
+		Password:   "some password",
+		AuthMethod: AuthMethodScramSHA256,
+	}
+
+	client, err := authenticateClient(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, client, mockClient)
+}
+
+func TestAuthenticateClientScramServerUnverified(t *testing.T) {
+	mockClient := setUpMockClient(t, nil, nil, nil)
+	mockClient.On("Support", "AUTH=SCRAM-SHA-256").Return(true, nil)
+	// The server reports success right after the client's proof without proving anything itself.
+	server := &fakeScramServer{
+		t: t, method: AuthMethodScramSHA256, username: "someone", password: "some password",
+		skipFinal: true,
+	}
+	mockClient.On("Authenticate", mock.AnythingOfType("*core.scramClient")).
+		Run(func(args mock.Arguments) {
+			assert.NoError(t, server.authenticate(args.Get(0).(sasl.Client)))
+		}).
+		Return(nil)
+
+	config := IMAPConfig{
+		User:       "someone",
+		Password:   "some password",
+		AuthMethod: AuthMethodScramSHA256,
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "server did not prove that it knows the password")
+}
+
+func TestAuthenticateClientScramNotAdvertised(t *testing.T) {
+	mockClient := setUpMockClient(t, nil, nil, nil)
+	mockClient.On("Support", "AUTH=SCRAM-SHA-1").Return(false, nil)
+
+	config := IMAPConfig{
+		User:       "someone",
+		Password:   "some password",
+		AuthMethod: AuthMethodScramSHA1,
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorIs(t, err, ErrLoginFailed)
+	assert.ErrorContains(t, err, "server does not advertise AUTH=SCRAM-SHA-1")
+}
+
+func TestAuthenticateClientScramUnknownMethod(t *testing.T) {
+	_ = setUpMockClient(t, nil, nil, nil)
+
+	config := IMAPConfig{
+		User:       "someone",
+		Password:   "some password",
+		AuthMethod: "SCRAM-MD5",
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "unknown authentication method")
+}
+
+func TestAuthenticateClientScramWithOAuth2(t *testing.T) {
+	_ = setUpMockClient(t, nil, nil, nil)
+
+	config := IMAPConfig{
+		User:       "someone",
+		AuthMethod: AuthMethodScramSHA256,
+		OAuth2:     OAuth2Config{RefreshToken: "some token"},
+	}
+
+	_, err := authenticateClient(config)
+
+	assert.ErrorContains(t, err, "cannot be used with OAuth2")
+}
@@ -0,0 +1,89 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/emersion/go-imap"
+)
+
+// FolderSearchResult lists the emails in a folder on the server that match a search. Each entry of
+// UIDs has the form "<UIDVALIDITY>/<UID>", which is also understood by DownloadOptions.UIDFile.
+type FolderSearchResult struct {
+	Name string   `json:"name"`
+	UIDs []string `json:"uids"`
+}
+
+// Search a folder on the server for emails matching the given criteria. The folder is selected in
+// read-only mode and only the UIDs of matching emails are retrieved, in ascending order.
+func searchFolder(
+	imapClient imapOps, folder string, criteria *imap.SearchCriteria,
+) ([]uidExt, error) {
+	mbox, err := selectFolder(imapClient, folder)
+	if err != nil {
+		return nil, err
+	}
+	logInfo(fmt.Sprintf("searching folder %s", folder))
+	found, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i] < found[j] })
+	uids := make([]uidExt, 0, len(found))
+	for _, msg := range found {
+		uids = append(uids, uidExt{folder: uidFolder(mbox.UidValidity), msg: uid(msg)})
+	}
+	logInfo(fmt.Sprintf("found %d matching emails", len(uids)))
+	return uids, nil
+}
+
+// SearchFolders searches each folder matching the given folder specs, see DownloadFolder, for
+// emails matching the given criteria without retrieving the emails themselves. Nil criteria match
+// all emails. Results are in the order in which the server lists the folders. A single connection
+// is used and folders are selected in read-only mode.
+func SearchFolders(
+	cfg IMAPConfig, folderSpecs []string, criteria *imap.SearchCriteria,
+) ([]FolderSearchResult, error) {
+	if criteria == nil {
+		criteria = imap.NewSearchCriteria()
+	}
+	ops := NewImapgrabOps()
+	errs := threadSafeErrors{verbose: true}
+	errs.add(ops.authenticateClient(cfg))
+	if errs.bad() {
+		return nil, errs.err()
+	}
+	availableFolders, listErr := ops.getFolderList()
+	errs.add(listErr)
+	var results []FolderSearchResult
+	if listErr == nil {
+		for _, folder := range expandFolders(folderSpecs, availableFolders) {
+			uids, searchErr := ops.searchFolder(folder, criteria)
+			errs.add(searchErr)
+			result := FolderSearchResult{Name: folder, UIDs: make([]string, 0, len(uids))}
+			for _, msg := range uids {
+				result.UIDs = append(result.UIDs, msg.String())
+			}
+			results = append(results, result)
+		}
+	}
+	errs.add(ops.logout(false))
+	return results, errs.err()
+}
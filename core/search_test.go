@@ -0,0 +1,103 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchFolder(t *testing.T) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	m := &mockClient{}
+	m.On("Select", "some folder", true).Return(&imap.MailboxStatus{UidValidity: 42}, nil)
+	m.On("UidSearch", criteria).Return([]uint32{7, 3}, nil)
+
+	uids, err := searchFolder(m, "some folder", criteria)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uidExt{{folder: 42, msg: 3}, {folder: 42, msg: 7}}, uids)
+	m.AssertExpectations(t)
+}
+
+func TestSearchFolderSelectError(t *testing.T) {
+	m := &mockClient{}
+	m.On("Select", "some folder", true).
+		Return(&imap.MailboxStatus{}, fmt.Errorf("some error"))
+
+	uids, err := searchFolder(m, "some folder", imap.NewSearchCriteria())
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Nil(t, uids)
+	m.AssertExpectations(t)
+}
+
+func TestSearchFolderSearchError(t *testing.T) {
+	m := &mockClient{}
+	m.On("Select", "some folder", true).Return(&imap.MailboxStatus{UidValidity: 42}, nil)
+	m.On("UidSearch", imap.NewSearchCriteria()).Return([]uint32{}, fmt.Errorf("some error"))
+
+	uids, err := searchFolder(m, "some folder", imap.NewSearchCriteria())
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Nil(t, uids)
+	m.AssertExpectations(t)
+}
+
+func TestSearchFolders(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	criteria := imap.NewSearchCriteria()
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderList").Return([]string{"f1", "f2", "f3"}, nil)
+	mock.On("logout", false).Return(nil)
+	mock.On("searchFolder", "f1", criteria).
+		Return([]uidExt{{folder: 42, msg: 1}, {folder: 42, msg: 5}}, nil)
+	mock.On("searchFolder", "f3", criteria).Return([]uidExt{}, fmt.Errorf("some error"))
+
+	setUpCoreTest(t, mock)
+
+	results, err := SearchFolders(cfg, []string{"_ALL_", "-f2"}, nil)
+
+	assert.ErrorContains(t, err, "some error")
+	expected := []FolderSearchResult{
+		{Name: "f1", UIDs: []string{"42/1", "42/5"}}, {Name: "f3", UIDs: []string{}},
+	}
+	assert.Equal(t, expected, results)
+	mock.AssertExpectations(t)
+}
+
+func TestSearchFoldersLoginError(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(fmt.Errorf("some error"))
+
+	setUpCoreTest(t, mock)
+
+	results, err := SearchFolders(cfg, []string{"_ALL_"}, imap.NewSearchCriteria())
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Nil(t, results)
+	mock.AssertExpectations(t)
+}
@@ -0,0 +1,93 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FolderSize reports how many files the local maildir of a folder consists of and how many bytes
+// they take up.
+type FolderSize struct {
+	Folder string `json:"folder"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// GetMaildirSizes determines the storage taken up by every maildir below a base directory, e.g. to
+// find folders worth pruning. Folders are named by the paths of their maildirs relative to the
+// base directory and are sorted by name. All regular files within a maildir count towards it,
+// including those with information about downloaded emails, except for those within nested
+// maildirs, which are reported separately. Symlinks are not followed, but hard links created via
+// DownloadOptions.Dedup count towards every folder containing them. Nothing is changed and the
+// server is not contacted.
+func GetMaildirSizes(maildirBase string) ([]FolderSize, error) {
+	if !isDir(maildirBase) {
+		return nil, fmt.Errorf("given directory %s does not exist", maildirBase)
+	}
+	// The folder of the maildir that every directory belongs to, if any.
+	folders := map[string]string{}
+	sizes := map[string]*FolderSize{}
+	err := filepath.WalkDir(maildirBase, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if !isMaildir(current) {
+				folders[current] = folders[filepath.Dir(current)]
+				return nil
+			}
+			folder, err := filepath.Rel(maildirBase, current)
+			if err != nil {
+				return err
+			}
+			folders[current] = folder
+			sizes[folder] = &FolderSize{Folder: folder}
+			return nil
+		}
+		size, found := sizes[folders[filepath.Dir(current)]]
+		if !found || !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// The file has been removed in the meantime.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size.Files++
+		size.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]FolderSize, 0, len(sizes))
+	for _, size := range sizes {
+		results = append(results, *size)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Folder < results[j].Folder })
+	return results, nil
+}
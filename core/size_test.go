@@ -0,0 +1,75 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMaildirSizes(t *testing.T) {
+	base := setUpEmptyMaildir(t, "INBOX", "oldmail-INBOX")
+	for _, dir := range []string{"cur", "new", "tmp"} {
+		err := os.MkdirAll(filepath.Join(base, "account", "Sent", dir), dirPerm)
+		assert.NoError(t, err)
+		err = os.MkdirAll(filepath.Join(base, "INBOX", ".pruned", dir), dirPerm)
+		assert.NoError(t, err)
+	}
+	for name, content := range map[string]string{
+		"INBOX/cur/1:2,S":        "12345",
+		"INBOX/new/2":            "123",
+		"INBOX/" + uidListName:   "42/1 1\n",
+		"INBOX/.pruned/cur/3":    "1",
+		"account/Sent/new/4":     "1234567890",
+		"account/not-an-email":   "ignored",
+		"oldmail-INBOX-is-empty": "ignored",
+	} {
+		err := os.WriteFile(filepath.Join(base, name), []byte(content), filePerm)
+		assert.NoError(t, err)
+	}
+	err := os.Symlink(
+		filepath.Join(base, "INBOX", "new", "2"), filepath.Join(base, "account/Sent/cur/2"),
+	)
+	assert.NoError(t, err)
+
+	sizes, err := GetMaildirSizes(base)
+
+	assert.NoError(t, err)
+	expected := []FolderSize{
+		{Folder: "INBOX", Files: 3, Bytes: 15},
+		{Folder: filepath.Join("INBOX", ".pruned"), Files: 1, Bytes: 1},
+		{Folder: filepath.Join("account", "Sent"), Files: 1, Bytes: 10},
+	}
+	assert.Equal(t, expected, sizes)
+}
+
+func TestGetMaildirSizesEmpty(t *testing.T) {
+	sizes, err := GetMaildirSizes(t.TempDir())
+
+	assert.NoError(t, err)
+	assert.Empty(t, sizes)
+}
+
+func TestGetMaildirSizesMissingBase(t *testing.T) {
+	_, err := GetMaildirSizes(filepath.Join(t.TempDir(), "missing"))
+
+	assert.ErrorContains(t, err, "does not exist")
+}
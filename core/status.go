@@ -0,0 +1,193 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// The capability of servers that can report the size of a folder via STATUS, see RFC 8438.
+	statusSizeCapability = "STATUS=SIZE"
+	statusSize           = imap.StatusItem("SIZE")
+)
+
+// FolderSummary provides an overview over a folder on the server.
+type FolderSummary struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Unseen   int    `json:"unseen"`
+	// Size is the total size of all emails in the folder in bytes.
+	Size int64 `json:"size"`
+	// Namespace is the kind of namespace the folder belongs to, i.e. one of NamespacePersonal,
+	// NamespaceOther, and NamespaceShared. It is empty if the server does not report namespaces.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// FolderCount provides the number of emails in a folder on the server.
+type FolderCount struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+}
+
+// Obtain the number of emails in a folder via STATUS. Unlike selecting the folder, this does not
+// affect the state of the connection and no information about the emails themselves is retrieved.
+func getMessageCount(imapClient imapOps, folder string) (int, error) {
+	logInfo(fmt.Sprintf("retrieving number of emails in folder %s", folder))
+	status, err := imapClient.Status(folder, []imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		return 0, err
+	}
+	return int(status.Messages), nil
+}
+
+// GetMessageCounts retrieves the number of emails in each folder matching the given folder specs,
+// see DownloadFolder, in the order in which the server lists the folders. This is a cheap
+// operation that uses a single connection and does not retrieve any information about the emails
+// themselves, e.g. for quick health checks.
+func GetMessageCounts(cfg IMAPConfig, folderSpecs []string) ([]FolderCount, error) {
+	ops := NewImapgrabOps()
+	errs := threadSafeErrors{verbose: true}
+	errs.add(ops.authenticateClient(cfg))
+	if errs.bad() {
+		return nil, errs.err()
+	}
+	availableFolders, listErr := ops.getFolderList()
+	errs.add(listErr)
+	var counts []FolderCount
+	if listErr == nil {
+		for _, folder := range expandFolders(folderSpecs, availableFolders) {
+			messages, countErr := ops.getMessageCount(folder)
+			errs.add(countErr)
+			counts = append(counts, FolderCount{Name: folder, Messages: messages})
+		}
+	}
+	errs.add(ops.logout(false))
+	return counts, errs.err()
+}
+
+// Obtain a summary for a folder via STATUS. If the server cannot report the size of a folder that
+// way, the folder is selected in read-only mode and the sizes of all emails are added up instead.
+func getFolderSummary(
+	imapClient imapOps, folder string, bufferSize int,
+) (FolderSummary, error) {
+	logInfo(fmt.Sprintf("retrieving status of folder %s", folder))
+	withSize, err := imapClient.Support(statusSizeCapability)
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen}
+	if withSize {
+		items = append(items, statusSize)
+	}
+	var status *imap.MailboxStatus
+	if err == nil {
+		status, err = imapClient.Status(folder, items)
+	}
+	summary := FolderSummary{Name: folder}
+	if err != nil {
+		return summary, err
+	}
+	summary.Messages = int(status.Messages)
+	summary.Unseen = int(status.Unseen)
+	if withSize {
+		summary.Size, err = strconv.ParseInt(fmt.Sprint(status.Items[statusSize]), 10, 64)
+	} else {
+		summary.Size, err = sumMessageSizes(imapClient, folder, status.Messages, bufferSize)
+	}
+	return summary, err
+}
+
+// Determine the total size of all emails in a folder by retrieving the size of each one.
+func sumMessageSizes(
+	imapClient imapOps, folder string, numMessages uint32, bufferSize int,
+) (int64, error) {
+	if numMessages == 0 {
+		return 0, nil
+	}
+	mbox, err := selectFolder(imapClient, folder)
+	if err != nil || mbox.Messages == 0 {
+		return 0, err
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, mbox.Messages)
+	messageChannel := make(chan *imap.Message, bufferSize)
+	items := []imap.FetchItem{imap.FetchRFC822Size}
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- imapClient.Fetch(seqset, items, messageChannel)
+	}()
+	var size int64
+	for m := range messageChannel {
+		if m != nil {
+			size += int64(m.Size)
+		}
+	}
+	return size, <-errChannel
+}
+
+// GetFolderSummaries retrieves a summary for each folder in a mailbox. The folders are processed
+// concurrently by the given number of threads, each of which uses its own connection. A number of
+// threads of 0 or less uses DefaultMaxThreads. The summaries are returned in the order in which
+// the server lists the folders. If any thread cannot log in, no summaries are returned since those
+// of its folders would be missing.
+func GetFolderSummaries(cfg IMAPConfig, threads int) ([]FolderSummary, error) {
+	if threads <= 0 {
+		threads = DefaultMaxThreads
+	}
+	folders, err := GetAllFolders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]FolderSummary, len(folders))
+	errs := threadSafeErrors{verbose: true}
+	var loginFailed atomic.Bool
+
+	partitions := partitionFolders(folders, threads)
+	var wg sync.WaitGroup
+	for idx := range partitions {
+		partition, partitionIdx := partitions[idx], idx // Avoid closing over loop variables.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ops := NewImapgrabOps()
+			if loginErr := ops.authenticateClient(cfg); loginErr != nil {
+				errs.add(loginErr)
+				loginFailed.Store(true)
+				return
+			}
+			defer func() { errs.add(ops.logout(false)) }()
+			namespaces, namespaceErr := ops.getNamespaces()
+			errs.add(namespaceErr)
+			for folderIdx, folder := range partition {
+				summary, statusErr := ops.getFolderSummary(folder)
+				errs.add(statusErr)
+				summary.Namespace = namespaces.kindOf(folder)
+				// Folders have been distributed across partitions in a round-robin fashion.
+				summaries[folderIdx*len(partitions)+partitionIdx] = summary
+			}
+		}()
+	}
+	wg.Wait()
+	if loginFailed.Load() {
+		return nil, errs.err()
+	}
+	return summaries, errs.err()
+}
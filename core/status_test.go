@@ -0,0 +1,216 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetFolderSummaryWithStatusSize(t *testing.T) {
+	status := &imap.MailboxStatus{
+		Messages: 3,
+		Unseen:   1,
+		Items:    map[imap.StatusItem]interface{}{statusSize: "1234"},
+	}
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen, statusSize}
+
+	m := &mockClient{}
+	m.On("Support", statusSizeCapability).Return(true, nil)
+	m.On("Status", "some folder", items).Return(status, nil)
+
+	summary, err := getFolderSummary(m, "some folder", defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t, FolderSummary{Name: "some folder", Messages: 3, Unseen: 1, Size: 1234}, summary,
+	)
+	m.AssertExpectations(t)
+}
+
+func TestGetFolderSummaryAddingUpSizes(t *testing.T) {
+	status := &imap.MailboxStatus{Messages: 2, Unseen: 2}
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen}
+
+	m := &mockClient{}
+	m.On("Support", statusSizeCapability).Return(false, nil)
+	m.On("Status", "some folder", items).Return(status, nil)
+	m.On("Select", "some folder", true).Return(status, nil)
+	m.On("Fetch", mock.Anything, []imap.FetchItem{imap.FetchRFC822Size}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			assert.Equal(t, "1:2", args.Get(0).(*imap.SeqSet).String())
+			ch := args.Get(2).(chan *imap.Message)
+			ch <- &imap.Message{Size: 10}
+			ch <- &imap.Message{Size: 32}
+		}).
+		Return(nil)
+
+	summary, err := getFolderSummary(m, "some folder", defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Equal(t, FolderSummary{Name: "some folder", Messages: 2, Unseen: 2, Size: 42}, summary)
+	m.AssertExpectations(t)
+}
+
+func TestGetFolderSummaryNotSelectingEmptyFolder(t *testing.T) {
+	status := &imap.MailboxStatus{}
+
+	m := &mockClient{}
+	m.On("Support", statusSizeCapability).Return(false, nil)
+	m.On("Status", "some folder", mock.Anything).Return(status, nil)
+
+	summary, err := getFolderSummary(m, "some folder", defaultMessageRetrievalBuffer)
+
+	assert.NoError(t, err)
+	assert.Equal(t, FolderSummary{Name: "some folder"}, summary)
+	m.AssertExpectations(t)
+}
+
+func TestGetFolderSummaryStatusError(t *testing.T) {
+	m := &mockClient{}
+	m.On("Support", statusSizeCapability).Return(false, nil)
+	m.On("Status", "some folder", mock.Anything).
+		Return(&imap.MailboxStatus{}, fmt.Errorf("some error"))
+
+	_, err := getFolderSummary(m, "some folder", defaultMessageRetrievalBuffer)
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestGetFolderSummaries(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	folders := []string{"f1", "f2", "f3"}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderList").Return(folders, nil)
+	mock.On("getNamespaces").Return(Namespaces{}, nil)
+	mock.On("logout", false).Return(nil)
+	for idx, folder := range folders {
+		mock.On("getFolderSummary", folder).
+			Return(FolderSummary{Name: folder, Messages: idx}, nil)
+	}
+
+	setUpCoreTest(t, mock)
+
+	summaries, err := GetFolderSummaries(cfg, 2) // nolint: gomnd
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]FolderSummary{{Name: "f1"}, {Name: "f2", Messages: 1}, {Name: "f3", Messages: 2}},
+		summaries,
+	)
+	mock.AssertExpectations(t)
+}
+
+func TestGetFolderSummariesListError(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(fmt.Errorf("some error"))
+
+	setUpCoreTest(t, mock)
+
+	summaries, err := GetFolderSummaries(cfg, 0)
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Nil(t, summaries)
+}
+
+func TestGetFolderSummariesDefaultThreads(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	folders := []string{"f1", "f2", "f3"}
+
+	mock := &mockImapgrabber{}
+	// One connection to list the folders and one per folder, which is fewer than the default
+	// number of threads.
+	mock.On("authenticateClient", cfg).Return(nil).Times(4) // nolint: gomnd
+	mock.On("getFolderList").Return(folders, nil)
+	mock.On("getNamespaces").Return(Namespaces{}, nil)
+	mock.On("logout", false).Return(nil)
+	for _, folder := range folders {
+		mock.On("getFolderSummary", folder).Return(FolderSummary{Name: folder}, nil)
+	}
+
+	setUpCoreTest(t, mock)
+
+	summaries, err := GetFolderSummaries(cfg, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, len(folders), len(summaries))
+	mock.AssertExpectations(t)
+}
+
+func TestGetFolderSummariesThreadLoginError(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+	folders := []string{"f1", "f2"}
+
+	mock := &mockImapgrabber{}
+	// Listing the folders and one of the threads succeed to log in, the other one does not.
+	mock.On("authenticateClient", cfg).Return(nil).Twice()
+	mock.On("authenticateClient", cfg).Return(fmt.Errorf("some error"))
+	mock.On("getFolderList").Return(folders, nil)
+	mock.On("getNamespaces").Return(Namespaces{}, nil)
+	mock.On("logout", false).Return(nil)
+	for _, folder := range folders {
+		mock.On("getFolderSummary", folder).Return(FolderSummary{Name: folder}, nil).Maybe()
+	}
+
+	setUpCoreTest(t, mock)
+
+	summaries, err := GetFolderSummaries(cfg, 2) // nolint: gomnd
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Nil(t, summaries)
+}
+
+func TestGetMessageCount(t *testing.T) {
+	m := &mockClient{}
+	m.On("Status", "some folder", []imap.StatusItem{imap.StatusMessages}).
+		Return(&imap.MailboxStatus{Messages: 3}, nil)
+
+	count, err := getMessageCount(m, "some folder")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	m.AssertExpectations(t)
+}
+
+func TestGetMessageCounts(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some user", Password: "secret"}
+
+	mock := &mockImapgrabber{}
+	mock.On("authenticateClient", cfg).Return(nil)
+	mock.On("getFolderList").Return([]string{"f1", "f2", "f3"}, nil)
+	mock.On("logout", false).Return(nil)
+	mock.On("getMessageCount", "f1").Return(1, nil)
+	mock.On("getMessageCount", "f3").Return(0, fmt.Errorf("some error"))
+
+	setUpCoreTest(t, mock)
+
+	counts, err := GetMessageCounts(cfg, []string{"_ALL_", "-f2"})
+
+	assert.ErrorContains(t, err, "some error")
+	assert.Equal(t, []FolderCount{{Name: "f1", Messages: 1}, {Name: "f3"}}, counts)
+	mock.AssertExpectations(t)
+}
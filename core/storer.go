@@ -0,0 +1,302 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// The format of names of files named after the keys of their emails.
+	uidFileNameFormat = "%d.%d.eml"
+	// The format and glob pattern of the paths of monthly maildirs relative to a folder's maildir.
+	datePartitionFormat  = "2006/01"
+	datePartitionPattern = "[0-9][0-9][0-9][0-9]/[0-9][0-9]"
+)
+
+// Storer abstracts away where downloaded emails are being stored. By default, emails are stored in
+// a local maildir. Implement this interface and set DownloadOptions.NewStorer to store emails
+// elsewhere instead, e.g. in some object storage, or DownloadOptions.AdditionalStorers to store
+// them there as well.
+//
+// Every email is identified by a key of the form "<UIDVALIDITY>/<UID>", which is unique within a
+// folder. A Storer is used for exactly one folder and only by one goroutine at a time.
+type Storer interface {
+	// Exists determines whether an email with the given key has already been stored. This is used
+	// to determine which emails have to be downloaded.
+	Exists(key string) (bool, error)
+	// Write stores the content of an email, formatted according to RFC822, under the key given in
+	// the info.
+	Write(info EmailInfo, content io.Reader) error
+}
+
+// EmailInfo contains meta data about an email that is being stored.
+type EmailInfo struct {
+	// Key identifies the email, see Storer.
+	Key string
+	// InternalDate is the date at which the server received the email.
+	InternalDate time.Time
+	// Flags are the flags of the email, e.g. "\Seen", if they have been retrieved.
+	Flags []string
+	// BodyStructure is the MIME structure of the email, if it has been retrieved.
+	BodyStructure *BodyStructure
+	// Size is the size of the email in bytes according to the server, i.e. with CRLF line endings,
+	// if it has been retrieved. It is zero otherwise.
+	Size int64
+	// Gmail holds Gmail's labels and IDs of the email, if they have been retrieved.
+	Gmail *GmailInfo
+}
+
+// Provide the key used to identify an email in a Storer.
+func (om oldmail) key() string {
+	return uidExt{folder: om.uidFolder, msg: om.uid}.String()
+}
+
+// Provide the meta data handed to a Storer when writing an email.
+func (om oldmail) info() EmailInfo {
+	return EmailInfo{
+		Key: om.key(), InternalDate: time.Unix(int64(om.timestamp), 0).UTC(), Flags: om.flags,
+		BodyStructure: om.structure, Size: om.size, Gmail: om.gmail,
+	}
+}
+
+// Type maildirStorer is the default Storer. It delivers emails to a maildir and uses the oldmail
+// information from previous runs to determine which emails have already been stored. The oldmail
+// file itself is updated separately.
+type maildirStorer struct {
+	path  string
+	known map[string]struct{}
+	// uidNames causes files to be named after the keys of their emails, see uidFileName.
+	uidNames bool
+	// hostID, if set, replaces the host name in unique file names, see newUniqueName.
+	hostID string
+	// byDate causes emails to be delivered to one maildir per month, see LayoutDate.
+	byDate bool
+	// nameTemplate, if set, determines the names of files, see DownloadOptions.FileNameTemplate.
+	nameTemplate *template.Template
+	// dedup, if set, replaces duplicate emails by links to existing files, see DownloadOptions.Dedup.
+	dedup *hashStore
+	// withFlags causes files to carry maildir flags, see DownloadOptions.MaildirFlags.
+	withFlags bool
+	// drafts causes every email to be flagged as a draft, which is used for drafts folders.
+	drafts bool
+	// subdir determines the sub-directory emails are delivered to, see DownloadOptions.MaildirSubdir.
+	subdir MaildirSubdir
+	// metadataInNames causes the UIDs and sizes of emails to be added to the names of their files,
+	// see DownloadOptions.FileNameMetadata.
+	metadataInNames bool
+}
+
+func newMaildirStorer(path string, oldmails []oldmail) *maildirStorer {
+	known := make(map[string]struct{}, len(oldmails))
+	for _, om := range oldmails {
+		known[om.key()] = struct{}{}
+	}
+	return &maildirStorer{path: path, known: known}
+}
+
+// Name files after the keys of their emails instead of uniquely. Emails whose files are named that
+// way are considered stored even if they are missing from the oldmail file.
+func (s *maildirStorer) nameByUID() error {
+	s.uidNames = true
+	paths, err := s.maildirs()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		for _, dir := range []string{curMaildir, newMaildir} {
+			entries, err := os.ReadDir(filepath.Join(path, dir))
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if key, ok := keyFromUIDFileName(entry.Name()); ok {
+					s.known[key] = struct{}{}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Determine the paths of all maildirs that emails might have been delivered to, i.e. the folder's
+// maildir and, with the date layout, all monthly maildirs within it.
+func (s *maildirStorer) maildirs() ([]string, error) {
+	paths := []string{s.path}
+	if !s.byDate {
+		return paths, nil
+	}
+	partitions, err := filepath.Glob(filepath.Join(s.path, datePartitionPattern))
+	for _, partition := range partitions {
+		if isMaildir(partition) {
+			paths = append(paths, partition)
+		}
+	}
+	return paths, err
+}
+
+// Determine the maildir that an email is delivered to, creating it if needed. With the date layout,
+// that is the maildir of the month in which the server received the email.
+func (s *maildirStorer) target(info EmailInfo) (string, error) {
+	if !s.byDate {
+		return s.path, nil
+	}
+	path := filepath.Join(s.path, info.InternalDate.UTC().Format(datePartitionFormat))
+	for _, dir := range []string{newMaildir, curMaildir, tmpMaildir} {
+		if err := os.MkdirAll(filepath.Join(path, dir), dirPerm); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// Exists determines whether an email has already been stored according to the oldmail file.
+func (s *maildirStorer) Exists(key string) (bool, error) {
+	_, found := s.known[key]
+	return found, nil
+}
+
+// Write delivers an email to the maildir and remembers the name of its file.
+func (s *maildirStorer) Write(info EmailInfo, content io.Reader) error {
+	var fileName string
+	var err error
+	if s.uidNames {
+		fileName, err = uidFileName(info.Key)
+	} else {
+		fileName, err = newUniqueName(s.hostID)
+	}
+	var path string
+	if err == nil {
+		path, err = s.target(info)
+	}
+	if err == nil && s.nameTemplate != nil {
+		fileName, content, err = s.templatedName(path, info, content)
+	}
+	if err == nil && s.metadataInNames {
+		var fields string
+		fields, err = fileNameMetadata(info)
+		fileName += fields
+	}
+	if err != nil {
+		return err
+	}
+	// The hash is computed while writing to avoid reading the file again.
+	hasher := sha256.New()
+	if s.dedup != nil {
+		content = io.TeeReader(content, hasher)
+	}
+	var flags string
+	if s.withFlags {
+		flags = maildirInfoFlags(info.Flags, s.drafts)
+	}
+	cur := s.subdir.cur(flags)
+	fileName, err = deliverMessage(content, path, fileName, flags, cur)
+	if err != nil {
+		return err
+	}
+	s.known[info.Key] = struct{}{}
+	if s.dedup != nil {
+		filePath := filepath.Join(path, deliveredFilePath(fileName, flags, cur))
+		s.dedup.deduplicate(filePath, hasher.Sum(nil))
+	}
+	// Names of files are remembered relative to the folder's maildir, which does not work for
+	// files in monthly maildirs.
+	if s.byDate {
+		return nil
+	}
+	// The email has been stored successfully even if its file name cannot be remembered.
+	if err := appendUIDList(s.path, info.Key, fileName); err != nil {
+		logWarning(fmt.Sprintf("cannot remember file name of email %s: %s", info.Key, err.Error()))
+	}
+	return nil
+}
+
+// Determine the name of the file of an email according to the template. The returned reader
+// provides the content of the email and must be used instead of the original one.
+func (s *maildirStorer) templatedName(
+	path string, info EmailInfo, content io.Reader,
+) (string, io.Reader, error) {
+	fields, content := readFileNameFields(info, content)
+	name, err := executeFileNameTemplate(s.nameTemplate, fields)
+	if err == nil && name == "" {
+		err = fmt.Errorf("file name template results in an empty name for email %s", info.Key)
+	}
+	if err == nil {
+		name, err = uniqueTemplatedName(path, name)
+	}
+	return name, content, err
+}
+
+// Determine the name of the file of an email named after its key, i.e.
+// "<UIDVALIDITY>.<UID>.eml".
+func uidFileName(key string) (string, error) {
+	var folder uidFolder
+	var msg uid
+	if _, err := fmt.Sscanf(key, "%d/%d", &folder, &msg); err != nil {
+		return "", fmt.Errorf("cannot name file after key '%s': %s", key, err.Error())
+	}
+	return fmt.Sprintf(uidFileNameFormat, folder, msg), nil
+}
+
+// Determine the extension fields of the name of the file of an email that state its UID and, if
+// known, its size with CRLF line endings, e.g. ",U=42,W=1234", as used by Dovecot and others.
+func fileNameMetadata(info EmailInfo) (string, error) {
+	var folder uidFolder
+	var msg uid
+	if _, err := fmt.Sscanf(info.Key, "%d/%d", &folder, &msg); err != nil {
+		return "", fmt.Errorf("cannot add UID of email '%s' to file name: %s", info.Key, err.Error())
+	}
+	fields := fmt.Sprintf(",U=%d", msg)
+	if info.Size > 0 {
+		fields += fmt.Sprintf(",W=%d", info.Size)
+	}
+	return fields, nil
+}
+
+// Determine the key of an email from the name of its file, see uidFileName. An info part with flags
+// as added by mail clients, e.g. ":2,S", and extension fields, e.g. ",U=42", are ignored. The
+// second return value is false for files named differently.
+func keyFromUIDFileName(fileName string) (string, bool) {
+	fileName, _, _ = strings.Cut(fileName, ":")
+	fileName, _, _ = strings.Cut(fileName, ",")
+	var folder uidFolder
+	var msg uid
+	if _, err := fmt.Sscanf(fileName, uidFileNameFormat, &folder, &msg); err != nil {
+		return "", false
+	}
+	// Reject names that merely start like names of this scheme.
+	if fmt.Sprintf(uidFileNameFormat, folder, msg) != fileName {
+		return "", false
+	}
+	return uidExt{folder: folder, msg: msg}.String(), true
+}
+
+// Close a storer if it needs closing, i.e. if it implements io.Closer.
+func closeStorer(storer Storer) error {
+	if closer, ok := storer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockStorer struct {
+	mock.Mock
+}
+
+func (m *mockStorer) Exists(key string) (bool, error) {
+	args := m.Called(key)
+	return args.Bool(0), args.Error(1)
+}
+
+// The content is read in completely to simplify setting expectations.
+func (m *mockStorer) Write(info EmailInfo, content io.Reader) error {
+	text, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	args := m.Called(info, string(text))
+	return args.Error(0)
+}
+
+func TestOldmailKey(t *testing.T) {
+	om := oldmail{uidFolder: 42, uid: 7, timestamp: 12345}
+	assert.Equal(t, "42/7", om.key())
+}
+
+func TestOldmailInfo(t *testing.T) {
+	om := oldmail{uidFolder: 42, uid: 7, timestamp: 12345}
+	assert.Equal(
+		t, EmailInfo{Key: "42/7", InternalDate: time.Unix(12345, 0).UTC()}, om.info(),
+	)
+}
+
+func TestMaildirStorerExists(t *testing.T) {
+	oldmails := []oldmail{{uidFolder: 42, uid: 1}, {uidFolder: 42, uid: 3}}
+	storer := newMaildirStorer("", oldmails)
+
+	for key, expected := range map[string]bool{"42/1": true, "42/2": false, "42/3": true} {
+		found, err := storer.Exists(key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, found, key)
+	}
+}
+
+func TestMaildirStorerWrite(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	storer := newMaildirStorer(filepath.Join(tmpdir, "folder"), nil)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(filepath.Join(tmpdir, "folder", "new"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+	// Written emails are known afterwards.
+	found, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestMaildirStorerWriteHostID(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	storer := newMaildirStorer(filepath.Join(tmpdir, "folder"), nil)
+	storer.hostID = "some-host-id"
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(filepath.Join(tmpdir, "folder", "new"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.True(t, strings.HasSuffix(files[0].Name(), ".some-host-id"))
+}
+
+func TestMaildirStorerWriteFlags(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+	storer.uidNames = true
+	storer.withFlags = true
+
+	info := EmailInfo{
+		Key: "42/1", Flags: []string{`\Seen`, `\Draft`, `\Answered`, `\Flagged`, `\Recent`},
+	}
+	err := storer.Write(info, strings.NewReader("some content"))
+	assert.NoError(t, err)
+	// Emails without flags go to the new sub-directory as usual.
+	err = storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("other content"))
+	assert.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "42.1.eml:2,DFRS"))
+	assert.FileExists(t, filepath.Join(folderPath, "new", "42.2.eml"))
+	// File names are remembered without flags.
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"42/1": "42.1.eml", "42/2": "42.2.eml"}, files)
+
+	// All emails in drafts folders are flagged as drafts.
+	storer.drafts = true
+	err = storer.Write(EmailInfo{Key: "42/3"}, strings.NewReader("a draft"))
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "42.3.eml:2,D"))
+}
+
+func TestMaildirStorerWriteSubdirCur(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+	storer.uidNames = true
+	storer.subdir = MaildirSubdirCur
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	assert.NoError(t, err)
+	storer.withFlags = true
+	err = storer.Write(
+		EmailInfo{Key: "42/2", Flags: []string{`\Flagged`}}, strings.NewReader("other content"),
+	)
+	assert.NoError(t, err)
+
+	// Files in the cur sub-directory always carry an info part, even without flags.
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "42.1.eml:2,"))
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "42.2.eml:2,F"))
+	assert.Equal(t, filepath.Join("cur", "42.1.eml:2,"), deliveredPath(folderPath, "42.1.eml"))
+	// Emails are still recognised by the names of their files.
+	storer = newMaildirStorer(folderPath, nil)
+	assert.NoError(t, storer.nameByUID())
+	found, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestMaildirStorerWriteSubdirNew(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+	storer.uidNames = true
+	storer.subdir = MaildirSubdirNew
+
+	err := storer.Write(
+		EmailInfo{Key: "42/1", Flags: []string{`\Seen`}}, strings.NewReader("some content"),
+	)
+	assert.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(folderPath, "new", "42.1.eml"))
+	assert.NoFileExists(t, filepath.Join(folderPath, "cur", "42.1.eml"))
+}
+
+func TestMaildirStorerWriteFileNameMetadata(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+	storer.uidNames = true
+	storer.metadataInNames = true
+	storer.withFlags = true
+
+	err := storer.Write(EmailInfo{Key: "42/1", Size: 1234}, strings.NewReader("some content"))
+	assert.NoError(t, err)
+	// The size is left out if it is unknown.
+	err = storer.Write(
+		EmailInfo{Key: "42/2", Flags: []string{`\Seen`}}, strings.NewReader("other content"),
+	)
+	assert.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(folderPath, "new", "42.1.eml,U=1,W=1234"))
+	assert.FileExists(t, filepath.Join(folderPath, "cur", "42.2.eml,U=2:2,S"))
+	// Emails are still recognised by the names of their files.
+	storer = newMaildirStorer(folderPath, nil)
+	assert.NoError(t, storer.nameByUID())
+	found, err := storer.Exists("42/2")
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestFileNameMetadata(t *testing.T) {
+	fields, err := fileNameMetadata(EmailInfo{Key: "42/7", Size: 99})
+	assert.NoError(t, err)
+	assert.Equal(t, ",U=7,W=99", fields)
+
+	_, err = fileNameMetadata(EmailInfo{Key: "invalid"})
+	assert.Error(t, err)
+}
+
+func TestMaildirStorerWriteError(t *testing.T) {
+	tmpdir := t.TempDir()
+	storer := newMaildirStorer(filepath.Join(tmpdir, "does", "not", "exist"), nil)
+
+	err := storer.Write(EmailInfo{Key: "42/1"}, strings.NewReader("some content"))
+	assert.Error(t, err)
+
+	found, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMaildirStorerNameByUID(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	// Emails named after their keys are detected in both "cur" and "new", even with flags. Other
+	// files are ignored.
+	for _, name := range []string{"cur/42.1.eml:2,S", "new/42.2.eml", "new/42.3.eml.bak", "new/x"} {
+		err := os.WriteFile(filepath.Join(folderPath, name), []byte("content"), filePerm)
+		assert.NoError(t, err)
+	}
+	storer := newMaildirStorer(folderPath, nil)
+
+	err := storer.nameByUID()
+	assert.NoError(t, err)
+
+	for key, expected := range map[string]bool{"42/1": true, "42/2": true, "42/3": false} {
+		found, err := storer.Exists(key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, found, key)
+	}
+
+	err = storer.Write(EmailInfo{Key: "42/4"}, strings.NewReader("some content"))
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(folderPath, "new", "42.4.eml"))
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"42/4": "42.4.eml"}, files)
+
+	err = storer.Write(EmailInfo{Key: "invalid"}, strings.NewReader("some content"))
+	assert.ErrorContains(t, err, "cannot name file after key")
+}
+
+func TestMaildirStorerNameByUIDMissingMaildir(t *testing.T) {
+	storer := newMaildirStorer(filepath.Join(t.TempDir(), "missing"), nil)
+
+	err := storer.nameByUID()
+	assert.Error(t, err)
+}
+
+func TestMaildirStorerWriteByDate(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	storer := newMaildirStorer(folderPath, nil)
+	storer.byDate = true
+	storer.uidNames = true
+	// The month is determined in UTC.
+	date := time.Date(2024, 2, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	err := storer.Write(EmailInfo{Key: "42/1", InternalDate: date}, strings.NewReader("content"))
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(folderPath, "2024", "01", "new", "42.1.eml"))
+	assert.True(t, isMaildir(filepath.Join(folderPath, "2024", "01")))
+	found, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	// File names are not remembered.
+	files, err := readUIDList(folderPath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestMaildirStorerWriteByDateError(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	// A file blocks the creation of the monthly maildir.
+	assert.NoError(t, os.WriteFile(filepath.Join(folderPath, "2024"), nil, filePerm))
+	storer := newMaildirStorer(folderPath, nil)
+	storer.byDate = true
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	err := storer.Write(EmailInfo{Key: "42/1", InternalDate: date}, strings.NewReader("content"))
+
+	assert.Error(t, err)
+}
+
+func TestMaildirStorerNameByUIDByDate(t *testing.T) {
+	tmpdir := setUpEmptyMaildir(t, "folder", "oldmail")
+	folderPath := filepath.Join(tmpdir, "folder")
+	for _, dir := range []string{"2023/12", "2024/01", "2024/notes"} {
+		for _, sub := range []string{"cur", "new", "tmp"} {
+			assert.NoError(t, os.MkdirAll(filepath.Join(folderPath, dir, sub), dirPerm))
+		}
+	}
+	// Emails in all monthly maildirs and the folder's maildir are found, others are ignored.
+	for _, name := range []string{
+		"new/42.1.eml", "2023/12/cur/42.2.eml:2,S", "2024/01/new/42.3.eml", "2024/notes/new/42.4.eml",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(folderPath, name), nil, filePerm))
+	}
+	storer := newMaildirStorer(folderPath, nil)
+	storer.byDate = true
+
+	err := storer.nameByUID()
+	assert.NoError(t, err)
+
+	for key, expected := range map[string]bool{
+		"42/1": true, "42/2": true, "42/3": true, "42/4": false,
+	} {
+		found, err := storer.Exists(key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, found, key)
+	}
+}
+
+func TestKeyFromUIDFileName(t *testing.T) {
+	for fileName, expected := range map[string]string{
+		"42.7.eml":                 "42/7",
+		"42.7.eml:2,FS":            "42/7",
+		"42.7.eml,U=7,W=12:2,S":    "42/7",
+		"42.7.emlx":                "",
+		"42.eml":                   "",
+		"1700000000.M1P2Q3R4.host": "",
+	} {
+		key, ok := keyFromUIDFileName(fileName)
+		assert.Equal(t, expected != "", ok, fileName)
+		assert.Equal(t, expected, key, fileName)
+	}
+}
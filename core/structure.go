@@ -0,0 +1,143 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// The name of the file in a maildir that lists the body structures of emails, one per line.
+const structureName = "imapgrab-bodystructure.jsonl"
+
+// BodyStructure describes the MIME structure of an email or of one of its parts as reported by the
+// server via BODYSTRUCTURE, see DownloadOptions.BodyStructure.
+type BodyStructure struct {
+	// MIMEType is the lower-case content type, e.g. "text/plain" or "multipart/mixed".
+	MIMEType string `json:"mime_type"`
+	// Size is the size in bytes of the encoded part. It is zero for multipart parts.
+	Size uint32 `json:"size,omitempty"`
+	// Encoding is the content transfer encoding, e.g. "base64".
+	Encoding    string `json:"encoding,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	// Filename is the decoded name of an attached file.
+	Filename string          `json:"filename,omitempty"`
+	Parts    []BodyStructure `json:"parts,omitempty"`
+}
+
+// Convert the body structure reported by the server. Servers sometimes report incomplete
+// structures, e.g. without filenames that can be decoded. Whatever is available is kept then.
+func newBodyStructure(structure *imap.BodyStructure) BodyStructure {
+	result := BodyStructure{
+		MIMEType: strings.ToLower(structure.MIMEType + "/" + structure.MIMESubType),
+		Size:     structure.Size,
+		Encoding: strings.ToLower(structure.Encoding),
+	}
+	result.Disposition = strings.ToLower(structure.Disposition)
+	if filename, err := structure.Filename(); err == nil {
+		result.Filename = filename
+	}
+	for _, part := range structure.Parts {
+		if part != nil {
+			result.Parts = append(result.Parts, newBodyStructure(part))
+		}
+	}
+	// Attached emails, i.e. parts of type message/rfc822, have a structure of their own.
+	if structure.BodyStructure != nil && structure.BodyStructure.MIMEType != "" {
+		result.Parts = append(result.Parts, newBodyStructure(structure.BodyStructure))
+	}
+	return result
+}
+
+// Parse the value of the BODYSTRUCTURE fetch item. Incomplete structures are no error so that the
+// email itself can be stored. Instead, nil is returned in that case.
+func parseBodyStructure(value interface{}) *BodyStructure {
+	fields, ok := value.([]interface{})
+	structure := &imap.BodyStructure{}
+	if !ok || len(fields) == 0 || structure.Parse(fields) != nil {
+		logWarning(fmt.Sprintf("ignoring incomplete body structure %v", value))
+		return nil
+	}
+	result := newBodyStructure(structure)
+	return &result
+}
+
+// Type structureEntry is a single line in the file listing body structures.
+type structureEntry struct {
+	UIDValidity uidFolder     `json:"uidvalidity"`
+	UID         uid           `json:"uid"`
+	Structure   BodyStructure `json:"structure"`
+}
+
+// Type structureStorer appends the body structure of each email to a file in the folder's maildir,
+// see DownloadOptions.BodyStructure. It is meant to be used as an additional storer. Thus, it does
+// not determine which emails exist. Emails whose structure is unknown are skipped. The file is
+// opened on the first write and must be closed once done.
+type structureStorer struct {
+	path string
+	file fileOps
+}
+
+func newStructureStorer(folderPath string) *structureStorer {
+	return &structureStorer{path: filepath.Join(folderPath, structureName)}
+}
+
+// Exists never reports an email as existing since only the primary storer determines that.
+func (s *structureStorer) Exists(string) (bool, error) {
+	return false, nil
+}
+
+// Write appends the body structure of an email as a single line of JSON. The content is not needed.
+func (s *structureStorer) Write(info EmailInfo, _ io.Reader) (err error) {
+	if info.BodyStructure == nil {
+		logWarning(fmt.Sprintf("no body structure known for email %s, skipping it", info.Key))
+		return nil
+	}
+	entry := structureEntry{Structure: *info.BodyStructure}
+	_, err = fmt.Sscanf(info.Key, "%d/%d", &entry.UIDValidity, &entry.UID)
+	var line []byte
+	if err == nil {
+		line, err = json.Marshal(entry)
+	}
+	if err == nil && s.file == nil {
+		logInfo(fmt.Sprintf("opening body structure file %s", s.path))
+		var file fileOps
+		if file, err = openFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm); err == nil {
+			s.file = file
+		}
+	}
+	// Write each line with a single call to avoid partial entries in case of errors.
+	if err == nil {
+		_, err = s.file.Write(append(line, '\n'))
+	}
+	return err
+}
+
+// Close closes the file, if it has been opened.
+func (s *structureStorer) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,125 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBodyStructure(t *testing.T) {
+	attached := &imap.BodyStructure{
+		MIMEType: "text", MIMESubType: "plain", Params: map[string]string{}, Size: 5,
+	}
+	structure := &imap.BodyStructure{
+		MIMEType: "multipart", MIMESubType: "MIXED",
+		Parts: []*imap.BodyStructure{
+			{MIMEType: "text", MIMESubType: "plain", Encoding: "7BIT", Size: 100},
+			{
+				MIMEType: "application", MIMESubType: "pdf", Encoding: "base64", Size: 2048,
+				Disposition: "attachment",
+				// Names are decoded.
+				DispositionParams: map[string]string{"filename": "=?utf-8?q?r=C3=A9sum=C3=A9.pdf?="},
+			},
+			{
+				MIMEType: "message", MIMESubType: "rfc822", Size: 300, BodyStructure: attached,
+			},
+			nil,
+		},
+	}
+
+	assert.Equal(
+		t,
+		BodyStructure{
+			MIMEType: "multipart/mixed",
+			Parts: []BodyStructure{
+				{MIMEType: "text/plain", Encoding: "7bit", Size: 100},
+				{
+					MIMEType: "application/pdf", Encoding: "base64", Size: 2048,
+					Disposition: "attachment", Filename: "résumé.pdf",
+				},
+				{
+					MIMEType: "message/rfc822", Size: 300,
+					Parts: []BodyStructure{{MIMEType: "text/plain", Size: 5}},
+				},
+			},
+		},
+		newBodyStructure(structure),
+	)
+}
+
+func TestParseBodyStructure(t *testing.T) {
+	structure := &imap.BodyStructure{
+		MIMEType: "multipart", MIMESubType: "alternative",
+		Parts: []*imap.BodyStructure{
+			{MIMEType: "text", MIMESubType: "plain", Params: map[string]string{}, Size: 10},
+			{MIMEType: "text", MIMESubType: "html", Params: map[string]string{}, Size: 20},
+		},
+	}
+
+	parsed := parseBodyStructure(structure.Format())
+
+	require.NotNil(t, parsed)
+	assert.Equal(t, "multipart/alternative", parsed.MIMEType)
+	assert.Len(t, parsed.Parts, 2)
+
+	assert.Nil(t, parseBodyStructure("not a list"))
+	assert.Nil(t, parseBodyStructure([]interface{}{}))
+	assert.Nil(t, parseBodyStructure([]interface{}{"text", "plain"}))
+}
+
+func TestStructureStorer(t *testing.T) {
+	folderPath := t.TempDir()
+	storer := newStructureStorer(folderPath)
+
+	exists, err := storer.Exists("42/1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	structure := &BodyStructure{MIMEType: "text/plain", Size: 7}
+	assert.NoError(t, storer.Write(EmailInfo{Key: "42/1", BodyStructure: structure}, nil))
+	// Emails without a structure are skipped.
+	assert.NoError(t, storer.Write(EmailInfo{Key: "42/2"}, strings.NewReader("content")))
+	assert.NoError(t, storer.Write(EmailInfo{Key: "42/3", BodyStructure: structure}, nil))
+	assert.NoError(t, storer.Close())
+
+	content, err := os.ReadFile(filepath.Join(folderPath, structureName))
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`{"uidvalidity":42,"uid":1,"structure":{"mime_type":"text/plain","size":7}}`+"\n"+
+			`{"uidvalidity":42,"uid":3,"structure":{"mime_type":"text/plain","size":7}}`+"\n",
+		string(content),
+	)
+}
+
+func TestStructureStorerErrors(t *testing.T) {
+	storer := newStructureStorer(filepath.Join(t.TempDir(), "missing"))
+	structure := &BodyStructure{MIMEType: "text/plain"}
+
+	assert.Error(t, storer.Write(EmailInfo{Key: "invalid", BodyStructure: structure}, nil))
+	assert.Error(t, storer.Write(EmailInfo{Key: "42/1", BodyStructure: structure}, nil))
+	// Nothing has been opened.
+	assert.NoError(t, storer.Close())
+}
@@ -0,0 +1,113 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FolderStats summarises the download of a single folder of an account.
+type FolderStats struct {
+	// Account identifies the account, see AccountDirName.
+	Account string `json:"account"`
+	Folder  string `json:"folder"`
+	// Downloaded is the number of emails that have been stored.
+	Downloaded int `json:"downloaded"`
+	// Skipped is the number of emails that have been considered but had already been downloaded.
+	Skipped int `json:"skipped"`
+	// Filtered is the number of emails that have been retrieved but not stored since none of their
+	// addresses matched, see DownloadOptions.AddressFilter.
+	Filtered int `json:"filtered"`
+	// Failed is the number of emails that were to be downloaded but have not been stored.
+	Failed int `json:"failed"`
+	// Bytes is the total size of all stored emails.
+	Bytes int `json:"bytes"`
+	// Seconds is the time spent downloading the folder.
+	Seconds float64 `json:"seconds"`
+	// Error describes why the folder could not be downloaded completely, if it could not.
+	Error string `json:"error,omitempty"`
+}
+
+// DownloadSummary collects statistics about all folders downloaded with the same DownloadOptions,
+// e.g. to print a report at the end of a run. Its zero value is ready to use and it is safe for
+// concurrent use.
+type DownloadSummary struct {
+	folders []FolderStats
+	sync.Mutex
+}
+
+// Folders provides the statistics of all folders downloaded so far, sorted by account and folder.
+func (s *DownloadSummary) Folders() []FolderStats {
+	s.Lock()
+	defer s.Unlock()
+	folders := append([]FolderStats{}, s.folders...)
+	sort.SliceStable(folders, func(i, j int) bool {
+		if folders[i].Account != folders[j].Account {
+			return folders[i].Account < folders[j].Account
+		}
+		return folders[i].Folder < folders[j].Folder
+	})
+	return folders
+}
+
+// Update the statistics of a folder, creating them first if needed. Nothing happens for a nil
+// summary.
+func (s *DownloadSummary) update(account, folder string, change func(*FolderStats)) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	for idx := range s.folders {
+		if s.folders[idx].Account == account && s.folders[idx].Folder == folder {
+			change(&s.folders[idx])
+			return
+		}
+	}
+	stats := FolderStats{Account: account, Folder: folder}
+	change(&stats)
+	s.folders = append(s.folders, stats)
+}
+
+// Record the outcome of downloading a folder. A nil error means success.
+func (s *DownloadSummary) recordOutcome(account, folder string, err error) {
+	s.update(account, folder, func(stats *FolderStats) {
+		if err != nil {
+			stats.Error = err.Error()
+		}
+	})
+}
+
+// Record how many emails of a folder have been handled how and how long that took. Emails that
+// were to be downloaded but have neither been stored nor filtered are counted as failed.
+func (s *DownloadSummary) recordCounts(
+	account, folder string, stored *progress, skipped, filtered, total int, start time.Time,
+) {
+	s.update(account, folder, func(stats *FolderStats) {
+		stats.Skipped, stats.Filtered = skipped, filtered
+		if stored != nil {
+			stored.Lock()
+			stats.Downloaded, stats.Bytes = stored.messages, stored.bytes
+			stored.Unlock()
+		}
+		stats.Failed = total - stats.Downloaded - stats.Filtered
+		stats.Seconds = now().Sub(start).Seconds()
+	})
+}
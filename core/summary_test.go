@@ -0,0 +1,156 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setUpNow(t *testing.T, times ...time.Time) {
+	orgNow := now
+	t.Cleanup(func() { now = orgNow })
+	now = func() time.Time {
+		current := times[0]
+		if len(times) > 1 {
+			times = times[1:]
+		}
+		return current
+	}
+}
+
+func TestDownloadSummaryFolders(t *testing.T) {
+	summary := &DownloadSummary{}
+
+	summary.recordOutcome("b@server", "INBOX", nil)
+	summary.recordOutcome("a@server", "Sent", fmt.Errorf("some error"))
+	summary.recordOutcome("a@server", "INBOX", nil)
+
+	expected := []FolderStats{
+		{Account: "a@server", Folder: "INBOX"},
+		{Account: "a@server", Folder: "Sent", Error: "some error"},
+		{Account: "b@server", Folder: "INBOX"},
+	}
+	assert.Equal(t, expected, summary.Folders())
+}
+
+func TestDownloadSummaryRecordCounts(t *testing.T) {
+	start := time.Unix(100, 0)
+	setUpNow(t, start, start.Add(2*time.Second))
+	summary := &DownloadSummary{}
+	stored := newProgress("INBOX", 4)
+	stored.add(10)
+	stored.add(32)
+
+	summary.recordCounts("a@server", "INBOX", stored, 5, 1, 4, start)
+	summary.recordOutcome("a@server", "INBOX", fmt.Errorf("some error"))
+
+	expected := []FolderStats{{
+		Account: "a@server", Folder: "INBOX", Downloaded: 2, Skipped: 5, Filtered: 1, Failed: 1,
+		Bytes: 42, Seconds: 2, Error: "some error",
+	}}
+	assert.Equal(t, expected, summary.Folders())
+}
+
+func TestDownloadSummaryNil(t *testing.T) {
+	var summary *DownloadSummary
+
+	assert.NotPanics(t, func() {
+		summary.recordOutcome("a@server", "INBOX", nil)
+		summary.recordCounts("a@server", "INBOX", nil, 0, 0, 0, time.Now())
+	})
+}
+
+func TestDownloadMissingEmailsToFolderSummary(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	oldmailFileName := "some-file"
+	_, _, err := initMaildir(oldmailFileName, maildirPath)
+	require.NoError(t, err)
+	// Both emails on the server have already been downloaded.
+	oldmailPath := filepath.Join(tmpdir, oldmailFileName)
+	err = os.WriteFile(oldmailPath, []byte("42/1\x0010\n42/2\x0011\n"), filePerm)
+	require.NoError(t, err)
+
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 2}
+	uids := []uidExt{{folder: 42, msg: 1}, {folder: 42, msg: 2}}
+
+	m := &mockDownloader{t: t}
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return(uids, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	summary := &DownloadSummary{}
+	opts := DownloadOptions{Summary: summary, account: "a@server"}
+	err = downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, opts)
+
+	assert.NoError(t, err)
+	folders := summary.Folders()
+	require.Len(t, folders, 1)
+	assert.Equal(t, "a@server", folders[0].Account)
+	assert.Equal(t, "some-folder", folders[0].Folder)
+	assert.Equal(t, 2, folders[0].Skipped)
+	assert.Zero(t, folders[0].Downloaded)
+	assert.Zero(t, folders[0].Failed)
+	m.AssertExpectations(t)
+}
+
+func TestDownloadFolderSummary(t *testing.T) {
+	cfg := IMAPConfig{Server: "some-server", Port: 42, User: "some_user", Password: "secret"}
+	folders := []string{"f1", "f2"}
+
+	m := &mockImapgrabber{}
+	m.On("authenticateClient", cfg).Return(nil)
+	m.On("getFolderInfos").Return(folderInfos(folders), nil)
+	m.On("logout", mock.Anything).Return(nil)
+	m.On("downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: "f1"},
+		mock.Anything, mock.Anything).Return(nil)
+	m.On("downloadMissingEmailsToFolder", maildirPathT{base: "/some/dir", folder: "f2"},
+		mock.Anything, mock.Anything).Return(fmt.Errorf("some error"))
+
+	setUpCoreTest(t, m)
+
+	summary := &DownloadSummary{}
+	err := DownloadFolder(cfg, folders, "/some/dir", 1, DownloadOptions{Summary: summary})
+
+	assert.Error(t, err)
+	expected := []FolderStats{
+		{Account: "some_user@some-server", Folder: "f1"},
+		{Account: "some_user@some-server", Folder: "f2", Error: "some error"},
+	}
+	assert.Equal(t, expected, summary.Folders())
+	// The account is handed on so that statistics can be recorded for it.
+	for _, call := range m.Calls {
+		if call.Method == "downloadMissingEmailsToFolder" {
+			opts := call.Arguments.Get(2).(DownloadOptions)
+			assert.Equal(t, "some_user@some-server", opts.account)
+		}
+	}
+	m.AssertExpectations(t)
+}
@@ -18,7 +18,9 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package core
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -46,6 +48,12 @@ func (t *threadSafeErrors) bad() bool {
 	return len(t.errs) != 0
 }
 
+func (t *threadSafeErrors) count() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.errs)
+}
+
 func (t *threadSafeErrors) err() error {
 	t.Lock()
 	defer t.Unlock()
@@ -55,6 +63,15 @@ func (t *threadSafeErrors) err() error {
 	return fmt.Errorf("%d errors detected: %s", len(t.errs), strings.Join(t.errs, ", "))
 }
 
+// Ignore errors due to folders that do not exist on the server. Such folders are skipped instead
+// of failing the download, see folderResults.record.
+func skipMissingFolder(err error) error {
+	if errors.Is(err, ErrFolderMissing) {
+		return nil
+	}
+	return err
+}
+
 type threadSafeCounter struct {
 	count int
 	sync.Mutex
@@ -72,3 +89,129 @@ func (t *threadSafeCounter) get() int {
 	defer t.Unlock()
 	return t.count
 }
+
+// folderResults keeps track of which folders could be downloaded and which could not, and why.
+type folderResults struct {
+	succeeded []string
+	failed    []string
+	reasons   map[string]string
+	// skipped are folders that do not exist on the server, see ErrFolderMissing.
+	skipped []string
+	// summary, if set, receives the outcome for each folder of the account.
+	summary *DownloadSummary
+	account string
+	sync.Mutex
+}
+
+// record the outcome of downloading a folder. A nil error means success. Folders that do not exist
+// are skipped, i.e. they are neither considered successful nor failed.
+func (f *folderResults) record(folder string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.summary.recordOutcome(f.account, folder, err)
+	if err == nil {
+		f.succeeded = append(f.succeeded, folder)
+		return
+	}
+	if errors.Is(err, ErrFolderMissing) {
+		logWarning(fmt.Sprintf("skipping folder '%s' since it does not exist", folder))
+		f.skipped = append(f.skipped, folder)
+		return
+	}
+	if f.reasons == nil {
+		f.reasons = map[string]string{}
+	}
+	f.failed = append(f.failed, folder)
+	f.reasons[folder] = err.Error()
+}
+
+// summarise logs which folders succeeded and which failed. It returns an error naming all failed
+// folders, if any.
+func (f *folderResults) summarise() error {
+	f.Lock()
+	defer f.Unlock()
+	sort.Strings(f.succeeded)
+	sort.Strings(f.failed)
+	sort.Strings(f.skipped)
+	if len(f.succeeded) > 0 {
+		logInfo(fmt.Sprintf(
+			"successfully downloaded %d folders: '%s'",
+			len(f.succeeded), strings.Join(f.succeeded, logJoiner),
+		))
+	}
+	if len(f.skipped) > 0 {
+		logWarning(fmt.Sprintf(
+			"skipped %d folders that do not exist: '%s'",
+			len(f.skipped), strings.Join(f.skipped, logJoiner),
+		))
+	}
+	if len(f.failed) == 0 {
+		return nil
+	}
+	for _, folder := range f.failed {
+		logError(fmt.Sprintf("failed to download folder '%s': %s", folder, f.reasons[folder]))
+	}
+	return fmt.Errorf("failed to download folders '%s'", strings.Join(f.failed, logJoiner))
+}
+
+// cancelGroup cancels all clients tracked by it once a channel is closed. Clients tracked after
+// that are cancelled right away.
+type cancelGroup struct {
+	clients   []ImapgrabOps
+	cancelled bool
+	stopChan  chan struct{}
+	sync.Mutex
+}
+
+// Create a cancelGroup that watches the given channel until stopped. A nil channel is never closed.
+func newCancelGroup(cancel <-chan struct{}) *cancelGroup {
+	group := &cancelGroup{stopChan: make(chan struct{})}
+	if cancel != nil {
+		go func() {
+			select {
+			case <-cancel:
+				group.cancel()
+			case <-group.stopChan:
+			}
+		}()
+	}
+	return group
+}
+
+// Cancel all tracked clients concurrently so that cancelling takes no longer than for a single one.
+func (g *cancelGroup) cancel() {
+	g.Lock()
+	defer g.Unlock()
+	logWarning("cancelling download")
+	g.cancelled = true
+	var wg sync.WaitGroup
+	for _, client := range g.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Cancel()
+		}()
+	}
+	wg.Wait()
+}
+
+func (g *cancelGroup) track(client ImapgrabOps) {
+	g.Lock()
+	defer g.Unlock()
+	if g.cancelled {
+		client.Cancel()
+		return
+	}
+	g.clients = append(g.clients, client)
+}
+
+func (g *cancelGroup) isCancelled() bool {
+	g.Lock()
+	defer g.Unlock()
+	return g.cancelled
+}
+
+// Stop watching the channel.
+func (g *cancelGroup) stop() {
+	close(g.stopChan)
+}
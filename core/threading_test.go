@@ -20,6 +20,7 @@ package core
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,3 +46,32 @@ func TestThreadSafeErrorsLifeCycle(t *testing.T) {
 	assert.Error(t, errs.err())
 	assert.True(t, errs.bad())
 }
+
+func TestCancelGroupTrackAfterCancel(t *testing.T) {
+	cancel := make(chan struct{})
+	group := newCancelGroup(cancel)
+	defer group.stop()
+
+	before := &mockImapgrabber{}
+	before.On("Cancel").Return().Once()
+	group.track(before)
+	assert.False(t, group.isCancelled())
+
+	close(cancel)
+	assert.Eventually(t, group.isCancelled, time.Second, time.Millisecond)
+	before.AssertExpectations(t)
+
+	// Clients tracked after cancelling are cancelled right away.
+	after := &mockImapgrabber{}
+	after.On("Cancel").Return().Once()
+	group.track(after)
+	after.AssertExpectations(t)
+}
+
+func TestCancelGroupWithoutChannel(t *testing.T) {
+	group := newCancelGroup(nil)
+	group.track(&mockImapgrabber{})
+	group.stop()
+
+	assert.False(t, group.isCancelled())
+}
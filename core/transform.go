@@ -0,0 +1,51 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Type transformingStorer applies a user-provided transformation to the content of each email
+// before handing it to the underlying storer. Emails whose transformation fails are rejected unless
+// they shall be kept, in which case they are stored unchanged and a warning is logged. Rejected
+// emails are not remembered as downloaded and thus retried next time.
+type transformingStorer struct {
+	Storer
+	transform         func([]byte) ([]byte, error)
+	keepUntransformed bool
+}
+
+// Write transforms an email and stores the result.
+func (s *transformingStorer) Write(info EmailInfo, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	transformed, err := s.transform(data)
+	if err != nil {
+		if !s.keepUntransformed {
+			return fmt.Errorf("cannot transform email %s: %s", info.Key, err.Error())
+		}
+		logWarning(fmt.Sprintf("storing email %s untransformed: %s", info.Key, err.Error()))
+		transformed = data
+	}
+	return s.Storer.Write(info, bytes.NewReader(transformed))
+}
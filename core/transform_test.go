@@ -0,0 +1,69 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func removePixel(data []byte) ([]byte, error) {
+	return bytes.ReplaceAll(data, []byte("<img src=\"pixel\">"), nil), nil
+}
+
+func failTransform([]byte) ([]byte, error) {
+	return nil, fmt.Errorf("some transform error")
+}
+
+func TestTransformingStorerStoresTransformed(t *testing.T) {
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7"}, "Subject: hi\r\n\r\nbody").Return(nil)
+	storer := &transformingStorer{Storer: ms, transform: removePixel}
+
+	err := storer.Write(
+		EmailInfo{Key: "42/7"}, strings.NewReader("Subject: hi\r\n\r\nbody<img src=\"pixel\">"),
+	)
+
+	assert.NoError(t, err)
+	ms.AssertExpectations(t)
+}
+
+func TestTransformingStorerRejectsOnError(t *testing.T) {
+	ms := &mockStorer{}
+	storer := &transformingStorer{Storer: ms, transform: failTransform}
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader("content"))
+
+	assert.ErrorContains(t, err, "cannot transform email 42/7: some transform error")
+	ms.AssertExpectations(t)
+}
+
+func TestTransformingStorerKeepsUntransformed(t *testing.T) {
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7"}, "content").Return(nil)
+	storer := &transformingStorer{Storer: ms, transform: failTransform, keepUntransformed: true}
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader("content"))
+
+	assert.NoError(t, err)
+	ms.AssertExpectations(t)
+}
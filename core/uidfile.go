@@ -0,0 +1,94 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Read the UIDs of the emails to download from a file for a folder with the given UIDVALIDITY.
+// Each line contains either a UID or a UIDVALIDITY and a UID separated by a slash, e.g. "1234/56".
+// Entries for other UIDVALIDITY values are skipped, which allows using the same file for several
+// folders. Empty lines and lines starting with "#" are ignored, as are duplicate entries.
+func readUIDFile(path string, folder uidFolder) (_ []uid, err error) {
+	file, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	uids := []uid{}
+	seen := map[uid]bool{}
+	skipped := 0
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseUIDEntry(line, folder)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid entry in line %d of UID file %s: %s", lineNo, path, err.Error(),
+			)
+		}
+		if entry.folder != folder {
+			skipped++
+		} else if !seen[entry.msg] {
+			seen[entry.msg] = true
+			uids = append(uids, entry.msg)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		logInfo(fmt.Sprintf(
+			"skipped %d entries of UID file for a UIDVALIDITY other than %d", skipped, folder,
+		))
+	}
+	logInfo(fmt.Sprintf("read %d UIDs from UID file %s", len(uids), path))
+	return uids, nil
+}
+
+// Parse a single entry of a UID file. Entries without a UIDVALIDITY belong to the given folder.
+func parseUIDEntry(entry string, folder uidFolder) (uidExt, error) {
+	result := uidExt{folder: folder}
+	msg := entry
+	if validity, rest, found := strings.Cut(entry, "/"); found {
+		value, err := strconv.Atoi(validity)
+		if err != nil || value <= 0 {
+			return uidExt{}, fmt.Errorf("UIDVALIDITY '%s' is not a positive integer", validity)
+		}
+		result.folder, msg = uidFolder(value), rest
+	}
+	value, err := strconv.Atoi(msg)
+	if err != nil || value <= 0 {
+		return uidExt{}, fmt.Errorf("UID '%s' is not a positive integer", msg)
+	}
+	result.msg = uid(value)
+	return result, nil
+}
@@ -0,0 +1,116 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func writeUIDFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "uids")
+	err := os.WriteFile(path, []byte(content), filePerm)
+	require.NoError(t, err)
+	return path
+}
+
+func TestReadUIDFileSuccess(t *testing.T) {
+	path := writeUIDFile(t, "# to repair\n42/7\n 3 \n\n41/5\n7\n42/1\n")
+
+	uids, err := readUIDFile(path, 42)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uid{7, 3, 1}, uids)
+}
+
+func TestReadUIDFileInvalidEntries(t *testing.T) {
+	for _, content := range []string{
+		"1\n0\n", "-3\n", "abc\n", "42/\n", "/3\n", "0/3\n", "42/3/4\n", "4 2\n",
+	} {
+		path := writeUIDFile(t, content)
+
+		_, err := readUIDFile(path, 42)
+
+		assert.ErrorContains(t, err, "invalid entry in line ", content)
+	}
+}
+
+func TestReadUIDFileMissing(t *testing.T) {
+	_, err := readUIDFile(filepath.Join(t.TempDir(), "missing"), 42)
+
+	assert.Error(t, err)
+}
+
+func TestDownloadMissingEmailsToFolderUIDFile(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	folderPath := maildirPath.folderPath()
+	oldmailFileName := "some-oldmail-file"
+	oldmailPath := filepath.Join(tmpdir, oldmailFileName)
+	_, _, err := initMaildir(oldmailFileName, maildirPath)
+	require.NoError(t, err)
+	// The first email has already been downloaded but shall be downloaded again.
+	err = os.WriteFile(oldmailPath, []byte("42/1\x0010\n"), filePerm)
+	require.NoError(t, err)
+	opts := DownloadOptions{UIDFile: writeUIDFile(t, "42/1\n3\n41/2\n")}
+
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 3}
+	messageChan := make(chan emailOps)
+	var inMessageChan <-chan emailOps = messageChan
+	deliveredChan := make(chan oldmail)
+	var inDeliveredChan <-chan oldmail = deliveredChan
+	var fetchErrCount, deliverErrCount, oldmailErrCount int
+
+	m := &mockDownloader{
+		t:             t,
+		messages:      []*mockEmail{{uid: 1}, {uid: 3}},
+		messageChan:   messageChan,
+		delivered:     []oldmail{{uidFolder: 42, uid: 1}, {uidFolder: 42, uid: 3}},
+		deliveredChan: deliveredChan,
+	}
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	// The list of emails is not retrieved from the server.
+	m.On("highestModseq", "some-folder").Return(uint64(20), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("streamingRetrieval",
+		[]uid{1, 3}, opts.fetchItems(), 0, mock.Anything, mock.Anything,
+		mock.AnythingOfType("func() bool"),
+	).Return(messageChan, &fetchErrCount, nil)
+	m.On(
+		"streamingDelivery", inMessageChan, mock.Anything, uidFolder(42), DateFallback(""),
+		mock.Anything, mock.Anything,
+	).Return(deliveredChan, &deliverErrCount)
+	m.On("streamingOldmailWriteout", inDeliveredChan, oldmailPath, mock.Anything, mock.Anything).
+		Return(&oldmailErrCount, nil)
+
+	err = downloadMissingEmailsToFolder(m, maildirPath, oldmailFileName, mi, opts)
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(folderPath, modseqFileName))
+	m.AssertExpectations(t)
+}
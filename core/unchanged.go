@@ -0,0 +1,92 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// The name of the file in a maildir that holds the status of the folder on the server as of the
+// last successful download, see DownloadOptions.OnlyChanged.
+const folderStatusFileName = "imapgrab-status"
+
+// Type folderStatus describes the status of a folder on the server as reported via STATUS. New
+// emails increase the UIDNEXT, i.e. the UID that the next new email will receive, while deleted
+// ones decrease the number of emails. Thus, a folder whose status is the same as during the last
+// download has not gained or lost any emails.
+type folderStatus struct {
+	folder   uidFolder
+	uidNext  uint32
+	messages uint32
+}
+
+func readFolderStatus(folderPath string) (status folderStatus, err error) {
+	content, err := os.ReadFile(filepath.Join(folderPath, folderStatusFileName)) // nolint: gosec
+	if os.IsNotExist(err) {
+		return folderStatus{}, nil
+	}
+	if err == nil {
+		_, err = fmt.Sscanf(
+			string(content), "%d %d %d", &status.folder, &status.uidNext, &status.messages,
+		)
+	}
+	return status, err
+}
+
+func writeFolderStatus(folderPath string, status folderStatus) error {
+	return writeFile(
+		filepath.Join(folderPath, folderStatusFileName),
+		strings.NewReader(
+			fmt.Sprintf("%d %d %d\n", status.folder, status.uidNext, status.messages),
+		),
+	)
+}
+
+// Retrieve the status of a folder. This must not be called for the currently selected folder.
+func getFolderStatus(imapClient imapOps, folder string) (folderStatus, error) {
+	status, err := imapClient.Status(
+		folder, []imap.StatusItem{imap.StatusUidValidity, imap.StatusUidNext, imap.StatusMessages},
+	)
+	if err != nil {
+		return folderStatus{}, err
+	}
+	return folderStatus{
+		folder: uidFolder(status.UidValidity), uidNext: status.UidNext, messages: status.Messages,
+	}, nil
+}
+
+// Determine the current status of a folder and whether it is the same as during the last
+// successful download. Errors only cause the folder to be considered changed since skipping
+// unchanged folders is merely an optimisation.
+func unchangedFolder(ops downloadOps, maildirPath maildirPathT) (folderStatus, bool) {
+	last, err := readFolderStatus(maildirPath.folderPath())
+	var current folderStatus
+	if err == nil {
+		current, err = ops.folderStatus(maildirPath.folderName())
+	}
+	if err != nil {
+		logWarning(fmt.Sprintf("cannot determine whether folder has changed: %s", err.Error()))
+		return folderStatus{}, false
+	}
+	return current, current.uidNext > 0 && current == last
+}
@@ -0,0 +1,181 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFolderStatusRoundTrip(t *testing.T) {
+	tmpdir := t.TempDir()
+	status := folderStatus{folder: 42, uidNext: 123, messages: 100}
+
+	err := writeFolderStatus(tmpdir, status)
+	require.NoError(t, err)
+	read, err := readFolderStatus(tmpdir)
+
+	assert.NoError(t, err)
+	assert.Equal(t, status, read)
+}
+
+func TestReadFolderStatusMissingFile(t *testing.T) {
+	status, err := readFolderStatus(t.TempDir())
+
+	assert.NoError(t, err)
+	assert.Equal(t, folderStatus{}, status)
+}
+
+func TestReadFolderStatusMalformed(t *testing.T) {
+	tmpdir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tmpdir, folderStatusFileName), []byte("garbage"), filePerm)
+	require.NoError(t, err)
+
+	_, err = readFolderStatus(tmpdir)
+
+	assert.Error(t, err)
+}
+
+func TestGetFolderStatus(t *testing.T) {
+	m := &mockClient{}
+	items := []imap.StatusItem{imap.StatusUidValidity, imap.StatusUidNext, imap.StatusMessages}
+	m.On("Status", "some-folder", items).
+		Return(&imap.MailboxStatus{UidValidity: 42, UidNext: 123, Messages: 100}, nil)
+
+	status, err := getFolderStatus(m, "some-folder")
+
+	assert.NoError(t, err)
+	assert.Equal(t, folderStatus{folder: 42, uidNext: 123, messages: 100}, status)
+	m.AssertExpectations(t)
+}
+
+func TestGetFolderStatusError(t *testing.T) {
+	m := &mockClient{}
+	m.On("Status", "some-folder", mock.Anything).
+		Return(&imap.MailboxStatus{}, fmt.Errorf("some error"))
+
+	_, err := getFolderStatus(m, "some-folder")
+
+	assert.ErrorContains(t, err, "some error")
+}
+
+func TestUnchangedFolder(t *testing.T) {
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "some-folder"}
+	require.NoError(t, os.MkdirAll(maildirPath.folderPath(), dirPerm))
+	last := folderStatus{folder: 42, uidNext: 123, messages: 100}
+	require.NoError(t, writeFolderStatus(maildirPath.folderPath(), last))
+
+	for _, testCase := range []struct {
+		current   folderStatus
+		unchanged bool
+	}{
+		{current: last, unchanged: true},
+		{current: folderStatus{folder: 42, uidNext: 124, messages: 101}},
+		{current: folderStatus{folder: 42, uidNext: 123, messages: 99}},
+		{current: folderStatus{folder: 43, uidNext: 123, messages: 100}},
+	} {
+		m := &mockDownloader{t: t}
+		m.On("folderStatus", "some-folder").Return(testCase.current, nil)
+
+		current, unchanged := unchangedFolder(m, maildirPath)
+
+		assert.Equal(t, testCase.current, current)
+		assert.Equal(t, testCase.unchanged, unchanged, testCase)
+	}
+}
+
+func TestUnchangedFolderError(t *testing.T) {
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "some-folder"}
+	m := &mockDownloader{t: t}
+	m.On("folderStatus", "some-folder").Return(folderStatus{}, fmt.Errorf("some error"))
+
+	current, unchanged := unchangedFolder(m, maildirPath)
+
+	assert.Equal(t, folderStatus{}, current)
+	assert.False(t, unchanged)
+}
+
+func TestUnchangedFolderNeverDownloaded(t *testing.T) {
+	maildirPath := maildirPathT{base: t.TempDir(), folder: "some-folder"}
+	m := &mockDownloader{t: t}
+	m.On("folderStatus", "some-folder").Return(folderStatus{}, nil)
+
+	// A server not reporting UIDNEXT must not cause folders to be skipped.
+	_, unchanged := unchangedFolder(m, maildirPath)
+
+	assert.False(t, unchanged)
+}
+
+func TestDownloadMissingEmailsToFolderOnlyChanged(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 3}
+	status := folderStatus{folder: 42, uidNext: 4, messages: 3}
+
+	m := &mockDownloader{t: t}
+	m.On("folderStatus", "some-folder").Return(status, nil).Twice()
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil).Once()
+	m.On("selectFolder", "some-folder").Return(mbox, nil).Once()
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{}, nil).Once()
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	// The first run lists all emails and remembers the status while the second one skips the
+	// folder without selecting it.
+	opts := DownloadOptions{OnlyChanged: true}
+	for range []int{1, 2} {
+		err := downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
+		assert.NoError(t, err)
+	}
+
+	read, err := readFolderStatus(maildirPath.folderPath())
+	assert.NoError(t, err)
+	assert.Equal(t, status, read)
+	m.AssertExpectations(t)
+}
+
+func TestDownloadMissingEmailsToFolderOnlyChangedSizeFilter(t *testing.T) {
+	tmpdir := t.TempDir()
+	maildirPath := maildirPathT{base: tmpdir, folder: "some-folder"}
+	mbox := &imap.MailboxStatus{Name: "some-folder", UidValidity: 42, Messages: 1}
+
+	m := &mockDownloader{t: t}
+	m.On("folderStatus", "some-folder").Return(folderStatus{folder: 42, uidNext: 2, messages: 1}, nil)
+	m.On("highestModseq", "some-folder").Return(uint64(0), nil)
+	m.On("selectFolder", "some-folder").Return(mbox, nil)
+	m.On("getAllMessageUUIDs", mbox).Return([]uidExt{{folder: 42, msg: 1, size: 10}}, nil)
+
+	mi := &mockInterrupter{}
+	mi.On("interrupted").Return(false)
+
+	opts := DownloadOptions{OnlyChanged: true, MinSize: 100}
+	err := downloadMissingEmailsToFolder(m, maildirPath, "some-file", mi, opts)
+
+	assert.NoError(t, err)
+	// The status is not remembered because emails excluded by size have not been downloaded.
+	assert.NoFileExists(t, filepath.Join(maildirPath.folderPath(), folderStatusFileName))
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,74 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// Type validatingStorer checks emails before handing them to the underlying storer. Emails that are
+// empty or whose header cannot be parsed are rejected unless they shall be kept, in which case a
+// warning is logged. Rejected emails are not remembered as downloaded and thus retried next time.
+type validatingStorer struct {
+	Storer
+	keepMalformed bool
+}
+
+// Write stores an email if it passes validation. Only the header of a valid email is read for
+// validation. The remainder is handed on to the underlying storer without copying it. Invalid
+// emails are read in full to determine why they are invalid.
+func (s *validatingStorer) Write(info EmailInfo, content io.Reader) error {
+	// Everything read from the content during validation is kept to be handed on.
+	consumed := &bytes.Buffer{}
+	reader := bufio.NewReader(io.TeeReader(content, consumed))
+	header, err := textproto.ReadHeader(reader)
+	if err != nil || header.Len() == 0 {
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return err
+		}
+		err = validateEmail(consumed.Bytes())
+	}
+	if err != nil {
+		if !s.keepMalformed {
+			return fmt.Errorf("not storing email %s: %s", info.Key, err.Error())
+		}
+		logWarning(fmt.Sprintf("storing email %s as is: %s", info.Key, err.Error()))
+	}
+	return s.Storer.Write(info, io.MultiReader(consumed, content))
+}
+
+// Check that an email is not empty and has a well-formed header with at least one field. This
+// detects empty replies from servers and many, but not all, truncated emails.
+func validateEmail(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fmt.Errorf("email is empty")
+	}
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return fmt.Errorf("email is malformed: %s", err.Error())
+	}
+	if header.Len() == 0 {
+		return fmt.Errorf("email has no header fields")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Type probingStorer records how much of the content of an email has been read from the source
+// once it is asked to write that email.
+type probingStorer struct {
+	Storer
+	source    *countingReader
+	readFirst int
+}
+
+func (s *probingStorer) Write(info EmailInfo, content io.Reader) error {
+	s.readFirst = s.source.count
+	return s.Storer.Write(info, content)
+}
+
+func TestValidateEmail(t *testing.T) {
+	for _, content := range []string{
+		"Subject: some subject\r\n\r\nsome body",
+		"Subject: some subject\r\nFrom: someone\r\n\r\n",
+		"Subject: only a header\r\n",
+	} {
+		assert.NoError(t, validateEmail([]byte(content)), content)
+	}
+}
+
+func TestValidateEmailFailure(t *testing.T) {
+	for content, expected := range map[string]string{
+		"":                           "email is empty",
+		" \r\n\r\n":                  "email is empty",
+		"\r\nonly a body":            "email has no header fields",
+		"no header line\r\n\r\nbody": "email is malformed",
+	} {
+		assert.ErrorContains(t, validateEmail([]byte(content)), expected, content)
+	}
+}
+
+func TestValidatingStorerRejectsMalformed(t *testing.T) {
+	ms := &mockStorer{}
+	storer := &validatingStorer{Storer: ms}
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader(""))
+
+	assert.ErrorContains(t, err, "not storing email 42/7: email is empty")
+	ms.AssertExpectations(t)
+}
+
+func TestValidatingStorerKeepsMalformed(t *testing.T) {
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7"}, "").Return(nil)
+	storer := &validatingStorer{Storer: ms, keepMalformed: true}
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader(""))
+
+	assert.NoError(t, err)
+	ms.AssertExpectations(t)
+}
+
+func TestValidatingStorerStoresValid(t *testing.T) {
+	content := "Subject: some subject\r\n\r\nsome body"
+	ms := &mockStorer{}
+	ms.On("Write", EmailInfo{Key: "42/7"}, content).Return(nil)
+	storer := &validatingStorer{Storer: ms}
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, strings.NewReader(content))
+
+	assert.NoError(t, err)
+	ms.AssertExpectations(t)
+}
+
+func TestValidatingStorerReadsOnlyHeader(t *testing.T) {
+	const bodySize = 1 << 20
+	header := "Subject: large attachment\r\n\r\n"
+	body := io.LimitReader(neverEndingReader{}, bodySize)
+	source := &countingReader{reader: io.MultiReader(strings.NewReader(header), body)}
+
+	path := t.TempDir()
+	for _, dir := range []string{tmpMaildir, newMaildir, curMaildir} {
+		require.NoError(t, os.Mkdir(filepath.Join(path, dir), dirPerm))
+	}
+	probe := &probingStorer{Storer: newMaildirStorer(path, nil), source: source}
+	storer := &validatingStorer{Storer: probe}
+
+	err := storer.Write(EmailInfo{Key: "42/7"}, source)
+
+	assert.NoError(t, err)
+	// Validation reads only a small part of the email before handing it on.
+	assert.Less(t, probe.readFirst, 64<<10)
+	assert.Equal(t, len(header)+bodySize, source.count)
+	entries, err := os.ReadDir(filepath.Join(path, newMaildir))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(header)+bodySize), info.Size())
+}
+
+// Type neverEndingReader provides an infinite stream of the same character.
+type neverEndingReader struct{}
+
+func (neverEndingReader) Read(p []byte) (int, error) {
+	for idx := range p {
+		p[idx] = 'a'
+	}
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"fmt"
+)
+
+// Verify that all emails that were to be downloaded have been remembered as stored in the oldmail
+// file after the download. If errors were reported during the download, some emails are expected
+// to be missing. Otherwise, missing emails have been dropped silently somewhere in the download
+// pipeline, which is logged as a warning or, if strict, reported as an error.
+func verifyDownloadCount(
+	oldmailPath string, folder uidFolder, downloaded []uid, reportedErrors, strict bool,
+) error {
+	oldmails, err := readOldmail(oldmailPath)
+	if err != nil {
+		return verificationFailed(
+			fmt.Sprintf("cannot verify number of downloaded emails: %s", err.Error()), strict,
+		)
+	}
+	remembered := make(map[uid]struct{}, len(oldmails))
+	for _, om := range oldmails {
+		if om.uidFolder == folder {
+			remembered[om.uid] = struct{}{}
+		}
+	}
+	missing := 0
+	for _, msg := range downloaded {
+		if _, found := remembered[msg]; !found {
+			missing++
+		}
+	}
+	logInfo(fmt.Sprintf(
+		"stored %d of %d new emails, %d emails stored in total",
+		len(downloaded)-missing, len(downloaded), len(remembered),
+	))
+	if missing == 0 || reportedErrors {
+		return nil
+	}
+	return verificationFailed(
+		fmt.Sprintf(
+			"%d of %d new emails have not been stored without an error", missing, len(downloaded),
+		),
+		strict,
+	)
+}
+
+// Report a failed verification as an error if strict or log a warning otherwise.
+func verificationFailed(msg string, strict bool) error {
+	if strict {
+		return fmt.Errorf("%s", msg)
+	}
+	logWarning(msg)
+	return nil
+}
@@ -0,0 +1,75 @@
+/* A re-implementation of the amazing imapgrap in plain Golang.
+Copyright (C) 2022  Torsten Long
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpVerifyTest(t *testing.T) string {
+	t.Helper()
+	oldmailPath := filepath.Join(t.TempDir(), "oldmail")
+	// Emails 1 and 2 have been stored, the former during an earlier run. Email 3 is from another
+	// folder.
+	content := "42/1\x001\n42/2\x002\n41/3\x003\n"
+	err := os.WriteFile(oldmailPath, []byte(content), filePerm)
+	assert.NoError(t, err)
+	return oldmailPath
+}
+
+func TestVerifyDownloadCountSuccess(t *testing.T) {
+	oldmailPath := setUpVerifyTest(t)
+
+	err := verifyDownloadCount(oldmailPath, 42, []uid{2}, false, true)
+
+	assert.NoError(t, err)
+}
+
+func TestVerifyDownloadCountMissing(t *testing.T) {
+	oldmailPath := setUpVerifyTest(t)
+
+	// Only a warning is logged by default.
+	err := verifyDownloadCount(oldmailPath, 42, []uid{2, 3}, false, false)
+	assert.NoError(t, err)
+
+	err = verifyDownloadCount(oldmailPath, 42, []uid{2, 3}, false, true)
+	assert.EqualError(t, err, "1 of 2 new emails have not been stored without an error")
+}
+
+func TestVerifyDownloadCountReportedErrors(t *testing.T) {
+	oldmailPath := setUpVerifyTest(t)
+
+	// Emails whose download failed with an error are expected to be missing.
+	err := verifyDownloadCount(oldmailPath, 42, []uid{2, 3}, true, true)
+
+	assert.NoError(t, err)
+}
+
+func TestVerifyDownloadCountNoOldmail(t *testing.T) {
+	oldmailPath := filepath.Join(t.TempDir(), "oldmail")
+
+	err := verifyDownloadCount(oldmailPath, 42, []uid{1}, false, false)
+	assert.NoError(t, err)
+
+	err = verifyDownloadCount(oldmailPath, 42, []uid{1}, false, true)
+	assert.ErrorContains(t, err, "cannot verify number of downloaded emails")
+}